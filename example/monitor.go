@@ -13,8 +13,9 @@ package main
 
 import (
 	"atlantis/monitor"
+	"os"
 )
 
 func main() {
-	monitor.Run()
+	os.Exit(monitor.Run())
 }
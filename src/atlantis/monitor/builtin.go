@@ -0,0 +1,206 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package monitor
+
+import (
+	"atlantis/supervisor/cgroup"
+	"atlantis/supervisor/rpc/types"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/docker/docker/client"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Checks the monitor runs itself, so containers without check scripts still get baseline monitoring. Only the
+// port check runs for the containers of remote supervisors, the others need the container on this host.
+const (
+	BuiltinPort    = "port"    // connect to the primary port
+	BuiltinRunning = "running" // the runtime has the container running
+	BuiltinMemory  = "memory"  // memory use against the container's limit
+	BuiltinDisk    = "disk"    // writable layer size against the manifest's disk limit
+)
+
+var DefaultBuiltinChecks = []string{BuiltinPort, BuiltinRunning, BuiltinMemory, BuiltinDisk}
+
+// What the runtime reports about a container
+type inspection struct {
+	running bool
+	pid     int
+	sizeRw  uint64
+}
+
+// Run the enabled built-in checks, each within t
+func (c *ContainerCheck) checkBuiltins(ctx context.Context, t time.Duration) {
+	enabled := map[string]bool{}
+	for _, name := range c.monitor.Config.BuiltinChecks {
+		enabled[name] = true
+	}
+	if enabled[BuiltinPort] {
+		c.checkPort(t)
+	}
+	if len(c.monitor.Config.Supervisors) > 0 || c.container.GetDockerID() == "" {
+		return
+	}
+	if !enabled[BuiltinRunning] && !enabled[BuiltinMemory] && !enabled[BuiltinDisk] {
+		return
+	}
+	inspectCtx, cancel := context.WithTimeout(ctx, t)
+	defer cancel()
+	info, err := c.inspect(inspectCtx)
+	if enabled[BuiltinRunning] {
+		c.checkRunning(info, err)
+	}
+	if enabled[BuiltinMemory] {
+		c.checkMemory(info, err)
+	}
+	if enabled[BuiltinDisk] {
+		c.checkDisk(info, err)
+	}
+}
+
+// Emit a built-in check's result under <check>_<container id>, unless this pass only assigns it a contact group
+func (c *ContainerCheck) emitBuiltin(check string, result *Result) {
+	result.Service = check + "_" + c.container.GetID()
+	if !c.updateContactGroup(result.Service) {
+		c.emit(result)
+	}
+}
+
+func (c *ContainerCheck) checkPort(t time.Duration) {
+	port := c.container.GetPrimaryPort()
+	if port == 0 {
+		return
+	}
+	addr := net.JoinHostPort(types.UnbracketHost(c.Host), strconv.Itoa(int(port)))
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, t)
+	if err != nil {
+		c.emitBuiltin(BuiltinPort, &Result{State: Critical, Message: fmt.Sprintf("Could not connect to %s: %s",
+			addr, err)})
+		return
+	}
+	conn.Close()
+	elapsed := time.Since(start)
+	c.emitBuiltin(BuiltinPort, &Result{State: OK, Perfdata: durationMetric("connect_time", elapsed),
+		Message: fmt.Sprintf("Connected to %s in %s", addr, elapsed.Truncate(time.Millisecond))})
+}
+
+// Returns the runtime's API endpoint: the configured one, or the default socket of the runtime
+func runtimeEndpoint(config *Config) string {
+	if config.RuntimeEndpoint != "" {
+		return config.RuntimeEndpoint
+	}
+	if config.RuntimeCommand == "podman" {
+		return "unix:///run/podman/podman.sock"
+	}
+	return "unix:///var/run/docker.sock"
+}
+
+// Returns the client for the runtime's API, which picks the API version with the daemon on first use
+func (m *Monitor) runtimeClient() (*client.Client, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.runtime == nil {
+		cli, err := client.NewClientWithOpts(client.WithHost(runtimeEndpoint(m.Config)),
+			client.WithAPIVersionNegotiation())
+		if err != nil {
+			return nil, err
+		}
+		m.runtime = cli
+	}
+	return m.runtime, nil
+}
+
+// Ask the runtime for the container's state and the size of its writable layer
+func (c *ContainerCheck) inspect(ctx context.Context) (*inspection, error) {
+	cli, err := c.monitor.runtimeClient()
+	if err != nil {
+		return nil, fmt.Errorf("could not reach the runtime: %s", err)
+	}
+	cont, _, err := cli.ContainerInspectWithRaw(ctx, c.container.GetDockerID(), true)
+	if err != nil {
+		return nil, fmt.Errorf("inspect failed: %s", err)
+	}
+	if cont.ContainerJSONBase == nil || cont.State == nil {
+		return nil, errors.New("inspect returned no state")
+	}
+	info := &inspection{running: cont.State.Running, pid: cont.State.Pid}
+	if cont.SizeRw != nil {
+		info.sizeRw = uint64(*cont.SizeRw)
+	}
+	return info, nil
+}
+
+func (c *ContainerCheck) checkRunning(info *inspection, err error) {
+	if err != nil {
+		c.emitBuiltin(BuiltinRunning, &Result{State: Critical, Message: err.Error()})
+	} else if !info.running {
+		c.emitBuiltin(BuiltinRunning, &Result{State: Critical, Message: "Container is not running"})
+	} else {
+		c.emitBuiltin(BuiltinRunning, &Result{State: OK, Message: fmt.Sprintf("Container is running as pid %d",
+			info.pid)})
+	}
+}
+
+func (c *ContainerCheck) checkMemory(info *inspection, err error) {
+	if err == nil && !info.running {
+		err = fmt.Errorf("container is not running")
+	}
+	var usage *types.ResourceUsage
+	if err == nil {
+		usage, err = cgroup.ContainerUsage(info.pid)
+	}
+	if err != nil {
+		c.emitBuiltin(BuiltinMemory, &Result{State: Uknown, Message: fmt.Sprintf("Could not read memory use: %s",
+			err)})
+		return
+	}
+	config := c.monitor.Config
+	c.emitBuiltin(BuiltinMemory, usageResult("memory", usage.MemoryBytes, usage.MemoryLimit, config.MemoryWarning,
+		config.MemoryCritical))
+}
+
+func (c *ContainerCheck) checkDisk(info *inspection, err error) {
+	if err != nil {
+		c.emitBuiltin(BuiltinDisk, &Result{State: Uknown, Message: fmt.Sprintf("Could not read disk use: %s", err)})
+		return
+	}
+	var limit uint64
+	if typedC, ok := c.container.(*types.Container); ok && typedC.Manifest != nil {
+		limit = uint64(typedC.Manifest.DiskLimit) * 1024 * 1024
+	}
+	config := c.monitor.Config
+	c.emitBuiltin(BuiltinDisk, usageResult("disk", info.sizeRw, limit, config.DiskWarning, config.DiskCritical))
+}
+
+// Returns the state of used bytes against limit, warning and critical being percentages of it. Without a limit
+// the use is only reported.
+func usageResult(metric string, used, limit uint64, warning, critical uint) *Result {
+	mb := func(b uint64) uint64 { return b / (1024 * 1024) }
+	if limit == 0 {
+		return &Result{State: OK, Perfdata: fmt.Sprintf("%s=%dB", metric, used),
+			Message: fmt.Sprintf("%d MB used, no limit", mb(used))}
+	}
+	warnBytes, critBytes := limit*uint64(warning)/100, limit*uint64(critical)/100
+	result := &Result{State: OK, Perfdata: fmt.Sprintf("%s=%dB;%d;%d;0;%d", metric, used, warnBytes, critBytes,
+		limit)}
+	if critical > 0 && used >= critBytes {
+		result.State = Critical
+	} else if warning > 0 && used >= warnBytes {
+		result.State = Warning
+	}
+	result.Message = fmt.Sprintf("%d MB of %d MB used (%d%%)", mb(used), mb(limit), used*100/limit)
+	return result
+}
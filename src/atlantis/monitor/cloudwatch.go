@@ -0,0 +1,144 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// put-metric-data takes at most this many metrics per call
+const cloudWatchBatchSize = 20
+
+type cloudWatchDimension struct {
+	Name  string
+	Value string
+}
+
+type cloudWatchDatum struct {
+	MetricName string
+	Dimensions []cloudWatchDimension
+	Value      float64
+	Unit       string
+	Timestamp  string
+}
+
+// Units of the perfdata unit suffixes the checks use
+var cloudWatchUnits = map[string]string{
+	"":   "Count",
+	"s":  "Seconds",
+	"ms": "Milliseconds",
+	"B":  "Bytes",
+	"%":  "Percent",
+}
+
+// Publishes each pass's results to CloudWatch with the aws cli, so the host's instance profile is used for
+// credentials. Every result becomes a CheckState metric valued 0-3, and each of its perfdata values a metric of
+// the same name, e.g. memory in Bytes. They carry the container's ContainerID and Check as dimensions, along with
+// the configured ones. Configured in monitor.toml as:
+//
+//	[cloudwatch]
+//	namespace = "Atlantis/Containers"
+//	region = "us-east-1"
+//	dimensions = { Environment = "prod" }
+type CloudWatchSink struct {
+	Namespace  string            `toml:"namespace"`
+	Region     string            `toml:"region"`     // defaults to the aws cli's
+	Dimensions map[string]string `toml:"dimensions"` // added to every metric, e.g. Environment
+}
+
+func (s *CloudWatchSink) Publish(results []*Result) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	var data []cloudWatchDatum
+	for _, r := range results {
+		dimensions := s.dimensions(r)
+		data = append(data, cloudWatchDatum{"CheckState", dimensions, float64(r.State), "None", now})
+		for _, metric := range strings.Split(r.Perfdata, "|") {
+			if name, value, unit, ok := parsePerfdata(metric); ok {
+				data = append(data, cloudWatchDatum{name, dimensions, value, unit, now})
+			}
+		}
+	}
+	for len(data) > 0 {
+		n := len(data)
+		if n > cloudWatchBatchSize {
+			n = cloudWatchBatchSize
+		}
+		if err := s.put(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (s *CloudWatchSink) dimensions(r *Result) []cloudWatchDimension {
+	dimensions := []cloudWatchDimension{}
+	if r.Container != "" {
+		dimensions = append(dimensions, cloudWatchDimension{"ContainerID", r.Container},
+			cloudWatchDimension{"Check", strings.TrimSuffix(r.Service, "_"+r.Container)})
+	} else {
+		dimensions = append(dimensions, cloudWatchDimension{"Check", r.Service})
+	}
+	names := make([]string, 0, len(s.Dimensions))
+	for name := range s.Dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		dimensions = append(dimensions, cloudWatchDimension{name, s.Dimensions[name]})
+	}
+	return dimensions
+}
+
+func (s *CloudWatchSink) put(data []cloudWatchDatum) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	args := []string{"cloudwatch", "put-metric-data", "--namespace", s.Namespace, "--metric-data",
+		"file:///dev/stdin"}
+	if s.Region != "" {
+		args = append(args, "--region", s.Region)
+	}
+	cmd := exec.Command("aws", args...)
+	cmd.Stdin = bytes.NewReader(body)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.New("CloudWatch put-metric-data failed: " + err.Error() + ": " +
+			strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// Parse a "name=value[unit][;warn;crit;min;max]" perfdata metric. ok is false if the unit has no CloudWatch
+// equivalent or the value is not a number.
+func parsePerfdata(metric string) (name string, value float64, unit string, ok bool) {
+	fields := strings.SplitN(strings.SplitN(metric, ";", 2)[0], "=", 2)
+	if len(fields) != 2 || fields[0] == "" {
+		return "", 0, "", false
+	}
+	number := strings.TrimRight(fields[1], "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ%")
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return "", 0, "", false
+	}
+	unit, ok = cloudWatchUnits[fields[1][len(number):]]
+	return fields[0], value, unit, ok
+}
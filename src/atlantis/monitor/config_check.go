@@ -0,0 +1,168 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package monitor
+
+import (
+	"atlantis/supervisor/containers/serialize"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Print the problems with config, loaded from file with loadErr, so a deployment pipeline can catch them before
+// restarting the monitor. Returns the exit status, 1 if there are problems.
+func checkConfig(file string, config *Config, loadErr error) int {
+	problems := configProblems(file, config, loadErr)
+	for _, problem := range problems {
+		fmt.Printf("%s: %s\n", file, problem)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	fmt.Printf("%s: OK\n", file)
+	return 0
+}
+
+// Returns what would make checks fail or go missing with this config, each with what to change
+func configProblems(file string, config *Config, loadErr error) []string {
+	if loadErr != nil {
+		return []string{loadErr.Error()}
+	}
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	// for the helpers below, which return "" when all is well
+	addProblem := func(problem string) {
+		if problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	if file != "" {
+		md, _ := toml.DecodeFile(file, DefaultConfig())
+		for _, key := range md.Undecoded() {
+			add("unknown key %s, check its spelling", key)
+		}
+	}
+	if config.SupervisorToken != "" {
+		if f, err := os.Open(config.SupervisorToken); err != nil {
+			add("supervisor_token can not be read: %s", err)
+		} else {
+			f.Close()
+		}
+	}
+	if len(config.Supervisors) == 0 {
+		addProblem(parentDirProblem("container_file", config.ContainerFile))
+		for contType, auxFile := range config.AuxContainerFiles {
+			addProblem(parentDirProblem("aux_container_files."+contType, auxFile))
+		}
+	}
+	addProblem(parentDirProblem("inventory_dir", config.InventoryDir))
+	addProblem(parentDirProblem("check_state_dir", config.CheckStateDir))
+	addProblem(parentDirProblem("cmk_queue_file", config.CMKQueueFile))
+	if config.RepeatInterval > 0 {
+		addProblem(parentDirProblem("alert_state_file", config.AlertStateFile))
+	}
+	if config.KnownHostsFile != "" {
+		addProblem(parentDirProblem("known_hosts_file", config.KnownHostsFile))
+	}
+	if config.StateKeyFile != "" && config.StateKeyCommand != "" {
+		add("only one of state_key_file and state_key_command can be set")
+	} else if _, err := serialize.LoadKey(config.StateKeyFile, config.StateKeyCommand); err != nil {
+		add("%s, it should be the supervisor's state key", err)
+	}
+	addProblem(identityProblem("ssh_identity", config.SSHIdentity))
+	for i, id := range config.SSHIdentities {
+		addProblem(identityProblem(fmt.Sprintf("ssh_identities[%d]", i), expandHome(id.Identity)))
+	}
+	if config.SSHUser == "" {
+		add("ssh_user is empty, set it to the user the monitor logs into containers as")
+	}
+	if config.TimeoutDuration == 0 {
+		add("timeout_duration is 0, every check would time out")
+	}
+	if config.Daemon && config.DaemonInterval == 0 {
+		add("daemon_interval is 0, set the max seconds between passes")
+	}
+	if _, err := compileSeverityOverrides(config.SeverityOverrides); err != nil {
+		add("severity_overrides: %s", err)
+	}
+	switch config.ExitMode {
+	case "", ExitModeSpool, ExitModeActive:
+	default:
+		add("exit_mode %q is unknown, use %q or %q", config.ExitMode, ExitModeSpool, ExitModeActive)
+	}
+	switch config.LogBackend {
+	case "", LogBackendSyslog, LogBackendJournald:
+	default:
+		add("log_backend %q is unknown, use %q, %q or leave it empty", config.LogBackend, LogBackendSyslog,
+			LogBackendJournald)
+	}
+	if config.StatsDAddr != "" {
+		if _, _, err := net.SplitHostPort(config.StatsDAddr); err != nil {
+			add("statsd_addr %q should be host:port, e.g. \"localhost:8125\"", config.StatsDAddr)
+		}
+	}
+	for _, name := range config.BuiltinChecks {
+		switch name {
+		case BuiltinPort, BuiltinRunning, BuiltinMemory, BuiltinDisk:
+		default:
+			add("builtin_checks: unknown check %q, use any of %s", name, strings.Join(DefaultBuiltinChecks, ", "))
+		}
+	}
+	if config.MemoryWarning > 100 || config.MemoryCritical > 100 || config.DiskWarning > 100 ||
+		config.DiskCritical > 100 {
+		add("memory_warning, memory_critical, disk_warning and disk_critical are percentages, at most 100")
+	}
+	if config.Piggyback {
+		if _, err := template.New("piggyback_host").Parse(config.PiggybackHost); err != nil {
+			add("piggyback_host: %s", err)
+		}
+	}
+	return problems
+}
+
+// Returns a problem if the directory file goes in does not exist, "" if it does
+func parentDirProblem(key, file string) string {
+	dir := filepath.Dir(file)
+	if info, err := os.Stat(dir); err != nil {
+		return fmt.Sprintf("%s: %s, create the directory or point %s elsewhere", key, err, key)
+	} else if !info.IsDir() {
+		return fmt.Sprintf("%s: %s is not a directory", key, dir)
+	}
+	return ""
+}
+
+// Returns a problem if ssh could not use the key file, "" if it can
+func identityProblem(key, file string) string {
+	if file == "" {
+		return fmt.Sprintf("%s is empty, set it to the private key authorized in containers", key)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Sprintf("%s: %s", key, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Sprintf("%s: %s", key, err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		// ssh ignores private keys others can read
+		return fmt.Sprintf("%s: %s is accessible by others, chmod 600 it", key, file)
+	}
+	return ""
+}
@@ -0,0 +1,119 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package monitor
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// How long to wait for a save to finish writing the container file before reloading it
+const containerFileSettle = 200 * time.Millisecond
+
+// Run a pass every interval until ctx is cancelled. The container files are watched as well, so a pass starts as
+// soon as the supervisor saves instead of checking stale containers until the next interval. passDone is called
+// with the results of each pass.
+func (m *Monitor) RunDaemon(ctx context.Context, interval time.Duration, passDone func([]*Result)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	// watch the dirs rather than the files, the supervisor replaces some of them by renaming over them
+	files := map[string]bool{}
+	for _, file := range m.containerFiles() {
+		file = filepath.Clean(file)
+		if files[file] {
+			continue
+		}
+		files[file] = true
+		if err := watcher.Add(filepath.Dir(file)); err != nil {
+			m.debugf("could not watch %s, only checking every %s: %s\n", file, interval, err)
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		results := m.Run(ctx)
+		if passDone != nil {
+			passDone(results)
+		}
+		if !m.waitForChange(ctx, watcher, files, ticker.C) {
+			return nil
+		}
+	}
+}
+
+// Block until the next pass is due: one of the files changed, the ticker fired or the watcher stopped. Returns
+// false if ctx was cancelled.
+func (m *Monitor) waitForChange(ctx context.Context, watcher *fsnotify.Watcher, files map[string]bool,
+	tick <-chan time.Time) bool {
+	var settle <-chan time.Time
+	events, errs := watcher.Events, watcher.Errors
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-tick:
+			return true
+		case <-settle:
+			return true
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if files[filepath.Clean(event.Name)] {
+				m.debugf("%s changed (%s)\n", event.Name, event.Op)
+				// a save can take several writes, reload once they stop
+				settle = time.After(containerFileSettle)
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			m.debugf("error watching the container files: %s\n", err)
+		}
+	}
+}
+
+// Use cfg from the next pass on. Passes already running keep the config they started with.
+func (m *Monitor) Reload(cfg *Config) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.pending = cfg
+}
+
+// Reload the config whenever the monitor gets a SIGHUP. The output options and the daemon interval are fixed at
+// startup.
+func reloadOnHUP(m *Monitor) {
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	for range hupChan {
+		config := DefaultConfig()
+		if err := overlayConfig(config); err != nil {
+			m.report(Warning, "Kept the current config, could not reload it: %s", err)
+			continue
+		}
+		if err := setStateKey(config); err != nil {
+			m.report(Warning, "Kept the current config, could not load the state key: %s", err)
+			continue
+		}
+		m.Reload(config)
+	}
+}
@@ -0,0 +1,123 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package monitor
+
+import (
+	"atlantis/supervisor/containers/serialize"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The last non-OK state of a service that was passed on to the sinks, and when
+type Alert struct {
+	State int
+	Sent  time.Time
+}
+
+// Holds back repeats of a problem from the sinks other than the local check output (syslog, StatsD, CloudWatch),
+// so a sustained outage doesn't turn into an alert storm. A non-OK result is passed on when its service changes
+// state or the repeat interval has gone by since it was last passed on. OK results always are. What was sent
+// is saved to disk, so the interval holds across monitor runs.
+type Deduper struct {
+	File     string
+	Interval time.Duration
+	Alerts   map[string]*Alert // host/service -> last alert passed on
+}
+
+func (m *Monitor) loadDeduper(file string, interval time.Duration) *Deduper {
+	d := &Deduper{File: file, Interval: interval, Alerts: map[string]*Alert{}}
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return d
+	}
+	if err := serialize.RetrieveObject(file, &d.Alerts); serialize.IsCorrupt(err) {
+		m.report(Warning, "Discarding corrupt alert dedup state %s: %s", file, err)
+		d.Alerts = nil
+	} else if err != nil {
+		m.report(Warning, "Could not load alert dedup state %s: %s", file, err)
+	}
+	if d.Alerts == nil {
+		d.Alerts = map[string]*Alert{}
+	}
+	return d
+}
+
+// Returns whether to pass the result on, recording it if so
+func (d *Deduper) Allow(r *Result) bool {
+	key := r.Host + "/" + r.Service
+	if r.State == OK {
+		delete(d.Alerts, key)
+		return true
+	}
+	now := time.Now()
+	if last, ok := d.Alerts[key]; ok && last.State == r.State && now.Sub(last.Sent) < d.Interval {
+		return false
+	}
+	d.Alerts[key] = &Alert{State: r.State, Sent: now}
+	return true
+}
+
+// Save the alerts that still hold back repeats
+func (d *Deduper) Save() error {
+	for key, alert := range d.Alerts {
+		if time.Since(alert.Sent) >= d.Interval {
+			delete(d.Alerts, key)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(d.File), 0755); err != nil {
+		return err
+	}
+	return serialize.SaveObject(d.File, d.Alerts)
+}
+
+// Passes results on to the sinks other than the local check output, leaving out the repeats the Deduper holds
+// back
+type Dispatcher struct {
+	Dedup      *Deduper // nil to pass every result on
+	lock       sync.Mutex
+	sinks      []ResultHandler
+	dispatched []*Result
+}
+
+// Pass results on to sink too
+func (d *Dispatcher) Add(sink ResultHandler) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.sinks = append(d.sinks, sink)
+}
+
+// A ResultHandler passing the result on unless it is a repeat
+func (d *Dispatcher) WriteResult(r *Result) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.Dedup != nil && !d.Dedup.Allow(r) {
+		return
+	}
+	d.dispatched = append(d.dispatched, r)
+	for _, sink := range d.sinks {
+		sink(r)
+	}
+}
+
+// Returns the results passed on since the last call, to hand to sinks that take a whole pass, and saves what the
+// Deduper sent
+func (d *Dispatcher) PassDone() ([]*Result, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	dispatched := d.dispatched
+	d.dispatched = nil
+	if d.Dedup == nil {
+		return dispatched, nil
+	}
+	return dispatched, d.Dedup.Save()
+}
@@ -0,0 +1,45 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package monitor
+
+import (
+	"os"
+	"strings"
+)
+
+// The SSH key to check the containers of an app and/or environment with, so one compromised key does not give
+// access to every container on the fleet. An empty App or Env matches any. The first matching identity is used,
+// falling back to ssh_identity.
+//
+//	[[ssh_identities]]
+//	app = "billing"
+//	env = "prod"
+//	identity = "/opt/atlantis/supervisor/billing_prod_id_rsa"
+type SSHIdentity struct {
+	App      string `toml:"app"`
+	Env      string `toml:"env"`
+	Identity string `toml:"identity"`
+}
+
+func expandHome(file string) string {
+	return strings.Replace(file, "~", os.Getenv("HOME"), 1)
+}
+
+// Returns the SSH key file to check the container with
+func (m *Monitor) identity(c MonitoredContainer) string {
+	for _, id := range m.Config.SSHIdentities {
+		if (id.App == "" || id.App == c.GetApp()) && (id.Env == "" || id.Env == c.GetEnv()) {
+			return expandHome(id.Identity)
+		}
+	}
+	return expandHome(m.Config.SSHIdentity)
+}
@@ -0,0 +1,158 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package monitor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/syslog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Where results and diagnostics are copied to besides the local check output, so host log aggregation still sees
+// check failures when the check_mk pipeline is broken
+const (
+	LogBackendSyslog   = "syslog"
+	LogBackendJournald = "journald"
+)
+
+const (
+	defaultLogTag  = "atlantis-monitor"
+	journaldSocket = "/run/systemd/journal/socket"
+)
+
+// Syslog priority of each state
+var statePriority = map[int]syslog.Priority{
+	OK:       syslog.LOG_INFO,
+	Warning:  syslog.LOG_WARNING,
+	Critical: syslog.LOG_ERR,
+	Uknown:   syslog.LOG_NOTICE,
+}
+
+// Copies results and debug output to syslog or the journal. Results carry their service, state, container, host
+// and perfdata as fields: journal fields, or key=value pairs ahead of the message in syslog.
+type LogSink struct {
+	lock     sync.Mutex
+	tag      string
+	syslog   *syslog.Writer
+	journald *net.UnixConn
+}
+
+// Connect to the given backend. Messages are tagged with tag, atlantis-monitor if it is empty.
+func NewLogSink(backend, tag string) (*LogSink, error) {
+	if tag == "" {
+		tag = defaultLogTag
+	}
+	s := &LogSink{tag: tag}
+	var err error
+	switch backend {
+	case LogBackendSyslog:
+		s.syslog, err = syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	case LogBackendJournald:
+		s.journald, err = net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	default:
+		err = fmt.Errorf("unknown log backend %q, use %s or %s", backend, LogBackendSyslog, LogBackendJournald)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// A ResultHandler logging the result
+func (s *LogSink) WriteResult(r *Result) {
+	priority, ok := statePriority[r.State]
+	if !ok {
+		priority = syslog.LOG_NOTICE
+	}
+	fields := [][2]string{
+		{"CHECK_SERVICE", r.Service},
+		{"CHECK_STATE", strconv.Itoa(r.State)},
+		{"CHECK_CONTAINER", r.Container},
+		{"CHECK_HOST", r.Host},
+		{"CHECK_PERFDATA", r.Perfdata},
+	}
+	s.log(priority, r.Message, fields)
+}
+
+// Log debug output, one message per line
+func (s *LogSink) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if line != "" {
+			s.log(syslog.LOG_DEBUG, line, nil)
+		}
+	}
+	return len(p), nil
+}
+
+func (s *LogSink) log(priority syslog.Priority, message string, fields [][2]string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.journald != nil {
+		var buf bytes.Buffer
+		writeJournalField(&buf, "MESSAGE", message)
+		writeJournalField(&buf, "PRIORITY", strconv.Itoa(int(priority)))
+		writeJournalField(&buf, "SYSLOG_IDENTIFIER", s.tag)
+		for _, field := range fields {
+			if field[1] != "" {
+				writeJournalField(&buf, field[0], field[1])
+			}
+		}
+		// nowhere to report a failure to, the results still go to the local check output
+		s.journald.Write(buf.Bytes())
+		return
+	}
+	var pairs []string
+	for _, field := range fields {
+		if field[1] != "" {
+			key := strings.ToLower(strings.TrimPrefix(field[0], "CHECK_"))
+			pairs = append(pairs, key+"="+strconv.Quote(field[1]))
+		}
+	}
+	if len(pairs) > 0 {
+		message = strings.Join(pairs, " ") + " " + message
+	}
+	switch priority {
+	case syslog.LOG_DEBUG:
+		s.syslog.Debug(message)
+	case syslog.LOG_INFO:
+		s.syslog.Info(message)
+	case syslog.LOG_NOTICE:
+		s.syslog.Notice(message)
+	case syslog.LOG_WARNING:
+		s.syslog.Warning(message)
+	default:
+		s.syslog.Err(message)
+	}
+}
+
+// Append a field in the journal's native protocol. Values with newlines are sent length-prefixed.
+func writeJournalField(buf *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		buf.WriteString(key + "=" + value + "\n")
+		return
+	}
+	buf.WriteString(key + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
+
+func (s *LogSink) Close() error {
+	if s.journald != nil {
+		return s.journald.Close()
+	}
+	return s.syslog.Close()
+}
@@ -12,19 +12,37 @@
 package monitor
 
 import (
+	"atlantis/supervisor/constant"
 	"atlantis/supervisor/containers/serialize"
 	"atlantis/supervisor/rpc/types"
+	atypes "atlantis/types"
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/BurntSushi/toml"
+	"github.com/docker/docker/client"
 	"github.com/jigish/go-flags"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
 )
 
+// Buckets of the supervisor's state store
+const (
+	supervisorContainersBucket = "containers"
+	supervisorStatusBucket     = "status" // the outcome of the supervisor's background jobs
+)
+
 const (
 	OK = iota
 	Warning
@@ -32,103 +50,287 @@ const (
 	Uknown
 )
 
+// How the monitor exits once a pass is done. Spooled by the check_mk agent, the results are in the output and the
+// exit status only tells whether the monitor ran. Run as an active check, the exit status is the worst state.
+const (
+	ExitModeSpool  = "spool"  // always exit 0
+	ExitModeActive = "active" // exit with the worst state, 0-3 like a Nagios plugin
+)
+
+// The container type used for the app containers found in ContainerFile
+const AppContainerType = "app"
+
 type Config struct {
-	ContainerFile   string `toml:"container_file"`
-	ContainersDir   string `toml:"container_dir"`
-	InventoryDir    string `toml:"inventory_dir"`
-	SSHIdentity     string `toml:"ssh_identity"`
-	SSHUser         string `toml:"ssh_user"`
-	CheckName       string `toml:"check_name"`
-	CheckDir        string `toml:"check_dir"`
-	DefaultGroup    string `toml:"default_group"`
-	TimeoutDuration uint   `toml:"timeout_duration"`
-	Verbose         bool   `toml:"verbose"`
+	ContainerFile     string             `toml:"container_file"`
+	ContainersDir     string             `toml:"container_dir"`
+	InventoryDir      string             `toml:"inventory_dir"`
+	CheckStateDir     string             `toml:"check_state_dir"`
+	SSHIdentity       string             `toml:"ssh_identity"`
+	SSHIdentities     []SSHIdentity      `toml:"ssh_identities"` // per app/env keys, used over SSHIdentity
+	SSHUser           string             `toml:"ssh_user"`
+	CheckUser         string             `toml:"check_user"` // user checks run as, empty for ssh_user
+	CheckName         string             `toml:"check_name"`
+	CheckDir          string             `toml:"check_dir"`
+	DefaultGroup      string             `toml:"default_group"`
+	TimeoutDuration   uint               `toml:"timeout_duration"`
+	Verbose           bool               `toml:"verbose"`
+	SortOutput        bool               `toml:"sort_output"`
+	CMKQueueFile      string             `toml:"cmk_queue_file"`
+	CMKRetryBase      uint               `toml:"cmk_retry_base"`      // seconds before the first cmk_admin retry
+	CMKRetryMax       uint               `toml:"cmk_retry_max"`       // max seconds between cmk_admin retries
+	AuxContainerFiles map[string]string  `toml:"aux_container_files"` // container type -> file with containers
+	CheckDirs         map[string]string  `toml:"check_dirs"`          // container type -> check dir override
+	Daemon            bool               `toml:"daemon"`
+	DaemonInterval    uint               `toml:"daemon_interval"` // max seconds between passes in daemon mode
+	OOMWindow         uint               `toml:"oom_window"`      // seconds an OOM kill keeps the oom check critical
+	SeverityOverrides []SeverityOverride `toml:"severity_overrides"`
+	MaxOutputBytes    uint               `toml:"max_output_bytes"`   // check messages are cut to this, 0 for no limit
+	Piggyback         bool               `toml:"piggyback"`          // attribute results to each container's host
+	PiggybackHost     string             `toml:"piggyback_host"`     // text/template of a container's check_mk host
+	Supervisors       []string           `toml:"supervisors"`        // RPC endpoints to list containers from
+	SupervisorToken   string             `toml:"supervisor_token"`   // file with the token to call them with
+	KnownHostsFile    string             `toml:"known_hosts_file"`   // written with the containers' host keys
+	AllowUnknownKeys  bool               `toml:"allow_unknown_keys"` // true by default, skips verifying keyless containers
+	ExitMode          string             `toml:"exit_mode"`          // spool or active, defaults to spool
+	LogBackend        string             `toml:"log_backend"`        // syslog or journald to also log results there
+	LogTag            string             `toml:"log_tag"`            // defaults to atlantis-monitor
+	StatsDAddr        string             `toml:"statsd_addr"`        // host:port to send check metrics to
+	StatsDPrefix      string             `toml:"statsd_prefix"`      // defaults to atlantis.monitor
+	BuiltinChecks     []string           `toml:"builtin_checks"`     // checks run without scripts, [] for none
+	RuntimeCommand    string             `toml:"runtime_command"`    // docker or podman, for the built-in checks
+	RuntimeEndpoint   string             `toml:"runtime_endpoint"`   // its API, defaults to its socket
+	MemoryWarning     uint               `toml:"memory_warning"`     // percent of the memory limit
+	MemoryCritical    uint               `toml:"memory_critical"`
+	DiskWarning       uint               `toml:"disk_warning"` // percent of the manifest's disk limit
+	DiskCritical      uint               `toml:"disk_critical"`
+	CloudWatch        CloudWatchSink     `toml:"cloudwatch"`       // publishes results to CloudWatch if it has a namespace
+	RepeatInterval    uint               `toml:"repeat_interval"`  // seconds before a sink gets a problem again
+	AlertStateFile    string             `toml:"alert_state_file"` // remembers what the sinks got across runs
+	StateKeyFile      string             `toml:"state_key_file"`   // the supervisor's, if it encrypts its state
+	StateKeyCommand   string             `toml:"state_key_command"`
 }
 
 type Opts struct {
-	ContainerFile   string `short:"f" long:"container-file" description:"file to get container information"`
-	ContainersDir   string `short:"s" long:"containers-dir" description:"directory containing configs for each container"`
-	SSHIdentity     string `short:"i" long:"ssh-identity" description:"file containing the SSH key for all containers"`
-	SSHUser         string `short:"u" long:"ssh-user" description:"user account to ssh into containers"`
-	CheckName       string `short:"n" long:"check-name" description:"service name that will appear in Nagios for the monitor"`
-	CheckDir        string `short:"d" long:"check-dir" description:"directory containing all the scripts for the monitoring checks"`
-	DefaultGroup    string `short:"g" long:"default-group" description:"default contact group to use if there is no valid group provided"`
-	Config          string `short:"c" long:"config-file" default:"/etc/atlantis/supervisor/monitor.toml" description:"the config file to use"`
-	TimeoutDuration uint   `short:"t" long:"timeout-duration" description:"max number of seconds to wait for a monitoring check to finish"`
-	Verbose         bool   `short:"v" long:"verbose" default:false description:"print verbose debug information"`
+	ContainerFile   string   `short:"f" long:"container-file" description:"file to get container information"`
+	ContainersDir   string   `short:"s" long:"containers-dir" description:"directory containing configs for each container"`
+	SSHIdentity     string   `short:"i" long:"ssh-identity" description:"file containing the default SSH key for containers"`
+	SSHUser         string   `short:"u" long:"ssh-user" description:"user account to ssh into containers"`
+	CheckName       string   `short:"n" long:"check-name" description:"service name that will appear in Nagios for the monitor"`
+	CheckDir        string   `short:"d" long:"check-dir" description:"directory containing all the scripts for the monitoring checks"`
+	DefaultGroup    string   `short:"g" long:"default-group" description:"default contact group to use if there is no valid group provided"`
+	Config          string   `short:"c" long:"config-file" default:"/etc/atlantis/supervisor/monitor.toml" description:"the config file to use"`
+	TimeoutDuration uint     `short:"t" long:"timeout-duration" description:"max number of seconds to wait for a monitoring check to finish"`
+	Verbose         bool     `short:"v" long:"verbose" default:false description:"print verbose debug information"`
+	SortOutput      bool     `short:"o" long:"sort-output" description:"hold results and print them sorted by service name"`
+	Daemon          bool     `short:"D" long:"daemon" description:"keep running, checking again whenever the container file changes"`
+	Piggyback       bool     `short:"p" long:"piggyback" description:"output check_mk piggyback data for each container's host"`
+	Supervisors     []string `short:"S" long:"supervisor" description:"host[:port] of a supervisor to check containers on instead of the container file"`
+	Version         bool     `long:"version" description:"print the version and build information and exit"`
+	CheckConfig     bool     `long:"check-config" description:"check the config for problems and exit"`
+	ExitMode        string   `short:"x" long:"exit-mode" description:"spool to always exit 0, active to exit with the worst state"`
+	LogBackend      string   `short:"l" long:"log-backend" description:"syslog or journald to copy results and debug output to"`
 }
 
 type ServiceCheck struct {
-	Service  string
-	User     string
-	Identity string
-	Host     string
-	Port     uint16
-	Script   string
+	Service    string
+	User       string
+	Identity   string
+	Host       string
+	Port       uint16
+	KnownHosts string // file to verify the container's host key against, empty to not verify it
+	Script     string
+	Custom     *types.ManifestCheck // set if this check was declared in the container's manifest
+	stateDir   string
+	severities []severityRule
+	maxOutput  int // bytes of message kept from the check's output
 }
 
-//TODO(mchandra):Need defaults defined by constants
-var config = &Config{
-	ContainerFile:   "/etc/atlantis/supervisor/save/containers",
-	ContainersDir:   "/etc/atlantis/containers",
-	InventoryDir:    "/etc/atlantis/supervisor/inventory",
-	SSHIdentity:     "/opt/atlantis/supervisor/master_id_rsa",
-	SSHUser:         "root",
-	CheckName:       "ContainerMonitor",
-	CheckDir:        "/check_mk_checks",
-	DefaultGroup:    "atlantis_orphan_apps",
-	TimeoutDuration: 11,
-	Verbose:         false,
+// TODO(mchandra):Need defaults defined by constants
+func DefaultConfig() *Config {
+	return &Config{
+		ContainerFile:    "/etc/atlantis/supervisor/save/state.db",
+		ContainersDir:    "/etc/atlantis/containers",
+		InventoryDir:     "/etc/atlantis/supervisor/inventory",
+		CheckStateDir:    "/etc/atlantis/supervisor/check_state",
+		CMKQueueFile:     "/etc/atlantis/supervisor/cmk_queue",
+		CMKRetryBase:     60,
+		CMKRetryMax:      3600,
+		SSHIdentity:      "/opt/atlantis/supervisor/master_id_rsa",
+		SSHUser:          "root",
+		CheckName:        "ContainerMonitor",
+		CheckDir:         "/check_mk_checks",
+		DefaultGroup:     "atlantis_orphan_apps",
+		TimeoutDuration:  11,
+		Verbose:          false,
+		DaemonInterval:   60,
+		OOMWindow:        3600,
+		MaxOutputBytes:   1024,
+		KnownHostsFile:   "/etc/atlantis/supervisor/monitor_known_hosts",
+		AllowUnknownKeys: true, // containers deployed before host keys were recorded have none
+		PiggybackHost:    "{{.GetID}}",
+		BuiltinChecks:    append([]string{}, DefaultBuiltinChecks...),
+		RuntimeCommand:   "docker",
+		MemoryWarning:    80,
+		MemoryCritical:   90,
+		DiskWarning:      80,
+		DiskCritical:     90,
+		AlertStateFile:   "/etc/atlantis/supervisor/alert_state",
+	}
 }
 
-func (s *ServiceCheck) cmd() *exec.Cmd {
-	return silentSshCmd(s.User, s.Identity, s.Host, s.Script, s.Port)
+func (s *ServiceCheck) cmd(ctx context.Context) *exec.Cmd {
+	return silentSshCmd(ctx, s.User, s.Identity, s.KnownHosts, s.Host, s.Script, s.Port)
 }
 
-func (s *ServiceCheck) timeOutMsg() string {
-	return fmt.Sprintf("%d %s - Timeout occured during check\n", Critical, s.Service)
+func (s *ServiceCheck) result(state int, format string, args ...interface{}) *Result {
+	return &Result{State: state, Service: s.Service, Message: fmt.Sprintf(format, args...)}
 }
 
-func (s *ServiceCheck) errMsg(err error) string {
+func (s *ServiceCheck) timeOutMsg() *Result {
+	return s.result(Critical, "Timeout occured during check")
+}
+
+func (s *ServiceCheck) errMsg(err error) *Result {
 	if err != nil {
-		return fmt.Sprintf("%d %s - %s\n", Critical, s.Service, err.Error())
+		return s.result(Critical, "%s", err.Error())
 	} else {
-		return fmt.Sprintf("%d %s - Error encountered while monitoring the service\n", Critical, s.Service)
+		return s.result(Critical, "Error encountered while monitoring the service")
 	}
 }
 
-func (s *ServiceCheck) validate(msg string) string {
+func (s *ServiceCheck) validate(msg string) *Result {
 	m := strings.SplitN(msg, " ", 4)
 	if len(m) < 2 {
-		return fmt.Sprintf("%d %s - %s\n", Critical, s.Service, "Check validation failed; no service name found in check")
+		return s.result(Critical, "Check validation failed; no service name found in check")
 	}
 	if m[1] != s.Service {
-		return fmt.Sprintf("%d %s - %s\n", Critical, s.Service, "Check validation failed; found service "+m[1]+", expected "+s.Service)
+		return s.result(Critical, "Check validation failed; found service %s, expected %s", m[1], s.Service)
+	}
+	r, err := ParseResult(msg)
+	if err != nil {
+		return s.result(Critical, "Check validation failed; %s", err.Error())
 	}
+	r.Sanitize(s.maxOutput)
+	mapSeverity(s.severities, r)
+	return r
+}
 
-	return msg
+// Turn the raw output of a manifest-declared check into a check result
+func (s *ServiceCheck) customResult(out []byte, err error) *Result {
+	output := strings.TrimSpace(string(out))
+	state := OK
+	if s.Custom.Warning != nil || s.Custom.Critical != nil {
+		if err != nil {
+			return s.errMsg(err)
+		}
+		fields := strings.Fields(output)
+		if len(fields) == 0 {
+			return s.result(Critical, "Check returned no value to compare to thresholds")
+		}
+		value, perr := strconv.ParseFloat(fields[0], 64)
+		if perr != nil {
+			return s.result(Critical, "Check returned non-numeric value %q", fields[0])
+		}
+		if s.Custom.Critical != nil && value >= *s.Custom.Critical {
+			state = Critical
+		} else if s.Custom.Warning != nil && value >= *s.Custom.Warning {
+			state = Warning
+		}
+	} else if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return s.errMsg(err)
+		}
+		state = exitErr.Sys().(syscall.WaitStatus).ExitStatus()
+		if state == 255 {
+			// ssh itself failed
+			return s.errMsg(err)
+		} else if state > Uknown {
+			state = Uknown
+		}
+	}
+	if output == "" {
+		output = "Check finished"
+	}
+	r := s.result(state, "%s", output)
+	r.Sanitize(s.maxOutput)
+	mapSeverity(s.severities, r)
+	return r
+}
+
+func (s *ServiceCheck) stateFile() string {
+	return filepath.Join(s.stateDir, s.Service)
 }
 
-func (s *ServiceCheck) runCheck(done chan bool) {
-	out, err := s.cmd().Output()
+// Returns the last result of a manifest-declared check if it ran less than its interval ago
+func (s *ServiceCheck) cachedResult() (*Result, bool) {
+	if s.Custom == nil || s.Custom.Interval == 0 {
+		return nil, false
+	}
+	fi, err := os.Stat(s.stateFile())
+	if err != nil || time.Since(fi.ModTime()) >= time.Duration(s.Custom.Interval)*time.Second {
+		return nil, false
+	}
+	saved, err := ioutil.ReadFile(s.stateFile())
+	if err != nil || len(saved) == 0 {
+		return nil, false
+	}
+	result, err := ParseResult(string(saved))
 	if err != nil {
-		fmt.Print(s.errMsg(err))
+		return nil, false
+	}
+	return result, true
+}
+
+func (s *ServiceCheck) saveResult(result *Result) {
+	if s.Custom == nil || s.Custom.Interval == 0 {
+		return
+	}
+	if err := os.MkdirAll(s.stateDir, 0755); err != nil {
+		return
+	}
+	ioutil.WriteFile(s.stateFile(), []byte(result.String()), 0644)
+}
+
+func (s *ServiceCheck) runCheck(ctx context.Context, done chan *Result) {
+	out, err := s.cmd(ctx).Output()
+	if ctx.Err() != nil {
+		// timed out or cancelled, checkWithTimeout reports it
+		done <- nil
+	} else if s.Custom != nil {
+		result := s.customResult(out, err)
+		s.saveResult(result)
+		done <- result
+	} else if err != nil {
+		done <- s.errMsg(err)
 	} else {
-		fmt.Print(s.validate(string(out)))
+		done <- s.validate(string(out))
 	}
-	done <- true
 }
 
-func (s *ServiceCheck) checkWithTimeout(results chan bool, d time.Duration) {
-	done := make(chan bool, 1)
-	go s.runCheck(done)
+// Run the check, killing it if it takes longer than d or ctx is cancelled
+func (s *ServiceCheck) checkWithTimeout(ctx context.Context, d time.Duration) *Result {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	done := make(chan *Result, 1)
+	go s.runCheck(ctx, done)
 	select {
-	case <-done:
-		results <- true
-	case <-time.After(d):
-		fmt.Print(s.timeOutMsg())
-		results <- true
+	case result := <-done:
+		if result != nil {
+			return result
+		}
+	case <-ctx.Done():
 	}
+	if ctx.Err() == context.Canceled {
+		return s.result(Critical, "Check cancelled")
+	}
+	return s.timeOutMsg()
+}
+
+// Anything managed by the supervisor that the monitor can ssh into and run checks on. App containers as well
+// as auxiliary containers (sidecars, one-shot tasks, system containers) satisfy this.
+type MonitoredContainer interface {
+	types.GenericContainer
 }
 
 type ContainerCheck struct {
@@ -138,60 +340,96 @@ type ContainerCheck struct {
 	Directory    string
 	Inventory    string
 	ContactGroup string
-	container    *types.Container
+	Host         string
+	CMKHost      string // check_mk host the container's results are attributed to, empty for the monitor's host
+	KnownHosts   string // file to verify the container's host key against, empty to not verify it
+	container    MonitoredContainer
+	unverified   bool // true if cmk_admin was unreachable when verifying ContactGroup
+	monitor      *Monitor
 }
 
 type ContainerConfig struct {
 	Dependencies map[string]interface{}
 }
 
-func (c *ContainerCheck) verifyContactGroup(group string) bool {
+func contactGroupExists(group string) (bool, error) {
 	output, err := exec.Command("/usr/bin/cmk_admin", "-l").Output()
 	if err != nil {
-		fmt.Printf("%d %s - Error listing existing contact_groups for validation, please try again later! Error: %s\n", Warning, c.Name, err.Error())
-		return false
+		return false, err
 	}
 	for _, l := range strings.Split(string(output), "\n") {
 		cg := strings.TrimSpace(strings.TrimPrefix(l, "*"))
-		if cg == c.ContactGroup {
-			return true
+		if cg == group {
+			return true, nil
 		}
 	}
-	return false
+	return false, nil
+}
+
+// Report a result for the container itself
+func (c *ContainerCheck) report(state int, format string, args ...interface{}) {
+	c.emit(&Result{State: state, Service: c.Name, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *ContainerCheck) emit(result *Result) {
+	result.Container = c.container.GetID()
+	result.Host = c.CMKHost
+	c.monitor.emit(result)
+}
+
+func (c *ContainerCheck) verifyContactGroup(group string) (bool, error) {
+	exists, err := contactGroupExists(group)
+	if err != nil {
+		c.report(Warning, "Error listing existing contact_groups for validation, will verify on retry. Error: %s", err.Error())
+	}
+	return exists, err
+}
+
+// Read the container's config from the containers dir or, for the containers of remote supervisors, over ssh from
+// the config dir mounted in the container. Returns the file read.
+func (c *ContainerCheck) retrieveConfig(cont_config *ContainerConfig) (string, error) {
+	config := c.monitor.Config
+	if len(config.Supervisors) == 0 {
+		config_file := filepath.Join(config.ContainersDir, c.container.GetID(), "config.json")
+		return config_file, serialize.RetrieveObject(config_file, cont_config)
+	}
+	config_file := path.Join(atypes.ContainerConfigDir, "config.json")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.TimeoutDuration)*time.Second)
+	defer cancel()
+	out, err := silentSshCmd(ctx, c.User, c.Identity, c.KnownHosts, c.Host, "cat "+config_file,
+		c.container.GetSSHPort()).Output()
+	if err != nil {
+		return config_file, err
+	}
+	return config_file, json.Unmarshal(out, cont_config)
 }
 
 func (c *ContainerCheck) parseContactGroup() {
+	config := c.monitor.Config
 	c.ContactGroup = config.DefaultGroup
-	config_file := filepath.Join(config.ContainersDir, c.container.ID, "config.json")
 	var cont_config ContainerConfig
-	if err := serialize.RetrieveObject(config_file, &cont_config); err != nil {
-		fmt.Printf("%d %s - Could not retrieve container config %s: %s\n", Critical, c.Name, config_file, err)
+	if config_file, err := c.retrieveConfig(&cont_config); err != nil {
+		c.report(Critical, "Could not retrieve container config %s: %s", config_file, err)
 	} else {
-		dep, ok := cont_config.Dependencies["cmk"]
+		dep, ok := cont_config.Dependencies[types.CmkDepName]
 		if !ok {
-			fmt.Printf("%d %s - cmk dep not present, defaulting to %s contact group!\n", OK, c.Name, config.DefaultGroup)
+			c.report(OK, "cmk dep not present, defaulting to %s contact group!", config.DefaultGroup)
 			return
 		}
-		cmk_dep, ok := dep.(map[string]interface{})
-		if !ok {
-			fmt.Printf("%d %s - cmk dep present, but value is not map[string]string!\n", Critical, c.Name)
-			return
-		}
-		val, ok := cmk_dep["contact_group"]
-		if !ok {
-			fmt.Printf("%d %s - cmk dep present, but no contact_group key!\n", Critical, c.Name)
+		cmk_dep, err := types.ParseCmkDep(dep)
+		if err != nil {
+			c.report(Critical, "cmk dep present, but invalid: %s!", err)
 			return
 		}
-		group, ok := val.(string)
-		if ok {
-			group = strings.ToLower(group)
-			if c.verifyContactGroup(group) {
-				c.ContactGroup = group
-			} else {
-				fmt.Printf("%d %s - Specified contact_group does not exist in cmk! Falling back to default group %s.\n", Critical, c.Name, config.DefaultGroup)
-			}
+		group := strings.ToLower(cmk_dep.ContactGroup)
+		if exists, err := c.verifyContactGroup(group); err != nil {
+			// cmk_admin is unreachable. the queued assignment will verify the group once it is back.
+			c.ContactGroup = group
+			c.unverified = true
+		} else if exists {
+			c.ContactGroup = group
 		} else {
-			fmt.Printf("%d %s - Value for contact_group key of cmk dep is not a string!\n", Critical, c.Name)
+			c.report(Critical, "Specified contact_group does not exist in cmk! Falling back to default group %s.", config.DefaultGroup)
 		}
 	}
 }
@@ -200,78 +438,255 @@ func (c *ContainerCheck) updateContactGroup(name string) (updated bool) {
 	if len(c.ContactGroup) == 0 {
 		c.parseContactGroup()
 	}
+	queue := c.monitor.queue
 	inventoryPath := path.Join(c.Inventory, name)
 	if _, err := os.Stat(inventoryPath); os.IsNotExist(err) {
+		if queue.Pending(name) {
+			// waiting to be retried, keep running the checks in the meantime
+			return
+		}
+		if c.unverified {
+			queue.Enqueue(name, c.ContactGroup, true)
+			return
+		}
 		output, err := exec.Command("/usr/bin/cmk_admin", "-s", name, "-a", c.ContactGroup).CombinedOutput()
 		if err != nil {
-			fmt.Printf("%d %s - Failure to update contact group for service %s. Error: %s\n", OK, c.Name, name, err.Error())
+			c.report(OK, "Failure to update contact group for service %s, queued for retry. Error: %s", name, err.Error())
+			queue.Enqueue(name, c.ContactGroup, false)
 		} else {
-			os.Create(inventoryPath)
+			ioutil.WriteFile(inventoryPath, nil, 0644)
 			updated = true
 		}
-		if config.Verbose {
-			fmt.Printf("\n/usr/bin/cmk_admin -s %s -a %s\n%s\n\n", name, c.ContactGroup, output)
-		}
+		c.monitor.debugf("\n/usr/bin/cmk_admin -s %s -a %s\n%s\n\n", name, c.ContactGroup, output)
 	}
 	return
 }
 
-func (c *ContainerCheck) Run(t time.Duration, done chan bool) {
+func (c *ContainerCheck) Run(ctx context.Context, t time.Duration, done chan bool) {
 	defer func() { done <- true }()
 	if c.updateContactGroup(c.Name) {
 		return
 	}
-	o, err := silentSshCmd(c.User, c.Identity, c.container.Host, "ls "+c.Directory, c.container.SSHPort).Output()
+	c.checkSidecars()
+	c.checkOOM()
+	c.checkBuiltins(ctx, t)
+	if !c.setHostKeyVerification() {
+		return
+	}
+	// listing the check scripts is a single round trip, which makes it a fair measure of ssh latency
+	start := time.Now()
+	o, err := silentSshCmd(ctx, c.User, c.Identity, c.KnownHosts, c.Host, "ls "+c.Directory,
+		c.container.GetSSHPort()).Output()
+	sshTime := time.Since(start)
 	if err != nil {
-		fmt.Printf("%d %s - Error getting checks for container: %s\n", Critical, c.Name, err.Error())
+		c.report(Critical, "Error getting checks for container: %s", err.Error())
 		return
 	}
-	fmt.Printf("%d %s - Got checks for container\n", OK, c.Name)
 	scripts := strings.Split(strings.TrimSpace(string(o)), "\n")
-	if len(scripts) == 0 || len(scripts[0]) == 0 {
-		// nothing to check on this container, exit
+	if len(scripts) == 1 && len(scripts[0]) == 0 {
+		scripts = nil
+	}
+	checks := c.serviceChecks(scripts)
+	var checksTime time.Duration
+	if len(checks) > 0 {
+		checksTime = c.checkAll(ctx, checks, t)
+	}
+	result := &Result{State: OK, Service: c.Name, Message: "Got checks for container"}
+	result.AddPerfdata(durationMetric("ssh_time", sshTime))
+	result.AddPerfdata(fmt.Sprintf("checks=%d", len(checks)))
+	result.AddPerfdata(durationMetric("checks_time", checksTime))
+	c.emit(result)
+}
+
+// Verify the container's host key if the supervisor recorded it. Returns false, after reporting it, if it did
+// not and unknown host keys aren't allowed.
+func (c *ContainerCheck) setHostKeyVerification() bool {
+	if typedC, ok := c.container.(*types.Container); ok && len(typedC.HostKeys) > 0 {
+		c.KnownHosts = c.monitor.Config.KnownHostsFile
+		return true
+	}
+	if c.monitor.Config.AllowUnknownKeys {
+		return true
+	}
+	c.report(Critical, "No ssh host key recorded for the container, redeploy it or set allow_unknown_keys")
+	return false
+}
+
+// Report the state the supervisor last saw each of the container's sidecars in
+func (c *ContainerCheck) checkSidecars() {
+	typedC, ok := c.container.(*types.Container)
+	if !ok {
+		return
+	}
+	for _, sidecar := range typedC.Sidecars {
+		service := fmt.Sprintf("sidecar_%s_%s", sidecar.Spec.Name, typedC.ID)
+		if c.updateContactGroup(service) {
+			continue
+		}
+		if sidecar.Running {
+			c.emit(&Result{State: OK, Service: service, Message: fmt.Sprintf("Sidecar running, %d restarts",
+				sidecar.Restarts)})
+		} else {
+			c.emit(&Result{State: Critical, Service: service, Message: "Sidecar is not running"})
+		}
+	}
+}
+
+// Report OOM kills in the container. The check stays critical for the OOM window after the last one.
+func (c *ContainerCheck) checkOOM() {
+	typedC, ok := c.container.(*types.Container)
+	if !ok {
 		return
 	}
-	c.checkAll(scripts, t)
+	service := "oom_" + typedC.ID
+	if c.updateContactGroup(service) {
+		return
+	}
+	result := &Result{State: OK, Service: service, Perfdata: fmt.Sprintf("oom_kills=%d", typedC.OOMKills)}
+	window := time.Duration(c.monitor.Config.OOMWindow) * time.Second
+	if typedC.OOMKills == 0 {
+		result.Message = "No OOM kills"
+	} else if since := time.Since(typedC.LastOOMKill); since < window {
+		result.State = Critical
+		result.Message = fmt.Sprintf("%d OOM kills, last %s ago", typedC.OOMKills, since.Truncate(time.Second))
+	} else {
+		result.Message = fmt.Sprintf("%d OOM kills, last at %s", typedC.OOMKills,
+			typedC.LastOOMKill.Format(time.RFC3339))
+	}
+	c.emit(result)
+}
+
+// Returns the user the container's checks run as: the manifest's CheckUser, or the configured default
+func (c *ContainerCheck) checkUser() string {
+	if typedC, ok := c.container.(*types.Container); ok && typedC.Manifest != nil && typedC.Manifest.CheckUser != "" {
+		return typedC.Manifest.CheckUser
+	}
+	return c.monitor.Config.CheckUser
+}
+
+// Wrap a check command so it runs as the check user instead of the ssh user, which is usually root
+func (c *ContainerCheck) asCheckUser(command string) string {
+	user := c.checkUser()
+	if user == "" || user == c.User {
+		return command
+	}
+	return fmt.Sprintf("su -s /bin/sh %s -c %s", shellQuote(user), shellQuote(command))
+}
+
+// Quote s as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Returns the checks declared in the container's manifest, if it has one
+func (c *ContainerCheck) manifestChecks() []types.ManifestCheck {
+	switch typedC := c.container.(type) {
+	case *types.Container:
+		if typedC.Manifest != nil {
+			return typedC.Manifest.Checks
+		}
+	}
+	return nil
+}
+
+// Merge the scripts found in the check dir with the checks declared in the manifest. A manifest check replaces
+// a script of the same name.
+func (c *ContainerCheck) serviceChecks(scripts []string) []*ServiceCheck {
+	custom := c.manifestChecks()
+	overridden := map[string]bool{}
+	for _, def := range custom {
+		overridden[def.Name] = true
+	}
+	checks := []*ServiceCheck{}
+	for _, script := range scripts {
+		if overridden[strings.Split(script, ".")[0]] {
+			continue
+		}
+		checks = append(checks, c.serviceCheck(script))
+	}
+	for i := range custom {
+		if custom[i].Name == "" || custom[i].Command == "" {
+			c.report(Warning, "Manifest check #%d is missing a name or command", i)
+			continue
+		}
+		checks = append(checks, c.customCheck(&custom[i]))
+	}
+	return checks
 }
 
-func (c *ContainerCheck) checkAll(scripts []string, t time.Duration) {
-	results := make(chan bool, len(scripts))
-	for _, s := range scripts {
-		serviceName := fmt.Sprintf("%s_%s", strings.Split(s, ".")[0], c.container.ID)
-		if c.updateContactGroup(serviceName) {
-			results <- true
+// Run the checks in parallel, returning the time spent executing them summed over all checks. Every result
+// that was actually executed carries its own execution time as check_time perfdata.
+func (c *ContainerCheck) checkAll(ctx context.Context, checks []*ServiceCheck, t time.Duration) (total time.Duration) {
+	results := make(chan time.Duration, len(checks))
+	for _, s := range checks {
+		if c.updateContactGroup(s.Service) {
+			results <- 0
+		} else if result, ok := s.cachedResult(); ok {
+			c.emit(result)
+			results <- 0
 		} else {
-			go c.serviceCheck(s).checkWithTimeout(results, t)
+			go func(s *ServiceCheck) {
+				start := time.Now()
+				result := s.checkWithTimeout(ctx, t)
+				elapsed := time.Since(start)
+				result.AddPerfdata(durationMetric("check_time", elapsed))
+				result.Duration = elapsed
+				c.emit(result)
+				results <- elapsed
+			}(s)
 		}
 	}
-	for _ = range scripts {
-		<-results
+	for _ = range checks {
+		total += <-results
 	}
+	return total
 }
 
 func (c *ContainerCheck) serviceCheck(script string) *ServiceCheck {
 	// The full path to the script is required
-	command := fmt.Sprintf("%s/%s %d %s", c.Directory, script, c.container.PrimaryPort, c.container.ID)
+	command := fmt.Sprintf("%s/%s %d %s", c.Directory, script, c.container.GetPrimaryPort(), c.container.GetID())
 	// The service name is obtained be removing the file extension from the script and appending the container
 	// id
-	serviceName := fmt.Sprintf("%s_%s", strings.Split(script, ".")[0], c.container.ID)
-	return &ServiceCheck{serviceName, c.User, c.Identity, c.container.Host, c.container.SSHPort, command}
+	serviceName := fmt.Sprintf("%s_%s", strings.Split(script, ".")[0], c.container.GetID())
+	return &ServiceCheck{serviceName, c.User, c.Identity, c.Host, c.container.GetSSHPort(), c.KnownHosts,
+		c.asCheckUser(command), nil, c.monitor.Config.CheckStateDir, c.monitor.severities,
+		int(c.monitor.Config.MaxOutputBytes)}
 }
 
-func silentSshCmd(user, identity, host, cmd string, port uint16) *exec.Cmd {
-	args := []string{"-q", user + "@" + host, "-i", identity, "-p", fmt.Sprintf("%d", port), "-o", "StrictHostKeyChecking=no", cmd}
-	return exec.Command("ssh", args...)
+func (c *ContainerCheck) customCheck(def *types.ManifestCheck) *ServiceCheck {
+	serviceName := fmt.Sprintf("%s_%s", def.Name, c.container.GetID())
+	return &ServiceCheck{serviceName, c.User, c.Identity, c.Host, c.container.GetSSHPort(), c.KnownHosts,
+		c.asCheckUser(def.Command), def, c.monitor.Config.CheckStateDir, c.monitor.severities,
+		int(c.monitor.Config.MaxOutputBytes)}
 }
 
-func overlayConfig() {
+// Returns the ssh command running cmd on the container. The host key is verified against knownHosts unless it is
+// empty.
+func silentSshCmd(ctx context.Context, user, identity, knownHosts, host, cmd string, port uint16) *exec.Cmd {
+	hostKeyOpts := []string{"-o", "StrictHostKeyChecking=no"}
+	if knownHosts != "" {
+		hostKeyOpts = []string{"-o", "UserKnownHostsFile=" + knownHosts, "-o", "StrictHostKeyChecking=yes"}
+	}
+	// ssh takes IPv6 addresses without brackets, the port goes in -p
+	// only offer the given key, not whatever else the agent or ~/.ssh has
+	args := []string{"-q", user + "@" + types.UnbracketHost(host), "-i", identity, "-o", "IdentitiesOnly=yes", "-p",
+		fmt.Sprintf("%d", port)}
+	args = append(append(args, hostKeyOpts...), cmd)
+	return exec.CommandContext(ctx, "ssh", args...)
+}
+
+// Load the config file into config, then override it with the command line options. The error is only about the
+// config file, config is still usable.
+func overlayConfig(config *Config) error {
 	opts := &Opts{}
 	flags.Parse(opts)
+	if opts.Version {
+		fmt.Printf("atlantis-monitor %s\n", constant.BuildInfo())
+		os.Exit(0)
+	}
+	var err error
 	if opts.Config != "" {
-		_, err := toml.DecodeFile(opts.Config, config)
-		if err != nil {
-			// no need to panic here. we have reasonable defaults.
-		}
+		_, err = toml.DecodeFile(opts.Config, config)
 	}
 	if opts.ContainerFile != "" {
 		config.ContainerFile = opts.ContainerFile
@@ -300,48 +715,421 @@ func overlayConfig() {
 	if opts.Verbose {
 		config.Verbose = true
 	}
+	if opts.SortOutput {
+		config.SortOutput = true
+	}
+	if opts.Daemon {
+		config.Daemon = true
+	}
+	if opts.Piggyback {
+		config.Piggyback = true
+	}
+	if len(opts.Supervisors) > 0 {
+		config.Supervisors = opts.Supervisors
+	}
+	if opts.ExitMode != "" {
+		config.ExitMode = opts.ExitMode
+	}
+	if opts.LogBackend != "" {
+		config.LogBackend = opts.LogBackend
+	}
+	if opts.CheckConfig {
+		os.Exit(checkConfig(opts.Config, config, err))
+	}
+	return err
+}
+
+// Receives every result as it is produced. Handlers are never called concurrently.
+type ResultHandler func(*Result)
+
+// Runs the checks for every container managed by the supervisor. Other components can embed a Monitor instead
+// of running the monitor binary and parsing its output:
+//
+//	results := monitor.New(cfg).OnResult(handler).Run(ctx)
+//
+// A Monitor runs one pass at a time.
+type Monitor struct {
+	Config     *Config
+	Debug      io.Writer // verbose debug information is written here if Config.Verbose is set
+	handlers   []ResultHandler
+	queue      *CMKQueue
+	runtime    *client.Client // for the built-in checks, created on first use
+	severities []severityRule // compiled from Config.SeverityOverrides at the start of each pass
+	pending    *Config        // replaces Config at the start of the next pass
+	lock       sync.Mutex
+	results    []*Result
+}
+
+// Create a Monitor with the given config. A nil config uses the defaults.
+func New(cfg *Config) *Monitor {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+	return &Monitor{Config: cfg, Debug: ioutil.Discard}
+}
+
+// Register a handler that is called with each result as it is produced
+func (m *Monitor) OnResult(handler ResultHandler) *Monitor {
+	m.handlers = append(m.handlers, handler)
+	return m
+}
+
+func (m *Monitor) emit(result *Result) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.results = append(m.results, result)
+	for _, handler := range m.handlers {
+		handler(result)
+	}
+}
+
+// Report a result for the monitor itself
+func (m *Monitor) report(state int, format string, args ...interface{}) {
+	m.emit(&Result{State: state, Service: m.Config.CheckName, Message: fmt.Sprintf(format, args...)})
+}
+
+// Decrypt the container file, and encrypt the monitor's own state, with the key the supervisor saves its state with
+func setStateKey(config *Config) error {
+	key, err := serialize.LoadKey(config.StateKeyFile, config.StateKeyCommand)
+	if err != nil {
+		return err
+	}
+	return serialize.SetKey(key)
+}
+
+func (m *Monitor) debugf(format string, args ...interface{}) {
+	if m.Config.Verbose && m.Debug != nil {
+		fmt.Fprintf(m.Debug, format, args...)
+	}
 }
 
-//file containing containers and service name to show in Nagios for the monitor itself
-func Run() {
-	overlayConfig()
-	var contMap map[string]*types.Container
-	//Check if folder exists
-	_, err := os.Stat(config.ContainerFile)
+// Returns the directory holding the check scripts for the given container type
+func (m *Monitor) checkDir(containerType string) string {
+	if dir, ok := m.Config.CheckDirs[containerType]; ok && dir != "" {
+		return dir
+	}
+	return m.Config.CheckDir
+}
+
+// Retrieve a saved container map, reporting a result if it could not be read. ok is false on failure.
+func (m *Monitor) retrieveContainers(file string) (contMap map[string]*types.Container, ok bool) {
+	_, err := os.Stat(file)
 	if os.IsNotExist(err) {
-		fmt.Printf("%d %s - Container file does not exists %s. Likely no live containers present.\n", OK, config.CheckName, config.ContainerFile)
+		m.report(OK, "Container file does not exists %s. Likely no live containers present.", file)
+		return nil, false
+	}
+	if strings.HasSuffix(file, ".db") {
+		// the supervisor's state store
+		store := &serialize.BoltStore{File: file, ReadOnly: true, Timeout: 5 * time.Second}
+		err = store.RetrieveAll(supervisorContainersBucket, &contMap)
+	} else {
+		err = serialize.RetrieveObject(file, &contMap)
+	}
+	if serialize.IsCorrupt(err) {
+		m.report(Critical, "Container file %s is corrupt, containers are not being monitored: %s", file, err)
+		return nil, false
+	} else if err != nil {
+		m.report(Critical, "Error retrieving %s: %s", file, err)
+		return nil, false
+	}
+	return contMap, true
+}
+
+// Report how the supervisor's last check_mk re-inventory went as its own service
+func (m *Monitor) checkInventory() {
+	if len(m.Config.Supervisors) > 0 || !strings.HasSuffix(m.Config.ContainerFile, ".db") {
+		return
+	}
+	statuses := map[string]*types.InventoryStatus{}
+	store := &serialize.BoltStore{File: m.Config.ContainerFile, ReadOnly: true, Timeout: 5 * time.Second}
+	if err := store.RetrieveAll(supervisorStatusBucket, &statuses); err != nil {
+		m.debugf("could not read the inventory status: %v\n", err)
 		return
 	}
-	if err := serialize.RetrieveObject(config.ContainerFile, &contMap); err != nil {
-		fmt.Printf("%d %s - Error retrieving %s: %s\n", Critical, config.CheckName, config.ContainerFile, err)
+	status := statuses["inventory"]
+	if status == nil {
 		return
 	}
-	done := make(chan bool, len(contMap))
-	config.SSHIdentity = strings.Replace(config.SSHIdentity, "~", os.Getenv("HOME"), 1)
-	for _, c := range contMap {
-		if c.Host == "" {
-			c.Host = "localhost"
+	result := &Result{State: OK, Service: m.Config.CheckName + "_inventory",
+		Perfdata: fmt.Sprintf("attempts=%d", status.Attempts)}
+	if status.Error != "" {
+		result.State = Critical
+		result.Message = fmt.Sprintf("Re-inventory failed %d times at %s: %s", status.Attempts,
+			status.LastRun.Format(time.RFC3339), status.Error)
+	} else {
+		result.Message = fmt.Sprintf("Re-inventoried at %s", status.LastRun.Format(time.RFC3339))
+	}
+	m.emit(result)
+}
+
+// Returns the files to load containers from, keyed by container type. There are none when checking the
+// containers of remote supervisors.
+func (m *Monitor) containerFiles() map[string]string {
+	if len(m.Config.Supervisors) > 0 {
+		return nil
+	}
+	files := map[string]string{AppContainerType: m.Config.ContainerFile}
+	for contType, file := range m.Config.AuxContainerFiles {
+		if contType != AppContainerType {
+			files[contType] = file
+		}
+	}
+	return files
+}
+
+// Load the app containers and any auxiliary containers, keyed by container type. complete is false if any of
+// the container files could not be read. If Config.Supervisors is set, only the app containers of those
+// supervisors are loaded.
+func (m *Monitor) loadContainers() (contsByType map[string][]MonitoredContainer, complete bool) {
+	complete = true
+	contsByType = map[string][]MonitoredContainer{}
+	if len(m.Config.Supervisors) > 0 {
+		var conts []MonitoredContainer
+		conts, complete = m.listContainers()
+		if len(conts) > 0 {
+			contsByType[AppContainerType] = conts
+		}
+		return contsByType, complete
+	}
+	for contType, file := range m.containerFiles() {
+		contMap, ok := m.retrieveContainers(file)
+		if !ok {
+			complete = false
+			continue
+		}
+		for _, c := range contMap {
+			contsByType[contType] = append(contsByType[contType], c)
+		}
+	}
+	return contsByType, complete
+}
+
+// Run every check once and return the results. Cancelling ctx kills any checks still running; they are
+// reported as Critical.
+func (m *Monitor) Run(ctx context.Context) []*Result {
+	m.lock.Lock()
+	m.results = nil
+	if m.pending != nil {
+		m.Config, m.pending = m.pending, nil
+		if m.runtime != nil {
+			// the runtime might have changed with the config
+			m.runtime.Close()
+			m.runtime = nil
 		}
-		check := &ContainerCheck{config.CheckName + "_" + c.ID, config.SSHUser, config.SSHIdentity, config.CheckDir, config.InventoryDir, "", c}
-		go check.Run(time.Duration(config.TimeoutDuration)*time.Second, done)
 	}
-	for _ = range contMap {
+	m.lock.Unlock()
+	defer func() { m.queue = nil }()
+	config := m.Config
+	contsByType, complete := m.loadContainers()
+	if len(contsByType) == 0 {
+		return m.Results()
+	}
+	contIDs := map[string]bool{}
+	for _, conts := range contsByType {
+		for _, c := range conts {
+			contIDs[c.GetID()] = true
+		}
+	}
+	m.writeKnownHosts(contsByType)
+	severities, err := compileSeverityOverrides(config.SeverityOverrides)
+	if err != nil {
+		m.report(Critical, "Invalid severity_overrides, reporting checks unmapped: %s", err)
+	}
+	m.severities = severities
+	cmkHost := m.cmkHostTemplate()
+	m.checkInventory()
+	m.queue = m.loadCMKQueue(config.CMKQueueFile)
+	if pending := m.queue.Retry(config.InventoryDir); pending > 0 {
+		m.report(Warning, "%d cmk_admin operations queued for retry", pending)
+	}
+	done := make(chan bool, len(contIDs))
+	numChecks := 0
+	for contType, conts := range contsByType {
+		for _, c := range conts {
+			host := c.GetHost()
+			if host == "" {
+				host = "localhost"
+			}
+			check := &ContainerCheck{config.CheckName + "_" + c.GetID(), config.SSHUser, m.identity(c),
+				m.checkDir(contType), config.InventoryDir, "", host, m.cmkHost(cmkHost, c), "", c, false, m}
+			go check.Run(ctx, time.Duration(config.TimeoutDuration)*time.Second, done)
+			numChecks++
+		}
+	}
+	for i := 0; i < numChecks; i++ {
 		<-done
 	}
-	// Clean up inventories from containers that no longer exist
-	err = filepath.Walk(config.InventoryDir, func(path string, _ os.FileInfo, _ error) error {
-		if path == config.InventoryDir {
+	if !complete || ctx.Err() != nil {
+		// don't clean up inventories for containers we could not load or check
+		return m.Results()
+	}
+	// Clean up inventories, queued cmk_admin operations and saved check results from containers that no longer
+	// exist
+	m.queue.Prune(contIDs)
+	m.removeObsoleteMarkers(config.InventoryDir, contIDs)
+	if _, err := os.Stat(config.CheckStateDir); err == nil {
+		m.removeObsoleteMarkers(config.CheckStateDir, contIDs)
+	}
+	return m.Results()
+}
+
+// Returns the template naming each container's check_mk host, nil if results are not piggybacked
+func (m *Monitor) cmkHostTemplate() *template.Template {
+	if !m.Config.Piggyback {
+		return nil
+	}
+	tmpl, err := template.New("piggyback_host").Parse(m.Config.PiggybackHost)
+	if err != nil {
+		m.report(Critical, "Invalid piggyback_host, attributing results to container ids: %s", err)
+		tmpl = template.Must(template.New("piggyback_host").Parse("{{.GetID}}"))
+	}
+	return tmpl
+}
+
+// Returns the check_mk host the container's results are attributed to, falling back to its id
+func (m *Monitor) cmkHost(tmpl *template.Template, c MonitoredContainer) string {
+	if tmpl == nil {
+		return ""
+	}
+	var host bytes.Buffer
+	if err := tmpl.Execute(&host, c); err != nil || strings.TrimSpace(host.String()) == "" {
+		return c.GetID()
+	}
+	return strings.TrimSpace(host.String())
+}
+
+// Returns the host of a container as it appears in known_hosts
+func knownHostsHost(host string, port uint16) string {
+	host = types.UnbracketHost(host)
+	if port == 22 {
+		return host
+	}
+	return fmt.Sprintf("[%s]:%d", host, port)
+}
+
+// Write the host keys the supervisor recorded for the containers to KnownHostsFile
+func (m *Monitor) writeKnownHosts(contsByType map[string][]MonitoredContainer) {
+	var lines bytes.Buffer
+	for _, conts := range contsByType {
+		for _, c := range conts {
+			typedC, ok := c.(*types.Container)
+			if !ok {
+				continue
+			}
+			host := c.GetHost()
+			if host == "" {
+				host = "localhost"
+			}
+			for _, key := range typedC.HostKeys {
+				fmt.Fprintf(&lines, "%s %s\n", knownHostsHost(host, c.GetSSHPort()), key)
+			}
+		}
+	}
+	file := m.Config.KnownHostsFile
+	// replace the file in one go so running checks never see it half written
+	err := ioutil.WriteFile(file+".tmp", lines.Bytes(), 0644)
+	if err == nil {
+		err = os.Rename(file+".tmp", file)
+	}
+	if err != nil {
+		m.report(Critical, "Could not write the containers' host keys to %s: %s", file, err)
+	}
+}
+
+// Returns the results of the last (or current) run in the order they were produced
+func (m *Monitor) Results() []*Result {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]*Result{}, m.results...)
+}
+
+// Remove the files in dir named <service>_<container id> for containers that are not in contIDs
+func (m *Monitor) removeObsoleteMarkers(dir string, contIDs map[string]bool) {
+	err := filepath.Walk(dir, func(path string, _ os.FileInfo, _ error) error {
+		if path == dir {
 			return nil
 		}
 		var err error
 		split := strings.Split(path, "_")
 		cont := split[len(split)-1]
-		if _, ok := contMap[cont]; !ok {
+		if !contIDs[cont] {
 			err = os.Remove(path)
 		}
 		return err
 	})
 	if err != nil {
-		fmt.Printf("%d %s - Error iterating over inventory to delete obsolete markers. Error: %s\n", OK, config.CheckName, err.Error())
+		m.report(OK, "Error iterating over %s to delete obsolete markers. Error: %s", dir, err.Error())
+	}
+}
+
+// file containing containers and service name to show in Nagios for the monitor itself. Returns the status to exit
+// with, which main passes to os.Exit once the deferred flushes have run.
+func Run() int {
+	config := DefaultConfig()
+	// no need to panic here. we have reasonable defaults.
+	overlayConfig(config)
+	writer := NewResultWriter(os.Stdout, config.SortOutput)
+	writer.Piggyback = config.Piggyback
+	defer writer.Flush()
+	m := New(config).OnResult(writer.WriteResult)
+	m.Debug = writer
+	if err := setStateKey(config); err != nil {
+		m.report(Critical, "Could not load the state key: %s", err)
+	}
+	dispatcher := &Dispatcher{}
+	if config.RepeatInterval > 0 {
+		dispatcher.Dedup = m.loadDeduper(config.AlertStateFile, time.Duration(config.RepeatInterval)*time.Second)
+	}
+	m.OnResult(dispatcher.WriteResult)
+	if config.LogBackend != "" {
+		if sink, err := NewLogSink(config.LogBackend, config.LogTag); err != nil {
+			m.report(Warning, "Could not log to %s: %s", config.LogBackend, err)
+		} else {
+			defer sink.Close()
+			dispatcher.Add(sink.WriteResult)
+			m.Debug = io.MultiWriter(writer, sink)
+		}
+	}
+	if config.StatsDAddr != "" {
+		if sink, err := NewStatsDSink(config.StatsDAddr, config.StatsDPrefix); err != nil {
+			m.report(Warning, "Could not send metrics to StatsD at %s: %s", config.StatsDAddr, err)
+		} else {
+			defer sink.Close()
+			dispatcher.Add(sink.WriteResult)
+		}
+	}
+	// the local check output gets every result, the other sinks only what the dispatcher passes on
+	publish := func() {
+		dispatched, err := dispatcher.PassDone()
+		if err != nil {
+			m.report(Warning, "Could not save alert dedup state: %s", err)
+		}
+		if config.CloudWatch.Namespace != "" {
+			if err := config.CloudWatch.Publish(dispatched); err != nil {
+				m.report(Warning, "Could not publish to CloudWatch: %s", err)
+			}
+		}
+	}
+	if config.Daemon {
+		go reloadOnHUP(m)
+		interval := time.Duration(config.DaemonInterval) * time.Second
+		passDone := func([]*Result) {
+			publish()
+			writer.Flush()
+		}
+		if err := m.RunDaemon(context.Background(), interval, passDone); err != nil {
+			m.report(Critical, "Could not run as a daemon: %s", err)
+		}
+		return 0
+	}
+	results := m.Run(context.Background())
+	publish()
+	switch config.ExitMode {
+	case "", ExitModeSpool:
+	case ExitModeActive:
+		return WorstState(results)
+	default:
+		m.report(Warning, "Unknown exit_mode %q, use %s or %s", config.ExitMode, ExitModeSpool, ExitModeActive)
 	}
+	return 0
 }
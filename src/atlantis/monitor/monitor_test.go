@@ -0,0 +1,179 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package monitor
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"github.com/adjust/gocheck"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) { gocheck.TestingT(t) }
+
+type MonitorSuite struct{}
+
+var _ = gocheck.Suite(&MonitorSuite{})
+
+func (s *MonitorSuite) TestHostKeyVerification(c *gocheck.C) {
+	m := New(nil)
+	// deployed before the supervisor recorded host keys
+	check := &ContainerCheck{Name: "app-1", container: &types.Container{ID: "app-1"}, monitor: m}
+	c.Assert(check.setHostKeyVerification(), gocheck.Equals, true)
+	c.Assert(check.KnownHosts, gocheck.Equals, "")
+	c.Assert(m.Results(), gocheck.HasLen, 0)
+	m.Config.AllowUnknownKeys = false
+	c.Assert(check.setHostKeyVerification(), gocheck.Equals, false)
+	c.Assert(m.Results(), gocheck.HasLen, 1)
+	c.Assert(m.Results()[0].State, gocheck.Equals, Critical)
+	keyed := &ContainerCheck{Name: "app-2", monitor: m,
+		container: &types.Container{ID: "app-2", HostKeys: []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5"}}}
+	c.Assert(keyed.setHostKeyVerification(), gocheck.Equals, true)
+	c.Assert(keyed.KnownHosts, gocheck.Equals, m.Config.KnownHostsFile)
+}
+
+func (s *MonitorSuite) TestCustomThresholds(c *gocheck.C) {
+	zero, ten := 0.0, 10.0
+	check := &ServiceCheck{Service: "custom", Custom: &types.ManifestCheck{Name: "queue", Critical: &zero}}
+	// a 0 threshold is still a threshold: any value at or above it is critical
+	c.Assert(check.customResult([]byte("0 jobs queued"), nil).State, gocheck.Equals, Critical)
+	c.Assert(check.customResult([]byte("-1 jobs queued"), nil).State, gocheck.Equals, OK)
+	check.Custom = &types.ManifestCheck{Name: "queue", Warning: &zero, Critical: &ten}
+	c.Assert(check.customResult([]byte("3"), nil).State, gocheck.Equals, Warning)
+	c.Assert(check.customResult([]byte("12"), nil).State, gocheck.Equals, Critical)
+	// without thresholds the output is not compared, only the exit code counts
+	check.Custom = &types.ManifestCheck{Name: "queue"}
+	c.Assert(check.customResult([]byte("12"), nil).State, gocheck.Equals, OK)
+}
+
+// Records each Write separately
+type recordingWriter struct {
+	sync.Mutex
+	writes []string
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func (s *MonitorSuite) TestResultWriter(c *gocheck.C) {
+	out := &recordingWriter{}
+	writer := NewResultWriter(out, true)
+	var wg sync.WaitGroup
+	for _, service := range []string{"port_c", "port_a", "port_b"} {
+		wg.Add(1)
+		go func(service string) {
+			defer wg.Done()
+			writer.WriteResult(&Result{State: OK, Service: service, Message: "up"})
+		}(service)
+	}
+	wg.Wait()
+	// sorted results are held until the flush
+	c.Assert(out.writes, gocheck.HasLen, 0)
+	writer.Flush()
+	c.Assert(strings.Join(out.writes, ""), gocheck.Equals, "0 port_a - up\n0 port_b - up\n0 port_c - up\n")
+	// every result goes out in a single write, so the lines of concurrent checks never interleave
+	out = &recordingWriter{}
+	writer = NewResultWriter(out, false)
+	block := "0 first - one\n1 second - two\n"
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			writer.Print(block)
+		}()
+	}
+	wg.Wait()
+	c.Assert(out.writes, gocheck.HasLen, 20)
+	for _, write := range out.writes {
+		c.Assert(write, gocheck.Equals, block)
+	}
+}
+
+func (s *MonitorSuite) TestSeverityOverrides(c *gocheck.C) {
+	rules, err := compileSeverityOverrides([]SeverityOverride{{Service: "^disk_", From: "critical", To: "warning"}})
+	c.Assert(err, gocheck.IsNil)
+	check := &ServiceCheck{Service: "disk_app-1", severities: rules}
+	r := check.validate("2 disk_app-1 - 95% full")
+	c.Assert(r.State, gocheck.Equals, Warning)
+	c.Assert(r.Message, gocheck.Equals, "95% full (critical mapped to warning)")
+	// other states and services are reported as they are
+	r = check.validate("1 disk_app-1 - 85% full")
+	c.Assert(r.State, gocheck.Equals, Warning)
+	c.Assert(r.Message, gocheck.Equals, "85% full")
+	other := &ServiceCheck{Service: "port_app-1", severities: rules}
+	c.Assert(other.validate("2 port_app-1 - refused").State, gocheck.Equals, Critical)
+	_, err = compileSeverityOverrides([]SeverityOverride{{Service: "^disk_", From: "fatal", To: "warning"}})
+	c.Assert(err, gocheck.ErrorMatches, `service "\^disk_": from: unknown state "fatal".*`)
+}
+
+func (s *MonitorSuite) TestSanitize(c *gocheck.C) {
+	for _, t := range []struct {
+		max       int
+		message   string
+		sanitized string
+	}{
+		{0, "line one\nline two\r\n", "line one line two"},
+		{0, "\x1b[31mred\x1b[0m", "[31mred [0m"},
+		{10, "0123456789", "0123456789"},
+		{10, "0123456789abc", "0123456..."},
+		{5, "ééé", "é..."}, // never cut inside a character
+		{2, "abc", "..."},
+	} {
+		r := &Result{Message: t.message}
+		r.Sanitize(t.max)
+		c.Assert(r.Message, gocheck.Equals, t.sanitized, gocheck.Commentf("%q cut to %d", t.message, t.max))
+	}
+	// perfdata stays a single field of the line
+	r := &Result{Perfdata: "load=1\tjobs=2\n"}
+	r.Sanitize(0)
+	c.Assert(r.Perfdata, gocheck.Equals, "load=1jobs=2")
+	// check output is sanitized before it is reported
+	check := &ServiceCheck{Service: "queue", maxOutput: 8}
+	c.Assert(check.validate("0 queue - 12 jobs\x07 queued").Message, gocheck.Equals, "12 jo...")
+}
+
+func (s *MonitorSuite) TestDedup(c *gocheck.C) {
+	dir, err := ioutil.TempDir("", "dedup")
+	c.Assert(err, gocheck.IsNil)
+	defer os.RemoveAll(dir)
+	m := New(nil)
+	file := filepath.Join(dir, "alert_state")
+	dedup := m.loadDeduper(file, time.Hour)
+	critical := &Result{State: Critical, Service: "port_app-1", Host: "host-1"}
+	c.Assert(dedup.Allow(critical), gocheck.Equals, true)
+	// repeats are held back within the interval, for that host and service only
+	c.Assert(dedup.Allow(critical), gocheck.Equals, false)
+	c.Assert(dedup.Allow(&Result{State: Critical, Service: "port_app-1", Host: "host-2"}), gocheck.Equals, true)
+	// a state change is passed on at once
+	warning := &Result{State: Warning, Service: "port_app-1", Host: "host-1"}
+	c.Assert(dedup.Allow(warning), gocheck.Equals, true)
+	c.Assert(dedup.Allow(warning), gocheck.Equals, false)
+	// and so is a repeat once the interval has gone by
+	dedup.Alerts["host-1/port_app-1"].Sent = time.Now().Add(-2 * time.Hour)
+	c.Assert(dedup.Allow(warning), gocheck.Equals, true)
+	// recovering resets the service, so the next problem is passed on
+	c.Assert(dedup.Allow(&Result{State: OK, Service: "port_app-1", Host: "host-1"}), gocheck.Equals, true)
+	c.Assert(dedup.Allow(warning), gocheck.Equals, true)
+	// the interval holds across runs
+	c.Assert(dedup.Save(), gocheck.IsNil)
+	c.Assert(m.loadDeduper(file, time.Hour).Allow(warning), gocheck.Equals, false)
+	c.Assert(m.Results(), gocheck.HasLen, 0)
+}
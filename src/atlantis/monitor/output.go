@@ -0,0 +1,230 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A single check result, in the fields of a check_mk local check line
+type Result struct {
+	State     int
+	Service   string
+	Perfdata  string // empty if the check reported none
+	Message   string
+	Container string        // id of the container checked, empty for results about the monitor itself
+	Host      string        // check_mk host the result is attributed to in piggyback output, empty for this host
+	Duration  time.Duration // how long the check ran, 0 if it did not run this pass
+}
+
+// Returns the result as a "<state> <service> <perfdata> <message>" local check line
+func (r *Result) String() string {
+	perfdata := r.Perfdata
+	if perfdata == "" {
+		perfdata = "-"
+	}
+	return fmt.Sprintf("%d %s %s %s\n", r.State, r.Service, perfdata, r.Message)
+}
+
+// Append a metric to the result's perfdata, keeping whatever the check itself reported
+func (r *Result) AddPerfdata(metric string) {
+	if r.Perfdata == "" {
+		r.Perfdata = metric
+	} else {
+		r.Perfdata += "|" + metric
+	}
+}
+
+// Returns a duration metric in seconds, the unit pnp4nagios and Grafana expect for timings
+func durationMetric(name string, d time.Duration) string {
+	return fmt.Sprintf("%s=%.3fs", name, d.Seconds())
+}
+
+// Replace control characters, newlines included, with spaces and cut the message to at most max bytes (no limit
+// if 0), so a misbehaving check script can not corrupt the rest of the local check output
+func (r *Result) Sanitize(max int) {
+	clean := func(replacement rune) func(rune) rune {
+		return func(c rune) rune {
+			if unicode.IsControl(c) {
+				return replacement
+			}
+			return c
+		}
+	}
+	// perfdata is a single field of the line, so it can't gain spaces
+	r.Perfdata = strings.Map(clean(-1), r.Perfdata)
+	r.Message = strings.TrimSpace(strings.Map(clean(' '), r.Message))
+	if max <= 0 || len(r.Message) <= max {
+		return
+	}
+	const ellipsis = "..."
+	cut := max - len(ellipsis)
+	if cut < 0 {
+		cut = 0
+	}
+	for cut > 0 && !utf8.RuneStart(r.Message[cut]) {
+		cut--
+	}
+	r.Message = r.Message[:cut] + ellipsis
+}
+
+// How bad each state is, check_mk's ordering: unknown is worse than warning but not as bad as critical
+var stateSeverity = map[int]int{OK: 0, Warning: 1, Uknown: 2, Critical: 3}
+
+// Returns the worst state of the results, OK if there are none. States outside 0-3 count as unknown.
+func WorstState(results []*Result) int {
+	worst := OK
+	for _, r := range results {
+		state := r.State
+		if _, ok := stateSeverity[state]; !ok {
+			state = Uknown
+		}
+		if stateSeverity[state] > stateSeverity[worst] {
+			worst = state
+		}
+	}
+	return worst
+}
+
+// Parse a local check line as printed by a check script
+func ParseResult(line string) (*Result, error) {
+	fields := strings.SplitN(strings.TrimSpace(line), " ", 4)
+	if len(fields) < 3 {
+		return nil, errors.New("expected \"<state> <service> <perfdata> <message>\"")
+	}
+	state, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid state %q", fields[0])
+	}
+	r := &Result{State: state, Service: fields[1], Perfdata: fields[2]}
+	if r.Perfdata == "-" {
+		r.Perfdata = ""
+	}
+	if len(fields) == 4 {
+		r.Message = fields[3]
+	}
+	return r, nil
+}
+
+// Serializes check results coming from many goroutines. Every result is written with a single Write so lines
+// from concurrent checks never interleave. If Sorted is set, results are held until Flush and written ordered
+// by service name so the output is stable from run to run.
+//
+// If Piggyback is set, results with a Host are held until Flush and written as check_mk piggyback data, one
+// section per host, after the results for this host:
+//
+//	<<<<host>>>>
+//	<<<local>>>
+//	0 service - message
+//	<<<<>>>>
+//	<<<local>>>
+//
+// The trailing local header switches back to this host's local section, which the agent opened before running
+// the monitor.
+type ResultWriter struct {
+	sync.Mutex
+	Out       io.Writer
+	Sorted    bool
+	Piggyback bool
+	results   []string
+	hosts     map[string][]string // piggybacked results by host
+}
+
+func NewResultWriter(out io.Writer, sorted bool) *ResultWriter {
+	return &ResultWriter{Out: out, Sorted: sorted}
+}
+
+// Write a complete result (a line, or a block of lines)
+func (w *ResultWriter) Print(result string) {
+	if result == "" {
+		return
+	}
+	if !strings.HasSuffix(result, "\n") {
+		result += "\n"
+	}
+	w.Lock()
+	defer w.Unlock()
+	if w.Sorted {
+		w.results = append(w.results, result)
+		return
+	}
+	io.WriteString(w.Out, result)
+}
+
+func (w *ResultWriter) Printf(format string, args ...interface{}) {
+	w.Print(fmt.Sprintf(format, args...))
+}
+
+func (w *ResultWriter) WriteResult(r *Result) {
+	if !w.Piggyback || r.Host == "" {
+		w.Print(r.String())
+		return
+	}
+	w.Lock()
+	defer w.Unlock()
+	if w.hosts == nil {
+		w.hosts = map[string][]string{}
+	}
+	w.hosts[r.Host] = append(w.hosts[r.Host], r.String())
+}
+
+// Write p as a single result, so a ResultWriter can take debug output too
+func (w *ResultWriter) Write(p []byte) (int, error) {
+	w.Print(string(p))
+	return len(p), nil
+}
+
+// Returns the service name of a "<state> <service> - <message>" result
+func serviceName(result string) string {
+	fields := strings.SplitN(strings.TrimSpace(result), " ", 3)
+	if len(fields) < 2 {
+		return ""
+	}
+	return fields[1]
+}
+
+type byService []string
+
+func (r byService) Len() int           { return len(r) }
+func (r byService) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byService) Less(i, j int) bool { return serviceName(r[i]) < serviceName(r[j]) }
+
+// Write out any held results
+func (w *ResultWriter) Flush() {
+	w.Lock()
+	defer w.Unlock()
+	sort.Stable(byService(w.results))
+	io.WriteString(w.Out, strings.Join(w.results, ""))
+	w.results = nil
+	hosts := make([]string, 0, len(w.hosts))
+	for host := range w.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		results := w.hosts[host]
+		if w.Sorted {
+			sort.Stable(byService(results))
+		}
+		io.WriteString(w.Out, "<<<<"+host+">>>>\n<<<local>>>\n"+strings.Join(results, "")+"<<<<>>>>\n<<<local>>>\n")
+	}
+	w.hosts = nil
+}
@@ -0,0 +1,157 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package monitor
+
+import (
+	"atlantis/supervisor/containers/serialize"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A contact group assignment that failed because cmk_admin could not be reached
+type CMKOp struct {
+	Service      string
+	ContactGroup string
+	Verify       bool // check that ContactGroup exists before assigning it, falling back to the default group
+	Attempts     uint
+	NextAttempt  time.Time
+}
+
+// Pending cmk_admin operations. These are saved to disk so they survive across monitor runs and are retried
+// with exponential backoff until check_mk is reachable again.
+type CMKQueue struct {
+	sync.Mutex
+	File    string
+	Ops     map[string]*CMKOp // service name -> pending op
+	monitor *Monitor
+}
+
+func (m *Monitor) loadCMKQueue(file string) *CMKQueue {
+	q := &CMKQueue{File: file, Ops: map[string]*CMKOp{}, monitor: m}
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return q
+	}
+	if err := serialize.RetrieveObject(file, &q.Ops); serialize.IsCorrupt(err) {
+		m.report(Warning, "Discarding corrupt cmk_admin queue %s: %s", file, err)
+		q.Ops = nil
+	} else if err != nil {
+		m.report(Warning, "Could not load cmk_admin queue %s: %s", file, err)
+	}
+	if q.Ops == nil {
+		q.Ops = map[string]*CMKOp{}
+	}
+	return q
+}
+
+func (q *CMKQueue) save() {
+	if q.File == "" {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(q.File), 0755); err != nil {
+		q.monitor.report(Warning, "Could not save cmk_admin queue: %s", err)
+		return
+	}
+	if err := serialize.SaveObject(q.File, q.Ops); err != nil {
+		q.monitor.report(Warning, "Could not save cmk_admin queue: %s", err)
+	}
+}
+
+// Returns the delay before the given attempt, doubling from CMKRetryBase up to CMKRetryMax
+func (q *CMKQueue) backoff(attempts uint) time.Duration {
+	config := q.monitor.Config
+	delay := time.Duration(config.CMKRetryBase) * time.Second
+	max := time.Duration(config.CMKRetryMax) * time.Second
+	for i := uint(1); i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+func (q *CMKQueue) Pending(service string) bool {
+	q.Lock()
+	defer q.Unlock()
+	_, ok := q.Ops[service]
+	return ok
+}
+
+func (q *CMKQueue) Enqueue(service, group string, verify bool) {
+	q.Lock()
+	defer q.Unlock()
+	if _, ok := q.Ops[service]; ok {
+		return
+	}
+	q.Ops[service] = &CMKOp{service, group, verify, 1, time.Now().Add(q.backoff(1))}
+	q.save()
+}
+
+// Retry every operation that is due. Successful operations get an inventory marker and are removed from the
+// queue. Returns the number of operations still pending.
+func (q *CMKQueue) Retry(inventory string) int {
+	q.Lock()
+	defer q.Unlock()
+	config := q.monitor.Config
+	now := time.Now()
+	for service, op := range q.Ops {
+		if now.Before(op.NextAttempt) {
+			continue
+		}
+		group := op.ContactGroup
+		if op.Verify {
+			exists, err := contactGroupExists(group)
+			if err != nil {
+				op.Attempts++
+				op.NextAttempt = now.Add(q.backoff(op.Attempts))
+				continue
+			}
+			if !exists {
+				q.monitor.emit(&Result{State: Critical, Service: service, Message: fmt.Sprintf(
+					"Specified contact_group %s does not exist in cmk! Falling back to default group %s.", group,
+					config.DefaultGroup)})
+				group = config.DefaultGroup
+			}
+		}
+		output, err := exec.Command("/usr/bin/cmk_admin", "-s", service, "-a", group).CombinedOutput()
+		q.monitor.debugf("\n[retry %d] /usr/bin/cmk_admin -s %s -a %s\n%s\n\n", op.Attempts, service, group, output)
+		if err != nil {
+			op.Attempts++
+			op.NextAttempt = now.Add(q.backoff(op.Attempts))
+			continue
+		}
+		ioutil.WriteFile(path.Join(inventory, service), nil, 0644)
+		delete(q.Ops, service)
+	}
+	q.save()
+	return len(q.Ops)
+}
+
+// Drop queued operations for containers that no longer exist
+func (q *CMKQueue) Prune(contIDs map[string]bool) {
+	q.Lock()
+	defer q.Unlock()
+	for service, _ := range q.Ops {
+		split := strings.Split(service, "_")
+		if !contIDs[split[len(split)-1]] {
+			delete(q.Ops, service)
+		}
+	}
+	q.save()
+}
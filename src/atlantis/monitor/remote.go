@@ -0,0 +1,82 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package monitor
+
+import (
+	. "atlantis/supervisor/constant"
+	"atlantis/supervisor/rpc/client"
+	"atlantis/supervisor/rpc/types"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Seconds to wait for a supervisor to answer the List RPC
+const supervisorListTimeout = 10
+
+// Returns the host of a supervisor RPC endpoint and the endpoint with the default port filled in if it had none
+func supervisorEndpoint(endpoint string) (host, hostAndPort string) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host = types.UnbracketHost(endpoint)
+		port = strconv.Itoa(int(DefaultSupervisorRPCPort))
+	}
+	return host, net.JoinHostPort(host, port)
+}
+
+// Load the app containers of every supervisor in Config.Supervisors through their List RPC. Containers without
+// a host are checked on the host of the supervisor managing them. complete is false if any supervisor could not
+// be reached.
+func (m *Monitor) listContainers() (conts []MonitoredContainer, complete bool) {
+	arg := types.SupervisorListArg{}
+	if m.Config.SupervisorToken != "" {
+		// read every pass so the token can be rotated without a reload
+		data, err := ioutil.ReadFile(m.Config.SupervisorToken)
+		if err != nil {
+			m.report(Critical, "Could not read the supervisor token, containers are not being monitored: %s", err)
+			return nil, false
+		}
+		arg.Token = strings.TrimSpace(string(data))
+	}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	complete = true
+	for _, endpoint := range m.Config.Supervisors {
+		wg.Add(1)
+		go func(endpoint string) {
+			defer wg.Done()
+			host, hostAndPort := supervisorEndpoint(endpoint)
+			var reply types.SupervisorListReply
+			err := client.NewSupervisorRPCClient(hostAndPort).CallWithTimeout("List", arg, &reply,
+				supervisorListTimeout)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				m.report(Critical, "Could not list containers on supervisor %s, they are not being monitored: %s",
+					endpoint, err)
+				complete = false
+				return
+			}
+			m.debugf("supervisor %s has %d containers\n", endpoint, len(reply.Containers))
+			for _, c := range reply.Containers {
+				if c.Host == "" {
+					c.Host = host
+				}
+				conts = append(conts, c)
+			}
+		}(endpoint)
+	}
+	wg.Wait()
+	return conts, complete
+}
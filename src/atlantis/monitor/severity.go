@@ -0,0 +1,77 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package monitor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Remaps the state a matching check reports, e.g. to treat a known-noisy check's critical as a warning, so alert
+// policy can be tuned without editing check scripts. States are named ok, warning, critical or unknown.
+//
+//	[[severity_overrides]]
+//	service = "^disk_"
+//	from = "critical"
+//	to = "warning"
+type SeverityOverride struct {
+	Service string `toml:"service"` // regexp matched against the service name, which ends in the container id
+	From    string `toml:"from"`
+	To      string `toml:"to"`
+}
+
+type severityRule struct {
+	service  *regexp.Regexp
+	from, to int
+}
+
+var stateNames = []string{OK: "ok", Warning: "warning", Critical: "critical", Uknown: "unknown"}
+
+func parseState(name string) (int, error) {
+	for state, n := range stateNames {
+		if strings.EqualFold(name, n) {
+			return state, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown state %q, expected one of %s", name, strings.Join(stateNames, ", "))
+}
+
+func compileSeverityOverrides(overrides []SeverityOverride) ([]severityRule, error) {
+	rules := make([]severityRule, len(overrides))
+	for i, o := range overrides {
+		var err error
+		if rules[i].service, err = regexp.Compile(o.Service); err != nil {
+			return nil, fmt.Errorf("service %q: %s", o.Service, err)
+		}
+		if rules[i].from, err = parseState(o.From); err != nil {
+			return nil, fmt.Errorf("service %q: from: %s", o.Service, err)
+		}
+		if rules[i].to, err = parseState(o.To); err != nil {
+			return nil, fmt.Errorf("service %q: to: %s", o.Service, err)
+		}
+	}
+	return rules, nil
+}
+
+// Apply the first rule matching the result's service and state. The message notes the original state so the
+// override is visible in Nagios.
+func mapSeverity(rules []severityRule, r *Result) {
+	for _, rule := range rules {
+		if rule.from != r.State || !rule.service.MatchString(r.Service) {
+			continue
+		}
+		r.Message = fmt.Sprintf("%s (%s mapped to %s)", r.Message, stateNames[rule.from], stateNames[rule.to])
+		r.State = rule.to
+		return
+	}
+}
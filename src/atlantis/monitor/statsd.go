@@ -0,0 +1,75 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+const DefaultStatsDPrefix = "atlantis.monitor"
+
+// Characters StatsD or the graphite naming behind it don't allow in a metric name component
+var unsafeMetricChars = regexp.MustCompile("[^a-zA-Z0-9_-]")
+
+// Publishes each result to StatsD as a gauge of its state and, for checks that were run, a timer of how long they
+// took:
+//
+//	<prefix>.<container id>.<check>.state:2|g
+//	<prefix>.<container id>.<check>.time:153|ms
+//
+// Results about the monitor itself go under <prefix>.monitor.<service>.
+type StatsDSink struct {
+	prefix string
+	conn   net.Conn
+}
+
+// Send metrics to the StatsD daemon at addr (host:port), named under prefix, atlantis.monitor if it is empty
+func NewStatsDSink(addr, prefix string) (*StatsDSink, error) {
+	if prefix == "" {
+		prefix = DefaultStatsDPrefix
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsDSink{prefix: strings.TrimSuffix(prefix, "."), conn: conn}, nil
+}
+
+// A ResultHandler sending the result's metrics in a single packet
+func (s *StatsDSink) WriteResult(r *Result) {
+	var buf bytes.Buffer
+	name := s.metricName(r)
+	fmt.Fprintf(&buf, "%s.state:%d|g\n", name, r.State)
+	if r.Duration > 0 {
+		fmt.Fprintf(&buf, "%s.time:%d|ms\n", name, r.Duration.Nanoseconds()/1e6)
+	}
+	// StatsD is fire and forget, a lost packet only leaves a gap in the graphs
+	s.conn.Write(buf.Bytes())
+}
+
+// Returns <prefix>.<container id>.<check> for a container's check, <prefix>.monitor.<service> for the monitor's own
+func (s *StatsDSink) metricName(r *Result) string {
+	if r.Container == "" {
+		return s.prefix + ".monitor." + unsafeMetricChars.ReplaceAllString(r.Service, "_")
+	}
+	check := strings.TrimSuffix(r.Service, "_"+r.Container)
+	return s.prefix + "." + unsafeMetricChars.ReplaceAllString(r.Container, "_") + "." +
+		unsafeMetricChars.ReplaceAllString(check, "_")
+}
+
+func (s *StatsDSink) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,76 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package apptype
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"github.com/docker/docker/api/types/container"
+	"sort"
+	"sync"
+)
+
+// Customizes the containers of an app type, the AppType of their manifest. Handlers keep what is particular to
+// an app type in one place instead of spread over the deploy code.
+type Handler interface {
+	// Fill in what the manifest leaves to the app type, such as probes and checks. Called when the container is
+	// deployed, before anything is created, so what is set here is used like the manifest's own settings.
+	Defaults(m *types.Manifest)
+	// Adjust the docker config of a container of this type just before it is created
+	Configure(c *types.Container, cfg *container.Config, hostCfg *container.HostConfig)
+}
+
+// A Handler that changes nothing, to embed in handlers that only need some of the methods
+type BaseHandler struct{}
+
+func (BaseHandler) Defaults(m *types.Manifest) {}
+
+func (BaseHandler) Configure(c *types.Container, cfg *container.Config, hostCfg *container.HostConfig) {
+}
+
+var (
+	handlersLock = sync.RWMutex{}
+	handlers     = map[string]Handler{
+		Java:   JavaHandler{},
+		Go:     GoHandler{},
+		Worker: WorkerHandler{},
+		Cron:   CronHandler{},
+	}
+)
+
+// Register the handler for an app type, replacing the built-in one if there is one
+func Register(appType string, h Handler) {
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	handlers[appType] = h
+}
+
+// Returns the handler for an app type, BaseHandler for app types without one
+func Get(appType string) Handler {
+	handlersLock.RLock()
+	defer handlersLock.RUnlock()
+	if h, ok := handlers[appType]; ok {
+		return h
+	}
+	return BaseHandler{}
+}
+
+// Returns the app types with a handler
+func Names() []string {
+	handlersLock.RLock()
+	defer handlersLock.RUnlock()
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,105 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package apptype
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+)
+
+// The built-in app types
+const (
+	Java   = "java"
+	Go     = "go"
+	Worker = "worker"
+	Cron   = "cron"
+)
+
+// Percent of the memory limit a java app gets for its heap unless its manifest sets JAVA_OPTS
+const JavaHeapPercent = 75
+
+// Java apps serve on their primary port and size their heap to the container
+type JavaHandler struct{}
+
+func (JavaHandler) Defaults(m *types.Manifest) {
+	defaultReadiness(m)
+	if m.MemoryLimit > 0 {
+		defaultEnv(m, "JAVA_OPTS", fmt.Sprintf("-Xmx%dm", m.MemoryLimit*JavaHeapPercent/100))
+	}
+}
+
+// the JVM sizes its thread pools to the cores of the host, not the ones dedicated to the container
+func (JavaHandler) Configure(c *types.Container, cfg *container.Config, hostCfg *container.HostConfig) {
+	if len(c.CPUSet) > 0 && c.Manifest.Env["JAVA_TOOL_OPTIONS"] == "" {
+		cfg.Env = append(cfg.Env, fmt.Sprintf("JAVA_TOOL_OPTIONS=-XX:ActiveProcessorCount=%d", len(c.CPUSet)))
+	}
+}
+
+// Go apps serve on their primary port
+type GoHandler struct{}
+
+func (GoHandler) Defaults(m *types.Manifest) {
+	defaultReadiness(m)
+}
+
+// the go runtime runs as many threads as the host has cores, not the ones dedicated to the container
+func (GoHandler) Configure(c *types.Container, cfg *container.Config, hostCfg *container.HostConfig) {
+	if len(c.CPUSet) > 0 && c.Manifest.Env["GOMAXPROCS"] == "" {
+		cfg.Env = append(cfg.Env, fmt.Sprintf("GOMAXPROCS=%d", len(c.CPUSet)))
+	}
+}
+
+// Workers serve nothing, so they get no probes, but are restarted when they fail
+type WorkerHandler struct {
+	BaseHandler
+}
+
+func (WorkerHandler) Defaults(m *types.Manifest) {
+	if m.RestartPolicy == nil {
+		m.RestartPolicy = &types.RestartPolicy{Name: types.RestartOnFailure, Backoff: 10}
+	}
+}
+
+// Cron apps run their jobs from the container's cron daemon, which the monitor checks is running
+type CronHandler struct {
+	BaseHandler
+}
+
+const CronCheckName = "cron"
+
+func (CronHandler) Defaults(m *types.Manifest) {
+	for _, check := range m.Checks {
+		if check.Name == CronCheckName {
+			return
+		}
+	}
+	m.Checks = append(m.Checks, types.ManifestCheck{Name: CronCheckName,
+		Command: "pgrep -x cron >/dev/null || pgrep -x crond >/dev/null || exit 2"})
+}
+
+// Check that the primary port takes connections before the container is ready, unless the manifest says how
+func defaultReadiness(m *types.Manifest) {
+	if m.Readiness == nil {
+		m.Readiness = &types.Probe{Type: types.ProbeTCP}
+	}
+}
+
+func defaultEnv(m *types.Manifest, name, val string) {
+	if _, ok := m.Env[name]; ok {
+		return
+	}
+	if m.Env == nil {
+		m.Env = map[string]string{}
+	}
+	m.Env[name] = val
+}
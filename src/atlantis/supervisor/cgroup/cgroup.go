@@ -0,0 +1,229 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+// Package cgroup reads the cgroup hierarchy the supervisor's containers are enforced by. Docker writes the limits
+// itself, but the files they end up in differ between the v1 hierarchy (cpu.shares, memory.limit_in_bytes, one
+// mount per controller) and the v2 unified hierarchy (cpu.weight, memory.max, one mount for everything).
+package cgroup
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	V1 = 1
+	V2 = 2
+)
+
+const (
+	minShares = 2
+	maxShares = 262144
+	maxWeight = 10000
+	// CPU shares are scaled up before they are handed to docker on v2 so small relative shares do not all end
+	// up with the minimum weight
+	v2ShareScale = 1024
+)
+
+var (
+	Root     = "/sys/fs/cgroup"
+	ProcRoot = "/proc"
+)
+
+// Returns V2 if the unified hierarchy is mounted at Root, V1 otherwise
+func Version() int {
+	if _, err := os.Stat(path.Join(Root, "cgroup.controllers")); err == nil {
+		return V2
+	}
+	return V1
+}
+
+// Check that the cpu and memory controllers the supervisor relies on are available
+func CheckControllers() error {
+	if Version() == V2 {
+		data, err := ioutil.ReadFile(path.Join(Root, "cgroup.controllers"))
+		if err != nil {
+			return err
+		}
+		available := map[string]bool{}
+		for _, controller := range strings.Fields(string(data)) {
+			available[controller] = true
+		}
+		for _, controller := range []string{"cpu", "memory"} {
+			if !available[controller] {
+				return fmt.Errorf("the %s controller is not enabled in %s", controller, Root)
+			}
+		}
+		return nil
+	}
+	for _, file := range []string{"cpu/cpu.shares", "memory/memory.limit_in_bytes"} {
+		if _, err := os.Stat(path.Join(Root, file)); err != nil {
+			return fmt.Errorf("%s is missing, is the cgroup v1 hierarchy mounted?", path.Join(Root, file))
+		}
+	}
+	return nil
+}
+
+// Returns the CPU shares to hand docker for the given relative shares. On v2 docker converts shares to a weight
+// with the same formula as CPUWeight, which maps everything below ~28 shares to the minimum weight, so the
+// shares are scaled up to keep the containers' relative weights apart.
+func DockerCPUShares(shares uint) int64 {
+	if shares == 0 || Version() != V2 {
+		return int64(shares)
+	}
+	scaled := uint64(shares) * v2ShareScale
+	if scaled > maxShares {
+		scaled = maxShares
+	}
+	return int64(scaled)
+}
+
+// Convert v1 CPU shares to a v2 cpu.weight the way runc does
+func CPUWeight(shares uint64) uint64 {
+	if shares == 0 {
+		return 0
+	}
+	if shares < minShares {
+		shares = minShares
+	}
+	if shares > maxShares {
+		shares = maxShares
+	}
+	return 1 + ((shares-minShares)*(maxWeight-1))/(maxShares-minShares)
+}
+
+// Read the usage of the cgroup the process with the given pid is in
+func ContainerUsage(pid int) (*types.ResourceUsage, error) {
+	version := Version()
+	cgroups, err := procCgroups(pid)
+	if err != nil {
+		return nil, err
+	}
+	usage := &types.ResourceUsage{CgroupVersion: version}
+	if version == V2 {
+		dir := path.Join(Root, cgroups[""])
+		weight, err := readUint(path.Join(dir, "cpu.weight"))
+		if err != nil {
+			return nil, err
+		}
+		usage.CPUShares = sharesFromWeight(weight)
+		stat, err := readKeyed(path.Join(dir, "cpu.stat"))
+		if err != nil {
+			return nil, err
+		}
+		usage.CPUTime = time.Duration(stat["usage_usec"]) * time.Microsecond
+		if usage.MemoryBytes, err = readUint(path.Join(dir, "memory.current")); err != nil {
+			return nil, err
+		}
+		if usage.MemoryLimit, err = readUint(path.Join(dir, "memory.max")); err != nil {
+			return nil, err
+		}
+		return usage, nil
+	}
+	cpuDir := path.Join(Root, "cpu", cgroups["cpu"])
+	if usage.CPUShares, err = readUint(path.Join(cpuDir, "cpu.shares")); err != nil {
+		return nil, err
+	}
+	cpuNanos, err := readUint(path.Join(Root, "cpuacct", cgroups["cpuacct"], "cpuacct.usage"))
+	if err != nil {
+		return nil, err
+	}
+	usage.CPUTime = time.Duration(cpuNanos)
+	memDir := path.Join(Root, "memory", cgroups["memory"])
+	if usage.MemoryBytes, err = readUint(path.Join(memDir, "memory.usage_in_bytes")); err != nil {
+		return nil, err
+	}
+	if usage.MemoryLimit, err = readUint(path.Join(memDir, "memory.limit_in_bytes")); err != nil {
+		return nil, err
+	}
+	if usage.MemoryLimit >= 1<<62 {
+		// v1 reports no limit as a huge page-aligned number
+		usage.MemoryLimit = 0
+	}
+	return usage, nil
+}
+
+// The inverse of CPUWeight, rounded to the shares that convert back to the same weight
+func sharesFromWeight(weight uint64) uint64 {
+	if weight == 0 {
+		return 0
+	}
+	return minShares + ((weight-1)*(maxShares-minShares)+maxWeight-2)/(maxWeight-1)
+}
+
+// Returns the cgroup of each controller of a process from /proc/<pid>/cgroup. On v2 the unified cgroup has the
+// controller "".
+func procCgroups(pid int) (map[string]string, error) {
+	f, err := os.Open(path.Join(ProcRoot, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cgroups := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			cgroups[strings.TrimPrefix(controller, "name=")] = parts[2]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(cgroups) == 0 {
+		return nil, errors.New("no cgroups found for pid " + strconv.Itoa(pid))
+	}
+	return cgroups, nil
+}
+
+// Read a file holding a single number. "max" is returned as 0.
+func readUint(file string) (uint64, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// Read a file of "<key> <number>" lines, like cpu.stat
+func readKeyed(file string) (map[string]uint64, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]uint64{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if value, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = value
+		}
+	}
+	return values, nil
+}
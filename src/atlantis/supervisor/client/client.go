@@ -15,34 +15,61 @@ import (
 	. "atlantis/common"
 	. "atlantis/supervisor/constant"
 	. "atlantis/supervisor/rpc/types"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/BurntSushi/toml"
 	"github.com/jigish/go-flags"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"text/tabwriter"
 	"time"
 )
 
 type Config struct {
-	Host string `toml:"host"`
-	Port uint16 `toml:"port"`
+	Host      string `toml:"host"`
+	Port      uint16 `toml:"port"`
+	Output    string `toml:"output"`     // how containers are printed, one of ContainerFormats
+	TokenFile string `toml:"token_file"` // holds the token to call the supervisor with, if it has an RBAC file
 }
 
 func (c *Config) RPCHostAndPort() string {
-	return fmt.Sprintf("%s:%d", c.Host, c.Port)
+	return HostPort(c.Host, c.Port)
 }
 
 type Opts struct {
-	Host   string `short:"H" long:"host" description:"the supervisor host to use"`
-	Port   uint16 `short:"P" long:"port" description:"the supervisor port to use"`
-	Config string `short:"F" long:"config-file" default:"/etc/atlantis/supervisor/client.toml" description:"the config file to use"`
+	Host      string `short:"H" long:"host" description:"the supervisor host to use"`
+	Port      uint16 `short:"P" long:"port" description:"the supervisor port to use"`
+	Config    string `short:"F" long:"config-file" default:"/etc/atlantis/supervisor/client.toml" description:"the config file to use"`
+	Output    string `short:"o" long:"output" description:"how to print containers: short, long or table"`
+	TokenFile string `short:"T" long:"token-file" description:"file with the token to call the supervisor with"`
 }
 
 var opts = &Opts{}
-var config = &Config{"localhost", DefaultSupervisorRPCPort}
+var config = &Config{Host: "localhost", Port: DefaultSupervisorRPCPort, Output: ContainerFormatLong}
 var rpcClient = NewRPCClientWithConfig(config, "Supervisor", SupervisorRPCVersion, false)
+var token string
+
+// An RPC arg the token can be set on
+type authArg interface {
+	SetToken(token string)
+}
+
+// Call the supervisor with the token
+func call(name string, arg authArg, reply interface{}) error {
+	arg.SetToken(token)
+	return rpcClient.Call(name, arg, reply)
+}
+
+func callWithTimeout(name string, arg authArg, reply interface{}, timeout int) error {
+	arg.SetToken(token)
+	return rpcClient.CallWithTimeout(name, arg, reply, timeout)
+}
 
 type Supervisor struct {
 	*flags.Parser
@@ -52,18 +79,47 @@ type Supervisor struct {
 func New() *Supervisor {
 	ih := &Supervisor{flags.NewParser(opts, flags.Default)}
 	ih.AddCommand("health", "check supervisor's health", "", &HealthCommand{})
+	ih.AddCommand("config", "show the config the supervisor is running with", "", &ConfigCommand{})
 	ih.AddCommand("list", "list supervisor containers & unused ports", "", &ListCommand{})
 	ih.AddCommand("deploy", "deploy an app+sha", "", &DeployCommand{})
+	ih.AddCommand("redeploy", "replace a container with a new sha once it is ready", "", &RedeployCommand{})
+	ih.AddCommand("rollback", "replace a redeployed container with the release it replaced", "",
+		&RollbackCommand{})
+	ih.AddCommand("diff-manifest", "show what a new manifest would change in a container", "",
+		&DiffManifestCommand{})
 	ih.AddCommand("teardown", "teardown one or more containers", "", &TeardownCommand{})
 	ih.AddCommand("get", "get information about a container", "", &GetCommand{})
+	ih.AddCommand("resize-container", "change the cpu shares and memory limit of a running container", "",
+		&ResizeContainerCommand{})
+	ih.AddCommand("checkpoint", "save the state of a running container (experimental)", "",
+		&CheckpointCommand{})
+	ih.AddCommand("restore-checkpoint", "start a container from its checkpoint (experimental)", "",
+		&RestoreCheckpointCommand{})
+	ih.AddCommand("list-events", "list exits and restarts of containers", "", &ListEventsCommand{})
+	ih.AddCommand("stats-history", "show the cpu, memory and network usage of containers over time", "",
+		&StatsHistoryCommand{})
+	ih.AddCommand("logs", "show what a container wrote to stdout and stderr", "", &LogsCommand{})
 	ih.AddCommand("version", "check supervisor's client and server versions", "", &VersionCommand{})
 	ih.AddCommand("authorize-ssh", "authorize ssh into a container", "", &AuthorizeSSHCommand{})
 	ih.AddCommand("deuthorize-ssh", "deauthorize ssh access to a container", "", &DeauthorizeSSHCommand{})
+	ih.AddCommand("ssh-audit", "show who was given ssh access to containers and when", "", &SSHAuditCommand{})
+	ih.AddCommand("maintenance", "show or set maintenance mode for the supervisor", "[on|off]",
+		&MaintenanceCommand{})
 	ih.AddCommand("container-maintenance", "set maintenance mode for a container", "",
 		&ContainerMaintenanceCommand{})
 	ih.AddCommand("update-ip-group", "update an ip group", "", &UpdateIPGroupCommand{})
 	ih.AddCommand("delete-ip-group", "delete an ip group", "", &DeleteIPGroupCommand{})
+	ih.AddCommand("network-rules", "list the firewall rules set up for containers", "", &NetworkRulesCommand{})
 	ih.AddCommand("idle", "check if supervisor is idle", "", &IdleCommand{})
+	ih.AddCommand("prefetch-image", "pull the image of an app+sha ahead of a deploy", "", &PrefetchImageCommand{})
+	ih.AddCommand("image-gc", "remove images not used by any container", "", &ImageGCCommand{})
+	ih.AddCommand("backup", "save the supervisor's container state to a file", "", &BackupCommand{})
+	ih.AddCommand("restore", "restore the supervisor's container state from a file", "", &RestoreCommand{})
+	ih.AddCommand("export-state", "dump the saved containers as json", "", &ExportStateCommand{})
+	ih.AddCommand("import-state", "replace saved container records with edited json", "", &ImportStateCommand{})
+	ih.AddCommand("list-state-snapshots", "list the saved versions of the container state", "",
+		&ListStateSnapshotsCommand{})
+	ih.AddCommand("rollback-state", "roll the container state back to a snapshot", "", &RollbackStateCommand{})
 	return ih
 }
 
@@ -86,6 +142,65 @@ func overlayConfig() {
 	if opts.Port != 0 {
 		config.Port = opts.Port
 	}
+	if opts.Output != "" {
+		config.Output = opts.Output
+	}
+	if opts.TokenFile != "" {
+		config.TokenFile = opts.TokenFile
+	}
+	if config.TokenFile != "" {
+		if data, err := ioutil.ReadFile(config.TokenFile); err != nil {
+			log.Println(err)
+		} else {
+			token = strings.TrimSpace(string(data))
+		}
+	}
+	for _, format := range ContainerFormats {
+		if config.Output == format {
+			return
+		}
+	}
+	log.Printf("unknown output %q, printing containers as %s", config.Output, ContainerFormatLong)
+	config.Output = ContainerFormatLong
+}
+
+// Print the containers in the configured output format, with a header above them if they are printed as a table
+func printContainers(conts ...*Container) {
+	if config.Output != ContainerFormatTable {
+		for _, cont := range conts {
+			log.Println("-> " + cont.Format(config.Output))
+		}
+		return
+	}
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, ContainerTableHeader)
+	for _, cont := range conts {
+		fmt.Fprintln(w, cont.Format(ContainerFormatTable))
+	}
+	w.Flush()
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		log.Println("-> " + line)
+	}
+}
+
+// Print the number of containers of each app and sha
+func printInstances(instances map[string]map[string]uint) {
+	apps := make([]string, 0, len(instances))
+	for app := range instances {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+	for _, app := range apps {
+		shas := make([]string, 0, len(instances[app]))
+		for sha := range instances[app] {
+			shas = append(shas, sha)
+		}
+		sort.Strings(shas)
+		for _, sha := range shas {
+			log.Printf("-> %s @ %s: %d", app, sha, instances[app][sha])
+		}
+	}
 }
 
 // ----------------------------------------------------------------------------------------------------------
@@ -103,7 +218,7 @@ func (c *HealthCommand) Execute(args []string) error {
 	}
 	arg := SupervisorHealthCheckArg{}
 	var reply SupervisorHealthCheckReply
-	err := rpcClient.CallWithTimeout("HealthCheck", arg, &reply, 5)
+	err := callWithTimeout("HealthCheck", &arg, &reply, 5)
 	if err != nil {
 		return err
 	}
@@ -118,40 +233,141 @@ func (c *HealthCommand) Execute(args []string) error {
 			reply.CPUShares.Free)
 		log.Printf("-> memory: %d MB total, %d MB used, %d MB free", reply.Memory.Total, reply.Memory.Used,
 			reply.Memory.Free)
+		if reply.RawCPUShares != nil && reply.CPUShares.Total != reply.RawCPUShares.Total {
+			log.Printf("-> raw cpu shares: %d total, %d free (%.2fx overcommit)", reply.RawCPUShares.Total,
+				reply.RawCPUShares.Free, reply.CPUOvercommit)
+		}
+		if reply.RawMemory != nil && reply.Memory.Total != reply.RawMemory.Total {
+			log.Printf("-> raw memory: %d MB total, %d MB free (%.2fx overcommit)", reply.RawMemory.Total,
+				reply.RawMemory.Free, reply.MemoryOvercommit)
+		}
+		if reply.GPUs != nil && reply.GPUs.Total > 0 {
+			log.Printf("-> gpus: %d total, %d used, %d free", reply.GPUs.Total, reply.GPUs.Used, reply.GPUs.Free)
+		}
+		if reply.CPUs != nil && reply.CPUs.Total > 0 {
+			log.Printf("-> dedicated cpus: %d total, %d used, %d free", reply.CPUs.Total, reply.CPUs.Used,
+				reply.CPUs.Free)
+		}
+		if reply.Disk != nil && reply.Disk.Total > 0 {
+			log.Printf("-> disk: %d MB total, %d MB used, %d MB free", reply.Disk.Total, reply.Disk.Used,
+				reply.Disk.Free)
+		}
+		if len(reply.Instances) > 0 {
+			log.Println("-> instances:")
+			printInstances(reply.Instances)
+		}
+		if reply.CgroupVersion != 0 {
+			log.Printf("-> cgroup: v%d", reply.CgroupVersion)
+		}
+		if reply.Runtime != "" {
+			log.Printf("-> runtime: %s", reply.Runtime)
+		}
+		if reply.RuntimeVersion != "" {
+			log.Printf("-> runtime version: %s (api %s)", reply.RuntimeVersion, reply.APIVersion)
+		}
+		log.Printf("-> apparmor: %t, selinux: %t", reply.AppArmor, reply.SELinux)
 		log.Printf("-> status: %s", reply.Status)
 	}
 	return nil
 }
 
+// Parse key=value label flags
+func parseLabels(flags []string) (map[string]string, error) {
+	if len(flags) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(flags))
+	for _, flag := range flags {
+		kv := strings.SplitN(flag, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("Labels should be key=value, got " + flag)
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return labels, nil
+}
+
 type ListCommand struct {
+	Labels []string `short:"l" long:"label" description:"only list containers with this key=value label"`
 }
 
 func (c *ListCommand) Execute(args []string) error {
 	overlayConfig()
 	log.Println("Supervisor List...")
-	arg := SupervisorListArg{}
+	labels, err := parseLabels(c.Labels)
+	if err != nil {
+		return err
+	}
+	arg := SupervisorListArg{Labels: labels}
 	var reply SupervisorListReply
-	err := rpcClient.Call("List", arg, &reply)
+	err = call("List", &arg, &reply)
 	if err != nil {
 		return err
 	}
 	log.Printf("-> UnusedPorts: %v", reply.UnusedPorts)
+	if pools := reply.PortPools; pools != nil {
+		log.Printf("-> PortPools: primary %s, ssh %s, secondary %s, excluded %v", pools.Primary, pools.SSH,
+			pools.Secondary, pools.Excluded)
+	}
 	log.Println("-> Containers:")
-	for _, cont := range reply.Containers {
-		log.Println("-> " + cont.String())
+	ids := make([]string, 0, len(reply.Containers))
+	for id := range reply.Containers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	conts := make([]*Container, len(ids))
+	for i, id := range ids {
+		conts[i] = reply.Containers[id]
+	}
+	printContainers(conts...)
+	if len(reply.Instances) > 0 {
+		log.Println("-> Instances:")
+		printInstances(reply.Instances)
 	}
 	return nil
 }
 
 type DeployCommand struct {
-	Host        string `short:"H" long:"host" description:"the host we're deploying on"`
-	App         string `short:"a" long:"app" description:"the app to deploy"`
-	Sha         string `short:"s" long:"sha" description:"the sha to deploy"`
-	Env         string `short:"e" long:"env" description:"the env to deploy"`
-	Container   string `short:"c" long:"container" description:"the container id to deploy"`
-	CPUShares   uint   `short:"C" long:"cpu-shares" description:"the number of cpu shares to use"`
-	MemoryLimit uint   `short:"m" long:"memory-limit" description:"the MBytes of memory to use"`
-	DepsFile    string `short:"d" long:"deps-file" description:"specify a file with dependencies"`
+	Host         string        `short:"H" long:"host" description:"the host we're deploying on"`
+	App          string        `short:"a" long:"app" description:"the app to deploy"`
+	Sha          string        `short:"s" long:"sha" description:"the sha to deploy"`
+	Env          string        `short:"e" long:"env" description:"the env to deploy"`
+	Container    string        `short:"c" long:"container" description:"the container id to deploy"`
+	CPUShares    uint          `short:"C" long:"cpu-shares" description:"the number of cpu shares to use"`
+	MemoryLimit  uint          `short:"m" long:"memory-limit" description:"the MBytes of memory to use"`
+	MemorySwap   uint          `long:"memory-swap" description:"the MBytes of memory+swap to use, unlimited swap if not set"`
+	DiskLimit    uint          `long:"disk-limit" description:"the MBytes of disk to use, no quota if not set"`
+	GPUs         uint          `short:"g" long:"gpus" description:"the number of GPUs to use"`
+	CPUs         uint          `long:"dedicated-cpus" description:"the number of cores to dedicate, instead of sharing cpu"`
+	DepsFile     string        `short:"d" long:"deps-file" description:"specify a file with dependencies"`
+	Labels       []string      `short:"l" long:"label" description:"a key=value label for the container"`
+	Registry     string        `long:"registry" description:"the registry host to pull from instead of the supervisor's"`
+	RegistryRepo string        `long:"registry-repo" description:"the repository in the registry to pull from"`
+	RegistryUser string        `long:"registry-user" description:"the registry user, the password is read from REGISTRY_PASSWORD"`
+	TTL          time.Duration `long:"ttl" description:"tear the container down after this long, e.g. 2h"`
+	Manifest     string        `long:"manifest-file" description:"a json manifest to deploy instead of the options'"`
+	Signature    string        `long:"signature-file" description:"the manifest file's detached signature"`
+	NetworkOf    string        `long:"network-of" description:"the id of a container whose network to join"`
+}
+
+// Read a json manifest file. It is sent signed, as is, if there is a signature file.
+func readManifestFile(file, signatureFile string) (*Manifest, *SignedManifest, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if signatureFile != "" {
+		signature, err := ioutil.ReadFile(signatureFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, &SignedManifest{JSON: data, Signature: signature}, nil
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, nil, err
+	}
+	return manifest, nil, nil
 }
 
 func (c *DeployCommand) Execute(args []string) error {
@@ -176,42 +392,72 @@ func (c *DeployCommand) Execute(args []string) error {
 			return err
 		}
 	}
+	labels, err := parseLabels(c.Labels)
+	if err != nil {
+		return err
+	}
 	log.Printf("Supervisor Deploy %s @ %s -> %s...", c.App, c.Sha, c.Container)
-	manifest := &Manifest{}
-	manifest.Deps = deps
-	manifest.CPUShares = c.CPUShares
-	manifest.MemoryLimit = c.MemoryLimit
-	log.Printf("-> Dependencies: %#v", manifest.Deps)
-	arg := SupervisorDeployArg{c.Host, c.App, c.Sha, c.Env, c.Container, manifest}
+	arg := SupervisorDeployArg{Host: c.Host, App: c.App, Sha: c.Sha, Env: c.Env, ContainerID: c.Container,
+		Labels: labels, TTL: uint(c.TTL / time.Second), NetworkOf: c.NetworkOf}
+	if c.Manifest != "" {
+		if arg.Manifest, arg.Signed, err = readManifestFile(c.Manifest, c.Signature); err != nil {
+			return err
+		}
+	} else {
+		manifest := &Manifest{}
+		manifest.Deps = deps
+		manifest.CPUShares = c.CPUShares
+		manifest.MemoryLimit = c.MemoryLimit
+		manifest.MemorySwap = c.MemorySwap
+		manifest.DiskLimit = c.DiskLimit
+		manifest.GPUs = c.GPUs
+		manifest.DedicatedCPUs = c.CPUs
+		log.Printf("-> Dependencies: %#v", manifest.Deps)
+		arg.Manifest = manifest
+	}
+	if c.Registry != "" || c.RegistryRepo != "" {
+		arg.Registry = &Registry{Host: c.Registry, Repo: c.RegistryRepo}
+	}
+	if c.RegistryUser != "" {
+		arg.RegistryAuth = &RegistryAuth{Username: c.RegistryUser, Password: os.Getenv("REGISTRY_PASSWORD")}
+	}
 	var reply SupervisorDeployReply
-	err := rpcClient.Call("Deploy", arg, &reply)
+	err = call("Deploy", &arg, &reply)
 	if err != nil {
 		return err
 	}
 	log.Printf("-> %v @ %v - STATUS: %v", c.App, c.Sha, reply.Status)
-	log.Println("-> " + reply.Container.String())
+	printContainers(reply.Container)
 	return nil
 }
 
 type TeardownCommand struct {
 	All        bool     `short:"a" long:"all" description:"tear down all the containers"`
 	Containers []string `short:"c" long:"containers" description:"the container to tear down"`
+	Labels     []string `short:"l" long:"label" description:"tear down the containers with this key=value label"`
 }
 
 func (c *TeardownCommand) Execute(args []string) error {
 	overlayConfig()
 	arg := SupervisorTeardownArg{}
+	labels, err := parseLabels(c.Labels)
+	if err != nil {
+		return err
+	}
 	if c.All {
 		log.Println("Supervisor Teardown all...")
 		arg.All = true
 	} else if c.Containers != nil && len(c.Containers) > 0 {
 		log.Printf("Supervisor Teardown %v...", c.Containers)
 		arg.ContainerIDs = c.Containers
+	} else if len(labels) > 0 {
+		log.Printf("Supervisor Teardown labels %v...", labels)
+		arg.Labels = labels
 	} else {
-		return errors.New("Please specify either all or a list of containers to teardown")
+		return errors.New("Please specify either all, a list of containers or labels to teardown")
 	}
 	var reply SupervisorTeardownReply
-	err := rpcClient.Call("Teardown", arg, &reply)
+	err = call("Teardown", &arg, &reply)
 	if err != nil {
 		return err
 	}
@@ -229,14 +475,115 @@ func (c *GetCommand) Execute(args []string) error {
 	if c.Container == "" {
 		return errors.New("Please specify a container to get")
 	}
-	arg := SupervisorGetArg{c.Container}
+	arg := SupervisorGetArg{ContainerID: c.Container}
 	var reply SupervisorGetReply
-	err := rpcClient.Call("Get", arg, &reply)
+	err := call("Get", &arg, &reply)
 	if err != nil {
 		return err
 	}
 	log.Printf("-> Get %s : %s", c.Container, reply.Status)
-	log.Printf("-> %s", reply.Container.String())
+	printContainers(reply.Container)
+	if reply.Usage != nil {
+		log.Printf("-> usage (cgroup v%d): cpu shares %d, cpu time %s, memory %d MB of %d MB",
+			reply.Usage.CgroupVersion, reply.Usage.CPUShares, reply.Usage.CPUTime,
+			reply.Usage.MemoryBytes/(1024*1024), reply.Usage.MemoryLimit/(1024*1024))
+	}
+	return nil
+}
+
+type ListEventsCommand struct {
+	Container string        `short:"c" long:"container" description:"only list the events of this container"`
+	Since     time.Duration `short:"s" long:"since" description:"only list events this long ago or newer, e.g. 1h"`
+}
+
+func (c *ListEventsCommand) Execute(args []string) error {
+	overlayConfig()
+	log.Println("Supervisor List Events...")
+	arg := SupervisorListEventsArg{ContainerID: c.Container}
+	if c.Since > 0 {
+		arg.Since = time.Now().Add(-c.Since)
+	}
+	var reply SupervisorListEventsReply
+	if err := call("ListEvents", &arg, &reply); err != nil {
+		return err
+	}
+	for _, event := range reply.Events {
+		log.Printf("-> %s", event)
+	}
+	return nil
+}
+
+type StatsHistoryCommand struct {
+	Container string        `short:"c" long:"container" description:"only show the usage of this container"`
+	Since     time.Duration `short:"s" long:"since" description:"only show samples this long ago or newer, e.g. 24h"`
+	Until     time.Duration `short:"u" long:"until" description:"only show samples at least this long ago"`
+}
+
+func (c *StatsHistoryCommand) Execute(args []string) error {
+	overlayConfig()
+	log.Println("Supervisor Stats History...")
+	arg := SupervisorStatsHistoryArg{ContainerID: c.Container}
+	if c.Since > 0 {
+		arg.Since = time.Now().Add(-c.Since)
+	}
+	if c.Until > 0 {
+		arg.Until = time.Now().Add(-c.Until)
+	}
+	var reply SupervisorStatsHistoryReply
+	if err := call("StatsHistory", &arg, &reply); err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(reply.Samples))
+	for id := range reply.Samples {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		log.Printf("-> %s:", id)
+		for _, sample := range reply.Samples[id] {
+			log.Printf("->   %s", sample)
+		}
+	}
+	return nil
+}
+
+type LogsCommand struct {
+	Container string        `short:"c" long:"container" description:"the container whose output to show"`
+	Stream    string        `long:"stream" description:"only show stdout or stderr"`
+	Lines     uint          `short:"n" long:"lines" description:"show this many of the last lines"`
+	Since     time.Duration `short:"s" long:"since" description:"only show lines this long ago or newer, e.g. 1h"`
+}
+
+func (c *LogsCommand) Execute(args []string) error {
+	overlayConfig()
+	log.Println("Supervisor Logs...")
+	arg := SupervisorLogsArg{ContainerID: c.Container, Stream: c.Stream, Lines: c.Lines}
+	if c.Since > 0 {
+		arg.Since = time.Now().Add(-c.Since)
+	}
+	var reply SupervisorLogsReply
+	if err := call("Logs", &arg, &reply); err != nil {
+		return err
+	}
+	for _, line := range reply.Lines {
+		log.Printf("-> %s", line)
+	}
+	return nil
+}
+
+type ConfigCommand struct {
+}
+
+func (c *ConfigCommand) Execute(args []string) error {
+	overlayConfig()
+	log.Println("Supervisor Config...")
+	arg := SupervisorConfigArg{}
+	var reply SupervisorConfigReply
+	if err := call("Config", &arg, &reply); err != nil {
+		return err
+	}
+	log.Printf("-> loaded at %s", reply.LoadedAt.Format(time.RFC3339))
+	fmt.Print(reply.Config)
 	return nil
 }
 
@@ -248,47 +595,73 @@ func (c *VersionCommand) Execute(args []string) error {
 	log.Println("Supervisor Version Check...")
 	arg := VersionArg{}
 	var reply VersionReply
+	var build SupervisorVersionReply
+	var buildErr error
 	defer func() {
 		if err := recover(); err != nil {
 			reply.RPCVersion = "unknown"
 		}
 		log.Printf("-> client rpc: %s", SupervisorRPCVersion)
 		log.Printf("-> server rpc: %s", reply.RPCVersion)
+		log.Printf("-> client build: %s", BuildInfo())
+		if buildErr != nil || build.Version == "" {
+			// supervisors from before api level 1 don't have the rpc
+			log.Printf("-> server build: unknown")
+		} else {
+			log.Printf("-> server build: %s (git %s, built %s, api level %d)", build.Version, build.GitSha,
+				build.BuildDate, build.APILevel)
+		}
 	}()
-	return rpcClient.Call("Version", arg, &reply)
+	if err := rpcClient.Call("Version", arg, &reply); err != nil {
+		return err
+	}
+	buildErr = call("SupervisorVersion", &SupervisorVersionArg{}, &build)
+	return nil
 }
 
 type AuthorizeSSHCommand struct {
 	Container string `short:"c" long:"container" description:"the container to authorize"`
 	User      string `short:"u" long:"user" description:"the user to authorize"`
 	PublicKey string `short:"k" long:"key" description:"the user's SSH public key"`
+	CertFile  string `long:"cert-file" description:"where to save the certificate, if the supervisor issues one"`
+	Requester string `short:"r" long:"requester" description:"who is asking, for the audit trail (default: you)"`
 }
 
 func (c *AuthorizeSSHCommand) Execute(args []string) error {
 	overlayConfig()
 	log.Println("Authorize SSH...")
-	arg := SupervisorAuthorizeSSHArg{c.Container, c.User, c.PublicKey}
+	arg := SupervisorAuthorizeSSHArg{ContainerID: c.Container, User: c.User, PublicKey: c.PublicKey,
+		Requester: requester(c.Requester)}
 	var reply SupervisorAuthorizeSSHReply
-	err := rpcClient.Call("AuthorizeSSH", arg, &reply)
+	err := call("AuthorizeSSH", &arg, &reply)
 	if err != nil {
 		return err
 	}
 	log.Printf("-> Authorize %s SSH for %s @ %s", reply.Status, c.User, c.Container)
 	log.Printf("-> %d", reply.Port)
+	if cert := reply.Certificate; cert != nil {
+		log.Printf("-> certificate %d valid until %s", cert.Serial, cert.ValidBefore.Local().Format(time.RFC3339))
+		if c.CertFile == "" {
+			fmt.Println(cert.Certificate)
+		} else if err := ioutil.WriteFile(c.CertFile, []byte(cert.Certificate+"\n"), 0644); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 type DeauthorizeSSHCommand struct {
 	Container string `short:"c" long:"container" description:"the container to deauthorize"`
 	User      string `short:"u" long:"user" description:"the user to deauthorize"`
+	Requester string `short:"r" long:"requester" description:"who is asking, for the audit trail (default: you)"`
 }
 
 func (c *DeauthorizeSSHCommand) Execute(args []string) error {
 	overlayConfig()
 	log.Println("Deauthorize SSH...")
-	arg := SupervisorDeauthorizeSSHArg{c.Container, c.User}
+	arg := SupervisorDeauthorizeSSHArg{ContainerID: c.Container, User: c.User, Requester: requester(c.Requester)}
 	var reply SupervisorDeauthorizeSSHReply
-	err := rpcClient.Call("DeauthorizeSSH", arg, &reply)
+	err := call("DeauthorizeSSH", &arg, &reply)
 	if err != nil {
 		return err
 	}
@@ -296,6 +669,62 @@ func (c *DeauthorizeSSHCommand) Execute(args []string) error {
 	return nil
 }
 
+// Returns who to record an ssh access change as: the given requester, or the user running the client
+func requester(given string) string {
+	if given != "" {
+		return given
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+type SSHAuditCommand struct {
+	Container string `short:"c" long:"container" description:"only this container"`
+	User      string `short:"u" long:"user" description:"only this user"`
+	Since     string `short:"s" long:"since" description:"start of the period, as 2006-01-02 or RFC3339"`
+	Until     string `long:"until" description:"end of the period, as 2006-01-02 or RFC3339 (default: now)"`
+}
+
+// Parse a date or a time, dates are midnight local time
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func (c *SSHAuditCommand) Execute(args []string) error {
+	overlayConfig()
+	log.Println("SSH Audit...")
+	arg := SupervisorSSHAuditArg{ContainerID: c.Container, User: c.User}
+	var err error
+	if arg.Since, err = parseAuditTime(c.Since); err != nil {
+		return err
+	}
+	if arg.Until, err = parseAuditTime(c.Until); err != nil {
+		return err
+	}
+	var reply SupervisorSSHAuditReply
+	if err := call("SSHAudit", &arg, &reply); err != nil {
+		return err
+	}
+	log.Printf("-> status: %s", reply.Status)
+	log.Println("-> access held:")
+	for _, grant := range reply.Grants {
+		log.Printf("->   %s", grant)
+	}
+	log.Println("-> changes:")
+	for _, record := range reply.Records {
+		log.Printf("->   %s", record)
+	}
+	return nil
+}
+
 type UpdateIPGroupCommand struct {
 	Name string   `short:"n" long:"name" description:"the name of the IP group"`
 	IPs  []string `short:"i" long:"ip" description:"the IP(s) in the group"`
@@ -306,7 +735,7 @@ func (c *UpdateIPGroupCommand) Execute(args []string) error {
 	log.Println("Update IP Group...")
 	arg := SupervisorUpdateIPGroupArg{Name: c.Name, IPs: c.IPs}
 	var reply SupervisorUpdateIPGroupReply
-	err := rpcClient.Call("UpdateIPGroup", arg, &reply)
+	err := call("UpdateIPGroup", &arg, &reply)
 	if err != nil {
 		return err
 	}
@@ -323,7 +752,7 @@ func (c *DeleteIPGroupCommand) Execute(args []string) error {
 	log.Println("Delete IP Group...")
 	arg := SupervisorDeleteIPGroupArg{Name: c.Name}
 	var reply SupervisorDeleteIPGroupReply
-	err := rpcClient.Call("DeleteIPGroup", arg, &reply)
+	err := call("DeleteIPGroup", &arg, &reply)
 	if err != nil {
 		return err
 	}
@@ -331,6 +760,208 @@ func (c *DeleteIPGroupCommand) Execute(args []string) error {
 	return nil
 }
 
+type NetworkRulesCommand struct {
+	Container string `short:"c" long:"container" description:"only list the rules of this container"`
+}
+
+func (c *NetworkRulesCommand) Execute(args []string) error {
+	overlayConfig()
+	log.Println("Network Rules...")
+	arg := SupervisorNetworkRulesArg{ContainerID: c.Container}
+	var reply SupervisorNetworkRulesReply
+	if err := call("NetworkRules", &arg, &reply); err != nil {
+		return err
+	}
+	log.Println("-> host:")
+	for _, rule := range reply.HostRules {
+		log.Printf("->   %s", rule)
+	}
+	ids := make([]string, 0, len(reply.ContainerRules))
+	for id := range reply.ContainerRules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		log.Printf("-> %s:", id)
+		for _, rule := range reply.ContainerRules[id] {
+			log.Printf("->   %s", rule)
+		}
+	}
+	return nil
+}
+
+type RedeployCommand struct {
+	Container    string   `short:"c" long:"container" description:"the container to replace"`
+	NewContainer string   `short:"n" long:"new-container" description:"the id of the new container"`
+	Sha          string   `short:"s" long:"sha" description:"the sha to deploy"`
+	Labels       []string `short:"l" long:"label" description:"a key=value label, the old container's if not set"`
+	ReadyTimeout uint     `long:"ready-timeout" description:"seconds to wait for the new container to be ready"`
+	DrainTime    uint     `long:"drain-time" description:"seconds to drain the old container before tearing it down"`
+	Manifest     string   `long:"manifest-file" description:"a json manifest to deploy, the old container's if not set"`
+	Signature    string   `long:"signature-file" description:"the manifest file's detached signature"`
+}
+
+func (c *RedeployCommand) Execute(args []string) error {
+	overlayConfig()
+	if c.Container == "" {
+		return errors.New("Please specify a container")
+	}
+	if c.Sha == "" {
+		return errors.New("Please specify a sha")
+	}
+	labels, err := parseLabels(c.Labels)
+	if err != nil {
+		return err
+	}
+	if c.NewContainer == "" {
+		c.NewContainer = fmt.Sprintf("%s-%s-%d", c.Container, c.Sha, time.Now().Unix())
+	}
+	log.Printf("Supervisor Redeploy %s -> %s @ %s...", c.Container, c.NewContainer, c.Sha)
+	arg := SupervisorRedeployArg{ContainerID: c.Container, NewContainerID: c.NewContainer, Sha: c.Sha,
+		Labels: labels, ReadyTimeout: c.ReadyTimeout, DrainTime: c.DrainTime}
+	if c.Manifest != "" {
+		if arg.Manifest, arg.Signed, err = readManifestFile(c.Manifest, c.Signature); err != nil {
+			return err
+		}
+	}
+	var reply SupervisorRedeployReply
+	if err := call("Redeploy", &arg, &reply); err != nil {
+		return err
+	}
+	log.Printf("-> status: %s", reply.Status)
+	if reply.Container != nil {
+		printContainers(reply.Container)
+	}
+	return nil
+}
+
+type DiffManifestCommand struct {
+	Container string `short:"c" long:"container" description:"the container to compare with"`
+	File      string `short:"f" long:"file" description:"the json file with the proposed manifest"`
+}
+
+func (c *DiffManifestCommand) Execute(args []string) error {
+	if c.Container == "" {
+		return errors.New("Please specify a container")
+	}
+	if c.File == "" {
+		return errors.New("Please specify a file.")
+	}
+	data, err := ioutil.ReadFile(c.File)
+	if err != nil {
+		return err
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return err
+	}
+	overlayConfig()
+	log.Printf("Supervisor Diff Manifest %s with %s...", c.Container, c.File)
+	var reply SupervisorDiffManifestReply
+	arg := SupervisorDiffManifestArg{ContainerID: c.Container, Manifest: manifest}
+	if err := call("DiffManifest", &arg, &reply); err != nil {
+		return err
+	}
+	log.Printf("-> status: %s", reply.Status)
+	if len(reply.Changes) == 0 {
+		log.Println("-> no changes")
+	}
+	for _, change := range reply.Changes {
+		log.Printf("-> %s", change)
+	}
+	return nil
+}
+
+type RollbackCommand struct {
+	Container    string `short:"c" long:"container" description:"the container to roll back"`
+	NewContainer string `short:"n" long:"new-container" description:"the id of the new container"`
+	ReadyTimeout uint   `long:"ready-timeout" description:"seconds to wait for the new container to be ready"`
+	DrainTime    uint   `long:"drain-time" description:"seconds to drain the container before tearing it down"`
+}
+
+func (c *RollbackCommand) Execute(args []string) error {
+	overlayConfig()
+	if c.Container == "" {
+		return errors.New("Please specify a container")
+	}
+	if c.NewContainer == "" {
+		c.NewContainer = fmt.Sprintf("%s-rollback-%d", c.Container, time.Now().Unix())
+	}
+	log.Printf("Supervisor Rollback %s -> %s...", c.Container, c.NewContainer)
+	arg := SupervisorRollbackArg{ContainerID: c.Container, NewContainerID: c.NewContainer,
+		ReadyTimeout: c.ReadyTimeout, DrainTime: c.DrainTime}
+	var reply SupervisorRollbackReply
+	if err := call("Rollback", &arg, &reply); err != nil {
+		return err
+	}
+	log.Printf("-> status: %s", reply.Status)
+	if reply.Container != nil {
+		printContainers(reply.Container)
+	}
+	return nil
+}
+
+type ResizeContainerCommand struct {
+	Container   string `short:"c" long:"container" description:"the container to resize"`
+	CPUShares   uint   `long:"cpu-shares" description:"the new cpu shares, unchanged if not set"`
+	MemoryLimit uint   `long:"memory-limit" description:"the new memory limit in MB, unchanged if not set"`
+}
+
+func (c *ResizeContainerCommand) Execute(args []string) error {
+	overlayConfig()
+	log.Println("Supervisor Resize Container...")
+	arg := SupervisorResizeContainerArg{ContainerID: c.Container, CPUShares: c.CPUShares, MemoryLimit: c.MemoryLimit}
+	var reply SupervisorResizeContainerReply
+	if err := call("ResizeContainer", &arg, &reply); err != nil {
+		return err
+	}
+	log.Printf("-> status: %s", reply.Status)
+	if reply.Container != nil {
+		log.Printf("-> cpu shares: %d, memory limit: %d MB", reply.Container.Manifest.CPUShares,
+			reply.Container.Manifest.MemoryLimit)
+	}
+	return nil
+}
+
+type CheckpointCommand struct {
+	Container string `short:"c" long:"container" description:"the container to checkpoint"`
+	Name      string `short:"n" long:"name" description:"the name of the checkpoint, named after the time if not set"`
+	Exit      bool   `long:"exit" description:"stop the container once it is checkpointed"`
+}
+
+func (c *CheckpointCommand) Execute(args []string) error {
+	overlayConfig()
+	log.Println("Supervisor Checkpoint...")
+	arg := SupervisorCheckpointArg{ContainerID: c.Container, Name: c.Name, Exit: c.Exit}
+	var reply SupervisorCheckpointReply
+	if err := call("Checkpoint", &arg, &reply); err != nil {
+		return err
+	}
+	log.Printf("-> status: %s", reply.Status)
+	log.Printf("-> checkpoint: %s", reply.Name)
+	return nil
+}
+
+type RestoreCheckpointCommand struct {
+	Container string `short:"c" long:"container" description:"the container to restore"`
+	Name      string `short:"n" long:"name" description:"the checkpoint to restore, the last one if not set"`
+}
+
+func (c *RestoreCheckpointCommand) Execute(args []string) error {
+	overlayConfig()
+	log.Println("Supervisor Restore Checkpoint...")
+	arg := SupervisorRestoreCheckpointArg{ContainerID: c.Container, Name: c.Name}
+	var reply SupervisorRestoreCheckpointReply
+	if err := call("RestoreCheckpoint", &arg, &reply); err != nil {
+		return err
+	}
+	log.Printf("-> status: %s", reply.Status)
+	if reply.Container != nil {
+		printContainers(reply.Container)
+	}
+	return nil
+}
+
 type ContainerMaintenanceCommand struct {
 	Container   string `short:"c" long:"container" description:"the container to set maintenance for"`
 	Maintenance bool   `short:"m" long:"maintenance" description:"if true, turn on maintenance mode"`
@@ -341,7 +972,7 @@ func (c *ContainerMaintenanceCommand) Execute(args []string) error {
 	log.Println("Container Maintenance...")
 	arg := SupervisorContainerMaintenanceArg{ContainerID: c.Container, Maintenance: c.Maintenance}
 	var reply SupervisorContainerMaintenanceReply
-	err := rpcClient.Call("ContainerMaintenance", arg, &reply)
+	err := call("ContainerMaintenance", &arg, &reply)
 	if err != nil {
 		return err
 	}
@@ -349,6 +980,38 @@ func (c *ContainerMaintenanceCommand) Execute(args []string) error {
 	return nil
 }
 
+type MaintenanceCommand struct {
+	Reason string `short:"r" long:"reason" description:"why the supervisor is going into maintenance"`
+}
+
+func (c *MaintenanceCommand) Execute(args []string) error {
+	overlayConfig()
+	if len(args) == 0 {
+		var reply SupervisorHealthCheckReply
+		if err := callWithTimeout("HealthCheck", &SupervisorHealthCheckArg{}, &reply, 5); err != nil {
+			return err
+		}
+		log.Printf("-> maintenance: %t", reply.Status == StatusMaintenance)
+		return nil
+	}
+	arg := SupervisorMaintenanceArg{Reason: c.Reason}
+	switch args[0] {
+	case "on":
+		arg.Maintenance = true
+	case "off":
+	default:
+		return errors.New("Please specify on or off")
+	}
+	log.Println("Supervisor Maintenance...")
+	var reply SupervisorMaintenanceReply
+	if err := call("Maintenance", &arg, &reply); err != nil {
+		return err
+	}
+	log.Printf("-> Maintenance %s to %t, the supervisor picks it up on its next check of %s", reply.Status,
+		arg.Maintenance, reply.File)
+	return nil
+}
+
 type IdleCommand struct {
 	Quiet bool `long:"quiet" description:"if true, quiet the output"`
 }
@@ -370,3 +1033,190 @@ func (c *IdleCommand) Execute(args []string) error {
 	}
 	return nil
 }
+
+type PrefetchImageCommand struct {
+	App          string `short:"a" long:"app" description:"the app to pull"`
+	Sha          string `short:"s" long:"sha" description:"the sha to pull"`
+	Registry     string `long:"registry" description:"the registry host to pull from instead of the supervisor's"`
+	RegistryRepo string `long:"registry-repo" description:"the repository in the registry to pull from"`
+	RegistryUser string `long:"registry-user" description:"the registry user, the password is read from REGISTRY_PASSWORD"`
+}
+
+func (c *PrefetchImageCommand) Execute(args []string) error {
+	overlayConfig()
+	log.Printf("Prefetch Image %s @ %s ...", c.App, c.Sha)
+	arg := SupervisorPrefetchImageArg{App: c.App, Sha: c.Sha}
+	if c.Registry != "" || c.RegistryRepo != "" {
+		arg.Registry = &Registry{Host: c.Registry, Repo: c.RegistryRepo}
+	}
+	if c.RegistryUser != "" {
+		arg.RegistryAuth = &RegistryAuth{Username: c.RegistryUser, Password: os.Getenv("REGISTRY_PASSWORD")}
+	}
+	var reply SupervisorPrefetchImageReply
+	err := call("PrefetchImage", &arg, &reply)
+	if err != nil {
+		return err
+	}
+	log.Printf("-> %s - STATUS: %s", reply.Image, reply.Status)
+	return nil
+}
+
+type ImageGCCommand struct {
+	Retention string `short:"r" long:"retention" description:"keep images pulled within this duration"`
+	DryRun    bool   `short:"n" long:"dry-run" description:"only list the images that would be removed"`
+}
+
+func (c *ImageGCCommand) Execute(args []string) error {
+	overlayConfig()
+	log.Println("Image GC ...")
+	arg := SupervisorImageGCArg{Retention: c.Retention, DryRun: c.DryRun}
+	var reply SupervisorImageGCReply
+	err := call("ImageGC", &arg, &reply)
+	if err != nil {
+		return err
+	}
+	log.Printf("-> status: %s", reply.Status)
+	for _, image := range reply.Removed {
+		log.Printf("-> removed %s", image)
+	}
+	return nil
+}
+
+type BackupCommand struct {
+	File string `short:"f" long:"file" description:"the file to save the backup to"`
+}
+
+func (c *BackupCommand) Execute(args []string) error {
+	if c.File == "" {
+		return errors.New("Please specify a file.")
+	}
+	overlayConfig()
+	log.Println("Backup ...")
+	var reply SupervisorBackupReply
+	err := call("Backup", &SupervisorBackupArg{}, &reply)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(c.File, reply.Backup, 0600); err != nil {
+		return err
+	}
+	log.Printf("-> status: %s", reply.Status)
+	log.Printf("-> saved to %s", c.File)
+	return nil
+}
+
+type RestoreCommand struct {
+	File string `short:"f" long:"file" description:"the backup file to restore from"`
+}
+
+func (c *RestoreCommand) Execute(args []string) error {
+	if c.File == "" {
+		return errors.New("Please specify a file.")
+	}
+	backup, err := ioutil.ReadFile(c.File)
+	if err != nil {
+		return err
+	}
+	overlayConfig()
+	log.Printf("Restore from %s ...", c.File)
+	var reply SupervisorRestoreReply
+	err = call("Restore", &SupervisorRestoreArg{Backup: backup}, &reply)
+	if err != nil {
+		return err
+	}
+	log.Printf("-> status: %s", reply.Status)
+	for _, id := range reply.ContainerIDs {
+		log.Printf("-> restored %s", id)
+	}
+	for id, msg := range reply.Errors {
+		log.Printf("-> could not bring %s back up: %s", id, msg)
+	}
+	return nil
+}
+
+type ExportStateCommand struct {
+	File string `short:"f" long:"file" description:"the file to write the json to instead of stdout"`
+}
+
+func (c *ExportStateCommand) Execute(args []string) error {
+	overlayConfig()
+	var reply SupervisorExportStateReply
+	err := call("ExportState", &SupervisorExportStateArg{}, &reply)
+	if err != nil {
+		return err
+	}
+	if c.File == "" {
+		fmt.Println(string(reply.State))
+		return nil
+	}
+	if err := ioutil.WriteFile(c.File, reply.State, 0600); err != nil {
+		return err
+	}
+	log.Printf("-> status: %s", reply.Status)
+	log.Printf("-> saved to %s", c.File)
+	return nil
+}
+
+type ImportStateCommand struct {
+	File string `short:"f" long:"file" description:"the json file with the records to replace"`
+}
+
+func (c *ImportStateCommand) Execute(args []string) error {
+	if c.File == "" {
+		return errors.New("Please specify a file.")
+	}
+	state, err := ioutil.ReadFile(c.File)
+	if err != nil {
+		return err
+	}
+	overlayConfig()
+	log.Printf("Import State from %s ...", c.File)
+	var reply SupervisorImportStateReply
+	err = call("ImportState", &SupervisorImportStateArg{State: state}, &reply)
+	if err != nil {
+		return err
+	}
+	log.Printf("-> status: %s", reply.Status)
+	for _, id := range reply.ContainerIDs {
+		log.Printf("-> replaced %s", id)
+	}
+	return nil
+}
+
+type ListStateSnapshotsCommand struct {
+}
+
+func (c *ListStateSnapshotsCommand) Execute(args []string) error {
+	overlayConfig()
+	log.Println("List State Snapshots ...")
+	var reply SupervisorListStateSnapshotsReply
+	err := call("ListStateSnapshots", &SupervisorListStateSnapshotsArg{}, &reply)
+	if err != nil {
+		return err
+	}
+	log.Printf("-> status: %s", reply.Status)
+	for _, snapshot := range reply.Snapshots {
+		log.Printf("-> %s (%s) %d containers", snapshot.ID, snapshot.Time.Local().Format(time.RFC3339),
+			snapshot.Containers)
+	}
+	return nil
+}
+
+type RollbackStateCommand struct {
+	SnapshotID string `short:"s" long:"snapshot" description:"the snapshot to roll back to"`
+}
+
+func (c *RollbackStateCommand) Execute(args []string) error {
+	overlayConfig()
+	log.Printf("Rollback State to %s ...", c.SnapshotID)
+	var reply SupervisorRollbackStateReply
+	err := call("RollbackState", &SupervisorRollbackStateArg{SnapshotID: c.SnapshotID}, &reply)
+	if err != nil {
+		return err
+	}
+	log.Printf("-> status: %s", reply.Status)
+	for _, id := range reply.ContainerIDs {
+		log.Printf("-> %s", id)
+	}
+	return nil
+}
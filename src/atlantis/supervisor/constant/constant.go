@@ -30,5 +30,7 @@ const (
 	DefaultResultDuration           = "30m"
 	DefaultMaintenanceFile          = "/etc/atlantis/supervisor/maint"
 	DefaultMaintenanceCheckInterval = "5s"
+	DefaultImageRetention           = "168h"
+	DefaultStateSnapshots           = 20
 	ContainerLogDir                 = "/var/log/atlantis"
 )
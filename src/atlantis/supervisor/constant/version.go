@@ -0,0 +1,36 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package constant
+
+import (
+	"fmt"
+)
+
+// Build information, set at link time by the Makefile:
+//
+//	go build -ldflags "-X atlantis/supervisor/constant.SupervisorVersion=1.2.3 ..."
+var (
+	SupervisorVersion   = "dev"
+	SupervisorGitSha    = "unknown"
+	SupervisorBuildDate = "unknown"
+)
+
+// Bumped whenever the supervisor gains an RPC or an RPC gains a field the manager may want to rely on, so the
+// manager can tell what a supervisor supports without parsing versions. Unlike SupervisorRPCVersion, a higher level
+// stays compatible with clients of a lower one.
+const SupervisorAPILevel = 2
+
+// Returns the build information as a single line for --version output
+func BuildInfo() string {
+	return fmt.Sprintf("%s (git %s, built %s, rpc %s, api level %d)", SupervisorVersion, SupervisorGitSha,
+		SupervisorBuildDate, SupervisorRPCVersion, SupervisorAPILevel)
+}
@@ -0,0 +1,274 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/docker"
+	"atlantis/supervisor/rpc/types"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+type RestoreReq struct {
+	backup   *types.StateBackup
+	respChan chan *RestoreResp
+}
+
+type RestoreResp struct {
+	containers []*Container // copies of the restored containers, to bring back up off the containerManager
+	err        error
+}
+
+type ReadoptReq struct {
+	cont     *Container // the copy brought back up
+	respChan chan error
+}
+
+// Export the containers, free ports and resource config of the supervisor
+func Backup() *types.StateBackup {
+	respChan := make(chan *types.StateBackup)
+	backupChan <- respChan
+	resp := <-respChan
+	close(respChan)
+	return resp
+}
+
+// Import a backup into a supervisor with no containers and bring the restored containers back up, redeploying
+// the ones docker no longer knows about. Returns the errors of the containers that could not be brought back up.
+func Restore(backup *types.StateBackup) (map[string]error, error) {
+	respChan := make(chan *RestoreResp)
+	req := &RestoreReq{backup, respChan}
+	restoreChan <- req
+	resp := <-respChan
+	close(respChan)
+	if resp.err != nil {
+		return nil, resp.err
+	}
+	errs := map[string]error{}
+	for _, cont := range resp.containers {
+		if err := cont.readopt(); err != nil {
+			log.Printf("[restore] ERROR: could not bring %s back up: %v", cont.ID, err)
+			errs[cont.ID] = err
+		}
+	}
+	return errs, nil
+}
+
+// Record a readopted container's docker state through the containerManager
+func recordReadopt(cont *Container) error {
+	respChan := make(chan error)
+	readoptChan <- &ReadoptReq{cont, respChan}
+	err := <-respChan
+	close(respChan)
+	return err
+}
+
+// Take over the docker ID, addresses and sidecars of the readopted copy
+func readopted(req *ReadoptReq) {
+	container := containers[req.cont.ID]
+	if container == nil {
+		req.respChan <- errors.New("Unknown Container.")
+		return
+	}
+	container.DockerID = req.cont.DockerID
+	container.Pid = req.cont.Pid
+	container.IP = req.cont.IP
+	container.IPv6 = req.cont.IPv6
+	container.Sidecars = req.cont.Sidecars
+	save(container.ID)
+	req.respChan <- nil
+}
+
+func backup(respChan chan *types.StateBackup) {
+	respChan <- currentState()
+}
+
+// Copy the containers, free ports and resource config into a backup
+func currentState() *types.StateBackup {
+	state := &types.StateBackup{
+		Version:           types.StateBackupVersion,
+		NumContainers:     NumContainers,
+		NumSecondaryPorts: NumSecondaryPorts,
+		MinPort:           MinPort,
+		CPUShares:         CPUShares,
+		MemoryLimit:       MemoryLimit,
+		NumGPUs:           NumGPUs,
+		PinnableCPUs:      PinnableCPUs,
+		SharesPerCPU:      sharesPerCPU,
+		Containers:        make(map[string]*types.Container, len(containers)),
+		FreePorts:         append([]uint16{}, ports...),
+	}
+	for id, container := range containers {
+		castedContainer := container.Container
+		state.Containers[id] = &castedContainer
+	}
+	return state
+}
+
+func restore(req *RestoreReq) {
+	if err := checkBackup(req.backup); err != nil {
+		req.respChan <- &RestoreResp{err: err}
+		return
+	}
+	ids := make([]string, 0, len(req.backup.Containers))
+	restored := make([]*Container, 0, len(req.backup.Containers))
+	for id, cont := range req.backup.Containers {
+		// backups taken by an older supervisor
+		if err := cont.Manifest.Migrate(); err != nil {
+			log.Printf("-> could not migrate manifest of %s: %v", id, err)
+		}
+		containers[id] = &Container{Container: *cont}
+		ids = append(ids, id)
+		restored = append(restored, &Container{Container: *cont.DeepCopy()})
+	}
+	sort.Strings(ids)
+	ports = append([]uint16{}, req.backup.FreePorts...)
+	countResources()
+	save(ids...)
+	log.Printf("[restore] restored %d containers: %v", len(ids), ids)
+	req.respChan <- &RestoreResp{containers: restored}
+}
+
+// A backup can only be restored into an empty supervisor
+func checkBackup(backup *types.StateBackup) error {
+	if len(containers) > 0 {
+		return errors.New("Backups can only be restored into a supervisor with no containers.")
+	}
+	return checkLayout(backup)
+}
+
+// A saved state has to have the same port layout as the supervisor, since the ports of the containers are derived
+// from it, and enough resources for all of its containers
+func checkLayout(backup *types.StateBackup) error {
+	if backup.Version < 1 || backup.Version > types.StateBackupVersion {
+		return fmt.Errorf("Unsupported backup version %d.", backup.Version)
+	}
+	if backup.NumContainers != NumContainers || backup.NumSecondaryPorts != NumSecondaryPorts ||
+		backup.MinPort != MinPort {
+		return fmt.Errorf("Port layout does not match. (backup: %d containers, %d secondary ports from %d; "+
+			"supervisor: %d containers, %d secondary ports from %d)", backup.NumContainers,
+			backup.NumSecondaryPorts, backup.MinPort, NumContainers, NumSecondaryPorts, MinPort)
+	}
+	return checkState(backup.Containers, backup.FreePorts)
+}
+
+// Check that the containers and free ports account for every port exactly once, that the ports of each container
+// match the port layout, and that the containers fit into the supervisor's CPU shares, memory and GPUs
+func checkState(conts map[string]*types.Container, freePorts []uint16) error {
+	usedPorts := map[uint16]string{}
+	for _, port := range freePorts {
+		if port >= NumContainers {
+			return fmt.Errorf("Free port slot %d is out of range.", port)
+		}
+		if _, dup := usedPorts[port]; dup {
+			return fmt.Errorf("Free port %d is listed twice.", primaryPool[port])
+		}
+		usedPorts[port] = ""
+	}
+	var cpu, memory, disk uint
+	usedGPUs := map[uint]string{}
+	usedCPUs := map[uint]string{}
+	pinnable := map[uint]bool{}
+	for _, core := range PinnableCPUs {
+		pinnable[core] = true
+	}
+	for id, cont := range conts {
+		if cont == nil || cont.ID != id || cont.Manifest == nil {
+			return fmt.Errorf("Container %s is incomplete.", id)
+		}
+		port, ok := portSlot(cont.PrimaryPort)
+		if !ok {
+			return fmt.Errorf("Port %d of %s is out of range.", cont.PrimaryPort, id)
+		}
+		if other, used := usedPorts[port]; used {
+			if other == "" {
+				return fmt.Errorf("Port %d of %s is also listed as free.", cont.PrimaryPort, id)
+			}
+			return fmt.Errorf("Port %d is used by both %s and %s.", cont.PrimaryPort, other, id)
+		}
+		usedPorts[port] = id
+		if !hasSlotPorts(cont) {
+			return fmt.Errorf("Ports of %s do not match its primary port %d.", id, cont.PrimaryPort)
+		}
+		for _, gpu := range cont.GPUs {
+			if gpu >= NumGPUs {
+				return fmt.Errorf("GPU %d of %s does not exist. (%d GPUs)", gpu, id, NumGPUs)
+			}
+			if other, used := usedGPUs[gpu]; used {
+				return fmt.Errorf("GPU %d is used by both %s and %s.", gpu, other, id)
+			}
+			usedGPUs[gpu] = id
+		}
+		for _, core := range cont.CPUSet {
+			if !pinnable[core] {
+				return fmt.Errorf("CPU %d of %s can not be dedicated. (pinnable: %v)", core, id, PinnableCPUs)
+			}
+			if other, used := usedCPUs[core]; used {
+				return fmt.Errorf("CPU %d is dedicated to both %s and %s.", core, other, id)
+			}
+			usedCPUs[core] = id
+		}
+		cpu += reservedCPUShares(cont.Manifest)
+		memory += cont.Manifest.MemoryLimit
+		disk += cont.Manifest.DiskLimit
+	}
+	if len(usedPorts) != int(NumContainers) {
+		return fmt.Errorf("Ports are missing. (%d of %d accounted for)", len(usedPorts), NumContainers)
+	}
+	if cpu > cpuCapacity {
+		return fmt.Errorf("Not enough CPU Shares. (%d needed, %d available)", cpu, cpuCapacity)
+	}
+	if memory > memoryCapacity {
+		return fmt.Errorf("Not enough Memory. (%d needed, %d available)", memory, memoryCapacity)
+	}
+	if diskLimit > 0 && disk > diskLimit {
+		return fmt.Errorf("Not enough Disk. (%d needed, %d available)", disk, diskLimit)
+	}
+	return nil
+}
+
+// Bring a copy of a restored container back under supervision. Containers docker no longer knows about, e.g. after
+// the host was reimaged, are deployed again from their saved config. The docker calls are made on the caller's
+// goroutine and the result is recorded through the containerManager.
+func (c *Container) readopt() error {
+	if running, _, err := docker.State(&c.Container); err != nil {
+		log.Printf("[restore] %s is gone, redeploying", c.ID)
+		// the credentials it was deployed with are not kept, only the supervisor's own can be used
+		if err := docker.Deploy(&c.Container, nil); err != nil {
+			return err
+		}
+		if err := deploySidecars(c); err != nil {
+			return err
+		}
+	} else if !running {
+		if err := restartContainer(c); err != nil {
+			return err
+		}
+	}
+	c.tuneNetwork()
+	c.addSecurity()
+	if err := recordReadopt(c); err != nil {
+		// torn down while it was brought back up
+		c.removeSecurity()
+		teardownSidecars(c.Sidecars)
+		docker.Teardown(&c.Container)
+		return err
+	}
+	startProbes(c)
+	startLogShipping(c)
+	startCapture(c)
+	startWatch(c)
+	startSidecarWatch(c)
+	return nil
+}
@@ -0,0 +1,84 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/docker"
+	"fmt"
+	"net"
+)
+
+// Host addresses or interface names to publish the containers' ports on, e.g. an internal NIC only, empty for every
+// address. SSHBindAddresses is for the SSH ports, which need the address of LocalSSHHost for the supervisor's own
+// ssh to reach them. Set before Init.
+var (
+	BindAddresses    []string
+	SSHBindAddresses []string
+)
+
+// Returns the addresses to publish ports on, taking interfaces for all their addresses. IPv6 addresses are left
+// out unless docker publishes on IPv6, and link-local ones always as docker can't bind them without a zone. An
+// address listed twice, or also through its interface, is returned once.
+func ResolveBindAddresses(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	addrs := []string{}
+	seen := map[string]bool{}
+	add := func(ip net.IP) {
+		if !seen[ip.String()] {
+			seen[ip.String()] = true
+			addrs = append(addrs, ip.String())
+		}
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			add(ip)
+			continue
+		}
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s is neither an IP nor an interface", name)
+		}
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, addr := range ifaceAddrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLinkLocalUnicast() || (ipNet.IP.To4() == nil && !docker.EnableIPv6) {
+				continue
+			}
+			add(ipNet.IP)
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("interface %s has no address to bind", name)
+		}
+	}
+	return addrs, nil
+}
+
+// Record the host addresses the container's ports are published on. Interfaces are resolved at every deploy, so
+// a container picks up the addresses an interface has then.
+func (c *Container) resolveBindAddresses() (err error) {
+	if c.BindAddrs, err = ResolveBindAddresses(BindAddresses); err != nil {
+		return err
+	}
+	sshAddrs := SSHBindAddresses
+	if len(sshAddrs) == 0 {
+		sshAddrs = BindAddresses
+	}
+	c.SSHAddrs, err = ResolveBindAddresses(sshAddrs)
+	return err
+}
@@ -0,0 +1,156 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/logship"
+	"atlantis/supervisor/rpc/types"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCaptureMaxSize   = 10 // MB
+	DefaultCaptureMaxFiles  = 5
+	DefaultCaptureRetention = 7 * 24 * time.Hour
+	capturePruneInterval    = time.Hour
+)
+
+// Where the supervisor writes what containers print to stdout and stderr, a dir per container with a log per
+// stream, empty to capture nothing. A log is rotated once it reaches CaptureMaxSize MB, keeping CaptureMaxFiles
+// rotated ones, and the logs of a torn down container are kept for CaptureRetention. Set before Init.
+var (
+	CaptureDir       string
+	CaptureMaxSize   uint = DefaultCaptureMaxSize
+	CaptureMaxFiles       = DefaultCaptureMaxFiles
+	CaptureRetention      = DefaultCaptureRetention
+)
+
+var (
+	captureLock  = sync.Mutex{}
+	captureStops = map[string]chan bool{} // container id -> closed to stop capturing the container's output
+	captureOnce  sync.Once
+)
+
+func captureDir(id string) string {
+	return path.Join(CaptureDir, id)
+}
+
+func initCapture() error {
+	if CaptureDir == "" {
+		return nil
+	}
+	return os.MkdirAll(CaptureDir, 0755)
+}
+
+func startCapturePruning() {
+	if CaptureDir != "" {
+		captureOnce.Do(func() { go pruneCaptureLoop() })
+	}
+}
+
+// Start capturing the container's output, unless its logging driver is one docker can not read back
+func startCapture(c *Container) {
+	if pretending() || CaptureDir == "" || c.Manifest == nil {
+		return
+	}
+	if c.Manifest.Logging != nil && !types.ReadableLogDrivers[c.Manifest.Logging.Driver] {
+		return
+	}
+	captureLock.Lock()
+	defer captureLock.Unlock()
+	if _, capturing := captureStops[c.ID]; capturing {
+		return
+	}
+	stop := make(chan bool)
+	captureStops[c.ID] = stop
+	go capture(c.ID, stop)
+}
+
+func stopCapture(id string) {
+	captureLock.Lock()
+	defer captureLock.Unlock()
+	if stop, capturing := captureStops[id]; capturing {
+		close(stop)
+		delete(captureStops, id)
+	}
+}
+
+// Write the container's output to its capture dir until stop is closed, picking up after the last line written
+func capture(id string, stop chan bool) {
+	sink := &logship.FileSink{Dir: captureDir(id), MaxSize: int64(CaptureMaxSize) * 1024 * 1024,
+		MaxFiles: CaptureMaxFiles}
+	shipper := logship.NewShipper(sink, nil)
+	defer shipper.Close()
+	followLogs(id, logship.LastFileTime(captureDir(id)), shipper, func(string) bool { return true }, stop)
+}
+
+func pruneCaptureLoop() {
+	for {
+		conts, _ := List()
+		pruneCaptures(conts, time.Now())
+		time.Sleep(capturePruneInterval)
+	}
+}
+
+// Remove the captured output of containers that were torn down and wrote nothing for CaptureRetention
+func pruneCaptures(conts map[string]*types.Container, now time.Time) {
+	dirs, err := ioutil.ReadDir(CaptureDir)
+	if err != nil {
+		return
+	}
+	for _, dir := range dirs {
+		if _, exists := conts[dir.Name()]; exists || !dir.IsDir() {
+			continue
+		}
+		last := dir.ModTime()
+		files, _ := ioutil.ReadDir(captureDir(dir.Name()))
+		for _, file := range files {
+			if file.ModTime().After(last) {
+				last = file.ModTime()
+			}
+		}
+		if now.Sub(last) > CaptureRetention {
+			log.Printf("[capture] removing the output of %s, torn down", dir.Name())
+			os.RemoveAll(captureDir(dir.Name()))
+		}
+	}
+}
+
+// Returns an error if id can not name a file of its own, as the ids of containers always can
+func checkFileID(id string) error {
+	if id == "" || id == "." || id == ".." || path.Base(id) != id {
+		return fmt.Errorf("invalid container id %q", id)
+	}
+	return nil
+}
+
+// Returns the last lines lines the container with id wrote to stream, or to either if stream is empty, from since
+// on, oldest first. Torn down containers are found as long as their output is kept.
+func Logs(id, stream string, since time.Time, lines int) ([]*types.LogLine, error) {
+	if err := checkFileID(id); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(captureDir(id)); os.IsNotExist(err) {
+		return nil, fmt.Errorf("no output of %s was captured", id)
+	}
+	var streams []string
+	if stream != "" {
+		streams = []string{stream}
+	}
+	return logship.ReadFiles(captureDir(id), streams, since, lines)
+}
@@ -0,0 +1,103 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/docker"
+	"atlantis/supervisor/rpc/types"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Set before Init. Checkpoints need an experimental docker daemon and criu on the host.
+var EnableCheckpoints bool
+
+type CheckpointReq struct {
+	id       string
+	name     string
+	exit     bool // stop the container once it is checkpointed
+	restore  bool // restore the container from the checkpoint instead
+	respChan chan error
+}
+
+// Checkpoint a container through the containerManager. An empty name is named after the current time. A container
+// stopped by its checkpoint is not restarted by its restart policy until it is restored.
+func Checkpoint(id, name string, exit bool) (string, error) {
+	if name == "" {
+		name = fmt.Sprintf("checkpoint-%d", time.Now().Unix())
+	}
+	return name, checkpointRequest(&CheckpointReq{id: id, name: name, exit: exit})
+}
+
+// Start a container stopped by a checkpoint from it through the containerManager. An empty name is the container's
+// last checkpoint.
+func RestoreCheckpoint(id, name string) error {
+	return checkpointRequest(&CheckpointReq{id: id, name: name, restore: true})
+}
+
+func checkpointRequest(req *CheckpointReq) error {
+	if !EnableCheckpoints {
+		return errors.New("Checkpoints are not enabled.")
+	}
+	req.respChan = make(chan error)
+	checkpointChan <- req
+	err := <-req.respChan
+	close(req.respChan)
+	return err
+}
+
+func checkpoint(req *CheckpointReq) {
+	container := containers[req.id]
+	if container == nil {
+		req.respChan <- errors.New("Unknown Container.")
+		return
+	}
+	if req.restore {
+		req.respChan <- restoreContainer(container, req.name)
+		return
+	}
+	if err := docker.Checkpoint(&container.Container, req.name, req.exit); err != nil {
+		req.respChan <- err
+		return
+	}
+	container.Checkpoint = req.name
+	container.CheckpointedAt = time.Now()
+	if req.exit {
+		container.removeSecurity()
+		container.RunState = types.ContainerCheckpointed
+	}
+	emit(container.ID, types.EventCheckpointed, "checkpointed as %s (exit: %t)", req.name, req.exit)
+	save(container.ID)
+	req.respChan <- nil
+}
+
+func restoreContainer(container *Container, name string) error {
+	if name == "" {
+		name = container.Checkpoint
+	}
+	if name == "" {
+		return errors.New("The container has no checkpoint to restore.")
+	}
+	if container.RunState != types.ContainerCheckpointed {
+		return errors.New("Only containers stopped by a checkpoint can be restored.")
+	}
+	if err := docker.Restore(&container.Container, name); err != nil {
+		return err
+	}
+	container.RunState = types.ContainerRunning
+	container.tuneNetwork()
+	container.addSecurity()
+	emit(container.ID, types.EventRestored, "restored from %s", name)
+	save(container.ID)
+	return nil
+}
@@ -0,0 +1,89 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+)
+
+const DefaultCheckScriptsDir = "/check_mk_checks"
+
+var (
+	// set before Init. a host directory or the http(s) URL of a .tar.gz of check scripts copied into every new
+	// container, so checks can be updated without rebuilding app images. empty to leave the image's checks alone.
+	CheckScripts    = ""
+	CheckScriptsDir = DefaultCheckScriptsDir // where the monitor looks for check scripts in the containers
+)
+
+// Returns a tar stream of the check scripts in source and the tar flag to extract it with. close must be called
+// once the stream has been read.
+func checkScriptsArchive(source string) (archive io.Reader, compression string, close func() error, err error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		resp, err := http.Get(source)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, "", nil, fmt.Errorf("fetching %s: %s", source, resp.Status)
+		}
+		return resp.Body, "z", resp.Body.Close, nil
+	}
+	cmd := exec.Command("tar", "-C", source, "-cf", "-", ".")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, "", nil, err
+	}
+	// closing our end first stops tar if ssh gave up before reading everything
+	return stdout, "", func() error { stdout.Close(); return cmd.Wait() }, nil
+}
+
+// Copy the check scripts into the container's check dir over ssh, next to the ones that came with the image
+func syncCheckScripts(c types.GenericContainer) error {
+	settingsLock.RLock()
+	source, dir := CheckScripts, CheckScriptsDir
+	settingsLock.RUnlock()
+	if source == "" {
+		return nil
+	}
+	if pretending() {
+		log.Printf("[pretend] sync check scripts from %s to %s:%s", source, c.GetID(), dir)
+		return nil
+	}
+	archive, compression, closeArchive, err := checkScriptsArchive(source)
+	if err != nil {
+		return fmt.Errorf("could not read the check scripts from %s: %v", source, err)
+	}
+	// the archive's owners may not exist in the container, or be outside its user namespace
+	cmd := exec.Command("ssh", containerSSHArgs(c, fmt.Sprintf("mkdir -p %s && tar --no-same-owner -C %s -x%sf -",
+		dir, dir, compression))...)
+	cmd.Stdin = archive
+	output, err := cmd.CombinedOutput()
+	if closeErr := closeArchive(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not sync the check scripts to %s: %v %s", c.GetID(), err,
+			strings.TrimSpace(string(output)))
+	}
+	log.Printf("[deploy] synced check scripts from %s to %s:%s", source, c.GetID(), dir)
+	return nil
+}
@@ -12,8 +12,12 @@
 package containers
 
 import (
+	"atlantis/supervisor/apptype"
 	"atlantis/supervisor/docker"
 	"atlantis/supervisor/rpc/types"
+	"errors"
+	"fmt"
+	"log"
 )
 
 type Container struct {
@@ -21,22 +25,103 @@ type Container struct {
 }
 
 // Deploy the given app+sha with the dependencies defined in deps. This will spin up a new docker container.
-func (c *Container) Deploy(host, app, sha, env string) error {
+func (c *Container) Deploy(host, app, sha, env string, labels map[string]string, auth *types.RegistryAuth) error {
 	c.Host = host
 	c.App = app
 	c.Sha = sha
 	c.Env = env
-	err := docker.Deploy(&c.Container)
+	c.Labels = labels
+	if c.Manifest.HostNetwork() && EnableNetsec && len(c.getSecurityGroups()) > 0 {
+		return errors.New("Security groups can not be enforced for a container on the host network.")
+	}
+	apptype.Get(c.Manifest.AppType).Defaults(c.Manifest)
+	if err := c.resolveTemplates(); err != nil {
+		return err
+	}
+	if err := c.resolveBindAddresses(); err != nil {
+		return fmt.Errorf("Could not resolve bind addresses: %v", err)
+	}
+	err := docker.Deploy(&c.Container, auth)
 	if err != nil {
 		return err
 	}
+	if c.NetworkOf != "" {
+		if shared := Get(c.NetworkOf); shared != nil {
+			c.IP = shared.IP
+		}
+		forwardSharedPorts(c)
+	}
+	if err := docker.SetMTU(&c.Container); err != nil {
+		return err
+	}
+	if err := deploySidecars(c); err != nil {
+		return err
+	}
 	// by this time Pid should be filled in
-	NetworkSecurity.AddContainerSecurity(c.ID, c.Pid, c.getSecurityGroups()) // add network security
-	save()                                                                   // save here because this is when we know the deployed container is actually alive
-	inventory()                                                              // now that the container is up and we've saved it, inventory check_mk
+	if err := c.addSecurity(); err != nil && c.Manifest.Egress != nil {
+		// the container must not run with an egress policy that is not enforced
+		return err
+	}
+	if keys, err := scanHostKeys(c); err != nil {
+		// the monitor won't be able to check the container, but it is up
+		log.Printf("[deploy] %v", err)
+	} else {
+		c.HostKeys = keys
+	}
+	if err := syncCheckScripts(c); err != nil {
+		// the monitor still runs the checks the image came with
+		log.Printf("[deploy] %v", err)
+	}
+	if err := trustSSHCA(c); err != nil {
+		// users can still be given access with raw keys once the ca is fixed
+		log.Printf("[deploy] could not make %s trust the ssh ca: %v", c.ID, err)
+	}
+	c.RunState = types.ContainerRunning
+	save(c.ID)  // save here because this is when we know the deployed container is actually alive
+	inventory() // now that the container is up and we've saved it, inventory check_mk
+	registerDNS(c)
+	announce(c)
+	addToProxy(c)
+	startProbes(c)
+	startLogShipping(c)
+	startCapture(c)
+	startWatch(c)
+	startSidecarWatch(c)
 	return nil
 }
 
+// Restart the docker container in place, refreshing its IP and Pid
+func restartContainer(c *Container) error {
+	return docker.Restart(&c.Container)
+}
+
+// Docker recreates the container's network namespace when it restarts, which resets the MTU
+func (c *Container) tuneNetwork() {
+	if err := docker.SetMTU(&c.Container); err != nil {
+		log.Printf("[network] %s: %v", c.ID, err)
+	}
+}
+
+// Containers on the host network have no network namespace of their own to secure
+func (c *Container) addSecurity() error {
+	if c.Manifest.HostNetwork() {
+		return nil
+	}
+	egress, err := egressDestinations(c.Manifest)
+	if err != nil {
+		return err
+	}
+	restrict := c.Manifest.Egress != nil && c.Manifest.Egress.DefaultDeny
+	return NetworkSecurity.AddContainerSecurity(c.ID, c.Pid, c.getSecurityGroups(), egress, restrict)
+}
+
+func (c *Container) removeSecurity() {
+	if c.Manifest.HostNetwork() {
+		return
+	}
+	NetworkSecurity.RemoveContainerSecurity(c.ID)
+}
+
 func (c *Container) getSecurityGroups() map[string][]uint16 {
 	sgsMap := map[string]map[uint16]bool{}
 	for _, appDep := range c.Manifest.Deps {
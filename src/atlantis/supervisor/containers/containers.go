@@ -19,18 +19,26 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
 const (
-	ContainersFile      = "containers"
-	PortsFile           = "ports"
+	ContainersFile      = "containers" // saved by older supervisors, imported into the state store
+	PortsFile           = "ports"      // saved by older supervisors, imported into the state store
 	NetworkSecurityFile = "netsec"
 )
 
 type ReserveReq struct {
 	id       string
+	app      string // empty if the container is not held to the manifest's instance cap
+	sha      string
+	replaces string // a container id that does not count toward the instance cap
 	manifest *types.Manifest
 	respChan chan *ReserveResp
 }
@@ -57,8 +65,13 @@ type ListResp struct {
 
 type NumsResp struct {
 	Containers *types.ResourceStats
-	CPUShares  *types.ResourceStats
-	Memory     *types.ResourceStats
+	CPUShares  *types.ResourceStats // overcommit-adjusted
+	Memory     *types.ResourceStats // overcommit-adjusted
+	RawCPU     *types.ResourceStats
+	RawMemory  *types.ResourceStats
+	GPUs       *types.ResourceStats
+	CPUs       *types.ResourceStats
+	Disk       *types.ResourceStats
 }
 
 var (
@@ -67,18 +80,49 @@ var (
 	NumContainers     uint16 // for maximum efficiency, should = CPUShares
 	NumSecondaryPorts uint16
 	MinPort           uint16
-	CPUShares         uint // relative
-	MemoryLimit       uint // actual MB
+	CPUShares         uint   // relative
+	MemoryLimit       uint   // actual MB
+	NumGPUs           uint   // set before Init. GPUs are numbered 0 to NumGPUs-1
+	PinnableCPUs      []uint // set before Init. cores that can be dedicated to containers, the rest are shared
+	SharesPerCPU      uint   // set before Init. CPU shares a dedicated core costs, 0 to spread CPUShares over the cores
+	DiskLimit         uint   // set before Init. MB of disk for container quotas, 0 to use the size of DiskRoot
+	DiskRoot          = "/var/lib/docker"
+	CPUOvercommit     float64 // set before Init. containers can reserve CPUShares times this, 0 for 1
+	MemoryOvercommit  float64 // set before Init. containers can reserve MemoryLimit times this, 0 for 1
+	StaticIPNetwork   string  // set before Init. docker network whose containers get an IP from StaticIPRange
+	StaticIPRange     string  // set before Init. IPv4 CIDR the supervisor allocates StaticIPNetwork's IPs from
+	RestrictEgress    bool    // set before Init. reject outbound traffic security groups do not allow, needs netsec
 	reserveChan       chan *ReserveReq
 	teardownChan      chan *TeardownReq
 	getChan           chan *GetReq
 	listChan          chan chan *ListResp
 	numsChan          chan chan *NumsResp
+	probeChan         chan *ProbeUpdateReq
+	restartChan       chan *RestartReq
+	restartDoneChan   chan *RestartDoneReq
+	backupChan        chan chan *types.StateBackup
+	restoreChan       chan *RestoreReq
+	readoptChan       chan *ReadoptReq
+	importChan        chan *ImportReq
+	rollbackChan      chan *RollbackReq
+	dockerEventChan   chan *DockerEventReq
+	resizeChan        chan *ResizeReq
+	checkpointChan    chan *CheckpointReq
+	reloadChan        chan *ReloadReq
+	runStateChan      chan *RunStateReq
 	dieChan           chan bool
 	containers        map[string]*Container // not for direct access. must go through containerManager.
 	ports             []uint16              // not for direct access. must go through containerManager.
 	usedMemoryLimit   uint                  // not for direct access. must go through containerManager.
 	usedCPUShares     uint                  // not for direct access. must go through containerManager.
+	gpus              []uint                // not for direct access. must go through containerManager.
+	cpus              []uint                // not for direct access. must go through containerManager.
+	sharesPerCPU      uint                  // SharesPerCPU or its default
+	cpuCapacity       uint                  // CPU shares containers can reserve, CPUShares with overcommit
+	memoryCapacity    uint                  // MB of memory containers can reserve, MemoryLimit with overcommit
+	diskLimit         uint                  // DiskLimit or its default. 0 if unknown, in which case it is not checked.
+	staticIPRange     *net.IPNet            // StaticIPRange parsed, nil if containers get docker's addresses
+	usedDiskLimit     uint                  // not for direct access. must go through containerManager.
 )
 
 // Initialize everything needed to use containers
@@ -86,16 +130,65 @@ func Init(registry, saveDir string, numContainers, numSecondaryPorts, minPort ui
 	if err := serialize.Init(saveDir); err != nil {
 		return err
 	}
-	
+
 	NumContainers = numContainers
 	NumSecondaryPorts = numSecondaryPorts
 	MinPort = minPort
 	CPUShares = cpu
 	MemoryLimit = memory
 	EnableNetsec = enableNetsec
-	
-	if uint64(MinPort)+(uint64(NumSecondaryPorts)+2)*uint64(NumContainers)-1 > 65535 {
-		return errors.New("Invalid Config. MinPort+(NumSecondaryPorts+2)*NumContainers-1 > 65535")
+
+	if err := initPortPools(); err != nil {
+		return err
+	}
+	if RestrictEgress && !EnableNetsec {
+		return errors.New("Invalid Config. Restricting egress needs network security enabled")
+	}
+	if docker.Rootless {
+		if EnableNetsec {
+			return errors.New("Invalid Config. Network security needs root for iptables, it can not be used rootless")
+		}
+		if lowest := lowestPort(); lowest < UnprivilegedPortStart {
+			return fmt.Errorf("Invalid Config. A rootless runtime can not publish port %d, ports start at %d", lowest,
+				UnprivilegedPortStart)
+		}
+	}
+	if CPUOvercommit < 0 || MemoryOvercommit < 0 {
+		return errors.New("Invalid Config. Overcommit ratios can not be negative")
+	}
+	if DefaultRestartPolicy != nil {
+		if err := DefaultRestartPolicy.Validate(); err != nil {
+			return errors.New("Invalid Config. Default " + err.Error())
+		}
+	}
+	cpuCapacity = overcommitted(CPUShares, CPUOvercommit)
+	memoryCapacity = overcommitted(MemoryLimit, MemoryOvercommit)
+	sharesPerCPU = SharesPerCPU
+	if sharesPerCPU == 0 {
+		sharesPerCPU = CPUShares / uint(runtime.NumCPU())
+		if sharesPerCPU == 0 {
+			sharesPerCPU = 1
+		}
+	}
+	for _, cpu := range PinnableCPUs {
+		if cpu >= uint(runtime.NumCPU()) {
+			log.Printf("WARNING: pinnable cpu %d does not exist, containers it is dedicated to will not start", cpu)
+		}
+	}
+	docker.SharedCPUSet = sharedCPUSet()
+	if err := initStaticIPs(); err != nil {
+		return err
+	}
+	if err := initCapture(); err != nil {
+		return err
+	}
+	diskLimit = DiskLimit
+	if diskLimit == 0 {
+		size, err := diskSize(DiskRoot)
+		if err != nil {
+			log.Printf("WARNING: could not get the size of %s, disk quotas will not be checked: %v", DiskRoot, err)
+		}
+		diskLimit = size
 	}
 	if uint(NumContainers) != CPUShares {
 		// don't error out because technically this is ok
@@ -106,18 +199,66 @@ func Init(registry, saveDir string, numContainers, numSecondaryPorts, minPort ui
 	getChan = make(chan *GetReq)
 	listChan = make(chan chan *ListResp)
 	numsChan = make(chan chan *NumsResp)
+	probeChan = make(chan *ProbeUpdateReq)
+	restartChan = make(chan *RestartReq)
+	restartDoneChan = make(chan *RestartDoneReq)
+	restarting = map[string]bool{}
+	backupChan = make(chan chan *types.StateBackup)
+	restoreChan = make(chan *RestoreReq)
+	readoptChan = make(chan *ReadoptReq)
+	importChan = make(chan *ImportReq)
+	rollbackChan = make(chan *RollbackReq)
+	dockerEventChan = make(chan *DockerEventReq)
+	resizeChan = make(chan *ResizeReq)
+	checkpointChan = make(chan *CheckpointReq)
+	reloadChan = make(chan *ReloadReq)
+	runStateChan = make(chan *RunStateReq)
 	dieChan = make(chan bool)
 	if err := docker.Init(registry); err != nil {
 		return err
 	}
+	if err := loadState(); err != nil {
+		return err
+	}
+	if err := loadSSHCerts(); err != nil {
+		return err
+	}
+	if err := loadSSHAudit(); err != nil {
+		return err
+	}
+	checkSlotPorts()
+	// count now rather than leave it to the manager, so nothing reserves from the previous Init's free GPUs and
+	// cores before it starts
+	countResources()
+	announceAll()
+	initProxies()
 	go containerManager()
+	go docker.WatchEvents(DockerEvent)
+	expireOnce.Do(func() { go expireLoop() })
+	startStats()
+	startCapturePruning()
 	return nil
 }
 
+// Returns the amount of a resource containers can reserve given its physical amount and overcommit ratio
+func overcommitted(amount uint, ratio float64) uint {
+	if ratio == 0 {
+		return amount
+	}
+	return uint(float64(amount) * ratio)
+}
+
 // Reserve a container
 func Reserve(id string, manifest *types.Manifest) (*Container, error) {
+	return ReserveInstance(id, "", "", manifest, "")
+}
+
+// Reserve a container for an instance of app @ sha. The reservation fails if the host already has the number
+// of instances of app @ sha the manifest allows (any number if Instances is 0), not counting replaces, the
+// container the new one is going to replace if there is one.
+func ReserveInstance(id, app, sha string, manifest *types.Manifest, replaces string) (*Container, error) {
 	respChan := make(chan *ReserveResp)
-	req := &ReserveReq{id, manifest, respChan}
+	req := &ReserveReq{id, app, sha, replaces, manifest, respChan}
 	reserveChan <- req
 	resp := <-respChan
 	close(respChan)
@@ -161,58 +302,181 @@ func Nums() (containers *types.ResourceStats, cpu *types.ResourceStats, memory *
 	return resp.Containers, resp.CPUShares, resp.Memory
 }
 
+// Return the total, used and free CPU shares and MB of memory without overcommit. Free is 0 if more than the
+// physical amount is reserved.
+func RawNums() (cpu *types.ResourceStats, memory *types.ResourceStats) {
+	respChan := make(chan *NumsResp)
+	numsChan <- respChan
+	resp := <-respChan
+	close(respChan)
+	return resp.RawCPU, resp.RawMemory
+}
+
+// Return the number of total, used, and free GPUs
+func GPUNums() *types.ResourceStats {
+	respChan := make(chan *NumsResp)
+	numsChan <- respChan
+	resp := <-respChan
+	close(respChan)
+	return resp.GPUs
+}
+
+// Return the number of total, used, and free cores that can be dedicated to containers
+func CPUNums() *types.ResourceStats {
+	respChan := make(chan *NumsResp)
+	numsChan <- respChan
+	resp := <-respChan
+	close(respChan)
+	return resp.CPUs
+}
+
+// Return the MB of disk total, reserved by container quotas, and free
+func DiskNums() *types.ResourceStats {
+	respChan := make(chan *NumsResp)
+	numsChan <- respChan
+	resp := <-respChan
+	close(respChan)
+	return resp.Disk
+}
+
 func reserve(req *ReserveReq) {
 	resp := &ReserveResp{}
 	if len(containers) >= int(NumContainers) { // check if there are enough containers
 		resp.err = errors.New("No free containers to reserve.")
 	} else if containers[req.id] != nil {
 		resp.err = errors.New("The ID (" + req.id + ") is in use.")
-	} else if req.manifest.CPUShares+usedCPUShares > CPUShares { // check cpu
+	} else if reservedCPUShares(req.manifest)+usedCPUShares > cpuCapacity { // check cpu
 		resp.err = errors.New(fmt.Sprintf("Not enough CPU Shares to reserve. (%d requested, %d available)",
-			req.manifest.CPUShares, CPUShares-usedCPUShares))
-	} else if req.manifest.MemoryLimit+usedMemoryLimit > MemoryLimit { // check memory
+			reservedCPUShares(req.manifest), remaining(cpuCapacity, usedCPUShares)))
+	} else if req.manifest.MemoryLimit+usedMemoryLimit > memoryCapacity { // check memory
 		resp.err = errors.New(fmt.Sprintf("Not enough Memory to reserve. (%d requested, %d available)",
-			req.manifest.MemoryLimit, MemoryLimit-usedMemoryLimit))
+			req.manifest.MemoryLimit, remaining(memoryCapacity, usedMemoryLimit)))
+	} else if diskLimit > 0 && req.manifest.DiskLimit+usedDiskLimit > diskLimit { // check disk
+		resp.err = errors.New(fmt.Sprintf("Not enough Disk to reserve. (%d requested, %d available)",
+			req.manifest.DiskLimit, remaining(diskLimit, usedDiskLimit)))
+	} else if req.manifest.GPUs > uint(len(gpus)) { // check gpus
+		resp.err = errors.New(fmt.Sprintf("Not enough GPUs to reserve. (%d requested, %d available)",
+			req.manifest.GPUs, len(gpus)))
+	} else if req.manifest.DedicatedCPUs > uint(len(cpus)) { // check dedicated cores
+		resp.err = errors.New(fmt.Sprintf("Not enough CPUs to dedicate. (%d requested, %d available)",
+			req.manifest.DedicatedCPUs, len(cpus)))
+	} else if numNamed := namedPortCount(req.manifest); numNamed > NumSecondaryPorts { // check named ports
+		resp.err = errors.New(fmt.Sprintf("Not enough secondary ports for named ports. (%d requested, %d available)",
+			numNamed, NumSecondaryPorts))
+	} else if req.manifest.TLS != nil && namedPortCount(req.manifest) >= NumSecondaryPorts { // check the tls port
+		resp.err = errors.New(fmt.Sprintf("No secondary port left to terminate TLS on. (all %d are named ports)",
+			NumSecondaryPorts))
+	} else if ip := freeStaticIP(req.manifest); ip == nil && needsStaticIP(req.manifest) { // check static ips
+		resp.err = errors.New(fmt.Sprintf("No free IPs to reserve on %s. (all of %s is in use)", StaticIPNetwork,
+			StaticIPRange))
+	} else if app := proxyPortApp(req.manifest.ProxyPort); req.app != "" && app != "" && app != req.app {
+		resp.err = errors.New(fmt.Sprintf("Proxy port %d is taken. (it is forwarded to %s)",
+			req.manifest.ProxyPort, app))
+	} else if n := instanceCount(req.app, req.sha, req.replaces); req.app != "" && req.manifest.Instances > 0 &&
+		n >= req.manifest.Instances { // check the instance cap
+		resp.err = errors.New(fmt.Sprintf("Too many instances of %s @ %s. (%d running, %d allowed per host)",
+			req.app, req.sha, n, req.manifest.Instances))
 	} else {
-		port := ports[0]
+		primaryPort, sshPort, secondaryPorts := slotPorts(ports[0])
 		ports = ports[1:]
-		secondaryPorts := make([]uint16, NumSecondaryPorts)
-		for i := uint16(0); i < NumSecondaryPorts; i++ {
-			secondaryPorts[i] = MinPort + (NumContainers * (i + 2)) + port
+		containers[req.id] = &Container{Container: types.Container{ID: req.id, PrimaryPort: primaryPort,
+			SSHPort: sshPort, SecondaryPorts: secondaryPorts, Manifest: req.manifest,
+			NamedPorts: assignNamedPorts(req.manifest, secondaryPorts), TLSPort: tlsPort(req.manifest, secondaryPorts),
+			App: req.app, Sha: req.sha}}
+		if ip != nil {
+			containers[req.id].IP = ip.String()
+		}
+		if req.manifest.GPUs > 0 {
+			containers[req.id].GPUs = append([]uint{}, gpus[:req.manifest.GPUs]...)
+			gpus = gpus[req.manifest.GPUs:]
+		}
+		if req.manifest.DedicatedCPUs > 0 {
+			containers[req.id].CPUSet = append([]uint{}, cpus[:req.manifest.DedicatedCPUs]...)
+			cpus = cpus[req.manifest.DedicatedCPUs:]
 		}
-		containers[req.id] = &Container{Container: types.Container{ID: req.id, PrimaryPort: MinPort + port,
-			SSHPort: MinPort + NumContainers + port, SecondaryPorts: secondaryPorts, Manifest: req.manifest}}
 		resp.container = containers[req.id]
 		usedMemoryLimit = usedMemoryLimit + req.manifest.MemoryLimit
-		usedCPUShares = usedCPUShares + req.manifest.CPUShares
+		usedDiskLimit = usedDiskLimit + req.manifest.DiskLimit
+		usedCPUShares = usedCPUShares + reservedCPUShares(req.manifest)
 	}
 	req.respChan <- resp
 	return
 }
 
+// Returns the number of containers of app @ sha, leaving out the one with id except
+func instanceCount(app, sha, except string) uint {
+	count := uint(0)
+	for id, cont := range containers {
+		if id != except && cont.App == app && cont.Sha == sha {
+			count++
+		}
+	}
+	return count
+}
+
+// Returns the number of containers of each app and sha, app -> sha -> containers. Containers that are reserved
+// but have no app yet are left out.
+func CountInstances(conts map[string]*types.Container) map[string]map[string]uint {
+	counts := map[string]map[string]uint{}
+	for _, cont := range conts {
+		if cont == nil || cont.App == "" {
+			continue
+		}
+		if counts[cont.App] == nil {
+			counts[cont.App] = map[string]uint{}
+		}
+		counts[cont.App][cont.Sha]++
+	}
+	return counts
+}
+
 func teardown(req *TeardownReq) {
-	container := containers[req.id]
+	req.respChan <- teardownContainer(req.id)
+}
+
+// Tear down the container, and first the containers that share its network namespace. Returns false if there is
+// no such container. Must be called from the containerManager.
+func teardownContainer(id string) bool {
+	container := containers[id]
 	if container != nil {
-		NetworkSecurity.RemoveContainerSecurity(req.id)
-		docker.Teardown(containers[req.id])
-		ports = append(ports, containers[req.id].PrimaryPort-MinPort)
-		usedMemoryLimit = usedMemoryLimit - containers[req.id].Manifest.MemoryLimit
-		usedCPUShares = usedCPUShares - containers[req.id].Manifest.CPUShares
-		delete(containers, req.id)
-		save()
+		for _, joiner := range joiners(id) {
+			teardownContainer(joiner)
+		}
+		stopProbes(id)
+		stopLogShipping(id)
+		stopCapture(id)
+		forgetShippedUntil(id)
+		stopWatch(id)
+		stopSidecarWatch(id)
+		removeFromProxy(container)
+		stopForwarding(container)
+		container.removeSecurity()
+		teardownSidecars(container.Sidecars)
+		docker.Teardown(containers[id])
+		if slot, ok := portSlot(containers[id].PrimaryPort); ok {
+			ports = append(ports, slot)
+		}
+		gpus = append(gpus, containers[id].GPUs...)
+		cpus = append(cpus, containers[id].CPUSet...)
+		usedMemoryLimit = usedMemoryLimit - containers[id].Manifest.MemoryLimit
+		usedDiskLimit = usedDiskLimit - containers[id].Manifest.DiskLimit
+		usedCPUShares = usedCPUShares - reservedCPUShares(containers[id].Manifest)
+		delete(containers, id)
+		save(id)
 		go func() {
-			// inventory() eventually calls back into the supervisor via cmk_admin -I
+			deregisterDNS(container)
+			withdraw(container)
+			// the inventory eventually calls back into the supervisor via cmk_admin -I
 			// Sleep to avoid this race condition.
 			// TODO(edanaher,2014-07-29): If we continue getting alerts about interfaces on torn-down containers,
 			// add additional sleep here to let tearing down complete before inventory.
 			<-time.After(100 * time.Millisecond)
-			uploadLog(req.id)
+			uploadLog(id)
 			inventory()
 		}()
-		req.respChan <- true
-	} else {
-		req.respChan <- false
+		return true
 	}
+	return false
 }
 
 func get(req *GetReq) {
@@ -220,8 +484,9 @@ func get(req *GetReq) {
 	if !present {
 		req.respChan <- nil
 	} else {
-		castedContainer := container.Container
-		req.respChan <- &castedContainer
+		castedContainer := container.Container.DeepCopy()
+		castedContainer.TTL = ttlLeft(castedContainer, time.Now())
+		req.respChan <- castedContainer
 	}
 }
 
@@ -229,12 +494,14 @@ func list(respChan chan *ListResp) {
 	// create copies
 	portsCopy := make([]uint16, len(ports))
 	for i, port := range ports {
-		portsCopy[i] = MinPort + port
+		portsCopy[i] = primaryPool[port]
 	}
 	containersCopy := make(map[string]*types.Container, len(containers))
+	now := time.Now()
 	for id, container := range containers {
-		castedContainer := container.Container
-		containersCopy[id] = &castedContainer
+		castedContainer := container.Container.DeepCopy()
+		castedContainer.TTL = ttlLeft(castedContainer, now)
+		containersCopy[id] = castedContainer
 	}
 	resp := &ListResp{containersCopy, portsCopy}
 	respChan <- resp
@@ -242,24 +509,95 @@ func list(respChan chan *ListResp) {
 
 func nums(respChan chan *NumsResp) {
 	resp := &NumsResp{&types.ResourceStats{uint(NumContainers), uint(len(containers)),
-		uint(NumContainers) - uint(len(containers))}, &types.ResourceStats{CPUShares, usedCPUShares,
-		CPUShares - usedCPUShares}, &types.ResourceStats{MemoryLimit, usedMemoryLimit,
-		MemoryLimit - usedMemoryLimit}}
+		uint(NumContainers) - uint(len(containers))}, &types.ResourceStats{cpuCapacity, usedCPUShares,
+		remaining(cpuCapacity, usedCPUShares)}, &types.ResourceStats{memoryCapacity, usedMemoryLimit,
+		remaining(memoryCapacity, usedMemoryLimit)}, &types.ResourceStats{CPUShares, usedCPUShares,
+		remaining(CPUShares, usedCPUShares)}, &types.ResourceStats{MemoryLimit, usedMemoryLimit,
+		remaining(MemoryLimit, usedMemoryLimit)},
+		&types.ResourceStats{NumGPUs, NumGPUs - uint(len(gpus)), uint(len(gpus))},
+		&types.ResourceStats{uint(len(PinnableCPUs)), uint(len(PinnableCPUs) - len(cpus)), uint(len(cpus))},
+		&types.ResourceStats{diskLimit, usedDiskLimit, remaining(diskLimit, usedDiskLimit)}}
 	respChan <- resp
 }
 
-func containerManager() {
-	if err := serialize.RetrieveObject(ContainersFile, &containers); err != nil || containers == nil {
-		containers = map[string]*Container{}
-		log.Printf("-> using default container map: %+v", containers)
-	}
-	if err := serialize.RetrieveObject(PortsFile, &ports); err != nil || ports == nil {
-		ports = make([]uint16, NumContainers)
-		for i := uint16(0); i < NumContainers; i++ {
-			ports[i] = i
+// Work out the used CPU shares, memory and disk and the free GPUs from the containers
+func countResources() {
+	usedCPUShares = 0
+	usedMemoryLimit = 0
+	usedDiskLimit = 0
+	usedGPUs := map[uint]bool{}
+	usedCPUs := map[uint]bool{}
+	for _, cont := range containers {
+		for _, gpu := range cont.GPUs {
+			usedGPUs[gpu] = true
+		}
+		for _, cpu := range cont.CPUSet {
+			usedCPUs[cpu] = true
+		}
+		usedCPUShares += reservedCPUShares(cont.Manifest)
+		usedMemoryLimit += cont.Manifest.MemoryLimit
+		usedDiskLimit += cont.Manifest.DiskLimit
+	}
+	gpus = []uint{}
+	for i := uint(0); i < NumGPUs; i++ {
+		if !usedGPUs[i] {
+			gpus = append(gpus, i)
 		}
-		log.Printf("-> using default port list: %+v", ports)
 	}
+	cpus = []uint{}
+	for _, cpu := range PinnableCPUs {
+		if !usedCPUs[cpu] {
+			cpus = append(cpus, cpu)
+		}
+	}
+}
+
+// Containers with dedicated cores pay for them in CPU shares instead of the shares in their manifest
+func reservedCPUShares(manifest *types.Manifest) uint {
+	if manifest.DedicatedCPUs > 0 {
+		return manifest.DedicatedCPUs * sharesPerCPU
+	}
+	return manifest.CPUShares
+}
+
+// Returns how much of total is not used. 0 if more than total is used, e.g. reserved under a bigger overcommit
+// ratio or before the disk shrank.
+func remaining(total, used uint) uint {
+	if used > total {
+		return 0
+	}
+	return total - used
+}
+
+// Returns the size of the filesystem path is on in MB
+func diskSize(path string) (uint, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, err
+	}
+	return uint(fs.Blocks * uint64(fs.Bsize) / (1024 * 1024)), nil
+}
+
+// Returns the cpuset for containers without dedicated cores, which is every core that can not be dedicated. Empty
+// if no cores can be dedicated.
+func sharedCPUSet() string {
+	if len(PinnableCPUs) == 0 {
+		return ""
+	}
+	pinnable := map[uint]bool{}
+	for _, cpu := range PinnableCPUs {
+		pinnable[cpu] = true
+	}
+	shared := []string{}
+	for cpu := uint(0); cpu < uint(runtime.NumCPU()); cpu++ {
+		if !pinnable[cpu] {
+			shared = append(shared, strconv.FormatUint(uint64(cpu), 10))
+		}
+	}
+	return strings.Join(shared, ",")
+}
+
+func containerManager() {
 	var ns netsec.NetworkSecurity
 	if err := serialize.RetrieveObject(NetworkSecurityFile, ns); err != nil {
 		// Enable is negated because it is "Pretend" on the inside, "Enable" on the outside.
@@ -268,17 +606,37 @@ func containerManager() {
 	} else {
 		NetworkSecurity = &ns
 	}
-	usedCPUShares = 0
-	usedMemoryLimit = 0
+	NetworkSecurity.RestrictEgress = RestrictEgress
 	for _, cont := range containers {
-		usedCPUShares += cont.Manifest.CPUShares
-		usedMemoryLimit += cont.Manifest.MemoryLimit
+		// containers saved by an older supervisor
+		if err := cont.Manifest.Migrate(); err != nil {
+			log.Printf("-> could not migrate manifest of %s: %v", cont.ID, err)
+		}
+		startProbes(cont)
+		startLogShipping(cont)
+		startCapture(cont)
+		startWatch(cont)
+		startSidecarWatch(cont)
 	}
+	countResources()
 	var reserveReq *ReserveReq
 	var teardownReq *TeardownReq
 	var getReq *GetReq
 	var listRespCh chan *ListResp
 	var numsRespCh chan *NumsResp
+	var probeReq *ProbeUpdateReq
+	var restartReq *RestartReq
+	var restartDoneReq *RestartDoneReq
+	var backupRespCh chan *types.StateBackup
+	var restoreReq *RestoreReq
+	var readoptReq *ReadoptReq
+	var importReq *ImportReq
+	var rollbackReq *RollbackReq
+	var dockerEventReq *DockerEventReq
+	var runStateReq *RunStateReq
+	var resizeReq *ResizeReq
+	var checkpointReq *CheckpointReq
+	var reloadReq *ReloadReq
 	for {
 		select {
 		case reserveReq = <-reserveChan:
@@ -291,6 +649,32 @@ func containerManager() {
 			get(getReq)
 		case numsRespCh = <-numsChan:
 			nums(numsRespCh)
+		case probeReq = <-probeChan:
+			probeUpdate(probeReq)
+		case restartReq = <-restartChan:
+			restartRequested(restartReq)
+		case restartDoneReq = <-restartDoneChan:
+			restartDone(restartDoneReq)
+		case backupRespCh = <-backupChan:
+			backup(backupRespCh)
+		case restoreReq = <-restoreChan:
+			restore(restoreReq)
+		case readoptReq = <-readoptChan:
+			readopted(readoptReq)
+		case importReq = <-importChan:
+			importState(importReq)
+		case rollbackReq = <-rollbackChan:
+			rollbackState(rollbackReq)
+		case dockerEventReq = <-dockerEventChan:
+			dockerEvent(dockerEventReq)
+		case runStateReq = <-runStateChan:
+			runStateChanged(runStateReq)
+		case resizeReq = <-resizeChan:
+			resize(resizeReq)
+		case checkpointReq = <-checkpointChan:
+			checkpoint(checkpointReq)
+		case reloadReq = <-reloadChan:
+			reload(reloadReq)
 		case <-dieChan:
 			close(reserveChan)
 			close(teardownChan)
@@ -302,27 +686,6 @@ func containerManager() {
 	}
 }
 
-func save() {
-	serialize.SaveAll(serialize.SaveDefinition{
-		ContainersFile,
-		containers,
-	}, serialize.SaveDefinition{
-		PortsFile,
-		ports,
-	})
-}
-
-func inventory() {
-	log.Println("[CMK Inventory] Start")
-	cmd := exec.Command("cmk_admin", "-I")
-	output, err := cmd.Output()
-	if err != nil {
-		log.Println("[CMK Inventory] ERROR: " + err.Error() + "\n" + string(output))
-	} else {
-		log.Println("[CMK Inventory] done:\n" + string(output))
-	}
-}
-
 func uploadLog(id string) {
 	log.Println("[Teardown Logsync] Start")
 	output, err := exec.Command("bash", "-c", "cd /opt/atlantis/logsync; ./run -suffix=.log -region=`my-region` -once").Output()
@@ -12,10 +12,32 @@
 package containers
 
 import (
+	"atlantis/supervisor/apptype"
+	"atlantis/supervisor/containers/serialize"
+	"atlantis/supervisor/crypto"
+	"atlantis/supervisor/discovery"
+	"atlantis/supervisor/dns"
+	"atlantis/supervisor/docker"
+	"atlantis/supervisor/logship"
+	"atlantis/supervisor/netsec"
 	"atlantis/supervisor/rpc/types"
+	"atlantis/supervisor/stats"
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"github.com/adjust/gocheck"
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestContainers(t *testing.T) { gocheck.TestingT(t) }
@@ -43,6 +65,39 @@ func (s *ContainersSuite) TestInit(c *gocheck.C) {
 	os.RemoveAll(saveDir)
 }
 
+func (s *ContainersSuite) TestStateStore(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	c.Assert(os.MkdirAll(saveDir, 0755), gocheck.IsNil)
+	// containers saved by an older supervisor are imported
+	c.Assert(ioutil.WriteFile(saveDir+"/"+ContainersFile, []byte(`{"old":{"ID":"old","PrimaryPort":61000,`+
+		`"Manifest":{"CPUShares":10,"MemoryLimit":10}}}`), 0644), gocheck.IsNil)
+	c.Assert(ioutil.WriteFile(saveDir+"/"+PortsFile, []byte(`[1]`), 0644), gocheck.IsNil)
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	conts, ports := List()
+	c.Assert(conts, gocheck.HasLen, 1)
+	c.Assert(conts["old"], gocheck.NotNil)
+	c.Assert(ports, gocheck.DeepEquals, []uint16{61001})
+	_, err := os.Stat(saveDir + "/" + ContainersFile)
+	c.Assert(os.IsNotExist(err), gocheck.Equals, true)
+	// saved containers and teardowns are written to the store
+	cont, err := Reserve("new", &types.Manifest{CPUShares: 10, MemoryLimit: 10})
+	c.Assert(err, gocheck.IsNil)
+	cont.App = "app"
+	save(cont.ID)
+	c.Assert(Teardown("old"), gocheck.Equals, true)
+	containers = nil
+	ports = nil
+	c.Assert(loadState(), gocheck.IsNil)
+	conts, ports = List()
+	c.Assert(conts, gocheck.HasLen, 1)
+	c.Assert(conts["new"], gocheck.NotNil)
+	c.Assert(conts["new"].App, gocheck.Equals, "app")
+	c.Assert(ports, gocheck.DeepEquals, []uint16{61000})
+	os.RemoveAll(saveDir)
+}
+
 func (s *ContainersSuite) TestReserve(c *gocheck.C) {
 	saveDir := "save_test"
 	os.RemoveAll(saveDir)
@@ -83,6 +138,68 @@ func (s *ContainersSuite) TestReserve(c *gocheck.C) {
 	dieChan <- true
 }
 
+func (s *ContainersSuite) TestInstanceCap(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	c.Assert(Init("localhost", saveDir, uint16(4), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	manifest := &types.Manifest{CPUShares: 1, MemoryLimit: 1, Instances: 2}
+	_, err := ReserveInstance("first", "app", "sha1", manifest, "")
+	c.Assert(err, gocheck.IsNil)
+	_, err = ReserveInstance("second", "app", "sha1", manifest, "")
+	c.Assert(err, gocheck.IsNil)
+	_, err = ReserveInstance("third", "app", "sha1", manifest, "")
+	c.Assert(err, gocheck.ErrorMatches, "Too many instances of app @ sha1\\. \\(2 running, 2 allowed per host\\)")
+	// a replacement does not count the container it replaces, and other shas have their own cap
+	_, err = ReserveInstance("third", "app", "sha1", manifest, "first")
+	c.Assert(err, gocheck.IsNil)
+	_, err = ReserveInstance("fourth", "app", "sha2", manifest, "")
+	c.Assert(err, gocheck.IsNil)
+	conts, _ := List()
+	c.Assert(CountInstances(conts), gocheck.DeepEquals, map[string]map[string]uint{"app": {"sha1": 3, "sha2": 1}})
+	os.RemoveAll(saveDir)
+}
+
+type customAppType struct {
+	apptype.BaseHandler
+}
+
+func (customAppType) Defaults(m *types.Manifest) {
+	m.CheckUser = "custom"
+}
+
+func (s *ContainersSuite) TestAppTypes(c *gocheck.C) {
+	manifest := &types.Manifest{AppType: apptype.Java, MemoryLimit: 1024}
+	apptype.Get(manifest.AppType).Defaults(manifest)
+	c.Assert(manifest.Readiness, gocheck.DeepEquals, &types.Probe{Type: types.ProbeTCP})
+	c.Assert(manifest.Env["JAVA_OPTS"], gocheck.Equals, "-Xmx768m")
+	manifest = &types.Manifest{AppType: apptype.Go, Env: map[string]string{}}
+	cont := &types.Container{Manifest: manifest, CPUSet: []uint{2, 3}}
+	cfg := &dockercontainer.Config{}
+	apptype.Get(manifest.AppType).Configure(cont, cfg, &dockercontainer.HostConfig{})
+	c.Assert(cfg.Env, gocheck.DeepEquals, []string{"GOMAXPROCS=2"})
+	manifest = &types.Manifest{AppType: apptype.Cron}
+	apptype.Get(manifest.AppType).Defaults(manifest)
+	apptype.Get(manifest.AppType).Defaults(manifest)
+	c.Assert(manifest.Checks, gocheck.HasLen, 1)
+	c.Assert(manifest.Checks[0].Name, gocheck.Equals, apptype.CronCheckName)
+	// types without a handler are left alone
+	manifest = &types.Manifest{AppType: "ruby"}
+	apptype.Get(manifest.AppType).Defaults(manifest)
+	c.Assert(manifest.Readiness, gocheck.IsNil)
+	// handlers apply on deploy
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	apptype.Register("custom", customAppType{})
+	custom, err := Reserve("custom", &types.Manifest{CPUShares: 1, MemoryLimit: 1, AppType: "custom"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(custom.Deploy("localhost", "app", "sha", "env", nil, nil), gocheck.IsNil)
+	c.Assert(Get("custom").Manifest.CheckUser, gocheck.Equals, "custom")
+	os.RemoveAll(saveDir)
+}
+
 func (s *ContainersSuite) TestTeardown(c *gocheck.C) {
 	os.Setenv("SUPERVISOR_PRETEND", "true")
 	saveDir := "save_test"
@@ -176,3 +293,1580 @@ func (s *ContainersSuite) TestNums(c *gocheck.C) {
 	os.RemoveAll(saveDir)
 	dieChan <- true
 }
+
+func (s *ContainersSuite) TestRunProbe(c *gocheck.C) {
+	// tcp probe against a listening port
+	l, err := net.Listen("tcp", "localhost:0")
+	c.Assert(err, gocheck.IsNil)
+	cont := &types.Container{PrimaryPort: uint16(l.Addr().(*net.TCPAddr).Port)}
+	c.Assert(runProbe(cont, &types.Probe{Type: types.ProbeTCP}), gocheck.IsNil)
+	l.Close()
+	c.Assert(runProbe(cont, &types.Probe{Type: types.ProbeTCP}), gocheck.NotNil)
+	// http probe checks the status code
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	cont = &types.Container{PrimaryPort: uint16(srv.Listener.Addr().(*net.TCPAddr).Port)}
+	c.Assert(runProbe(cont, &types.Probe{Type: types.ProbeHTTP, Path: "/healthz"}), gocheck.IsNil)
+	c.Assert(runProbe(cont, &types.Probe{Type: types.ProbeHTTP, Path: "/down"}), gocheck.ErrorMatches,
+		"unexpected status 503.*")
+}
+
+func (s *ContainersSuite) TestRestartPolicy(c *gocheck.C) {
+	onFailure := &types.RestartPolicy{Name: types.RestartOnFailure, MaxRetries: 2, Backoff: 1}
+	c.Assert(onFailure.ShouldRestart(0, 0), gocheck.Equals, false)
+	c.Assert(onFailure.ShouldRestart(1, 1), gocheck.Equals, true)
+	c.Assert(onFailure.ShouldRestart(1, 2), gocheck.Equals, false)
+	always := &types.RestartPolicy{Name: types.RestartAlways}
+	c.Assert(always.ShouldRestart(0, 100), gocheck.Equals, true)
+	never := &types.RestartPolicy{Name: types.RestartNever}
+	c.Assert(never.ShouldRestart(1, 0), gocheck.Equals, false)
+	c.Assert((&types.RestartPolicy{Name: "sometimes"}).Validate(), gocheck.NotNil)
+	// backoff doubles with every restart and is capped
+	c.Assert(restartBackoff(onFailure, 0), gocheck.Equals, time.Second)
+	c.Assert(restartBackoff(onFailure, 3), gocheck.Equals, 8*time.Second)
+	c.Assert(restartBackoff(onFailure, 20), gocheck.Equals, MaxRestartBackoff)
+}
+
+func (s *ContainersSuite) TestUlimitsAndSysctls(c *gocheck.C) {
+	c.Assert((&types.Ulimit{Name: "nofile", Soft: 65536, Hard: 65536}).Validate(), gocheck.IsNil)
+	c.Assert((&types.Ulimit{Name: "nofile", Soft: 2, Hard: 1}).Validate(), gocheck.NotNil)
+	c.Assert((&types.Ulimit{Name: "files", Soft: 1, Hard: 1}).Validate(), gocheck.NotNil)
+	c.Assert(types.ValidateSysctl("net.core.somaxconn"), gocheck.IsNil)
+	c.Assert(types.ValidateSysctl("kernel.sem"), gocheck.IsNil)
+	c.Assert(types.ValidateSysctl("vm.swappiness"), gocheck.NotNil)
+	manifest := &types.Manifest{
+		Ulimits: []types.Ulimit{{"nproc", 10, 20}},
+		Sysctls: map[string]string{"net.ipv4.tcp_fin_timeout": "15"},
+	}
+	dup := manifest.DeepCopy()
+	dup.Ulimits[0].Soft = 1
+	dup.Sysctls["net.ipv4.tcp_fin_timeout"] = "30"
+	c.Assert(manifest.Ulimits[0].Soft, gocheck.Equals, int64(10))
+	c.Assert(manifest.Sysctls["net.ipv4.tcp_fin_timeout"], gocheck.Equals, "15")
+}
+
+func (s *ContainersSuite) TestLogging(c *gocheck.C) {
+	logging := &types.LogConfig{Driver: "json-file", Options: map[string]string{"max-size": "10m", "max-file": "3"}}
+	c.Assert(logging.Validate(), gocheck.IsNil)
+	c.Assert((&types.LogConfig{Driver: "fluentd", Options: map[string]string{"max-size": "10m"}}).Validate(),
+		gocheck.NotNil)
+	c.Assert((&types.LogConfig{Driver: "splunk"}).Validate(), gocheck.NotNil)
+	manifest := &types.Manifest{Logging: logging}
+	dup := manifest.DeepCopy()
+	dup.Logging.Options["max-file"] = "5"
+	c.Assert(logging.Options["max-file"], gocheck.Equals, "3")
+	c.Assert((&types.Manifest{}).DeepCopy().Logging, gocheck.IsNil)
+}
+
+func (s *ContainersSuite) TestSecrets(c *gocheck.C) {
+	secret := types.Secret{Ref: "vault:secret/data/app#password", File: "password"}
+	c.Assert(secret.Validate(), gocheck.IsNil)
+	c.Assert((&types.Secret{Ref: "vault:secret/data/app", Env: "PASSWORD"}).Validate(), gocheck.NotNil)
+	c.Assert((&types.Secret{Ref: "vault:secret/data/app#password"}).Validate(), gocheck.NotNil)
+	c.Assert((&types.Secret{Ref: "vault:secret/data/app#password", File: "../password"}).Validate(),
+		gocheck.NotNil)
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/app" || r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"password":"hunter2"},"metadata":{"version":1}}}`))
+	}))
+	defer vault.Close()
+	tokenFile, err := ioutil.TempFile("", "vault-token")
+	c.Assert(err, gocheck.IsNil)
+	defer os.Remove(tokenFile.Name())
+	tokenFile.WriteString("token\n")
+	tokenFile.Close()
+	crypto.RegisterSecretResolver("vault", &crypto.VaultKVResolver{Addr: vault.URL, TokenFile: tokenFile.Name()})
+	val, err := crypto.ResolveSecret(&secret)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(val, gocheck.Equals, "hunter2")
+	_, err = crypto.ResolveSecret(&types.Secret{Ref: "vault:secret/data/other#password"})
+	c.Assert(err, gocheck.NotNil)
+	_, err = crypto.ResolveSecret(&types.Secret{Ref: "vault:secret/data/app#user"})
+	c.Assert(err, gocheck.NotNil)
+	_, err = crypto.ResolveSecret(&types.Secret{Ref: "consul:app#password"})
+	c.Assert(err, gocheck.NotNil)
+}
+
+func (s *ContainersSuite) TestRegistry(c *gocheck.C) {
+	c.Assert((&types.Registry{Host: "registry.example.com:5000", Repo: "team/apps"}).Validate(), gocheck.IsNil)
+	c.Assert((&types.Registry{Host: "https://registry.example.com"}).Validate(), gocheck.NotNil)
+	c.Assert((&types.Registry{Repo: "Apps"}).Validate(), gocheck.NotNil)
+	cont := &types.Container{}
+	c.Assert(cont.GetDockerRepo(), gocheck.Equals, "apps")
+	cont.Registry = &types.Registry{Host: "registry.example.com"}
+	c.Assert(cont.GetDockerRepo(), gocheck.Equals, "apps")
+	cont.Registry.Repo = "team/apps"
+	c.Assert(cont.GetDockerRepo(), gocheck.Equals, "team/apps")
+}
+
+func (s *ContainersSuite) TestVolumes(c *gocheck.C) {
+	hostPath := types.Volume{Source: "/data/cache", Target: "/srv/cache"}
+	c.Assert(hostPath.Validate(), gocheck.IsNil)
+	c.Assert(hostPath.Bind(), gocheck.Equals, "/data/cache:/srv/cache")
+	named := types.Volume{Source: "app-data", Target: "/srv/data", ReadOnly: true}
+	c.Assert(named.Validate(), gocheck.IsNil)
+	c.Assert(named.Bind(), gocheck.Equals, "app-data:/srv/data:ro")
+	c.Assert((&types.Volume{Source: "/data/../etc", Target: "/srv"}).Validate(), gocheck.NotNil)
+	c.Assert((&types.Volume{Source: "data", Target: "srv"}).Validate(), gocheck.NotNil)
+	c.Assert((&types.Volume{Source: "data", Target: "/"}).Validate(), gocheck.NotNil)
+}
+
+func (s *ContainersSuite) TestSidecars(c *gocheck.C) {
+	c.Assert((&types.Sidecar{Name: "log-shipper", Image: "shipper", Version: "1.0"}).Validate(), gocheck.IsNil)
+	c.Assert((&types.Sidecar{Name: "Log Shipper", Image: "shipper", Version: "1.0"}).Validate(), gocheck.NotNil)
+	c.Assert((&types.Sidecar{Name: "proxy"}).Validate(), gocheck.NotNil)
+	cont := &Container{Container: types.Container{ID: "app-1", DockerID: "docker-1", Host: "host", Env: "prod",
+		PrimaryPort: 61000, Manifest: &types.Manifest{Sidecars: []types.Sidecar{
+			{Name: "proxy", Image: "proxy", Version: "2", Env: map[string]string{"UPSTREAM": "localhost"}},
+		}}}}
+	c.Assert(deploySidecars(cont), gocheck.IsNil)
+	c.Assert(cont.Sidecars, gocheck.HasLen, 1)
+	sidecar := cont.Sidecars[0]
+	c.Assert(sidecar.ID, gocheck.Equals, "app-1-proxy")
+	c.Assert(sidecar.PrimaryDockerID, gocheck.Equals, "docker-1")
+	// restarting replaces the sidecar so earlier copies are not modified
+	restarted, err := restartedSidecar(sidecar, cont.DockerID, "test")
+	c.Assert(err, gocheck.IsNil)
+	replaceSidecars(cont, []*types.SidecarContainer{restarted})
+	c.Assert(cont.Sidecars[0].Restarts, gocheck.Equals, uint(1))
+	c.Assert(sidecar.Restarts, gocheck.Equals, uint(0))
+	respChan := make(chan error, 1)
+	restartSidecar(cont, "missing", "test", respChan)
+	c.Assert(<-respChan, gocheck.NotNil)
+}
+
+func (s *ContainersSuite) TestResolveTemplates(c *gocheck.C) {
+	str, err := types.Interpolate("run --env ${ENV} --home ${HOME} $${ENV}", map[string]string{"ENV": "prod"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(str, gocheck.Equals, "run --env prod --home ${HOME} ${ENV}")
+	_, err = types.Interpolate("${deps.db.password}", map[string]string{})
+	c.Assert(err, gocheck.NotNil)
+	cont := &Container{Container: types.Container{ID: "app-1", Env: "staging", Manifest: &types.Manifest{
+		RunCommands: []string{"serve --id ${CONTAINER_ID} --db ${deps.db.host}", "auth --password ${deps.db.password}"},
+		RunArgv:     [][]string{{"auth", "--password", "${deps.db.password}"}},
+		Env:         map[string]string{"DB_PORT": "${deps.db.port}"},
+		Deps: types.DepsType{"db": &types.AppDep{DataMap: map[string]interface{}{"host": "db1", "port": 5432,
+			"password": "it's $(secret)"}}},
+	}}}
+	c.Assert(cont.resolveTemplates(), gocheck.IsNil)
+	c.Assert(cont.Manifest.RunCommands[0], gocheck.Equals, "serve --id app-1 --db db1")
+	// values are quoted in commands the shell runs, and passed as they are in argv
+	c.Assert(cont.Manifest.RunCommands[1], gocheck.Equals, `auth --password 'it'"'"'s $(secret)'`)
+	c.Assert(cont.Manifest.RunArgv[0], gocheck.DeepEquals, []string{"auth", "--password", "it's $(secret)"})
+	c.Assert(cont.Manifest.Env["DB_PORT"], gocheck.Equals, "5432")
+	c.Assert(types.ValidateRunCommand(`serve --name "my app" 'x' \"`), gocheck.IsNil)
+	c.Assert(types.ValidateRunCommand(" "), gocheck.ErrorMatches, "empty run command")
+	c.Assert(types.ValidateRunCommand("serve\nrm -rf /"), gocheck.ErrorMatches, ".*single line.*")
+	c.Assert(types.ValidateRunCommand(`echo "it's`), gocheck.ErrorMatches, `.*unterminated " quote`)
+	c.Assert(types.ValidateRunArgv([]string{"serve", "--name", "my app; rm -rf /"}), gocheck.IsNil)
+	c.Assert(types.ValidateRunArgv([]string{}), gocheck.NotNil)
+}
+
+func (s *ContainersSuite) TestMigrateManifest(c *gocheck.C) {
+	manifest := &types.Manifest{Deps: types.DepsType{"db": nil}}
+	c.Assert(manifest.Migrate(), gocheck.IsNil)
+	c.Assert(manifest.SchemaVersion, gocheck.Equals, uint(types.ManifestSchemaVersion))
+	c.Assert(manifest.Deps["db"], gocheck.NotNil)
+	c.Assert(manifest.RunCommands, gocheck.NotNil)
+	c.Assert((&types.Manifest{SchemaVersion: types.ManifestSchemaVersion + 1}).Migrate(), gocheck.NotNil)
+}
+
+func (s *ContainersSuite) TestNamedPorts(c *gocheck.C) {
+	manifest := &types.Manifest{Ports: []types.PortSpec{{Name: "metrics"}, {Name: "workers", Count: 2}}}
+	c.Assert(namedPortCount(manifest), gocheck.Equals, uint16(3))
+	cont := &Container{Container: types.Container{
+		NamedPorts: assignNamedPorts(manifest, []uint16{61004, 61006, 61008, 61010}),
+	}}
+	c.Assert(cont.NamedPorts["metrics"], gocheck.DeepEquals, []uint16{61004})
+	c.Assert(cont.NamedPorts["workers"], gocheck.DeepEquals, []uint16{61006, 61008})
+	c.Assert(cont.NamedPort("workers"), gocheck.Equals, uint16(61006))
+	c.Assert(cont.NamedPort("admin"), gocheck.Equals, uint16(0))
+	env := cont.NamedPortEnv()
+	c.Assert(env["PORT_METRICS"], gocheck.Equals, "61004")
+	c.Assert(env["PORT_WORKERS_1"], gocheck.Equals, "61008")
+	c.Assert((&types.PortSpec{Name: "http"}).Validate(), gocheck.NotNil)
+}
+
+func (s *ContainersSuite) TestGPUs(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	NumGPUs = 2
+	defer func() { NumGPUs = 0 }()
+	c.Assert(Init("localhost", saveDir, uint16(3), uint16(2), uint16(61000), 3, 1024, false), gocheck.IsNil)
+	first, err := Reserve("first", &types.Manifest{CPUShares: 1, MemoryLimit: 100, GPUs: 1})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(first.GPUs, gocheck.DeepEquals, []uint{0})
+	_, err = Reserve("second", &types.Manifest{CPUShares: 1, MemoryLimit: 100, GPUs: 2})
+	c.Assert(err, gocheck.ErrorMatches, "Not enough GPUs to reserve\\. \\(2 requested, 1 available\\)")
+	gpus := GPUNums()
+	c.Assert(gpus.Used, gocheck.Equals, uint(1))
+	c.Assert(gpus.Free, gocheck.Equals, uint(1))
+	c.Assert(Teardown("first"), gocheck.Equals, true)
+	second, err := Reserve("second", &types.Manifest{CPUShares: 1, MemoryLimit: 100, GPUs: 2})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(second.GPUs, gocheck.HasLen, 2)
+	c.Assert(GPUNums().Free, gocheck.Equals, uint(0))
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestBackupRestore(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	cont, err := Reserve("first", &types.Manifest{CPUShares: 10, MemoryLimit: 100})
+	c.Assert(err, gocheck.IsNil)
+	cont.App = "app"
+	save(cont.ID)
+	backup := Backup()
+	c.Assert(backup.Version, gocheck.Equals, types.StateBackupVersion)
+	c.Assert(backup.Containers, gocheck.HasLen, 1)
+	c.Assert(backup.FreePorts, gocheck.DeepEquals, []uint16{1})
+	// only an empty supervisor can be restored into
+	_, err = Restore(backup)
+	c.Assert(err, gocheck.ErrorMatches, "Backups can only be restored into a supervisor with no containers\\.")
+	os.RemoveAll(saveDir)
+	c.Assert(Init("localhost", saveDir, uint16(3), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	_, err = Restore(backup)
+	c.Assert(err, gocheck.ErrorMatches, "Port layout does not match\\..*")
+	os.RemoveAll(saveDir)
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 5, 1024, false), gocheck.IsNil)
+	_, err = Restore(backup)
+	c.Assert(err, gocheck.ErrorMatches, "Not enough CPU Shares\\. \\(10 needed, 5 available\\)")
+	os.RemoveAll(saveDir)
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	_, err = Restore(&types.StateBackup{Version: types.StateBackupVersion + 1})
+	c.Assert(err, gocheck.ErrorMatches, "Unsupported backup version.*")
+	errs, err := Restore(backup)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(errs, gocheck.HasLen, 0)
+	conts, ports := List()
+	c.Assert(conts["first"], gocheck.NotNil)
+	c.Assert(conts["first"].App, gocheck.Equals, "app")
+	c.Assert(ports, gocheck.DeepEquals, []uint16{61001})
+	_, cpu, _ := Nums()
+	c.Assert(cpu.Used, gocheck.Equals, uint(10))
+	// the restored state is saved
+	containers = nil
+	c.Assert(loadState(), gocheck.IsNil)
+	c.Assert(containers["first"], gocheck.NotNil)
+	stopProbes("first")
+	stopWatch("first")
+	stopSidecarWatch("first")
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestImportState(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	_, err := Reserve("first", &types.Manifest{CPUShares: 10, MemoryLimit: 100})
+	c.Assert(err, gocheck.IsNil)
+	conts, _ := List()
+	record := conts["first"]
+	// records have to fit the port layout and resources
+	record.Manifest = &types.Manifest{CPUShares: 200, MemoryLimit: 100}
+	_, err = ImportState(map[string]*types.Container{"first": record})
+	c.Assert(err, gocheck.ErrorMatches, "Not enough CPU Shares\\. \\(200 needed, 100 available\\)")
+	record.Manifest = &types.Manifest{CPUShares: 20, MemoryLimit: 100}
+	record.SSHPort = 61003
+	_, err = ImportState(map[string]*types.Container{"first": record})
+	c.Assert(err, gocheck.ErrorMatches, "Ports of first do not match its primary port 61000\\.")
+	record.SSHPort = 61002
+	record.App = "fixed"
+	ids, err := ImportState(map[string]*types.Container{"first": record})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(ids, gocheck.DeepEquals, []string{"first"})
+	c.Assert(Get("first").App, gocheck.Equals, "fixed")
+	_, cpu, _ := Nums()
+	c.Assert(cpu.Used, gocheck.Equals, uint(20))
+	// dropping a record frees its port
+	ids, err = ImportState(map[string]*types.Container{"first": nil})
+	c.Assert(err, gocheck.IsNil)
+	conts, ports := List()
+	c.Assert(conts, gocheck.HasLen, 0)
+	c.Assert(ports, gocheck.DeepEquals, []uint16{61001, 61000})
+	containers = nil
+	c.Assert(loadState(), gocheck.IsNil)
+	c.Assert(containers, gocheck.HasLen, 0)
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestContainerJSON(c *gocheck.C) {
+	expires := time.Unix(1400000000, 0).UTC()
+	cont := &types.Container{ID: "json", PrimaryPort: 61000, SSHPort: 61001, Manifest: &types.Manifest{CPUShares: 10},
+		ExpiresAt: expires}
+	data, err := json.Marshal(cont)
+	c.Assert(err, gocheck.IsNil)
+	// lowerCamel keys, unset fields and times left out, deps and run commands never null
+	c.Assert(string(data), gocheck.Equals, `{"id":"json","primaryPort":61000,"sshPort":61001,"manifest":`+
+		`{"schemaVersion":0,"cpuShares":10,"runCommands":[],"deps":{}},"expiresAt":"2014-05-13T16:53:20Z"}`)
+	var decoded types.Container
+	c.Assert(json.Unmarshal(data, &decoded), gocheck.IsNil)
+	c.Assert(decoded.ID, gocheck.Equals, "json")
+	c.Assert(decoded.SSHPort, gocheck.Equals, uint16(61001))
+	c.Assert(decoded.ExpiresAt.Equal(expires), gocheck.Equals, true)
+	c.Assert(decoded.ExitedAt.IsZero(), gocheck.Equals, true)
+	c.Assert(decoded.Manifest.CPUShares, gocheck.Equals, uint(10))
+}
+
+func (s *ContainersSuite) TestContainerFormat(c *gocheck.C) {
+	var none *types.Container
+	c.Assert(none.String(), gocheck.Equals, "none")
+	// a container without a manifest or sidecars still prints
+	cont := &types.Container{ID: "fmt", PrimaryPort: 61000, Sidecars: []*types.SidecarContainer{nil}}
+	c.Assert(cont.String(), gocheck.Matches, "(?s)fmt\n.*CPU Shares      : none\n.*Sidecars        : none\n.*")
+	c.Assert(cont.Format(types.ContainerFormatShort), gocheck.Equals, "fmt none @ none on port 61000 (unknown)")
+	c.Assert(cont.Format(types.ContainerFormatTable), gocheck.Equals, "fmt\tnone\tnone\tnone\t61000\tunknown\t-\t-")
+	cont.App, cont.Sha, cont.Host, cont.RunState = "app", "sha", "host", types.ContainerRunning
+	cont.Manifest = &types.Manifest{CPUShares: 10, MemoryLimit: 256}
+	c.Assert(cont.Format(types.ContainerFormatShort), gocheck.Equals, "fmt app @ sha on host:61000 (running)")
+	c.Assert(cont.Format(types.ContainerFormatTable), gocheck.Equals, "fmt\tapp\tsha\thost\t61000\trunning\t10\t256")
+}
+
+// Set every exported field reachable from v to something other than its zero value
+func fillValue(v reflect.Value, depth int) {
+	if depth > 6 {
+		return
+	}
+	if v.Type() == reflect.TypeOf(time.Time{}) {
+		v.Set(reflect.ValueOf(time.Unix(1400000000, 0)))
+		return
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1)
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fillValue(v.Elem(), depth+1)
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fillValue(v.Index(0), depth+1)
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		key, val := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+		fillValue(key, depth+1)
+		fillValue(val, depth+1)
+		v.SetMapIndex(key, val)
+	case reflect.Interface:
+		v.Set(reflect.ValueOf(map[string]interface{}{"list": []interface{}{"x"}}))
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				fillValue(v.Field(i), depth+1)
+			}
+		}
+	}
+}
+
+// Returns the path to a pointer, map or slice that a and b share, empty if they share none
+func sharedMemory(a, b reflect.Value, path string) string {
+	switch a.Kind() {
+	case reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			return ""
+		}
+		if a.Pointer() == b.Pointer() {
+			return path
+		}
+		return sharedMemory(a.Elem(), b.Elem(), path)
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return ""
+		}
+		return sharedMemory(a.Elem(), b.Elem(), path)
+	case reflect.Slice:
+		if a.Len() > 0 && b.Len() > 0 && a.Pointer() == b.Pointer() {
+			return path
+		}
+		for i := 0; i < a.Len() && i < b.Len(); i++ {
+			if shared := sharedMemory(a.Index(i), b.Index(i), path+"[]"); shared != "" {
+				return shared
+			}
+		}
+	case reflect.Map:
+		if !a.IsNil() && !b.IsNil() && a.Pointer() == b.Pointer() {
+			return path
+		}
+		for _, key := range a.MapKeys() {
+			if val := b.MapIndex(key); val.IsValid() {
+				if shared := sharedMemory(a.MapIndex(key), val, path+"[]"); shared != "" {
+					return shared
+				}
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if field := a.Type().Field(i); field.PkgPath == "" {
+				if shared := sharedMemory(a.Field(i), b.Field(i), path+"."+field.Name); shared != "" {
+					return shared
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func (s *ContainersSuite) TestDeepCopy(c *gocheck.C) {
+	values := []interface{}{&types.Container{}, &types.Manifest{}, &types.SupervisorDeployArg{},
+		&types.SupervisorListReply{}, &types.SupervisorHealthCheckReply{}, &types.SupervisorListEventsReply{},
+		&types.SupervisorListStateSnapshotsReply{}, &types.StateBackup{}, &types.DepsType{}}
+	for _, value := range values {
+		orig := reflect.ValueOf(value).Elem()
+		fillValue(orig, 0)
+		dup := orig.Addr().MethodByName("DeepCopy").Call(nil)[0]
+		if dup.Kind() == reflect.Ptr {
+			dup = dup.Elem()
+		}
+		c.Assert(dup.Interface(), gocheck.DeepEquals, orig.Interface(), gocheck.Commentf("%T", value))
+		c.Assert(sharedMemory(orig, dup, orig.Type().Name()), gocheck.Equals, "")
+	}
+	var none *types.Container
+	c.Assert(none.DeepCopy(), gocheck.IsNil)
+	// containers handed out can be changed without changing the supervisor's
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	_, err := Reserve("first", &types.Manifest{CPUShares: 1, MemoryLimit: 1, Env: map[string]string{"A": "1"}})
+	c.Assert(err, gocheck.IsNil)
+	Get("first").Manifest.Env["A"] = "2"
+	conts, _ := List()
+	conts["first"].Manifest.CPUShares = 2
+	c.Assert(Get("first").Manifest.Env["A"], gocheck.Equals, "1")
+	c.Assert(Get("first").Manifest.CPUShares, gocheck.Equals, uint(1))
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestTypedDeps(c *gocheck.C) {
+	cmk, err := types.ParseCmkDep(map[string]interface{}{"contact_group": "ops", "other": 1})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cmk.ContactGroup, gocheck.Equals, "ops")
+	_, err = types.ParseCmkDep(map[string]interface{}{"contact_group": 1})
+	c.Assert(err, gocheck.ErrorMatches, "contact_group should be a string, not a number")
+	_, err = types.ParseCmkDep(map[string]interface{}{})
+	c.Assert(err, gocheck.ErrorMatches, "no contact_group")
+	_, err = types.ParseCmkDep("ops")
+	c.Assert(err, gocheck.ErrorMatches, "expected a map, not string")
+	// data decoded from json has numbers as float64
+	db, err := (&types.AppDep{DataMap: map[string]interface{}{"host": "db", "port": float64(5432),
+		"database": "app"}}).Database()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(*db, gocheck.Equals, types.DatabaseDep{Host: "db", Port: 5432, Database: "app"})
+	_, err = types.ParsePortDep(map[string]interface{}{"host": "svc", "port": float64(70000)})
+	c.Assert(err, gocheck.NotNil)
+	deps := types.DepsType{types.CmkDepName: {DataMap: map[string]interface{}{"contact_group": true}}}
+	c.Assert(deps.Validate(), gocheck.ErrorMatches, "cmk dep: contact_group should be a string, not a bool")
+	deps[types.CmkDepName] = &types.AppDep{EncryptedData: "secret"}
+	c.Assert(deps.Validate(), gocheck.IsNil)
+}
+
+func (s *ContainersSuite) TestEnvOverrides(c *gocheck.C) {
+	m := &types.Manifest{MemoryLimit: 512, Instances: 1, RunCommands: []string{"run"},
+		EnvOverrides: map[string]*types.ManifestOverride{
+			"production": {MemoryLimit: 2048, Instances: 4, Env: map[string]string{"LEVEL": "warn"}},
+			"staging":    {RunCommands: []string{"run --debug"}},
+		}}
+	m.ApplyEnv("dev")
+	c.Assert(m.MemoryLimit, gocheck.Equals, uint(512))
+	staging := m.DeepCopy()
+	staging.ApplyEnv("staging")
+	c.Assert(staging.MemoryLimit, gocheck.Equals, uint(512))
+	c.Assert(staging.RunCommands, gocheck.DeepEquals, []string{"run --debug"})
+	m.ApplyEnv("production")
+	m.ApplyEnv("production")
+	c.Assert(m.MemoryLimit, gocheck.Equals, uint(2048))
+	c.Assert(m.Instances, gocheck.Equals, uint(4))
+	c.Assert(m.Env, gocheck.DeepEquals, map[string]string{"LEVEL": "warn"})
+	c.Assert(m.RunCommands, gocheck.DeepEquals, []string{"run"})
+	c.Assert(m.EnvOverrides["production"].Env, gocheck.DeepEquals, map[string]string{"LEVEL": "warn"})
+	bad := &types.ManifestOverride{Env: map[string]string{"BAD NAME": "x"}}
+	c.Assert(bad.Validate(), gocheck.NotNil)
+}
+
+func (s *ContainersSuite) TestDiffManifests(c *gocheck.C) {
+	old := &types.Manifest{CPUShares: 1, MemoryLimit: 512, RunCommands: []string{"run", "tail"},
+		Env:  map[string]string{"LEVEL": "info", "GONE": "x"},
+		Deps: types.DepsType{"db": {DataMap: map[string]interface{}{"password": "old"}}}}
+	proposed := old.DeepCopy()
+	proposed.MemoryLimit = 1024
+	proposed.RunCommands = []string{"run --fast"}
+	proposed.Env = map[string]string{"LEVEL": "warn", "NEW": "y"}
+	proposed.Deps["db"].DataMap["password"] = "new"
+	changes, err := types.DiffManifests(old, proposed)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(changes, gocheck.DeepEquals, []types.ManifestChange{
+		{Path: "deps.db.dataMap.password", Kind: types.ChangeChanged, Old: types.RedactedValue, New: types.RedactedValue},
+		{Path: "env.GONE", Kind: types.ChangeRemoved, Old: `"x"`},
+		{Path: "env.LEVEL", Kind: types.ChangeChanged, Old: `"info"`, New: `"warn"`},
+		{Path: "env.NEW", Kind: types.ChangeAdded, New: `"y"`},
+		{Path: "memoryLimit", Kind: types.ChangeChanged, Old: "512", New: "1024"},
+		{Path: "runCommands[0]", Kind: types.ChangeChanged, Old: `"run"`, New: `"run --fast"`},
+		{Path: "runCommands[1]", Kind: types.ChangeRemoved, Old: `"tail"`},
+	})
+	c.Assert(changes[1].String(), gocheck.Equals, `- env.GONE: "x"`)
+	changes, err = types.DiffManifests(old, old.DeepCopy())
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(changes, gocheck.HasLen, 0)
+}
+
+func (s *ContainersSuite) TestMACProfile(c *gocheck.C) {
+	c.Assert((&types.MACProfile{}).Validate(), gocheck.ErrorMatches, "no AppArmor profile or SELinux label")
+	profile := &types.MACProfile{AppArmor: "atlantis-app"}
+	c.Assert(profile.Validate(), gocheck.IsNil)
+	c.Assert(profile.UsesSELinux(), gocheck.Equals, false)
+	profile = &types.MACProfile{SELinuxType: "svirt_lxc_net_t", SELinuxLevel: "s0:c100,c200"}
+	c.Assert(profile.Validate(), gocheck.IsNil)
+	c.Assert(profile.UsesSELinux(), gocheck.Equals, true)
+	c.Assert((&types.MACProfile{AppArmor: "bad profile"}).Validate(), gocheck.NotNil)
+	c.Assert((&types.MACProfile{SELinuxType: "type,level"}).Validate(), gocheck.NotNil)
+	m := &types.Manifest{MACProfile: profile}
+	dup := m.DeepCopy()
+	c.Assert(dup.MACProfile, gocheck.DeepEquals, profile)
+	dup.MACProfile.SELinuxType = "other_t"
+	c.Assert(profile.SELinuxType, gocheck.Equals, "svirt_lxc_net_t")
+}
+
+func (s *ContainersSuite) TestSeccomp(c *gocheck.C) {
+	c.Assert(types.ValidateSeccomp(types.SeccompUnconfined), gocheck.IsNil)
+	c.Assert(types.ValidateSeccomp("no-raw-sockets"), gocheck.IsNil)
+	c.Assert(types.ValidateSeccomp("../etc/passwd"), gocheck.NotNil)
+	oldDir := docker.SeccompDir
+	defer func() { docker.SeccompDir = oldDir }()
+	docker.SeccompDir = c.MkDir()
+	cont := &types.Container{Manifest: &types.Manifest{}}
+	opts, err := docker.SeccompSecurityOpts(cont)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(opts, gocheck.HasLen, 0)
+	cont.Manifest.Seccomp = types.SeccompUnconfined
+	opts, err = docker.SeccompSecurityOpts(cont)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(opts, gocheck.DeepEquals, []string{"seccomp=unconfined"})
+	cont.Manifest.Seccomp = "strict"
+	_, err = docker.SeccompSecurityOpts(cont)
+	c.Assert(err, gocheck.ErrorMatches, "Could not read seccomp profile strict: .*")
+	profile := `{"defaultAction": "SCMP_ACT_ERRNO"}`
+	c.Assert(ioutil.WriteFile(docker.SeccompProfilePath("strict"), []byte(profile), 0644), gocheck.IsNil)
+	opts, err = docker.SeccompSecurityOpts(cont)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(opts, gocheck.DeepEquals, []string{"seccomp=" + profile})
+}
+
+func (s *ContainersSuite) TestCapabilities(c *gocheck.C) {
+	caps, err := types.NormalizeCapabilities([]string{"net_raw", "CAP_SYS_PTRACE", "NET_RAW"}, false)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(caps, gocheck.DeepEquals, []string{"NET_RAW", "SYS_PTRACE"})
+	_, err = types.NormalizeCapabilities([]string{"ALL"}, false)
+	c.Assert(err, gocheck.ErrorMatches, `unknown capability "ALL"`)
+	caps, err = types.NormalizeCapabilities([]string{"all"}, true)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(caps, gocheck.DeepEquals, []string{"ALL"})
+	_, err = types.NormalizeCapabilities([]string{"NET_MAGIC"}, true)
+	c.Assert(err, gocheck.NotNil)
+	cont := &types.Container{ID: "cont", Manifest: &types.Manifest{CapAdd: []string{"NET_BIND_SERVICE"},
+		CapDrop: []string{"ALL"}}}
+	c.Assert(strings.Contains(cont.String(), "Capabilities    : +NET_BIND_SERVICE -ALL\n"), gocheck.Equals, true)
+}
+
+func (s *ContainersSuite) TestReadOnlyRoot(c *gocheck.C) {
+	m := &types.Manifest{Tmpfs: []types.TmpfsMount{{Target: "/var/cache/app", SizeMB: 64}}}
+	c.Assert(m.TmpfsMounts(), gocheck.DeepEquals, m.Tmpfs)
+	m.ReadOnlyRoot = true
+	m.Volumes = []types.Volume{{Source: "scratch", Target: "/tmp"}}
+	c.Assert(m.TmpfsMounts(), gocheck.DeepEquals, []types.TmpfsMount{{Target: "/var/cache/app", SizeMB: 64},
+		{Target: "/run"}})
+	c.Assert((&types.TmpfsMount{Target: "tmp"}).Validate(), gocheck.NotNil)
+	cont := &types.Container{ID: "cont", App: "app", Sha: "sha", Manifest: m}
+	_, hostCfg := docker.ContainerDockerCfgs(cont)
+	c.Assert(hostCfg.ReadonlyRootfs, gocheck.Equals, true)
+	c.Assert(hostCfg.Tmpfs, gocheck.DeepEquals, map[string]string{"/var/cache/app": "rw,nosuid,nodev,size=64m",
+		"/run": "rw,nosuid,nodev"})
+}
+
+func (s *ContainersSuite) TestRemappedRoot(c *gocheck.C) {
+	oldUIDs, oldGIDs := docker.SubUIDFile, docker.SubGIDFile
+	defer func() { docker.SubUIDFile, docker.SubGIDFile = oldUIDs, oldGIDs }()
+	dir := c.MkDir()
+	docker.SubUIDFile, docker.SubGIDFile = filepath.Join(dir, "subuid"), filepath.Join(dir, "subgid")
+	c.Assert(ioutil.WriteFile(docker.SubUIDFile, []byte("other:100000:65536\ndockremap:165536:65536\n"), 0644),
+		gocheck.IsNil)
+	c.Assert(ioutil.WriteFile(docker.SubGIDFile, []byte("dockremap:231072:65536\nremapgroup:300000:65536\n"),
+		0644), gocheck.IsNil)
+	uid, gid, err := docker.RemappedRoot("")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert([]int{uid, gid}, gocheck.DeepEquals, []int{0, 0})
+	uid, gid, err = docker.RemappedRoot("default")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert([]int{uid, gid}, gocheck.DeepEquals, []int{165536, 231072})
+	uid, gid, err = docker.RemappedRoot("dockremap:remapgroup")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert([]int{uid, gid}, gocheck.DeepEquals, []int{165536, 300000})
+	_, _, err = docker.RemappedRoot("nobody-here")
+	c.Assert(err, gocheck.ErrorMatches, "no subordinate ids for nobody-here in .*")
+	// nothing is chowned with remapping off
+	c.Assert(docker.ChownToContainerRoot(filepath.Join(dir, "missing")), gocheck.IsNil)
+}
+
+func (s *ContainersSuite) TestStateSnapshots(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	NumSnapshots = 2
+	defer func() { NumSnapshots = 0 }()
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	_, err := Reserve("first", &types.Manifest{CPUShares: 10, MemoryLimit: 100})
+	c.Assert(err, gocheck.IsNil)
+	save("first")
+	snapshots, err := StateSnapshots()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(snapshots, gocheck.HasLen, 1)
+	c.Assert(snapshots[0].Containers, gocheck.Equals, 1)
+	good := snapshots[0].ID
+	_, err = Reserve("second", &types.Manifest{CPUShares: 10, MemoryLimit: 100})
+	c.Assert(err, gocheck.IsNil)
+	save("second")
+	save("second")
+	// only the newest snapshots are kept
+	snapshots, err = StateSnapshots()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(snapshots, gocheck.HasLen, 2)
+	c.Assert(snapshots[0].ID, gocheck.Not(gocheck.Equals), good)
+	c.Assert(snapshots[1].Containers, gocheck.Equals, 2)
+	latest := snapshots[1].ID
+	_, err = RollbackState("../ports")
+	c.Assert(err, gocheck.ErrorMatches, "Invalid snapshot id\\.")
+	_, err = RollbackState("20000101T000000.000000000Z")
+	c.Assert(err, gocheck.ErrorMatches, "Unknown snapshot\\.")
+	// a bad save of no containers can be rolled back
+	_, err = ImportState(map[string]*types.Container{"first": nil, "second": nil})
+	c.Assert(err, gocheck.IsNil)
+	conts, _ := List()
+	c.Assert(conts, gocheck.HasLen, 0)
+	ids, err := RollbackState(latest)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(ids, gocheck.DeepEquals, []string{"first", "second"})
+	conts, ports := List()
+	c.Assert(conts, gocheck.HasLen, 2)
+	c.Assert(ports, gocheck.HasLen, 0)
+	_, cpu, _ := Nums()
+	c.Assert(cpu.Used, gocheck.Equals, uint(20))
+	containers = nil
+	c.Assert(loadState(), gocheck.IsNil)
+	c.Assert(containers, gocheck.HasLen, 2)
+	for _, id := range ids {
+		stopProbes(id)
+		stopWatch(id)
+		stopSidecarWatch(id)
+	}
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestMigrateState(c *gocheck.C) {
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	c.Assert(os.MkdirAll(saveDir, 0755), gocheck.IsNil)
+	// state saved before the format was versioned
+	old := serialize.NewBoltStore(saveDir + "/" + StateFile)
+	c.Assert(old.Update(serialize.Update{Bucket: ContainersBucket, Key: "old", Object: &types.Container{ID: "old",
+		SecondaryPorts: []uint16{61004, 61006}, Manifest: &types.Manifest{Ports: []types.PortSpec{{Name: "http"}}}}}),
+		gocheck.IsNil)
+	from, err := MigrateState(saveDir)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(from, gocheck.Equals, 0)
+	backups, err := filepath.Glob(saveDir + "/" + StateFile + ".v0-*.bak")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(backups, gocheck.HasLen, 1)
+	saved := map[string]*types.Container{}
+	c.Assert(old.RetrieveAll(ContainersBucket, &saved), gocheck.IsNil)
+	c.Assert(saved["old"].Manifest.SchemaVersion, gocheck.Equals, uint(types.ManifestSchemaVersion))
+	c.Assert(saved["old"].NamedPorts, gocheck.DeepEquals, map[string][]uint16{"http": []uint16{61004}})
+	from, err = MigrateState(saveDir)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(from, gocheck.Equals, StateFormatVersion)
+	// state saved by a newer supervisor is left alone
+	c.Assert(old.Update(serialize.Update{Bucket: MetaBucket, Key: formatVersionKey, Object: StateFormatVersion + 1}),
+		gocheck.IsNil)
+	_, err = MigrateState(saveDir)
+	c.Assert(err, gocheck.ErrorMatches, ".*newer than the supported version.*")
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestDedicatedCPUs(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	PinnableCPUs = []uint{2, 3}
+	SharesPerCPU = 10
+	defer func() { PinnableCPUs, SharesPerCPU = nil, 0 }()
+	c.Assert(Init("localhost", saveDir, uint16(3), uint16(2), uint16(61000), 30, 1024, false), gocheck.IsNil)
+	first, err := Reserve("first", &types.Manifest{CPUShares: 1, MemoryLimit: 100, DedicatedCPUs: 1})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(first.CPUSet, gocheck.DeepEquals, []uint{2})
+	_, err = Reserve("second", &types.Manifest{CPUShares: 1, MemoryLimit: 100, DedicatedCPUs: 2})
+	c.Assert(err, gocheck.ErrorMatches, "Not enough CPUs to dedicate\\. \\(2 requested, 1 available\\)")
+	// dedicated cores are paid for in cpu shares
+	_, cpu, _ := Nums()
+	c.Assert(cpu.Used, gocheck.Equals, uint(10))
+	_, err = Reserve("second", &types.Manifest{CPUShares: 1, MemoryLimit: 100, DedicatedCPUs: 1})
+	c.Assert(err, gocheck.IsNil)
+	cpus := CPUNums()
+	c.Assert(cpus.Total, gocheck.Equals, uint(2))
+	c.Assert(cpus.Free, gocheck.Equals, uint(0))
+	c.Assert(Teardown("first"), gocheck.Equals, true)
+	c.Assert(CPUNums().Free, gocheck.Equals, uint(1))
+	_, cpu, _ = Nums()
+	c.Assert(cpu.Used, gocheck.Equals, uint(10))
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestDockerEvents(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	container, err := Reserve("first", &types.Manifest{CPUShares: 1, MemoryLimit: 100, MemorySwap: 200})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(container.Deploy("host", "app", "sha", "env", nil, nil), gocheck.IsNil)
+	dockerID := Get("first").DockerID
+	c.Assert(dockerID, gocheck.Not(gocheck.Equals), "")
+	DockerEvent("oom", "unknown-docker-id")
+	DockerEvent("oom", dockerID)
+	DockerEvent("oom", dockerID)
+	oomed := Get("first")
+	c.Assert(oomed.OOMKills, gocheck.Equals, uint(2))
+	c.Assert(time.Since(oomed.LastOOMKill) < time.Minute, gocheck.Equals, true)
+	// the count survives a restart of the supervisor
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	c.Assert(Get("first").OOMKills, gocheck.Equals, uint(2))
+	// out of band changes show up as discrepancies until docker reports the container running again
+	DockerEvent("die", dockerID)
+	c.Assert(Get("first").RunState, gocheck.Equals, types.ContainerExited)
+	before := time.Now()
+	DockerEvent("destroy", dockerID)
+	removed := Get("first")
+	c.Assert(removed.Discrepancy, gocheck.Equals, types.DiscrepancyRemoved)
+	c.Assert(Events("first", before)[0].Type, gocheck.Equals, types.EventRemoved)
+	DockerEvent("start", dockerID)
+	started := Get("first")
+	c.Assert(started.Discrepancy, gocheck.Equals, "")
+	c.Assert(started.RunState, gocheck.Equals, types.ContainerRunning)
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestDiskLimit(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	DiskLimit = 1000
+	defer func() { DiskLimit = 0 }()
+	c.Assert(Init("localhost", saveDir, uint16(3), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	_, err := Reserve("first", &types.Manifest{CPUShares: 1, MemoryLimit: 100, DiskLimit: 600})
+	c.Assert(err, gocheck.IsNil)
+	_, err = Reserve("second", &types.Manifest{CPUShares: 1, MemoryLimit: 100, DiskLimit: 500})
+	c.Assert(err, gocheck.ErrorMatches, "Not enough Disk to reserve\\. \\(500 requested, 400 available\\)")
+	// containers without a quota do not count against the disk
+	_, err = Reserve("second", &types.Manifest{CPUShares: 1, MemoryLimit: 100})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(DiskNums(), gocheck.DeepEquals, &types.ResourceStats{Total: 1000, Used: 600, Free: 400})
+	c.Assert(Teardown("first"), gocheck.Equals, true)
+	c.Assert(DiskNums().Free, gocheck.Equals, uint(1000))
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestOvercommit(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	CPUOvercommit, MemoryOvercommit = 1.5, 2
+	defer func() { CPUOvercommit, MemoryOvercommit = 0, 0 }()
+	c.Assert(Init("localhost", saveDir, uint16(3), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	_, err := Reserve("first", &types.Manifest{CPUShares: 120, MemoryLimit: 1500})
+	c.Assert(err, gocheck.IsNil)
+	_, err = Reserve("second", &types.Manifest{CPUShares: 31, MemoryLimit: 100})
+	c.Assert(err, gocheck.ErrorMatches, "Not enough CPU Shares to reserve\\. \\(31 requested, 30 available\\)")
+	_, err = Reserve("second", &types.Manifest{CPUShares: 30, MemoryLimit: 549})
+	c.Assert(err, gocheck.ErrorMatches, "Not enough Memory to reserve\\. \\(549 requested, 548 available\\)")
+	_, cpu, memory := Nums()
+	c.Assert(cpu, gocheck.DeepEquals, &types.ResourceStats{Total: 150, Used: 120, Free: 30})
+	c.Assert(memory, gocheck.DeepEquals, &types.ResourceStats{Total: 2048, Used: 1500, Free: 548})
+	rawCPU, rawMemory := RawNums()
+	c.Assert(rawCPU, gocheck.DeepEquals, &types.ResourceStats{Total: 100, Used: 120, Free: 0})
+	c.Assert(rawMemory, gocheck.DeepEquals, &types.ResourceStats{Total: 1024, Used: 1500, Free: 0})
+	CPUOvercommit = -1
+	c.Assert(Init("localhost", saveDir, uint16(3), uint16(2), uint16(61000), 100, 1024, false), gocheck.NotNil)
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestPortPools(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	SSHPortRange = types.PortRange{Min: 62000, Max: 62099}
+	ExcludedPorts = []uint16{61001, 62000}
+	defer func() { SSHPortRange, ExcludedPorts = types.PortRange{}, nil }()
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	c.Assert(PortPools(), gocheck.DeepEquals, &types.PortPools{Primary: types.PortRange{Min: 61000, Max: 61002},
+		SSH: types.PortRange{Min: 62001, Max: 62002}, Secondary: types.PortRange{Min: 62003, Max: 62006},
+		Excluded: []uint16{61001, 62000}})
+	_, err := Reserve("first", &types.Manifest{CPUShares: 1, MemoryLimit: 1})
+	c.Assert(err, gocheck.IsNil)
+	second, err := Reserve("second", &types.Manifest{CPUShares: 1, MemoryLimit: 1})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(second.PrimaryPort, gocheck.Equals, uint16(61002))
+	c.Assert(second.SSHPort, gocheck.Equals, uint16(62002))
+	c.Assert(second.SecondaryPorts, gocheck.DeepEquals, []uint16{62004, 62006})
+	c.Assert(Teardown("first"), gocheck.Equals, true)
+	_, free := List()
+	c.Assert(free, gocheck.DeepEquals, []uint16{61000})
+	// a range too small for the pool once the excluded ports are taken out
+	SSHPortRange = types.PortRange{Min: 62000, Max: 62001}
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.ErrorMatches,
+		"Invalid Config\\. Not enough ssh ports\\. \\(2 needed, 1 available\\)")
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestCheckPortRanges(c *gocheck.C) {
+	settings := &Settings{SSHPortRange: types.PortRange{Min: 62000, Max: 62099}, ExcludedPorts: []uint16{62000}}
+	c.Assert(CheckPortRanges(settings, 61000, 2, 2), gocheck.IsNil)
+	settings.SecondaryPortRange = types.PortRange{Min: 62050, Max: 62199}
+	c.Assert(CheckPortRanges(settings, 61000, 2, 2), gocheck.ErrorMatches,
+		"Invalid Config\\. The ssh port range 62000-62099 overlaps the secondary port range 62050-62199\\.")
+	settings.SecondaryPortRange = types.PortRange{Min: 62100, Max: 62102}
+	c.Assert(CheckPortRanges(settings, 61000, 2, 2), gocheck.ErrorMatches,
+		"Invalid Config\\. Not enough secondary ports\\. \\(4 needed, 3 available\\)")
+}
+
+func (s *ContainersSuite) TestResize(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	_, err := Reserve("first", &types.Manifest{CPUShares: 10, MemoryLimit: 100, MemorySwap: 600})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(Resize("first", 20, 500), gocheck.IsNil)
+	resized := Get("first")
+	c.Assert(resized.Manifest.CPUShares, gocheck.Equals, uint(20))
+	c.Assert(resized.Manifest.MemoryLimit, gocheck.Equals, uint(500))
+	_, cpu, memory := Nums()
+	c.Assert(cpu.Used, gocheck.Equals, uint(20))
+	c.Assert(memory.Used, gocheck.Equals, uint(500))
+	// shrinking one resource leaves the other alone
+	c.Assert(Resize("first", 0, 200), gocheck.IsNil)
+	c.Assert(Get("first").Manifest.CPUShares, gocheck.Equals, uint(20))
+	c.Assert(Resize("first", 0, 700), gocheck.ErrorMatches, "Memory Limit can not be more than Memory Swap.*")
+	c.Assert(Resize("first", 101, 0), gocheck.ErrorMatches,
+		"Not enough CPU Shares to reserve\\. \\(81 more requested, 80 available\\)")
+	c.Assert(Resize("unknown", 1, 0), gocheck.ErrorMatches, "Unknown Container\\.")
+	_, cpu, memory = Nums()
+	c.Assert(cpu.Used, gocheck.Equals, uint(20))
+	c.Assert(memory.Used, gocheck.Equals, uint(200))
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestCheckpoint(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	_, err := Reserve("first", &types.Manifest{CPUShares: 1, MemoryLimit: 1})
+	c.Assert(err, gocheck.IsNil)
+	_, err = Checkpoint("first", "warm", true)
+	c.Assert(err, gocheck.ErrorMatches, "Checkpoints are not enabled\\.")
+	EnableCheckpoints = true
+	defer func() { EnableCheckpoints = false }()
+	c.Assert(RestoreCheckpoint("first", ""), gocheck.ErrorMatches, "The container has no checkpoint to restore\\.")
+	name, err := Checkpoint("first", "warm", true)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(name, gocheck.Equals, "warm")
+	checkpointed := Get("first")
+	c.Assert(checkpointed.Checkpoint, gocheck.Equals, "warm")
+	c.Assert(checkpointed.RunState, gocheck.Equals, types.ContainerCheckpointed)
+	// the exit caused by the checkpoint does not count as one
+	reportRunState("first", false, 137)
+	c.Assert(Get("first").RunState, gocheck.Equals, types.ContainerCheckpointed)
+	c.Assert(RestoreCheckpoint("first", ""), gocheck.IsNil)
+	c.Assert(Get("first").RunState, gocheck.Equals, types.ContainerRunning)
+	c.Assert(RestoreCheckpoint("first", ""), gocheck.ErrorMatches, "Only containers stopped by a checkpoint can be restored\\.")
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestTTL(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	ephemeral, err := Reserve("ephemeral", &types.Manifest{CPUShares: 1, MemoryLimit: 1})
+	c.Assert(err, gocheck.IsNil)
+	ephemeral.ExpiresAt = time.Now().Add(time.Hour)
+	_, err = Reserve("kept", &types.Manifest{CPUShares: 1, MemoryLimit: 1})
+	c.Assert(err, gocheck.IsNil)
+	ttl := Get("ephemeral").TTL
+	c.Assert(ttl > 3590 && ttl <= 3600, gocheck.Equals, true)
+	c.Assert(Get("kept").TTL, gocheck.Equals, uint(0))
+	expire(time.Now())
+	c.Assert(Get("ephemeral"), gocheck.NotNil)
+	before := time.Now()
+	expire(time.Now().Add(2 * time.Hour))
+	c.Assert(Get("ephemeral"), gocheck.IsNil)
+	c.Assert(Get("kept"), gocheck.NotNil)
+	c.Assert(Events("ephemeral", before)[0].Type, gocheck.Equals, types.EventExpired)
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestInventory(c *gocheck.C) {
+	defer func(command string, retries uint, delay time.Duration) {
+		InventoryCommand, InventoryRetries, InventoryRetryDelay = command, retries, delay
+	}(InventoryCommand, InventoryRetries, InventoryRetryDelay)
+	hostname, _ := os.Hostname()
+	InventoryCommand = "echo -I {{.Hostname}}"
+	status := runInventory()
+	c.Assert(status.Command, gocheck.DeepEquals, []string{"echo", "-I", hostname})
+	c.Assert(status.Attempts, gocheck.Equals, uint(1))
+	c.Assert(status.Error, gocheck.Equals, "")
+	c.Assert(status.Output, gocheck.Equals, "-I "+hostname+"\n")
+	InventoryCommand, InventoryRetries, InventoryRetryDelay = "false", 2, 0
+	status = runInventory()
+	c.Assert(status.Attempts, gocheck.Equals, uint(3))
+	c.Assert(status.Error, gocheck.Not(gocheck.Equals), "")
+	InventoryCommand = "cmk_admin -I {{.Host}}"
+	c.Assert(runInventory().Error, gocheck.Matches, "invalid inventory command .*")
+}
+
+func (s *ContainersSuite) TestRootless(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	docker.Rootless = true
+	defer func() { docker.Rootless = false }()
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, true), gocheck.ErrorMatches,
+		"Invalid Config\\. Network security needs root.*")
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(80), 100, 1024, false), gocheck.ErrorMatches,
+		"Invalid Config\\. A rootless runtime can not publish port 80, ports start at 1024")
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestIPv6SSHHost(c *gocheck.C) {
+	LocalSSHHost = "[::1]"
+	defer func() { LocalSSHHost = "localhost" }()
+	args := containerSSHArgs(&types.Container{SSHPort: 61002}, "true")
+	c.Assert(args[len(args)-2], gocheck.Equals, "root@::1")
+	c.Assert(types.HostPort("[::1]", 22), gocheck.Equals, "[::1]:22")
+	c.Assert(types.HostPort("2001:db8::1", 22), gocheck.Equals, "[2001:db8::1]:22")
+	c.Assert(types.HostPort("localhost", 22), gocheck.Equals, "localhost:22")
+}
+
+func (s *ContainersSuite) TestReload(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	defer func() {
+		SSHPortRange, CPUOvercommit, MemoryOvercommit, DefaultRestartPolicy = types.PortRange{}, 0, 0, nil
+		InventoryCommand, InventoryRetries = DefaultInventoryCommand, 3
+	}()
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	_, err := Reserve("first", &types.Manifest{CPUShares: 100, MemoryLimit: 1})
+	c.Assert(err, gocheck.IsNil)
+	settings := &Settings{SSHPortRange: types.PortRange{Min: 62000, Max: 62099}, CPUOvercommit: 2,
+		InventoryCommand: "true", CheckScriptsDir: "/check_mk_checks"}
+	c.Assert(Reload(settings), gocheck.IsNil)
+	c.Assert(PortPools().SSH, gocheck.Equals, types.PortRange{Min: 62000, Max: 62001})
+	c.Assert(InventoryCommand, gocheck.Equals, "true")
+	second, err := Reserve("second", &types.Manifest{CPUShares: 100, MemoryLimit: 1})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(second.SSHPort, gocheck.Equals, uint16(62001))
+	// nothing is applied if any setting is invalid
+	c.Assert(Reload(&Settings{SSHPortRange: types.PortRange{Min: 62000, Max: 62000}}), gocheck.ErrorMatches,
+		"Invalid Config\\. Not enough ssh ports\\. \\(2 needed, 1 available\\)")
+	c.Assert(PortPools().SSH, gocheck.Equals, types.PortRange{Min: 62000, Max: 62001})
+	c.Assert(Reload(&Settings{DefaultRestartPolicy: &types.RestartPolicy{Name: "sometimes"}}), gocheck.NotNil)
+	_, cpu, _ := Nums()
+	c.Assert(cpu.Total, gocheck.Equals, uint(200))
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestRunStateEvents(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	DefaultRestartPolicy = &types.RestartPolicy{Name: "sometimes"}
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.ErrorMatches,
+		"Invalid Config\\. Default restart policy .*")
+	DefaultRestartPolicy = nil
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	_, err := Reserve("first", &types.Manifest{CPUShares: 1, MemoryLimit: 1})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(Get("first").RunState, gocheck.Equals, "")
+	start := time.Now()
+	reportRunState("first", false, 137)
+	exited := Get("first")
+	c.Assert(exited.RunState, gocheck.Equals, types.ContainerExited)
+	c.Assert(exited.LastExitCode, gocheck.Equals, 137)
+	c.Assert(exited.ExitedAt.Before(start), gocheck.Equals, false)
+	c.Assert(Restart("first", 137, "exited with code 137"), gocheck.IsNil)
+	c.Assert(Get("first").RunState, gocheck.Equals, types.ContainerRunning)
+	restarted := Events("first", start)
+	c.Assert(restarted, gocheck.HasLen, 1)
+	c.Assert(restarted[0].Type, gocheck.Equals, types.EventRestarted)
+	c.Assert(restarted[0].Message, gocheck.Equals, "exited with code 137")
+	c.Assert(Events("second", start), gocheck.HasLen, 0)
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestSSHCA(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	SSHCAKey = "/etc/atlantis/supervisor/ssh_ca"
+	defer func() {
+		SSHCAKey, SSHCertTTL = "", DefaultSSHCertTTL
+	}()
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	c.Assert(SSHCAEnabled(), gocheck.Equals, true)
+	cont, err := Reserve("first", &types.Manifest{CPUShares: 1, MemoryLimit: 1})
+	c.Assert(err, gocheck.IsNil)
+	cert, err := IssueSSHCert(cont, "alice", "ssh-ed25519 AAAA alice")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cert.KeyID, gocheck.Equals, "alice@first")
+	c.Assert(cert.ValidBefore.After(time.Now().Add(DefaultSSHCertTTL-time.Minute)), gocheck.Equals, true)
+	_, err = IssueSSHCert(cont, "alice", "ssh-ed25519 BBBB alice")
+	c.Assert(err, gocheck.IsNil)
+	_, err = IssueSSHCert(cont, "bob", "ssh-ed25519 CCCC bob")
+	c.Assert(err, gocheck.IsNil)
+	// the certificates survive a restart
+	c.Assert(loadSSHCerts(), gocheck.IsNil)
+	c.Assert(sshCerts, gocheck.HasLen, 3)
+	revoked, err := RevokeSSHCerts(cont, "alice")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(revoked, gocheck.Equals, 2)
+	revoked, err = RevokeSSHCerts(cont, "alice")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(revoked, gocheck.Equals, 0)
+	// expired certificates are forgotten the next time the list is saved
+	SSHCertTTL = -time.Second
+	_, err = IssueSSHCert(cont, "carol", "ssh-ed25519 DDDD carol")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(loadSSHCerts(), gocheck.IsNil)
+	c.Assert(sshCerts, gocheck.HasLen, 3)
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestSSHAudit(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	defer func() { SSHAuditRetention = DefaultSSHAuditRetention }()
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	key := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKAMJ/I9GheQExHhApxQoJ9jSCHKLHHSM5QHfaKarBPX test"
+	c.Assert(SSHKeyFingerprint(key), gocheck.Equals, "SHA256:agJoCMTugcFyNb/FMaei2j8e4cVxDzXuZoLsMXi2Lds")
+	c.Assert(SSHKeyFingerprint("not a key"), gocheck.Equals, "")
+	audit := func(action, user string) {
+		c.Assert(AuditSSHAccess(&types.SSHAccessRecord{ContainerID: "first", Action: action, User: user,
+			Fingerprint: SSHKeyFingerprint(key), Requester: "security"}), gocheck.IsNil)
+	}
+	audit(types.SSHAccessAuthorized, "alice")
+	audit(types.SSHAccessAuthorized, "bob")
+	audit(types.SSHAccessDeauthorized, "alice")
+	records, grants := SSHAudit("first", "", time.Time{}, time.Time{})
+	c.Assert(records, gocheck.HasLen, 3)
+	c.Assert(grants, gocheck.HasLen, 2)
+	c.Assert(grants[0].User, gocheck.Equals, "alice")
+	c.Assert(grants[0].Until.Equal(records[2].Time), gocheck.Equals, true)
+	c.Assert(grants[1].User, gocheck.Equals, "bob")
+	c.Assert(grants[1].Until.IsZero(), gocheck.Equals, true)
+	records, grants = SSHAudit("second", "", time.Time{}, time.Time{})
+	c.Assert(records, gocheck.HasLen, 0)
+	c.Assert(grants, gocheck.HasLen, 0)
+	// who could get in during a month after the changes only finds the access that was never taken away
+	for i, day := range []int{3, 4, 10} {
+		sshAudit[i].Time = time.Date(2026, time.May, day, 12, 0, 0, 0, time.UTC)
+	}
+	june := time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC)
+	records, grants = SSHAudit("first", "", june, june.AddDate(0, 1, 0))
+	c.Assert(records, gocheck.HasLen, 0)
+	c.Assert(grants, gocheck.HasLen, 1)
+	c.Assert(grants[0].User, gocheck.Equals, "bob")
+	// old records are dropped, but not the authorization that still holds
+	SSHAuditRetention = time.Hour
+	audit(types.SSHAccessAuthorized, "carol")
+	c.Assert(loadSSHAudit(), gocheck.IsNil)
+	records, _ = SSHAudit("", "", time.Time{}, time.Time{})
+	c.Assert(records, gocheck.HasLen, 2)
+	c.Assert(records[0].User, gocheck.Equals, "bob")
+	c.Assert(records[1].User, gocheck.Equals, "carol")
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestStaticIPs(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	StaticIPNetwork, StaticIPRange = "macvlan0", "10.1.2.0/30"
+	defer func() { StaticIPNetwork, StaticIPRange = "", "" }()
+	c.Assert(Init("localhost", saveDir, uint16(4), uint16(2), uint16(61000), 4, 1024, false), gocheck.IsNil)
+	static := &types.Manifest{CPUShares: 1, MemoryLimit: 100, NetworkMode: "macvlan0"}
+	first, err := Reserve("first", static)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(first.IP, gocheck.Equals, "10.1.2.1")
+	second, err := Reserve("second", static)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(second.IP, gocheck.Equals, "10.1.2.2")
+	// the broadcast address is never handed out
+	_, err = Reserve("third", static)
+	c.Assert(err, gocheck.ErrorMatches, "No free IPs to reserve on macvlan0\\. \\(all of 10\\.1\\.2\\.0/30 is in use\\)")
+	bridged, err := Reserve("bridged", &types.Manifest{CPUShares: 1, MemoryLimit: 100})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(bridged.IP, gocheck.Equals, "")
+	c.Assert(Teardown("first"), gocheck.Equals, true)
+	third, err := Reserve("third", static)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(third.IP, gocheck.Equals, "10.1.2.1")
+	save(second.ID)
+	save(third.ID)
+	// the allocated IPs survive a supervisor restart
+	c.Assert(Init("localhost", saveDir, uint16(4), uint16(2), uint16(61000), 4, 1024, false), gocheck.IsNil)
+	c.Assert(Get("second").IP, gocheck.Equals, "10.1.2.2")
+	c.Assert(Get("third").IP, gocheck.Equals, "10.1.2.1")
+	StaticIPRange = "2001:db8::/64"
+	c.Assert(Init("localhost", saveDir, uint16(4), uint16(2), uint16(61000), 4, 1024, false), gocheck.ErrorMatches,
+		"Invalid Config\\. the static IP range should be an IPv4 CIDR, got \"2001:db8::/64\"")
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestDNSRegistration(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	hostsFile := filepath.Join(saveDir, "hosts")
+	DNSRegistrar = &dns.DnsmasqRegistrar{HostsFile: hostsFile}
+	DNSDomain, DNSAddress = "containers.example.com.", "192.0.2.1"
+	StaticIPNetwork, StaticIPRange = "macvlan0", "10.1.2.0/30"
+	defer func() {
+		DNSRegistrar, DNSDomain, DNSAddress = nil, "", ""
+		StaticIPNetwork, StaticIPRange = "", ""
+	}()
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	bridged, err := Reserve("Bridged", &types.Manifest{CPUShares: 1, MemoryLimit: 100})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(bridged.Deploy("host", "app", "sha", "env", nil, nil), gocheck.IsNil)
+	static, err := Reserve("static", &types.Manifest{CPUShares: 1, MemoryLimit: 100, NetworkMode: "macvlan0"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(static.Deploy("host", "app", "sha", "env", nil, nil), gocheck.IsNil)
+	hosts, err := ioutil.ReadFile(hostsFile)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(string(hosts), gocheck.Equals,
+		"192.0.2.1 bridged.containers.example.com\n10.1.2.1 static.containers.example.com\n")
+	c.Assert(Teardown("Bridged"), gocheck.Equals, true)
+	// the record is removed in the background
+	for i := 0; i < 100; i++ {
+		if hosts, _ = ioutil.ReadFile(hostsFile); !strings.Contains(string(hosts), "bridged") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(string(hosts), gocheck.Equals, "10.1.2.1 static.containers.example.com\n")
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestDiscovery(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	announced := make(chan string, 10)
+	etcd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		key, _ := base64.StdEncoding.DecodeString(body["key"])
+		value, _ := base64.StdEncoding.DecodeString(body["value"])
+		announced <- strings.TrimPrefix(r.URL.Path, "/v3/kv/") + " " + string(key) + " " + string(value)
+	}))
+	defer etcd.Close()
+	announcer, err := discovery.New(&discovery.Config{Backend: discovery.Etcd, Endpoints: []string{etcd.URL}})
+	c.Assert(err, gocheck.IsNil)
+	Announcer = announcer
+	defer func() { Announcer = nil }()
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	container, err := Reserve("first", &types.Manifest{CPUShares: 1, MemoryLimit: 100})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(container.Deploy("host", "app", "sha", "env", nil, nil), gocheck.IsNil)
+	c.Assert(<-announced, gocheck.Equals, `put /atlantis/instances/app/env/first {"id":"first","app":"app",`+
+		`"sha":"sha","env":"env","host":"host","address":"host","primaryPort":61000,"sshPort":61002,`+
+		`"secondaryPorts":[61004,61006]}`)
+	// the containers are announced again when the supervisor restarts
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	c.Assert(<-announced, gocheck.Matches, "put /atlantis/instances/app/env/first .*")
+	c.Assert(Teardown("first"), gocheck.Equals, true)
+	c.Assert(<-announced, gocheck.Equals, "deleterange /atlantis/instances/app/env/first ")
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestProxy(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	// stands in for the app listening on its primary port
+	app, err := net.Listen("tcp", "127.0.0.1:61000")
+	c.Assert(err, gocheck.IsNil)
+	defer app.Close()
+	go func() {
+		for {
+			conn, err := app.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				conn.Write([]byte("pong " + line))
+			}()
+		}
+	}()
+	manifest := &types.Manifest{CPUShares: 1, MemoryLimit: 100, ProxyPort: 61900}
+	container, err := ReserveInstance("first", "app", "sha", manifest, "")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(container.Deploy("host", "app", "sha", "env", nil, nil), gocheck.IsNil)
+	conn, err := net.Dial("tcp", "127.0.0.1:61900")
+	c.Assert(err, gocheck.IsNil)
+	conn.Write([]byte("ping\n"))
+	reply, err := ioutil.ReadAll(conn)
+	conn.Close()
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(string(reply), gocheck.Equals, "pong ping\n")
+	// the port belongs to the app until its last container is torn down
+	_, err = ReserveInstance("second", "other", "sha", manifest, "")
+	c.Assert(err, gocheck.ErrorMatches, "Proxy port 61900 is taken\\. \\(it is forwarded to app\\)")
+	c.Assert(Teardown("first"), gocheck.Equals, true)
+	_, err = net.Dial("tcp", "127.0.0.1:61900")
+	c.Assert(err, gocheck.NotNil)
+	_, err = ReserveInstance("second", "other", "sha", manifest, "")
+	c.Assert(err, gocheck.IsNil)
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestSharedNetwork(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	StaticIPNetwork, StaticIPRange = "macvlan0", "10.1.2.0/30"
+	defer func() { StaticIPNetwork, StaticIPRange = "", "" }()
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	first, err := Reserve("first", &types.Manifest{CPUShares: 1, MemoryLimit: 100, NetworkMode: "macvlan0"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(first.Deploy("host", "app", "sha", "env", nil, nil), gocheck.IsNil)
+	manifest := &types.Manifest{CPUShares: 1, MemoryLimit: 100}
+	c.Assert(CheckNetworkOf("nope", manifest), gocheck.ErrorMatches, "there is no container nope to share the network of")
+	c.Assert(CheckNetworkOf("first", &types.Manifest{NetworkMode: "bridge"}), gocheck.ErrorMatches,
+		"a container joining another's network can not set its own network mode or proxy port")
+	c.Assert(CheckNetworkOf("first", manifest), gocheck.IsNil)
+	second, err := Reserve("second", manifest)
+	c.Assert(err, gocheck.IsNil)
+	second.NetworkOf = "first"
+	c.Assert(second.Deploy("host", "sidecar", "sha", "env", nil, nil), gocheck.IsNil)
+	c.Assert(second.IP, gocheck.Equals, "10.1.2.1")
+	// docker can't publish its ports, the supervisor forwards them into the shared namespace
+	proxyLock.Lock()
+	c.Assert(proxies[second.SSHPort].backends["second"], gocheck.Equals, fmt.Sprintf("10.1.2.1:%d", second.SSHPort))
+	proxyLock.Unlock()
+	c.Assert(CheckNetworkOf("second", manifest), gocheck.ErrorMatches,
+		"second shares the network of first, join that one instead")
+	// it goes down with the container whose network it shares
+	c.Assert(Teardown("first"), gocheck.Equals, true)
+	c.Assert(Get("second"), gocheck.IsNil)
+	proxyLock.Lock()
+	c.Assert(proxies[second.SSHPort], gocheck.IsNil)
+	proxyLock.Unlock()
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestTLSSidecar(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	TLSSidecarImage, TLSSidecarVersion = "tls-proxy", "1.0"
+	defer func() { TLSSidecarImage, TLSSidecarVersion = "", "" }()
+	c.Assert((&types.TLSTermination{}).Validate(), gocheck.IsNil)
+	c.Assert((&types.TLSTermination{Cert: "vault:secret/app#cert"}).Validate(), gocheck.NotNil)
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	_, err := Reserve("named", &types.Manifest{CPUShares: 1, MemoryLimit: 100, TLS: &types.TLSTermination{},
+		Ports: []types.PortSpec{{Name: "workers", Count: 2}}})
+	c.Assert(err, gocheck.ErrorMatches, "No secondary port left to terminate TLS on\\. \\(all 2 are named ports\\)")
+	manifest := &types.Manifest{CPUShares: 1, MemoryLimit: 100, TLS: &types.TLSTermination{},
+		Ports: []types.PortSpec{{Name: "metrics"}}}
+	cont, err := Reserve("tls", manifest)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cont.TLSPort, gocheck.Equals, cont.SecondaryPorts[1])
+	c.Assert(cont.Deploy("host", "app", "sha", "env", nil, nil), gocheck.IsNil)
+	c.Assert(cont.Sidecars, gocheck.HasLen, 1)
+	sidecar := cont.Sidecars[0]
+	c.Assert(sidecar.ID, gocheck.Equals, "tls-tls")
+	c.Assert(sidecar.Spec.Image, gocheck.Equals, "tls-proxy")
+	c.Assert(sidecar.Spec.TLS, gocheck.NotNil)
+	c.Assert(sidecar.Spec.Env["TLS_PORT"], gocheck.Equals, fmt.Sprintf("%d", cont.TLSPort))
+	// the manifest itself is left alone
+	c.Assert(manifest.Sidecars, gocheck.HasLen, 0)
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestRestrictEgress(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	RestrictEgress = true
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.ErrorMatches,
+		"Invalid Config\\. Restricting egress needs network security enabled")
+	RestrictEgress = false
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	Nums() // network security is set up once the containerManager runs
+	c.Assert(NetworkSecurity.HostRules(), gocheck.HasLen, 0)
+	c.Assert(NetworkSecurity.UpdateIPGroup("db", []string{"10.0.0.6", "10.0.0.5"}), gocheck.IsNil)
+	c.Assert(NetworkSecurity.HostRules(), gocheck.DeepEquals, []string{
+		"iptables -A FORWARD -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT",
+		"iptables -A FORWARD -d 10.0.0.5 -j REJECT",
+		"iptables -A FORWARD -d 10.0.0.6 -j REJECT",
+	})
+	c.Assert(NetworkSecurity.ContainerRules(""), gocheck.HasLen, 0)
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestEgressDestinations(c *gocheck.C) {
+	c.Assert((&types.EgressPolicy{Allow: []string{"10.0.0.0/8", "db.internal:5432", "[2001:db8::1]:443"}}).Validate(),
+		gocheck.IsNil)
+	c.Assert((&types.EgressPolicy{Allow: []string{"10.0.0.0/8:0"}}).Validate(), gocheck.ErrorMatches,
+		"egress entry \"10.0.0.0/8:0\" has an invalid port")
+	c.Assert((&types.EgressPolicy{Allow: []string{"not a host"}}).Validate(), gocheck.ErrorMatches,
+		"egress entry \"not a host\" should be a CIDR, an IP or a domain name")
+	dests, err := egressDestinations(&types.Manifest{})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dests, gocheck.HasLen, 0)
+	dests, err = egressDestinations(&types.Manifest{Egress: &types.EgressPolicy{
+		Allow: []string{"10.0.0.0/8:5432", "10.1.2.3", "[2001:db8::1]:443"}, DefaultDeny: true}})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(dests, gocheck.DeepEquals, []netsec.Destination{{CIDR: "10.0.0.0/8", Port: 5432},
+		{CIDR: "10.1.2.3/32"}, {CIDR: "2001:db8::1/128", Port: 443}})
+}
+
+func (s *ContainersSuite) TestBindAddresses(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	addrs, err := ResolveBindAddresses([]string{"127.0.0.1", "lo", "10.0.0.5"})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(addrs, gocheck.DeepEquals, []string{"127.0.0.1", "10.0.0.5"})
+	_, err = ResolveBindAddresses([]string{"nope0"})
+	c.Assert(err, gocheck.ErrorMatches, "nope0 is neither an IP nor an interface")
+	BindAddresses, SSHBindAddresses = []string{"10.0.0.5"}, []string{"lo"}
+	defer func() { BindAddresses, SSHBindAddresses = nil, nil }()
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	cont, err := Reserve("bound", &types.Manifest{CPUShares: 1, MemoryLimit: 100})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cont.Deploy("host", "app", "sha", "env", nil, nil), gocheck.IsNil)
+	c.Assert(cont.BindAddrs, gocheck.DeepEquals, []string{"10.0.0.5"})
+	c.Assert(cont.SSHAddrs, gocheck.DeepEquals, []string{"127.0.0.1"})
+	// the ssh port follows the other ports unless it has addresses of its own
+	SSHBindAddresses = nil
+	c.Assert(cont.resolveBindAddresses(), gocheck.IsNil)
+	c.Assert(cont.SSHAddrs, gocheck.DeepEquals, []string{"10.0.0.5"})
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestNetworkTuning(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	tuning := &types.NetworkTuning{MTU: 1400, KeepaliveTime: 60, KeepaliveProbes: 3, ConntrackUDP: 10}
+	c.Assert(tuning.Validate(), gocheck.IsNil)
+	c.Assert((&types.NetworkTuning{MTU: 40}).Validate(), gocheck.ErrorMatches, "mtu 40 should be between 68 and 65535")
+	c.Assert((&types.NetworkTuning{KeepaliveProbes: 200}).Validate(), gocheck.NotNil)
+	c.Assert(tuning.Sysctls(), gocheck.DeepEquals, map[string]string{
+		"net.ipv4.tcp_keepalive_time":            "60",
+		"net.ipv4.tcp_keepalive_probes":          "3",
+		"net.netfilter.nf_conntrack_udp_timeout": "10",
+	})
+	manifest := &types.Manifest{CPUShares: 1, MemoryLimit: 100, Network: tuning}
+	dup := manifest.DeepCopy()
+	dup.Network.MTU = 9000
+	c.Assert(manifest.Network.MTU, gocheck.Equals, uint(1400))
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	cont, err := Reserve("tuned", manifest)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(cont.Deploy("host", "app", "sha", "env", nil, nil), gocheck.IsNil)
+	// the namespace belongs to the container that is joined, which is the one to tune
+	c.Assert(CheckNetworkOf("tuned", &types.Manifest{Network: tuning}), gocheck.ErrorMatches,
+		"a container joining another's network can not tune it, tune the one it joins")
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestStatsHistory(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	c.Assert(Init("localhost", saveDir, uint16(2), uint16(2), uint16(61000), 100, 1024, false), gocheck.IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(saveDir, StatsDir), 0755), gocheck.IsNil)
+	start := time.Unix(1400000000, 0)
+	for i := 0; i < 5; i++ {
+		sample := &types.StatsSample{Time: start.Add(time.Duration(i) * time.Minute), CPUTime: time.Duration(i),
+			MemoryBytes: 1024, RxBytes: 10, TxBytes: 20}
+		c.Assert(stats.Append(statsFile("a"), 3, sample), gocheck.IsNil)
+	}
+	c.Assert(stats.Append(statsFile("b"), 3, &types.StatsSample{Time: start}), gocheck.IsNil)
+	// only the last 3 samples of a are left, oldest first
+	history, err := StatsHistory("", time.Time{}, time.Time{})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(history["a"], gocheck.HasLen, 3)
+	c.Assert(history["b"], gocheck.HasLen, 1)
+	c.Assert(history["a"][0].CPUTime, gocheck.Equals, time.Duration(2))
+	c.Assert(history["a"][2].Time.Equal(start.Add(4*time.Minute)), gocheck.Equals, true)
+	c.Assert(history["a"][2].MemoryBytes, gocheck.Equals, uint64(1024))
+	history, err = StatsHistory("a", start.Add(3*time.Minute), start.Add(3*time.Minute))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(history["a"], gocheck.HasLen, 1)
+	c.Assert(history["a"][0].CPUTime, gocheck.Equals, time.Duration(3))
+	history, err = StatsHistory("", start.Add(time.Minute), time.Time{})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(history, gocheck.HasLen, 1)
+	history, err = StatsHistory("nope", time.Time{}, time.Time{})
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(history, gocheck.HasLen, 0)
+	_, err = StatsHistory("../a", time.Time{}, time.Time{})
+	c.Assert(err, gocheck.NotNil)
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestLogShipping(c *gocheck.C) {
+	c.Assert((&types.LogShipping{Sink: "syslog", Address: "tcp://logs:514", Facility: "local3"}).Validate(),
+		gocheck.IsNil)
+	c.Assert((&types.LogShipping{Sink: "syslog", Address: "sctp://logs:514"}).Validate(), gocheck.NotNil)
+	c.Assert((&types.LogShipping{Sink: "kafka", Address: "http://rest-proxy:8082"}).Validate(), gocheck.ErrorMatches,
+		"a topic is needed for kafka and only for kafka")
+	c.Assert((&types.LogShipping{Sink: "http", Address: "logs:80"}).Validate(), gocheck.NotNil)
+	c.Assert((&types.LogShipping{Sink: "http", Address: "https://logs/in", Streams: []string{"stdin"}}).Validate(),
+		gocheck.ErrorMatches, `unknown stream "stdin", use stdout or stderr`)
+	manifest := &types.Manifest{LogShipping: &types.LogShipping{Sink: "http", Address: "https://logs/in",
+		Fields: map[string]string{"team": "a"}}}
+	dup := manifest.DeepCopy()
+	dup.LogShipping.Fields["team"] = "b"
+	c.Assert(manifest.LogShipping.Fields["team"], gocheck.Equals, "a")
+
+	received := make(chan []*logship.Entry, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var entries []*logship.Entry
+		c.Check(json.NewDecoder(r.Body).Decode(&entries), gocheck.IsNil)
+		received <- entries
+	}))
+	defer server.Close()
+	sink, err := logship.New(&types.LogShipping{Sink: "http", Address: server.URL})
+	c.Assert(err, gocheck.IsNil)
+	shipped := time.Time{}
+	shipper := logship.NewShipper(sink, func(last time.Time) { shipped = last })
+	after := time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	meta := &logship.Entry{App: "app", Env: "prod", Container: "app-1"}
+	w := &logship.LogWriter{Shipper: shipper, Meta: meta, Stream: "stderr", After: &after}
+	// the first line was shipped before, the last one is only complete after the second write
+	w.Write([]byte("2014-05-01T12:00:00Z old\n2014-05-01T12:00:01.5Z first\n2014-05-01T12:00:02Z sec"))
+	w.Write([]byte("ond\n"))
+	shipper.Close()
+	entries := <-received
+	c.Assert(entries, gocheck.HasLen, 2)
+	c.Assert(entries[0].Message, gocheck.Equals, "first")
+	c.Assert(entries[1].Message, gocheck.Equals, "second")
+	c.Assert(entries[1].Container, gocheck.Equals, "app-1")
+	c.Assert(entries[1].Stream, gocheck.Equals, "stderr")
+	c.Assert(shipped.Equal(time.Date(2014, 5, 1, 12, 0, 2, 0, time.UTC)), gocheck.Equals, true)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, gocheck.IsNil)
+	defer conn.Close()
+	sink, err = logship.New(&types.LogShipping{Sink: "syslog", Address: conn.LocalAddr().String()})
+	c.Assert(err, gocheck.IsNil)
+	entries[0].Fields = map[string]string{"team": "a\"b"}
+	c.Assert(sink.Ship(entries[:1]), gocheck.IsNil)
+	sink.Close()
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(string(buf[:n]), gocheck.Equals, "<131>1 2014-05-01T12:00:01.500000Z - app app-1 stderr "+
+		`[atlantis@32473 env="prod" sha="" team="a\"b"] first`)
+
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	serialize.SaveDir = saveDir
+	c.Assert(shippedUntil("app-1").IsZero(), gocheck.Equals, true)
+	saveShippedUntil("app-1", shipped)
+	c.Assert(shippedUntil("app-1").Equal(shipped), gocheck.Equals, true)
+	forgetShippedUntil("app-1")
+	c.Assert(shippedUntil("app-1").IsZero(), gocheck.Equals, true)
+	os.RemoveAll(saveDir)
+}
+
+func (s *ContainersSuite) TestCapture(c *gocheck.C) {
+	captureDir := "capture_test"
+	os.RemoveAll(captureDir)
+	defer os.RemoveAll(captureDir)
+	CaptureDir = captureDir
+	defer func() { CaptureDir = "" }()
+	start := time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	// a line is 33 bytes with its time, so a log holds 2 lines and only the last 2 rotated logs are kept
+	sink := &logship.FileSink{Dir: filepath.Join(captureDir, "app-1"), MaxSize: 70, MaxFiles: 2}
+	for i := 0; i < 10; i++ {
+		stream := "stdout"
+		if i%4 == 3 {
+			stream = "stderr"
+		}
+		c.Assert(sink.Ship([]*logship.Entry{&logship.Entry{Time: start.Add(time.Duration(i) * time.Second),
+			Stream: stream, Message: fmt.Sprint(i)}}), gocheck.IsNil)
+	}
+	c.Assert(sink.Close(), gocheck.IsNil)
+	_, err := os.Stat(filepath.Join(captureDir, "app-1", "stdout.log.3"))
+	c.Assert(os.IsNotExist(err), gocheck.Equals, true)
+	c.Assert(logship.LastFileTime(sink.Dir).Equal(start.Add(9*time.Second)), gocheck.Equals, true)
+
+	lines, err := Logs("app-1", "", time.Time{}, 100)
+	c.Assert(err, gocheck.IsNil)
+	messages := []string{}
+	for _, line := range lines {
+		messages = append(messages, line.Message)
+	}
+	// stdout 0 and 1 fell off the end of the rotation
+	c.Assert(messages, gocheck.DeepEquals, []string{"2", "3", "4", "5", "6", "7", "8", "9"})
+	lines, err = Logs("app-1", "stderr", time.Time{}, 1)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(lines, gocheck.HasLen, 1)
+	c.Assert(lines[0].Message, gocheck.Equals, "7")
+	c.Assert(lines[0].Stream, gocheck.Equals, "stderr")
+	lines, err = Logs("app-1", "stdout", start.Add(5*time.Second), 100)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(lines, gocheck.HasLen, 4)
+	_, err = Logs("app-2", "", time.Time{}, 100)
+	c.Assert(err, gocheck.ErrorMatches, "no output of app-2 was captured")
+	_, err = Logs("..", "", time.Time{}, 100)
+	c.Assert(err, gocheck.NotNil)
+
+	// kept while the container exists or it wrote something lately
+	pruneCaptures(map[string]*types.Container{"app-1": &types.Container{}}, time.Now().Add(30*24*time.Hour))
+	pruneCaptures(nil, time.Now())
+	_, err = os.Stat(sink.Dir)
+	c.Assert(err, gocheck.IsNil)
+	pruneCaptures(nil, time.Now().Add(CaptureRetention+time.Hour))
+	_, err = os.Stat(sink.Dir)
+	c.Assert(os.IsNotExist(err), gocheck.Equals, true)
+}
@@ -0,0 +1,69 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/discovery"
+	"log"
+)
+
+var Announcer discovery.Announcer // set before Init. nil to not announce containers to a service registry
+
+// Returns the container as the service registry sees it
+func instance(c *Container) *discovery.Instance {
+	address := c.Host
+	if needsStaticIP(c.Manifest) && c.IP != "" {
+		address = c.IP
+	}
+	return &discovery.Instance{ID: c.ID, App: c.App, Sha: c.Sha, Env: c.Env, Host: c.Host, Address: address,
+		PrimaryPort: c.PrimaryPort, SSHPort: c.SSHPort, SecondaryPorts: c.SecondaryPorts,
+		NamedPorts: c.NamedPorts, Labels: c.Labels}
+}
+
+func announce(c *Container) {
+	if Announcer == nil {
+		return
+	}
+	if err := Announcer.Announce(instance(c)); err != nil {
+		log.Printf("[discovery] could not announce %s: %v", c.ID, err)
+	}
+}
+
+func withdraw(c *Container) {
+	if Announcer == nil {
+		return
+	}
+	if err := Announcer.Withdraw(instance(c)); err != nil {
+		log.Printf("[discovery] could not withdraw %s: %v", c.ID, err)
+	}
+}
+
+// Announce the containers again, in case the registry lost them while the supervisor was down
+func announceAll() {
+	if Announcer == nil {
+		return
+	}
+	instances := []*discovery.Instance{}
+	for _, cont := range containers {
+		if cont.App != "" {
+			// copied, since the manager may change the labels while they are being sent
+			instances = append(instances, instance(&Container{*cont.Container.DeepCopy()}))
+		}
+	}
+	go func() {
+		for _, i := range instances {
+			if err := Announcer.Announce(i); err != nil {
+				log.Printf("[discovery] could not announce %s: %v", i.ID, err)
+			}
+		}
+	}()
+}
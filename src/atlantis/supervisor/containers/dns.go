@@ -0,0 +1,80 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/dns"
+	"errors"
+	"log"
+	"net"
+	"strings"
+)
+
+var (
+	DNSRegistrar dns.Registrar // set before Init. nil to not register containers in DNS
+	DNSDomain    string        // set before Init. containers are registered as <container-id>.<DNSDomain>
+	DNSAddress   string        // set before Init. address of containers without a static IP, empty for the host's
+)
+
+// Returns the name the container is registered as
+func dnsName(c *Container) string {
+	return strings.ToLower(c.ID) + "." + strings.Trim(DNSDomain, ".")
+}
+
+// Returns the address the container's name points to. Containers on the static IP network can be reached
+// directly, the rest through the ports published on the host.
+func dnsAddress(c *Container) (string, error) {
+	if needsStaticIP(c.Manifest) && c.IP != "" {
+		return c.IP, nil
+	}
+	if DNSAddress != "" {
+		return DNSAddress, nil
+	}
+	addrs, err := net.LookupHost(c.Host)
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip.To4() != nil && !ip.IsLoopback() {
+			return addr, nil
+		}
+	}
+	return "", errors.New("no IPv4 address for " + c.Host)
+}
+
+func registerDNS(c *Container) {
+	if DNSRegistrar == nil {
+		return
+	}
+	address, err := dnsAddress(c)
+	if err == nil {
+		err = DNSRegistrar.Register(dnsName(c), address)
+	}
+	if err != nil {
+		log.Printf("[dns] could not register %s: %v", dnsName(c), err)
+		return
+	}
+	log.Printf("[dns] registered %s -> %s", dnsName(c), address)
+}
+
+func deregisterDNS(c *Container) {
+	if DNSRegistrar == nil {
+		return
+	}
+	address, err := dnsAddress(c)
+	if err == nil {
+		err = DNSRegistrar.Deregister(dnsName(c), address)
+	}
+	if err != nil {
+		log.Printf("[dns] could not deregister %s: %v", dnsName(c), err)
+	}
+}
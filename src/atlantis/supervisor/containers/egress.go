@@ -0,0 +1,56 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/netsec"
+	"atlantis/supervisor/rpc/types"
+	"fmt"
+	"net"
+)
+
+// Resolves the allow entries of the manifest's egress policy to the destinations network security lets through.
+// Domain names are resolved now; the container keeps the addresses they had until it is redeployed.
+func egressDestinations(manifest *types.Manifest) ([]netsec.Destination, error) {
+	if manifest.Egress == nil {
+		return nil, nil
+	}
+	dests := []netsec.Destination{}
+	for _, entry := range manifest.Egress.Allow {
+		host, port, err := types.ParseEgressEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err := net.ParseCIDR(host); err == nil {
+			dests = append(dests, netsec.Destination{CIDR: host, Port: port})
+			continue
+		}
+		ips := []net.IP{net.ParseIP(host)}
+		if ips[0] == nil {
+			if ips, err = net.LookupIP(host); err != nil {
+				return nil, fmt.Errorf("Could not resolve egress %s: %v", host, err)
+			}
+		}
+		for _, ip := range ips {
+			dests = append(dests, netsec.Destination{CIDR: hostCIDR(ip), Port: port})
+		}
+	}
+	return dests, nil
+}
+
+// Returns the CIDR of the single address ip
+func hostCIDR(ip net.IP) string {
+	if ip.To4() != nil {
+		return ip.String() + "/32"
+	}
+	return ip.String() + "/128"
+}
@@ -0,0 +1,53 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+var MaxEvents = 1000 // oldest events are dropped past this
+
+var (
+	eventsLock = sync.Mutex{}
+	events     = []*types.ContainerEvent{}
+)
+
+// Record something that happened to the container on its own
+func emit(id, eventType, format string, args ...interface{}) {
+	event := &types.ContainerEvent{Time: time.Now(), ContainerID: id, Type: eventType,
+		Message: fmt.Sprintf(format, args...)}
+	log.Printf("[event] %s %s: %s", id, eventType, event.Message)
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+	events = append(events, event)
+	if len(events) > MaxEvents {
+		events = append([]*types.ContainerEvent{}, events[len(events)-MaxEvents:]...)
+	}
+}
+
+// Return the recorded events after since, oldest first. Only the events of id if it is not empty.
+func Events(id string, since time.Time) []*types.ContainerEvent {
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+	matching := []*types.ContainerEvent{}
+	for _, event := range events {
+		if (id == "" || event.ContainerID == id) && event.Time.After(since) {
+			matching = append(matching, event)
+		}
+	}
+	return matching
+}
@@ -0,0 +1,122 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/docker"
+	"atlantis/supervisor/rpc/types"
+	"log"
+	"time"
+)
+
+type DockerEventReq struct {
+	status   string
+	dockerID string
+}
+
+// Handle an event from the docker event stream. Supervisor state is updated to match what docker reports, and
+// everything is reconciled against docker when the stream is (re)subscribed since events may have been missed.
+func DockerEvent(status, dockerID string) {
+	if status == docker.EventsSubscribed {
+		go reconcile()
+		return
+	}
+	dockerEventChan <- &DockerEventReq{status, dockerID}
+}
+
+// Check every container against docker, catching what happened while nobody was listening (e.g. the daemon
+// restarted).
+func reconcile() {
+	conts, _ := List()
+	for _, cont := range conts {
+		running, _, err := docker.State(cont)
+		switch {
+		case err == nil && running:
+			DockerEvent(docker.EventStart, cont.DockerID)
+		case err == nil:
+			DockerEvent(docker.EventDie, cont.DockerID)
+		case docker.IsNoSuchContainer(err):
+			DockerEvent(docker.EventDestroy, cont.DockerID)
+		default:
+			log.Printf("[reconcile] could not get state of %s: %v", cont.ID, err)
+		}
+	}
+}
+
+func dockerEvent(req *DockerEventReq) {
+	if req.dockerID == "" {
+		return
+	}
+	if req.status == docker.EventOOM {
+		recordOOM(req.dockerID)
+		return
+	}
+	for _, container := range containers {
+		if container.DockerID != req.dockerID {
+			continue
+		}
+		switch req.status {
+		case docker.EventStart:
+			if container.RunState == types.ContainerRunning && container.Discrepancy == "" {
+				return
+			}
+			container.RunState = types.ContainerRunning
+			container.Discrepancy = ""
+		case docker.EventDie:
+			if container.RunState == types.ContainerExited || container.RunState == types.ContainerCheckpointed {
+				return
+			}
+			container.RunState = types.ContainerExited
+			container.ExitedAt = time.Now()
+		case docker.EventDestroy:
+			if container.Discrepancy == types.DiscrepancyRemoved {
+				return
+			}
+			// the supervisor removes containers after freeing them, so this one went behind its back
+			container.RunState = types.ContainerExited
+			container.Discrepancy = types.DiscrepancyRemoved
+			emit(container.ID, types.EventRemoved, "docker container %s was removed outside the supervisor",
+				container.DockerID)
+		default:
+			return
+		}
+		save(container.ID)
+		return
+	}
+}
+
+func recordOOM(dockerID string) {
+	if dockerID == "" {
+		return
+	}
+	for _, container := range containers {
+		if container.DockerID == dockerID {
+			log.Printf("[oom] %s: process killed for running out of memory", container.ID)
+			oomKilled(container)
+			return
+		}
+		for _, sidecar := range container.Sidecars {
+			if sidecar.DockerID == dockerID {
+				log.Printf("[oom] %s: process killed for running out of memory", sidecar.ID)
+				sidecar.OOMKills++
+				oomKilled(container)
+				return
+			}
+		}
+	}
+}
+
+func oomKilled(container *Container) {
+	container.OOMKills++
+	container.LastOOMKill = time.Now()
+	save(container.ID)
+}
@@ -0,0 +1,106 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"log"
+	"sort"
+)
+
+type ImportReq struct {
+	records  map[string]*types.Container
+	respChan chan *ImportResp
+}
+
+type ImportResp struct {
+	ids []string
+	err error
+}
+
+// Replace the saved records of the given containers, for fixing up records that no longer match reality. A nil
+// record drops the container from the supervisor without tearing down its docker container, and a record for an
+// unknown container adopts it on the port it names. The resulting state is checked as a whole before anything is
+// changed. Returns the ids of the records that were changed.
+func ImportState(records map[string]*types.Container) ([]string, error) {
+	respChan := make(chan *ImportResp)
+	req := &ImportReq{records, respChan}
+	importChan <- req
+	resp := <-respChan
+	close(respChan)
+	return resp.ids, resp.err
+}
+
+func importState(req *ImportReq) {
+	merged := make(map[string]*types.Container, len(containers)+len(req.records))
+	for id, cont := range containers {
+		merged[id] = &cont.Container
+	}
+	for id, record := range req.records {
+		if record == nil {
+			delete(merged, id)
+		} else {
+			merged[id] = record
+		}
+	}
+	// keep the order of the free ports, adding the ones freed up at the end
+	used := map[uint16]bool{}
+	for _, cont := range merged {
+		if slot, ok := portSlot(cont.PrimaryPort); ok {
+			used[slot] = true
+		}
+	}
+	freePorts := []uint16{}
+	listed := map[uint16]bool{}
+	for _, port := range ports {
+		if !used[port] {
+			freePorts = append(freePorts, port)
+		}
+		listed[port] = true
+	}
+	for id := range req.records {
+		if cont := containers[id]; cont != nil {
+			if slot, ok := portSlot(cont.PrimaryPort); ok && !used[slot] && !listed[slot] {
+				freePorts = append(freePorts, slot)
+			}
+		}
+	}
+	if err := checkState(merged, freePorts); err != nil {
+		req.respChan <- &ImportResp{err: err}
+		return
+	}
+	ids := make([]string, 0, len(req.records))
+	for id, record := range req.records {
+		stopProbes(id)
+		stopLogShipping(id)
+		stopCapture(id)
+		stopWatch(id)
+		stopSidecarWatch(id)
+		if record == nil {
+			delete(containers, id)
+		} else {
+			containers[id] = &Container{Container: *record}
+			startProbes(containers[id])
+			startLogShipping(containers[id])
+			startCapture(containers[id])
+			startWatch(containers[id])
+			startSidecarWatch(containers[id])
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	ports = freePorts
+	countResources()
+	save(ids...)
+	log.Printf("[import] replaced the records of %v", ids)
+	req.respChan <- &ImportResp{ids: ids}
+}
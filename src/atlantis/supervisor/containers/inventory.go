@@ -0,0 +1,128 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/containers/serialize"
+	"atlantis/supervisor/rpc/types"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	StatusBucket            = "status"
+	inventoryStatusKey      = "inventory"
+	MaxInventoryOutput      = 4096
+	DefaultInventoryCommand = "cmk_admin -I"
+)
+
+// check_mk is re-inventoried in the background after containers are deployed or torn down so its services match
+// them. Requests made while an inventory is running are coalesced into one more run after it.
+var (
+	// set before Init. a text/template with .Hostname, split into arguments on whitespace. empty to never
+	// re-inventory.
+	InventoryCommand    = DefaultInventoryCommand
+	InventoryRetries    = uint(3) // set before Init. attempts after the first one fails
+	InventoryRetryDelay = 10 * time.Second
+	inventoryChan       = make(chan bool, 1)
+	inventoryOnce       sync.Once
+)
+
+type inventoryVars struct {
+	Hostname string
+}
+
+// Ask for check_mk to be re-inventoried. Never blocks.
+func inventory() {
+	settingsLock.RLock()
+	command := InventoryCommand
+	settingsLock.RUnlock()
+	if pretending() || command == "" {
+		return
+	}
+	inventoryOnce.Do(func() { go inventoryLoop() })
+	select {
+	case inventoryChan <- true:
+	default:
+		// one is pending already
+	}
+}
+
+func inventoryLoop() {
+	for range inventoryChan {
+		status := runInventory()
+		if err := store.Update(serialize.Update{Bucket: StatusBucket, Key: inventoryStatusKey,
+			Object: status}); err != nil {
+			log.Printf("[CMK Inventory] ERROR: could not save the status: %v", err)
+		}
+	}
+}
+
+// Returns the re-inventory command's arguments
+func inventoryArgs(command string) ([]string, error) {
+	tmpl, err := template.New("inventory").Option("missingkey=error").Parse(command)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, &inventoryVars{Hostname: hostname}); err != nil {
+		return nil, err
+	}
+	args := strings.Fields(buf.String())
+	if len(args) == 0 {
+		return nil, errors.New("the inventory command is empty")
+	}
+	return args, nil
+}
+
+// Run the re-inventory command, retrying it if it fails
+func runInventory() *types.InventoryStatus {
+	status := &types.InventoryStatus{LastRun: time.Now()}
+	settingsLock.RLock()
+	command, retries := InventoryCommand, InventoryRetries
+	settingsLock.RUnlock()
+	args, err := inventoryArgs(command)
+	if err != nil {
+		status.Error = fmt.Sprintf("invalid inventory command %q: %v", command, err)
+		log.Printf("[CMK Inventory] ERROR: %s", status.Error)
+		return status
+	}
+	status.Command = args
+	for status.Attempts = 1; ; status.Attempts++ {
+		log.Printf("[CMK Inventory] %s (attempt %d)", strings.Join(args, " "), status.Attempts)
+		output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if len(output) > MaxInventoryOutput {
+			output = output[len(output)-MaxInventoryOutput:]
+		}
+		status.Output = string(output)
+		if err == nil {
+			status.Error = ""
+			log.Printf("[CMK Inventory] done:\n%s", output)
+			return status
+		}
+		status.Error = err.Error()
+		log.Printf("[CMK Inventory] ERROR: %v\n%s", err, output)
+		if status.Attempts > retries {
+			return status
+		}
+		time.Sleep(InventoryRetryDelay)
+	}
+}
@@ -0,0 +1,159 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/containers/serialize"
+	"atlantis/supervisor/docker"
+	"atlantis/supervisor/logship"
+	"atlantis/supervisor/rpc/types"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LogShipDir   = "logship" // in the save dir, how far the logs of each container were shipped
+	logShipRetry = 5 * time.Second
+)
+
+var (
+	logShipLock       = sync.Mutex{}
+	logShipStops      = map[string]chan bool{} // container id -> closed to stop shipping the container's logs
+	errLogShipStopped = errors.New("log shipping stopped")
+)
+
+func logShipFile(id string) string {
+	return path.Join(serialize.SaveDir, LogShipDir, id)
+}
+
+// Returns the time of the last line of the container's logs that was shipped, zero if none was
+func shippedUntil(id string) time.Time {
+	data, err := ioutil.ReadFile(logShipFile(id))
+	if err != nil {
+		return time.Time{}
+	}
+	t, _ := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	return t
+}
+
+func saveShippedUntil(id string, t time.Time) {
+	if err := os.MkdirAll(path.Join(serialize.SaveDir, LogShipDir), 0755); err != nil {
+		log.Printf("[logship] could not save how far the logs of %s were shipped: %v", id, err)
+		return
+	}
+	if err := ioutil.WriteFile(logShipFile(id), []byte(t.Format(time.RFC3339Nano)), 0644); err != nil {
+		log.Printf("[logship] could not save how far the logs of %s were shipped: %v", id, err)
+	}
+}
+
+// Fails writes once stop is closed, so following the logs of a container that is still running ends with its
+// next line
+type stoppableWriter struct {
+	io.Writer
+	stop chan bool
+}
+
+func (w *stoppableWriter) Write(p []byte) (int, error) {
+	select {
+	case <-w.stop:
+		return 0, errLogShipStopped
+	default:
+		return w.Writer.Write(p)
+	}
+}
+
+// Start shipping the container's logs where its manifest says
+func startLogShipping(c *Container) {
+	if pretending() || c.Manifest == nil || c.Manifest.LogShipping == nil {
+		return
+	}
+	logShipLock.Lock()
+	defer logShipLock.Unlock()
+	if _, shipping := logShipStops[c.ID]; shipping {
+		return
+	}
+	stop := make(chan bool)
+	logShipStops[c.ID] = stop
+	go shipLogs(c.ID, c.Manifest.LogShipping, stop)
+}
+
+func stopLogShipping(id string) {
+	logShipLock.Lock()
+	defer logShipLock.Unlock()
+	if stop, shipping := logShipStops[id]; shipping {
+		close(stop)
+		delete(logShipStops, id)
+	}
+}
+
+// Ship the container's logs until stop is closed. Picks up after the last line shipped, also across supervisor
+// restarts.
+func shipLogs(id string, cfg *types.LogShipping, stop chan bool) {
+	sink, err := logship.New(cfg)
+	if err != nil {
+		log.Printf("[logship] can not ship the logs of %s: %v", id, err)
+		return
+	}
+	shipper := logship.NewShipper(sink, func(last time.Time) {
+		select {
+		case <-stop:
+			// torn down, and its position with it
+		default:
+			saveShippedUntil(id, last)
+		}
+	})
+	defer shipper.Close()
+	followLogs(id, shippedUntil(id), shipper, cfg.Ships, stop)
+}
+
+// Add the lines the container logs after after to shipper until stop is closed, following its logs again whenever
+// docker stops because the container did. Only the streams ships says yes to are followed.
+func followLogs(id string, after time.Time, shipper *logship.Shipper, ships func(stream string) bool,
+	stop chan bool) {
+	for {
+		if c := Get(id); c != nil {
+			meta := &logship.Entry{Host: c.Host, App: c.App, Sha: c.Sha, Env: c.Env, Container: id}
+			if c.Manifest != nil && c.Manifest.LogShipping != nil {
+				meta.Fields = c.Manifest.LogShipping.Fields
+			}
+			var stdout, stderr io.Writer
+			if ships(types.LogStreamStdout) {
+				stdout = &stoppableWriter{&logship.LogWriter{Shipper: shipper, Meta: meta,
+					Stream: types.LogStreamStdout, After: &after}, stop}
+			}
+			if ships(types.LogStreamStderr) {
+				stderr = &stoppableWriter{&logship.LogWriter{Shipper: shipper, Meta: meta,
+					Stream: types.LogStreamStderr, After: &after}, stop}
+			}
+			if err := docker.FollowLogs(c, after, stdout, stderr); err != nil && err != errLogShipStopped {
+				log.Printf("[logship] stopped following the logs of %s: %v", id, err)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(logShipRetry):
+		}
+	}
+}
+
+// Forget how far the logs of a container that was torn down were shipped
+func forgetShippedUntil(id string) {
+	os.Remove(logShipFile(id))
+}
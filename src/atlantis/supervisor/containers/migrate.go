@@ -0,0 +1,104 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/containers/serialize"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	MetaBucket       = "meta"
+	formatVersionKey = "format_version"
+)
+
+// The format of the saved containers this supervisor understands. Bump it and add a migration whenever a field of
+// Container changes meaning or needs a value containers saved by older supervisors do not have.
+const StateFormatVersion = 1
+
+// stateMigrations[i] upgrades a saved container from state format version i to i+1
+var stateMigrations = []func(*Container) error{
+	// 0 -> 1: containers saved before the format was versioned can have unversioned manifests and predate named
+	// ports
+	func(c *Container) error {
+		if c.Manifest == nil {
+			return fmt.Errorf("%s has no manifest", c.ID)
+		}
+		if err := c.Manifest.Migrate(); err != nil {
+			return err
+		}
+		if c.NamedPorts == nil && len(c.Manifest.Ports) > 0 {
+			c.NamedPorts = assignNamedPorts(c.Manifest, c.SecondaryPorts)
+		}
+		return nil
+	},
+}
+
+// Returns the format version of the saved state, 0 for state saved before the format was versioned
+func stateFormatVersion(s serialize.Store) (int, error) {
+	meta := map[string]int{}
+	if err := s.RetrieveAll(MetaBucket, &meta); err != nil {
+		return 0, err
+	}
+	return meta[formatVersionKey], nil
+}
+
+// Upgrade the saved containers to StateFormatVersion in a single transaction, after copying the store aside.
+// State saved by a newer supervisor is rejected rather than having the fields this supervisor does not know
+// about dropped the next time it saves. Returns the format version the state was upgraded from.
+func migrateState(s *serialize.BoltStore) (int, error) {
+	version, err := stateFormatVersion(s)
+	if err != nil {
+		return 0, err
+	}
+	if version > StateFormatVersion {
+		return version, fmt.Errorf("state format version %d is newer than the supported version %d", version,
+			StateFormatVersion)
+	}
+	if version == StateFormatVersion {
+		return version, nil
+	}
+	backup := fmt.Sprintf("%s.v%d-%s.bak", s.File, version, time.Now().UTC().Format("20060102T150405Z"))
+	if err := s.Backup(backup); err != nil {
+		return version, fmt.Errorf("could not back up %s: %v", s.File, err)
+	}
+	saved := map[string]*Container{}
+	if err := s.RetrieveAll(ContainersBucket, &saved); err != nil {
+		return version, err
+	}
+	updates := []serialize.Update{{Bucket: MetaBucket, Key: formatVersionKey, Object: StateFormatVersion}}
+	for id, cont := range saved {
+		for v := version; v < StateFormatVersion; v++ {
+			if err := stateMigrations[v](cont); err != nil {
+				return version, fmt.Errorf("could not migrate %s from state format version %d: %v", id, v, err)
+			}
+		}
+		updates = append(updates, serialize.Update{Bucket: ContainersBucket, Key: id, Object: cont})
+	}
+	if err := s.Update(updates...); err != nil {
+		return version, err
+	}
+	log.Printf("-> upgraded %d containers from state format version %d to %d, the old state is in %s",
+		len(saved), version, StateFormatVersion, backup)
+	return version, nil
+}
+
+// Upgrade the state saved in saveDir to the current format without starting the supervisor, e.g. before
+// switching to a new supervisor binary. Returns the format version the state was upgraded from.
+func MigrateState(saveDir string) (int, error) {
+	if err := serialize.Init(saveDir); err != nil {
+		return 0, err
+	}
+	return openState()
+}
@@ -0,0 +1,100 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+)
+
+// A container can be deployed into the network namespace of another one, e.g. a sidecar that has to reach the
+// other container on localhost. Docker can not publish the ports of such a container, so the supervisor forwards
+// them from the host to the shared namespace itself. The container goes down with the one whose namespace it
+// shares: it is torn down first and restarted after it.
+
+// Check that a container deployed with the manifest can join the network namespace of the container with id
+func CheckNetworkOf(id string, manifest *types.Manifest) error {
+	if id == "" {
+		return nil
+	}
+	if manifest.NetworkMode != "" || manifest.ProxyPort != 0 {
+		return errors.New("a container joining another's network can not set its own network mode or proxy port")
+	}
+	if manifest.Network != nil {
+		return errors.New("a container joining another's network can not tune it, tune the one it joins")
+	}
+	shared := Get(id)
+	if shared == nil {
+		return fmt.Errorf("there is no container %s to share the network of", id)
+	}
+	if shared.NetworkOf != "" {
+		return fmt.Errorf("%s shares the network of %s, join that one instead", id, shared.NetworkOf)
+	}
+	if shared.Manifest.HostNetwork() {
+		return fmt.Errorf("%s is on the host network", id)
+	}
+	return nil
+}
+
+// Returns the ids of the containers that share the network namespace of the container with id, sorted. Must be
+// called from the containerManager.
+func joiners(id string) []string {
+	ids := []string{}
+	for _, cont := range containers {
+		if cont.NetworkOf == id {
+			ids = append(ids, cont.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Returns the ports of the container the supervisor forwards
+func forwardedPorts(c *Container) []uint16 {
+	return append([]uint16{c.PrimaryPort, c.SSHPort}, c.SecondaryPorts...)
+}
+
+// Forward the ports of a container sharing another's network namespace to that namespace, which has the other
+// container's IP. Nothing is forwarded before the IP is known.
+func forwardSharedPorts(c *Container) {
+	if c.NetworkOf == "" {
+		return
+	}
+	if c.IP == "" {
+		log.Printf("[netns] %s: the network of %s has no IP, not forwarding its ports", c.ID, c.NetworkOf)
+		return
+	}
+	for _, port := range forwardedPorts(c) {
+		startProxy(port, c.ID, c.ID, net.JoinHostPort(c.IP, fmt.Sprintf("%d", port)), true)
+	}
+}
+
+func stopForwarding(c *Container) {
+	if c.NetworkOf == "" {
+		return
+	}
+	for _, port := range forwardedPorts(c) {
+		stopProxy(port, c.ID)
+	}
+}
+
+// Restart the containers sharing the container's network namespace, since docker gave it a new one. Must be
+// called from the containerManager.
+func restartJoiners(container *Container) {
+	for _, id := range joiners(container.ID) {
+		restart(containers[id], "the network of "+container.ID+" restarted", true, nil)
+	}
+}
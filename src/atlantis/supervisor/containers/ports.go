@@ -0,0 +1,221 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"fmt"
+	"log"
+)
+
+// Every container gets a slot, which picks its primary, SSH and secondary ports out of the port pools. Without
+// configured ranges or excluded ports the pools follow each other from MinPort: NumContainers primary ports,
+// NumContainers SSH ports, then NumSecondaryPorts*NumContainers secondary ports.
+var (
+	PrimaryPortRange   types.PortRange   // set before Init. empty to start at MinPort
+	SSHPortRange       types.PortRange   // set before Init. empty to follow the primary ports
+	SecondaryPortRange types.PortRange   // set before Init. empty to follow the ssh ports
+	ExcludedPorts      []uint16          // set before Init. ports other services on the host are bound to
+	primaryPool        []uint16          // primary port of each slot
+	sshPool            []uint16          // ssh port of each slot
+	secondaryPool      []uint16          // secondary port i of slot s is at i*NumContainers+s
+	primarySlots       map[uint16]uint16 // primary port -> slot
+)
+
+// Set before Init. The lowest port a rootless runtime can publish, net.ipv4.ip_unprivileged_port_start.
+var UnprivilegedPortStart = uint16(1024)
+
+func initPortPools() (err error) {
+	primaryPool, sshPool, secondaryPool, err = buildPortPools(MinPort, NumContainers, NumSecondaryPorts,
+		PrimaryPortRange, SSHPortRange, SecondaryPortRange, ExcludedPorts)
+	if err != nil {
+		return err
+	}
+	primarySlots = make(map[uint16]uint16, len(primaryPool))
+	for slot, port := range primaryPool {
+		primarySlots[port] = uint16(slot)
+	}
+	return nil
+}
+
+// Returns true if the port is in one of the pools
+func IsContainerPort(port uint16) bool {
+	for _, pool := range [][]uint16{primaryPool, sshPool, secondaryPool} {
+		for _, p := range pool {
+			if p == port {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the primary, ssh and secondary pools, each starting after the one before it if it has no range
+func buildPortPools(minPort, numContainers, numSecondary uint16, primaryRange, sshRange, secondaryRange types.PortRange,
+	excluded []uint16) (primary, ssh, secondary []uint16, err error) {
+	taken := map[uint16]bool{}
+	for _, port := range excluded {
+		taken[port] = true
+	}
+	next := uint32(minPort)
+	if primary, next, err = portPool("primary", primaryRange, next, int(numContainers), taken); err != nil {
+		return
+	}
+	if ssh, next, err = portPool("ssh", sshRange, next, int(numContainers), taken); err != nil {
+		return
+	}
+	secondary, _, err = portPool("secondary", secondaryRange, next, int(numSecondary)*int(numContainers), taken)
+	return
+}
+
+// Checks that the configured port ranges don't overlap and hold enough ports for every slot, without touching the
+// pools in use. Init lets ranges overlap, the ports they share going to the first pool, but that is rarely meant.
+func CheckPortRanges(s *Settings, minPort, numContainers, numSecondary uint16) error {
+	ranges := []struct {
+		name string
+		r    types.PortRange
+	}{{"primary", s.PrimaryPortRange}, {"ssh", s.SSHPortRange}, {"secondary", s.SecondaryPortRange}}
+	for i, a := range ranges {
+		for _, b := range ranges[i+1:] {
+			if a.r == (types.PortRange{}) || b.r == (types.PortRange{}) {
+				continue
+			}
+			if a.r.Min <= b.r.Max && b.r.Min <= a.r.Max {
+				return fmt.Errorf("Invalid Config. The %s port range %s overlaps the %s port range %s.", a.name, a.r,
+					b.name, b.r)
+			}
+		}
+	}
+	_, _, _, err := buildPortPools(minPort, numContainers, numSecondary, s.PrimaryPortRange, s.SSHPortRange,
+		s.SecondaryPortRange, s.ExcludedPorts)
+	return err
+}
+
+// Returns the lowest port in the pools
+func lowestPort() uint16 {
+	lowest := uint16(65535)
+	for _, pool := range [][]uint16{primaryPool, sshPool, secondaryPool} {
+		for _, port := range pool {
+			if port < lowest {
+				lowest = port
+			}
+		}
+	}
+	return lowest
+}
+
+// Take needed ports out of r, or from next on if r is empty, skipping the taken ones. Also returns where the pool
+// after it starts if that one has no range.
+func portPool(name string, r types.PortRange, next uint32, needed int, taken map[uint16]bool) ([]uint16, uint32,
+	error) {
+	first, last := next, uint32(65535)
+	if r != (types.PortRange{}) {
+		if r.Min > r.Max {
+			return nil, 0, fmt.Errorf("Invalid Config. The %s port range %s is empty.", name, r)
+		}
+		first, last = uint32(r.Min), uint32(r.Max)
+	}
+	pool := make([]uint16, 0, needed)
+	port := first
+	for ; len(pool) < needed && port <= last; port++ {
+		if !taken[uint16(port)] {
+			taken[uint16(port)] = true
+			pool = append(pool, uint16(port))
+		}
+	}
+	if len(pool) < needed {
+		return nil, 0, fmt.Errorf("Invalid Config. Not enough %s ports. (%d needed, %d available)", name, needed,
+			len(pool))
+	}
+	return pool, port, nil
+}
+
+// Returns the ports of a slot
+func slotPorts(slot uint16) (primary, ssh uint16, secondary []uint16) {
+	secondary = make([]uint16, NumSecondaryPorts)
+	for i := range secondary {
+		secondary[i] = secondaryPool[i*int(NumContainers)+int(slot)]
+	}
+	return primaryPool[slot], sshPool[slot], secondary
+}
+
+// Returns the slot of a primary port, false if it is not in the primary pool
+func portSlot(primary uint16) (uint16, bool) {
+	slot, ok := primarySlots[primary]
+	return slot, ok
+}
+
+// Returns true if the container has the ports of its slot
+func hasSlotPorts(cont *types.Container) bool {
+	slot, ok := portSlot(cont.PrimaryPort)
+	if !ok {
+		return false
+	}
+	_, ssh, secondary := slotPorts(slot)
+	if cont.SSHPort != ssh || len(cont.SecondaryPorts) != len(secondary) {
+		return false
+	}
+	for i, port := range secondary {
+		if cont.SecondaryPorts[i] != port {
+			return false
+		}
+	}
+	return true
+}
+
+// Warn about containers whose ports do not match the pools, e.g. because the ranges were changed under them
+func checkSlotPorts() {
+	for id, cont := range containers {
+		if !hasSlotPorts(&cont.Container) {
+			log.Printf("WARNING: ports of %s (primary %d) do not match the configured port pools", id,
+				cont.PrimaryPort)
+		}
+	}
+}
+
+// Return the boundaries of the port pools. They are set at Init and by Reload.
+func PortPools() *types.PortPools {
+	settingsLock.RLock()
+	defer settingsLock.RUnlock()
+	return &types.PortPools{Primary: poolRange(primaryPool), SSH: poolRange(sshPool), Secondary: poolRange(secondaryPool),
+		Excluded: append([]uint16(nil), ExcludedPorts...)}
+}
+
+func poolRange(pool []uint16) types.PortRange {
+	if len(pool) == 0 {
+		return types.PortRange{}
+	}
+	return types.PortRange{Min: pool[0], Max: pool[len(pool)-1]}
+}
+
+func namedPortCount(manifest *types.Manifest) uint16 {
+	count := uint16(0)
+	for _, spec := range manifest.Ports {
+		count += spec.Size()
+	}
+	return count
+}
+
+// Hand out the container's secondary ports to its named ports, in the order they are declared
+func assignNamedPorts(manifest *types.Manifest, secondaryPorts []uint16) map[string][]uint16 {
+	if len(manifest.Ports) == 0 {
+		return nil
+	}
+	named := make(map[string][]uint16, len(manifest.Ports))
+	next := 0
+	for _, spec := range manifest.Ports {
+		size := int(spec.Size())
+		named[spec.Name] = append([]uint16{}, secondaryPorts[next:next+size]...)
+		next += size
+	}
+	return named
+}
@@ -0,0 +1,206 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultProbeInterval         = 10 * time.Second
+	DefaultProbeTimeout          = 5 * time.Second
+	DefaultProbeFailureThreshold = uint(3)
+	ReadyPollInterval            = time.Second
+)
+
+type ProbeUpdateReq struct {
+	id       string
+	liveness bool
+	status   *types.ProbeStatus
+	restart  bool
+}
+
+var (
+	probeLock  = sync.Mutex{}
+	probeStops = map[string]chan bool{} // container id -> closed to stop the container's probes
+)
+
+func probeDuration(seconds uint, def time.Duration) time.Duration {
+	if seconds == 0 {
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Run a single probe against the container, returning an error if it failed
+func runProbe(c *types.Container, probe *types.Probe) error {
+	timeout := probeDuration(probe.Timeout, DefaultProbeTimeout)
+	port := probe.Port
+	if port == 0 && probe.PortName != "" {
+		port = c.NamedPort(probe.PortName)
+	}
+	if port == 0 {
+		port = c.PrimaryPort
+	}
+	addr := fmt.Sprintf("localhost:%d", port)
+	switch probe.Type {
+	case types.ProbeTCP:
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case types.ProbeHTTP:
+		resp, err := (&http.Client{Timeout: timeout}).Get("http://" + addr + probe.Path)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return errors.New("unexpected status " + resp.Status)
+		}
+		return nil
+	case types.ProbeExec:
+		cmd := exec.Command("ssh", containerSSHArgs(c, probe.Command)...)
+		done := make(chan error, 1)
+		go func() {
+			output, err := cmd.CombinedOutput()
+			if err != nil {
+				err = fmt.Errorf("%v: %s", err, output)
+			}
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(timeout):
+			if cmd.Process != nil {
+				cmd.Process.Kill()
+			}
+			return errors.New("timed out")
+		}
+	}
+	return errors.New("unknown probe type " + probe.Type)
+}
+
+func probeLoop(id string, probe *types.Probe, liveness bool, stop chan bool) {
+	interval := probeDuration(probe.Interval, DefaultProbeInterval)
+	threshold := probe.FailureThreshold
+	if threshold == 0 {
+		threshold = DefaultProbeFailureThreshold
+	}
+	failures := uint(0)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+		cont := Get(id)
+		if cont == nil {
+			return
+		}
+		status := &types.ProbeStatus{LastChecked: time.Now()}
+		if err := runProbe(cont, probe); err != nil {
+			failures++
+			status.LastError = err.Error()
+		} else {
+			failures = 0
+		}
+		status.ConsecutiveFailures = failures
+		status.Passing = failures < threshold
+		restart := liveness && !status.Passing && probe.RestartOnFailure
+		updateProbe(&ProbeUpdateReq{id, liveness, status, restart})
+		if restart {
+			failures = 0
+		}
+	}
+}
+
+// Start running the probes declared in the container's manifest
+func startProbes(c *Container) {
+	if pretending() || c.Manifest == nil || (c.Manifest.Readiness == nil && c.Manifest.Liveness == nil) {
+		return
+	}
+	probeLock.Lock()
+	defer probeLock.Unlock()
+	if _, running := probeStops[c.ID]; running {
+		return
+	}
+	stop := make(chan bool)
+	probeStops[c.ID] = stop
+	if c.Manifest.Readiness != nil {
+		go probeLoop(c.ID, c.Manifest.Readiness, false, stop)
+	}
+	if c.Manifest.Liveness != nil {
+		go probeLoop(c.ID, c.Manifest.Liveness, true, stop)
+	}
+}
+
+func stopProbes(id string) {
+	probeLock.Lock()
+	defer probeLock.Unlock()
+	if stop, running := probeStops[id]; running {
+		close(stop)
+		delete(probeStops, id)
+	}
+}
+
+// Wait for the container's readiness probe to pass. Containers without a readiness probe are ready right away.
+func WaitReady(id string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		cont := Get(id)
+		if cont == nil {
+			return errors.New("Unknown Container.")
+		}
+		if pretending() || cont.Manifest.Readiness == nil {
+			return nil
+		}
+		// a single failure still counts as passing, so wait for a success
+		if cont.Readiness != nil && cont.Readiness.Passing && cont.Readiness.ConsecutiveFailures == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s was not ready after %s: %s", id, timeout, cont.Readiness)
+		}
+		time.Sleep(ReadyPollInterval)
+	}
+}
+
+func updateProbe(req *ProbeUpdateReq) {
+	probeChan <- req
+}
+
+// Record a probe result. Must be called from the containerManager.
+func probeUpdate(req *ProbeUpdateReq) {
+	container := containers[req.id]
+	if container == nil {
+		return
+	}
+	if req.liveness {
+		container.Liveness = req.status
+	} else {
+		container.Readiness = req.status
+		setProxyReady(container)
+	}
+	if req.restart {
+		restart(container, "failed liveness probe: "+req.status.LastError, false, nil)
+	}
+}
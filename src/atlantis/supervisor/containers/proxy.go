@@ -0,0 +1,216 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Apps whose manifest has a ProxyPort are also reachable on that port of the host. The supervisor listens on it
+// and forwards each connection to the next ready container of the app, so consumers keep using the same port
+// while containers come and go in redeploys.
+type portProxy struct {
+	port     uint16
+	app      string
+	listener net.Listener
+	backends map[string]string // container id -> address of its primary port
+	ready    map[string]bool
+	next     int
+}
+
+var (
+	ProxyDialTimeout = 5 * time.Second
+	proxyLock        = sync.Mutex{}
+	proxies          = map[uint16]*portProxy{}
+)
+
+// Returns the address the proxy forwards the container's connections to. Ports of containers on the static IP
+// network are not published on the host.
+func proxyBackend(c *Container) string {
+	host := "127.0.0.1"
+	if needsStaticIP(c.Manifest) && c.IP != "" {
+		host = c.IP
+	}
+	return net.JoinHostPort(host, fmt.Sprintf("%d", c.PrimaryPort))
+}
+
+// Returns true if the proxy may send the container connections. Containers with a readiness probe have to
+// pass it first.
+func proxyReady(c *Container) bool {
+	return pretending() || c.Manifest.Readiness == nil || (c.Readiness != nil && c.Readiness.Passing)
+}
+
+// Start forwarding the container's proxy port to it, listening on the port if no other container of the app
+// has already
+func addToProxy(c *Container) {
+	if c.Manifest.ProxyPort == 0 || c.App == "" {
+		return
+	}
+	startProxy(c.Manifest.ProxyPort, c.App, c.ID, proxyBackend(c), proxyReady(c))
+}
+
+// Forward the port to backend for the container, listening on it if nothing is forwarded yet. Ports are owned by
+// whatever they were first forwarded for, an app or a single container.
+func startProxy(port uint16, owner, id, backend string, ready bool) {
+	proxyLock.Lock()
+	defer proxyLock.Unlock()
+	p := proxies[port]
+	if p == nil {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			log.Printf("[proxy] could not listen on %d for %s: %v", port, owner, err)
+			return
+		}
+		p = &portProxy{port: port, app: owner, listener: listener, backends: map[string]string{},
+			ready: map[string]bool{}}
+		proxies[port] = p
+		go p.serve()
+		log.Printf("[proxy] forwarding %d to %s", port, owner)
+	} else if p.app != owner {
+		log.Printf("[proxy] %d is forwarded to %s, not adding %s", port, p.app, id)
+		return
+	}
+	p.backends[id] = backend
+	p.ready[id] = ready
+}
+
+// Forward the proxy ports of the containers loaded from the saved state, dropping the proxies of an earlier Init
+func initProxies() {
+	proxyLock.Lock()
+	for port, p := range proxies {
+		p.listener.Close()
+		delete(proxies, port)
+	}
+	proxyLock.Unlock()
+	for _, cont := range containers {
+		addToProxy(cont)
+		forwardSharedPorts(cont)
+	}
+}
+
+// Returns the app of the containers with the proxy port, empty if there are none. Must be called from the
+// containerManager.
+func proxyPortApp(port uint16) string {
+	if port == 0 {
+		return ""
+	}
+	for _, cont := range containers {
+		if cont.Manifest.ProxyPort == port && cont.App != "" {
+			return cont.App
+		}
+	}
+	return ""
+}
+
+// Update whether the proxy sends the container connections, after its readiness probe ran
+func setProxyReady(c *Container) {
+	proxyLock.Lock()
+	defer proxyLock.Unlock()
+	if p := proxies[c.Manifest.ProxyPort]; p != nil && p.backends[c.ID] != "" {
+		p.ready[c.ID] = proxyReady(c)
+	}
+}
+
+// Stop forwarding to the container, and stop listening once the app has no containers left. Connections already
+// forwarded are left alone.
+func removeFromProxy(c *Container) {
+	stopProxy(c.Manifest.ProxyPort, c.ID)
+}
+
+func stopProxy(port uint16, id string) {
+	proxyLock.Lock()
+	defer proxyLock.Unlock()
+	p := proxies[port]
+	if p == nil || p.backends[id] == "" {
+		return
+	}
+	delete(p.backends, id)
+	delete(p.ready, id)
+	if len(p.backends) == 0 {
+		p.listener.Close()
+		delete(proxies, p.port)
+		log.Printf("[proxy] stopped forwarding %d to %s", port, p.app)
+	}
+}
+
+// Returns the address of the next ready container, in turn. If none is ready, e.g. while the only container is
+// starting, the next container is tried anyway. Empty if the app has no containers.
+func (p *portProxy) pick() string {
+	proxyLock.Lock()
+	defer proxyLock.Unlock()
+	ids := make([]string, 0, len(p.backends))
+	for id := range p.backends {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	ready := []string{}
+	for _, id := range ids {
+		if p.ready[id] {
+			ready = append(ready, id)
+		}
+	}
+	if len(ready) == 0 {
+		ready = ids
+	}
+	if len(ready) == 0 {
+		return ""
+	}
+	p.next++
+	return p.backends[ready[p.next%len(ready)]]
+}
+
+func (p *portProxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			// closed once the app has no containers left
+			return
+		}
+		go p.forward(conn)
+	}
+}
+
+func (p *portProxy) forward(conn net.Conn) {
+	defer conn.Close()
+	backend := p.pick()
+	if backend == "" {
+		return
+	}
+	upstream, err := net.DialTimeout("tcp", backend, ProxyDialTimeout)
+	if err != nil {
+		log.Printf("[proxy] %d: could not connect to %s: %v", p.port, backend, err)
+		return
+	}
+	defer upstream.Close()
+	done := make(chan bool, 1)
+	go func() {
+		io.Copy(upstream, conn)
+		closeWrite(upstream)
+		done <- true
+	}()
+	io.Copy(conn, upstream)
+	closeWrite(conn)
+	<-done
+}
+
+// Pass on the end of one direction of the connection while the other can still carry data
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	}
+}
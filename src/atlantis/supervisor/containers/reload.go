@@ -0,0 +1,97 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/docker"
+	"atlantis/supervisor/rpc/types"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// The settings that can be changed while the supervisor runs. New port ranges only apply to containers deployed
+// afterwards, the containers already using the old ports keep them.
+type Settings struct {
+	PrimaryPortRange     types.PortRange
+	SSHPortRange         types.PortRange
+	SecondaryPortRange   types.PortRange
+	ExcludedPorts        []uint16
+	CPUOvercommit        float64
+	MemoryOvercommit     float64
+	DefaultRestartPolicy *types.RestartPolicy
+	InventoryCommand     string
+	InventoryRetries     uint
+	CheckScripts         string
+	CheckScriptsDir      string
+}
+
+type ReloadReq struct {
+	settings *Settings
+	respChan chan error
+}
+
+// Guards the settings Reload changes that are read outside the containerManager
+var settingsLock sync.RWMutex
+
+// Apply new settings. Nothing is changed if any of them are invalid.
+func Reload(settings *Settings) error {
+	respChan := make(chan error)
+	reloadChan <- &ReloadReq{settings, respChan}
+	return <-respChan
+}
+
+func reload(req *ReloadReq) {
+	req.respChan <- applySettings(req.settings)
+}
+
+func applySettings(s *Settings) error {
+	if s.CPUOvercommit < 0 || s.MemoryOvercommit < 0 {
+		return errors.New("Invalid Config. Overcommit ratios can not be negative")
+	}
+	if s.DefaultRestartPolicy != nil {
+		if err := s.DefaultRestartPolicy.Validate(); err != nil {
+			return errors.New("Invalid Config. Default " + err.Error())
+		}
+	}
+	settingsLock.Lock()
+	defer settingsLock.Unlock()
+	oldRanges := []types.PortRange{PrimaryPortRange, SSHPortRange, SecondaryPortRange}
+	oldExcluded := ExcludedPorts
+	oldPools := [][]uint16{primaryPool, sshPool, secondaryPool}
+	oldSlots := primarySlots
+	PrimaryPortRange, SSHPortRange, SecondaryPortRange = s.PrimaryPortRange, s.SSHPortRange, s.SecondaryPortRange
+	ExcludedPorts = s.ExcludedPorts
+	err := initPortPools()
+	if err == nil && docker.Rootless && lowestPort() < UnprivilegedPortStart {
+		err = fmt.Errorf("Invalid Config. A rootless runtime can not publish port %d, ports start at %d",
+			lowestPort(), UnprivilegedPortStart)
+	}
+	if err != nil {
+		PrimaryPortRange, SSHPortRange, SecondaryPortRange = oldRanges[0], oldRanges[1], oldRanges[2]
+		ExcludedPorts = oldExcluded
+		primaryPool, sshPool, secondaryPool = oldPools[0], oldPools[1], oldPools[2]
+		primarySlots = oldSlots
+		return err
+	}
+	checkSlotPorts()
+	CPUOvercommit, MemoryOvercommit = s.CPUOvercommit, s.MemoryOvercommit
+	cpuCapacity = overcommitted(CPUShares, CPUOvercommit)
+	memoryCapacity = overcommitted(MemoryLimit, MemoryOvercommit)
+	DefaultRestartPolicy = s.DefaultRestartPolicy
+	InventoryCommand, InventoryRetries = s.InventoryCommand, s.InventoryRetries
+	CheckScripts, CheckScriptsDir = s.CheckScripts, s.CheckScriptsDir
+	log.Printf("[reload] primary ports %s, ssh ports %s, secondary ports %s, cpu capacity %d, memory capacity %d",
+		poolRange(primaryPool), poolRange(sshPool), poolRange(secondaryPool), cpuCapacity, memoryCapacity)
+	return nil
+}
@@ -0,0 +1,80 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/docker"
+	"errors"
+	"fmt"
+)
+
+type ResizeReq struct {
+	id          string
+	cpuShares   uint // 0 to leave as it is
+	memoryLimit uint // MB, 0 to leave as it is
+	respChan    chan error
+}
+
+// Change the CPU shares and memory limit of a running container through the containerManager. The resources are
+// reserved like they are for a new container and docker updates the container in place.
+func Resize(id string, cpuShares, memoryLimit uint) error {
+	respChan := make(chan error)
+	resizeChan <- &ResizeReq{id, cpuShares, memoryLimit, respChan}
+	err := <-respChan
+	close(respChan)
+	return err
+}
+
+func resize(req *ResizeReq) {
+	req.respChan <- resizeContainer(req)
+}
+
+func resizeContainer(req *ResizeReq) error {
+	container := containers[req.id]
+	if container == nil {
+		return errors.New("Unknown Container.")
+	}
+	manifest := container.Manifest.DeepCopy()
+	if req.cpuShares != 0 {
+		if manifest.DedicatedCPUs > 0 {
+			return errors.New("Containers with dedicated CPUs can not change their CPU Shares.")
+		}
+		manifest.CPUShares = req.cpuShares
+	}
+	if req.memoryLimit != 0 {
+		manifest.MemoryLimit = req.memoryLimit
+	}
+	if manifest.MemorySwap > 0 && manifest.MemorySwap < manifest.MemoryLimit {
+		return fmt.Errorf("Memory Limit can not be more than Memory Swap. (%d requested, %d swap)",
+			manifest.MemoryLimit, manifest.MemorySwap)
+	}
+	oldCPUShares, oldMemoryLimit := reservedCPUShares(container.Manifest), container.Manifest.MemoryLimit
+	if reservedCPUShares(manifest) > oldCPUShares &&
+		reservedCPUShares(manifest)-oldCPUShares+usedCPUShares > cpuCapacity {
+		return fmt.Errorf("Not enough CPU Shares to reserve. (%d more requested, %d available)",
+			reservedCPUShares(manifest)-oldCPUShares, remaining(cpuCapacity, usedCPUShares))
+	}
+	if manifest.MemoryLimit > oldMemoryLimit && manifest.MemoryLimit-oldMemoryLimit+usedMemoryLimit > memoryCapacity {
+		return fmt.Errorf("Not enough Memory to reserve. (%d more requested, %d available)",
+			manifest.MemoryLimit-oldMemoryLimit, remaining(memoryCapacity, usedMemoryLimit))
+	}
+	resized := container.Container
+	resized.Manifest = manifest
+	if err := docker.Resize(&resized); err != nil {
+		return err
+	}
+	container.Manifest = manifest
+	usedCPUShares = usedCPUShares - oldCPUShares + reservedCPUShares(manifest)
+	usedMemoryLimit = usedMemoryLimit - oldMemoryLimit + manifest.MemoryLimit
+	save(req.id)
+	return nil
+}
@@ -0,0 +1,289 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/docker"
+	"atlantis/supervisor/rpc/types"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	RestartWatchInterval = 5 * time.Second
+	MaxRestartBackoff    = 5 * time.Minute
+)
+
+type RestartReq struct {
+	id       string
+	exitCode int
+	reason   string
+	sidecar  string // restart this sidecar instead of the container itself
+	respChan chan error
+}
+
+// The outcome of a restart, worked out off the containerManager and recorded by it
+type RestartDoneReq struct {
+	id       string
+	reason   string
+	sidecar  string // the sidecar that was restarted, empty if it was the container itself
+	joined   bool   // restarted because the container whose network it joins was
+	err      error
+	pid      int
+	ip       string
+	ipv6     string
+	sidecars []*types.SidecarContainer // restarted copies of the container's sidecars
+	respChan chan error                // nil if nothing waits on the restart
+}
+
+type RunStateReq struct {
+	id       string
+	running  bool
+	exitCode int
+}
+
+var (
+	DefaultRestartPolicy *types.RestartPolicy // set before Init. for containers without one, nil to only report exits
+	watchLock            = sync.Mutex{}
+	watchStops           = map[string]chan bool{} // container id -> closed to stop watching the container
+	restarting           map[string]bool          // not for direct access. must go through containerManager.
+)
+
+// Restart a container through the containerManager
+func Restart(id string, exitCode int, reason string) error {
+	respChan := make(chan error)
+	req := &RestartReq{id, exitCode, reason, "", respChan}
+	restartChan <- req
+	err := <-respChan
+	close(respChan)
+	return err
+}
+
+func restartRequested(req *RestartReq) {
+	container := containers[req.id]
+	if container == nil {
+		req.respChan <- errors.New("Unknown Container.")
+		return
+	}
+	if req.sidecar != "" {
+		restartSidecar(container, req.sidecar, req.reason, req.respChan)
+		return
+	}
+	container.LastExitCode = req.exitCode
+	restart(container, req.reason, false, req.respChan)
+}
+
+// Start restarting the container. Docker restarts it and its network security is re-established, since the pid
+// changes, on a goroutine of its own; restartDone records the outcome and answers respChan if it is not nil. Must be
+// called from the containerManager.
+func restart(container *Container, reason string, joined bool, respChan chan error) {
+	if restarting[container.ID] {
+		replyRestart(respChan, errors.New("Container is already restarting."))
+		return
+	}
+	restarting[container.ID] = true
+	log.Printf("[restart] %s: %s", container.ID, reason)
+	cont := &Container{container.Container}
+	cont.Manifest = container.Manifest.DeepCopy()
+	cont.Sidecars = append([]*types.SidecarContainer{}, container.Sidecars...)
+	go restartDocker(cont, reason, joined, respChan)
+}
+
+// Restart a copy of the container in docker, along with its sidecars, and hand the result to the containerManager
+func restartDocker(cont *Container, reason string, joined bool, respChan chan error) {
+	done := &RestartDoneReq{id: cont.ID, reason: reason, joined: joined, respChan: respChan}
+	cont.removeSecurity()
+	if done.err = restartContainer(cont); done.err == nil {
+		cont.tuneNetwork()
+		cont.addSecurity()
+		done.pid, done.ip, done.ipv6 = cont.Pid, cont.IP, cont.IPv6
+		done.sidecars = restartAllSidecars(cont, "primary container restarted")
+	}
+	restartDoneChan <- done
+}
+
+// Record the outcome of a restart. Containers torn down while docker restarted them are cleaned up after again.
+// Must be called from the containerManager.
+func restartDone(req *RestartDoneReq) {
+	container := containers[req.id]
+	if req.sidecar != "" {
+		delete(restarting, req.id+"/"+req.sidecar)
+	} else {
+		delete(restarting, req.id)
+	}
+	if container == nil {
+		log.Printf("[restart] %s was torn down while restarting", req.id)
+		go func() {
+			teardownSidecars(req.sidecars)
+			if req.sidecar == "" {
+				NetworkSecurity.RemoveContainerSecurity(req.id)
+			}
+		}()
+		replyRestart(req.respChan, errors.New("Unknown Container."))
+		return
+	}
+	if req.sidecar != "" {
+		replaceSidecars(container, req.sidecars)
+		save(container.ID)
+		replyRestart(req.respChan, req.err)
+		return
+	}
+	if req.err != nil {
+		log.Printf("[restart] -> error restarting %s: %v", container.ID, req.err)
+		emit(container.ID, types.EventRestartFailed, "%s: %v", req.reason, req.err)
+		replyRestart(req.respChan, req.err)
+		return
+	}
+	emit(container.ID, types.EventRestarted, "%s", req.reason)
+	container.RunState = types.ContainerRunning
+	container.Restarts++
+	container.Pid, container.IP, container.IPv6 = req.pid, req.ip, req.ipv6
+	replaceSidecars(container, req.sidecars)
+	if primary := containers[container.NetworkOf]; req.joined && primary != nil {
+		stopForwarding(container)
+		container.IP = primary.IP
+		forwardSharedPorts(container)
+	}
+	save(container.ID)
+	restartJoiners(container)
+	replyRestart(req.respChan, nil)
+}
+
+func replyRestart(respChan chan error, err error) {
+	if respChan != nil {
+		respChan <- err
+	}
+}
+
+func restartBackoff(policy *types.RestartPolicy, restarts uint) time.Duration {
+	delay := time.Duration(policy.Backoff) * time.Second
+	for i := uint(0); i < restarts && delay < MaxRestartBackoff; i++ {
+		delay *= 2
+	}
+	if delay > MaxRestartBackoff {
+		delay = MaxRestartBackoff
+	}
+	return delay
+}
+
+// Record whether the container is running through the containerManager
+func reportRunState(id string, running bool, exitCode int) {
+	runStateChan <- &RunStateReq{id, running, exitCode}
+}
+
+func runStateChanged(req *RunStateReq) {
+	container := containers[req.id]
+	if container == nil || (!req.running && container.RunState == types.ContainerCheckpointed) {
+		return
+	}
+	if req.running {
+		container.RunState = types.ContainerRunning
+	} else {
+		container.RunState = types.ContainerExited
+		container.LastExitCode = req.exitCode
+		container.ExitedAt = time.Now()
+	}
+	save(req.id)
+}
+
+// Poll the container until it is torn down. Exits are recorded and, if policy allows, the container is restarted.
+// A nil policy never restarts.
+func watchLoop(id string, policy *types.RestartPolicy, stop chan bool) {
+	restarts := uint(0)
+	gaveUp := false
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(RestartWatchInterval):
+		}
+		cont := Get(id)
+		if cont == nil {
+			return
+		}
+		if cont.RunState == types.ContainerCheckpointed {
+			// stopped on purpose, it is started again by restoring it
+			continue
+		}
+		running, exitCode, err := docker.State(cont)
+		if err != nil {
+			log.Printf("[restart] could not get state of %s: %v", id, err)
+			continue
+		}
+		if running {
+			if gaveUp {
+				// started again from outside the supervisor
+				reportRunState(id, true, 0)
+				gaveUp = false
+			}
+			continue
+		}
+		if gaveUp {
+			continue
+		}
+		reportRunState(id, false, exitCode)
+		if policy == nil {
+			emit(id, types.EventExited, "exited with code %d, no restart policy", exitCode)
+			gaveUp = true
+			continue
+		}
+		if !policy.ShouldRestart(exitCode, restarts) {
+			emit(id, types.EventGaveUp, "exited with code %d, not restarting (policy %s, %d restarts)", exitCode,
+				policy.Name, restarts)
+			gaveUp = true
+			continue
+		}
+		emit(id, types.EventExited, "exited with code %d, restarting in %s (policy %s)", exitCode,
+			restartBackoff(policy, restarts), policy.Name)
+		select {
+		case <-stop:
+			return
+		case <-time.After(restartBackoff(policy, restarts)):
+		}
+		restarts++
+		Restart(id, exitCode, fmt.Sprintf("exited with code %d (policy %s, restart %d)", exitCode, policy.Name,
+			restarts))
+	}
+}
+
+// Start watching the container for exits, restarting it by its manifest's restart policy or the default one
+func startWatch(c *Container) {
+	if pretending() || c.Manifest == nil {
+		return
+	}
+	policy := c.Manifest.RestartPolicy
+	if policy == nil {
+		settingsLock.RLock()
+		policy = DefaultRestartPolicy
+		settingsLock.RUnlock()
+	}
+	watchLock.Lock()
+	defer watchLock.Unlock()
+	if _, watching := watchStops[c.ID]; watching {
+		return
+	}
+	stop := make(chan bool)
+	watchStops[c.ID] = stop
+	go watchLoop(c.ID, policy, stop)
+}
+
+func stopWatch(id string) {
+	watchLock.Lock()
+	defer watchLock.Unlock()
+	if stop, watching := watchStops[id]; watching {
+		close(stop)
+		delete(watchStops, id)
+	}
+}
@@ -0,0 +1,177 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package serialize
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+const (
+	KeySize          = 32 // aes-256
+	encryptedVersion = "v1"
+)
+
+// What encrypted state is saved as instead of its json. It is json itself, so a record or file can hold either.
+type sealed struct {
+	Version string `json:"atlantisEncrypted"`
+	KeyID   string `json:"keyID"`
+	Data    []byte `json:"data"` // the nonce followed by the aes-256-gcm ciphertext
+}
+
+var sealedPrefix = []byte(`{"atlantisEncrypted"`)
+
+var (
+	keyLock sync.RWMutex
+	aead    cipher.AEAD // nil if state is saved in the clear
+	keyID   string
+)
+
+// Encrypt everything saved from now on with key, and decrypt what was saved with it. Anything saved in the clear
+// can still be read, so turning encryption on needs no migration. A nil key turns encryption off.
+func SetKey(key []byte) error {
+	keyLock.Lock()
+	defer keyLock.Unlock()
+	if key == nil {
+		aead, keyID = nil, ""
+		return nil
+	}
+	if len(key) != KeySize {
+		return fmt.Errorf("the state key must be %d bytes, not %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	if aead, err = cipher.NewGCM(block); err != nil {
+		return err
+	}
+	keyID = KeyID(key)
+	return nil
+}
+
+func Encrypting() bool {
+	keyLock.RLock()
+	defer keyLock.RUnlock()
+	return aead != nil
+}
+
+// Returns a short id for the key, saved with what it encrypts so a wrong key can be told apart from corruption
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// Read the state key from file, or from the output of command if file is empty. The command is run with sh, so it
+// can fetch the key from a KMS, e.g. by decrypting a data key. The key may be raw, hex or base64.
+func LoadKey(file, command string) ([]byte, error) {
+	var raw []byte
+	var err error
+	switch {
+	case file != "":
+		raw, err = ioutil.ReadFile(file)
+	case command != "":
+		var stderr bytes.Buffer
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Stderr = &stderr
+		if raw, err = cmd.Output(); err != nil {
+			err = fmt.Errorf("%v %s", err, strings.TrimSpace(stderr.String()))
+		}
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read the state key: %v", err)
+	}
+	return parseKey(raw)
+}
+
+func parseKey(raw []byte) ([]byte, error) {
+	if len(raw) == KeySize {
+		return raw, nil
+	}
+	text := strings.TrimSpace(string(raw))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("the state key must be %d bytes, raw, hex or base64", KeySize)
+}
+
+// Marshal object to json, encrypted if there is a key
+func marshal(object interface{}) ([]byte, error) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	return seal(data)
+}
+
+func seal(data []byte) ([]byte, error) {
+	keyLock.RLock()
+	defer keyLock.RUnlock()
+	if aead == nil {
+		return data, nil
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return json.Marshal(&sealed{Version: encryptedVersion, KeyID: keyID, Data: aead.Seal(nonce, nonce, data, nil)})
+}
+
+// Returns the json in data, decrypting it if it was saved encrypted. name is what data was read from, for errors.
+// Data that fails to decrypt with the right key is a *CorruptState, a missing or wrong key is a plain error so the
+// state isn't thrown away.
+func unseal(name string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, sealedPrefix) {
+		return data, nil
+	}
+	var s sealed
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, &CorruptState{File: name, Reason: err.Error()}
+	}
+	if s.Version != encryptedVersion {
+		return nil, fmt.Errorf("%s is encrypted with unknown version %q", name, s.Version)
+	}
+	keyLock.RLock()
+	defer keyLock.RUnlock()
+	if aead == nil {
+		return nil, fmt.Errorf("%s is encrypted with key %s, but no state key is set", name, s.KeyID)
+	}
+	if s.KeyID != keyID {
+		return nil, fmt.Errorf("%s is encrypted with key %s, not the state key %s", name, s.KeyID, keyID)
+	}
+	if len(s.Data) < aead.NonceSize() {
+		return nil, &CorruptState{File: name, Reason: "truncated encrypted data"}
+	}
+	nonce, ciphertext := s.Data[:aead.NonceSize()], s.Data[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, &CorruptState{File: name, Reason: "could not decrypt: " + err.Error()}
+	}
+	return plain, nil
+}
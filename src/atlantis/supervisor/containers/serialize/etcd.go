@@ -0,0 +1,101 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package serialize
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Replicates to etcd through its v3 JSON gateway, keeping each record at <Prefix>/<bucket>/<key>. Endpoints are
+// tried in order until one answers.
+type EtcdReplicator struct {
+	Endpoints []string
+	Prefix    string
+}
+
+var etcdClient = &http.Client{Timeout: 5 * time.Second}
+
+func (r *EtcdReplicator) path(bucket, key string) string {
+	return strings.TrimRight(r.Prefix, "/") + "/" + bucket + "/" + key
+}
+
+func (r *EtcdReplicator) call(method string, body map[string]string, result interface{}) error {
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	err = errors.New("no etcd endpoints configured")
+	for _, endpoint := range r.Endpoints {
+		var resp *http.Response
+		resp, err = etcdClient.Post(strings.TrimRight(endpoint, "/")+"/v3/kv/"+method, "application/json",
+			bytes.NewReader(reqBody))
+		if err != nil {
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("etcd %s: %s", method, resp.Status)
+		}
+		if result == nil {
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return err
+}
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func (r *EtcdReplicator) Put(bucket, key string, val []byte) error {
+	return r.call("put", map[string]string{"key": b64(r.path(bucket, key)), "value": b64(string(val))}, nil)
+}
+
+func (r *EtcdReplicator) Delete(bucket, key string) error {
+	return r.call("deleterange", map[string]string{"key": b64(r.path(bucket, key))}, nil)
+}
+
+func (r *EtcdReplicator) List(bucket string) (map[string][]byte, error) {
+	prefix := r.path(bucket, "")
+	// the end of a prefix range is the prefix with its last byte incremented
+	rangeEnd := prefix[:len(prefix)-1] + string(prefix[len(prefix)-1]+1)
+	var result struct {
+		Kvs []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := r.call("range", map[string]string{"key": b64(prefix), "range_end": b64(rangeEnd)}, &result); err != nil {
+		return nil, err
+	}
+	records := map[string][]byte{}
+	for _, kv := range result.Kvs {
+		key, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		val, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		records[strings.TrimPrefix(string(key), prefix)] = val
+	}
+	return records, nil
+}
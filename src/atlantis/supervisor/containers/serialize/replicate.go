@@ -0,0 +1,186 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package serialize
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ResyncInterval   = 10 * time.Second // how soon a replica that missed updates is synced, set before NewReplicatedStore
+	MaxResyncBackoff = 5 * time.Minute  // between syncs while the replica stays unreachable
+)
+
+// Mirrors records somewhere off the host, such as etcd or Zookeeper, so they outlive the host's disk. Records are
+// addressed by bucket and key under whatever prefix the Replicator was configured with.
+type Replicator interface {
+	Put(bucket, key string, val []byte) error
+	Delete(bucket, key string) error
+	List(bucket string) (map[string][]byte, error)
+}
+
+type replicaOp struct {
+	bucket string
+	key    string
+	val    []byte // nil deletes the record
+}
+
+// A Store that mirrors every update to a Replicator. The local store stays authoritative: updates are applied to
+// it first, and mirrored in order in the background so an unreachable replica never blocks a save. Buckets whose
+// replica missed an update are synced in the background until it catches up.
+type ReplicatedStore struct {
+	Store
+	replicator Replicator
+	ops        chan []replicaOp
+	interval   time.Duration // ResyncInterval when the store was made
+	dirtyLock  sync.Mutex
+	dirty      map[string]bool // buckets whose replica missed updates
+}
+
+func NewReplicatedStore(local Store, replicator Replicator) *ReplicatedStore {
+	s := &ReplicatedStore{Store: local, replicator: replicator, ops: make(chan []replicaOp, 100),
+		interval: ResyncInterval, dirty: map[string]bool{}}
+	go s.replicate()
+	go s.resync()
+	return s
+}
+
+func (s *ReplicatedStore) Update(updates ...Update) error {
+	if err := s.Store.Update(updates...); err != nil {
+		return err
+	}
+	// marshal now, the objects may well have changed by the time they are replicated
+	ops := make([]replicaOp, len(updates))
+	for i, update := range updates {
+		ops[i] = replicaOp{bucket: update.Bucket, key: update.Key}
+		if update.Object != nil {
+			val, err := marshal(update.Object)
+			if err != nil {
+				return err
+			}
+			ops[i].val = val
+		}
+	}
+	select {
+	case s.ops <- ops:
+	default:
+		log.Printf("[replicate] ERROR: too far behind, dropping %d updates until the next sync", len(ops))
+		for _, op := range ops {
+			s.markDirty(op.bucket)
+		}
+	}
+	return nil
+}
+
+// Make the replica of the buckets match the local store, putting every local record and deleting the replicated
+// records that no longer exist locally. Catches the replica up on anything missed while it was unreachable.
+// Buckets that could not be synced are synced again later.
+func (s *ReplicatedStore) Sync(buckets ...string) error {
+	for i, bucket := range buckets {
+		if err := s.syncBucket(bucket); err != nil {
+			for _, unsynced := range buckets[i:] {
+				s.markDirty(unsynced)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ReplicatedStore) syncBucket(bucket string) error {
+	local := map[string]json.RawMessage{}
+	if err := s.Store.RetrieveAll(bucket, &local); err != nil {
+		return err
+	}
+	remote, err := s.replicator.List(bucket)
+	if err != nil {
+		return err
+	}
+	ops := []replicaOp{}
+	for key, val := range local {
+		// the local store decrypted the record, so the replica has to be sent it encrypted again
+		sealedVal, err := seal(val)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, replicaOp{bucket, key, sealedVal})
+	}
+	for key := range remote {
+		if _, ok := local[key]; !ok {
+			ops = append(ops, replicaOp{bucket: bucket, key: key})
+		}
+	}
+	s.ops <- ops
+	return nil
+}
+
+func (s *ReplicatedStore) markDirty(bucket string) {
+	s.dirtyLock.Lock()
+	defer s.dirtyLock.Unlock()
+	s.dirty[bucket] = true
+}
+
+// Returns the buckets whose replica missed updates, sorted, and forgets them
+func (s *ReplicatedStore) takeDirty() []string {
+	s.dirtyLock.Lock()
+	defer s.dirtyLock.Unlock()
+	buckets := make([]string, 0, len(s.dirty))
+	for bucket := range s.dirty {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	s.dirty = map[string]bool{}
+	return buckets
+}
+
+// Sync the buckets whose replica missed updates until it has them all. A bucket that is dirty again by the next
+// check failed to sync, so the checks back off until the replica stays caught up.
+func (s *ReplicatedStore) resync() {
+	delay := s.interval
+	for {
+		time.Sleep(delay)
+		buckets := s.takeDirty()
+		if len(buckets) == 0 {
+			delay = s.interval
+			continue
+		}
+		log.Printf("[replicate] the replica of %s missed updates, syncing", strings.Join(buckets, ", "))
+		if err := s.Sync(buckets...); err != nil {
+			log.Printf("[replicate] ERROR: could not sync: %v", err)
+		}
+		if delay *= 2; delay > MaxResyncBackoff {
+			delay = MaxResyncBackoff
+		}
+	}
+}
+
+func (s *ReplicatedStore) replicate() {
+	for ops := range s.ops {
+		for _, op := range ops {
+			var err error
+			if op.val == nil {
+				err = s.replicator.Delete(op.bucket, op.key)
+			} else {
+				err = s.replicator.Put(op.bucket, op.key, op.val)
+			}
+			if err != nil {
+				log.Printf("[replicate] ERROR: could not replicate %s/%s: %v", op.bucket, op.key, err)
+				s.markDirty(op.bucket)
+			}
+		}
+	}
+}
@@ -12,13 +12,41 @@
 package serialize
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
 	"os"
 	"path"
 )
 
+const (
+	headerPrefix = "#atlantis-state "
+	headerFormat = headerPrefix + "v1 length=%d sha256=%x\n"
+)
+
 var SaveDir string
 
+// Returned when saved state exists but can not be trusted, as opposed to there being no saved state at all
+type CorruptState struct {
+	File   string
+	Reason string
+}
+
+func (e *CorruptState) Error() string {
+	return fmt.Sprintf("corrupt state in %s: %s", e.File, e.Reason)
+}
+
+// Returns true if err is a *CorruptState
+func IsCorrupt(err error) bool {
+	_, ok := err.(*CorruptState)
+	return ok
+}
+
 func Init(saveDir string) error {
 	SaveDir = saveDir
 	err := os.MkdirAll(SaveDir, 0755)
@@ -35,33 +63,102 @@ type SaveDefinition struct {
 
 func SaveAll(defs ...SaveDefinition) {
 	for _, def := range defs {
-		SaveObject(def.File, def.Object)
+		if err := SaveObject(def.File, def.Object); err != nil {
+			log.Printf("[serialize] ERROR: could not save %s: %v", def.File, err)
+		}
 	}
 }
 
-// Use json to save an object to a file
+// Use json to save an object to a file, encrypted if there is a state key. The json is preceded by a header line
+// with its length and checksum so corruption can be detected when it is retrieved. The object is written to a temp
+// file in the same dir, synced and then renamed over the file, so a crash mid-write leaves either the old or the new
+// contents but never a mix.
 func SaveObject(file string, object interface{}) error {
-	fo, err := os.Create(path.Join(SaveDir, file))
+	data, err := marshal(object)
 	if err != nil {
 		return err
 	}
-	defer fo.Close()
-	e := json.NewEncoder(fo)
-	if err := e.Encode(object); err != nil {
+	data = append(data, '\n')
+	file = path.Join(SaveDir, file)
+	fo, err := ioutil.TempFile(path.Dir(file), "."+path.Base(file)+".tmp")
+	if err != nil {
 		return err
 	}
-	return nil
+	defer os.Remove(fo.Name()) // no-op once renamed
+	if _, err := fmt.Fprintf(fo, headerFormat, len(data), sha256.Sum256(data)); err != nil {
+		fo.Close()
+		return err
+	}
+	if _, err := fo.Write(data); err != nil {
+		fo.Close()
+		return err
+	}
+	if err := fo.Sync(); err != nil {
+		fo.Close()
+		return err
+	}
+	if err := fo.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(fo.Name(), file); err != nil {
+		return err
+	}
+	return syncDir(path.Dir(file))
 }
 
-// Use json to retrieve an object from a file
+// Sync a dir so a rename in it is durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Use json to retrieve an object from a file. Returns a *CorruptState if the file does not match its header or
+// holds anything other than exactly one json value. Files without a header, as saved by older versions or by other
+// tools, are read without the checksum.
 func RetrieveObject(file string, object interface{}) error {
-	fi, err := os.Open(path.Join(SaveDir, file))
+	data, err := ioutil.ReadFile(path.Join(SaveDir, file))
 	if err != nil {
 		return err
 	}
-	d := json.NewDecoder(fi)
-	if err := d.Decode(object); err != nil {
+	if bytes.HasPrefix(data, []byte(headerPrefix)) {
+		if data, err = checkHeader(data); err != nil {
+			return &CorruptState{File: file, Reason: err.Error()}
+		}
+	}
+	if data, err = unseal(file, bytes.TrimSpace(data)); err != nil {
 		return err
 	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	if err := d.Decode(object); err != nil {
+		return &CorruptState{File: file, Reason: err.Error()}
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return &CorruptState{File: file, Reason: "trailing data after the saved object"}
+	}
 	return nil
 }
+
+// Verify the header of data and return the json after it
+func checkHeader(data []byte) ([]byte, error) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return nil, errors.New("truncated header")
+	}
+	var length int
+	var checksum []byte
+	if _, err := fmt.Sscanf(string(data[:i+1]), headerFormat, &length, &checksum); err != nil {
+		return nil, errors.New("malformed header: " + err.Error())
+	}
+	data = data[i+1:]
+	if len(data) != length {
+		return nil, fmt.Errorf("expected %d bytes, found %d", length, len(data))
+	}
+	if sum := sha256.Sum256(data); !bytes.Equal(sum[:], checksum) {
+		return nil, errors.New("checksum mismatch")
+	}
+	return data, nil
+}
@@ -12,9 +12,18 @@
 package serialize
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"github.com/adjust/gocheck"
+	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestSerialize(t *testing.T) { gocheck.TestingT(t) }
@@ -54,3 +63,271 @@ func (s *SerializeSuite) TestSerialize(c *gocheck.C) {
 	c.Assert(retrievedMap, gocheck.DeepEquals, savedMap)
 	os.RemoveAll(SaveDir)
 }
+
+func (s *SerializeSuite) TestAtomicSave(c *gocheck.C) {
+	SaveDir = "save_test"
+	os.RemoveAll(SaveDir)
+	c.Assert(os.MkdirAll(SaveDir, 0755), gocheck.IsNil)
+	c.Assert(SaveObject("slice", []uint16{1, 2, 3}), gocheck.IsNil)
+	c.Assert(SaveObject("slice", []uint16{4, 5}), gocheck.IsNil)
+	var retrievedSlice []uint16
+	c.Assert(RetrieveObject("slice", &retrievedSlice), gocheck.IsNil)
+	c.Assert(retrievedSlice, gocheck.DeepEquals, []uint16{4, 5})
+	// no temp files are left behind
+	files, err := ioutil.ReadDir(SaveDir)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(files, gocheck.HasLen, 1)
+	// an unencodable object leaves the saved file alone
+	c.Assert(SaveObject("slice", func() {}), gocheck.NotNil)
+	c.Assert(RetrieveObject("slice", &retrievedSlice), gocheck.IsNil)
+	c.Assert(retrievedSlice, gocheck.DeepEquals, []uint16{4, 5})
+	files, err = ioutil.ReadDir(SaveDir)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(files, gocheck.HasLen, 1)
+	// truncated and concatenated files are rejected
+	c.Assert(ioutil.WriteFile(SaveDir+"/truncated", []byte(`[1, 2`), 0644), gocheck.IsNil)
+	c.Assert(RetrieveObject("truncated", &retrievedSlice), gocheck.NotNil)
+	c.Assert(ioutil.WriteFile(SaveDir+"/concatenated", []byte("[1]\n[2]\n"), 0644), gocheck.IsNil)
+	c.Assert(RetrieveObject("concatenated", &retrievedSlice), gocheck.NotNil)
+	os.RemoveAll(SaveDir)
+}
+
+func (s *SerializeSuite) TestCorruptState(c *gocheck.C) {
+	SaveDir = "save_test"
+	os.RemoveAll(SaveDir)
+	c.Assert(os.MkdirAll(SaveDir, 0755), gocheck.IsNil)
+	var retrievedSlice []uint16
+	err := RetrieveObject("missing", &retrievedSlice)
+	c.Assert(os.IsNotExist(err), gocheck.Equals, true)
+	c.Assert(IsCorrupt(err), gocheck.Equals, false)
+	c.Assert(SaveObject("slice", []uint16{1, 2, 3}), gocheck.IsNil)
+	data, err := ioutil.ReadFile(SaveDir + "/slice")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(string(data), gocheck.Matches, "#atlantis-state v1 length=8 sha256=[0-9a-f]{64}\n\\[1,2,3\\]\n")
+	// flipped bytes, truncation and a mangled header are all caught
+	header := string(data[:len(data)-8])
+	for _, corrupt := range []string{header + "[1,2,4]\n", header + "[1,2", "#atlantis-state v1 length=x\n[1,2,3]\n"} {
+		c.Assert(ioutil.WriteFile(SaveDir+"/slice", []byte(corrupt), 0644), gocheck.IsNil)
+		err = RetrieveObject("slice", &retrievedSlice)
+		c.Assert(IsCorrupt(err), gocheck.Equals, true, gocheck.Commentf("%q", corrupt))
+	}
+	// files without a header are still read
+	c.Assert(ioutil.WriteFile(SaveDir+"/slice", []byte("[4,5]\n"), 0644), gocheck.IsNil)
+	c.Assert(RetrieveObject("slice", &retrievedSlice), gocheck.IsNil)
+	c.Assert(retrievedSlice, gocheck.DeepEquals, []uint16{4, 5})
+	c.Assert(ioutil.WriteFile(SaveDir+"/state.db", []byte("not a bolt database"), 0644), gocheck.IsNil)
+	var retrievedMap map[string]int
+	c.Assert(IsCorrupt(NewBoltStore(SaveDir+"/state.db").RetrieveAll("ints", &retrievedMap)), gocheck.Equals, true)
+	os.RemoveAll(SaveDir)
+}
+
+func (s *SerializeSuite) TestBoltStore(c *gocheck.C) {
+	SaveDir = "save_test"
+	os.RemoveAll(SaveDir)
+	c.Assert(os.MkdirAll(SaveDir, 0755), gocheck.IsNil)
+	readOnly := &BoltStore{File: SaveDir + "/state.db", ReadOnly: true}
+	var retrievedMap map[string]*TestSerializeStruct
+	c.Assert(readOnly.RetrieveAll("structs", &retrievedMap), gocheck.NotNil)
+	store := NewBoltStore(SaveDir + "/state.db")
+	c.Assert(store.RetrieveAll("structs", &retrievedMap), gocheck.IsNil)
+	c.Assert(retrievedMap, gocheck.HasLen, 0)
+	one := &TestSerializeStruct{1, true, "one", []string{"one"}, map[string]string{"one": "yes"}}
+	two := &TestSerializeStruct{2, false, "two", nil, nil}
+	c.Assert(store.Update(Update{"structs", "one", one}, Update{"structs", "two", two},
+		Update{"slices", "ports", []uint16{1, 2}}), gocheck.IsNil)
+	c.Assert(readOnly.RetrieveAll("structs", &retrievedMap), gocheck.IsNil)
+	c.Assert(retrievedMap, gocheck.DeepEquals, map[string]*TestSerializeStruct{"one": one, "two": two})
+	// a failed update is rolled back as a whole
+	c.Assert(store.Update(Update{"structs", "two", nil}, Update{"structs", "three", func() {}}), gocheck.NotNil)
+	retrievedMap = nil
+	c.Assert(store.RetrieveAll("structs", &retrievedMap), gocheck.IsNil)
+	c.Assert(retrievedMap, gocheck.HasLen, 2)
+	c.Assert(store.Update(Update{"structs", "two", nil}), gocheck.IsNil)
+	retrievedMap = nil
+	c.Assert(store.RetrieveAll("structs", &retrievedMap), gocheck.IsNil)
+	c.Assert(retrievedMap, gocheck.DeepEquals, map[string]*TestSerializeStruct{"one": one})
+	var retrievedSlices map[string][]uint16
+	c.Assert(store.RetrieveAll("slices", &retrievedSlices), gocheck.IsNil)
+	c.Assert(retrievedSlices["ports"], gocheck.DeepEquals, []uint16{1, 2})
+	os.RemoveAll(SaveDir)
+}
+
+func (s *SerializeSuite) TestEncryptedState(c *gocheck.C) {
+	SaveDir = "save_test"
+	os.RemoveAll(SaveDir)
+	c.Assert(os.MkdirAll(SaveDir, 0755), gocheck.IsNil)
+	defer SetKey(nil)
+	// saved before there was a key, so in the clear
+	c.Assert(SaveObject("clear", map[string]string{"password": "hunter2"}), gocheck.IsNil)
+	key := []byte("0123456789abcdef0123456789abcdef")
+	c.Assert(SetKey(key[:16]), gocheck.NotNil)
+	c.Assert(SetKey(key), gocheck.IsNil)
+	c.Assert(Encrypting(), gocheck.Equals, true)
+	saved := map[string]string{"password": "hunter2"}
+	c.Assert(SaveObject("secret", saved), gocheck.IsNil)
+	data, err := ioutil.ReadFile(SaveDir + "/secret")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(strings.Contains(string(data), "hunter2"), gocheck.Equals, false)
+	var retrieved map[string]string
+	c.Assert(RetrieveObject("secret", &retrieved), gocheck.IsNil)
+	c.Assert(retrieved, gocheck.DeepEquals, saved)
+	retrieved = nil
+	c.Assert(RetrieveObject("clear", &retrieved), gocheck.IsNil)
+	c.Assert(retrieved, gocheck.DeepEquals, saved)
+	// records in a bolt store are encrypted one by one
+	store := NewBoltStore(SaveDir + "/state.db")
+	c.Assert(store.Update(Update{"secrets", "one", saved}), gocheck.IsNil)
+	var retrievedMap map[string]map[string]string
+	c.Assert(store.RetrieveAll("secrets", &retrievedMap), gocheck.IsNil)
+	c.Assert(retrievedMap["one"], gocheck.DeepEquals, saved)
+	data, err = ioutil.ReadFile(SaveDir + "/state.db")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(strings.Contains(string(data), "hunter2"), gocheck.Equals, false)
+	// a missing or wrong key is not corruption, the state must not be thrown away
+	c.Assert(SetKey([]byte("fedcba9876543210fedcba9876543210")), gocheck.IsNil)
+	err = RetrieveObject("secret", &retrieved)
+	c.Assert(err, gocheck.ErrorMatches, "secret is encrypted with key [0-9a-f]{8}, not the state key [0-9a-f]{8}")
+	c.Assert(IsCorrupt(err), gocheck.Equals, false)
+	c.Assert(SetKey(nil), gocheck.IsNil)
+	err = store.RetrieveAll("secrets", &retrievedMap)
+	c.Assert(err, gocheck.ErrorMatches,
+		".*state.db:secrets/one is encrypted with key [0-9a-f]{8}, but no state key is set")
+	c.Assert(IsCorrupt(err), gocheck.Equals, false)
+	// tampering is
+	c.Assert(SetKey(key), gocheck.IsNil)
+	var box sealed
+	c.Assert(json.Unmarshal(data[bytes.Index(data, sealedPrefix):bytes.IndexByte(data, '}')+1], &box), gocheck.IsNil)
+	box.Data[len(box.Data)-1] ^= 1
+	tampered, err := json.Marshal(&box)
+	c.Assert(err, gocheck.IsNil)
+	_, err = unseal("tampered", tampered)
+	c.Assert(IsCorrupt(err), gocheck.Equals, true)
+	os.RemoveAll(SaveDir)
+}
+
+func (s *SerializeSuite) TestLoadKey(c *gocheck.C) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	for _, encoded := range []string{string(key), hex.EncodeToString(key) + "\n",
+		base64.StdEncoding.EncodeToString(key)} {
+		parsed, err := parseKey([]byte(encoded))
+		c.Assert(err, gocheck.IsNil)
+		c.Assert(parsed, gocheck.DeepEquals, key)
+	}
+	_, err := parseKey([]byte("too short"))
+	c.Assert(err, gocheck.NotNil)
+	loaded, err := LoadKey("", "echo "+hex.EncodeToString(key))
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(loaded, gocheck.DeepEquals, key)
+	_, err = LoadKey("", "echo no key >&2; exit 1")
+	c.Assert(err, gocheck.ErrorMatches, "could not read the state key: exit status 1 no key")
+	loaded, err = LoadKey("", "")
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(loaded, gocheck.IsNil)
+}
+
+type memReplicator struct {
+	sync.Mutex
+	records map[string]string
+	down    bool // fail every call, like an unreachable replica
+}
+
+func (r *memReplicator) setDown(down bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.down = down
+}
+
+func (r *memReplicator) Put(bucket, key string, val []byte) error {
+	r.Lock()
+	defer r.Unlock()
+	if r.down {
+		return errors.New("replica down")
+	}
+	r.records[bucket+"/"+key] = string(val)
+	return nil
+}
+
+func (r *memReplicator) Delete(bucket, key string) error {
+	r.Lock()
+	defer r.Unlock()
+	if r.down {
+		return errors.New("replica down")
+	}
+	delete(r.records, bucket+"/"+key)
+	return nil
+}
+
+func (r *memReplicator) List(bucket string) (map[string][]byte, error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.down {
+		return nil, errors.New("replica down")
+	}
+	records := map[string][]byte{}
+	for path, val := range r.records {
+		if len(path) > len(bucket) && path[:len(bucket)+1] == bucket+"/" {
+			records[path[len(bucket)+1:]] = []byte(val)
+		}
+	}
+	return records, nil
+}
+
+// Wait for the replica to hold want
+func (r *memReplicator) waitFor(c *gocheck.C, want map[string]string) {
+	for i := 0; i < 100; i++ {
+		r.Lock()
+		records := map[string]string{}
+		for path, val := range r.records {
+			records[path] = val
+		}
+		r.Unlock()
+		if len(records) == len(want) {
+			match := true
+			for path, val := range want {
+				match = match && records[path] == val
+			}
+			if match {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("replica never matched %v", want)
+}
+
+func (s *SerializeSuite) TestReplicatedStore(c *gocheck.C) {
+	SaveDir = "save_test"
+	os.RemoveAll(SaveDir)
+	c.Assert(os.MkdirAll(SaveDir, 0755), gocheck.IsNil)
+	local := NewBoltStore(SaveDir + "/state.db")
+	c.Assert(local.Update(Update{"slices", "one", []int{1}}), gocheck.IsNil)
+	replica := &memReplicator{records: map[string]string{"slices/stale": "[0]"}}
+	store := NewReplicatedStore(local, replica)
+	c.Assert(store.Sync("slices"), gocheck.IsNil)
+	replica.waitFor(c, map[string]string{"slices/one": "[1]"})
+	c.Assert(store.Update(Update{"slices", "two", []int{2}}, Update{"slices", "one", nil}), gocheck.IsNil)
+	replica.waitFor(c, map[string]string{"slices/two": "[2]"})
+	var retrieved map[string][]int
+	c.Assert(store.RetrieveAll("slices", &retrieved), gocheck.IsNil)
+	c.Assert(retrieved, gocheck.DeepEquals, map[string][]int{"two": []int{2}})
+	os.RemoveAll(SaveDir)
+}
+
+func (s *SerializeSuite) TestReplicaResync(c *gocheck.C) {
+	SaveDir = "save_test"
+	os.RemoveAll(SaveDir)
+	c.Assert(os.MkdirAll(SaveDir, 0755), gocheck.IsNil)
+	ResyncInterval = 10 * time.Millisecond
+	defer func() { ResyncInterval = 10 * time.Second }()
+	local := NewBoltStore(SaveDir + "/state.db")
+	c.Assert(local.Update(Update{"slices", "one", []int{1}}), gocheck.IsNil)
+	replica := &memReplicator{records: map[string]string{}, down: true}
+	store := NewReplicatedStore(local, replica)
+	c.Assert(store.Sync("slices"), gocheck.ErrorMatches, "replica down")
+	c.Assert(store.Update(Update{"slices", "two", []int{2}}), gocheck.IsNil)
+	time.Sleep(50 * time.Millisecond)
+	// the replica gets what it missed once it is back, without another update or sync
+	replica.setDown(false)
+	replica.waitFor(c, map[string]string{"slices/one": "[1]", "slices/two": "[2]"})
+	os.RemoveAll(SaveDir)
+}
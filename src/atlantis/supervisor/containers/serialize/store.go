@@ -0,0 +1,156 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package serialize
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go.etcd.io/bbolt"
+	"os"
+	"sync"
+	"time"
+)
+
+// A store of json records grouped in buckets and keyed by name. Records are encrypted if there is a state key.
+type Store interface {
+	// Retrieve all the records of a bucket into object, a pointer to a map keyed by record name
+	RetrieveAll(bucket string, object interface{}) error
+	// Apply the updates in a single transaction
+	Update(updates ...Update) error
+}
+
+type Update struct {
+	Bucket string
+	Key    string
+	Object interface{} // nil deletes the record
+}
+
+// Keeps records in a bbolt database. The database is only open for the duration of each call so other
+// processes, like the monitor, can open it in between.
+type BoltStore struct {
+	sync.Mutex
+	File     string
+	ReadOnly bool
+	Timeout  time.Duration // how long to wait for another process to close the database
+}
+
+func NewBoltStore(file string) *BoltStore {
+	return &BoltStore{File: file, Timeout: 10 * time.Second}
+}
+
+func (s *BoltStore) open() (*bbolt.DB, error) {
+	if s.ReadOnly {
+		// a read-only open would fail on a file that does not exist rather than create it
+		if _, err := os.Stat(s.File); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bbolt.Open(s.File, 0600, &bbolt.Options{Timeout: s.Timeout, ReadOnly: s.ReadOnly})
+	if err == bbolt.ErrInvalid || err == bbolt.ErrChecksum || err == bbolt.ErrVersionMismatch {
+		return nil, &CorruptState{File: s.File, Reason: err.Error()}
+	}
+	return db, err
+}
+
+func (s *BoltStore) RetrieveAll(bucket string, object interface{}) error {
+	s.Lock()
+	defer s.Unlock()
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// the records are json already, so join them into a json object and decode that in one go
+	var buf bytes.Buffer
+	err = db.View(func(tx *bbolt.Tx) error {
+		buf.WriteByte('{')
+		if b := tx.Bucket([]byte(bucket)); b != nil {
+			first := true
+			err := b.ForEach(func(key, val []byte) error {
+				if !first {
+					buf.WriteByte(',')
+				}
+				first = false
+				jsonKey, err := json.Marshal(string(key))
+				if err != nil {
+					return err
+				}
+				if val, err = unseal(s.File+":"+bucket+"/"+string(key), val); err != nil {
+					return err
+				}
+				buf.Write(jsonKey)
+				buf.WriteByte(':')
+				buf.Write(val)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(buf.Bytes(), object); err != nil {
+		return &CorruptState{File: s.File, Reason: fmt.Sprintf("bucket %s: %v", bucket, err)}
+	}
+	return nil
+}
+
+func (s *BoltStore) Update(updates ...Update) error {
+	s.Lock()
+	defer s.Unlock()
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bbolt.Tx) error {
+		for _, update := range updates {
+			b, err := tx.CreateBucketIfNotExists([]byte(update.Bucket))
+			if err != nil {
+				return err
+			}
+			if update.Object == nil {
+				if err := b.Delete([]byte(update.Key)); err != nil {
+					return err
+				}
+				continue
+			}
+			val, err := marshal(update.Object)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(update.Key), val); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Copy the database to file, e.g. before it is rewritten
+func (s *BoltStore) Backup(file string) error {
+	s.Lock()
+	defer s.Unlock()
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(func(tx *bbolt.Tx) error {
+		return tx.CopyFile(file, 0600)
+	})
+}
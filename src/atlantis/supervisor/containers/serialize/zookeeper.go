@@ -0,0 +1,90 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package serialize
+
+import (
+	"github.com/go-zookeeper/zk"
+	"strings"
+	"time"
+)
+
+// Replicates to Zookeeper, keeping each record in the znode <Prefix>/<bucket>/<key>
+type ZookeeperReplicator struct {
+	conn   *zk.Conn
+	prefix string
+}
+
+func NewZookeeperReplicator(servers []string, prefix string) (*ZookeeperReplicator, error) {
+	conn, _, err := zk.Connect(servers, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &ZookeeperReplicator{conn: conn, prefix: strings.TrimRight(prefix, "/")}, nil
+}
+
+func (r *ZookeeperReplicator) path(bucket, key string) string {
+	if key == "" {
+		return r.prefix + "/" + bucket
+	}
+	return r.prefix + "/" + bucket + "/" + key
+}
+
+// Create the znode at path and any of its missing parents
+func (r *ZookeeperReplicator) create(path string, val []byte) error {
+	parts := strings.Split(strings.TrimLeft(path, "/"), "/")
+	for i := range parts[:len(parts)-1] {
+		parent := "/" + strings.Join(parts[:i+1], "/")
+		_, err := r.conn.Create(parent, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return err
+		}
+	}
+	_, err := r.conn.Create(path, val, 0, zk.WorldACL(zk.PermAll))
+	return err
+}
+
+func (r *ZookeeperReplicator) Put(bucket, key string, val []byte) error {
+	path := r.path(bucket, key)
+	_, err := r.conn.Set(path, val, -1)
+	if err == zk.ErrNoNode {
+		err = r.create(path, val)
+	}
+	return err
+}
+
+func (r *ZookeeperReplicator) Delete(bucket, key string) error {
+	err := r.conn.Delete(r.path(bucket, key), -1)
+	if err == zk.ErrNoNode {
+		return nil
+	}
+	return err
+}
+
+func (r *ZookeeperReplicator) List(bucket string) (map[string][]byte, error) {
+	records := map[string][]byte{}
+	keys, _, err := r.conn.Children(r.path(bucket, ""))
+	if err == zk.ErrNoNode {
+		return records, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		val, _, err := r.conn.Get(r.path(bucket, key))
+		if err == zk.ErrNoNode {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		records[key] = val
+	}
+	return records, nil
+}
@@ -0,0 +1,183 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/docker"
+	"atlantis/supervisor/rpc/types"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	sidecarLock  = sync.Mutex{}
+	sidecarStops = map[string]chan bool{} // container id -> closed to stop watching the container's sidecars
+)
+
+// Deploy the sidecars declared in the container's manifest, and the TLS sidecar if it has one. If one fails, the
+// ones already deployed are torn down again.
+func deploySidecars(c *Container) error {
+	specs := sidecarSpecs(c)
+	if len(specs) == 0 {
+		return nil
+	}
+	sidecars := make([]*types.SidecarContainer, 0, len(specs))
+	for _, spec := range specs {
+		sidecar := &types.SidecarContainer{
+			ID:              c.ID + "-" + spec.Name,
+			PrimaryID:       c.ID,
+			PrimaryDockerID: c.DockerID,
+			PrimaryPort:     c.PrimaryPort,
+			Host:            c.Host,
+			Env:             c.Env,
+			Spec:            *spec.DeepCopy(),
+			Running:         true,
+		}
+		if err := docker.Deploy(sidecar, nil); err != nil {
+			teardownSidecars(sidecars)
+			return fmt.Errorf("Could not deploy sidecar %s: %v", spec.Name, err)
+		}
+		sidecars = append(sidecars, sidecar)
+	}
+	c.Sidecars = sidecars
+	return nil
+}
+
+func teardownSidecars(sidecars []*types.SidecarContainer) {
+	for _, sidecar := range sidecars {
+		if err := docker.Teardown(sidecar); err != nil {
+			log.Printf("[sidecar] could not teardown %s: %v", sidecar.ID, err)
+		}
+	}
+}
+
+// Restart a sidecar through the containerManager
+func RestartSidecar(id, name string, exitCode int) error {
+	respChan := make(chan error)
+	req := &RestartReq{id, exitCode, fmt.Sprintf("exited with code %d", exitCode), name, respChan}
+	restartChan <- req
+	err := <-respChan
+	close(respChan)
+	return err
+}
+
+// Start restarting the named sidecar on a goroutine of its own; restartDone replaces it with the updated copy. Must
+// be called from the containerManager.
+func restartSidecar(container *Container, name, reason string, respChan chan error) {
+	key := container.ID + "/" + name
+	if restarting[container.ID] || restarting[key] {
+		replyRestart(respChan, errors.New("Container is already restarting."))
+		return
+	}
+	for _, sidecar := range container.Sidecars {
+		if sidecar.Spec.Name != name {
+			continue
+		}
+		restarting[key] = true
+		go func(sidecar *types.SidecarContainer, primaryDockerID string) {
+			restarted, err := restartedSidecar(sidecar, primaryDockerID, reason)
+			restartDoneChan <- &RestartDoneReq{id: container.ID, reason: reason, sidecar: name, err: err,
+				sidecars: []*types.SidecarContainer{restarted}, respChan: respChan}
+		}(sidecar, container.DockerID)
+		return
+	}
+	replyRestart(respChan, errors.New("Unknown Sidecar."))
+}
+
+// Restart a copy of the sidecar in docker. The copy is returned whether or not it restarted.
+func restartedSidecar(sidecar *types.SidecarContainer, primaryDockerID, reason string) (*types.SidecarContainer,
+	error) {
+	log.Printf("[sidecar] restart %s: %s", sidecar.ID, reason)
+	restarted := *sidecar
+	restarted.PrimaryDockerID = primaryDockerID
+	err := docker.Restart(&restarted)
+	restarted.Running = err == nil
+	if err != nil {
+		log.Printf("[sidecar] -> error restarting %s: %v", sidecar.ID, err)
+	} else {
+		restarted.Restarts++
+	}
+	return &restarted, err
+}
+
+// Sidecars share the primary container's network namespace, which is recreated when the primary restarts. Returns
+// the restarted copies.
+func restartAllSidecars(c *Container, reason string) []*types.SidecarContainer {
+	sidecars := make([]*types.SidecarContainer, len(c.Sidecars))
+	for i, sidecar := range c.Sidecars {
+		sidecars[i], _ = restartedSidecar(sidecar, c.DockerID, reason)
+	}
+	return sidecars
+}
+
+// Replace the container's sidecars with the copies of the same name. Must be called from the containerManager.
+func replaceSidecars(container *Container, restarted []*types.SidecarContainer) {
+	sidecars := make([]*types.SidecarContainer, len(container.Sidecars))
+	for i, sidecar := range container.Sidecars {
+		sidecars[i] = sidecar
+		for _, copied := range restarted {
+			if copied.Spec.Name == sidecar.Spec.Name {
+				sidecars[i] = copied
+			}
+		}
+	}
+	container.Sidecars = sidecars
+}
+
+func sidecarLoop(id string, stop chan bool) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(RestartWatchInterval):
+		}
+		cont := Get(id)
+		if cont == nil {
+			return
+		}
+		for _, sidecar := range cont.Sidecars {
+			running, exitCode, err := docker.State(sidecar)
+			if err != nil {
+				log.Printf("[sidecar] could not get state of %s: %v", sidecar.ID, err)
+			} else if !running {
+				RestartSidecar(id, sidecar.Spec.Name, exitCode)
+			}
+		}
+	}
+}
+
+// Start watching the container's sidecars, restarting any that exit
+func startSidecarWatch(c *Container) {
+	if pretending() || len(c.Sidecars) == 0 {
+		return
+	}
+	sidecarLock.Lock()
+	defer sidecarLock.Unlock()
+	if _, watching := sidecarStops[c.ID]; watching {
+		return
+	}
+	stop := make(chan bool)
+	sidecarStops[c.ID] = stop
+	go sidecarLoop(c.ID, stop)
+}
+
+func stopSidecarWatch(id string) {
+	sidecarLock.Lock()
+	defer sidecarLock.Unlock()
+	if stop, watching := sidecarStops[id]; watching {
+		close(stop)
+		delete(sidecarStops, id)
+	}
+}
@@ -0,0 +1,181 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/containers/serialize"
+	"atlantis/supervisor/rpc/types"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SnapshotDir    = "snapshots"
+	snapshotFormat = "20060102T150405.000000000Z"
+	snapshotSuffix = ".json"
+)
+
+var NumSnapshots = 0 // set before Init. the number of versions of the saved state to keep, 0 to keep none
+
+type RollbackReq struct {
+	id       string
+	respChan chan *RollbackResp
+}
+
+type RollbackResp struct {
+	ids []string
+	err error
+}
+
+func snapshotPath(id string) string {
+	return path.Join(SnapshotDir, id+snapshotSuffix)
+}
+
+// Save a timestamped copy of the whole state and remove the oldest copies beyond NumSnapshots
+func snapshot() {
+	if NumSnapshots <= 0 {
+		return
+	}
+	if err := os.MkdirAll(path.Join(serialize.SaveDir, SnapshotDir), 0755); err != nil {
+		log.Printf("[snapshot] ERROR: could not create %s: %v", SnapshotDir, err)
+		return
+	}
+	id := time.Now().UTC().Format(snapshotFormat)
+	if err := serialize.SaveObject(snapshotPath(id), currentState()); err != nil {
+		log.Printf("[snapshot] ERROR: could not save %s: %v", id, err)
+		return
+	}
+	ids, err := snapshotIDs()
+	if err != nil {
+		log.Printf("[snapshot] ERROR: could not list snapshots: %v", err)
+		return
+	}
+	for len(ids) > NumSnapshots {
+		if err := os.Remove(path.Join(serialize.SaveDir, snapshotPath(ids[0]))); err != nil {
+			log.Printf("[snapshot] ERROR: could not remove %s: %v", ids[0], err)
+		}
+		ids = ids[1:]
+	}
+}
+
+// The ids of the saved snapshots, oldest first
+func snapshotIDs() ([]string, error) {
+	files, err := ioutil.ReadDir(path.Join(serialize.SaveDir, SnapshotDir))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, file := range files {
+		if name := file.Name(); strings.HasSuffix(name, snapshotSuffix) && !strings.HasPrefix(name, ".") {
+			ids = append(ids, strings.TrimSuffix(name, snapshotSuffix))
+		}
+	}
+	sort.Strings(ids) // the timestamps sort in time order
+	return ids, nil
+}
+
+// List the saved versions of the state, oldest first
+func StateSnapshots() ([]*types.StateSnapshot, error) {
+	ids, err := snapshotIDs()
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]*types.StateSnapshot, 0, len(ids))
+	for _, id := range ids {
+		snapshotTime, err := time.Parse(snapshotFormat, id)
+		if err != nil {
+			continue // not one of ours
+		}
+		var state types.StateBackup
+		if err := serialize.RetrieveObject(snapshotPath(id), &state); err != nil {
+			log.Printf("[snapshot] ERROR: could not read %s: %v", id, err)
+			continue
+		}
+		snapshots = append(snapshots, &types.StateSnapshot{ID: id, Time: snapshotTime,
+			Containers: len(state.Containers)})
+	}
+	return snapshots, nil
+}
+
+// Replace the containers and free ports with a snapshot, e.g. after a bad save. Only the saved state changes, docker
+// containers are not deployed or torn down. Returns the ids of the containers in the snapshot.
+func RollbackState(id string) ([]string, error) {
+	respChan := make(chan *RollbackResp)
+	req := &RollbackReq{id, respChan}
+	rollbackChan <- req
+	resp := <-respChan
+	close(respChan)
+	return resp.ids, resp.err
+}
+
+func rollbackState(req *RollbackReq) {
+	if _, err := time.Parse(snapshotFormat, req.id); err != nil {
+		req.respChan <- &RollbackResp{err: errors.New("Invalid snapshot id.")}
+		return
+	}
+	var state types.StateBackup
+	if err := serialize.RetrieveObject(snapshotPath(req.id), &state); err != nil {
+		if os.IsNotExist(err) {
+			err = errors.New("Unknown snapshot.")
+		}
+		req.respChan <- &RollbackResp{err: err}
+		return
+	}
+	if err := checkLayout(&state); err != nil {
+		req.respChan <- &RollbackResp{err: err}
+		return
+	}
+	changed := make([]string, 0, len(containers)+len(state.Containers))
+	for id := range containers {
+		if state.Containers[id] == nil {
+			changed = append(changed, id)
+		}
+	}
+	ids := make([]string, 0, len(state.Containers))
+	for id := range state.Containers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	changed = append(changed, ids...)
+	for _, id := range changed {
+		stopProbes(id)
+		stopLogShipping(id)
+		stopCapture(id)
+		stopWatch(id)
+		stopSidecarWatch(id)
+		delete(containers, id)
+	}
+	for id, cont := range state.Containers {
+		if err := cont.Manifest.Migrate(); err != nil {
+			log.Printf("-> could not migrate manifest of %s: %v", id, err)
+		}
+		containers[id] = &Container{Container: *cont}
+		startProbes(containers[id])
+		startLogShipping(containers[id])
+		startCapture(containers[id])
+		startWatch(containers[id])
+		startSidecarWatch(containers[id])
+	}
+	ports = state.FreePorts
+	countResources()
+	save(changed...)
+	log.Printf("[snapshot] rolled back to %s", req.id)
+	req.respChan <- &RollbackResp{ids: ids}
+}
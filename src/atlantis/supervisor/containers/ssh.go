@@ -13,15 +13,23 @@ package containers
 
 import (
 	"atlantis/supervisor/rpc/types"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 )
 
 type SSHCmd []string
 
+// Host the supervisor reaches the containers' ssh ports on, e.g. ::1 on hosts without IPv4
+var LocalSSHHost = "localhost"
+
+// How long to wait for a new container's sshd to offer its host keys
+var HostKeyTimeout = 30 * time.Second
+
 func pretending() bool {
 	return os.Getenv("SUPERVISOR_PRETEND") != ""
 }
@@ -41,33 +49,64 @@ func (s SSHCmd) Execute() error {
 	return err
 }
 
+// Returns the ssh arguments to run cmd as root inside the container
+func containerSSHArgs(c types.GenericContainer, cmd string) []string {
+	return []string{"-p", fmt.Sprintf("%d", c.GetSSHPort()), "-i", "/opt/atlantis/supervisor/master_id_rsa", "-o",
+		"UserKnownHostsFile=/dev/null", "-o", "StrictHostKeyChecking=no", "root@" + types.UnbracketHost(LocalSSHHost),
+		cmd}
+}
+
 func AuthorizeSSHUser(c types.GenericContainer, user, publicKey string) error {
 	// copy file to container
 	// rebuild authorize_keys
-	return SSHCmd{"-p", fmt.Sprintf("%d", c.GetSSHPort()), "-i", "/opt/atlantis/supervisor/master_id_rsa", "-o",
-		"UserKnownHostsFile=/dev/null", "-o", "StrictHostKeyChecking=no", "root@localhost",
-		fmt.Sprintf("echo \"%s\" >/root/.ssh/authorized_keys.d/%s.pub && rebuild_authorized_keys", publicKey,
-			user)}.Execute()
+	return SSHCmd(containerSSHArgs(c, fmt.Sprintf("echo \"%s\" >/root/.ssh/authorized_keys.d/%s.pub && "+
+		"rebuild_authorized_keys", publicKey, user))).Execute()
 }
 
 func DeauthorizeSSHUser(c types.GenericContainer, user string) error {
 	// delete file from container
 	// rebuild authorize_keys
-	return SSHCmd{"-p", fmt.Sprintf("%d", c.GetSSHPort()), "-i", "/opt/atlantis/supervisor/master_id_rsa", "-o",
-		"UserKnownHostsFile=/dev/null", "-o", "StrictHostKeyChecking=no", "root@localhost",
-		fmt.Sprintf("rm /root/.ssh/authorized_keys.d/%s.pub && rebuild_authorized_keys",
-			user)}.Execute()
+	return SSHCmd(containerSSHArgs(c, fmt.Sprintf("rm /root/.ssh/authorized_keys.d/%s.pub && "+
+		"rebuild_authorized_keys", user))).Execute()
 }
 
 func SetMaintenance(c types.GenericContainer, maint bool) error {
 	if maint {
 		// touch /etc/maint
-		return SSHCmd{"-p", fmt.Sprintf("%d", c.GetSSHPort()), "-i", "/opt/atlantis/supervisor/master_id_rsa", "-o",
-			"UserKnownHostsFile=/dev/null", "-o", "StrictHostKeyChecking=no", "root@localhost",
-			"touch /etc/maint"}.Execute()
+		return SSHCmd(containerSSHArgs(c, "touch /etc/maint")).Execute()
 	}
 	// rm -f /etc/maint
-	return SSHCmd{"-p", fmt.Sprintf("%d", c.GetSSHPort()), "-i", "/opt/atlantis/supervisor/master_id_rsa", "-o",
-		"UserKnownHostsFile=/dev/null", "-o", "StrictHostKeyChecking=no", "root@localhost",
-		"rm -f /etc/maint"}.Execute()
+	return SSHCmd(containerSSHArgs(c, "rm -f /etc/maint")).Execute()
+}
+
+// Returns the container's ssh host keys as "<type> <key>" lines, so the monitor can verify it is talking to the
+// container. They are scanned over loopback right after the deploy, where nothing can stand in for the container.
+func scanHostKeys(c types.GenericContainer) ([]string, error) {
+	if pretending() {
+		return nil, nil
+	}
+	deadline := time.Now().Add(HostKeyTimeout)
+	for {
+		// sshd may still be starting
+		output, err := exec.Command("ssh-keyscan", "-T", "5", "-p", fmt.Sprintf("%d", c.GetSSHPort()),
+			types.UnbracketHost(LocalSSHHost)).Output()
+		var keys []string
+		for _, line := range strings.Split(string(output), "\n") {
+			// <host> <type> <key>
+			fields := strings.Fields(line)
+			if len(fields) == 3 && !strings.HasPrefix(fields[0], "#") {
+				keys = append(keys, fields[1]+" "+fields[2])
+			}
+		}
+		if len(keys) > 0 {
+			return keys, nil
+		}
+		if time.Now().After(deadline) {
+			if err == nil {
+				err = errors.New("no host keys offered")
+			}
+			return nil, fmt.Errorf("could not scan the ssh host keys of %s: %v", c.GetID(), err)
+		}
+		time.Sleep(time.Second)
+	}
 }
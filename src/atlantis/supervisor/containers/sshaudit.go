@@ -0,0 +1,128 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/containers/serialize"
+	"atlantis/supervisor/rpc/types"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSSHAuditRetention = 400 * 24 * time.Hour
+	SSHAuditFile             = "ssh_audit"
+)
+
+var SSHAuditRetention = DefaultSSHAuditRetention // records older than this are dropped unless the access still holds
+
+var (
+	sshAuditLock sync.Mutex
+	sshAudit     []*types.SSHAccessRecord // oldest first
+)
+
+func loadSSHAudit() error {
+	sshAuditLock.Lock()
+	defer sshAuditLock.Unlock()
+	sshAudit = nil
+	if err := serialize.RetrieveObject(SSHAuditFile, &sshAudit); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Returns the OpenSSH SHA256 fingerprint of a public key in authorized_keys format, or "" if it can't be parsed
+func SSHKeyFingerprint(publicKey string) string {
+	for _, field := range strings.Fields(publicKey) {
+		blob, err := base64.StdEncoding.DecodeString(field)
+		if err != nil || len(blob) < 4 {
+			continue
+		}
+		sum := sha256.Sum256(blob)
+		return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+	}
+	return ""
+}
+
+// Add a record to the audit trail and save it. The time is set to now.
+func AuditSSHAccess(record *types.SSHAccessRecord) error {
+	sshAuditLock.Lock()
+	defer sshAuditLock.Unlock()
+	record.Time = time.Now()
+	sshAudit = append(sshAudit, record)
+	cutoff := record.Time.Add(-SSHAuditRetention)
+	ends := sshAccessEnds(sshAudit)
+	kept := []*types.SSHAccessRecord{}
+	for i, r := range sshAudit {
+		// an old authorization is still needed to show who has access today
+		if r.Time.After(cutoff) || (r.Action == types.SSHAccessAuthorized && (ends[i].IsZero() || ends[i].After(cutoff))) {
+			kept = append(kept, r)
+		}
+	}
+	sshAudit = kept
+	return serialize.SaveObject(SSHAuditFile, sshAudit)
+}
+
+// Returns when the access each authorization in records gave ended: at the next deauthorization of the user on the
+// container, or when the certificate expired if that was earlier. Zero if it has not ended.
+func sshAccessEnds(records []*types.SSHAccessRecord) []time.Time {
+	ends := make([]time.Time, len(records))
+	for i, r := range records {
+		if r.Action != types.SSHAccessAuthorized {
+			continue
+		}
+		ends[i] = r.ValidBefore
+		for _, later := range records[i+1:] {
+			if later.Action == types.SSHAccessDeauthorized && later.ContainerID == r.ContainerID &&
+				later.User == r.User {
+				if ends[i].IsZero() || later.Time.Before(ends[i]) {
+					ends[i] = later.Time
+				}
+				break
+			}
+		}
+		if !ends[i].IsZero() && ends[i].After(time.Now()) {
+			ends[i] = time.Time{}
+		}
+	}
+	return ends
+}
+
+// Returns the audit trail between since and until (now if zero), and the access that was held at any time in
+// between. Only the records of id and user if they are not empty.
+func SSHAudit(id, user string, since, until time.Time) ([]*types.SSHAccessRecord, []*types.SSHAccessGrant) {
+	sshAuditLock.Lock()
+	defer sshAuditLock.Unlock()
+	if until.IsZero() {
+		until = time.Now()
+	}
+	records := []*types.SSHAccessRecord{}
+	grants := []*types.SSHAccessGrant{}
+	ends := sshAccessEnds(sshAudit)
+	for i, r := range sshAudit {
+		if (id != "" && r.ContainerID != id) || (user != "" && r.User != user) {
+			continue
+		}
+		if !r.Time.Before(since) && !r.Time.After(until) {
+			records = append(records, r)
+		}
+		if r.Action == types.SSHAccessAuthorized && !r.Time.After(until) && (ends[i].IsZero() || ends[i].After(since)) {
+			grants = append(grants, &types.SSHAccessGrant{ContainerID: r.ContainerID, User: r.User,
+				Fingerprint: r.Fingerprint, Requester: r.Requester, From: r.Time, Until: ends[i]})
+		}
+	}
+	return records, grants
+}
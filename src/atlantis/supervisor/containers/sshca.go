@@ -0,0 +1,218 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/containers/serialize"
+	"atlantis/supervisor/docker"
+	"atlantis/supervisor/helper"
+	"atlantis/supervisor/rpc/types"
+	atypes "atlantis/types"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSSHCertTTL = 8 * time.Hour
+	SSHCertsFile      = "ssh_certs"
+	sshCADir          = "ssh" // in the containers' config dirs, where their sshd finds the CA, principal and KRL
+)
+
+var (
+	// set before Init. the private key of the SSH CA that signs certificates for AuthorizeSSH, its public key is
+	// next to it as <key>.pub. empty to authorize raw public keys instead.
+	SSHCAKey   = ""
+	SSHCertTTL = DefaultSSHCertTTL // set before Init. how long the certificates are valid
+)
+
+var (
+	sshCertsLock sync.Mutex
+	sshCerts     []*types.SSHCert // issued certificates that have not expired yet
+)
+
+func SSHCAEnabled() bool {
+	return SSHCAKey != ""
+}
+
+// Returns the principal certificates for a container are issued for. Each container only accepts its own, so a
+// certificate can not be used on any other container.
+func sshPrincipal(id string) string {
+	return "atlantis-" + id
+}
+
+func loadSSHCerts() error {
+	sshCertsLock.Lock()
+	defer sshCertsLock.Unlock()
+	sshCerts = nil
+	if err := serialize.RetrieveObject(SSHCertsFile, &sshCerts); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Drop the expired certificates and save the rest. sshCertsLock must be held.
+func saveSSHCerts() error {
+	now := time.Now()
+	current := []*types.SSHCert{}
+	for _, cert := range sshCerts {
+		if cert.ValidBefore.After(now) {
+			current = append(current, cert)
+		}
+	}
+	sshCerts = current
+	return serialize.SaveObject(SSHCertsFile, sshCerts)
+}
+
+// Make the container's sshd trust certificates the CA issues for it. The CA key, principal and revocation list
+// live in the config dir, which the supervisor can update without logging in; sshd_config only has to point at
+// them once.
+func trustSSHCA(c *Container) error {
+	if !SSHCAEnabled() {
+		return nil
+	}
+	if pretending() {
+		log.Printf("[pretend] trust the ssh ca in %s", c.ID)
+		return nil
+	}
+	caKey, err := ioutil.ReadFile(SSHCAKey + ".pub")
+	if err != nil {
+		return fmt.Errorf("could not read the ssh ca key: %v", err)
+	}
+	dir := path.Join(helper.HostConfigDir(c.ID), sshCADir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "ca.pub"), caKey, 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "principals"), []byte(sshPrincipal(c.ID)+"\n"), 0644); err != nil {
+		return err
+	}
+	// sshd refuses every key if its RevokedKeys file is missing
+	if err := ioutil.WriteFile(path.Join(dir, "revoked_keys"), nil, 0644); err != nil {
+		return err
+	}
+	if err := docker.ChownToContainerRoot(dir); err != nil {
+		return err
+	}
+	containerDir := path.Join(atypes.ContainerConfigDir, sshCADir)
+	return SSHCmd(containerSSHArgs(c, fmt.Sprintf("if ! grep -q '^TrustedUserCAKeys' /etc/ssh/sshd_config; then "+
+		"printf 'TrustedUserCAKeys %[1]s/ca.pub\\nAuthorizedPrincipalsFile %[1]s/principals\\n"+
+		"RevokedKeys %[1]s/revoked_keys\\n' >>/etc/ssh/sshd_config && kill -HUP $(cat /var/run/sshd.pid); fi",
+		containerDir))).Execute()
+}
+
+// Sign the user's public key with the CA, so it can log in as root to the container until the certificate
+// expires or is revoked
+func IssueSSHCert(c types.GenericContainer, user, publicKey string) (*types.SSHCert, error) {
+	cert := &types.SSHCert{ContainerID: c.GetID(), User: user, KeyID: user + "@" + c.GetID(),
+		Serial: uint64(time.Now().UnixNano()), ValidBefore: time.Now().Add(SSHCertTTL)}
+	if pretending() {
+		log.Printf("[pretend] ssh-keygen -s %s -I %s -n %s", SSHCAKey, cert.KeyID, sshPrincipal(c.GetID()))
+		cert.Certificate = "pretend-cert " + cert.KeyID
+	} else {
+		dir, err := ioutil.TempDir("", "atlantis-ssh-cert")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		keyFile := path.Join(dir, "key.pub")
+		if err := ioutil.WriteFile(keyFile, []byte(strings.TrimSpace(publicKey)+"\n"), 0600); err != nil {
+			return nil, err
+		}
+		output, err := exec.Command("ssh-keygen", "-q", "-s", SSHCAKey, "-I", cert.KeyID, "-n",
+			sshPrincipal(c.GetID()), "-V", fmt.Sprintf("+%ds", int64(SSHCertTTL/time.Second)), "-z",
+			fmt.Sprintf("%d", cert.Serial), keyFile).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("could not sign the key of %s: %v %s", user, err, strings.TrimSpace(string(output)))
+		}
+		signed, err := ioutil.ReadFile(path.Join(dir, "key-cert.pub"))
+		if err != nil {
+			return nil, err
+		}
+		cert.Certificate = strings.TrimSpace(string(signed))
+	}
+	sshCertsLock.Lock()
+	defer sshCertsLock.Unlock()
+	sshCerts = append(sshCerts, cert)
+	if err := saveSSHCerts(); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// Revoke the certificates issued to the user for the container that have not expired yet. Returns how many were
+// revoked.
+func RevokeSSHCerts(c types.GenericContainer, user string) (int, error) {
+	sshCertsLock.Lock()
+	defer sshCertsLock.Unlock()
+	revoked := 0
+	spec := ""
+	for _, cert := range sshCerts {
+		if cert.ContainerID != c.GetID() {
+			continue
+		}
+		if cert.User == user && !cert.Revoked {
+			cert.Revoked = true
+			revoked++
+		}
+		if cert.Revoked {
+			spec += fmt.Sprintf("serial: %d\n", cert.Serial)
+		}
+	}
+	if err := saveSSHCerts(); err != nil {
+		return 0, err
+	}
+	if err := writeKRL(c.GetID(), spec); err != nil {
+		return 0, err
+	}
+	return revoked, nil
+}
+
+// Replace the container's revocation list with one revoking the serials in spec, a ssh-keygen KRL spec
+func writeKRL(id, spec string) error {
+	if pretending() {
+		log.Printf("[pretend] ssh-keygen -k for %s:\n%s", id, spec)
+		return nil
+	}
+	dir := path.Join(helper.HostConfigDir(id), sshCADir)
+	krl := path.Join(dir, "revoked_keys")
+	if spec == "" {
+		return ioutil.WriteFile(krl, nil, 0644)
+	}
+	tmpDir, err := ioutil.TempDir("", "atlantis-krl")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	specFile := path.Join(tmpDir, "spec")
+	if err := ioutil.WriteFile(specFile, []byte(spec), 0600); err != nil {
+		return err
+	}
+	newKRL := path.Join(dir, ".revoked_keys.new")
+	output, err := exec.Command("ssh-keygen", "-k", "-f", newKRL, "-s", SSHCAKey+".pub", specFile).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("could not build the revocation list of %s: %v %s", id, err,
+			strings.TrimSpace(string(output)))
+	}
+	if err := docker.ChownToContainerRoot(newKRL); err != nil {
+		return err
+	}
+	return os.Rename(newKRL, krl)
+}
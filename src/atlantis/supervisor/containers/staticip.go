@@ -0,0 +1,93 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/docker"
+	"atlantis/supervisor/rpc/types"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Parse StaticIPRange
+func initStaticIPs() error {
+	ipRange, err := ParseStaticIPRange(StaticIPNetwork, StaticIPRange)
+	if err != nil {
+		return errors.New("Invalid Config. " + err.Error())
+	}
+	staticIPRange = ipRange
+	docker.StaticIPNetwork = ""
+	if ipRange != nil {
+		docker.StaticIPNetwork = StaticIPNetwork
+	}
+	return nil
+}
+
+// Returns the range the supervisor allocates the network's IPs from, nil if neither is set. The range should
+// leave out the network's gateway, like the --ip-range of the docker network, since the supervisor hands out
+// every address in it but the first and the last.
+func ParseStaticIPRange(network, ipRange string) (*net.IPNet, error) {
+	if network == "" && ipRange == "" {
+		return nil, nil
+	}
+	if network == "" || ipRange == "" {
+		return nil, errors.New("static IPs need both a network and a range")
+	}
+	if err := types.ValidateNetworkMode(network); err != nil || network == types.NetworkBridge ||
+		network == types.NetworkHost {
+		return nil, fmt.Errorf("static IPs need a user defined docker network, got %q", network)
+	}
+	_, parsed, err := net.ParseCIDR(ipRange)
+	if err != nil || parsed.IP.To4() == nil {
+		return nil, fmt.Errorf("the static IP range should be an IPv4 CIDR, got %q", ipRange)
+	}
+	return parsed, nil
+}
+
+// Returns true if the supervisor allocates containers deployed with the manifest their IP
+func needsStaticIP(manifest *types.Manifest) bool {
+	return staticIPRange != nil && manifest.NetworkMode == StaticIPNetwork
+}
+
+// Returns the lowest address in the static IP range no container has, nil if there is none or the manifest's
+// containers get their address from docker
+func freeStaticIP(manifest *types.Manifest) net.IP {
+	if !needsStaticIP(manifest) {
+		return nil
+	}
+	used := map[string]bool{}
+	for _, cont := range containers {
+		if cont.IP != "" {
+			used[cont.IP] = true
+		}
+	}
+	// skip the network address, and the broadcast address at the end
+	for ip := nextIP(staticIPRange.IP); staticIPRange.Contains(nextIP(ip)); ip = nextIP(ip) {
+		if !used[ip.String()] {
+			return ip
+		}
+	}
+	return nil
+}
+
+// Returns the address after ip
+func nextIP(ip net.IP) net.IP {
+	next := append(net.IP{}, ip.To4()...)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
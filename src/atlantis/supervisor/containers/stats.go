@@ -0,0 +1,160 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/cgroup"
+	"atlantis/supervisor/containers/serialize"
+	"atlantis/supervisor/rpc/types"
+	"atlantis/supervisor/stats"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	StatsDir              = "stats" // in the save dir, a ring file per container
+	DefaultStatsRetention = 7 * 24 * time.Hour
+)
+
+// How often the usage of every container is sampled, 0 to keep no history, and how long samples are kept. The
+// history of a torn down container is kept for StatsRetention too. Set before Init.
+var (
+	StatsInterval  time.Duration
+	StatsRetention = DefaultStatsRetention
+)
+
+var (
+	statsOnce   sync.Once
+	lastSamples = map[string]*types.StatsSample{} // container id -> previous sample, only used by statsLoop
+)
+
+func statsFile(id string) string {
+	return path.Join(serialize.SaveDir, StatsDir, id)
+}
+
+// The number of samples a ring file holds, enough for StatsRetention
+func statsCapacity() int {
+	if capacity := int(StatsRetention / StatsInterval); capacity > 1 {
+		return capacity
+	}
+	return 1
+}
+
+func startStats() {
+	if StatsInterval <= 0 {
+		return
+	}
+	if err := os.MkdirAll(path.Join(serialize.SaveDir, StatsDir), 0755); err != nil {
+		log.Printf("[stats] WARNING: no history will be kept: %v", err)
+		return
+	}
+	statsOnce.Do(func() { go statsLoop() })
+}
+
+func statsLoop() {
+	for {
+		time.Sleep(StatsInterval)
+		sampleStats(time.Now())
+	}
+}
+
+// Sample the usage of every running container and drop the history of containers gone for StatsRetention
+func sampleStats(now time.Time) {
+	conts, _ := List()
+	for id, cont := range conts {
+		if cont.Pid == 0 || cont.RunState != types.ContainerRunning {
+			delete(lastSamples, id)
+			continue
+		}
+		sample, err := containerSample(cont.Pid, now)
+		if err != nil {
+			log.Printf("[stats] could not sample %s: %v", id, err)
+			continue
+		}
+		if last := lastSamples[id]; last != nil && sample.CPUTime >= last.CPUTime && now.After(last.Time) {
+			sample.CPUCores = float64(sample.CPUTime-last.CPUTime) / float64(now.Sub(last.Time))
+		}
+		lastSamples[id] = sample
+		if err := stats.Append(statsFile(id), statsCapacity(), sample); err != nil {
+			log.Printf("[stats] could not save the sample of %s: %v", id, err)
+		}
+	}
+	for id := range lastSamples {
+		if _, exists := conts[id]; !exists {
+			delete(lastSamples, id)
+		}
+	}
+	pruneStats(conts, now)
+}
+
+func containerSample(pid int, now time.Time) (*types.StatsSample, error) {
+	usage, err := cgroup.ContainerUsage(pid)
+	if err != nil {
+		return nil, err
+	}
+	rx, tx, err := stats.NetworkUsage(pid)
+	if err != nil {
+		return nil, err
+	}
+	return &types.StatsSample{Time: now, CPUTime: usage.CPUTime, MemoryBytes: usage.MemoryBytes, RxBytes: rx,
+		TxBytes: tx}, nil
+}
+
+func pruneStats(conts map[string]*types.Container, now time.Time) {
+	files, err := ioutil.ReadDir(path.Join(serialize.SaveDir, StatsDir))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		if _, exists := conts[file.Name()]; !exists && now.Sub(file.ModTime()) > StatsRetention {
+			os.Remove(statsFile(file.Name()))
+		}
+	}
+}
+
+// Returns the samples taken of the container with id, or of every container with a history if id is empty,
+// from since up to until. Containers without samples in that time are left out.
+func StatsHistory(id string, since, until time.Time) (map[string][]*types.StatsSample, error) {
+	if id != "" {
+		if err := checkFileID(id); err != nil {
+			return nil, err
+		}
+	}
+	ids := []string{id}
+	if id == "" {
+		files, err := ioutil.ReadDir(path.Join(serialize.SaveDir, StatsDir))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		ids = make([]string, len(files))
+		for i, file := range files {
+			ids[i] = file.Name()
+		}
+	}
+	history := map[string][]*types.StatsSample{}
+	for _, id := range ids {
+		samples, err := stats.Read(statsFile(id), since, until)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if len(samples) > 0 {
+			history[id] = samples
+		}
+	}
+	return history, nil
+}
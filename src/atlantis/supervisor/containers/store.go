@@ -0,0 +1,155 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/containers/serialize"
+	"atlantis/supervisor/trace"
+	"fmt"
+	"log"
+	"os"
+	"path"
+)
+
+const (
+	StateFile        = "state.db"
+	ContainersBucket = "containers"
+	PortsBucket      = "ports"
+	freePortsKey     = "free"
+)
+
+var (
+	store      serialize.Store
+	Replicator serialize.Replicator // set before Init to mirror the state store off the host
+)
+
+// Load the containers and free ports from the state store, importing and upgrading state saved by older
+// supervisors first. Fails rather than starting out empty if the saved state
+// can not be read, so containers that are still running are never handed out again.
+func loadState() error {
+	if _, err := openState(); err != nil {
+		return err
+	}
+	if Replicator != nil {
+		replicated := serialize.NewReplicatedStore(store, Replicator)
+		// catch the replica up before anything else changes
+		if err := replicated.Sync(ContainersBucket, PortsBucket); err != nil {
+			log.Printf("-> ERROR: could not sync the state replica: %v", err)
+		}
+		store = replicated
+	}
+	containers = map[string]*Container{}
+	if err := store.RetrieveAll(ContainersBucket, &containers); err != nil {
+		return fmt.Errorf("could not load the saved containers: %v", err)
+	}
+	savedPorts := map[string][]uint16{}
+	if err := store.RetrieveAll(PortsBucket, &savedPorts); err != nil {
+		return fmt.Errorf("could not load the saved ports: %v", err)
+	}
+	ports = savedPorts[freePortsKey]
+	if ports == nil {
+		ports = make([]uint16, NumContainers)
+		for i := uint16(0); i < NumContainers; i++ {
+			ports[i] = i
+		}
+		log.Printf("-> using default port list: %+v", ports)
+	}
+	if serialize.Encrypting() {
+		// encrypt the records saved before there was a state key. bolt may keep the old copies in free pages until
+		// they are reused, so rotate the credentials in them if that matters.
+		updates := []serialize.Update{{Bucket: PortsBucket, Key: freePortsKey, Object: ports}}
+		for id, cont := range containers {
+			updates = append(updates, serialize.Update{Bucket: ContainersBucket, Key: id, Object: cont})
+		}
+		if err := store.Update(updates...); err != nil {
+			return fmt.Errorf("could not encrypt the saved containers: %v", err)
+		}
+	}
+	return nil
+}
+
+// Open the state store, importing the flat files older supervisors saved everything to if it does not exist yet,
+// and upgrade it to the current format. Returns the format version the state was upgraded from.
+func openState() (int, error) {
+	stateFile := path.Join(serialize.SaveDir, StateFile)
+	_, statErr := os.Stat(stateFile)
+	boltStore := serialize.NewBoltStore(stateFile)
+	store = boltStore
+	if os.IsNotExist(statErr) {
+		imported, err := migrateFlatFiles()
+		if err != nil {
+			return 0, fmt.Errorf("could not import the saved containers: %v", err)
+		}
+		if !imported {
+			// nothing to upgrade in a new store
+			return StateFormatVersion, store.Update(serialize.Update{Bucket: MetaBucket, Key: formatVersionKey,
+				Object: StateFormatVersion})
+		}
+	}
+	from, err := migrateState(boltStore)
+	if err != nil {
+		return from, fmt.Errorf("could not upgrade the saved containers: %v", err)
+	}
+	return from, nil
+}
+
+// Import the containers and ports files into the store and move them out of the way. Returns false if there
+// were no files to import.
+func migrateFlatFiles() (bool, error) {
+	var oldContainers map[string]*Container
+	if err := serialize.RetrieveObject(ContainersFile, &oldContainers); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	var oldPorts []uint16
+	if err := serialize.RetrieveObject(PortsFile, &oldPorts); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	updates := []serialize.Update{{Bucket: PortsBucket, Key: freePortsKey, Object: oldPorts}}
+	for id, cont := range oldContainers {
+		updates = append(updates, serialize.Update{Bucket: ContainersBucket, Key: id, Object: cont})
+	}
+	if err := store.Update(updates...); err != nil {
+		return false, err
+	}
+	log.Printf("-> imported %d containers into %s", len(oldContainers), StateFile)
+	for _, file := range []string{ContainersFile, PortsFile} {
+		file = path.Join(serialize.SaveDir, file)
+		if err := os.Rename(file, file+".migrated"); err != nil {
+			log.Printf("-> could not move %s aside: %v", file, err)
+		}
+	}
+	return true, nil
+}
+
+// Save the given containers, or remove them if they no longer exist, along with the free ports in one
+// transaction. A snapshot of the whole state is kept for every save.
+func save(ids ...string) {
+	updates := []serialize.Update{{Bucket: PortsBucket, Key: freePortsKey, Object: ports}}
+	for _, id := range ids {
+		update := serialize.Update{Bucket: ContainersBucket, Key: id}
+		if cont := containers[id]; cont != nil {
+			update.Object = cont
+		}
+		updates = append(updates, update)
+	}
+	span := trace.Child("save state", ids...)
+	err := store.Update(updates...)
+	span.End(err)
+	if err != nil {
+		log.Printf("[save] ERROR: could not save %v: %v", ids, err)
+		return
+	}
+	snapshot()
+}
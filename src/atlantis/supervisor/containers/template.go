@@ -0,0 +1,98 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/crypto"
+	"atlantis/supervisor/rpc/types"
+	"fmt"
+)
+
+// Returns the values available to manifest placeholders. Dependency data is available as
+// ${deps.<dependency>.<key>}.
+func (c *Container) templateVars() (map[string]string, error) {
+	vars := map[string]string{
+		"ENV":            c.Env,
+		"CONTAINER_ID":   c.ID,
+		"CONTAINER_HOST": c.Host,
+		"APP":            c.App,
+		"SHA":            c.Sha,
+		"HTTP_PORT":      fmt.Sprintf("%d", c.PrimaryPort),
+		"SSHD_PORT":      fmt.Sprintf("%d", c.SSHPort),
+	}
+	for name, val := range c.NamedPortEnv() {
+		vars[name] = val
+	}
+	for name, appDep := range c.Manifest.Deps {
+		data := appDep.DataMap
+		if appDep.EncryptedData != "" {
+			var err error
+			if data, err = crypto.DecryptedAppDepData(appDep); err != nil {
+				return nil, err
+			}
+		}
+		for key, val := range data {
+			vars[fmt.Sprintf("deps.%s.%s", name, key)] = fmt.Sprintf("%v", val)
+		}
+	}
+	return vars, nil
+}
+
+// Resolve the placeholders in the manifest's run commands and env values, and in those of its sidecars
+func (c *Container) resolveTemplates() error {
+	if c.Manifest == nil {
+		return nil
+	}
+	vars, err := c.templateVars()
+	if err != nil {
+		return err
+	}
+	resolve := func(strs []string) error {
+		for i, str := range strs {
+			if strs[i], err = types.Interpolate(str, vars); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	resolveMap := func(strs map[string]string) error {
+		for key, str := range strs {
+			if strs[key], err = types.Interpolate(str, vars); err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+		}
+		return nil
+	}
+	// string-form run commands go through the shell, so the values put in them are quoted
+	for i, cmd := range c.Manifest.RunCommands {
+		if c.Manifest.RunCommands[i], err = types.InterpolateShell(cmd, vars); err != nil {
+			return fmt.Errorf("Could not resolve run command: %v", err)
+		}
+	}
+	for _, argv := range c.Manifest.RunArgv {
+		if err := resolve(argv); err != nil {
+			return fmt.Errorf("Could not resolve run command: %v", err)
+		}
+	}
+	if err := resolveMap(c.Manifest.Env); err != nil {
+		return fmt.Errorf("Could not resolve env: %v", err)
+	}
+	for _, sidecar := range c.Manifest.Sidecars {
+		if err := resolve(sidecar.Command); err != nil {
+			return fmt.Errorf("Could not resolve command of sidecar %s: %v", sidecar.Name, err)
+		}
+		if err := resolveMap(sidecar.Env); err != nil {
+			return fmt.Errorf("Could not resolve env of sidecar %s: %v", sidecar.Name, err)
+		}
+	}
+	return nil
+}
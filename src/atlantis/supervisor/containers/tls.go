@@ -0,0 +1,52 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"fmt"
+)
+
+// Image and version of the sidecar injected for manifests with TLS, deployed from
+// <registry>/sidecars/<TLSSidecarImage>-<TLSSidecarVersion>. The image should listen on TLS_PORT with the
+// certificate and key in TLS_CERT_FILE and TLS_KEY_FILE and proxy to HTTP_PORT on localhost. Set before Init.
+var (
+	TLSSidecarImage   = ""
+	TLSSidecarVersion = ""
+)
+
+// The TLS port is the first secondary port after the named ports, 0 if the manifest has no TLS
+func tlsPort(manifest *types.Manifest, secondaryPorts []uint16) uint16 {
+	if manifest.TLS == nil {
+		return 0
+	}
+	return secondaryPorts[namedPortCount(manifest)]
+}
+
+// Returns the sidecars to deploy for the container: the ones its manifest declares, then the TLS sidecar if it
+// terminates TLS
+func sidecarSpecs(c *Container) []types.Sidecar {
+	if c.Manifest == nil {
+		return nil
+	}
+	specs := c.Manifest.Sidecars
+	if c.Manifest.TLS != nil && c.TLSPort != 0 {
+		specs = append(specs[:len(specs):len(specs)], types.Sidecar{
+			Name:    types.TLSSidecarName,
+			Image:   TLSSidecarImage,
+			Version: TLSSidecarVersion,
+			Env:     map[string]string{"TLS_PORT": fmt.Sprintf("%d", c.TLSPort)},
+			TLS:     c.Manifest.TLS,
+		})
+	}
+	return specs
+}
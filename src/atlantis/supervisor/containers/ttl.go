@@ -0,0 +1,51 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package containers
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"sync"
+	"time"
+)
+
+const ExpireInterval = 10 * time.Second
+
+var expireOnce sync.Once
+
+// Returns the whole seconds left until the container expires, 0 if it has no TTL or is past it
+func ttlLeft(c *types.Container, now time.Time) uint {
+	if c.ExpiresAt.IsZero() || !now.Before(c.ExpiresAt) {
+		return 0
+	}
+	return uint(c.ExpiresAt.Sub(now) / time.Second)
+}
+
+// Tear down the containers whose TTL ran out every ExpireInterval
+func expireLoop() {
+	for {
+		time.Sleep(ExpireInterval)
+		expire(time.Now())
+	}
+}
+
+// Tear down the containers that expired by now
+func expire(now time.Time) {
+	conts, _ := List()
+	for id, cont := range conts {
+		if cont.ExpiresAt.IsZero() || now.Before(cont.ExpiresAt) {
+			continue
+		}
+		if Teardown(id) {
+			emit(id, types.EventExpired, "torn down, it expired at %s", cont.ExpiresAt.Format(time.RFC3339))
+		}
+	}
+}
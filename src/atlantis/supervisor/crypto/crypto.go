@@ -40,7 +40,10 @@ func DecryptAppDep(data *types.AppDep) error {
 
 func DecryptedAppDepData(data *types.AppDep) (map[string]interface{}, error) {
 	// decrypt Data
-	decryptedBytes := crypto.Decrypt([]byte(data.EncryptedData))
+	decryptedBytes, err := decrypt(data.EncryptedData)
+	if err != nil {
+		return nil, err
+	}
 	dataMap := map[string]interface{}{}
 	// Unmarshal JSON
 	return dataMap, json.Unmarshal(decryptedBytes, &dataMap)
@@ -0,0 +1,97 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package crypto
+
+import (
+	"atlantis/crypto"
+	"errors"
+	"strings"
+	"sync"
+)
+
+const AtlantisDecrypterName = "atlantis"
+
+// Decrypts the EncryptedData of an AppDep. EncryptedData may start with "<provider>:" to pick the registered
+// Decrypter that handles it, in which case the Decrypter is given the data without that prefix. Data without a
+// known prefix goes to the default Decrypter.
+type Decrypter interface {
+	Decrypt(data string) ([]byte, error)
+}
+
+// Uses the atlantis key, the way dep data has always been encrypted
+type AtlantisDecrypter struct{}
+
+func (d AtlantisDecrypter) Decrypt(data string) ([]byte, error) {
+	return crypto.Decrypt([]byte(data)), nil
+}
+
+var (
+	decryptersLock   = sync.RWMutex{}
+	decrypters       = map[string]Decrypter{AtlantisDecrypterName: AtlantisDecrypter{}}
+	defaultDecrypter = AtlantisDecrypterName
+)
+
+func RegisterDecrypter(name string, d Decrypter) {
+	decryptersLock.Lock()
+	defer decryptersLock.Unlock()
+	decrypters[name] = d
+}
+
+// Set the Decrypter used for data without a provider prefix
+func SetDefaultDecrypter(name string) error {
+	decryptersLock.Lock()
+	defer decryptersLock.Unlock()
+	if decrypters[name] == nil {
+		return errors.New("Unknown decrypter: " + name)
+	}
+	defaultDecrypter = name
+	return nil
+}
+
+func decrypt(data string) ([]byte, error) {
+	decryptersLock.RLock()
+	d := decrypters[defaultDecrypter]
+	if i := strings.Index(data, ":"); i > 0 {
+		if prefixed, ok := decrypters[data[:i]]; ok {
+			d = prefixed
+			data = data[i+1:]
+		}
+	}
+	decryptersLock.RUnlock()
+	return d.Decrypt(data)
+}
+
+type DecrypterConfig struct {
+	Default         string
+	KMSRegion       string
+	GPGHome         string
+	VaultAddr       string
+	VaultTokenFile  string
+	VaultMount      string
+	VaultTransitKey string
+}
+
+// Register the KMS and GPG decrypters, and the Vault transit decrypter and secret resolver if a Vault address is
+// configured
+func InitDecrypters(cfg *DecrypterConfig) error {
+	RegisterDecrypter(KMSDecrypterName, &KMSDecrypter{Region: cfg.KMSRegion})
+	RegisterDecrypter(GPGDecrypterName, &GPGDecrypter{Home: cfg.GPGHome})
+	if cfg.VaultAddr != "" {
+		RegisterDecrypter(VaultDecrypterName, &VaultTransitDecrypter{Addr: cfg.VaultAddr,
+			TokenFile: cfg.VaultTokenFile, Mount: cfg.VaultMount, Key: cfg.VaultTransitKey})
+		RegisterSecretResolver(VaultDecrypterName, &VaultKVResolver{Addr: cfg.VaultAddr, TokenFile: cfg.VaultTokenFile})
+	}
+	if cfg.Default == "" {
+		return nil
+	}
+	return SetDefaultDecrypter(cfg.Default)
+}
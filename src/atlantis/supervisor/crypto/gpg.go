@@ -0,0 +1,50 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+const GPGDecrypterName = "gpg"
+
+// Decrypts ASCII armored or base64 encoded GPG messages with the keys in Home (the default keyring if empty)
+type GPGDecrypter struct {
+	Home string
+}
+
+func (d *GPGDecrypter) Decrypt(data string) ([]byte, error) {
+	message := []byte(data)
+	if !strings.HasPrefix(strings.TrimSpace(data), "-----BEGIN PGP MESSAGE-----") {
+		var err error
+		if message, err = base64.StdEncoding.DecodeString(strings.TrimSpace(data)); err != nil {
+			return nil, errors.New("GPG data is neither armored nor base64: " + err.Error())
+		}
+	}
+	args := []string{"--batch", "--quiet", "--no-tty"}
+	if d.Home != "" {
+		args = append(args, "--homedir", d.Home)
+	}
+	cmd := exec.Command("gpg", append(args, "--decrypt")...)
+	cmd.Stdin = bytes.NewReader(message)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, errors.New("GPG decrypt failed: " + err.Error() + ": " + strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
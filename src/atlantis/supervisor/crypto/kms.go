@@ -0,0 +1,49 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+const KMSDecrypterName = "kms"
+
+// Decrypts base64 encoded AWS KMS ciphertext blobs using the aws cli, so the host's instance profile is used
+// for credentials
+type KMSDecrypter struct {
+	Region string
+}
+
+func (d *KMSDecrypter) Decrypt(data string) ([]byte, error) {
+	blob, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+	if err != nil {
+		return nil, errors.New("KMS data is not base64: " + err.Error())
+	}
+	args := []string{"kms", "decrypt", "--ciphertext-blob", "fileb:///dev/stdin", "--output", "text",
+		"--query", "Plaintext"}
+	if d.Region != "" {
+		args = append(args, "--region", d.Region)
+	}
+	cmd := exec.Command("aws", args...)
+	cmd.Stdin = bytes.NewReader(blob)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, errors.New("KMS decrypt failed: " + err.Error() + ": " + strings.TrimSpace(stderr.String()))
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(output)))
+}
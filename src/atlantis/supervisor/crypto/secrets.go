@@ -0,0 +1,48 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package crypto
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"errors"
+	"sync"
+)
+
+// Resolves the secret references of a manifest for one provider, the part of the reference before the colon
+type SecretResolver interface {
+	Resolve(path, key string) (string, error)
+}
+
+var (
+	resolversLock = sync.RWMutex{}
+	resolvers     = map[string]SecretResolver{}
+)
+
+func RegisterSecretResolver(name string, r SecretResolver) {
+	resolversLock.Lock()
+	defer resolversLock.Unlock()
+	resolvers[name] = r
+}
+
+func ResolveSecret(secret *types.Secret) (string, error) {
+	provider, path, key, err := secret.ParseRef()
+	if err != nil {
+		return "", err
+	}
+	resolversLock.RLock()
+	r := resolvers[provider]
+	resolversLock.RUnlock()
+	if r == nil {
+		return "", errors.New("No secret provider configured for " + provider)
+	}
+	return r.Resolve(path, key)
+}
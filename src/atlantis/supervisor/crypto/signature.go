@@ -0,0 +1,81 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package crypto
+
+import (
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// Read the PEM encoded public keys in file. It may hold several, so a new signing key can be trusted before the
+// old one is retired.
+func LoadPublicKeys(file string) ([]stdcrypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	keys := []stdcrypto.PublicKey{}
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		switch key.(type) {
+		case ed25519.PublicKey, *rsa.PublicKey, *ecdsa.PublicKey:
+		default:
+			return nil, fmt.Errorf("%s: unsupported key type %T", file, key)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no PUBLIC KEY blocks", file)
+	}
+	return keys, nil
+}
+
+// Check that sig is a signature of data by any of the keys. Ed25519 signs data as is, RSA (PKCS #1 v1.5) and
+// ECDSA (ASN.1) sign its SHA-256, as openssl dgst -sha256 -sign does.
+func VerifySignature(keys []stdcrypto.PublicKey, data, sig []byte) error {
+	digest := sha256.Sum256(data)
+	for _, key := range keys {
+		switch key := key.(type) {
+		case ed25519.PublicKey:
+			if ed25519.Verify(key, data, sig) {
+				return nil
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, stdcrypto.SHA256, digest[:], sig) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(key, digest[:], sig) {
+				return nil
+			}
+		}
+	}
+	return errors.New("not signed by a trusted key")
+}
@@ -0,0 +1,119 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const VaultDecrypterName = "vault"
+
+// Decrypts data with Vault's transit secrets engine. Vault ciphertexts already start with "vault:", which
+// selects this Decrypter. The token is read from TokenFile on every call so it can be rotated underneath the
+// supervisor.
+type VaultTransitDecrypter struct {
+	Addr      string
+	TokenFile string
+	Mount     string // defaults to "transit"
+	Key       string
+}
+
+var vaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// Makes a request against the Vault API and decodes the data field of the response into data
+func vaultRequest(addr, tokenFile, method, apiPath string, body interface{}, data interface{}) error {
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return errors.New("Could not read vault token: " + err.Error())
+	}
+	var reqBody io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(bodyBytes)
+	}
+	url := fmt.Sprintf("%s/v1/%s", strings.TrimRight(addr, "/"), strings.TrimLeft(apiPath, "/"))
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", strings.TrimSpace(string(token)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := vaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	result := struct {
+		Data   interface{} `json:"data"`
+		Errors []string    `json:"errors"`
+	}{Data: data}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("%s: %v", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.Join(result.Errors, ", "))
+	}
+	return nil
+}
+
+func (d *VaultTransitDecrypter) Decrypt(data string) ([]byte, error) {
+	mount := d.Mount
+	if mount == "" {
+		mount = "transit"
+	}
+	var result struct {
+		Plaintext string `json:"plaintext"`
+	}
+	body := map[string]string{"ciphertext": VaultDecrypterName + ":" + data}
+	if err := vaultRequest(d.Addr, d.TokenFile, "POST", mount+"/decrypt/"+d.Key, body, &result); err != nil {
+		return nil, errors.New("Vault decrypt failed: " + err.Error())
+	}
+	return base64.StdEncoding.DecodeString(result.Plaintext)
+}
+
+// Reads secrets from Vault's kv secrets engine. Both versions of the engine are supported, for version 2 the
+// path includes the data/ segment, e.g. secret/data/myapp.
+type VaultKVResolver struct {
+	Addr      string
+	TokenFile string
+}
+
+func (r *VaultKVResolver) Resolve(path, key string) (string, error) {
+	data := map[string]interface{}{}
+	if err := vaultRequest(r.Addr, r.TokenFile, "GET", path, nil, &data); err != nil {
+		return "", fmt.Errorf("Vault read of %s failed: %v", path, err)
+	}
+	// kv version 2 nests the secret's data alongside its metadata
+	if inner, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = inner
+	}
+	val, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("Vault secret %s has no key %s", path, key)
+	}
+	if str, ok := val.(string); ok {
+		return str, nil
+	}
+	return fmt.Sprintf("%v", val), nil
+}
@@ -0,0 +1,99 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package discovery
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Registers each instance as a service of the local Consul agent, named after the app and tagged with the env.
+// The agent syncs it to the catalog and drops it if the host leaves the cluster.
+type ConsulAnnouncer struct {
+	Endpoints []string
+	TokenFile string
+}
+
+var consulClient = &http.Client{Timeout: 5 * time.Second}
+
+type consulService struct {
+	ID      string            `json:"ID"`
+	Name    string            `json:"Name"`
+	Tags    []string          `json:"Tags"`
+	Address string            `json:"Address"`
+	Port    uint16            `json:"Port"`
+	Meta    map[string]string `json:"Meta"`
+}
+
+func (a *ConsulAnnouncer) Announce(i *Instance) error {
+	meta := map[string]string{"sha": i.Sha, "env": i.Env, "host": i.Host,
+		"ssh_port": strconv.Itoa(int(i.SSHPort))}
+	for name, ports := range i.NamedPorts {
+		if len(ports) > 0 {
+			meta["port_"+name] = strconv.Itoa(int(ports[0]))
+		}
+	}
+	for key, val := range i.Labels {
+		meta["label_"+key] = val
+	}
+	body, err := json.Marshal(&consulService{ID: i.ID, Name: i.App, Tags: []string{i.Env}, Address: i.Address,
+		Port: i.PrimaryPort, Meta: meta})
+	if err != nil {
+		return err
+	}
+	return a.call("/v1/agent/service/register", body)
+}
+
+func (a *ConsulAnnouncer) Withdraw(i *Instance) error {
+	return a.call("/v1/agent/service/deregister/"+i.ID, nil)
+}
+
+func (a *ConsulAnnouncer) call(path string, body []byte) error {
+	token := ""
+	if a.TokenFile != "" {
+		data, err := ioutil.ReadFile(a.TokenFile)
+		if err != nil {
+			return err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	err := errors.New("no consul endpoints configured")
+	for _, endpoint := range a.Endpoints {
+		var req *http.Request
+		req, err = http.NewRequest("PUT", strings.TrimRight(endpoint, "/")+path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		if token != "" {
+			req.Header.Set("X-Consul-Token", token)
+		}
+		var resp *http.Response
+		resp, err = consulClient.Do(req)
+		if err != nil {
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := ioutil.ReadAll(resp.Body)
+			return fmt.Errorf("consul %s: %s: %s", path, resp.Status, strings.TrimSpace(string(msg)))
+		}
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+// Announces the containers a supervisor runs to a service registry, so load balancers and clients can find the
+// instances of an app without asking every supervisor for its containers
+package discovery
+
+import (
+	"atlantis/supervisor/containers/serialize"
+	"errors"
+	"fmt"
+)
+
+const (
+	Consul = "consul"
+	Etcd   = "etcd"
+
+	DefaultConsulAddr = "http://127.0.0.1:8500"
+	DefaultEtcdPrefix = "/atlantis/instances"
+)
+
+// A container as the registry sees it. Containers publish their ports on the host under the same numbers, so
+// Address and the ports are all a client needs.
+type Instance struct {
+	ID             string              `json:"id"`
+	App            string              `json:"app"`
+	Sha            string              `json:"sha"`
+	Env            string              `json:"env"`
+	Host           string              `json:"host"`
+	Address        string              `json:"address"` // the container's static IP, else Host
+	PrimaryPort    uint16              `json:"primaryPort"`
+	SSHPort        uint16              `json:"sshPort"`
+	SecondaryPorts []uint16            `json:"secondaryPorts,omitempty"`
+	NamedPorts     map[string][]uint16 `json:"namedPorts,omitempty"`
+	Labels         map[string]string   `json:"labels,omitempty"`
+}
+
+// Adds an instance to the registry, replacing what was registered under its ID, or removes it
+type Announcer interface {
+	Announce(i *Instance) error
+	Withdraw(i *Instance) error
+}
+
+type Config struct {
+	Backend         string   // Consul or Etcd
+	Endpoints       []string // tried in order. DefaultConsulAddr if empty for Consul.
+	Prefix          string   // etcd keys are <Prefix>/<app>/<env>/<id>, DefaultEtcdPrefix if empty
+	ConsulTokenFile string   // ACL token, empty for none
+}
+
+// Returns the configured Announcer, nil if none is
+func New(cfg *Config) (Announcer, error) {
+	switch cfg.Backend {
+	case "":
+		return nil, nil
+	case Consul:
+		endpoints := cfg.Endpoints
+		if len(endpoints) == 0 {
+			endpoints = []string{DefaultConsulAddr}
+		}
+		return &ConsulAnnouncer{Endpoints: endpoints, TokenFile: cfg.ConsulTokenFile}, nil
+	case Etcd:
+		if len(cfg.Endpoints) == 0 {
+			return nil, errors.New("the etcd backend needs endpoints")
+		}
+		prefix := cfg.Prefix
+		if prefix == "" {
+			prefix = DefaultEtcdPrefix
+		}
+		return &EtcdAnnouncer{etcd: &serialize.EtcdReplicator{Endpoints: cfg.Endpoints, Prefix: prefix}}, nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q, use %q or %q", cfg.Backend, Consul, Etcd)
+	}
+}
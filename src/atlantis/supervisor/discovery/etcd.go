@@ -0,0 +1,35 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package discovery
+
+import (
+	"atlantis/supervisor/containers/serialize"
+	"encoding/json"
+)
+
+// Keeps each instance as JSON at <prefix>/<app>/<env>/<id>, so clients can watch the prefix of an app and env.
+// The keys have no lease: an instance disappears when its container is torn down, not when its host does.
+type EtcdAnnouncer struct {
+	etcd *serialize.EtcdReplicator
+}
+
+func (a *EtcdAnnouncer) Announce(i *Instance) error {
+	data, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	return a.etcd.Put(i.App+"/"+i.Env, i.ID, data)
+}
+
+func (a *EtcdAnnouncer) Withdraw(i *Instance) error {
+	return a.etcd.Delete(i.App+"/"+i.Env, i.ID)
+}
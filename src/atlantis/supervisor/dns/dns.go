@@ -0,0 +1,84 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+// Registers containers in DNS as <container-id>.<domain> so humans and tools can reach them by name
+package dns
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const (
+	Route53  = "route53"
+	Nsupdate = "nsupdate"
+	Dnsmasq  = "dnsmasq"
+
+	DefaultTTL = 60
+)
+
+// Adds and removes the A record of a name. Register replaces whatever address the name had. Names are fully
+// qualified, without the trailing dot.
+type Registrar interface {
+	Register(name, ip string) error
+	Deregister(name, ip string) error
+}
+
+type Config struct {
+	Registrar            string // Route53, Nsupdate or Dnsmasq
+	TTL                  uint   // seconds, DefaultTTL if 0
+	Route53ZoneID        string
+	NsupdateServer       string
+	NsupdateKeyFile      string
+	DnsmasqHostsFile     string
+	DnsmasqReloadCommand string
+}
+
+// Returns the configured Registrar, nil if none is
+func New(cfg *Config) (Registrar, error) {
+	ttl := cfg.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	switch cfg.Registrar {
+	case "":
+		return nil, nil
+	case Route53:
+		if cfg.Route53ZoneID == "" {
+			return nil, errors.New("the route53 registrar needs a hosted zone id")
+		}
+		return &Route53Registrar{ZoneID: cfg.Route53ZoneID, TTL: ttl}, nil
+	case Nsupdate:
+		return &NsupdateRegistrar{Server: cfg.NsupdateServer, KeyFile: cfg.NsupdateKeyFile, TTL: ttl}, nil
+	case Dnsmasq:
+		if cfg.DnsmasqHostsFile == "" {
+			return nil, errors.New("the dnsmasq registrar needs a hosts file")
+		}
+		return &DnsmasqRegistrar{HostsFile: cfg.DnsmasqHostsFile, ReloadCommand: cfg.DnsmasqReloadCommand}, nil
+	default:
+		return nil, fmt.Errorf("unknown registrar %q, use %q, %q or %q", cfg.Registrar, Route53, Nsupdate, Dnsmasq)
+	}
+}
+
+// Run a command, with its stderr in the error if it fails
+func run(stdin []byte, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package dns
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Keeps the records in a hosts file dnsmasq reads with --addn-hosts. dnsmasq only rereads it on SIGHUP, which
+// ReloadCommand should send.
+type DnsmasqRegistrar struct {
+	HostsFile     string
+	ReloadCommand string // split into arguments on whitespace, e.g. "pkill -HUP dnsmasq". empty for none.
+	lock          sync.Mutex
+}
+
+func (r *DnsmasqRegistrar) Register(name, ip string) error {
+	return r.rewrite(name, ip+" "+name)
+}
+
+func (r *DnsmasqRegistrar) Deregister(name, ip string) error {
+	return r.rewrite(name, "")
+}
+
+// Replace the lines for name with line, or just remove them if line is empty
+func (r *DnsmasqRegistrar) rewrite(name, line string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	data, err := ioutil.ReadFile(r.HostsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := []string{}
+	for _, existing := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(existing)
+		if len(fields) == 0 || (len(fields) == 2 && fields[1] == name) {
+			continue
+		}
+		lines = append(lines, existing)
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	// dnsmasq may reread the file at any time, so it is replaced rather than written in place
+	tmp, err := ioutil.TempFile(filepath.Dir(r.HostsFile), ".hosts")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(strings.Join(lines, "\n") + "\n")
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), r.HostsFile)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if command := strings.Fields(r.ReloadCommand); len(command) > 0 {
+		return run(nil, command[0], command[1:]...)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package dns
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Sends dynamic updates with BIND's nsupdate
+type NsupdateRegistrar struct {
+	Server  string // empty for the primary server of the name's zone
+	KeyFile string // TSIG key to sign the updates with, empty to send them unsigned
+	TTL     uint
+}
+
+func (r *NsupdateRegistrar) Register(name, ip string) error {
+	return r.update(fmt.Sprintf("update delete %s. A\nupdate add %s. %d A %s\n", name, name, r.TTL, ip))
+}
+
+func (r *NsupdateRegistrar) Deregister(name, ip string) error {
+	return r.update(fmt.Sprintf("update delete %s. A %s\n", name, ip))
+}
+
+func (r *NsupdateRegistrar) update(commands string) error {
+	var script bytes.Buffer
+	if r.Server != "" {
+		fmt.Fprintf(&script, "server %s\n", r.Server)
+	}
+	script.WriteString(commands)
+	script.WriteString("send\n")
+	args := []string{}
+	if r.KeyFile != "" {
+		args = append(args, "-k", r.KeyFile)
+	}
+	return run(script.Bytes(), "nsupdate", args...)
+}
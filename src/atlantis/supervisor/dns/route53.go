@@ -0,0 +1,59 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package dns
+
+import (
+	"encoding/json"
+)
+
+// Keeps A records in a Route 53 hosted zone using the aws cli, so the host's instance profile is used for
+// credentials
+type Route53Registrar struct {
+	ZoneID string
+	TTL    uint
+}
+
+type route53Change struct {
+	Action            string `json:"Action"`
+	ResourceRecordSet struct {
+		Name            string `json:"Name"`
+		Type            string `json:"Type"`
+		TTL             uint   `json:"TTL"`
+		ResourceRecords []struct {
+			Value string `json:"Value"`
+		} `json:"ResourceRecords"`
+	} `json:"ResourceRecordSet"`
+}
+
+func (r *Route53Registrar) Register(name, ip string) error {
+	return r.change("UPSERT", name, ip)
+}
+
+func (r *Route53Registrar) Deregister(name, ip string) error {
+	return r.change("DELETE", name, ip)
+}
+
+func (r *Route53Registrar) change(action, name, ip string) error {
+	change := route53Change{Action: action}
+	change.ResourceRecordSet.Name = name + "."
+	change.ResourceRecordSet.Type = "A"
+	change.ResourceRecordSet.TTL = r.TTL
+	change.ResourceRecordSet.ResourceRecords = []struct {
+		Value string `json:"Value"`
+	}{{Value: ip}}
+	batch, err := json.Marshal(map[string][]route53Change{"Changes": []route53Change{change}})
+	if err != nil {
+		return err
+	}
+	return run(nil, "aws", "route53", "change-resource-record-sets", "--hosted-zone-id", r.ZoneID,
+		"--change-batch", string(batch))
+}
@@ -0,0 +1,99 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package docker
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"context"
+	"fmt"
+	dtypes "github.com/docker/docker/api/types"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Checkpoints are experimental: the docker daemon has to run with --experimental and criu has to be installed.
+// The docker compatible API of podman does not support them, so podman is asked through its own libpod API. Podman
+// keeps one checkpoint per container and ignores the name.
+
+// Save the state of the running container with CRIU. If exit is true the container is stopped afterwards.
+func Checkpoint(c *types.Container, name string, exit bool) error {
+	if pretending() {
+		log.Printf("[pretend] checkpoint %s as %s (exit: %t)...", c.ID, name, exit)
+		return nil
+	}
+	log.Printf("checkpoint %s as %s (exit: %t)...", c.ID, name, exit)
+	if Runtime == RuntimePodman {
+		return apiPost(fmt.Sprintf("/libpod/containers/%s/checkpoint?leaveRunning=%t", c.DockerID, !exit))
+	}
+	dockerLock.Lock()
+	defer dockerLock.Unlock()
+	return dockerClient.CheckpointCreate(context.Background(), c.DockerID,
+		dtypes.CheckpointCreateOptions{CheckpointID: name, Exit: exit})
+}
+
+// Start the stopped container from a checkpoint. The IP and Pid of the container are refreshed afterwards.
+func Restore(c *types.Container, name string) error {
+	if pretending() {
+		log.Printf("[pretend] restore %s from %s...", c.ID, name)
+		return nil
+	}
+	log.Printf("restore %s from %s...", c.ID, name)
+	var err error
+	if Runtime == RuntimePodman {
+		err = apiPost(fmt.Sprintf("/libpod/containers/%s/restore", c.DockerID))
+	} else {
+		dockerLock.Lock()
+		err = dockerClient.ContainerStart(context.Background(), c.DockerID,
+			dtypes.ContainerStartOptions{CheckpointID: name})
+		dockerLock.Unlock()
+	}
+	if err != nil {
+		log.Printf("failed to restore %s: %v", c.ID, err)
+		return err
+	}
+	return refresh(c)
+}
+
+// POST to podman's libpod API at the negotiated version
+func apiPost(path string) error {
+	socket, err := endpoint()
+	if err != nil {
+		return err
+	}
+	client := http.DefaultClient
+	base := socket
+	if strings.HasPrefix(socket, "unix://") {
+		sockPath := strings.TrimPrefix(socket, "unix://")
+		client = &http.Client{Transport: &http.Transport{Dial: func(network, addr string) (net.Conn, error) {
+			return net.Dial("unix", sockPath)
+		}}}
+		base = "http://" + Runtime
+	} else if strings.HasPrefix(socket, "tcp://") {
+		base = "http://" + strings.TrimPrefix(socket, "tcp://")
+	}
+	if _, apiVersion := Version(); apiVersion != "" {
+		base += "/v" + apiVersion
+	}
+	resp, err := client.Post(base+path, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s", Runtime, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -12,17 +12,25 @@
 package docker
 
 import (
+	"atlantis/supervisor/apptype"
+	"atlantis/supervisor/cgroup"
 	. "atlantis/supervisor/constant"
 	"atlantis/supervisor/crypto"
 	"atlantis/supervisor/helper"
 	"atlantis/supervisor/rpc/types"
 	atypes "atlantis/types"
+	"errors"
 	"fmt"
-	"github.com/fsouza/go-dockerclient"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	units "github.com/docker/go-units"
+	"log"
+	"os/exec"
+	"strings"
 )
 
-func NewDockerPort(port, proto string) docker.Port {
-	return docker.Port(fmt.Sprintf("%s/%s", port, proto))
+func NewDockerPort(port, proto string) nat.Port {
+	return nat.Port(fmt.Sprintf("%s/%s", port, proto))
 }
 
 func ContainerAppCfgs(c *types.Container) (*atypes.AppConfig, error) {
@@ -48,7 +56,84 @@ func ContainerAppCfgs(c *types.Container) (*atypes.AppConfig, error) {
 	}, nil
 }
 
-func ContainerDockerCfgs(c *types.Container) (*docker.Config, *docker.HostConfig) {
+// Publish a port on the given host addresses, or on every host address if there are none. Docker only binds IPv4
+// for an empty host IP, so both stacks are listed explicitly with IPv6 enabled.
+func hostBindings(port string, addrs []string) []nat.PortBinding {
+	if len(addrs) > 0 {
+		bindings := make([]nat.PortBinding, len(addrs))
+		for i, addr := range addrs {
+			bindings[i] = nat.PortBinding{HostIP: addr, HostPort: port}
+		}
+		return bindings
+	}
+	if !EnableIPv6 {
+		return []nat.PortBinding{nat.PortBinding{HostIP: "", HostPort: port}}
+	}
+	return []nat.PortBinding{nat.PortBinding{HostIP: "0.0.0.0", HostPort: port},
+		nat.PortBinding{HostIP: "::", HostPort: port}}
+}
+
+// Returns the manifest's sysctls along with the ones its network tuning sets
+func containerSysctls(manifest *types.Manifest) map[string]string {
+	if manifest.Network == nil {
+		return manifest.Sysctls
+	}
+	sysctls := manifest.Network.Sysctls()
+	for name, val := range manifest.Sysctls {
+		sysctls[name] = val
+	}
+	return sysctls
+}
+
+// Set the MTU of the container's eth0 from the host, entering the network namespace of its process
+func SetMTU(c *types.Container) error {
+	if c.Manifest == nil || c.Manifest.Network == nil || c.Manifest.Network.MTU == 0 {
+		return nil
+	}
+	mtu := fmt.Sprintf("%d", c.Manifest.Network.MTU)
+	if pretending() {
+		log.Printf("[%s][pretend] set mtu %s", c.ID, mtu)
+		return nil
+	}
+	if c.Pid == 0 {
+		return errors.New("Could not set mtu: the container has no pid")
+	}
+	output, err := exec.Command("nsenter", "-t", fmt.Sprintf("%d", c.Pid), "-n", "ip", "link", "set", "dev", "eth0",
+		"mtu", mtu).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Could not set mtu: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Returns the device nodes needed to use the given GPUs, along with the shared nvidia control devices
+func gpuDevices(gpus []uint) []container.DeviceMapping {
+	if len(gpus) == 0 {
+		return nil
+	}
+	devices := []container.DeviceMapping{}
+	for _, gpu := range gpus {
+		path := fmt.Sprintf("/dev/nvidia%d", gpu)
+		devices = append(devices, container.DeviceMapping{PathOnHost: path, PathInContainer: path,
+			CgroupPermissions: "rwm"})
+	}
+	for _, path := range []string{"/dev/nvidiactl", "/dev/nvidia-uvm"} {
+		devices = append(devices, container.DeviceMapping{PathOnHost: path, PathInContainer: path,
+			CgroupPermissions: "rwm"})
+	}
+	return devices
+}
+
+// Returns the indexes as a comma separated list, the format of both NVIDIA_VISIBLE_DEVICES and cpusets
+func indexList(indexes []uint) string {
+	list := make([]string, len(indexes))
+	for i, index := range indexes {
+		list[i] = fmt.Sprintf("%d", index)
+	}
+	return strings.Join(list, ",")
+}
+
+func ContainerDockerCfgs(c *types.Container) (*container.Config, *container.HostConfig) {
 	// get env cfg
 	envs := []string{
 		"ATLANTIS=true",
@@ -60,59 +145,62 @@ func ContainerDockerCfgs(c *types.Container) (*docker.Config, *docker.HostConfig
 	}
 
 	// get port cfg
-	exposedPorts := map[docker.Port]struct{}{}
-	portBindings := map[docker.Port][]docker.PortBinding{}
+	exposedPorts := nat.PortSet{}
+	portBindings := nat.PortMap{}
 	sPrimaryPort := fmt.Sprintf("%d", c.PrimaryPort)
 	dPrimaryPort := NewDockerPort(sPrimaryPort, "tcp")
 	exposedPorts[dPrimaryPort] = struct{}{}
-	portBindings[dPrimaryPort] = []docker.PortBinding{docker.PortBinding{
-		HostIP:   "",
-		HostPort: sPrimaryPort,
-	}}
+	portBindings[dPrimaryPort] = hostBindings(sPrimaryPort, c.BindAddrs)
 	sSSHPort := fmt.Sprintf("%d", c.SSHPort)
 	dSSHPort := NewDockerPort(sSSHPort, "tcp")
 	exposedPorts[dSSHPort] = struct{}{}
-	portBindings[dSSHPort] = []docker.PortBinding{docker.PortBinding{
-		HostIP:   "",
-		HostPort: sSSHPort,
-	}}
+	portBindings[dSSHPort] = hostBindings(sSSHPort, c.SSHAddrs)
 	for i, port := range c.SecondaryPorts {
 		sPort := fmt.Sprintf("%d", port)
 		dPort := NewDockerPort(sPort, "tcp")
 		exposedPorts[dPort] = struct{}{}
-		portBindings[dPort] = []docker.PortBinding{docker.PortBinding{
-			HostIP:   "",
-			HostPort: sPort,
-		}}
+		portBindings[dPort] = hostBindings(sPort, c.BindAddrs)
 		envs = append(envs, fmt.Sprintf("SECONDARY_PORT%d=%d", i, port))
 	}
+	for key, val := range c.NamedPortEnv() {
+		envs = append(envs, fmt.Sprintf("%s=%s", key, val))
+	}
+	if len(c.GPUs) > 0 {
+		envs = append(envs, fmt.Sprintf("NVIDIA_VISIBLE_DEVICES=%s", indexList(c.GPUs)))
+	}
+	for key, val := range c.Manifest.Env {
+		envs = append(envs, fmt.Sprintf("%s=%s", key, val))
+	}
 
 	// setup actual cfg
-	dCfg := &docker.Config{
+	dCfg := &container.Config{
 		Tty:          true, // allocate pseudo-tty
 		OpenStdin:    true, // keep stdin open even if we're not attached
-		CPUShares:    int64(c.Manifest.CPUShares),
-		Memory:       int64(c.Manifest.MemoryLimit) * int64(1024*1024), // this is in bytes
-		MemorySwap:   int64(-1),                                        // -1 turns swap off
 		ExposedPorts: exposedPorts,
 		Env:          envs,
 		Cmd: []string{
 			"runsvdir",
 			"/etc/service",
 		},
-		Image: fmt.Sprintf("%s/%s/%s-%s", RegistryHost, c.GetDockerRepo(), c.App, c.Sha),
+		Image:  imageName(c, c.App, c.Sha),
+		Labels: c.Labels,
 		Volumes: map[string]struct{}{
 			ContainerLogDir:           struct{}{},
 			atypes.ContainerConfigDir: struct{}{},
 		},
 	}
-	dHostCfg := &docker.HostConfig{
+	dHostCfg := &container.HostConfig{
+		Resources: container.Resources{
+			CPUShares:  cgroup.DockerCPUShares(c.Manifest.CPUShares),
+			Memory:     int64(c.Manifest.MemoryLimit) * int64(1024*1024), // this is in bytes
+			MemorySwap: int64(-1),                                        // -1 leaves swap unlimited
+		},
 		PortBindings: portBindings,
 		Binds: []string{
 			fmt.Sprintf("%s:%s", helper.HostLogDir(c.ID), ContainerLogDir),
 			fmt.Sprintf("%s:%s", helper.HostConfigDir(c.ID), atypes.ContainerConfigDir),
 		},
-		RestartPolicy: docker.AlwaysRestart(),
+		RestartPolicy: container.RestartPolicy{Name: "always"},
 
 		// We added this so that we could reference the veth after it was created. However, docker no longer
 		// uses lxc as the default driver (and neither do we) disable this configuration for now, investigate
@@ -126,5 +214,63 @@ func ContainerDockerCfgs(c *types.Container) (*docker.Config, *docker.HostConfig
 		//			},
 
 	}
+	for _, volume := range c.Manifest.Volumes {
+		dCfg.Volumes[volume.Target] = struct{}{}
+		dHostCfg.Binds = append(dHostCfg.Binds, volume.Bind())
+	}
+	for _, mount := range c.Manifest.TmpfsMounts() {
+		if dHostCfg.Tmpfs == nil {
+			dHostCfg.Tmpfs = map[string]string{}
+		}
+		dHostCfg.Tmpfs[mount.Target] = mount.Options()
+	}
+	dHostCfg.ReadonlyRootfs = c.Manifest.ReadOnlyRoot
+	dHostCfg.Devices = gpuDevices(c.GPUs)
+	if c.Manifest.DiskLimit > 0 {
+		// needs a storage driver with quotas, e.g. overlay2 on xfs mounted with pquota
+		dHostCfg.StorageOpt = map[string]string{"size": fmt.Sprintf("%dM", c.Manifest.DiskLimit)}
+	}
+	if c.Manifest.MemorySwap > 0 {
+		dHostCfg.MemorySwap = int64(c.Manifest.MemorySwap) * int64(1024*1024)
+	}
+	if len(c.CPUSet) > 0 {
+		dHostCfg.CpusetCpus = indexList(c.CPUSet)
+	} else {
+		dHostCfg.CpusetCpus = SharedCPUSet
+	}
+	for _, ulimit := range c.Manifest.Ulimits {
+		dHostCfg.Ulimits = append(dHostCfg.Ulimits, &units.Ulimit{Name: ulimit.Name, Soft: ulimit.Soft,
+			Hard: ulimit.Hard})
+	}
+	if sysctls := containerSysctls(c.Manifest); len(sysctls) > 0 {
+		dHostCfg.Sysctls = sysctls
+	}
+	if c.Manifest.NetworkMode != "" {
+		dHostCfg.NetworkMode = container.NetworkMode(c.Manifest.NetworkMode)
+	}
+	if logging := c.Manifest.Logging; logging != nil {
+		dHostCfg.LogConfig = container.LogConfig{Type: logging.Driver, Config: logging.Options}
+	} else if DefaultLogConfig != nil {
+		dHostCfg.LogConfig = container.LogConfig{Type: DefaultLogConfig.Driver, Config: DefaultLogConfig.Options}
+	}
+	dHostCfg.SecurityOpt = macSecurityOpts(c.Manifest.MACProfile)
+	dHostCfg.CapAdd = c.Manifest.CapAdd
+	dHostCfg.CapDrop = c.Manifest.CapDrop
+	if c.Manifest.HostNetwork() {
+		// the app binds its ports on the host directly
+		dCfg.ExposedPorts = nil
+		dHostCfg.PortBindings = nil
+	}
+	if c.NetworkOf != "" {
+		// docker can not publish ports in another container's namespace, the supervisor forwards them instead
+		dHostCfg.NetworkMode = container.NetworkMode("container:" + c.NetworkOf)
+		dCfg.ExposedPorts = nil
+		dHostCfg.PortBindings = nil
+	}
+	if c.Manifest.RestartPolicy != nil {
+		// the supervisor watches the container and applies the manifest's policy itself
+		dHostCfg.RestartPolicy = container.RestartPolicy{Name: "no"}
+	}
+	apptype.Get(c.Manifest.AppType).Configure(c, dCfg, dHostCfg)
 	return dCfg, dHostCfg
 }
@@ -12,12 +12,19 @@
 package docker
 
 import (
+	"atlantis/supervisor/cgroup"
 	"atlantis/supervisor/helper"
 	"atlantis/supervisor/rpc/types"
+	"atlantis/supervisor/trace"
 	atypes "atlantis/types"
+	"context"
 	"errors"
 	"fmt"
-	"github.com/fsouza/go-dockerclient"
+	dtypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
 	"log"
 	"os"
 	"regexp"
@@ -26,23 +33,73 @@ import (
 )
 
 var (
-	RegistryHost   string
-	dockerIDRegexp = regexp.MustCompile("^[A-Za-z0-9]+$")
-	dockerLock     = sync.Mutex{}
-	dockerClient   *docker.Client
+	RegistryHost    string
+	RegistryAuth    *types.RegistryAuth // credentials for RegistryHost, nil for none
+	SharedCPUSet    string              // cpuset of containers without dedicated cores, empty for every core
+	EnableIPv6      bool                // publish container ports on IPv6 as well. docker has to run with --ipv6.
+	StaticIPNetwork string              // docker network containers keep the IP the supervisor allocated on
+	dockerIDRegexp  = regexp.MustCompile("^[A-Za-z0-9]+$")
+	dockerLock      = sync.Mutex{}
+	dockerClient    *client.Client
 )
 
 func Init(registry string) (err error) {
 	RegistryHost = registry
-	dockerClient, err = docker.NewClient("unix:///var/run/docker.sock")
+	socket, err := endpoint()
 	if err != nil {
 		return err
 	}
+	dockerClient, err = newClient(socket, "")
+	if err != nil {
+		return err
+	}
+	if !pretending() {
+		dockerLock.Lock()
+		err = negotiate(socket)
+		dockerLock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
 	go removeExited()
 	go restartGhost()
 	return nil
 }
 
+// Returns a client for the runtime's API at socket. An empty api version has the client negotiate one itself the
+// first time it is used.
+func newClient(socket, api string) (*client.Client, error) {
+	if api == "" {
+		return client.NewClientWithOpts(client.WithHost(socket), client.WithAPIVersionNegotiation())
+	}
+	return client.NewClientWithOpts(client.WithHost(socket), client.WithVersion(api))
+}
+
+// Returns the credentials to pull from host, encoded for the registry auth header: the ones the deploy came with, or
+// the supervisor's own for its registry. Empty if there are none.
+func registryAuth(host string, auth *types.RegistryAuth) (string, error) {
+	if auth == nil && host == RegistryHost {
+		auth = RegistryAuth
+	}
+	if auth == nil {
+		return "", nil
+	}
+	return registry.EncodeAuthConfig(registry.AuthConfig{Username: auth.Username, Password: auth.Password,
+		Email: auth.Email, ServerAddress: host})
+}
+
+// Returns the registry host the container's image is pulled from
+func registryHost(c types.GenericContainer) string {
+	if typedC, ok := c.(*types.Container); ok && typedC.Registry != nil && typedC.Registry.Host != "" {
+		return typedC.Registry.Host
+	}
+	return RegistryHost
+}
+
+func imageName(c types.GenericContainer, name, version string) string {
+	return fmt.Sprintf("%s/%s/%s-%s", registryHost(c), c.GetDockerRepo(), name, version)
+}
+
 func pretending() bool {
 	return os.Getenv("SUPERVISOR_PRETEND") != ""
 }
@@ -53,7 +110,7 @@ func removeExited() {
 	}
 	dockerLock.Lock()
 	defer dockerLock.Unlock()
-	containers, err := dockerClient.ListContainers(docker.ListContainersOptions{All: true})
+	containers, err := dockerClient.ContainerList(context.Background(), dtypes.ContainerListOptions{All: true})
 	if err != nil {
 		log.Printf("[RemoveExited] could not list containers: %v", err)
 		return
@@ -64,7 +121,7 @@ func removeExited() {
 			continue
 		}
 		log.Printf("[RemoveExited] remove %s (%v)", cont.ID, cont.Names)
-		err := dockerClient.RemoveContainer(docker.RemoveContainerOptions{ID: cont.ID})
+		err := dockerClient.ContainerRemove(context.Background(), cont.ID, dtypes.ContainerRemoveOptions{})
 		if err != nil {
 			log.Printf("[RemoveExited] -> error: %v", err)
 		} else {
@@ -79,7 +136,7 @@ func restartGhost() {
 	}
 	dockerLock.Lock()
 	defer dockerLock.Unlock()
-	containers, err := dockerClient.ListContainers(docker.ListContainersOptions{All: true})
+	containers, err := dockerClient.ContainerList(context.Background(), dtypes.ContainerListOptions{All: true})
 	if err != nil {
 		log.Printf("[RestartGhost] could not list containers: %v", err)
 		return
@@ -90,7 +147,8 @@ func restartGhost() {
 			continue
 		}
 		log.Printf("[RestartGhost] restart %s (%v)", cont.ID, cont.Names)
-		err := dockerClient.RestartContainer(cont.ID, 0)
+		timeout := 0
+		err := dockerClient.ContainerRestart(context.Background(), cont.ID, container.StopOptions{Timeout: &timeout})
 		if err != nil {
 			log.Printf("[RestartGhost] -> error: %v", err)
 		} else {
@@ -99,10 +157,12 @@ func restartGhost() {
 	}
 }
 
-func DockerCfgs(c types.GenericContainer) (*docker.Config, *docker.HostConfig) {
+func DockerCfgs(c types.GenericContainer) (*container.Config, *container.HostConfig) {
 	switch typedC := c.(type) {
 	case *types.Container:
 		return ContainerDockerCfgs(typedC)
+	case *types.SidecarContainer:
+		return SidecarDockerCfgs(typedC)
 	default:
 		return nil, nil
 	}
@@ -112,13 +172,16 @@ func AppCfgs(c types.GenericContainer) (*atypes.AppConfig, error) {
 	switch typedC := c.(type) {
 	case *types.Container:
 		return ContainerAppCfgs(typedC)
+	case *types.SidecarContainer:
+		return SidecarAppCfgs(typedC)
 	default:
 		return nil, errors.New("could not fetch app configs")
 	}
 }
 
-func Deploy(c types.GenericContainer) error {
-	dRepo := fmt.Sprintf("%s/%s/%s-%s", RegistryHost, c.GetDockerRepo(), c.GetApp(), c.GetSha())
+// Pull the container's image, with auth if the registry needs credentials other than the supervisor's, and run it
+func Deploy(c types.GenericContainer, auth *types.RegistryAuth) error {
+	dRepo := imageName(c, c.GetApp(), c.GetSha())
 	// Pull docker container
 	if pretending() {
 		log.Printf("[%s][pretend] deploy with %s @ %s...", c.GetID(), c.GetApp(), c.GetSha())
@@ -128,9 +191,10 @@ func Deploy(c types.GenericContainer) error {
 	} else {
 		log.Printf("[%s] deploy with %s @ %s...", c.GetID(), c.GetApp(), c.GetSha())
 		log.Printf("[%s] docker pull %s", c.GetID(), dRepo)
-		dockerLock.Lock()
-		err := dockerClient.PullImage(docker.PullImageOptions{Repository: dRepo}, docker.AuthConfiguration{})
-		dockerLock.Unlock()
+		span := trace.Child("pull image", c.GetID())
+		span.SetAttribute("image", dRepo)
+		err := pullImage(c, dRepo, auth)
+		span.End(err)
 		if err != nil {
 			log.Printf("[%s] ERROR: failed to pull %s", c.GetID(), dRepo)
 			return err
@@ -155,13 +219,36 @@ func Deploy(c types.GenericContainer) error {
 			RemoveConfigDir(c)
 			return err
 		}
+		secretEnvs, err := Secrets(c)
+		if err != nil {
+			RemoveConfigDir(c)
+			return err
+		}
+		if err := TLSFiles(c); err != nil {
+			RemoveConfigDir(c)
+			return err
+		}
+		seccompOpts, err := SeccompSecurityOpts(c)
+		if err != nil {
+			RemoveConfigDir(c)
+			return err
+		}
+		if err := ChownToContainerRoot(helper.HostLogDir(c.GetID()), helper.HostConfigDir(c.GetID())); err != nil {
+			RemoveConfigDir(c)
+			return err
+		}
 
 		log.Printf("[%s] docker run %s", c.GetID(), dRepo)
 		// create docker container
 		dCfg, dHostCfg := DockerCfgs(c)
+		dCfg.Env = append(dCfg.Env, secretEnvs...)
+		dHostCfg.SecurityOpt = append(dHostCfg.SecurityOpt, seccompOpts...)
+		span = trace.Child("create container", c.GetID())
 		dockerLock.Lock()
-		dCont, err := dockerClient.CreateContainer(docker.CreateContainerOptions{Name: c.GetID(), Config: dCfg})
+		dCont, err := dockerClient.ContainerCreate(context.Background(), dCfg, dHostCfg, networkingConfig(c), nil,
+			c.GetID())
 		dockerLock.Unlock()
+		span.End(err)
 		if err != nil {
 			log.Printf("[%s] ERROR: failed to create container: %s", c.GetID(), err.Error())
 			return err
@@ -169,15 +256,17 @@ func Deploy(c types.GenericContainer) error {
 		c.SetDockerID(dCont.ID)
 
 		// start docker container
+		span = trace.Child("start container", c.GetID())
 		dockerLock.Lock()
-		err = dockerClient.StartContainer(c.GetDockerID(), dHostCfg)
+		err = dockerClient.ContainerStart(context.Background(), c.GetDockerID(), dtypes.ContainerStartOptions{})
 		dockerLock.Unlock()
+		span.End(err)
 		if err != nil {
 			log.Printf("[%s] ERROR: failed to start container: %s", c.GetID(), err.Error())
 			log.Printf("[%s] -- full create response:\n%+v", c.GetID(), dCont)
 			log.Printf("[%s] inspecting container for more information...", c.GetID())
 			dockerLock.Lock()
-			inspCont, ierr := dockerClient.InspectContainer(c.GetDockerID())
+			inspCont, ierr := dockerClient.ContainerInspect(context.Background(), c.GetDockerID())
 			dockerLock.Unlock()
 			if ierr != nil {
 				log.Printf("[%s] ERROR: failed to inspect container: %s", c.GetID(), ierr.Error())
@@ -188,7 +277,7 @@ func Deploy(c types.GenericContainer) error {
 		}
 
 		dockerLock.Lock()
-		inspCont, err := dockerClient.InspectContainer(c.GetDockerID())
+		inspCont, err := dockerClient.ContainerInspect(context.Background(), c.GetDockerID())
 		dockerLock.Unlock()
 		if err != nil {
 			log.Printf("[%s] ERROR: failed to inspect container: %s", c.GetID(), err.Error())
@@ -198,12 +287,116 @@ func Deploy(c types.GenericContainer) error {
 			log.Printf("[%s] ERROR: failed to get container network settings.")
 			return errors.New("Could not get NetworkSettings from docker")
 		}
-		c.SetIP(inspCont.NetworkSettings.IPAddress)
+		setAddresses(c, inspCont)
 		c.SetPid(inspCont.State.Pid)
 	}
 	return nil
 }
 
+// Pin the container to the IP the supervisor allocated it on the static IP network. nil if docker picks the
+// address.
+func networkingConfig(c types.GenericContainer) *network.NetworkingConfig {
+	typedC, isContainer := c.(*types.Container)
+	if !isContainer || typedC.Manifest == nil || StaticIPNetwork == "" ||
+		typedC.Manifest.NetworkMode != StaticIPNetwork || typedC.IP == "" {
+		return nil
+	}
+	return &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{
+		StaticIPNetwork: &network.EndpointSettings{IPAMConfig: &network.EndpointIPAMConfig{IPv4Address: typedC.IP}},
+	}}
+}
+
+// Containers on a named docker network only have addresses within that network
+func setAddresses(c types.GenericContainer, inspCont dtypes.ContainerJSON) {
+	ip, ipv6 := inspCont.NetworkSettings.IPAddress, inspCont.NetworkSettings.GlobalIPv6Address
+	typedC, isContainer := c.(*types.Container)
+	if isContainer && typedC.NetworkOf != "" {
+		// docker reports no addresses in another container's namespace, the supervisor keeps that one's IP
+		return
+	}
+	if isContainer && typedC.Manifest != nil {
+		if endpoint, ok := inspCont.NetworkSettings.Networks[typedC.Manifest.NetworkMode]; ok {
+			if ip == "" {
+				ip = endpoint.IPAddress
+			}
+			if ipv6 == "" {
+				ipv6 = endpoint.GlobalIPv6Address
+			}
+		}
+	}
+	c.SetIP(ip)
+	if isContainer {
+		typedC.IPv6 = ipv6
+	}
+}
+
+// Restart the docker container in place. The IP and Pid of the container are refreshed afterwards.
+func Restart(c types.GenericContainer) error {
+	if pretending() {
+		log.Printf("[pretend] restart %s...", c.GetID())
+		return nil
+	}
+	log.Printf("restart %s...", c.GetID())
+	timeout := 10
+	dockerLock.Lock()
+	err := dockerClient.ContainerRestart(context.Background(), c.GetDockerID(), container.StopOptions{Timeout: &timeout})
+	dockerLock.Unlock()
+	if err != nil {
+		log.Printf("failed to restart %s: %v", c.GetID(), err)
+		return err
+	}
+	return refresh(c)
+}
+
+// Update the IP and Pid of the container after docker (re)started it
+func refresh(c types.GenericContainer) error {
+	dockerLock.Lock()
+	inspCont, err := dockerClient.ContainerInspect(context.Background(), c.GetDockerID())
+	dockerLock.Unlock()
+	if err != nil {
+		log.Printf("[%s] ERROR: failed to inspect container: %s", c.GetID(), err.Error())
+		return err
+	}
+	if inspCont.NetworkSettings != nil {
+		setAddresses(c, inspCont)
+	}
+	c.SetPid(inspCont.State.Pid)
+	return nil
+}
+
+// Change the CPU shares and memory limit of the running container to what its manifest says
+func Resize(c *types.Container) error {
+	if pretending() {
+		log.Printf("[pretend] resize %s to %d cpu shares, %d MB...", c.ID, c.Manifest.CPUShares,
+			c.Manifest.MemoryLimit)
+		return nil
+	}
+	update := container.UpdateConfig{Resources: container.Resources{
+		CPUShares: cgroup.DockerCPUShares(c.Manifest.CPUShares), Memory: int64(c.Manifest.MemoryLimit) * 1024 * 1024,
+		MemorySwap: -1}}
+	if c.Manifest.MemorySwap > 0 {
+		update.MemorySwap = int64(c.Manifest.MemorySwap) * 1024 * 1024
+	}
+	dockerLock.Lock()
+	defer dockerLock.Unlock()
+	_, err := dockerClient.ContainerUpdate(context.Background(), c.DockerID, update)
+	return err
+}
+
+// Returns whether the docker container is running and, if it is not, the code it exited with
+func State(c types.GenericContainer) (running bool, exitCode int, err error) {
+	if pretending() {
+		return true, 0, nil
+	}
+	dockerLock.Lock()
+	inspCont, err := dockerClient.ContainerInspect(context.Background(), c.GetDockerID())
+	dockerLock.Unlock()
+	if err != nil {
+		return false, 0, err
+	}
+	return inspCont.State.Running, inspCont.State.ExitCode, nil
+}
+
 func RemoveConfigDir(c types.GenericContainer) error {
 	return os.RemoveAll(helper.HostConfigDir(c.GetID()))
 }
@@ -217,16 +410,23 @@ func Teardown(c types.GenericContainer) error {
 		log.Printf("teardown %s...", c.GetID())
 	}
 	defer removeExited()
+	span := trace.Child("kill container", c.GetID())
 	dockerLock.Lock()
-	err := dockerClient.KillContainer(docker.KillContainerOptions{ID: c.GetDockerID()})
+	err := dockerClient.ContainerKill(context.Background(), c.GetDockerID(), "KILL")
 	dockerLock.Unlock()
+	span.End(err)
 	if err != nil {
 		log.Printf("failed to teardown[kill] %s: %v", c.GetID(), err)
 		return err
 	}
 	// Make sure the container is dead before we return to avoid cmk (or other) race conditions
 	dockerLock.Lock()
-	_, err = dockerClient.WaitContainer(c.GetDockerID())
+	waitChan, errChan := dockerClient.ContainerWait(context.Background(), c.GetDockerID(),
+		container.WaitConditionNotRunning)
+	select {
+	case <-waitChan:
+	case err = <-errChan:
+	}
 	dockerLock.Unlock()
 	if err != nil {
 		log.Printf("failed to wait on dead container[wait] %s: %v", c.GetID(), err)
@@ -0,0 +1,74 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package docker
+
+import (
+	"context"
+	dtypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"log"
+	"time"
+)
+
+const EventsRetryInterval = 10 * time.Second
+
+// Actions of docker container events the supervisor acts on
+const (
+	EventStart   = "start"
+	EventDie     = "die"
+	EventOOM     = "oom"
+	EventDestroy = "destroy"
+	// not sent by docker. the stream was (re)subscribed to and events might have been missed before it, e.g.
+	// because the daemon restarted.
+	EventsSubscribed = "subscribed"
+)
+
+// Watch the docker event stream and call handle with the action and docker id of every container event. Never
+// returns, resubscribing when docker drops the stream.
+func WatchEvents(handle func(action, dockerID string)) {
+	if pretending() {
+		return
+	}
+	for {
+		// the daemon might have been upgraded while the stream was down
+		renegotiate()
+		dockerLock.Lock()
+		cli := dockerClient
+		dockerLock.Unlock()
+		ctx, cancel := context.WithCancel(context.Background())
+		msgs, errs := cli.Events(ctx, dtypes.EventsOptions{Filters: filters.NewArgs(filters.Arg("type", "container"))})
+		handle(EventsSubscribed, "")
+		err := watchStream(msgs, errs, handle)
+		cancel()
+		log.Printf("[events] docker event stream closed, resubscribing: %v", err)
+		time.Sleep(EventsRetryInterval)
+	}
+}
+
+// Hand the events to handle until the stream ends. Returns the error it ended with.
+func watchStream(msgs <-chan events.Message, errs <-chan error, handle func(action, dockerID string)) error {
+	for {
+		select {
+		case event := <-msgs:
+			handle(event.Action, event.Actor.ID)
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// Returns true if err is docker not knowing about a container
+func IsNoSuchContainer(err error) bool {
+	return client.IsErrNotFound(err)
+}
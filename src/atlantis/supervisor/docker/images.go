@@ -0,0 +1,185 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package docker
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"context"
+	"encoding/json"
+	"errors"
+	dtypes "github.com/docker/docker/api/types"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// how long unused images are kept for by default
+	ImageRetention time.Duration
+	// when each image was last pulled, images are kept for the retention period from then rather than from when
+	// they were built
+	pulledAt     = map[string]time.Time{}
+	pulledAtLock = sync.Mutex{}
+)
+
+func pullImage(c types.GenericContainer, image string, auth *types.RegistryAuth) error {
+	encodedAuth, err := registryAuth(registryHost(c), auth)
+	if err != nil {
+		return err
+	}
+	dockerLock.Lock()
+	err = pull(image, encodedAuth)
+	dockerLock.Unlock()
+	if err == nil {
+		pulledAtLock.Lock()
+		pulledAt[image] = time.Now()
+		pulledAtLock.Unlock()
+	}
+	return err
+}
+
+// The pull is done once docker closes the progress stream, which reports a failed pull as its last message. Must be
+// called with dockerLock held.
+func pull(image, encodedAuth string) error {
+	opts := dtypes.ImagePullOptions{RegistryAuth: encodedAuth}
+	progress, err := dockerClient.ImagePull(context.Background(), image, opts)
+	if err != nil {
+		return err
+	}
+	defer progress.Close()
+	return pullError(progress)
+}
+
+// Returns the error the progress stream of a pull ends with, nil if it ends without one
+func pullError(progress io.Reader) error {
+	dec := json.NewDecoder(progress)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+	}
+}
+
+// Pull the image for app @ sha so a later deploy of it starts without waiting on the pull. Returns the image.
+func PrefetchImage(app, sha string, registry *types.Registry, auth *types.RegistryAuth) (string, error) {
+	c := &types.Container{App: app, Sha: sha, Registry: registry}
+	image := imageName(c, app, sha)
+	if pretending() {
+		log.Printf("[prefetch][pretend] docker pull %s", image)
+		return image, nil
+	}
+	log.Printf("[prefetch] docker pull %s", image)
+	if err := pullImage(c, image, auth); err != nil {
+		log.Printf("[prefetch] ERROR: failed to pull %s: %v", image, err)
+		return image, err
+	}
+	return image, nil
+}
+
+// Remove the images not used by any container, running or not, that were pulled (or, if the supervisor has not
+// pulled them since it started, built) longer than retention ago. Returns the tags (or ids of untagged images)
+// of the removed images; with dryRun nothing is actually removed.
+func ImageGC(retention time.Duration, dryRun bool) ([]string, error) {
+	if pretending() {
+		log.Printf("[ImageGC][pretend] remove images unused for %s", retention)
+		return []string{}, nil
+	}
+	dockerLock.Lock()
+	defer dockerLock.Unlock()
+	conts, err := dockerClient.ContainerList(context.Background(), dtypes.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+	inUse := map[string]bool{}
+	for _, cont := range conts {
+		inUse[cont.Image] = true
+	}
+	images, err := dockerClient.ImageList(context.Background(), dtypes.ImageListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	removed := []string{}
+	cutoff := time.Now().Add(-retention)
+	pulledAtLock.Lock()
+	defer pulledAtLock.Unlock()
+	for _, image := range images {
+		if inUse[image.ID] || imageUsed(image, inUse) || !imageExpired(image, cutoff) {
+			continue
+		}
+		names := image.RepoTags
+		if len(names) == 0 || names[0] == "<none>:<none>" {
+			names = []string{image.ID}
+		}
+		for _, name := range names {
+			log.Printf("[ImageGC] remove %s", name)
+			if dryRun {
+				removed = append(removed, name)
+				continue
+			}
+			_, err := dockerClient.ImageRemove(context.Background(), name, dtypes.ImageRemoveOptions{})
+			if err != nil {
+				log.Printf("[ImageGC] -> error: %v", err)
+				continue
+			}
+			delete(pulledAt, repoName(name))
+			removed = append(removed, name)
+		}
+	}
+	return removed, nil
+}
+
+func imageUsed(image dtypes.ImageSummary, inUse map[string]bool) bool {
+	for _, tag := range image.RepoTags {
+		if inUse[tag] || inUse[repoName(tag)] {
+			return true
+		}
+	}
+	return false
+}
+
+// pulledAt must be locked
+func imageExpired(image dtypes.ImageSummary, cutoff time.Time) bool {
+	last := time.Unix(image.Created, 0)
+	for _, tag := range image.RepoTags {
+		if pulled, ok := pulledAt[repoName(tag)]; ok && pulled.After(last) {
+			last = pulled
+		}
+	}
+	return last.Before(cutoff)
+}
+
+// Images are pulled and run without a tag, which docker lists as <image>:latest
+func repoName(tag string) string {
+	return strings.TrimSuffix(tag, ":latest")
+}
+
+// Run ImageGC every interval
+func ImageGCLoop(interval, retention time.Duration) {
+	for _ = range time.Tick(interval) {
+		removed, err := ImageGC(retention, false)
+		if err != nil {
+			log.Printf("[ImageGC] ERROR: %v", err)
+			continue
+		}
+		log.Printf("[ImageGC] removed %d images", len(removed))
+	}
+}
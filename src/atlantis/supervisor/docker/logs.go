@@ -0,0 +1,65 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package docker
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"context"
+	"fmt"
+	dtypes "github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// The logging driver of containers whose manifest picks none, nil for the docker daemon's default. Set before the
+// first Deploy.
+var DefaultLogConfig *types.LogConfig
+
+// Write the container's stdout and stderr since the second since was in to stdout and stderr, each line starting
+// with the time docker got it, and keep writing what it logs until it stops or a writer fails. A nil writer skips
+// its stream. Docker can only read back the logs of the json-file, local and journald drivers.
+func FollowLogs(c types.GenericContainer, since time.Time, stdout, stderr io.Writer) error {
+	opts := dtypes.ContainerLogsOptions{ShowStdout: stdout != nil, ShowStderr: stderr != nil, Follow: true,
+		Timestamps: true}
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+	if !since.IsZero() {
+		opts.Since = fmt.Sprintf("%d", since.Unix())
+	}
+	// only hold dockerLock for the client, this blocks for as long as the container runs
+	dockerLock.Lock()
+	cli := dockerClient
+	dockerLock.Unlock()
+	inspCont, err := cli.ContainerInspect(context.Background(), c.GetDockerID())
+	if err != nil {
+		return err
+	}
+	logs, err := cli.ContainerLogs(context.Background(), c.GetDockerID(), opts)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+	if inspCont.Config != nil && inspCont.Config.Tty {
+		// a tty has a single stream, which docker sends as it is
+		_, err = io.Copy(stdout, logs)
+		return err
+	}
+	// without a tty docker multiplexes both streams into one
+	_, err = stdcopy.StdCopy(stdout, stderr, logs)
+	return err
+}
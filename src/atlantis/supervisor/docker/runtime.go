@@ -0,0 +1,84 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// Container runtimes the supervisor can drive. Both are talked to through the docker API, which podman serves
+// from its system service (podman system service).
+const (
+	RuntimeDocker = "docker"
+	RuntimePodman = "podman"
+)
+
+var (
+	Runtime  = RuntimeDocker // set before Init
+	Rootless bool            // set before Init. the runtime runs as the supervisor's user rather than root
+	Endpoint string          // set before Init. the runtime's API, defaults to its socket
+)
+
+// Returns the runtime's API endpoint
+func endpoint() (string, error) {
+	if Endpoint != "" {
+		return Endpoint, nil
+	}
+	if !Rootless {
+		switch Runtime {
+		case RuntimeDocker:
+			return "unix:///var/run/docker.sock", nil
+		case RuntimePodman:
+			return "unix:///run/podman/podman.sock", nil
+		}
+		return "", fmt.Errorf("Invalid Config. Unknown runtime %q", Runtime)
+	}
+	runDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runDir == "" {
+		runDir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+	switch Runtime {
+	case RuntimeDocker:
+		return "unix://" + path.Join(runDir, "docker.sock"), nil
+	case RuntimePodman:
+		return "unix://" + path.Join(runDir, "podman/podman.sock"), nil
+	}
+	return "", fmt.Errorf("Invalid Config. Unknown runtime %q", Runtime)
+}
+
+// Returns the directory the runtime keeps images and container filesystems in
+func DataRoot() string {
+	if !Rootless {
+		if Runtime == RuntimePodman {
+			return "/var/lib/containers/storage"
+		}
+		return "/var/lib/docker"
+	}
+	dataDir := os.Getenv("XDG_DATA_HOME")
+	if dataDir == "" {
+		dataDir = path.Join(os.Getenv("HOME"), ".local/share")
+	}
+	if Runtime == RuntimePodman {
+		return path.Join(dataDir, "containers/storage")
+	}
+	return path.Join(dataDir, "docker")
+}
+
+// Returns the runtime and whether it is rootless, for display
+func RuntimeName() string {
+	if Rootless {
+		return Runtime + " (rootless)"
+	}
+	return Runtime
+}
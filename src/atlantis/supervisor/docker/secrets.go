@@ -0,0 +1,51 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package docker
+
+import (
+	"atlantis/supervisor/crypto"
+	"atlantis/supervisor/helper"
+	"atlantis/supervisor/rpc/types"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// Resolves the secrets of a container, writing the file secrets to the secrets dir of its config dir (mounted
+// at <ContainerConfigDir>/secrets) and returning the env secrets in docker's KEY=value format
+func Secrets(c types.GenericContainer) ([]string, error) {
+	typedC, ok := c.(*types.Container)
+	if !ok || typedC.Manifest == nil || len(typedC.Manifest.Secrets) == 0 {
+		return nil, nil
+	}
+	secretsDir := path.Join(helper.HostConfigDir(typedC.ID), "secrets")
+	if err := os.MkdirAll(secretsDir, 0700); err != nil {
+		return nil, err
+	}
+	envs := []string{}
+	for _, secret := range typedC.Manifest.Secrets {
+		val, err := crypto.ResolveSecret(&secret)
+		if err != nil {
+			return nil, fmt.Errorf("Could not resolve secret %s: %v", secret.Ref, err)
+		}
+		if secret.Env != "" {
+			envs = append(envs, fmt.Sprintf("%s=%s", secret.Env, val))
+		}
+		if secret.File != "" {
+			if err := ioutil.WriteFile(path.Join(secretsDir, secret.File), []byte(val), 0600); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return envs, nil
+}
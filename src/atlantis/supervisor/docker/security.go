@@ -0,0 +1,87 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package docker
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+var (
+	SysRoot    = "/sys"                             // where sysfs is mounted, to see which security modules are enforced
+	SeccompDir = "/etc/atlantis/supervisor/seccomp" // set before Init. the custom seccomp profiles, as <name>.json
+)
+
+// Returns whether the kernel has AppArmor enabled, so profiles can be loaded and enforced
+func AppArmorEnabled() bool {
+	enabled, err := ioutil.ReadFile(path.Join(SysRoot, "module/apparmor/parameters/enabled"))
+	return err == nil && strings.TrimSpace(string(enabled)) == "Y"
+}
+
+// Returns whether SELinux is in enforcing mode. In permissive mode labels are applied but denials are only
+// logged, so that does not count.
+func SELinuxEnabled() bool {
+	enforce, err := ioutil.ReadFile(path.Join(SysRoot, "fs/selinux/enforce"))
+	return err == nil && strings.TrimSpace(string(enforce)) == "1"
+}
+
+// Returns the docker security options that apply the profile
+func macSecurityOpts(p *types.MACProfile) []string {
+	if p == nil {
+		return nil
+	}
+	opts := []string{}
+	if p.AppArmor != "" {
+		opts = append(opts, "apparmor="+p.AppArmor)
+	}
+	for _, part := range []struct{ name, val string }{{"user", p.SELinuxUser}, {"role", p.SELinuxRole},
+		{"type", p.SELinuxType}, {"level", p.SELinuxLevel}} {
+		if part.val != "" {
+			opts = append(opts, "label="+part.name+":"+part.val)
+		}
+	}
+	return opts
+}
+
+// Returns the file a custom seccomp profile is read from
+func SeccompProfilePath(name string) string {
+	return path.Join(SeccompDir, name+".json")
+}
+
+// Returns the docker security option applying the container's seccomp profile, none for the runtime's default.
+// The docker API takes the profile itself rather than a path, so custom profiles are read here.
+func SeccompSecurityOpts(c types.GenericContainer) ([]string, error) {
+	typedC, ok := c.(*types.Container)
+	if !ok || typedC.Manifest == nil {
+		return nil, nil
+	}
+	switch name := typedC.Manifest.Seccomp; name {
+	case "", types.SeccompDefault:
+		return nil, nil
+	case types.SeccompUnconfined:
+		return []string{"seccomp=" + types.SeccompUnconfined}, nil
+	default:
+		profile, err := ioutil.ReadFile(SeccompProfilePath(name))
+		if err != nil {
+			return nil, fmt.Errorf("Could not read seccomp profile %s: %v", name, err)
+		}
+		var parsed map[string]interface{}
+		if err := json.Unmarshal(profile, &parsed); err != nil {
+			return nil, fmt.Errorf("Invalid seccomp profile %s: %v", name, err)
+		}
+		return []string{"seccomp=" + string(profile)}, nil
+	}
+}
@@ -0,0 +1,81 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package docker
+
+import (
+	"atlantis/supervisor/cgroup"
+	. "atlantis/supervisor/constant"
+	"atlantis/supervisor/helper"
+	"atlantis/supervisor/rpc/types"
+	atypes "atlantis/types"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"path"
+)
+
+// Sidecars get no dependencies, only enough to find the primary container
+func SidecarAppCfgs(c *types.SidecarContainer) (*atypes.AppConfig, error) {
+	return &atypes.AppConfig{
+		HTTPPort: c.PrimaryPort,
+		Container: &atypes.ContainerConfig{
+			ID:   c.PrimaryID,
+			Host: c.Host,
+			Env:  c.Env,
+		},
+		Dependencies: map[string]map[string]interface{}{},
+	}, nil
+}
+
+func SidecarDockerCfgs(c *types.SidecarContainer) (*container.Config, *container.HostConfig) {
+	envs := []string{
+		"ATLANTIS=true",
+		fmt.Sprintf("CONTAINER_ID=%s", c.PrimaryID),
+		fmt.Sprintf("CONTAINER_HOST=%s", c.Host),
+		fmt.Sprintf("CONTAINER_ENV=%s", c.Env),
+		fmt.Sprintf("HTTP_PORT=%d", c.PrimaryPort),
+		fmt.Sprintf("SIDECAR_NAME=%s", c.Spec.Name),
+	}
+	if c.Spec.TLS != nil {
+		tlsDir := path.Join(atypes.ContainerConfigDir, "tls")
+		envs = append(envs, "TLS_CERT_FILE="+path.Join(tlsDir, "cert.pem"), "TLS_KEY_FILE="+path.Join(tlsDir, "key.pem"))
+	}
+	for key, val := range c.Spec.Env {
+		envs = append(envs, fmt.Sprintf("%s=%s", key, val))
+	}
+	dCfg := &container.Config{
+		Env:   envs,
+		Cmd:   c.Spec.Command, // nil uses the image's command
+		Image: imageName(c, c.Spec.Image, c.Spec.Version),
+		Volumes: map[string]struct{}{
+			ContainerLogDir:           struct{}{},
+			atypes.ContainerConfigDir: struct{}{},
+		},
+	}
+	dHostCfg := &container.HostConfig{
+		Resources: container.Resources{
+			CPUShares: cgroup.DockerCPUShares(c.Spec.CPUShares),
+			Memory:    int64(c.Spec.MemoryLimit) * int64(1024*1024), // this is in bytes
+		},
+		// share the primary container's network namespace so the sidecar can reach it on localhost
+		NetworkMode: container.NetworkMode("container:" + c.PrimaryDockerID),
+		Binds: []string{
+			fmt.Sprintf("%s:%s:ro", helper.HostLogDir(c.PrimaryID), ContainerLogDir),
+			fmt.Sprintf("%s:%s", helper.HostConfigDir(c.ID), atypes.ContainerConfigDir),
+		},
+		// the supervisor restarts sidecars itself, along with the primary container
+		RestartPolicy: container.RestartPolicy{Name: "no"},
+	}
+	if c.Spec.MemoryLimit > 0 {
+		dHostCfg.MemorySwap = int64(-1) // -1 turns swap off
+	}
+	return dCfg, dHostCfg
+}
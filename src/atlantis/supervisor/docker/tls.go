@@ -0,0 +1,66 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package docker
+
+import (
+	"atlantis/supervisor/crypto"
+	"atlantis/supervisor/helper"
+	"atlantis/supervisor/rpc/types"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// The supervisor's own certificate and key, for sidecars whose TLS gives none. Set before the first Deploy.
+var (
+	TLSCertFile = ""
+	TLSKeyFile  = ""
+)
+
+// Writes the certificate and key of a sidecar terminating TLS to the tls dir of its config dir (mounted at
+// <ContainerConfigDir>/tls)
+func TLSFiles(c types.GenericContainer) error {
+	sidecar, ok := c.(*types.SidecarContainer)
+	if !ok || sidecar.Spec.TLS == nil {
+		return nil
+	}
+	cert, err := tlsPEM(sidecar.Spec.TLS.Cert, TLSCertFile)
+	if err != nil {
+		return fmt.Errorf("Could not get TLS certificate: %v", err)
+	}
+	key, err := tlsPEM(sidecar.Spec.TLS.Key, TLSKeyFile)
+	if err != nil {
+		return fmt.Errorf("Could not get TLS key: %v", err)
+	}
+	tlsDir := path.Join(helper.HostConfigDir(sidecar.ID), "tls")
+	if err := os.MkdirAll(tlsDir, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(tlsDir, "cert.pem"), cert, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(tlsDir, "key.pem"), key, 0600)
+}
+
+// Resolves the secret ref, or reads the supervisor's file if there is none
+func tlsPEM(ref, file string) ([]byte, error) {
+	if ref != "" {
+		val, err := crypto.ResolveSecret(&types.Secret{Ref: ref})
+		return []byte(val), err
+	}
+	if file == "" {
+		return nil, errors.New("the supervisor has no TLS certificate configured")
+	}
+	return ioutil.ReadFile(file)
+}
@@ -0,0 +1,113 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package docker
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The user docker remaps container root to when its daemon runs with userns-remap=default
+const DefaultRemapUser = "dockremap"
+
+var (
+	UsernsRemap = ""            // set before Init. the daemon's userns-remap setting, user[:group] or "default"
+	SubUIDFile  = "/etc/subuid" // where the remap user's subordinate ids are read from
+	SubGIDFile  = "/etc/subgid"
+)
+
+// Returns the remap user and group as the daemon reads its userns-remap setting
+func remapUserGroup(remap string) (string, string) {
+	if remap == "default" {
+		remap = DefaultRemapUser
+	}
+	parts := strings.SplitN(remap, ":", 2)
+	if len(parts) == 1 {
+		return parts[0], parts[0]
+	}
+	return parts[0], parts[1]
+}
+
+// Returns the start of the first subordinate id range of name (or of the user or group with the numeric id
+// name) in a subuid or subgid file
+func firstSubordinateID(file, name, id string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// <name or id>:<start>:<count>
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(fields) != 3 || (fields[0] != name && fields[0] != id) {
+			continue
+		}
+		return strconv.Atoi(fields[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no subordinate ids for %s in %s", name, file)
+}
+
+// Returns the host uid and gid root in the containers is mapped to with the given userns-remap setting, 0 and 0
+// if it is empty
+func RemappedRoot(remap string) (uid, gid int, err error) {
+	if remap == "" {
+		return 0, 0, nil
+	}
+	userName, groupName := remapUserGroup(remap)
+	userID, groupID := "", ""
+	if u, err := user.Lookup(userName); err == nil {
+		userID = u.Uid
+	}
+	if g, err := user.LookupGroup(groupName); err == nil {
+		groupID = g.Gid
+	}
+	if uid, err = firstSubordinateID(SubUIDFile, userName, userID); err != nil {
+		return 0, 0, err
+	}
+	if gid, err = firstSubordinateID(SubGIDFile, groupName, groupID); err != nil {
+		return 0, 0, err
+	}
+	return uid, gid, nil
+}
+
+// Give the files the supervisor shares with a container (its config, secrets and log dirs) to the container's
+// root, so they are not owned by an unmapped user inside the container when userns-remap is on
+func ChownToContainerRoot(dirs ...string) error {
+	if UsernsRemap == "" {
+		return nil
+	}
+	uid, gid, err := RemappedRoot(UsernsRemap)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Lchown(path, uid, gid)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// The range of docker API versions the supervisor works with. Daemons newer than MaxAPIVersion are talked to at
+// MaxAPIVersion, which they keep supporting; daemons older than MinAPIVersion are refused.
+const (
+	MinAPIVersion = "1.12"
+	MaxAPIVersion = "1.41"
+)
+
+var (
+	daemonVersion    string // of the runtime, as it reports it
+	daemonAPIVersion string // negotiated, empty until the daemon could be asked
+)
+
+// Ask the daemon for its version and switch to a client that speaks the newest API both sides support. Must be
+// called with dockerLock held. Leaves the client as it is if the daemon can not be reached.
+func negotiate(socket string) error {
+	version, err := dockerClient.ServerVersion(context.Background())
+	if err != nil {
+		log.Printf("[docker] could not get the daemon version, will negotiate later: %v", err)
+		return nil
+	}
+	daemonAPI := version.APIVersion
+	if daemonAPI == "" {
+		return fmt.Errorf("%s did not report an API version", Runtime)
+	}
+	if apiVersionLess(daemonAPI, MinAPIVersion) {
+		return fmt.Errorf("%s API version %s is too old, at least %s is needed", Runtime, daemonAPI, MinAPIVersion)
+	}
+	api := daemonAPI
+	if apiVersionLess(MaxAPIVersion, api) {
+		api = MaxAPIVersion
+	}
+	if api != daemonAPIVersion {
+		cli, err := newClient(socket, api)
+		if err != nil {
+			return err
+		}
+		dockerClient.Close()
+		dockerClient = cli
+		log.Printf("[docker] %s %s, using API version %s", Runtime, version.Version, api)
+	}
+	daemonVersion = version.Version
+	daemonAPIVersion = api
+	return nil
+}
+
+// Renegotiate the API version, e.g. after the daemon restarted and might have been upgraded
+func renegotiate() {
+	if pretending() {
+		return
+	}
+	socket, err := endpoint()
+	if err != nil {
+		return
+	}
+	dockerLock.Lock()
+	defer dockerLock.Unlock()
+	if err := negotiate(socket); err != nil {
+		log.Printf("[docker] could not renegotiate the API version: %v", err)
+	}
+}
+
+// Returns the daemon's version and the API version used with it. Both are empty if the daemon could not be asked.
+func Version() (version, apiVersion string) {
+	dockerLock.Lock()
+	defer dockerLock.Unlock()
+	return daemonVersion, daemonAPIVersion
+}
+
+// Compare API versions like 1.9 and 1.24 numerically
+func apiVersionLess(a, b string) bool {
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var aNum, bNum int
+		if i < len(aParts) {
+			aNum, _ = strconv.Atoi(aParts[i])
+		}
+		if i < len(bParts) {
+			bNum, _ = strconv.Atoi(bParts[i])
+		}
+		if aNum != bNum {
+			return aNum < bNum
+		}
+	}
+	return false
+}
@@ -12,7 +12,7 @@ import (
 )
 
 func healthzHandler(w http.ResponseWriter, r *http.Request) {
-	config := &Config{"localhost", DefaultSupervisorRPCPort}
+	config := &Config{Host: "localhost", Port: DefaultSupervisorRPCPort}
 	rpcClient := NewRPCClientWithConfig(config, "Supervisor", SupervisorRPCVersion, false)
 	arg := SupervisorHealthCheckArg{}
 	var reply SupervisorHealthCheckReply
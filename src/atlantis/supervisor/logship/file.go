@@ -0,0 +1,180 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package logship
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// UTC with a fixed number of digits, so the lines of files sort by their time as strings do
+const fileTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+// Writes entries to <Dir>/<stream>.log, a "<time> <message>" line each. Once a file would grow past MaxSize it is
+// rotated to <stream>.log.1, what was .1 to .2 and so on, keeping MaxFiles rotated files.
+type FileSink struct {
+	Dir      string
+	MaxSize  int64
+	MaxFiles int
+	files    map[string]*os.File // stream -> its open log
+}
+
+func logFile(dir, stream string, rotation int) string {
+	if rotation == 0 {
+		return path.Join(dir, stream+".log")
+	}
+	return path.Join(dir, fmt.Sprintf("%s.log.%d", stream, rotation))
+}
+
+func (s *FileSink) Ship(entries []*Entry) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		line := entry.Time.UTC().Format(fileTimeFormat) + " " + entry.Message + "\n"
+		f, err := s.file(entry.Stream, int64(len(line)))
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the open log of stream, rotated first if size more bytes would grow it past MaxSize
+func (s *FileSink) file(stream string, size int64) (*os.File, error) {
+	if s.files == nil {
+		s.files = map[string]*os.File{}
+	}
+	f := s.files[stream]
+	if f == nil {
+		var err error
+		if f, err = os.OpenFile(logFile(s.Dir, stream, 0), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err != nil {
+			return nil, err
+		}
+		s.files[stream] = f
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if s.MaxSize <= 0 || info.Size() == 0 || info.Size()+size <= s.MaxSize {
+		return f, nil
+	}
+	f.Close()
+	delete(s.files, stream)
+	os.Remove(logFile(s.Dir, stream, s.MaxFiles))
+	for rotation := s.MaxFiles; rotation > 0; rotation-- {
+		os.Rename(logFile(s.Dir, stream, rotation-1), logFile(s.Dir, stream, rotation))
+	}
+	return s.file(stream, 0)
+}
+
+func (s *FileSink) Close() error {
+	for stream, f := range s.files {
+		f.Close()
+		delete(s.files, stream)
+	}
+	return nil
+}
+
+func parseLine(stream, line string) *types.LogLine {
+	i := strings.IndexByte(line, ' ')
+	if i < 0 {
+		return nil
+	}
+	t, err := time.Parse(fileTimeFormat, line[:i])
+	if err != nil {
+		return nil
+	}
+	return &types.LogLine{Time: t, Stream: stream, Message: line[i+1:]}
+}
+
+// Returns the lines of a stream in a FileSink's dir from since on, oldest first, at most the last max of them
+func readStream(dir, stream string, since time.Time, max int) ([]*types.LogLine, error) {
+	var lines []*types.LogLine
+	// newest file first, stopping once there are enough lines
+	for rotation := 0; len(lines) < max; rotation++ {
+		f, err := os.Open(logFile(dir, stream, rotation))
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		var fileLines []*types.LogLine
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 2*maxLine)
+		for scanner.Scan() {
+			if line := parseLine(stream, scanner.Text()); line != nil && !line.Time.Before(since) {
+				fileLines = append(fileLines, line)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		lines = append(fileLines, lines...)
+	}
+	if len(lines) > max {
+		lines = lines[len(lines)-max:]
+	}
+	return lines, nil
+}
+
+type byTime []*types.LogLine
+
+func (l byTime) Len() int           { return len(l) }
+func (l byTime) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l byTime) Less(i, j int) bool { return l[i].Time.Before(l[j].Time) }
+
+// Returns the last max lines from since on of the streams in a FileSink's dir, both streams if none is given,
+// oldest first
+func ReadFiles(dir string, streams []string, since time.Time, max int) ([]*types.LogLine, error) {
+	if len(streams) == 0 {
+		streams = []string{types.LogStreamStdout, types.LogStreamStderr}
+	}
+	var lines []*types.LogLine
+	for _, stream := range streams {
+		streamLines, err := readStream(dir, stream, since, max)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, streamLines...)
+	}
+	sort.Stable(byTime(lines))
+	if len(lines) > max {
+		lines = lines[len(lines)-max:]
+	}
+	return lines, nil
+}
+
+// Returns the time of the last line in a FileSink's dir, zero if there is none
+func LastFileTime(dir string) time.Time {
+	var last time.Time
+	for _, stream := range []string{types.LogStreamStdout, types.LogStreamStderr} {
+		if lines, err := readStream(dir, stream, time.Time{}, 1); err == nil && len(lines) > 0 &&
+			lines[0].Time.After(last) {
+			last = lines[0].Time
+		}
+	}
+	return last
+}
@@ -0,0 +1,83 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package logship
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Posts each batch as a JSON array of entries
+type HTTPSink struct {
+	URL    string
+	client *http.Client
+}
+
+func (s *HTTPSink) Ship(entries []*Entry) error {
+	body, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return post(s.client, s.URL, "application/json", body)
+}
+
+func (s *HTTPSink) Close() error {
+	return nil
+}
+
+// Produces each entry as a JSON record through a Kafka REST proxy. Records are keyed by container so the lines
+// of a container stay in order on one partition.
+type KafkaSink struct {
+	URL    string
+	Topic  string
+	client *http.Client
+}
+
+type kafkaRecord struct {
+	Key   string `json:"key"`
+	Value *Entry `json:"value"`
+}
+
+func (s *KafkaSink) Ship(entries []*Entry) error {
+	records := make([]kafkaRecord, len(entries))
+	for i, entry := range entries {
+		records[i] = kafkaRecord{Key: entry.Container, Value: entry}
+	}
+	body, err := json.Marshal(map[string]interface{}{"records": records})
+	if err != nil {
+		return err
+	}
+	return post(s.client, strings.TrimSuffix(s.URL, "/")+"/topics/"+s.Topic, "application/vnd.kafka.json.v2+json",
+		body)
+}
+
+func (s *KafkaSink) Close() error {
+	return nil
+}
+
+func post(client *http.Client, url, contentType string, body []byte) error {
+	resp, err := client.Post(url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s answered %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
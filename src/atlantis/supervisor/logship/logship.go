@@ -0,0 +1,64 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+// Ships the stdout and stderr of containers, a line at a time, to syslog, a Kafka REST proxy or an HTTP endpoint
+// with the app, env and container of every line attached
+package logship
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// A line a container logged
+type Entry struct {
+	Time      time.Time         `json:"time"`
+	Host      string            `json:"host"`
+	App       string            `json:"app"`
+	Sha       string            `json:"sha"`
+	Env       string            `json:"env"`
+	Container string            `json:"container"`
+	Stream    string            `json:"stream"` // stdout or stderr
+	Message   string            `json:"message"`
+	Fields    map[string]string `json:"fields,omitempty"` // the manifest's
+}
+
+// Sends entries to a log collector. Ship is only called by one goroutine at a time.
+type Sink interface {
+	Ship(entries []*Entry) error
+	Close() error
+}
+
+const sinkTimeout = 10 * time.Second
+
+// Returns the sink cfg ships to
+func New(cfg *types.LogShipping) (Sink, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: sinkTimeout}
+	switch cfg.Sink {
+	case types.LogSinkSyslog:
+		network, addr := cfg.SyslogAddress()
+		facility, ok := types.SyslogFacilities[cfg.Facility]
+		if !ok {
+			facility = types.SyslogFacilities["local0"]
+		}
+		return &SyslogSink{Network: network, Addr: addr, Facility: facility}, nil
+	case types.LogSinkKafka:
+		return &KafkaSink{URL: cfg.Address, Topic: cfg.Topic, client: client}, nil
+	case types.LogSinkHTTP:
+		return &HTTPSink{URL: cfg.Address, client: client}, nil
+	}
+	return nil, fmt.Errorf("unsupported sink %q", cfg.Sink)
+}
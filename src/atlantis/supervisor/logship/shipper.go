@@ -0,0 +1,144 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package logship
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	BatchSize     = 100
+	FlushInterval = time.Second
+	ShipRetries   = 3 // a batch the sink refuses this many times more is dropped
+	maxLine       = 64 * 1024
+)
+
+// Batches entries and ships them from a goroutine of its own, so a slow sink does not hold up reading the logs,
+// until it has BatchSize of them or FlushInterval passed
+type Shipper struct {
+	Sink    Sink
+	Shipped func(last time.Time) // called with the time of the last entry of every batch shipped, may be nil
+	entries chan *Entry
+	done    chan bool
+}
+
+// Returns a shipper that ships to sink until it is closed
+func NewShipper(sink Sink, shipped func(last time.Time)) *Shipper {
+	s := &Shipper{Sink: sink, Shipped: shipped, entries: make(chan *Entry, 10*BatchSize), done: make(chan bool)}
+	go s.loop()
+	return s
+}
+
+// Queue entry to be shipped. Blocks while the queue is full.
+func (s *Shipper) Add(entry *Entry) {
+	s.entries <- entry
+}
+
+// Ship what is queued and close the sink
+func (s *Shipper) Close() {
+	close(s.entries)
+	<-s.done
+}
+
+func (s *Shipper) loop() {
+	defer close(s.done)
+	defer s.Sink.Close()
+	ticker := time.NewTicker(FlushInterval)
+	defer ticker.Stop()
+	batch := make([]*Entry, 0, BatchSize)
+	for {
+		select {
+		case entry, ok := <-s.entries:
+			if !ok {
+				s.ship(batch)
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) < BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		s.ship(batch)
+		batch = batch[:0]
+	}
+}
+
+func (s *Shipper) ship(batch []*Entry) {
+	if len(batch) == 0 {
+		return
+	}
+	var err error
+	for try := 0; try <= ShipRetries; try++ {
+		if try > 0 {
+			time.Sleep(time.Duration(1<<uint(try-1)) * time.Second)
+		}
+		if err = s.Sink.Ship(batch); err == nil {
+			if s.Shipped != nil {
+				s.Shipped(batch[len(batch)-1].Time)
+			}
+			return
+		}
+	}
+	log.Printf("[logship] WARNING: dropped %d lines of %s: %v", len(batch), batch[0].Container, err)
+}
+
+// Splits the log stream docker writes into lines and adds each to the shipper as an entry like Meta. Lines start
+// with the timestamp docker adds when asked to, lines stamped at or before After were added already and are
+// skipped. After moves on with every line added, so writers of both streams can share it.
+type LogWriter struct {
+	Shipper *Shipper
+	Meta    *Entry
+	Stream  string
+	After   *time.Time
+	buf     []byte
+}
+
+func (w *LogWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 && len(w.buf) < maxLine {
+			return len(p), nil
+		}
+		if i < 0 || i > maxLine {
+			i = maxLine
+		}
+		line := string(bytes.TrimRight(w.buf[:i], "\r"))
+		w.buf = w.buf[i:]
+		if len(w.buf) > 0 && w.buf[0] == '\n' {
+			w.buf = w.buf[1:]
+		}
+		w.add(line)
+	}
+}
+
+func (w *LogWriter) add(line string) {
+	stamp, msg := time.Now(), line
+	if i := strings.IndexByte(line, ' '); i > 0 {
+		if t, err := time.Parse(time.RFC3339Nano, line[:i]); err == nil {
+			stamp, msg = t, line[i+1:]
+		}
+	}
+	if !stamp.After(*w.After) {
+		return
+	}
+	*w.After = stamp
+	entry := *w.Meta
+	entry.Time = stamp
+	entry.Stream = w.Stream
+	entry.Message = msg
+	w.Shipper.Add(&entry)
+}
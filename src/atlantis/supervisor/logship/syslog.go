@@ -0,0 +1,115 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package logship
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	syslogInfo  = 6
+	syslogError = 3
+	// an enterprise number for the structured data the metadata goes in, 32473 is the one for examples
+	syslogSDID = "atlantis@32473"
+)
+
+// Sends each entry as an RFC 5424 message: the app is the app name, the container the proc id and the stream
+// the msg id, with stderr at error severity and stdout at info. Over tcp messages are framed by octet counting.
+type SyslogSink struct {
+	Network  string // udp or tcp
+	Addr     string
+	Facility int
+	conn     net.Conn
+}
+
+func (s *SyslogSink) Ship(entries []*Entry) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.Network, s.Addr, sinkTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(sinkTimeout))
+	for _, entry := range entries {
+		msg := syslogMessage(s.Facility, entry)
+		if s.Network == "tcp" {
+			msg = fmt.Sprintf("%d %s", len(msg), msg)
+		}
+		if _, err := s.conn.Write([]byte(msg)); err != nil {
+			// reconnect on the next try
+			s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SyslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// Returns entry formatted as an RFC 5424 message
+func syslogMessage(facility int, entry *Entry) string {
+	severity := syslogInfo
+	if entry.Stream == types.LogStreamStderr {
+		severity = syslogError
+	}
+	params := map[string]string{"sha": entry.Sha, "env": entry.Env}
+	for name, val := range entry.Fields {
+		params[name] = val
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sd bytes.Buffer
+	sd.WriteString("[" + syslogSDID)
+	for _, name := range names {
+		fmt.Fprintf(&sd, " %s=\"%s\"", name, sdEscaper.Replace(params[name]))
+	}
+	sd.WriteString("]")
+	return fmt.Sprintf("<%d>1 %s %s %s %s %s %s %s", facility*8+severity,
+		entry.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), headerField(entry.Host, 255),
+		headerField(entry.App, 48), headerField(entry.Container, 128), headerField(entry.Stream, 32), sd.String(),
+		entry.Message)
+}
+
+var sdEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+// Returns val as a header field: printable ASCII without spaces, at most max characters, "-" if empty
+func headerField(val string, max int) string {
+	field := strings.Map(func(c rune) rune {
+		if c <= ' ' || c > '~' {
+			return '_'
+		}
+		return c
+	}, val)
+	if len(field) > max {
+		field = field[:max]
+	}
+	if field == "" {
+		return "-"
+	}
+	return field
+}
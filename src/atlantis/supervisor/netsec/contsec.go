@@ -17,6 +17,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
@@ -37,6 +38,19 @@ func guano(pid int) (string, string, error) {
 	return parts[0], parts[1], nil
 }
 
+// Somewhere a container may connect to besides its security groups: a CIDR and a TCP port, 0 for any port
+type Destination struct {
+	CIDR string
+	Port uint16
+}
+
+func (d Destination) String() string {
+	if d.Port == 0 {
+		return d.CIDR
+	}
+	return fmt.Sprintf("%s:%d", d.CIDR, d.Port)
+}
+
 type ContainerSecurity struct {
 	veth           string
 	mark           string
@@ -44,17 +58,23 @@ type ContainerSecurity struct {
 	Pid            int
 	Pretend        bool
 	SecurityGroups map[string][]uint16 // ipgroup name -> ports
+	IPv6           bool                // the veth is marked for ip6tables as well
+	Egress         []Destination       // allowed on top of the security groups
+	Restricted     bool                // outbound traffic the security groups and Egress do not allow is rejected
 }
 
 func (c ContainerSecurity) String() string {
-	return fmt.Sprintf("veth %s mark %s id %s pid %d groups %v", c.veth, c.mark, c.ID, c.Pid, c.SecurityGroups)
+	return fmt.Sprintf("veth %s mark %s id %s pid %d groups %v egress %v", c.veth, c.mark, c.ID, c.Pid,
+		c.SecurityGroups, c.Egress)
 }
 
-func NewContainerSecurity(id string, pid int, sgs map[string][]uint16, pretend bool) (contSec *ContainerSecurity, err error) {
+func NewContainerSecurity(id string, pid int, sgs map[string][]uint16, egress []Destination,
+	pretend bool) (contSec *ContainerSecurity, err error) {
 	contSec = &ContainerSecurity{
 		ID:             id,
 		Pid:            pid,
 		SecurityGroups: sgs,
+		Egress:         egress,
 		Pretend:        pretend,
 	}
 	for i := 0; i < 5; i++ {
@@ -67,17 +87,28 @@ func NewContainerSecurity(id string, pid int, sgs map[string][]uint16, pretend b
 	return contSec, err
 }
 
-func (c *ContainerSecurity) filterPort(action, ip string, port uint16) error {
-	defer echoIPTables(c.Pretend)
-	_, err := c.executeCommand("iptables", action, "FORWARD",
+func (c *ContainerSecurity) filterPortRule(ip string, port uint16) []string {
+	return []string{"FORWARD",
 		"-d", ip,
 		"-p", "tcp", "--dport", fmt.Sprintf("%d", port),
 		"-m", "mark", "--mark", c.mark,
-		"-j", "ACCEPT")
+		"-j", "ACCEPT"}
+}
+
+func (c *ContainerSecurity) filterPort(action, ip string, port uint16) error {
+	defer echoIPTables(c.Pretend)
+	_, err := c.executeCommand(iptables(ip), append([]string{action}, c.filterPortRule(ip, port)...)...)
 	return err
 }
 
 func (c *ContainerSecurity) allowPort(ip string, port uint16) error {
+	if isIPv6(ip) && !c.IPv6 {
+		// the first IPv6 address the container can reach
+		if err := c.markVeth("ip6tables", "-I"); err != nil {
+			return err
+		}
+		c.IPv6 = true
+	}
 	return c.filterPort("-I", ip, port)
 }
 
@@ -85,20 +116,115 @@ func (c *ContainerSecurity) rejectPort(ip string, port uint16) error {
 	return c.filterPort("-D", ip, port)
 }
 
-func (c *ContainerSecurity) markVeth(action string) error {
+func (c *ContainerSecurity) egressRule(dest Destination) []string {
+	args := []string{"FORWARD", "-d", dest.CIDR}
+	if dest.Port != 0 {
+		args = append(args, "-p", "tcp", "--dport", fmt.Sprintf("%d", dest.Port))
+	}
+	return append(args, "-m", "mark", "--mark", c.mark, "-j", "ACCEPT")
+}
+
+func (c *ContainerSecurity) filterEgress(action string, dest Destination) error {
 	defer echoIPTables(c.Pretend)
-	_, err := c.executeCommand("iptables", action, "PREROUTING", "-t", "mangle",
+	_, err := c.executeCommand(iptables(cidrIP(dest.CIDR)), append([]string{action}, c.egressRule(dest)...)...)
+	return err
+}
+
+func (c *ContainerSecurity) allowEgress(dest Destination) error {
+	if isIPv6(cidrIP(dest.CIDR)) && !c.IPv6 {
+		if err := c.markVeth("ip6tables", "-I"); err != nil {
+			return err
+		}
+		c.IPv6 = true
+	}
+	return c.filterEgress("-I", dest)
+}
+
+func (c *ContainerSecurity) rejectEgress(dest Destination) error {
+	return c.filterEgress("-D", dest)
+}
+
+func (c *ContainerSecurity) markVethRule() []string {
+	return []string{"PREROUTING", "-t", "mangle",
 		"-m", "physdev", "--physdev-in", c.veth,
-		"-j", "MARK", "--set-mark", c.mark)
+		"-j", "MARK", "--set-mark", c.mark}
+}
+
+func (c *ContainerSecurity) markVeth(iptables, action string) error {
+	defer echoIPTables(c.Pretend)
+	_, err := c.executeCommand(iptables, append([]string{action}, c.markVethRule()...)...)
 	return err
 }
 
 func (c *ContainerSecurity) addMark() error {
-	return c.markVeth("-I")
+	return c.markVeth("iptables", "-I")
 }
 
 func (c *ContainerSecurity) delMark() error {
-	return c.markVeth("-D")
+	if c.IPv6 {
+		c.markVeth("ip6tables", "-D")
+	}
+	return c.markVeth("iptables", "-D")
+}
+
+func (c *ContainerSecurity) restrictRule() []string {
+	return []string{"FORWARD", "-m", "mark", "--mark", c.mark, "-j", "REJECT"}
+}
+
+// Reject whatever the container sends that no allow rule accepts. The allow rules are inserted after this one
+// and so end up above it.
+func (c *ContainerSecurity) restrict() error {
+	defer echoIPTables(c.Pretend)
+	if !c.IPv6 {
+		if err := c.markVeth("ip6tables", "-I"); err != nil {
+			return err
+		}
+		c.IPv6 = true
+	}
+	for _, cmd := range []string{"iptables", "ip6tables"} {
+		if _, err := c.executeCommand(cmd, append([]string{"-I"}, c.restrictRule()...)...); err != nil {
+			c.unrestrict()
+			return err
+		}
+	}
+	c.Restricted = true
+	return nil
+}
+
+func (c *ContainerSecurity) unrestrict() error {
+	defer echoIPTables(c.Pretend)
+	_, err := c.executeCommand("iptables", append([]string{"-D"}, c.restrictRule()...)...)
+	if _, err6 := c.executeCommand("ip6tables", append([]string{"-D"}, c.restrictRule()...)...); err == nil {
+		err = err6
+	}
+	return err
+}
+
+// Returns the rules set up for the container in the format of iptables -S, each prefixed with its command
+func (c *ContainerSecurity) Rules(ipGroups map[string][]string) []string {
+	rules := []string{rule("iptables", c.markVethRule())}
+	if c.IPv6 {
+		rules = append(rules, rule("ip6tables", c.markVethRule()))
+	}
+	groups := make([]string, 0, len(c.SecurityGroups))
+	for group := range c.SecurityGroups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		for _, port := range c.SecurityGroups[group] {
+			for _, ip := range ipGroups[group] {
+				rules = append(rules, rule(iptables(ip), c.filterPortRule(ip, port)))
+			}
+		}
+	}
+	for _, dest := range c.Egress {
+		rules = append(rules, rule(iptables(cidrIP(dest.CIDR)), c.egressRule(dest)))
+	}
+	if c.Restricted {
+		rules = append(rules, rule("iptables", c.restrictRule()), rule("ip6tables", c.restrictRule()))
+	}
+	return rules
 }
 
 func (c *ContainerSecurity) executeCommand(cmd string, args ...string) (string, error) {
@@ -15,16 +15,18 @@ import (
 	"atlantis/supervisor/containers/serialize"
 	"errors"
 	"log"
+	"sort"
 	"sync"
 )
 
 type NetworkSecurity struct {
 	sync.Mutex
-	Pretend    bool
-	SaveFile   string                        // where to save state
-	DeniedIPs  map[string]bool               // list of denied IPs. map for easy existence check
-	IPGroups   map[string][]string           // group name -> list of infrastructure IPs to blanket deny
-	Containers map[string]*ContainerSecurity // container id -> ContainerSecurity
+	Pretend        bool
+	RestrictEgress bool                          // containers added from now on may only reach their security groups
+	SaveFile       string                        // where to save state
+	DeniedIPs      map[string]bool               // list of denied IPs. map for easy existence check
+	IPGroups       map[string][]string           // group name -> list of infrastructure IPs to blanket deny
+	Containers     map[string]*ContainerSecurity // container id -> ContainerSecurity
 }
 
 func New(saveFile string, pretend bool) *NetworkSecurity {
@@ -127,10 +129,13 @@ func (n *NetworkSecurity) DeleteIPGroup(name string) error {
 	return nil
 }
 
-func (n *NetworkSecurity) AddContainerSecurity(id string, pid int, sgs map[string][]uint16) error {
+// Set up the container's security groups and egress. With restrict, or if RestrictEgress is set, anything else
+// the container sends is rejected.
+func (n *NetworkSecurity) AddContainerSecurity(id string, pid int, sgs map[string][]uint16, egress []Destination,
+	restrict bool) error {
 	n.Lock()
 	defer n.Unlock()
-	log.Printf("[netsec] add container security: "+id+", pid: %d, sgs: %#v", pid, sgs)
+	log.Printf("[netsec] add container security: "+id+", pid: %d, sgs: %#v, egress: %v", pid, sgs, egress)
 	if _, exists := n.Containers[id]; exists {
 		// we already have security set up for this id. don't do it and return an error.
 		log.Println("[netsec] -- not adding, already existed for: " + id)
@@ -146,13 +151,25 @@ func (n *NetworkSecurity) AddContainerSecurity(id string, pid int, sgs map[strin
 	}
 
 	// fetch network info
-	contSec, err := NewContainerSecurity(id, pid, sgs, n.Pretend)
+	contSec, err := NewContainerSecurity(id, pid, sgs, egress, n.Pretend)
 	if err != nil {
 		log.Println("[netsec] -- guano error: " + err.Error())
 		return err
 	}
 	log.Println("[netsec] --> contSec: " + contSec.String())
 	contSec.addMark()
+	if restrict || n.RestrictEgress {
+		if err := contSec.restrict(); err != nil {
+			n.removeRules(contSec)
+			log.Println("[netsec] -- restrict error: " + err.Error())
+			return err
+		}
+		// replies to connections made to the container must still get out, so conntrack goes back on top
+		n.delConnTrackRule()
+		if err := n.addConnTrackRule(); err != nil {
+			log.Println("[netsec] -- conntrack rule error: " + err.Error())
+		}
+	}
 
 	// add forward rules
 	for group, ports := range sgs {
@@ -167,6 +184,13 @@ func (n *NetworkSecurity) AddContainerSecurity(id string, pid int, sgs map[strin
 			}
 		}
 	}
+	for _, dest := range egress {
+		if err := contSec.allowEgress(dest); err != nil {
+			n.removeRules(contSec)
+			log.Println("[netsec] -- allow egress error: " + err.Error())
+			return err
+		}
+	}
 	n.Containers[id] = contSec
 	n.save()
 	log.Println("[netsec] -- added " + id)
@@ -185,7 +209,19 @@ func (n *NetworkSecurity) RemoveContainerSecurity(id string) error {
 	}
 
 	log.Println("[netsec] --> contSec: " + contSec.String())
+	n.removeRules(contSec)
+	delete(n.Containers, id)
+	n.save()
+	log.Println("[netsec] -- removed " + id)
+	return nil
+}
+
+// Delete the container's rules, ignoring the ones that were never added
+func (n *NetworkSecurity) removeRules(contSec *ContainerSecurity) {
 	contSec.delMark()
+	if contSec.Restricted {
+		contSec.unrestrict()
+	}
 	// remove forward rules
 	for group, ports := range contSec.SecurityGroups {
 		for _, port := range ports {
@@ -195,29 +231,59 @@ func (n *NetworkSecurity) RemoveContainerSecurity(id string) error {
 			}
 		}
 	}
-	delete(n.Containers, id)
-	n.save()
-	log.Println("[netsec] -- removed " + id)
-	return nil
+	for _, dest := range contSec.Egress {
+		contSec.rejectEgress(dest)
+	}
 }
 
-func (n *NetworkSecurity) delConnTrackRule() error {
+var connTrackArgs = []string{"FORWARD", "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}
+
+func (n *NetworkSecurity) connTrackRule(action string) error {
 	defer echoIPTables(n.Pretend)
-	_, err := n.executeCommand("iptables", "-D", "FORWARD", "-m", "conntrack", "--ctstate",
-		"RELATED,ESTABLISHED", "-j", "ACCEPT")
+	_, err := n.executeCommand("iptables", append([]string{action}, connTrackArgs...)...)
+	if n.hasIPv6() {
+		if _, err6 := n.executeCommand("ip6tables", append([]string{action}, connTrackArgs...)...); err == nil {
+			err = err6
+		}
+	}
 	return err
 }
 
+func (n *NetworkSecurity) delConnTrackRule() error {
+	return n.connTrackRule("-D")
+}
+
 func (n *NetworkSecurity) addConnTrackRule() error {
-	defer echoIPTables(n.Pretend)
-	_, err := n.executeCommand("iptables", "-I", "FORWARD", "-m", "conntrack", "--ctstate",
-		"RELATED,ESTABLISHED", "-j", "ACCEPT")
-	return err
+	return n.connTrackRule("-I")
+}
+
+// Returns true if any ip group has an IPv6 address or any container is restricted, which needs the ip6tables rules
+func (n *NetworkSecurity) hasIPv6() bool {
+	if n.RestrictEgress {
+		return true
+	}
+	for _, contSec := range n.Containers {
+		if contSec.Restricted {
+			return true
+		}
+	}
+	for _, ips := range n.IPGroups {
+		for _, ip := range ips {
+			if isIPv6(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func forwardRuleArgs(ip string) []string {
+	return []string{"FORWARD", "-d", ip, "-j", "REJECT"}
 }
 
 func (n *NetworkSecurity) forwardRule(action, ip string) error {
 	defer echoIPTables(n.Pretend)
-	_, err := n.executeCommand("iptables", action, "FORWARD", "-d", ip, "-j", "REJECT")
+	_, err := n.executeCommand(iptables(ip), append([]string{action}, forwardRuleArgs(ip)...)...)
 	return err
 }
 
@@ -232,3 +298,40 @@ func (n *NetworkSecurity) allowIP(ip string) error {
 func (n *NetworkSecurity) executeCommand(cmd string, args ...string) (string, error) {
 	return executeCommand(n.Pretend, cmd, args...)
 }
+
+// Returns the rules set up for every container: conntrack and the blanket rejects of the ip groups' IPs, in the
+// format of iptables -S
+func (n *NetworkSecurity) HostRules() []string {
+	n.Lock()
+	defer n.Unlock()
+	rules := []string{}
+	if len(n.IPGroups) > 0 || len(n.Containers) > 0 {
+		rules = append(rules, rule("iptables", connTrackArgs))
+		if n.hasIPv6() {
+			rules = append(rules, rule("ip6tables", connTrackArgs))
+		}
+	}
+	ips := make([]string, 0, len(n.DeniedIPs))
+	for ip := range n.DeniedIPs {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	for _, ip := range ips {
+		rules = append(rules, rule(iptables(ip), forwardRuleArgs(ip)))
+	}
+	return rules
+}
+
+// Returns the rules set up for the container with id, or for every container if id is empty, container id ->
+// rules in the format of iptables -S
+func (n *NetworkSecurity) ContainerRules(id string) map[string][]string {
+	n.Lock()
+	defer n.Unlock()
+	rules := map[string][]string{}
+	for contID, contSec := range n.Containers {
+		if id == "" || id == contID {
+			rules[contID] = contSec.Rules(n.IPGroups)
+		}
+	}
+	return rules
+}
@@ -13,11 +13,40 @@ package netsec
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"net"
 	"os/exec"
 	"strings"
 )
 
+func isIPv6(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.To4() == nil
+}
+
+// Returns the address of a CIDR, or the string itself if it is a plain IP
+func cidrIP(cidr string) string {
+	return strings.SplitN(cidr, "/", 2)[0]
+}
+
+// Returns the iptables command that filters traffic to ip
+func iptables(ip string) string {
+	if isIPv6(ip) {
+		return "ip6tables"
+	}
+	return "iptables"
+}
+
+// Formats the chain and arguments of a rule as iptables -S prints it, "<command> [-t <table>] -A <chain> ..."
+func rule(command string, args []string) string {
+	chain, args := args[0], args[1:]
+	if len(args) >= 2 && args[0] == "-t" {
+		return fmt.Sprintf("%s -t %s -A %s %s", command, args[1], chain, strings.Join(args[2:], " "))
+	}
+	return fmt.Sprintf("%s -A %s %s", command, chain, strings.Join(args, " "))
+}
+
 func echoIPTables(pretend bool) {
 	executeCommand(pretend, "iptables", "-L")
 	executeCommand(pretend, "iptables", "-t", "mangle", "-L")
@@ -0,0 +1,201 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package rpc
+
+import (
+	. "atlantis/supervisor/rpc/types"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	AllMethods        = "*"
+	AnonymousIdentity = "anonymous"
+)
+
+// What a role may call. Deny takes methods back from Allow, so a role can be given everything but a few methods.
+type Role struct {
+	Allow []string `toml:"allow"` // RPC methods, or * for all of them
+	Deny  []string `toml:"deny"`
+}
+
+func (r *Role) permits(method string) bool {
+	for _, denied := range r.Deny {
+		if denied == method {
+			return false
+		}
+	}
+	for _, allowed := range r.Allow {
+		if allowed == method || allowed == AllMethods {
+			return true
+		}
+	}
+	return false
+}
+
+type Identity struct {
+	TokenSHA256 string   `toml:"token_sha256"` // hex sha256 of the token, so the file holds no secrets
+	Roles       []string `toml:"roles"`
+}
+
+// Maps the tokens clients call with to the RPC methods they may call, e.g.
+//
+//	anonymous = ["monitoring"]
+//
+//	[roles.monitoring]
+//	allow = ["List", "HealthCheck"]
+//
+//	[roles.deploy]
+//	allow = ["*"]
+//	deny = ["AuthorizeSSH", "DeauthorizeSSH"]
+//
+//	[identities.deployer]
+//	token_sha256 = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+//	roles = ["deploy"]
+//
+// Version and Idle can always be called.
+type RBAC struct {
+	Anonymous  []string             `toml:"anonymous"` // roles of callers without a token
+	Roles      map[string]*Role     `toml:"roles"`
+	Identities map[string]*Identity `toml:"identities"`
+	byToken    map[string]string    // token sha256 -> identity
+}
+
+var (
+	rbacLock sync.RWMutex
+	rbac     *RBAC // nil if anybody may call anything
+)
+
+// Returns the names of the RPC methods
+func Methods() []string {
+	t := reflect.TypeOf(new(Supervisor))
+	methods := make([]string, t.NumMethod())
+	for i := range methods {
+		methods[i] = t.Method(i).Name
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Read and check an RBAC file
+func LoadRBAC(file string) (*RBAC, error) {
+	r := &RBAC{}
+	if _, err := toml.DecodeFile(file, r); err != nil {
+		return nil, err
+	}
+	if err := r.check(); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return r, nil
+}
+
+func (r *RBAC) check() error {
+	methods := map[string]bool{AllMethods: true}
+	for _, method := range Methods() {
+		methods[method] = true
+	}
+	for name, role := range r.Roles {
+		for _, method := range append(append([]string{}, role.Allow...), role.Deny...) {
+			if !methods[method] {
+				return fmt.Errorf("role %s: unknown method %s", name, method)
+			}
+		}
+	}
+	checkRoles := func(identity string, roles []string) error {
+		for _, role := range roles {
+			if r.Roles[role] == nil {
+				return fmt.Errorf("%s: unknown role %s", identity, role)
+			}
+		}
+		return nil
+	}
+	if err := checkRoles(AnonymousIdentity, r.Anonymous); err != nil {
+		return err
+	}
+	r.byToken = map[string]string{}
+	for name, identity := range r.Identities {
+		if name == AnonymousIdentity {
+			return fmt.Errorf("%s is reserved for callers without a token", name)
+		}
+		sum := strings.ToLower(identity.TokenSHA256)
+		if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("%s: token_sha256 must be a hex sha256", name)
+		}
+		if other, ok := r.byToken[sum]; ok {
+			return fmt.Errorf("%s and %s have the same token", name, other)
+		}
+		r.byToken[sum] = name
+		if err := checkRoles(name, identity.Roles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the identity a token belongs to and its roles, an error if the token is unknown
+func (r *RBAC) identify(token string) (string, []string, error) {
+	if token == "" {
+		return AnonymousIdentity, r.Anonymous, nil
+	}
+	sum := sha256.Sum256([]byte(token))
+	name, ok := r.byToken[hex.EncodeToString(sum[:])]
+	if !ok {
+		return "", nil, errors.New("Unknown token.")
+	}
+	return name, r.Identities[name].Roles, nil
+}
+
+// Returns nil if the caller with the token may call the method
+func (r *RBAC) Authorize(method, token string) error {
+	name, roles, err := r.identify(token)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if r.Roles[role].permits(method) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s may not call %s.", name, method)
+}
+
+// Enforce r from now on, nil to let anybody call anything
+func SetRBAC(r *RBAC) {
+	rbacLock.Lock()
+	defer rbacLock.Unlock()
+	rbac = r
+}
+
+// Returns nil if the caller may call the method. The token is cleared once it is checked, so the request the task
+// tracker keeps around afterwards holds no credentials.
+func authorize(method string, auth *SupervisorAuthArg) error {
+	rbacLock.RLock()
+	defer rbacLock.RUnlock()
+	if rbac == nil {
+		auth.Token = ""
+		return nil
+	}
+	if err := rbac.Authorize(method, auth.Token); err != nil {
+		log.Printf("[RPC][%s] denied: %v", method, err)
+		return err
+	}
+	auth.Token = ""
+	return nil
+}
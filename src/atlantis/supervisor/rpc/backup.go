@@ -0,0 +1,117 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package rpc
+
+import (
+	. "atlantis/common"
+	"atlantis/supervisor/containers"
+	. "atlantis/supervisor/rpc/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+type BackupExecutor struct {
+	arg   SupervisorBackupArg
+	reply *SupervisorBackupReply
+}
+
+func (e *BackupExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *BackupExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *BackupExecutor) Description() string {
+	return "Backup"
+}
+
+func (e *BackupExecutor) Authorize() error {
+	return authorize("Backup", &e.arg.SupervisorAuthArg)
+}
+
+func (e *BackupExecutor) Execute(t *Task) (err error) {
+	backup := containers.Backup()
+	if e.reply.Backup, err = json.Marshal(backup); err != nil {
+		e.reply.Status = StatusError
+		return err
+	}
+	t.Log("-> backed up %d containers", len(backup.Containers))
+	e.reply.Status = StatusOk
+	return nil
+}
+
+func (ih *Supervisor) Backup(arg SupervisorBackupArg, reply *SupervisorBackupReply) error {
+	return NewTask("Backup", &BackupExecutor{arg, reply}).Run()
+}
+
+type RestoreExecutor struct {
+	arg   SupervisorRestoreArg
+	reply *SupervisorRestoreReply
+}
+
+func (e *RestoreExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *RestoreExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *RestoreExecutor) Description() string {
+	return fmt.Sprintf("%d bytes", len(e.arg.Backup))
+}
+
+func (e *RestoreExecutor) Authorize() error {
+	return authorize("Restore", &e.arg.SupervisorAuthArg)
+}
+
+func (e *RestoreExecutor) Execute(t *Task) error {
+	if len(e.arg.Backup) == 0 {
+		return errors.New("Please specify a backup.")
+	}
+	var backup StateBackup
+	if err := json.Unmarshal(e.arg.Backup, &backup); err != nil {
+		e.reply.Status = StatusError
+		return errors.New("Invalid backup: " + err.Error())
+	}
+	errs, err := containers.Restore(&backup)
+	if err != nil {
+		e.reply.Status = StatusError
+		return err
+	}
+	e.reply.ContainerIDs = []string{}
+	e.reply.Errors = map[string]string{}
+	for id := range backup.Containers {
+		if errs[id] != nil {
+			e.reply.Errors[id] = errs[id].Error()
+			t.Log("-> could not bring %s back up: %v", id, errs[id])
+		} else {
+			e.reply.ContainerIDs = append(e.reply.ContainerIDs, id)
+			t.Log("-> restored %s", id)
+		}
+	}
+	sort.Strings(e.reply.ContainerIDs)
+	// the state is restored either way, so the reply still goes out with the containers that need attention
+	e.reply.Status = StatusOk
+	if len(e.reply.Errors) > 0 {
+		e.reply.Status = StatusError
+	}
+	return nil
+}
+
+func (ih *Supervisor) Restore(arg SupervisorRestoreArg, reply *SupervisorRestoreReply) error {
+	return NewTask("Restore", &RestoreExecutor{arg, reply}).Run()
+}
@@ -0,0 +1,99 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package rpc
+
+import (
+	. "atlantis/common"
+	"atlantis/supervisor/containers"
+	. "atlantis/supervisor/rpc/types"
+	"errors"
+	"fmt"
+)
+
+type CheckpointExecutor struct {
+	arg   SupervisorCheckpointArg
+	reply *SupervisorCheckpointReply
+}
+
+func (e *CheckpointExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *CheckpointExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *CheckpointExecutor) Description() string {
+	return fmt.Sprintf("%s as %s, exit: %t", e.arg.ContainerID, e.arg.Name, e.arg.Exit)
+}
+
+func (e *CheckpointExecutor) Authorize() error {
+	return authorize("Checkpoint", &e.arg.SupervisorAuthArg)
+}
+
+func (e *CheckpointExecutor) Execute(t *Task) error {
+	if e.arg.ContainerID == "" {
+		return errors.New("Please specify a container id.")
+	}
+	name, err := containers.Checkpoint(e.arg.ContainerID, e.arg.Name, e.arg.Exit)
+	if err != nil {
+		e.reply.Status = StatusError
+		return err
+	}
+	t.Log("[RPC][Checkpoint] checkpointed %s as %s", e.arg.ContainerID, name)
+	e.reply.Name = name
+	e.reply.Status = StatusOk
+	return nil
+}
+
+func (ih *Supervisor) Checkpoint(arg SupervisorCheckpointArg, reply *SupervisorCheckpointReply) error {
+	return NewTask("Checkpoint", &CheckpointExecutor{arg, reply}).Run()
+}
+
+type RestoreCheckpointExecutor struct {
+	arg   SupervisorRestoreCheckpointArg
+	reply *SupervisorRestoreCheckpointReply
+}
+
+func (e *RestoreCheckpointExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *RestoreCheckpointExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *RestoreCheckpointExecutor) Description() string {
+	return fmt.Sprintf("%s from %s", e.arg.ContainerID, e.arg.Name)
+}
+
+func (e *RestoreCheckpointExecutor) Authorize() error {
+	return authorize("RestoreCheckpoint", &e.arg.SupervisorAuthArg)
+}
+
+func (e *RestoreCheckpointExecutor) Execute(t *Task) error {
+	if e.arg.ContainerID == "" {
+		return errors.New("Please specify a container id.")
+	}
+	if err := containers.RestoreCheckpoint(e.arg.ContainerID, e.arg.Name); err != nil {
+		e.reply.Status = StatusError
+		return err
+	}
+	e.reply.Container = containers.Get(e.arg.ContainerID)
+	e.reply.Status = StatusOk
+	return nil
+}
+
+func (ih *Supervisor) RestoreCheckpoint(arg SupervisorRestoreCheckpointArg,
+	reply *SupervisorRestoreCheckpointReply) error {
+	return NewTask("RestoreCheckpoint", &RestoreCheckpointExecutor{arg, reply}).Run()
+}
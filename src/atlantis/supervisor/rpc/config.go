@@ -0,0 +1,59 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package rpc
+
+import (
+	. "atlantis/common"
+	. "atlantis/supervisor/rpc/types"
+	"errors"
+	"time"
+)
+
+// Returns the active config rendered as TOML and when it was loaded. Set by the server.
+var ActiveConfig func() (string, time.Time, error)
+
+type ConfigExecutor struct {
+	arg   SupervisorConfigArg
+	reply *SupervisorConfigReply
+}
+
+func (e *ConfigExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *ConfigExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *ConfigExecutor) Description() string {
+	return "Config"
+}
+
+func (e *ConfigExecutor) Authorize() error {
+	return authorize("Config", &e.arg.SupervisorAuthArg)
+}
+
+func (e *ConfigExecutor) AllowDuringMaintenance() bool {
+	return true // only reads
+}
+
+func (e *ConfigExecutor) Execute(t *Task) (err error) {
+	if ActiveConfig == nil {
+		return errors.New("The config is not available")
+	}
+	e.reply.Config, e.reply.LoadedAt, err = ActiveConfig()
+	return err
+}
+
+func (ih *Supervisor) Config(arg SupervisorConfigArg, reply *SupervisorConfigReply) error {
+	return NewTask("Config", &ConfigExecutor{arg, reply}).Run()
+}
@@ -13,8 +13,10 @@ package rpc
 
 import (
 	. "atlantis/common"
+	"atlantis/supervisor/cgroup"
 	"atlantis/supervisor/containers"
 	. "atlantis/supervisor/rpc/types"
+	"atlantis/supervisor/trace"
 	"errors"
 	"fmt"
 )
@@ -34,15 +36,29 @@ func (e *ListExecutor) Result() interface{} {
 }
 
 func (e *ListExecutor) Description() string {
+	if len(e.arg.Labels) > 0 {
+		return fmt.Sprintf("labels: %v", e.arg.Labels)
+	}
 	return "List"
 }
 
 func (e *ListExecutor) Authorize() error {
-	return nil
+	return authorize("List", &e.arg.SupervisorAuthArg)
 }
 
 func (e *ListExecutor) Execute(t *Task) error {
+	span := trace.Start("Supervisor.List", nil)
+	defer span.End(nil)
+	listSpan := trace.Start("list containers", span)
 	e.reply.Containers, e.reply.UnusedPorts = containers.List()
+	listSpan.End(nil)
+	e.reply.PortPools = containers.PortPools()
+	for id, cont := range e.reply.Containers {
+		if !cont.MatchesLabels(e.arg.Labels) {
+			delete(e.reply.Containers, id)
+		}
+	}
+	e.reply.Instances = containers.CountInstances(e.reply.Containers)
 	return nil
 }
 
@@ -68,7 +84,7 @@ func (e *GetExecutor) Description() string {
 }
 
 func (e *GetExecutor) Authorize() error {
-	return nil
+	return authorize("Get", &e.arg.SupervisorAuthArg)
 }
 
 func (e *GetExecutor) Execute(t *Task) (err error) {
@@ -77,6 +93,13 @@ func (e *GetExecutor) Execute(t *Task) (err error) {
 		e.reply.Status = StatusError
 		err = errors.New("Unknown Container.")
 	} else {
+		if e.reply.Container.Pid != 0 {
+			usage, uerr := cgroup.ContainerUsage(e.reply.Container.Pid)
+			if uerr != nil {
+				t.Log("-> could not read the cgroup of %s: %v", e.arg.ContainerID, uerr)
+			}
+			e.reply.Usage = usage
+		}
 		e.reply.Status = StatusOk
 	}
 	return
@@ -85,3 +108,128 @@ func (e *GetExecutor) Execute(t *Task) (err error) {
 func (ih *Supervisor) Get(arg SupervisorGetArg, reply *SupervisorGetReply) (err error) {
 	return NewTask("Get", &GetExecutor{arg, reply}).Run()
 }
+
+type ListEventsExecutor struct {
+	arg   SupervisorListEventsArg
+	reply *SupervisorListEventsReply
+}
+
+func (e *ListEventsExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *ListEventsExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *ListEventsExecutor) Description() string {
+	return fmt.Sprintf("%s since %s", e.arg.ContainerID, e.arg.Since)
+}
+
+func (e *ListEventsExecutor) Authorize() error {
+	return authorize("ListEvents", &e.arg.SupervisorAuthArg)
+}
+
+func (e *ListEventsExecutor) Execute(t *Task) error {
+	e.reply.Events = containers.Events(e.arg.ContainerID, e.arg.Since)
+	e.reply.Status = StatusOk
+	return nil
+}
+
+func (ih *Supervisor) ListEvents(arg SupervisorListEventsArg, reply *SupervisorListEventsReply) error {
+	return NewTask("ListEvents", &ListEventsExecutor{arg, reply}).Run()
+}
+
+type StatsHistoryExecutor struct {
+	arg   SupervisorStatsHistoryArg
+	reply *SupervisorStatsHistoryReply
+}
+
+func (e *StatsHistoryExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *StatsHistoryExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *StatsHistoryExecutor) Description() string {
+	return fmt.Sprintf("%s from %s until %s", e.arg.ContainerID, e.arg.Since, e.arg.Until)
+}
+
+func (e *StatsHistoryExecutor) Authorize() error {
+	return authorize("StatsHistory", &e.arg.SupervisorAuthArg)
+}
+
+func (e *StatsHistoryExecutor) Execute(t *Task) error {
+	if containers.StatsInterval <= 0 {
+		e.reply.Status = StatusError
+		return errors.New("This supervisor keeps no stats history.")
+	}
+	if !e.arg.Until.IsZero() && e.arg.Until.Before(e.arg.Since) {
+		e.reply.Status = StatusError
+		return errors.New("Until is before Since.")
+	}
+	samples, err := containers.StatsHistory(e.arg.ContainerID, e.arg.Since, e.arg.Until)
+	if err != nil {
+		e.reply.Status = StatusError
+		return err
+	}
+	e.reply.Samples = samples
+	e.reply.Status = StatusOk
+	return nil
+}
+
+func (ih *Supervisor) StatsHistory(arg SupervisorStatsHistoryArg, reply *SupervisorStatsHistoryReply) error {
+	return NewTask("StatsHistory", &StatsHistoryExecutor{arg, reply}).Run()
+}
+
+const DefaultLogLines = 100
+
+type LogsExecutor struct {
+	arg   SupervisorLogsArg
+	reply *SupervisorLogsReply
+}
+
+func (e *LogsExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *LogsExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *LogsExecutor) Description() string {
+	return fmt.Sprintf("%s %s last %d lines", e.arg.ContainerID, e.arg.Stream, e.arg.Lines)
+}
+
+func (e *LogsExecutor) Authorize() error {
+	return authorize("Logs", &e.arg.SupervisorAuthArg)
+}
+
+func (e *LogsExecutor) Execute(t *Task) error {
+	if containers.CaptureDir == "" {
+		e.reply.Status = StatusError
+		return errors.New("This supervisor does not capture the output of containers.")
+	}
+	if e.arg.Stream != "" && e.arg.Stream != LogStreamStdout && e.arg.Stream != LogStreamStderr {
+		e.reply.Status = StatusError
+		return fmt.Errorf("Unknown stream %q, use %s or %s.", e.arg.Stream, LogStreamStdout, LogStreamStderr)
+	}
+	lines := int(e.arg.Lines)
+	if lines == 0 {
+		lines = DefaultLogLines
+	}
+	logLines, err := containers.Logs(e.arg.ContainerID, e.arg.Stream, e.arg.Since, lines)
+	if err != nil {
+		e.reply.Status = StatusError
+		return err
+	}
+	e.reply.Lines = logLines
+	e.reply.Status = StatusOk
+	return nil
+}
+
+func (ih *Supervisor) Logs(arg SupervisorLogsArg, reply *SupervisorLogsReply) error {
+	return NewTask("Logs", &LogsExecutor{arg, reply}).Run()
+}
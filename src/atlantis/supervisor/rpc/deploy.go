@@ -13,12 +13,24 @@ package rpc
 
 import (
 	. "atlantis/common"
+	. "atlantis/supervisor/constant"
 	"atlantis/supervisor/containers"
+	scrypto "atlantis/supervisor/crypto"
+	"atlantis/supervisor/docker"
 	. "atlantis/supervisor/rpc/types"
+	"atlantis/supervisor/trace"
+	atypes "atlantis/types"
+	"crypto"
 	"errors"
 	"fmt"
+	"os"
+	"sort"
+	"time"
 )
 
+// Set before Init. Manifests must be signed with one of these keys to be deployed, empty to deploy any manifest
+var ManifestKeys []crypto.PublicKey
+
 // Deploys an app+sha to the given container id using the given service dependencies (comes from arg.Manifest)
 type DeployExecutor struct {
 	arg   SupervisorDeployArg
@@ -43,10 +55,20 @@ func (e *DeployExecutor) Description() string {
 }
 
 func (e *DeployExecutor) Authorize() error {
-	return nil
+	return authorize("Deploy", &e.arg.SupervisorAuthArg)
 }
 
-func (e *DeployExecutor) Execute(t *Task) error {
+func (e *DeployExecutor) Execute(t *Task) (err error) {
+	span := trace.Start("Supervisor.Deploy", nil)
+	span.SetAttribute("container.id", e.arg.ContainerID)
+	span.SetAttribute("app", e.arg.App)
+	span.SetAttribute("sha", e.arg.Sha)
+	span.SetAttribute("env", e.arg.Env)
+	trace.Bind(e.arg.ContainerID, span)
+	defer func() {
+		trace.Unbind(e.arg.ContainerID)
+		span.End(err)
+	}()
 	if e.arg.App == "" {
 		return errors.New("Please specify an app.")
 	}
@@ -56,25 +78,50 @@ func (e *DeployExecutor) Execute(t *Task) error {
 	if e.arg.ContainerID == "" {
 		return errors.New("Please specify a container id.")
 	}
-	if e.arg.Manifest == nil {
+	if e.arg.Manifest == nil && e.arg.Signed == nil {
 		return errors.New("Please specify a manifest.")
 	}
-	if e.arg.Manifest.CPUShares == 0 {
-		return errors.New("Please specify a number of CPU shares.")
+	manifest, err := verifiedManifest(e.arg.Manifest, e.arg.Signed)
+	if err != nil {
+		return err
 	}
-	if e.arg.Manifest.MemoryLimit == 0 {
-		return errors.New("Please specify a memory limit.")
+	e.arg.Manifest, e.arg.Signed = manifest, nil
+	e.arg.Manifest.ApplyEnv(e.arg.Env)
+	if err := validateManifest(e.arg.Manifest); err != nil {
+		return err
 	}
-	cont, err := containers.Reserve(e.arg.ContainerID, e.arg.Manifest)
+	if err := ValidateLabels(e.arg.Labels); err != nil {
+		return errors.New("Invalid labels: " + err.Error())
+	}
+	if err := containers.CheckNetworkOf(e.arg.NetworkOf, e.arg.Manifest); err != nil {
+		return errors.New("Invalid network: " + err.Error())
+	}
+	if e.arg.Registry != nil {
+		if err := e.arg.Registry.Validate(); err != nil {
+			return errors.New("Invalid registry: " + err.Error())
+		}
+	}
+	// keep the credentials out of the task's request
+	auth := e.arg.RegistryAuth
+	e.arg.RegistryAuth = nil
+	reserveSpan := trace.Start("reserve ports", span)
+	cont, err := containers.ReserveInstance(e.arg.ContainerID, e.arg.App, e.arg.Sha, e.arg.Manifest, "")
+	reserveSpan.End(err)
 	if err != nil {
 		t.Log("-> Error reserving container: %v", err)
 		return err
 	}
-	err = cont.Deploy(e.arg.Host, e.arg.App, e.arg.Sha, e.arg.Env)
+	cont.Registry = e.arg.Registry
+	cont.NetworkOf = e.arg.NetworkOf
+	if e.arg.TTL > 0 {
+		cont.ExpiresAt = time.Now().Add(time.Duration(e.arg.TTL) * time.Second)
+	}
+	err = cont.Deploy(e.arg.Host, e.arg.App, e.arg.Sha, e.arg.Env, e.arg.Labels, auth)
 	if err != nil {
 		cont.Teardown()
 		return err
 	}
+	t.Log("-> deployed %s", cont.Format(ContainerFormatShort))
 	e.reply.Status = StatusOk
 	e.reply.Container = &cont.Container
 	return nil
@@ -99,34 +146,50 @@ func (e *TeardownExecutor) Result() interface{} {
 }
 
 func (e *TeardownExecutor) Description() string {
-	return fmt.Sprintf("%v, all: %t", e.arg.ContainerIDs, e.arg.All)
+	return fmt.Sprintf("%v, all: %t, labels: %v", e.arg.ContainerIDs, e.arg.All, e.arg.Labels)
 }
 
 func (e *TeardownExecutor) Authorize() error {
-	return nil
+	return authorize("Teardown", &e.arg.SupervisorAuthArg)
 }
 
-func (e *TeardownExecutor) Execute(t *Task) error {
-	if e.arg.ContainerIDs == nil && e.arg.All == false {
+func (e *TeardownExecutor) Execute(t *Task) (err error) {
+	span := trace.Start("Supervisor.Teardown", nil)
+	defer func() { span.End(err) }()
+	if e.arg.ContainerIDs == nil && e.arg.All == false && len(e.arg.Labels) == 0 {
 		return errors.New("Please specify container ids or all.")
 	}
 	var containerIDs []string
-	if e.arg.All {
-		t.Log("All requested.")
+	if e.arg.All || len(e.arg.Labels) > 0 {
+		if e.arg.All {
+			t.Log("All requested.")
+		} else {
+			t.Log("Labels %v requested.", e.arg.Labels)
+		}
 		conts, _ := containers.List()
-		containerIDs = make([]string, len(conts))
-		i := 0
-		for id, _ := range conts {
+		containerIDs = make([]string, 0, len(conts))
+		for id, cont := range conts {
+			if !e.arg.All && !cont.MatchesLabels(e.arg.Labels) {
+				continue
+			}
 			t.Log("-> found container %s", id)
-			containerIDs[i] = id
-			i++
+			containerIDs = append(containerIDs, id)
 		}
 	} else {
 		containerIDs = e.arg.ContainerIDs
 	}
+	// containers sharing another's network go down with it, so they are torn down first
+	conts, _ := containers.List()
+	sort.Stable(joinersFirst{containerIDs, conts})
 	e.reply.ContainerIDs = []string{}
 	for _, containerID := range containerIDs {
-		if !containers.Teardown(containerID) {
+		contSpan := trace.Start("teardown container", span)
+		contSpan.SetAttribute("container.id", containerID)
+		trace.Bind(containerID, contSpan)
+		tornDown := containers.Teardown(containerID)
+		trace.Unbind(containerID)
+		contSpan.End(nil)
+		if !tornDown {
 			t.Log("-> no such container: %s", containerID)
 			e.reply.Status += "no such container: " + containerID + "\n"
 		} else {
@@ -142,3 +205,292 @@ func (e *TeardownExecutor) Execute(t *Task) error {
 func (ih *Supervisor) Teardown(arg SupervisorTeardownArg, reply *SupervisorTeardownReply) error {
 	return NewTask("Teardown", &TeardownExecutor{arg, reply}).Run()
 }
+
+// Orders container ids so the containers sharing another's network namespace come first
+type joinersFirst struct {
+	ids   []string
+	conts map[string]*Container
+}
+
+func (j joinersFirst) Len() int      { return len(j.ids) }
+func (j joinersFirst) Swap(a, b int) { j.ids[a], j.ids[b] = j.ids[b], j.ids[a] }
+func (j joinersFirst) Less(a, b int) bool {
+	return j.joined(j.ids[a]) && !j.joined(j.ids[b])
+}
+
+func (j joinersFirst) joined(id string) bool {
+	cont := j.conts[id]
+	return cont != nil && cont.NetworkOf != ""
+}
+
+// Check the profile and that this host can enforce it, rather than have the runtime fail or run the container
+// unconfined
+func validateMACProfile(profile *MACProfile) error {
+	if err := profile.Validate(); err != nil {
+		return err
+	}
+	if profile.AppArmor != "" && profile.AppArmor != AppArmorUnconfined && !docker.AppArmorEnabled() {
+		return errors.New("AppArmor is not enabled on this host")
+	}
+	if profile.UsesSELinux() && !docker.SELinuxEnabled() {
+		return errors.New("SELinux is not enforcing on this host")
+	}
+	return nil
+}
+
+// Normalize the capabilities the manifest adds and drops, so the container records them as they were applied
+func normalizeCapabilities(manifest *Manifest) error {
+	capAdd, err := NormalizeCapabilities(manifest.CapAdd, false)
+	if err != nil {
+		return err
+	}
+	capDrop, err := NormalizeCapabilities(manifest.CapDrop, true)
+	if err != nil {
+		return err
+	}
+	for _, cap := range capAdd {
+		for _, dropped := range capDrop {
+			if cap == dropped {
+				return fmt.Errorf("%s is both added and dropped", cap)
+			}
+		}
+	}
+	manifest.CapAdd, manifest.CapDrop = capAdd, capDrop
+	return nil
+}
+
+// Check that a custom seccomp profile is on this host, so a deploy does not get as far as creating the container
+func validateSeccomp(name string) error {
+	if err := ValidateSeccomp(name); err != nil {
+		return err
+	}
+	switch name {
+	case "", SeccompDefault, SeccompUnconfined:
+		return nil
+	}
+	if _, err := os.Stat(docker.SeccompProfilePath(name)); err != nil {
+		return fmt.Errorf("no profile %s on this host (%v)", name, err)
+	}
+	return nil
+}
+
+// Returns the manifest to deploy: the signed one, once its signature checks out, or the unsigned one if this
+// supervisor has no ManifestKeys. Signed manifests are deployed unchecked without keys, so a pipeline can sign
+// every manifest before all the supervisors verify them.
+func verifiedManifest(manifest *Manifest, signed *SignedManifest) (*Manifest, error) {
+	if signed == nil {
+		if len(ManifestKeys) > 0 {
+			return nil, errors.New("This supervisor only deploys signed manifests.")
+		}
+		return manifest, nil
+	}
+	if manifest != nil {
+		return nil, errors.New("Please specify a manifest or a signed manifest, not both.")
+	}
+	if len(ManifestKeys) > 0 {
+		if err := scrypto.VerifySignature(ManifestKeys, signed.JSON, signed.Signature); err != nil {
+			return nil, errors.New("Invalid manifest signature: " + err.Error())
+		}
+	}
+	manifest, err := signed.Manifest()
+	if err != nil {
+		return nil, errors.New("Invalid manifest: " + err.Error())
+	}
+	return manifest, nil
+}
+
+// Migrate the manifest from older formats and check that everything in it can be deployed
+func validateManifest(manifest *Manifest) error {
+	if err := manifest.Migrate(); err != nil {
+		return errors.New("Invalid manifest: " + err.Error())
+	}
+	if manifest.CPUShares == 0 {
+		return errors.New("Please specify a number of CPU shares.")
+	}
+	if manifest.MemoryLimit == 0 {
+		return errors.New("Please specify a memory limit.")
+	}
+	if manifest.MemorySwap != 0 && manifest.MemorySwap < manifest.MemoryLimit {
+		return errors.New("Please specify a memory+swap limit of at least the memory limit.")
+	}
+	if manifest.Readiness != nil {
+		if err := manifest.Readiness.Validate(); err != nil {
+			return errors.New("Invalid readiness probe: " + err.Error())
+		}
+	}
+	if manifest.Liveness != nil {
+		if err := manifest.Liveness.Validate(); err != nil {
+			return errors.New("Invalid liveness probe: " + err.Error())
+		}
+	}
+	if manifest.RestartPolicy != nil {
+		if err := manifest.RestartPolicy.Validate(); err != nil {
+			return errors.New("Invalid restart policy: " + err.Error())
+		}
+	}
+	if err := ValidateNetworkMode(manifest.NetworkMode); err != nil {
+		return errors.New("Invalid network mode: " + err.Error())
+	}
+	if manifest.ProxyPort != 0 && containers.IsContainerPort(manifest.ProxyPort) {
+		return fmt.Errorf("Invalid proxy port: %d is one of the ports the supervisor gives containers",
+			manifest.ProxyPort)
+	}
+	if manifest.HostNetwork() && docker.UsernsRemap != "" {
+		// it would need the container to run in the host's user namespace, as root on the host
+		return errors.New("Invalid network mode: host networking is not allowed with user namespace remapping")
+	}
+	if manifest.Logging != nil {
+		if err := manifest.Logging.Validate(); err != nil {
+			return errors.New("Invalid logging: " + err.Error())
+		}
+	}
+	if manifest.LogShipping != nil {
+		if err := manifest.LogShipping.Validate(); err != nil {
+			return errors.New("Invalid log shipping: " + err.Error())
+		}
+		if manifest.Logging != nil && !ReadableLogDrivers[manifest.Logging.Driver] {
+			return fmt.Errorf("Invalid log shipping: docker can not read back the logs of the %s logging driver",
+				manifest.Logging.Driver)
+		}
+	}
+	if manifest.MACProfile != nil {
+		if err := validateMACProfile(manifest.MACProfile); err != nil {
+			return errors.New("Invalid mac profile: " + err.Error())
+		}
+	}
+	if err := normalizeCapabilities(manifest); err != nil {
+		return errors.New("Invalid capabilities: " + err.Error())
+	}
+	if err := validateSeccomp(manifest.Seccomp); err != nil {
+		return errors.New("Invalid seccomp profile: " + err.Error())
+	}
+	for _, cmd := range manifest.RunCommands {
+		if err := ValidateRunCommand(cmd); err != nil {
+			return errors.New("Invalid run command: " + err.Error())
+		}
+	}
+	for _, argv := range manifest.RunArgv {
+		if err := ValidateRunArgv(argv); err != nil {
+			return errors.New("Invalid run command: " + err.Error())
+		}
+	}
+	for env, override := range manifest.EnvOverrides {
+		if override == nil {
+			return errors.New("Invalid override for env " + env + ": it is empty")
+		}
+		if err := override.Validate(); err != nil {
+			return errors.New("Invalid override for env " + env + ": " + err.Error())
+		}
+	}
+	if err := manifest.Deps.Validate(); err != nil {
+		return errors.New("Invalid deps: " + err.Error())
+	}
+	for _, ulimit := range manifest.Ulimits {
+		if err := ulimit.Validate(); err != nil {
+			return errors.New("Invalid ulimit: " + err.Error())
+		}
+	}
+	for name := range manifest.Sysctls {
+		if err := ValidateSysctl(name); err != nil {
+			return errors.New("Invalid sysctl: " + err.Error())
+		}
+	}
+	if tuning := manifest.Network; tuning != nil {
+		if err := tuning.Validate(); err != nil {
+			return errors.New("Invalid network tuning: " + err.Error())
+		}
+		if manifest.HostNetwork() {
+			return errors.New("Invalid network tuning: it would tune the host's network")
+		}
+		if tuning.MTU != 0 && docker.Rootless {
+			return errors.New("Invalid network tuning: a rootless supervisor can not set the mtu")
+		}
+		for name := range tuning.Sysctls() {
+			if _, set := manifest.Sysctls[name]; set {
+				return errors.New("Invalid network tuning: sysctl " + name + " is set by sysctls as well")
+			}
+		}
+	}
+	targets := map[string]bool{ContainerLogDir: true, atypes.ContainerConfigDir: true}
+	for _, volume := range manifest.Volumes {
+		if err := volume.Validate(); err != nil {
+			return errors.New("Invalid volume: " + err.Error())
+		}
+		if targets[volume.Target] {
+			return errors.New("Invalid volume: " + volume.Target + " is already mounted")
+		}
+		targets[volume.Target] = true
+	}
+	for _, mount := range manifest.Tmpfs {
+		if err := mount.Validate(); err != nil {
+			return errors.New("Invalid tmpfs: " + err.Error())
+		}
+		if targets[mount.Target] {
+			return errors.New("Invalid tmpfs: " + mount.Target + " is already mounted")
+		}
+		targets[mount.Target] = true
+	}
+	portNames := map[string]bool{}
+	for _, port := range manifest.Ports {
+		if err := port.Validate(); err != nil {
+			return errors.New("Invalid port: " + err.Error())
+		}
+		if portNames[port.Name] {
+			return errors.New("Invalid port: " + port.Name + " is declared more than once")
+		}
+		portNames[port.Name] = true
+	}
+	for _, probe := range []*Probe{manifest.Readiness, manifest.Liveness} {
+		if probe != nil && probe.PortName != "" && !portNames[probe.PortName] {
+			return errors.New("Invalid probe: unknown port " + probe.PortName)
+		}
+	}
+	secretEnvs := map[string]bool{}
+	secretFiles := map[string]bool{}
+	for _, secret := range manifest.Secrets {
+		if err := secret.Validate(); err != nil {
+			return errors.New("Invalid secret: " + err.Error())
+		}
+		if secret.Env != "" && secretEnvs[secret.Env] || secret.File != "" && secretFiles[secret.File] {
+			return errors.New("Invalid secret: " + secret.Ref + " is injected where another secret already is")
+		}
+		secretEnvs[secret.Env] = true
+		secretFiles[secret.File] = true
+	}
+	sidecars := map[string]bool{}
+	for _, sidecar := range manifest.Sidecars {
+		if err := sidecar.Validate(); err != nil {
+			return errors.New("Invalid sidecar: " + err.Error())
+		}
+		if sidecars[sidecar.Name] {
+			return errors.New("Invalid sidecar: " + sidecar.Name + " is declared more than once")
+		}
+		sidecars[sidecar.Name] = true
+	}
+	if egress := manifest.Egress; egress != nil {
+		if err := egress.Validate(); err != nil {
+			return errors.New("Invalid egress: " + err.Error())
+		}
+		if !containers.EnableNetsec {
+			return errors.New("Invalid egress: this supervisor does not enforce network security")
+		}
+		if manifest.HostNetwork() {
+			return errors.New("Invalid egress: it can not be enforced for a container on the host network")
+		}
+	}
+	if tls := manifest.TLS; tls != nil {
+		if err := tls.Validate(); err != nil {
+			return errors.New("Invalid TLS: " + err.Error())
+		}
+		if containers.TLSSidecarImage == "" {
+			return errors.New("Invalid TLS: this supervisor has no TLS sidecar")
+		}
+		if tls.Cert == "" && docker.TLSCertFile == "" {
+			return errors.New("Invalid TLS: this supervisor has no certificate of its own, the manifest should give one")
+		}
+		if sidecars[TLSSidecarName] {
+			return errors.New("Invalid sidecar: " + TLSSidecarName + " is the TLS sidecar's name")
+		}
+	}
+	return nil
+}
@@ -13,8 +13,10 @@ package rpc
 
 import (
 	. "atlantis/common"
+	"atlantis/supervisor/cgroup"
 	. "atlantis/supervisor/constant"
 	"atlantis/supervisor/containers"
+	"atlantis/supervisor/docker"
 	. "atlantis/supervisor/rpc/types"
 )
 
@@ -37,7 +39,7 @@ func (e *HealthCheckExecutor) Description() string {
 }
 
 func (e *HealthCheckExecutor) Authorize() error {
-	return nil
+	return authorize("HealthCheck", &e.arg.SupervisorAuthArg)
 }
 
 func (e *HealthCheckExecutor) AllowDuringMaintenance() bool {
@@ -49,9 +51,21 @@ func (e *HealthCheckExecutor) Execute(t *Task) error {
 	e.reply.Zone = Zone
 	e.reply.Price = Price
 	e.reply.Containers, e.reply.CPUShares, e.reply.Memory = containers.Nums()
+	e.reply.RawCPUShares, e.reply.RawMemory = containers.RawNums()
+	e.reply.CPUOvercommit, e.reply.MemoryOvercommit = containers.CPUOvercommit, containers.MemoryOvercommit
+	e.reply.GPUs = containers.GPUNums()
+	e.reply.CPUs = containers.CPUNums()
+	e.reply.Disk = containers.DiskNums()
+	e.reply.CgroupVersion = cgroup.Version()
+	e.reply.Runtime = docker.RuntimeName()
+	e.reply.RuntimeVersion, e.reply.APIVersion = docker.Version()
+	e.reply.AppArmor, e.reply.SELinux = docker.AppArmorEnabled(), docker.SELinuxEnabled()
+	conts, _ := containers.List()
+	e.reply.Instances = containers.CountInstances(conts)
 	if Tracker.UnderMaintenance() {
 		e.reply.Status = StatusMaintenance
-	} else if e.reply.Containers.Free == 0 || e.reply.Memory.Free == 0 || e.reply.CPUShares.Free == 0 {
+	} else if e.reply.Containers.Free == 0 || e.reply.Memory.Free == 0 || e.reply.CPUShares.Free == 0 ||
+		(e.reply.Disk.Total > 0 && e.reply.Disk.Free == 0) {
 		e.reply.Status = StatusFull
 	} else {
 		e.reply.Status = StatusOk
@@ -63,6 +77,17 @@ func (e *HealthCheckExecutor) Execute(t *Task) error {
 		e.reply.CPUShares.Used, e.reply.CPUShares.Free)
 	t.Log("-> memory: %d MB total, %d MB used, %d MB free", e.reply.Memory.Total,
 		e.reply.Memory.Used, e.reply.Memory.Free)
+	t.Log("-> raw cpu shares: %d total, %d free (overcommit %.2f)", e.reply.RawCPUShares.Total,
+		e.reply.RawCPUShares.Free, e.reply.CPUOvercommit)
+	t.Log("-> raw memory: %d MB total, %d MB free (overcommit %.2f)", e.reply.RawMemory.Total,
+		e.reply.RawMemory.Free, e.reply.MemoryOvercommit)
+	t.Log("-> gpus: %d total, %d used, %d free", e.reply.GPUs.Total, e.reply.GPUs.Used, e.reply.GPUs.Free)
+	t.Log("-> dedicated cpus: %d total, %d used, %d free", e.reply.CPUs.Total, e.reply.CPUs.Used, e.reply.CPUs.Free)
+	t.Log("-> disk: %d MB total, %d MB used, %d MB free", e.reply.Disk.Total, e.reply.Disk.Used, e.reply.Disk.Free)
+	t.Log("-> runtime: %s %s (api %s)", e.reply.Runtime, e.reply.RuntimeVersion, e.reply.APIVersion)
+	t.Log("-> cgroup: v%d", e.reply.CgroupVersion)
+	t.Log("-> apparmor: %t, selinux: %t", e.reply.AppArmor, e.reply.SELinux)
+	t.Log("-> instances: %v", e.reply.Instances)
 	t.Log("-> status: %s", e.reply.Status)
 	return nil
 }
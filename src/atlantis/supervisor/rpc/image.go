@@ -0,0 +1,115 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package rpc
+
+import (
+	. "atlantis/common"
+	"atlantis/supervisor/docker"
+	. "atlantis/supervisor/rpc/types"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type PrefetchImageExecutor struct {
+	arg   SupervisorPrefetchImageArg
+	reply *SupervisorPrefetchImageReply
+}
+
+func (e *PrefetchImageExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *PrefetchImageExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *PrefetchImageExecutor) Description() string {
+	return fmt.Sprintf("%s @ %s", e.arg.App, e.arg.Sha)
+}
+
+func (e *PrefetchImageExecutor) Authorize() error {
+	return authorize("PrefetchImage", &e.arg.SupervisorAuthArg)
+}
+
+func (e *PrefetchImageExecutor) Execute(t *Task) (err error) {
+	if e.arg.App == "" {
+		return errors.New("Please specify an app.")
+	}
+	if e.arg.Sha == "" {
+		return errors.New("Please specify a sha.")
+	}
+	if e.arg.Registry != nil {
+		if err := e.arg.Registry.Validate(); err != nil {
+			return errors.New("Invalid registry: " + err.Error())
+		}
+	}
+	// keep the credentials out of the task's request
+	auth := e.arg.RegistryAuth
+	e.arg.RegistryAuth = nil
+	e.reply.Image, err = docker.PrefetchImage(e.arg.App, e.arg.Sha, e.arg.Registry, auth)
+	if err != nil {
+		e.reply.Status = StatusError
+		return err
+	}
+	t.Log("-> pulled %s", e.reply.Image)
+	e.reply.Status = StatusOk
+	return nil
+}
+
+func (ih *Supervisor) PrefetchImage(arg SupervisorPrefetchImageArg, reply *SupervisorPrefetchImageReply) error {
+	return NewTask("PrefetchImage", &PrefetchImageExecutor{arg, reply}).Run()
+}
+
+type ImageGCExecutor struct {
+	arg   SupervisorImageGCArg
+	reply *SupervisorImageGCReply
+}
+
+func (e *ImageGCExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *ImageGCExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *ImageGCExecutor) Description() string {
+	return fmt.Sprintf("retention: %s, dry run: %t", e.arg.Retention, e.arg.DryRun)
+}
+
+func (e *ImageGCExecutor) Authorize() error {
+	return authorize("ImageGC", &e.arg.SupervisorAuthArg)
+}
+
+func (e *ImageGCExecutor) Execute(t *Task) (err error) {
+	retention := docker.ImageRetention
+	if e.arg.Retention != "" {
+		if retention, err = time.ParseDuration(e.arg.Retention); err != nil {
+			return errors.New("Invalid retention: " + err.Error())
+		}
+	}
+	e.reply.Removed, err = docker.ImageGC(retention, e.arg.DryRun)
+	if err != nil {
+		e.reply.Status = StatusError
+		return err
+	}
+	for _, image := range e.reply.Removed {
+		t.Log("-> removed %s", image)
+	}
+	e.reply.Status = StatusOk
+	return nil
+}
+
+func (ih *Supervisor) ImageGC(arg SupervisorImageGCArg, reply *SupervisorImageGCReply) error {
+	return NewTask("ImageGC", &ImageGCExecutor{arg, reply}).Run()
+}
@@ -37,7 +37,7 @@ func (e *UpdateIPGroupExecutor) Description() string {
 }
 
 func (e *UpdateIPGroupExecutor) Authorize() error {
-	return nil
+	return authorize("UpdateIPGroup", &e.arg.SupervisorAuthArg)
 }
 
 func (e *UpdateIPGroupExecutor) Execute(t *Task) error {
@@ -78,7 +78,7 @@ func (e *DeleteIPGroupExecutor) Description() string {
 }
 
 func (e *DeleteIPGroupExecutor) Authorize() error {
-	return nil
+	return authorize("DeleteIPGroup", &e.arg.SupervisorAuthArg)
 }
 
 func (e *DeleteIPGroupExecutor) Execute(t *Task) error {
@@ -97,3 +97,39 @@ func (e *DeleteIPGroupExecutor) Execute(t *Task) error {
 func (ih *Supervisor) DeleteIPGroup(arg SupervisorDeleteIPGroupArg, reply *SupervisorDeleteIPGroupReply) error {
 	return NewTask("DeleteIPGroup", &DeleteIPGroupExecutor{arg, reply}).Run()
 }
+
+type NetworkRulesExecutor struct {
+	arg   SupervisorNetworkRulesArg
+	reply *SupervisorNetworkRulesReply
+}
+
+func (e *NetworkRulesExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *NetworkRulesExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *NetworkRulesExecutor) Description() string {
+	return e.arg.ContainerID
+}
+
+func (e *NetworkRulesExecutor) Authorize() error {
+	return authorize("NetworkRules", &e.arg.SupervisorAuthArg)
+}
+
+func (e *NetworkRulesExecutor) Execute(t *Task) error {
+	if e.arg.ContainerID != "" && containers.Get(e.arg.ContainerID) == nil {
+		e.reply.Status = StatusError
+		return errors.New("Unknown Container.")
+	}
+	e.reply.HostRules = containers.NetworkSecurity.HostRules()
+	e.reply.ContainerRules = containers.NetworkSecurity.ContainerRules(e.arg.ContainerID)
+	e.reply.Status = StatusOk
+	return nil
+}
+
+func (ih *Supervisor) NetworkRules(arg SupervisorNetworkRulesArg, reply *SupervisorNetworkRulesReply) error {
+	return NewTask("NetworkRules", &NetworkRulesExecutor{arg, reply}).Run()
+}
@@ -17,8 +17,14 @@ import (
 	. "atlantis/supervisor/rpc/types"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
 )
 
+// The file whose existence puts the supervisor in maintenance. Set by the server.
+var MaintenanceFile string
+
 type ContainerMaintenanceExecutor struct {
 	arg   SupervisorContainerMaintenanceArg
 	reply *SupervisorContainerMaintenanceReply
@@ -37,7 +43,7 @@ func (e *ContainerMaintenanceExecutor) Description() string {
 }
 
 func (e *ContainerMaintenanceExecutor) Authorize() error {
-	return nil
+	return authorize("ContainerMaintenance", &e.arg.SupervisorAuthArg)
 }
 
 func (e *ContainerMaintenanceExecutor) Execute(t *Task) error {
@@ -62,6 +68,56 @@ func (ih *Supervisor) ContainerMaintenance(arg SupervisorContainerMaintenanceArg
 	return NewTask("ContainerMaintenance", &ContainerMaintenanceExecutor{arg, reply}).Run()
 }
 
+type SupervisorMaintenanceExecutor struct {
+	arg   SupervisorMaintenanceArg
+	reply *SupervisorMaintenanceReply
+}
+
+func (e *SupervisorMaintenanceExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *SupervisorMaintenanceExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *SupervisorMaintenanceExecutor) Description() string {
+	return fmt.Sprintf("%t : %s", e.arg.Maintenance, e.arg.Reason)
+}
+
+func (e *SupervisorMaintenanceExecutor) Authorize() error {
+	return authorize("Maintenance", &e.arg.SupervisorAuthArg)
+}
+
+func (e *SupervisorMaintenanceExecutor) AllowDuringMaintenance() bool {
+	return true // otherwise maintenance could never be turned off
+}
+
+func (e *SupervisorMaintenanceExecutor) Execute(t *Task) error {
+	if MaintenanceFile == "" {
+		e.reply.Status = StatusError
+		return errors.New("No maintenance file configured.")
+	}
+	e.reply.File = MaintenanceFile
+	var err error
+	if e.arg.Maintenance {
+		contents := fmt.Sprintf("%s %s\n", time.Now().Format(time.RFC3339), e.arg.Reason)
+		err = ioutil.WriteFile(MaintenanceFile, []byte(contents), 0644)
+	} else if err = os.Remove(MaintenanceFile); os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		e.reply.Status = StatusError
+		return err
+	}
+	e.reply.Status = StatusOk
+	return nil
+}
+
+func (ih *Supervisor) Maintenance(arg SupervisorMaintenanceArg, reply *SupervisorMaintenanceReply) error {
+	return NewTask("Maintenance", &SupervisorMaintenanceExecutor{arg, reply}).Run()
+}
+
 // Supervisor Idle Check
 type IdleExecutor struct {
 	arg   SupervisorIdleArg
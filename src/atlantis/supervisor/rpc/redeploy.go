@@ -0,0 +1,258 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package rpc
+
+import (
+	. "atlantis/common"
+	"atlantis/supervisor/containers"
+	. "atlantis/supervisor/rpc/types"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const DefaultRedeployReadyTimeout = 5 * time.Minute
+
+// Deploys the new container of a replace. Replaced in tests to see what it is deployed with.
+var deployContainer = (*containers.Container).Deploy
+
+// Replaces a container with a new sha of its app without taking the app down: the new container is deployed and
+// must pass its readiness probe before the old one is drained and torn down. The old container is left alone if
+// the new one does not come up.
+type RedeployExecutor struct {
+	arg   SupervisorRedeployArg
+	reply *SupervisorRedeployReply
+}
+
+func (e *RedeployExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *RedeployExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *RedeployExecutor) Description() string {
+	return fmt.Sprintf("%s -> %s @ %s", e.arg.ContainerID, e.arg.NewContainerID, e.arg.Sha)
+}
+
+func (e *RedeployExecutor) Authorize() error {
+	return authorize("Redeploy", &e.arg.SupervisorAuthArg)
+}
+
+func (e *RedeployExecutor) Execute(t *Task) error {
+	if e.arg.ContainerID == "" {
+		return errors.New("Please specify a container id.")
+	}
+	if e.arg.NewContainerID == "" {
+		return errors.New("Please specify a new container id.")
+	}
+	if e.arg.Sha == "" {
+		return errors.New("Please specify a sha.")
+	}
+	old := containers.Get(e.arg.ContainerID)
+	if old == nil {
+		e.reply.Status = StatusError
+		return errors.New("Unknown Container.")
+	}
+	manifest := e.arg.Manifest
+	if manifest == nil && e.arg.Signed == nil {
+		// verified when the old container was deployed
+		manifest = old.Manifest.DeepCopy()
+	} else {
+		var err error
+		if manifest, err = verifiedManifest(manifest, e.arg.Signed); err != nil {
+			e.reply.Status = StatusError
+			return err
+		}
+	}
+	manifest.ApplyEnv(old.Env)
+	labels := e.arg.Labels
+	if labels == nil {
+		labels = old.Labels
+	}
+	release := &Release{Sha: e.arg.Sha, Manifest: manifest, Labels: labels, Registry: old.Registry}
+	// keep the credentials out of the task's request
+	auth := e.arg.RegistryAuth
+	e.arg.RegistryAuth = nil
+	cont, err := replace(t, old, e.arg.NewContainerID, release, auth, e.arg.ReadyTimeout, e.arg.DrainTime)
+	if err != nil {
+		e.reply.Status = StatusError
+		return err
+	}
+	e.reply.Status = StatusOk
+	e.reply.Container = cont
+	e.reply.OldContainer = old
+	return nil
+}
+
+func (ih *Supervisor) Redeploy(arg SupervisorRedeployArg, reply *SupervisorRedeployReply) error {
+	return NewTask("Redeploy", &RedeployExecutor{arg, reply}).Run()
+}
+
+// Deploy release next to old, pulling it with auth if its registry needs credentials other than the supervisor's,
+// wait for it to be ready, then drain and tear down old. The new container remembers old's release so it can be
+// rolled back to. Old is left alone if the new container does not come up.
+func replace(t *Task, old *Container, newID string, release *Release, auth *RegistryAuth, readyTimeout,
+	drainTime uint) (*Container, error) {
+	if err := validateManifest(release.Manifest); err != nil {
+		return nil, err
+	}
+	if err := ValidateLabels(release.Labels); err != nil {
+		return nil, errors.New("Invalid labels: " + err.Error())
+	}
+	cont, err := containers.ReserveInstance(newID, old.App, release.Sha, release.Manifest, old.ID)
+	if err != nil {
+		t.Log("-> Error reserving container: %v", err)
+		return nil, err
+	}
+	cont.Registry = release.Registry
+	cont.Previous = &Release{ContainerID: old.ID, Sha: old.Sha, Manifest: old.Manifest, Labels: old.Labels,
+		Registry: old.Registry, ReplacedAt: time.Now()}
+	if err := deployContainer(cont, old.Host, old.App, release.Sha, old.Env, release.Labels, auth); err != nil {
+		cont.Teardown()
+		return nil, err
+	}
+	t.Log("-> deployed %s on port %d, waiting for it to be ready", cont.ID, cont.PrimaryPort)
+	timeout := DefaultRedeployReadyTimeout
+	if readyTimeout > 0 {
+		timeout = time.Duration(readyTimeout) * time.Second
+	}
+	if err := containers.WaitReady(cont.ID, timeout); err != nil {
+		t.Log("-> tearing down %s, keeping %s", cont.ID, old.ID)
+		cont.Teardown()
+		return nil, err
+	}
+	t.Log("-> draining %s for %ds", old.ID, drainTime)
+	if err := containers.SetMaintenance(old, true); err != nil {
+		t.Log("-> could not put %s in maintenance: %v", old.ID, err)
+	}
+	time.Sleep(time.Duration(drainTime) * time.Second)
+	containers.Teardown(old.ID)
+	t.Log("-> replaced %s (port %d) with %s (port %d)", old.ID, old.PrimaryPort, cont.ID, cont.PrimaryPort)
+	return containers.Get(cont.ID), nil
+}
+
+// Redeploys the release a container replaced, so a bad redeploy can be reverted without the manager knowing what
+// was there before
+type RollbackExecutor struct {
+	arg   SupervisorRollbackArg
+	reply *SupervisorRollbackReply
+}
+
+func (e *RollbackExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *RollbackExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *RollbackExecutor) Description() string {
+	return fmt.Sprintf("%s -> %s", e.arg.ContainerID, e.arg.NewContainerID)
+}
+
+func (e *RollbackExecutor) Authorize() error {
+	return authorize("Rollback", &e.arg.SupervisorAuthArg)
+}
+
+func (e *RollbackExecutor) Execute(t *Task) error {
+	if e.arg.ContainerID == "" {
+		return errors.New("Please specify a container id.")
+	}
+	if e.arg.NewContainerID == "" {
+		return errors.New("Please specify a new container id.")
+	}
+	old := containers.Get(e.arg.ContainerID)
+	if old == nil {
+		e.reply.Status = StatusError
+		return errors.New("Unknown Container.")
+	}
+	if old.Previous == nil {
+		e.reply.Status = StatusError
+		return errors.New("Nothing to roll back to, the container was not redeployed.")
+	}
+	t.Log("-> rolling %s back to %s", old.ID, old.Previous)
+	release := *old.Previous
+	release.Manifest = old.Previous.Manifest.DeepCopy()
+	// keep the credentials out of the task's request
+	auth := e.arg.RegistryAuth
+	e.arg.RegistryAuth = nil
+	cont, err := replace(t, old, e.arg.NewContainerID, &release, auth, e.arg.ReadyTimeout, e.arg.DrainTime)
+	if err != nil {
+		e.reply.Status = StatusError
+		return err
+	}
+	e.reply.Status = StatusOk
+	e.reply.Container = cont
+	e.reply.OldContainer = old
+	return nil
+}
+
+func (ih *Supervisor) Rollback(arg SupervisorRollbackArg, reply *SupervisorRollbackReply) error {
+	return NewTask("Rollback", &RollbackExecutor{arg, reply}).Run()
+}
+
+// Lists what redeploying a container with a new manifest would change, so it can be reviewed first
+type DiffManifestExecutor struct {
+	arg   SupervisorDiffManifestArg
+	reply *SupervisorDiffManifestReply
+}
+
+func (e *DiffManifestExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *DiffManifestExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *DiffManifestExecutor) Description() string {
+	return e.arg.ContainerID
+}
+
+func (e *DiffManifestExecutor) Authorize() error {
+	return authorize("DiffManifest", &e.arg.SupervisorAuthArg)
+}
+
+func (e *DiffManifestExecutor) Execute(t *Task) error {
+	if e.arg.ContainerID == "" {
+		return errors.New("Please specify a container id.")
+	}
+	if e.arg.Manifest == nil {
+		return errors.New("Please specify a manifest.")
+	}
+	cont := containers.Get(e.arg.ContainerID)
+	if cont == nil {
+		e.reply.Status = StatusError
+		return errors.New("Unknown Container.")
+	}
+	// compare against what a redeploy would actually run
+	manifest := e.arg.Manifest.DeepCopy()
+	if err := manifest.Migrate(); err != nil {
+		e.reply.Status = StatusError
+		return errors.New("Invalid manifest: " + err.Error())
+	}
+	manifest.ApplyEnv(cont.Env)
+	changes, err := DiffManifests(cont.Manifest, manifest)
+	if err != nil {
+		e.reply.Status = StatusError
+		return err
+	}
+	e.reply.Status = StatusOk
+	e.reply.Changes = changes
+	return nil
+}
+
+func (ih *Supervisor) DiffManifest(arg SupervisorDiffManifestArg, reply *SupervisorDiffManifestReply) error {
+	return NewTask("DiffManifest", &DiffManifestExecutor{arg, reply}).Run()
+}
@@ -0,0 +1,62 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package rpc
+
+import (
+	. "atlantis/common"
+	"atlantis/supervisor/containers"
+	. "atlantis/supervisor/rpc/types"
+	"errors"
+	"fmt"
+)
+
+type ResizeContainerExecutor struct {
+	arg   SupervisorResizeContainerArg
+	reply *SupervisorResizeContainerReply
+}
+
+func (e *ResizeContainerExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *ResizeContainerExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *ResizeContainerExecutor) Description() string {
+	return fmt.Sprintf("%s : %d cpu shares, %d MB memory", e.arg.ContainerID, e.arg.CPUShares, e.arg.MemoryLimit)
+}
+
+func (e *ResizeContainerExecutor) Authorize() error {
+	return authorize("ResizeContainer", &e.arg.SupervisorAuthArg)
+}
+
+func (e *ResizeContainerExecutor) Execute(t *Task) error {
+	if e.arg.ContainerID == "" {
+		return errors.New("Please specify a container id.")
+	}
+	if e.arg.CPUShares == 0 && e.arg.MemoryLimit == 0 {
+		return errors.New("Please specify CPU shares or a memory limit.")
+	}
+	if err := containers.Resize(e.arg.ContainerID, e.arg.CPUShares, e.arg.MemoryLimit); err != nil {
+		e.reply.Status = StatusError
+		return err
+	}
+	t.Log("[RPC][ResizeContainer] resized %s", e.arg.ContainerID)
+	e.reply.Container = containers.Get(e.arg.ContainerID)
+	e.reply.Status = StatusOk
+	return nil
+}
+
+func (ih *Supervisor) ResizeContainer(arg SupervisorResizeContainerArg, reply *SupervisorResizeContainerReply) error {
+	return NewTask("ResizeContainer", &ResizeContainerExecutor{arg, reply}).Run()
+}
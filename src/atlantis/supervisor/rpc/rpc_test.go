@@ -14,10 +14,20 @@ package rpc
 import (
 	. "atlantis/common"
 	"atlantis/supervisor/containers"
+	scrypto "atlantis/supervisor/crypto"
 	. "atlantis/supervisor/rpc/types"
+	"atlantis/supervisor/trace"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"github.com/adjust/gocheck"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"sort"
+	"sync"
 	"testing"
 )
 
@@ -56,6 +66,9 @@ func (s *RpcSuite) TestDeploy(c *gocheck.C) {
 	arg = SupervisorDeployArg{App: "theApp", Sha: "theSha", ContainerID: "theContainerID", Manifest: &Manifest{CPUShares: 1}}
 	reply = SupervisorDeployReply{}
 	c.Assert(ih.Deploy(arg, &reply), gocheck.ErrorMatches, "Please specify a memory limit\\.")
+	arg.Manifest = &Manifest{CPUShares: 1, MemoryLimit: 1, CapAdd: []string{"NET_RAW"}, CapDrop: []string{"net_raw"}}
+	reply = SupervisorDeployReply{}
+	c.Assert(ih.Deploy(arg, &reply), gocheck.ErrorMatches, "Invalid capabilities: NET_RAW is both added and dropped")
 	arg = SupervisorDeployArg{App: "theApp", Sha: "theSha", ContainerID: "theContainerID", Manifest: &Manifest{CPUShares: 1, MemoryLimit: 1}}
 	reply = SupervisorDeployReply{}
 	c.Assert(ih.Deploy(arg, &reply), gocheck.IsNil)
@@ -205,6 +218,8 @@ func (s *RpcSuite) TestList(c *gocheck.C) {
 	c.Assert(ih.List(arg, &reply), gocheck.IsNil)
 	c.Assert(reply.Containers, gocheck.DeepEquals, map[string]*Container{})
 	c.Assert(reply.UnusedPorts, gocheck.DeepEquals, []uint16{61000, 61001})
+	c.Assert(reply.PortPools, gocheck.DeepEquals, &PortPools{Primary: PortRange{Min: 61000, Max: 61001},
+		SSH: PortRange{Min: 61002, Max: 61003}, Secondary: PortRange{Min: 61004, Max: 61007}})
 	// deploy one
 	var dreply SupervisorDeployReply
 	darg := SupervisorDeployArg{App: "theApp1", Sha: "theSha1", ContainerID: "theContainerID1", Manifest: &Manifest{CPUShares: 1, MemoryLimit: 1}}
@@ -240,3 +255,311 @@ func (s *RpcSuite) TestList(c *gocheck.C) {
 	c.Assert(reply.UnusedPorts, gocheck.DeepEquals, []uint16{61000, 61001})
 	os.RemoveAll(saveDir)
 }
+
+func (s *RpcSuite) TestLabels(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	containers.Init("localhost", saveDir, 10, 2, 61000, 100, 1024, false)
+	ih := new(Supervisor)
+	var dreply SupervisorDeployReply
+	darg := SupervisorDeployArg{App: "theApp", Sha: "theSha", ContainerID: "theContainerID", Manifest: &Manifest{CPUShares: 1, MemoryLimit: 1},
+		Labels: map[string]string{"team": "search", "tier": "canary"}}
+	c.Assert(ih.Deploy(darg, &dreply), gocheck.IsNil)
+	c.Assert(dreply.Container.Labels["tier"], gocheck.Equals, "canary")
+	darg = SupervisorDeployArg{App: "theApp2", Sha: "theSha2", ContainerID: "theContainerID2", Manifest: &Manifest{CPUShares: 1, MemoryLimit: 1},
+		Labels: map[string]string{"team": "search"}}
+	dreply = SupervisorDeployReply{}
+	c.Assert(ih.Deploy(darg, &dreply), gocheck.IsNil)
+	darg = SupervisorDeployArg{App: "theApp3", Sha: "theSha3", ContainerID: "theContainerID3", Manifest: &Manifest{CPUShares: 1, MemoryLimit: 1},
+		Labels: map[string]string{"bad\nkey": "x"}}
+	dreply = SupervisorDeployReply{}
+	c.Assert(ih.Deploy(darg, &dreply), gocheck.ErrorMatches, "Invalid labels: .*")
+	// list by label
+	var lreply SupervisorListReply
+	c.Assert(ih.List(SupervisorListArg{Labels: map[string]string{"team": "search"}}, &lreply), gocheck.IsNil)
+	c.Assert(lreply.Containers, gocheck.HasLen, 2)
+	lreply = SupervisorListReply{}
+	c.Assert(ih.List(SupervisorListArg{Labels: map[string]string{"tier": "canary"}}, &lreply), gocheck.IsNil)
+	c.Assert(lreply.Containers, gocheck.HasLen, 1)
+	c.Assert(lreply.Containers["theContainerID"], gocheck.NotNil)
+	// teardown by label
+	var treply SupervisorTeardownReply
+	c.Assert(ih.Teardown(SupervisorTeardownArg{Labels: map[string]string{"tier": "canary"}}, &treply), gocheck.IsNil)
+	c.Assert(treply.ContainerIDs, gocheck.DeepEquals, []string{"theContainerID"})
+	lreply = SupervisorListReply{}
+	c.Assert(ih.List(SupervisorListArg{}, &lreply), gocheck.IsNil)
+	c.Assert(lreply.Containers, gocheck.HasLen, 1)
+	os.RemoveAll(saveDir)
+}
+
+func (s *RpcSuite) TestRedeploy(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	containers.Init("localhost", saveDir, 10, 2, 61000, 100, 1024, false)
+	ih := new(Supervisor)
+	var dreply SupervisorDeployReply
+	darg := SupervisorDeployArg{App: "theApp", Sha: "theSha", Env: "theEnv", ContainerID: "theContainerID",
+		Manifest: &Manifest{CPUShares: 1, MemoryLimit: 1}, Labels: map[string]string{"team": "search"}}
+	c.Assert(ih.Deploy(darg, &dreply), gocheck.IsNil)
+	// preview a manifest change, with the container's env override applied
+	var diffReply SupervisorDiffManifestReply
+	proposed := &Manifest{CPUShares: 1, MemoryLimit: 1, EnvOverrides: map[string]*ManifestOverride{
+		"theEnv": {MemoryLimit: 2}}}
+	diffArg := SupervisorDiffManifestArg{ContainerID: "theContainerID", Manifest: proposed}
+	c.Assert(ih.DiffManifest(diffArg, &diffReply), gocheck.IsNil)
+	c.Assert(diffReply.Changes, gocheck.DeepEquals, []ManifestChange{
+		{Path: "envOverrides", Kind: ChangeAdded, New: `{"theEnv":{"memoryLimit":2}}`},
+		{Path: "memoryLimit", Kind: ChangeChanged, Old: "1", New: "2"},
+	})
+	c.Assert(proposed.MemoryLimit, gocheck.Equals, uint(1))
+	var reply SupervisorRedeployReply
+	arg := SupervisorRedeployArg{ContainerID: "unknown", NewContainerID: "theNewContainerID", Sha: "theNewSha"}
+	c.Assert(ih.Redeploy(arg, &reply), gocheck.ErrorMatches, "Unknown Container\\.")
+	arg.ContainerID = "theContainerID"
+	reply = SupervisorRedeployReply{}
+	c.Assert(ih.Redeploy(arg, &reply), gocheck.IsNil)
+	c.Assert(reply.OldContainer.ID, gocheck.Equals, "theContainerID")
+	c.Assert(reply.Container.ID, gocheck.Equals, "theNewContainerID")
+	c.Assert(reply.Container.App, gocheck.Equals, "theApp")
+	c.Assert(reply.Container.Env, gocheck.Equals, "theEnv")
+	c.Assert(reply.Container.Sha, gocheck.Equals, "theNewSha")
+	c.Assert(reply.Container.Labels, gocheck.DeepEquals, map[string]string{"team": "search"})
+	c.Assert(reply.Container.PrimaryPort, gocheck.Not(gocheck.Equals), reply.OldContainer.PrimaryPort)
+	var lreply SupervisorListReply
+	c.Assert(ih.List(SupervisorListArg{}, &lreply), gocheck.IsNil)
+	c.Assert(lreply.Containers, gocheck.HasLen, 1)
+	c.Assert(lreply.Containers["theNewContainerID"], gocheck.NotNil)
+	c.Assert(reply.Container.Previous.Sha, gocheck.Equals, "theSha")
+	c.Assert(reply.Container.Previous.ContainerID, gocheck.Equals, "theContainerID")
+	// roll back to the sha that was replaced
+	var rreply SupervisorRollbackReply
+	rarg := SupervisorRollbackArg{ContainerID: "theContainerID", NewContainerID: "theRollbackID"}
+	c.Assert(ih.Rollback(rarg, &rreply), gocheck.ErrorMatches, "Unknown Container\\.")
+	rarg.ContainerID = "theNewContainerID"
+	c.Assert(ih.Rollback(rarg, &rreply), gocheck.IsNil)
+	c.Assert(rreply.Container.Sha, gocheck.Equals, "theSha")
+	c.Assert(rreply.Container.Previous.Sha, gocheck.Equals, "theNewSha")
+	lreply = SupervisorListReply{}
+	c.Assert(ih.List(SupervisorListArg{}, &lreply), gocheck.IsNil)
+	c.Assert(lreply.Containers, gocheck.HasLen, 1)
+	c.Assert(lreply.Containers["theRollbackID"], gocheck.NotNil)
+	os.RemoveAll(saveDir)
+}
+
+func (s *RpcSuite) TestRedeployRegistryAuth(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	containers.Init("localhost", saveDir, 10, 2, 61000, 100, 1024, false)
+	var deployedWith []*RegistryAuth
+	deployContainer = func(cont *containers.Container, host, app, sha, env string, labels map[string]string,
+		auth *RegistryAuth) error {
+		deployedWith = append(deployedWith, auth)
+		return cont.Deploy(host, app, sha, env, labels, auth)
+	}
+	defer func() { deployContainer = (*containers.Container).Deploy }()
+	ih := new(Supervisor)
+	auth := &RegistryAuth{Username: "theUser", Password: "thePassword"}
+	var dreply SupervisorDeployReply
+	darg := SupervisorDeployArg{App: "theApp", Sha: "theSha", Env: "theEnv", ContainerID: "theContainerID",
+		Manifest: &Manifest{CPUShares: 1, MemoryLimit: 1}, Registry: &Registry{Host: "private.example.com"},
+		RegistryAuth: auth}
+	c.Assert(ih.Deploy(darg, &dreply), gocheck.IsNil)
+	executor := &RedeployExecutor{SupervisorRedeployArg{ContainerID: "theContainerID",
+		NewContainerID: "theNewContainerID", Sha: "theNewSha", RegistryAuth: auth}, &SupervisorRedeployReply{}}
+	c.Assert(NewTask("Redeploy", executor).Run(), gocheck.IsNil)
+	c.Assert(executor.Request().(SupervisorRedeployArg).RegistryAuth, gocheck.IsNil)
+	var rreply SupervisorRollbackReply
+	rarg := SupervisorRollbackArg{ContainerID: "theNewContainerID", NewContainerID: "theRollbackID",
+		RegistryAuth: auth}
+	c.Assert(ih.Rollback(rarg, &rreply), gocheck.IsNil)
+	c.Assert(rreply.Container.Registry.Host, gocheck.Equals, "private.example.com")
+	c.Assert(deployedWith, gocheck.DeepEquals, []*RegistryAuth{auth, auth})
+	os.RemoveAll(saveDir)
+}
+
+func (s *RpcSuite) TestImages(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	ih := new(Supervisor)
+	var preply SupervisorPrefetchImageReply
+	c.Assert(ih.PrefetchImage(SupervisorPrefetchImageArg{App: "theApp"}, &preply), gocheck.ErrorMatches,
+		"Please specify a sha\\.")
+	preply = SupervisorPrefetchImageReply{}
+	parg := SupervisorPrefetchImageArg{App: "theApp", Sha: "theSha", Registry: &Registry{Host: "registry:5000"}}
+	c.Assert(ih.PrefetchImage(parg, &preply), gocheck.IsNil)
+	c.Assert(preply.Image, gocheck.Equals, "registry:5000/apps/theApp-theSha")
+	var greply SupervisorImageGCReply
+	c.Assert(ih.ImageGC(SupervisorImageGCArg{Retention: "forever"}, &greply), gocheck.ErrorMatches,
+		"Invalid retention: .+")
+	greply = SupervisorImageGCReply{}
+	c.Assert(ih.ImageGC(SupervisorImageGCArg{Retention: "24h", DryRun: true}, &greply), gocheck.IsNil)
+	c.Assert(greply.Status, gocheck.Equals, StatusOk)
+}
+
+func (s *RpcSuite) TestRBAC(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	containers.Init("localhost", saveDir, 2, 2, 61000, 100, 1024, false)
+	defer SetRBAC(nil)
+	rbacFile := saveDir + "/rbac.toml"
+	writeRBAC := func(content string) {
+		c.Assert(ioutil.WriteFile(rbacFile, []byte(content), 0644), gocheck.IsNil)
+	}
+	writeRBAC(`
+anonymous = ["monitoring"]
+
+[roles.monitoring]
+allow = ["List", "HealthCheck"]
+
+[roles.deploy]
+allow = ["*"]
+deny = ["AuthorizeSSH", "DeauthorizeSSH"]
+
+[identities.deployer]
+token_sha256 = "e0e2baa1858c9be1a3e01469a2bc9cf12342e00ddc40e3607234a58ba1891e92"
+roles = ["deploy"]
+`)
+	rbac, err := LoadRBAC(rbacFile)
+	c.Assert(err, gocheck.IsNil)
+	SetRBAC(rbac)
+	ih := new(Supervisor)
+	var listReply SupervisorListReply
+	c.Assert(ih.List(SupervisorListArg{}, &listReply), gocheck.IsNil)
+	var idleReply SupervisorIdleReply
+	c.Assert(ih.Idle(SupervisorIdleArg{}, &idleReply), gocheck.IsNil)
+	var deployReply SupervisorDeployReply
+	c.Assert(ih.Deploy(SupervisorDeployArg{}, &deployReply), gocheck.ErrorMatches, "anonymous may not call Deploy\\.")
+	deployer := SupervisorAuthArg{Token: "deploy-token"}
+	c.Assert(ih.Deploy(SupervisorDeployArg{SupervisorAuthArg: deployer}, &deployReply), gocheck.ErrorMatches,
+		"Please specify an app\\.")
+	var sshReply SupervisorAuthorizeSSHReply
+	c.Assert(ih.AuthorizeSSH(SupervisorAuthorizeSSHArg{SupervisorAuthArg: deployer}, &sshReply),
+		gocheck.ErrorMatches, "deployer may not call AuthorizeSSH\\.")
+	c.Assert(ih.List(SupervisorListArg{SupervisorAuthArg: SupervisorAuthArg{Token: "guess"}}, &listReply),
+		gocheck.ErrorMatches, "Unknown token\\.")
+	// the tracked request keeps no token once it is authorized
+	executor := &HealthCheckExecutor{SupervisorHealthCheckArg{SupervisorAuthArg: deployer},
+		&SupervisorHealthCheckReply{}}
+	c.Assert(NewTask("HealthCheck", executor).Run(), gocheck.IsNil)
+	c.Assert(executor.Request().(SupervisorHealthCheckArg).Token, gocheck.Equals, "")
+	// mistakes in the file are caught when it is loaded
+	for content, problem := range map[string]string{
+		"[roles.ops]\nallow = [\"Dploy\"]":                        "role ops: unknown method Dploy",
+		"anonymous = [\"ops\"]":                                   "anonymous: unknown role ops",
+		"[identities.ops]\ntoken_sha256 = \"deploy-token\"":       "ops: token_sha256 must be a hex sha256",
+		"[identities.anonymous]\nroles = []\ntoken_sha256 = \"\"": "anonymous is reserved for callers without a token",
+	} {
+		writeRBAC(content)
+		_, err := LoadRBAC(rbacFile)
+		c.Assert(err, gocheck.ErrorMatches, ".*rbac.toml: "+problem)
+	}
+	os.RemoveAll(saveDir)
+}
+
+func (s *RpcSuite) TestSignedManifest(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	containers.Init("localhost", saveDir, 2, 2, 61000, 100, 1024, false)
+	defer func() { ManifestKeys = nil }()
+	public, private, err := ed25519.GenerateKey(nil)
+	c.Assert(err, gocheck.IsNil)
+	der, err := x509.MarshalPKIXPublicKey(public)
+	c.Assert(err, gocheck.IsNil)
+	keyFile := saveDir + "/manifest_keys.pem"
+	c.Assert(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644),
+		gocheck.IsNil)
+	ManifestKeys, err = scrypto.LoadPublicKeys(keyFile)
+	c.Assert(err, gocheck.IsNil)
+	c.Assert(ManifestKeys, gocheck.HasLen, 1)
+	ih := new(Supervisor)
+	arg := SupervisorDeployArg{App: "theApp", Sha: "theSha", ContainerID: "theContainerID",
+		Manifest: &Manifest{CPUShares: 1, MemoryLimit: 1}}
+	var reply SupervisorDeployReply
+	c.Assert(ih.Deploy(arg, &reply), gocheck.ErrorMatches, "This supervisor only deploys signed manifests\\.")
+	data := []byte(`{"CPUShares": 1, "MemoryLimit": 1}`)
+	arg.Manifest = nil
+	arg.Signed = &SignedManifest{JSON: data, Signature: ed25519.Sign(private, []byte("something else"))}
+	c.Assert(ih.Deploy(arg, &reply), gocheck.ErrorMatches, "Invalid manifest signature: .*")
+	arg.Signed = &SignedManifest{JSON: data, Signature: ed25519.Sign(private, data)}
+	c.Assert(ih.Deploy(arg, &reply), gocheck.IsNil)
+	c.Assert(reply.Container.ID, gocheck.Equals, "theContainerID")
+	c.Assert(reply.Container.Manifest.MemoryLimit, gocheck.Equals, uint(1))
+	os.RemoveAll(saveDir)
+}
+
+func (s *RpcSuite) TestTracing(c *gocheck.C) {
+	os.Setenv("SUPERVISOR_PRETEND", "true")
+	saveDir := "save_test"
+	os.RemoveAll(saveDir)
+	containers.Init("localhost", saveDir, 2, 2, 61000, 100, 1024, false)
+	type span struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId"`
+		Name         string `json:"name"`
+		Status       struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"status"`
+	}
+	var lock sync.Mutex
+	spans := map[string]*span{} // name -> the last span of that name
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []*span `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		c.Check(json.NewDecoder(r.Body).Decode(&req), gocheck.IsNil)
+		lock.Lock()
+		defer lock.Unlock()
+		for _, resource := range req.ResourceSpans {
+			for _, scope := range resource.ScopeSpans {
+				for _, sp := range scope.Spans {
+					spans[sp.Name] = sp
+				}
+			}
+		}
+	}))
+	defer collector.Close()
+	trace.Endpoint = collector.URL
+	defer func() { trace.Endpoint = "" }()
+	trace.Init()
+
+	ih := new(Supervisor)
+	arg := SupervisorDeployArg{App: "theApp", Sha: "theSha", ContainerID: "theContainerID",
+		Manifest: &Manifest{CPUShares: 1, MemoryLimit: 1}}
+	c.Assert(ih.Deploy(arg, &SupervisorDeployReply{}), gocheck.IsNil)
+	trace.Flush()
+	lock.Lock()
+	deploy, reserve, save := spans["Supervisor.Deploy"], spans["reserve ports"], spans["save state"]
+	lock.Unlock()
+	c.Assert(deploy, gocheck.NotNil)
+	c.Assert(deploy.ParentSpanID, gocheck.Equals, "")
+	c.Assert(deploy.Status.Code, gocheck.Equals, 1)
+	c.Assert(reserve.TraceID, gocheck.Equals, deploy.TraceID)
+	c.Assert(reserve.ParentSpanID, gocheck.Equals, deploy.SpanID)
+	c.Assert(save.ParentSpanID, gocheck.Equals, deploy.SpanID)
+
+	// the container is taken now, so this deploy fails reserving
+	c.Assert(ih.Deploy(arg, &SupervisorDeployReply{}), gocheck.NotNil)
+	c.Assert(ih.Teardown(SupervisorTeardownArg{ContainerIDs: []string{"theContainerID"}},
+		&SupervisorTeardownReply{}), gocheck.IsNil)
+	c.Assert(ih.List(SupervisorListArg{}, &SupervisorListReply{}), gocheck.IsNil)
+	trace.Flush()
+	lock.Lock()
+	defer lock.Unlock()
+	c.Assert(spans["Supervisor.Deploy"].Status.Code, gocheck.Equals, 2)
+	c.Assert(spans["Supervisor.Deploy"].Status.Message, gocheck.Not(gocheck.Equals), "")
+	c.Assert(spans["teardown container"].ParentSpanID, gocheck.Equals, spans["Supervisor.Teardown"].SpanID)
+	// saving the teardown belongs to the teardown's trace
+	c.Assert(spans["save state"].ParentSpanID, gocheck.Equals, spans["teardown container"].SpanID)
+	c.Assert(spans["list containers"].ParentSpanID, gocheck.Equals, spans["Supervisor.List"].SpanID)
+	os.RemoveAll(saveDir)
+}
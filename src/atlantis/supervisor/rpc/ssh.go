@@ -17,6 +17,7 @@ import (
 	. "atlantis/supervisor/rpc/types"
 	"errors"
 	"fmt"
+	"time"
 )
 
 type AuthorizeSSHExecutor struct {
@@ -37,7 +38,7 @@ func (e *AuthorizeSSHExecutor) Description() string {
 }
 
 func (e *AuthorizeSSHExecutor) Authorize() error {
-	return nil
+	return authorize("AuthorizeSSH", &e.arg.SupervisorAuthArg)
 }
 
 func (e *AuthorizeSSHExecutor) Execute(t *Task) error {
@@ -55,12 +56,29 @@ func (e *AuthorizeSSHExecutor) Execute(t *Task) error {
 		e.reply.Status = StatusError
 		return errors.New("Unknown Container.")
 	}
-	if err := containers.AuthorizeSSHUser(cont, e.arg.User, e.arg.PublicKey); err != nil {
+	record := &SSHAccessRecord{ContainerID: cont.ID, Action: SSHAccessAuthorized, User: e.arg.User,
+		Fingerprint: containers.SSHKeyFingerprint(e.arg.PublicKey), Requester: e.arg.Requester}
+	if containers.SSHCAEnabled() {
+		cert, err := containers.IssueSSHCert(cont, e.arg.User, e.arg.PublicKey)
+		if err != nil {
+			e.reply.Status = StatusError
+			return err
+		}
+		t.Log("[RPC][AuthorizeSSH] issued certificate %d valid until %s", cert.Serial,
+			cert.ValidBefore.Format(time.RFC3339))
+		e.reply.Certificate = cert
+		record.ValidBefore = cert.ValidBefore
+	} else if err := containers.AuthorizeSSHUser(cont, e.arg.User, e.arg.PublicKey); err != nil {
 		e.reply.Status = StatusError
 		return err
 	}
 	t.Log("[RPC][AuthorizeSSH] authorized %d", cont.SSHPort)
 	e.reply.Port = cont.SSHPort
+	if err := containers.AuditSSHAccess(record); err != nil {
+		// the access stands, but security can't see it so the caller has to know
+		e.reply.Status = StatusError
+		return fmt.Errorf("authorized but could not record it in the audit trail: %v", err)
+	}
 	e.reply.Status = StatusOk
 	return nil
 }
@@ -87,7 +105,7 @@ func (e *DeauthorizeSSHExecutor) Description() string {
 }
 
 func (e *DeauthorizeSSHExecutor) Authorize() error {
-	return nil
+	return authorize("DeauthorizeSSH", &e.arg.SupervisorAuthArg)
 }
 
 func (e *DeauthorizeSSHExecutor) Execute(t *Task) error {
@@ -102,10 +120,23 @@ func (e *DeauthorizeSSHExecutor) Execute(t *Task) error {
 		e.reply.Status = StatusError
 		return errors.New("Unknown Container.")
 	}
-	if err := containers.DeauthorizeSSHUser(cont, e.arg.User); err != nil {
+	if containers.SSHCAEnabled() {
+		revoked, err := containers.RevokeSSHCerts(cont, e.arg.User)
+		if err != nil {
+			e.reply.Status = StatusError
+			return err
+		}
+		t.Log("[RPC][DeauthorizeSSH] revoked %d certificates", revoked)
+	} else if err := containers.DeauthorizeSSHUser(cont, e.arg.User); err != nil {
 		e.reply.Status = StatusError
 		return err
 	}
+	record := &SSHAccessRecord{ContainerID: cont.ID, Action: SSHAccessDeauthorized, User: e.arg.User,
+		Requester: e.arg.Requester}
+	if err := containers.AuditSSHAccess(record); err != nil {
+		e.reply.Status = StatusError
+		return fmt.Errorf("deauthorized but could not record it in the audit trail: %v", err)
+	}
 	e.reply.Status = StatusOk
 	return nil
 }
@@ -113,3 +144,38 @@ func (e *DeauthorizeSSHExecutor) Execute(t *Task) error {
 func (ih *Supervisor) DeauthorizeSSH(arg SupervisorDeauthorizeSSHArg, reply *SupervisorDeauthorizeSSHReply) error {
 	return NewTask("DeauthorizeSSH", &DeauthorizeSSHExecutor{arg, reply}).Run()
 }
+
+type SSHAuditExecutor struct {
+	arg   SupervisorSSHAuditArg
+	reply *SupervisorSSHAuditReply
+}
+
+func (e *SSHAuditExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *SSHAuditExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *SSHAuditExecutor) Description() string {
+	return fmt.Sprintf("%s @ %s from %s until %s", e.arg.User, e.arg.ContainerID, e.arg.Since, e.arg.Until)
+}
+
+func (e *SSHAuditExecutor) Authorize() error {
+	return authorize("SSHAudit", &e.arg.SupervisorAuthArg)
+}
+
+func (e *SSHAuditExecutor) Execute(t *Task) error {
+	if !e.arg.Until.IsZero() && e.arg.Until.Before(e.arg.Since) {
+		e.reply.Status = StatusError
+		return errors.New("Until is before since.")
+	}
+	e.reply.Records, e.reply.Grants = containers.SSHAudit(e.arg.ContainerID, e.arg.User, e.arg.Since, e.arg.Until)
+	e.reply.Status = StatusOk
+	return nil
+}
+
+func (ih *Supervisor) SSHAudit(arg SupervisorSSHAuditArg, reply *SupervisorSSHAuditReply) error {
+	return NewTask("SSHAudit", &SSHAuditExecutor{arg, reply}).Run()
+}
@@ -0,0 +1,196 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package rpc
+
+import (
+	. "atlantis/common"
+	"atlantis/supervisor/containers"
+	. "atlantis/supervisor/rpc/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+type ExportStateExecutor struct {
+	arg   SupervisorExportStateArg
+	reply *SupervisorExportStateReply
+}
+
+func (e *ExportStateExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *ExportStateExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *ExportStateExecutor) Description() string {
+	return "ExportState"
+}
+
+func (e *ExportStateExecutor) Authorize() error {
+	return authorize("ExportState", &e.arg.SupervisorAuthArg)
+}
+
+func (e *ExportStateExecutor) Execute(t *Task) (err error) {
+	conts, _ := containers.List()
+	if e.reply.State, err = json.MarshalIndent(conts, "", "  "); err != nil {
+		e.reply.Status = StatusError
+		return err
+	}
+	t.Log("-> exported %d containers", len(conts))
+	e.reply.Status = StatusOk
+	return nil
+}
+
+func (ih *Supervisor) ExportState(arg SupervisorExportStateArg, reply *SupervisorExportStateReply) error {
+	return NewTask("ExportState", &ExportStateExecutor{arg, reply}).Run()
+}
+
+type ImportStateExecutor struct {
+	arg   SupervisorImportStateArg
+	reply *SupervisorImportStateReply
+}
+
+func (e *ImportStateExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *ImportStateExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *ImportStateExecutor) Description() string {
+	return fmt.Sprintf("%d bytes", len(e.arg.State))
+}
+
+func (e *ImportStateExecutor) Authorize() error {
+	return authorize("ImportState", &e.arg.SupervisorAuthArg)
+}
+
+func (e *ImportStateExecutor) Execute(t *Task) (err error) {
+	if len(e.arg.State) == 0 {
+		return errors.New("Please specify the state to import.")
+	}
+	var records map[string]*Container
+	if err := json.Unmarshal(e.arg.State, &records); err != nil {
+		return errors.New("Invalid state: " + err.Error())
+	}
+	if len(records) == 0 {
+		return errors.New("Please specify at least one container.")
+	}
+	for id, record := range records {
+		if record == nil {
+			continue
+		}
+		if record.Manifest == nil {
+			return errors.New("Invalid state: " + id + " has no manifest")
+		}
+		if err := validateManifest(record.Manifest); err != nil {
+			return fmt.Errorf("Invalid state: %s: %v", id, err)
+		}
+		if err := ValidateLabels(record.Labels); err != nil {
+			return fmt.Errorf("Invalid state: %s: invalid labels: %v", id, err)
+		}
+	}
+	e.reply.ContainerIDs, err = containers.ImportState(records)
+	if err != nil {
+		e.reply.Status = StatusError
+		return errors.New("Invalid state: " + err.Error())
+	}
+	for _, id := range e.reply.ContainerIDs {
+		if records[id] == nil {
+			t.Log("-> dropped %s", id)
+		} else {
+			t.Log("-> replaced %s", id)
+		}
+	}
+	e.reply.Status = StatusOk
+	return nil
+}
+
+func (ih *Supervisor) ImportState(arg SupervisorImportStateArg, reply *SupervisorImportStateReply) error {
+	return NewTask("ImportState", &ImportStateExecutor{arg, reply}).Run()
+}
+
+type ListStateSnapshotsExecutor struct {
+	arg   SupervisorListStateSnapshotsArg
+	reply *SupervisorListStateSnapshotsReply
+}
+
+func (e *ListStateSnapshotsExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *ListStateSnapshotsExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *ListStateSnapshotsExecutor) Description() string {
+	return "ListStateSnapshots"
+}
+
+func (e *ListStateSnapshotsExecutor) Authorize() error {
+	return authorize("ListStateSnapshots", &e.arg.SupervisorAuthArg)
+}
+
+func (e *ListStateSnapshotsExecutor) Execute(t *Task) (err error) {
+	if e.reply.Snapshots, err = containers.StateSnapshots(); err != nil {
+		e.reply.Status = StatusError
+		return err
+	}
+	e.reply.Status = StatusOk
+	return nil
+}
+
+func (ih *Supervisor) ListStateSnapshots(arg SupervisorListStateSnapshotsArg,
+	reply *SupervisorListStateSnapshotsReply) error {
+	return NewTask("ListStateSnapshots", &ListStateSnapshotsExecutor{arg, reply}).Run()
+}
+
+type RollbackStateExecutor struct {
+	arg   SupervisorRollbackStateArg
+	reply *SupervisorRollbackStateReply
+}
+
+func (e *RollbackStateExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *RollbackStateExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *RollbackStateExecutor) Description() string {
+	return e.arg.SnapshotID
+}
+
+func (e *RollbackStateExecutor) Authorize() error {
+	return authorize("RollbackState", &e.arg.SupervisorAuthArg)
+}
+
+func (e *RollbackStateExecutor) Execute(t *Task) (err error) {
+	if e.arg.SnapshotID == "" {
+		return errors.New("Please specify a snapshot id.")
+	}
+	if e.reply.ContainerIDs, err = containers.RollbackState(e.arg.SnapshotID); err != nil {
+		e.reply.Status = StatusError
+		return err
+	}
+	t.Log("-> rolled back to %s with %d containers", e.arg.SnapshotID, len(e.reply.ContainerIDs))
+	e.reply.Status = StatusOk
+	return nil
+}
+
+func (ih *Supervisor) RollbackState(arg SupervisorRollbackStateArg, reply *SupervisorRollbackStateReply) error {
+	return NewTask("RollbackState", &RollbackStateExecutor{arg, reply}).Run()
+}
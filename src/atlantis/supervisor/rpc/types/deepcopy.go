@@ -0,0 +1,2138 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+// Code generated by deepcopy_gen.go; DO NOT EDIT.
+
+package types
+
+// Returns a copy of the AppDep that shares no memory with it, nil if it is nil
+func (in *AppDep) DeepCopy() *AppDep {
+	if in == nil {
+		return nil
+	}
+	out := new(AppDep)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the AppDep that shares no memory with it
+func (in *AppDep) DeepCopyInto(out *AppDep) {
+	*out = *in
+	if in.SecurityGroup != nil {
+		out.SecurityGroup = make(map[string][]uint16, len(in.SecurityGroup))
+		for key0, val0 := range in.SecurityGroup {
+			var cp0 []uint16
+			if val0 != nil {
+				cp0 = make([]uint16, len(val0))
+				copy(cp0, val0)
+			}
+			out.SecurityGroup[key0] = cp0
+		}
+	}
+	if in.DataMap != nil {
+		out.DataMap = make(map[string]interface{}, len(in.DataMap))
+		for key0, val0 := range in.DataMap {
+			var cp0 interface{}
+			cp0 = deepCopyValue(val0)
+			out.DataMap[key0] = cp0
+		}
+	}
+}
+
+// Returns a copy of the CmkDep that shares no memory with it, nil if it is nil
+func (in *CmkDep) DeepCopy() *CmkDep {
+	if in == nil {
+		return nil
+	}
+	out := new(CmkDep)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the CmkDep that shares no memory with it
+func (in *CmkDep) DeepCopyInto(out *CmkDep) {
+	*out = *in
+}
+
+// Returns a copy of the Container that shares no memory with it, nil if it is nil
+func (in *Container) DeepCopy() *Container {
+	if in == nil {
+		return nil
+	}
+	out := new(Container)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the Container that shares no memory with it
+func (in *Container) DeepCopyInto(out *Container) {
+	*out = *in
+	if in.SecondaryPorts != nil {
+		out.SecondaryPorts = make([]uint16, len(in.SecondaryPorts))
+		copy(out.SecondaryPorts, in.SecondaryPorts)
+	}
+	if in.HostKeys != nil {
+		out.HostKeys = make([]string, len(in.HostKeys))
+		copy(out.HostKeys, in.HostKeys)
+	}
+	out.Manifest = in.Manifest.DeepCopy()
+	out.Readiness = in.Readiness.DeepCopy()
+	out.Liveness = in.Liveness.DeepCopy()
+	if in.Sidecars != nil {
+		out.Sidecars = make([]*SidecarContainer, len(in.Sidecars))
+		for i0 := range in.Sidecars {
+			out.Sidecars[i0] = in.Sidecars[i0].DeepCopy()
+		}
+	}
+	if in.NamedPorts != nil {
+		out.NamedPorts = make(map[string][]uint16, len(in.NamedPorts))
+		for key0, val0 := range in.NamedPorts {
+			var cp0 []uint16
+			if val0 != nil {
+				cp0 = make([]uint16, len(val0))
+				copy(cp0, val0)
+			}
+			out.NamedPorts[key0] = cp0
+		}
+	}
+	if in.Labels != nil {
+		out.Labels = make(map[string]string, len(in.Labels))
+		for key0, val0 := range in.Labels {
+			out.Labels[key0] = val0
+		}
+	}
+	if in.GPUs != nil {
+		out.GPUs = make([]uint, len(in.GPUs))
+		copy(out.GPUs, in.GPUs)
+	}
+	if in.CPUSet != nil {
+		out.CPUSet = make([]uint, len(in.CPUSet))
+		copy(out.CPUSet, in.CPUSet)
+	}
+	if in.BindAddrs != nil {
+		out.BindAddrs = make([]string, len(in.BindAddrs))
+		copy(out.BindAddrs, in.BindAddrs)
+	}
+	if in.SSHAddrs != nil {
+		out.SSHAddrs = make([]string, len(in.SSHAddrs))
+		copy(out.SSHAddrs, in.SSHAddrs)
+	}
+	out.Registry = in.Registry.DeepCopy()
+	out.Previous = in.Previous.DeepCopy()
+}
+
+// Returns a copy of the ContainerEvent that shares no memory with it, nil if it is nil
+func (in *ContainerEvent) DeepCopy() *ContainerEvent {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerEvent)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the ContainerEvent that shares no memory with it
+func (in *ContainerEvent) DeepCopyInto(out *ContainerEvent) {
+	*out = *in
+}
+
+// Returns a copy of the DatabaseDep that shares no memory with it, nil if it is nil
+func (in *DatabaseDep) DeepCopy() *DatabaseDep {
+	if in == nil {
+		return nil
+	}
+	out := new(DatabaseDep)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the DatabaseDep that shares no memory with it
+func (in *DatabaseDep) DeepCopyInto(out *DatabaseDep) {
+	*out = *in
+}
+
+// Returns a copy of the DepsType that shares no memory with it
+func (in DepsType) DeepCopy() DepsType {
+	var out DepsType
+	if in != nil {
+		out = make(map[string]*AppDep, len(in))
+		for key0, val0 := range in {
+			var cp0 *AppDep
+			cp0 = val0.DeepCopy()
+			out[key0] = cp0
+		}
+	}
+	return out
+}
+
+// Returns a copy of the EgressPolicy that shares no memory with it, nil if it is nil
+func (in *EgressPolicy) DeepCopy() *EgressPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the EgressPolicy that shares no memory with it
+func (in *EgressPolicy) DeepCopyInto(out *EgressPolicy) {
+	*out = *in
+	if in.Allow != nil {
+		out.Allow = make([]string, len(in.Allow))
+		copy(out.Allow, in.Allow)
+	}
+}
+
+// Returns a copy of the InventoryStatus that shares no memory with it, nil if it is nil
+func (in *InventoryStatus) DeepCopy() *InventoryStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(InventoryStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the InventoryStatus that shares no memory with it
+func (in *InventoryStatus) DeepCopyInto(out *InventoryStatus) {
+	*out = *in
+	if in.Command != nil {
+		out.Command = make([]string, len(in.Command))
+		copy(out.Command, in.Command)
+	}
+}
+
+// Returns a copy of the LogConfig that shares no memory with it, nil if it is nil
+func (in *LogConfig) DeepCopy() *LogConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(LogConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the LogConfig that shares no memory with it
+func (in *LogConfig) DeepCopyInto(out *LogConfig) {
+	*out = *in
+	if in.Options != nil {
+		out.Options = make(map[string]string, len(in.Options))
+		for key0, val0 := range in.Options {
+			out.Options[key0] = val0
+		}
+	}
+}
+
+// Returns a copy of the LogLine that shares no memory with it, nil if it is nil
+func (in *LogLine) DeepCopy() *LogLine {
+	if in == nil {
+		return nil
+	}
+	out := new(LogLine)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the LogLine that shares no memory with it
+func (in *LogLine) DeepCopyInto(out *LogLine) {
+	*out = *in
+}
+
+// Returns a copy of the LogShipping that shares no memory with it, nil if it is nil
+func (in *LogShipping) DeepCopy() *LogShipping {
+	if in == nil {
+		return nil
+	}
+	out := new(LogShipping)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the LogShipping that shares no memory with it
+func (in *LogShipping) DeepCopyInto(out *LogShipping) {
+	*out = *in
+	if in.Streams != nil {
+		out.Streams = make([]string, len(in.Streams))
+		copy(out.Streams, in.Streams)
+	}
+	if in.Fields != nil {
+		out.Fields = make(map[string]string, len(in.Fields))
+		for key0, val0 := range in.Fields {
+			out.Fields[key0] = val0
+		}
+	}
+}
+
+// Returns a copy of the MACProfile that shares no memory with it, nil if it is nil
+func (in *MACProfile) DeepCopy() *MACProfile {
+	if in == nil {
+		return nil
+	}
+	out := new(MACProfile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the MACProfile that shares no memory with it
+func (in *MACProfile) DeepCopyInto(out *MACProfile) {
+	*out = *in
+}
+
+// Returns a copy of the Manifest that shares no memory with it, nil if it is nil
+func (in *Manifest) DeepCopy() *Manifest {
+	if in == nil {
+		return nil
+	}
+	out := new(Manifest)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the Manifest that shares no memory with it
+func (in *Manifest) DeepCopyInto(out *Manifest) {
+	*out = *in
+	if in.RunCommands != nil {
+		out.RunCommands = make([]string, len(in.RunCommands))
+		copy(out.RunCommands, in.RunCommands)
+	}
+	if in.RunArgv != nil {
+		out.RunArgv = make([][]string, len(in.RunArgv))
+		for i0 := range in.RunArgv {
+			if in.RunArgv[i0] != nil {
+				out.RunArgv[i0] = make([]string, len(in.RunArgv[i0]))
+				copy(out.RunArgv[i0], in.RunArgv[i0])
+			}
+		}
+	}
+	if in.Env != nil {
+		out.Env = make(map[string]string, len(in.Env))
+		for key0, val0 := range in.Env {
+			out.Env[key0] = val0
+		}
+	}
+	out.Deps = in.Deps.DeepCopy()
+	if in.Checks != nil {
+		out.Checks = make([]ManifestCheck, len(in.Checks))
+		for i0 := range in.Checks {
+			in.Checks[i0].DeepCopyInto(&out.Checks[i0])
+		}
+	}
+	out.Readiness = in.Readiness.DeepCopy()
+	out.Liveness = in.Liveness.DeepCopy()
+	out.RestartPolicy = in.RestartPolicy.DeepCopy()
+	if in.Ulimits != nil {
+		out.Ulimits = make([]Ulimit, len(in.Ulimits))
+		copy(out.Ulimits, in.Ulimits)
+	}
+	if in.Sysctls != nil {
+		out.Sysctls = make(map[string]string, len(in.Sysctls))
+		for key0, val0 := range in.Sysctls {
+			out.Sysctls[key0] = val0
+		}
+	}
+	if in.Volumes != nil {
+		out.Volumes = make([]Volume, len(in.Volumes))
+		copy(out.Volumes, in.Volumes)
+	}
+	if in.Sidecars != nil {
+		out.Sidecars = make([]Sidecar, len(in.Sidecars))
+		for i0 := range in.Sidecars {
+			in.Sidecars[i0].DeepCopyInto(&out.Sidecars[i0])
+		}
+	}
+	if in.Ports != nil {
+		out.Ports = make([]PortSpec, len(in.Ports))
+		copy(out.Ports, in.Ports)
+	}
+	out.TLS = in.TLS.DeepCopy()
+	out.Egress = in.Egress.DeepCopy()
+	out.Network = in.Network.DeepCopy()
+	out.Logging = in.Logging.DeepCopy()
+	out.LogShipping = in.LogShipping.DeepCopy()
+	if in.Secrets != nil {
+		out.Secrets = make([]Secret, len(in.Secrets))
+		copy(out.Secrets, in.Secrets)
+	}
+	out.MACProfile = in.MACProfile.DeepCopy()
+	if in.CapAdd != nil {
+		out.CapAdd = make([]string, len(in.CapAdd))
+		copy(out.CapAdd, in.CapAdd)
+	}
+	if in.CapDrop != nil {
+		out.CapDrop = make([]string, len(in.CapDrop))
+		copy(out.CapDrop, in.CapDrop)
+	}
+	if in.Tmpfs != nil {
+		out.Tmpfs = make([]TmpfsMount, len(in.Tmpfs))
+		copy(out.Tmpfs, in.Tmpfs)
+	}
+	if in.EnvOverrides != nil {
+		out.EnvOverrides = make(map[string]*ManifestOverride, len(in.EnvOverrides))
+		for key0, val0 := range in.EnvOverrides {
+			var cp0 *ManifestOverride
+			cp0 = val0.DeepCopy()
+			out.EnvOverrides[key0] = cp0
+		}
+	}
+}
+
+// Returns a copy of the ManifestChange that shares no memory with it, nil if it is nil
+func (in *ManifestChange) DeepCopy() *ManifestChange {
+	if in == nil {
+		return nil
+	}
+	out := new(ManifestChange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the ManifestChange that shares no memory with it
+func (in *ManifestChange) DeepCopyInto(out *ManifestChange) {
+	*out = *in
+}
+
+// Returns a copy of the ManifestCheck that shares no memory with it, nil if it is nil
+func (in *ManifestCheck) DeepCopy() *ManifestCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(ManifestCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the ManifestCheck that shares no memory with it
+func (in *ManifestCheck) DeepCopyInto(out *ManifestCheck) {
+	*out = *in
+	if in.Warning != nil {
+		out.Warning = new(float64)
+		*out.Warning = *in.Warning
+	}
+	if in.Critical != nil {
+		out.Critical = new(float64)
+		*out.Critical = *in.Critical
+	}
+}
+
+// Returns a copy of the ManifestOverride that shares no memory with it, nil if it is nil
+func (in *ManifestOverride) DeepCopy() *ManifestOverride {
+	if in == nil {
+		return nil
+	}
+	out := new(ManifestOverride)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the ManifestOverride that shares no memory with it
+func (in *ManifestOverride) DeepCopyInto(out *ManifestOverride) {
+	*out = *in
+	if in.Env != nil {
+		out.Env = make(map[string]string, len(in.Env))
+		for key0, val0 := range in.Env {
+			out.Env[key0] = val0
+		}
+	}
+	if in.RunCommands != nil {
+		out.RunCommands = make([]string, len(in.RunCommands))
+		copy(out.RunCommands, in.RunCommands)
+	}
+}
+
+// Returns a copy of the NetworkTuning that shares no memory with it, nil if it is nil
+func (in *NetworkTuning) DeepCopy() *NetworkTuning {
+	if in == nil {
+		return nil
+	}
+	out := new(NetworkTuning)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the NetworkTuning that shares no memory with it
+func (in *NetworkTuning) DeepCopyInto(out *NetworkTuning) {
+	*out = *in
+}
+
+// Returns a copy of the PortDep that shares no memory with it, nil if it is nil
+func (in *PortDep) DeepCopy() *PortDep {
+	if in == nil {
+		return nil
+	}
+	out := new(PortDep)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the PortDep that shares no memory with it
+func (in *PortDep) DeepCopyInto(out *PortDep) {
+	*out = *in
+}
+
+// Returns a copy of the PortPools that shares no memory with it, nil if it is nil
+func (in *PortPools) DeepCopy() *PortPools {
+	if in == nil {
+		return nil
+	}
+	out := new(PortPools)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the PortPools that shares no memory with it
+func (in *PortPools) DeepCopyInto(out *PortPools) {
+	*out = *in
+	if in.Excluded != nil {
+		out.Excluded = make([]uint16, len(in.Excluded))
+		copy(out.Excluded, in.Excluded)
+	}
+}
+
+// Returns a copy of the PortRange that shares no memory with it, nil if it is nil
+func (in *PortRange) DeepCopy() *PortRange {
+	if in == nil {
+		return nil
+	}
+	out := new(PortRange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the PortRange that shares no memory with it
+func (in *PortRange) DeepCopyInto(out *PortRange) {
+	*out = *in
+}
+
+// Returns a copy of the PortSpec that shares no memory with it, nil if it is nil
+func (in *PortSpec) DeepCopy() *PortSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PortSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the PortSpec that shares no memory with it
+func (in *PortSpec) DeepCopyInto(out *PortSpec) {
+	*out = *in
+}
+
+// Returns a copy of the Probe that shares no memory with it, nil if it is nil
+func (in *Probe) DeepCopy() *Probe {
+	if in == nil {
+		return nil
+	}
+	out := new(Probe)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the Probe that shares no memory with it
+func (in *Probe) DeepCopyInto(out *Probe) {
+	*out = *in
+}
+
+// Returns a copy of the ProbeStatus that shares no memory with it, nil if it is nil
+func (in *ProbeStatus) DeepCopy() *ProbeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ProbeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the ProbeStatus that shares no memory with it
+func (in *ProbeStatus) DeepCopyInto(out *ProbeStatus) {
+	*out = *in
+}
+
+// Returns a copy of the Registry that shares no memory with it, nil if it is nil
+func (in *Registry) DeepCopy() *Registry {
+	if in == nil {
+		return nil
+	}
+	out := new(Registry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the Registry that shares no memory with it
+func (in *Registry) DeepCopyInto(out *Registry) {
+	*out = *in
+}
+
+// Returns a copy of the RegistryAuth that shares no memory with it, nil if it is nil
+func (in *RegistryAuth) DeepCopy() *RegistryAuth {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistryAuth)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the RegistryAuth that shares no memory with it
+func (in *RegistryAuth) DeepCopyInto(out *RegistryAuth) {
+	*out = *in
+}
+
+// Returns a copy of the Release that shares no memory with it, nil if it is nil
+func (in *Release) DeepCopy() *Release {
+	if in == nil {
+		return nil
+	}
+	out := new(Release)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the Release that shares no memory with it
+func (in *Release) DeepCopyInto(out *Release) {
+	*out = *in
+	out.Manifest = in.Manifest.DeepCopy()
+	if in.Labels != nil {
+		out.Labels = make(map[string]string, len(in.Labels))
+		for key0, val0 := range in.Labels {
+			out.Labels[key0] = val0
+		}
+	}
+	out.Registry = in.Registry.DeepCopy()
+}
+
+// Returns a copy of the ResourceStats that shares no memory with it, nil if it is nil
+func (in *ResourceStats) DeepCopy() *ResourceStats {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceStats)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the ResourceStats that shares no memory with it
+func (in *ResourceStats) DeepCopyInto(out *ResourceStats) {
+	*out = *in
+}
+
+// Returns a copy of the ResourceUsage that shares no memory with it, nil if it is nil
+func (in *ResourceUsage) DeepCopy() *ResourceUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the ResourceUsage that shares no memory with it
+func (in *ResourceUsage) DeepCopyInto(out *ResourceUsage) {
+	*out = *in
+}
+
+// Returns a copy of the RestartPolicy that shares no memory with it, nil if it is nil
+func (in *RestartPolicy) DeepCopy() *RestartPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(RestartPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the RestartPolicy that shares no memory with it
+func (in *RestartPolicy) DeepCopyInto(out *RestartPolicy) {
+	*out = *in
+}
+
+// Returns a copy of the SSHAccessGrant that shares no memory with it, nil if it is nil
+func (in *SSHAccessGrant) DeepCopy() *SSHAccessGrant {
+	if in == nil {
+		return nil
+	}
+	out := new(SSHAccessGrant)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SSHAccessGrant that shares no memory with it
+func (in *SSHAccessGrant) DeepCopyInto(out *SSHAccessGrant) {
+	*out = *in
+}
+
+// Returns a copy of the SSHAccessRecord that shares no memory with it, nil if it is nil
+func (in *SSHAccessRecord) DeepCopy() *SSHAccessRecord {
+	if in == nil {
+		return nil
+	}
+	out := new(SSHAccessRecord)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SSHAccessRecord that shares no memory with it
+func (in *SSHAccessRecord) DeepCopyInto(out *SSHAccessRecord) {
+	*out = *in
+}
+
+// Returns a copy of the SSHCert that shares no memory with it, nil if it is nil
+func (in *SSHCert) DeepCopy() *SSHCert {
+	if in == nil {
+		return nil
+	}
+	out := new(SSHCert)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SSHCert that shares no memory with it
+func (in *SSHCert) DeepCopyInto(out *SSHCert) {
+	*out = *in
+}
+
+// Returns a copy of the Secret that shares no memory with it, nil if it is nil
+func (in *Secret) DeepCopy() *Secret {
+	if in == nil {
+		return nil
+	}
+	out := new(Secret)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the Secret that shares no memory with it
+func (in *Secret) DeepCopyInto(out *Secret) {
+	*out = *in
+}
+
+// Returns a copy of the Sidecar that shares no memory with it, nil if it is nil
+func (in *Sidecar) DeepCopy() *Sidecar {
+	if in == nil {
+		return nil
+	}
+	out := new(Sidecar)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the Sidecar that shares no memory with it
+func (in *Sidecar) DeepCopyInto(out *Sidecar) {
+	*out = *in
+	if in.Command != nil {
+		out.Command = make([]string, len(in.Command))
+		copy(out.Command, in.Command)
+	}
+	if in.Env != nil {
+		out.Env = make(map[string]string, len(in.Env))
+		for key0, val0 := range in.Env {
+			out.Env[key0] = val0
+		}
+	}
+	out.TLS = in.TLS.DeepCopy()
+}
+
+// Returns a copy of the SidecarContainer that shares no memory with it, nil if it is nil
+func (in *SidecarContainer) DeepCopy() *SidecarContainer {
+	if in == nil {
+		return nil
+	}
+	out := new(SidecarContainer)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SidecarContainer that shares no memory with it
+func (in *SidecarContainer) DeepCopyInto(out *SidecarContainer) {
+	*out = *in
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// Returns a copy of the SignedManifest that shares no memory with it, nil if it is nil
+func (in *SignedManifest) DeepCopy() *SignedManifest {
+	if in == nil {
+		return nil
+	}
+	out := new(SignedManifest)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SignedManifest that shares no memory with it
+func (in *SignedManifest) DeepCopyInto(out *SignedManifest) {
+	*out = *in
+	if in.JSON != nil {
+		out.JSON = make([]byte, len(in.JSON))
+		copy(out.JSON, in.JSON)
+	}
+	if in.Signature != nil {
+		out.Signature = make([]byte, len(in.Signature))
+		copy(out.Signature, in.Signature)
+	}
+}
+
+// Returns a copy of the StateBackup that shares no memory with it, nil if it is nil
+func (in *StateBackup) DeepCopy() *StateBackup {
+	if in == nil {
+		return nil
+	}
+	out := new(StateBackup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the StateBackup that shares no memory with it
+func (in *StateBackup) DeepCopyInto(out *StateBackup) {
+	*out = *in
+	if in.PinnableCPUs != nil {
+		out.PinnableCPUs = make([]uint, len(in.PinnableCPUs))
+		copy(out.PinnableCPUs, in.PinnableCPUs)
+	}
+	if in.Containers != nil {
+		out.Containers = make(map[string]*Container, len(in.Containers))
+		for key0, val0 := range in.Containers {
+			var cp0 *Container
+			cp0 = val0.DeepCopy()
+			out.Containers[key0] = cp0
+		}
+	}
+	if in.FreePorts != nil {
+		out.FreePorts = make([]uint16, len(in.FreePorts))
+		copy(out.FreePorts, in.FreePorts)
+	}
+}
+
+// Returns a copy of the StateSnapshot that shares no memory with it, nil if it is nil
+func (in *StateSnapshot) DeepCopy() *StateSnapshot {
+	if in == nil {
+		return nil
+	}
+	out := new(StateSnapshot)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the StateSnapshot that shares no memory with it
+func (in *StateSnapshot) DeepCopyInto(out *StateSnapshot) {
+	*out = *in
+}
+
+// Returns a copy of the StatsSample that shares no memory with it, nil if it is nil
+func (in *StatsSample) DeepCopy() *StatsSample {
+	if in == nil {
+		return nil
+	}
+	out := new(StatsSample)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the StatsSample that shares no memory with it
+func (in *StatsSample) DeepCopyInto(out *StatsSample) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorAuthArg that shares no memory with it, nil if it is nil
+func (in *SupervisorAuthArg) DeepCopy() *SupervisorAuthArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorAuthArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorAuthArg that shares no memory with it
+func (in *SupervisorAuthArg) DeepCopyInto(out *SupervisorAuthArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorAuthorizeSSHArg that shares no memory with it, nil if it is nil
+func (in *SupervisorAuthorizeSSHArg) DeepCopy() *SupervisorAuthorizeSSHArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorAuthorizeSSHArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorAuthorizeSSHArg that shares no memory with it
+func (in *SupervisorAuthorizeSSHArg) DeepCopyInto(out *SupervisorAuthorizeSSHArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorAuthorizeSSHReply that shares no memory with it, nil if it is nil
+func (in *SupervisorAuthorizeSSHReply) DeepCopy() *SupervisorAuthorizeSSHReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorAuthorizeSSHReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorAuthorizeSSHReply that shares no memory with it
+func (in *SupervisorAuthorizeSSHReply) DeepCopyInto(out *SupervisorAuthorizeSSHReply) {
+	*out = *in
+	out.Certificate = in.Certificate.DeepCopy()
+}
+
+// Returns a copy of the SupervisorBackupArg that shares no memory with it, nil if it is nil
+func (in *SupervisorBackupArg) DeepCopy() *SupervisorBackupArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorBackupArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorBackupArg that shares no memory with it
+func (in *SupervisorBackupArg) DeepCopyInto(out *SupervisorBackupArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorBackupReply that shares no memory with it, nil if it is nil
+func (in *SupervisorBackupReply) DeepCopy() *SupervisorBackupReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorBackupReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorBackupReply that shares no memory with it
+func (in *SupervisorBackupReply) DeepCopyInto(out *SupervisorBackupReply) {
+	*out = *in
+	if in.Backup != nil {
+		out.Backup = make([]byte, len(in.Backup))
+		copy(out.Backup, in.Backup)
+	}
+}
+
+// Returns a copy of the SupervisorCheckpointArg that shares no memory with it, nil if it is nil
+func (in *SupervisorCheckpointArg) DeepCopy() *SupervisorCheckpointArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorCheckpointArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorCheckpointArg that shares no memory with it
+func (in *SupervisorCheckpointArg) DeepCopyInto(out *SupervisorCheckpointArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorCheckpointReply that shares no memory with it, nil if it is nil
+func (in *SupervisorCheckpointReply) DeepCopy() *SupervisorCheckpointReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorCheckpointReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorCheckpointReply that shares no memory with it
+func (in *SupervisorCheckpointReply) DeepCopyInto(out *SupervisorCheckpointReply) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorConfigArg that shares no memory with it, nil if it is nil
+func (in *SupervisorConfigArg) DeepCopy() *SupervisorConfigArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorConfigArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorConfigArg that shares no memory with it
+func (in *SupervisorConfigArg) DeepCopyInto(out *SupervisorConfigArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorConfigReply that shares no memory with it, nil if it is nil
+func (in *SupervisorConfigReply) DeepCopy() *SupervisorConfigReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorConfigReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorConfigReply that shares no memory with it
+func (in *SupervisorConfigReply) DeepCopyInto(out *SupervisorConfigReply) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorContainerMaintenanceArg that shares no memory with it, nil if it is nil
+func (in *SupervisorContainerMaintenanceArg) DeepCopy() *SupervisorContainerMaintenanceArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorContainerMaintenanceArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorContainerMaintenanceArg that shares no memory with it
+func (in *SupervisorContainerMaintenanceArg) DeepCopyInto(out *SupervisorContainerMaintenanceArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorContainerMaintenanceReply that shares no memory with it, nil if it is nil
+func (in *SupervisorContainerMaintenanceReply) DeepCopy() *SupervisorContainerMaintenanceReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorContainerMaintenanceReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorContainerMaintenanceReply that shares no memory with it
+func (in *SupervisorContainerMaintenanceReply) DeepCopyInto(out *SupervisorContainerMaintenanceReply) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorDeauthorizeSSHArg that shares no memory with it, nil if it is nil
+func (in *SupervisorDeauthorizeSSHArg) DeepCopy() *SupervisorDeauthorizeSSHArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorDeauthorizeSSHArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorDeauthorizeSSHArg that shares no memory with it
+func (in *SupervisorDeauthorizeSSHArg) DeepCopyInto(out *SupervisorDeauthorizeSSHArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorDeauthorizeSSHReply that shares no memory with it, nil if it is nil
+func (in *SupervisorDeauthorizeSSHReply) DeepCopy() *SupervisorDeauthorizeSSHReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorDeauthorizeSSHReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorDeauthorizeSSHReply that shares no memory with it
+func (in *SupervisorDeauthorizeSSHReply) DeepCopyInto(out *SupervisorDeauthorizeSSHReply) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorDeleteIPGroupArg that shares no memory with it, nil if it is nil
+func (in *SupervisorDeleteIPGroupArg) DeepCopy() *SupervisorDeleteIPGroupArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorDeleteIPGroupArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorDeleteIPGroupArg that shares no memory with it
+func (in *SupervisorDeleteIPGroupArg) DeepCopyInto(out *SupervisorDeleteIPGroupArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorDeleteIPGroupReply that shares no memory with it, nil if it is nil
+func (in *SupervisorDeleteIPGroupReply) DeepCopy() *SupervisorDeleteIPGroupReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorDeleteIPGroupReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorDeleteIPGroupReply that shares no memory with it
+func (in *SupervisorDeleteIPGroupReply) DeepCopyInto(out *SupervisorDeleteIPGroupReply) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorDeployArg that shares no memory with it, nil if it is nil
+func (in *SupervisorDeployArg) DeepCopy() *SupervisorDeployArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorDeployArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorDeployArg that shares no memory with it
+func (in *SupervisorDeployArg) DeepCopyInto(out *SupervisorDeployArg) {
+	*out = *in
+	out.Manifest = in.Manifest.DeepCopy()
+	out.Signed = in.Signed.DeepCopy()
+	if in.Labels != nil {
+		out.Labels = make(map[string]string, len(in.Labels))
+		for key0, val0 := range in.Labels {
+			out.Labels[key0] = val0
+		}
+	}
+	out.Registry = in.Registry.DeepCopy()
+	out.RegistryAuth = in.RegistryAuth.DeepCopy()
+}
+
+// Returns a copy of the SupervisorDeployReply that shares no memory with it, nil if it is nil
+func (in *SupervisorDeployReply) DeepCopy() *SupervisorDeployReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorDeployReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorDeployReply that shares no memory with it
+func (in *SupervisorDeployReply) DeepCopyInto(out *SupervisorDeployReply) {
+	*out = *in
+	out.Container = in.Container.DeepCopy()
+}
+
+// Returns a copy of the SupervisorDiffManifestArg that shares no memory with it, nil if it is nil
+func (in *SupervisorDiffManifestArg) DeepCopy() *SupervisorDiffManifestArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorDiffManifestArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorDiffManifestArg that shares no memory with it
+func (in *SupervisorDiffManifestArg) DeepCopyInto(out *SupervisorDiffManifestArg) {
+	*out = *in
+	out.Manifest = in.Manifest.DeepCopy()
+}
+
+// Returns a copy of the SupervisorDiffManifestReply that shares no memory with it, nil if it is nil
+func (in *SupervisorDiffManifestReply) DeepCopy() *SupervisorDiffManifestReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorDiffManifestReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorDiffManifestReply that shares no memory with it
+func (in *SupervisorDiffManifestReply) DeepCopyInto(out *SupervisorDiffManifestReply) {
+	*out = *in
+	if in.Changes != nil {
+		out.Changes = make([]ManifestChange, len(in.Changes))
+		copy(out.Changes, in.Changes)
+	}
+}
+
+// Returns a copy of the SupervisorExportStateArg that shares no memory with it, nil if it is nil
+func (in *SupervisorExportStateArg) DeepCopy() *SupervisorExportStateArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorExportStateArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorExportStateArg that shares no memory with it
+func (in *SupervisorExportStateArg) DeepCopyInto(out *SupervisorExportStateArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorExportStateReply that shares no memory with it, nil if it is nil
+func (in *SupervisorExportStateReply) DeepCopy() *SupervisorExportStateReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorExportStateReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorExportStateReply that shares no memory with it
+func (in *SupervisorExportStateReply) DeepCopyInto(out *SupervisorExportStateReply) {
+	*out = *in
+	if in.State != nil {
+		out.State = make([]byte, len(in.State))
+		copy(out.State, in.State)
+	}
+}
+
+// Returns a copy of the SupervisorGetArg that shares no memory with it, nil if it is nil
+func (in *SupervisorGetArg) DeepCopy() *SupervisorGetArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorGetArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorGetArg that shares no memory with it
+func (in *SupervisorGetArg) DeepCopyInto(out *SupervisorGetArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorGetReply that shares no memory with it, nil if it is nil
+func (in *SupervisorGetReply) DeepCopy() *SupervisorGetReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorGetReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorGetReply that shares no memory with it
+func (in *SupervisorGetReply) DeepCopyInto(out *SupervisorGetReply) {
+	*out = *in
+	out.Container = in.Container.DeepCopy()
+	out.Usage = in.Usage.DeepCopy()
+}
+
+// Returns a copy of the SupervisorHealthCheckArg that shares no memory with it, nil if it is nil
+func (in *SupervisorHealthCheckArg) DeepCopy() *SupervisorHealthCheckArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorHealthCheckArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorHealthCheckArg that shares no memory with it
+func (in *SupervisorHealthCheckArg) DeepCopyInto(out *SupervisorHealthCheckArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorHealthCheckReply that shares no memory with it, nil if it is nil
+func (in *SupervisorHealthCheckReply) DeepCopy() *SupervisorHealthCheckReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorHealthCheckReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorHealthCheckReply that shares no memory with it
+func (in *SupervisorHealthCheckReply) DeepCopyInto(out *SupervisorHealthCheckReply) {
+	*out = *in
+	out.Containers = in.Containers.DeepCopy()
+	out.CPUShares = in.CPUShares.DeepCopy()
+	out.Memory = in.Memory.DeepCopy()
+	out.RawCPUShares = in.RawCPUShares.DeepCopy()
+	out.RawMemory = in.RawMemory.DeepCopy()
+	out.GPUs = in.GPUs.DeepCopy()
+	out.CPUs = in.CPUs.DeepCopy()
+	out.Disk = in.Disk.DeepCopy()
+	if in.Instances != nil {
+		out.Instances = make(map[string]map[string]uint, len(in.Instances))
+		for key0, val0 := range in.Instances {
+			var cp0 map[string]uint
+			if val0 != nil {
+				cp0 = make(map[string]uint, len(val0))
+				for key1, val1 := range val0 {
+					cp0[key1] = val1
+				}
+			}
+			out.Instances[key0] = cp0
+		}
+	}
+}
+
+// Returns a copy of the SupervisorIdleArg that shares no memory with it, nil if it is nil
+func (in *SupervisorIdleArg) DeepCopy() *SupervisorIdleArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorIdleArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorIdleArg that shares no memory with it
+func (in *SupervisorIdleArg) DeepCopyInto(out *SupervisorIdleArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorIdleReply that shares no memory with it, nil if it is nil
+func (in *SupervisorIdleReply) DeepCopy() *SupervisorIdleReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorIdleReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorIdleReply that shares no memory with it
+func (in *SupervisorIdleReply) DeepCopyInto(out *SupervisorIdleReply) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorImageGCArg that shares no memory with it, nil if it is nil
+func (in *SupervisorImageGCArg) DeepCopy() *SupervisorImageGCArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorImageGCArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorImageGCArg that shares no memory with it
+func (in *SupervisorImageGCArg) DeepCopyInto(out *SupervisorImageGCArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorImageGCReply that shares no memory with it, nil if it is nil
+func (in *SupervisorImageGCReply) DeepCopy() *SupervisorImageGCReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorImageGCReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorImageGCReply that shares no memory with it
+func (in *SupervisorImageGCReply) DeepCopyInto(out *SupervisorImageGCReply) {
+	*out = *in
+	if in.Removed != nil {
+		out.Removed = make([]string, len(in.Removed))
+		copy(out.Removed, in.Removed)
+	}
+}
+
+// Returns a copy of the SupervisorImportStateArg that shares no memory with it, nil if it is nil
+func (in *SupervisorImportStateArg) DeepCopy() *SupervisorImportStateArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorImportStateArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorImportStateArg that shares no memory with it
+func (in *SupervisorImportStateArg) DeepCopyInto(out *SupervisorImportStateArg) {
+	*out = *in
+	if in.State != nil {
+		out.State = make([]byte, len(in.State))
+		copy(out.State, in.State)
+	}
+}
+
+// Returns a copy of the SupervisorImportStateReply that shares no memory with it, nil if it is nil
+func (in *SupervisorImportStateReply) DeepCopy() *SupervisorImportStateReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorImportStateReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorImportStateReply that shares no memory with it
+func (in *SupervisorImportStateReply) DeepCopyInto(out *SupervisorImportStateReply) {
+	*out = *in
+	if in.ContainerIDs != nil {
+		out.ContainerIDs = make([]string, len(in.ContainerIDs))
+		copy(out.ContainerIDs, in.ContainerIDs)
+	}
+}
+
+// Returns a copy of the SupervisorListArg that shares no memory with it, nil if it is nil
+func (in *SupervisorListArg) DeepCopy() *SupervisorListArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorListArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorListArg that shares no memory with it
+func (in *SupervisorListArg) DeepCopyInto(out *SupervisorListArg) {
+	*out = *in
+	if in.Labels != nil {
+		out.Labels = make(map[string]string, len(in.Labels))
+		for key0, val0 := range in.Labels {
+			out.Labels[key0] = val0
+		}
+	}
+}
+
+// Returns a copy of the SupervisorListEventsArg that shares no memory with it, nil if it is nil
+func (in *SupervisorListEventsArg) DeepCopy() *SupervisorListEventsArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorListEventsArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorListEventsArg that shares no memory with it
+func (in *SupervisorListEventsArg) DeepCopyInto(out *SupervisorListEventsArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorListEventsReply that shares no memory with it, nil if it is nil
+func (in *SupervisorListEventsReply) DeepCopy() *SupervisorListEventsReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorListEventsReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorListEventsReply that shares no memory with it
+func (in *SupervisorListEventsReply) DeepCopyInto(out *SupervisorListEventsReply) {
+	*out = *in
+	if in.Events != nil {
+		out.Events = make([]*ContainerEvent, len(in.Events))
+		for i0 := range in.Events {
+			out.Events[i0] = in.Events[i0].DeepCopy()
+		}
+	}
+}
+
+// Returns a copy of the SupervisorListReply that shares no memory with it, nil if it is nil
+func (in *SupervisorListReply) DeepCopy() *SupervisorListReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorListReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorListReply that shares no memory with it
+func (in *SupervisorListReply) DeepCopyInto(out *SupervisorListReply) {
+	*out = *in
+	if in.Containers != nil {
+		out.Containers = make(map[string]*Container, len(in.Containers))
+		for key0, val0 := range in.Containers {
+			var cp0 *Container
+			cp0 = val0.DeepCopy()
+			out.Containers[key0] = cp0
+		}
+	}
+	if in.UnusedPorts != nil {
+		out.UnusedPorts = make([]uint16, len(in.UnusedPorts))
+		copy(out.UnusedPorts, in.UnusedPorts)
+	}
+	out.PortPools = in.PortPools.DeepCopy()
+	if in.Instances != nil {
+		out.Instances = make(map[string]map[string]uint, len(in.Instances))
+		for key0, val0 := range in.Instances {
+			var cp0 map[string]uint
+			if val0 != nil {
+				cp0 = make(map[string]uint, len(val0))
+				for key1, val1 := range val0 {
+					cp0[key1] = val1
+				}
+			}
+			out.Instances[key0] = cp0
+		}
+	}
+}
+
+// Returns a copy of the SupervisorListStateSnapshotsArg that shares no memory with it, nil if it is nil
+func (in *SupervisorListStateSnapshotsArg) DeepCopy() *SupervisorListStateSnapshotsArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorListStateSnapshotsArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorListStateSnapshotsArg that shares no memory with it
+func (in *SupervisorListStateSnapshotsArg) DeepCopyInto(out *SupervisorListStateSnapshotsArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorListStateSnapshotsReply that shares no memory with it, nil if it is nil
+func (in *SupervisorListStateSnapshotsReply) DeepCopy() *SupervisorListStateSnapshotsReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorListStateSnapshotsReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorListStateSnapshotsReply that shares no memory with it
+func (in *SupervisorListStateSnapshotsReply) DeepCopyInto(out *SupervisorListStateSnapshotsReply) {
+	*out = *in
+	if in.Snapshots != nil {
+		out.Snapshots = make([]*StateSnapshot, len(in.Snapshots))
+		for i0 := range in.Snapshots {
+			out.Snapshots[i0] = in.Snapshots[i0].DeepCopy()
+		}
+	}
+}
+
+// Returns a copy of the SupervisorLogsArg that shares no memory with it, nil if it is nil
+func (in *SupervisorLogsArg) DeepCopy() *SupervisorLogsArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorLogsArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorLogsArg that shares no memory with it
+func (in *SupervisorLogsArg) DeepCopyInto(out *SupervisorLogsArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorLogsReply that shares no memory with it, nil if it is nil
+func (in *SupervisorLogsReply) DeepCopy() *SupervisorLogsReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorLogsReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorLogsReply that shares no memory with it
+func (in *SupervisorLogsReply) DeepCopyInto(out *SupervisorLogsReply) {
+	*out = *in
+	if in.Lines != nil {
+		out.Lines = make([]*LogLine, len(in.Lines))
+		for i0 := range in.Lines {
+			out.Lines[i0] = in.Lines[i0].DeepCopy()
+		}
+	}
+}
+
+// Returns a copy of the SupervisorMaintenanceArg that shares no memory with it, nil if it is nil
+func (in *SupervisorMaintenanceArg) DeepCopy() *SupervisorMaintenanceArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorMaintenanceArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorMaintenanceArg that shares no memory with it
+func (in *SupervisorMaintenanceArg) DeepCopyInto(out *SupervisorMaintenanceArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorMaintenanceReply that shares no memory with it, nil if it is nil
+func (in *SupervisorMaintenanceReply) DeepCopy() *SupervisorMaintenanceReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorMaintenanceReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorMaintenanceReply that shares no memory with it
+func (in *SupervisorMaintenanceReply) DeepCopyInto(out *SupervisorMaintenanceReply) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorNetworkRulesArg that shares no memory with it, nil if it is nil
+func (in *SupervisorNetworkRulesArg) DeepCopy() *SupervisorNetworkRulesArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorNetworkRulesArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorNetworkRulesArg that shares no memory with it
+func (in *SupervisorNetworkRulesArg) DeepCopyInto(out *SupervisorNetworkRulesArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorNetworkRulesReply that shares no memory with it, nil if it is nil
+func (in *SupervisorNetworkRulesReply) DeepCopy() *SupervisorNetworkRulesReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorNetworkRulesReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorNetworkRulesReply that shares no memory with it
+func (in *SupervisorNetworkRulesReply) DeepCopyInto(out *SupervisorNetworkRulesReply) {
+	*out = *in
+	if in.HostRules != nil {
+		out.HostRules = make([]string, len(in.HostRules))
+		copy(out.HostRules, in.HostRules)
+	}
+	if in.ContainerRules != nil {
+		out.ContainerRules = make(map[string][]string, len(in.ContainerRules))
+		for key0, val0 := range in.ContainerRules {
+			var cp0 []string
+			if val0 != nil {
+				cp0 = make([]string, len(val0))
+				copy(cp0, val0)
+			}
+			out.ContainerRules[key0] = cp0
+		}
+	}
+}
+
+// Returns a copy of the SupervisorPrefetchImageArg that shares no memory with it, nil if it is nil
+func (in *SupervisorPrefetchImageArg) DeepCopy() *SupervisorPrefetchImageArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorPrefetchImageArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorPrefetchImageArg that shares no memory with it
+func (in *SupervisorPrefetchImageArg) DeepCopyInto(out *SupervisorPrefetchImageArg) {
+	*out = *in
+	out.Registry = in.Registry.DeepCopy()
+	out.RegistryAuth = in.RegistryAuth.DeepCopy()
+}
+
+// Returns a copy of the SupervisorPrefetchImageReply that shares no memory with it, nil if it is nil
+func (in *SupervisorPrefetchImageReply) DeepCopy() *SupervisorPrefetchImageReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorPrefetchImageReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorPrefetchImageReply that shares no memory with it
+func (in *SupervisorPrefetchImageReply) DeepCopyInto(out *SupervisorPrefetchImageReply) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorRedeployArg that shares no memory with it, nil if it is nil
+func (in *SupervisorRedeployArg) DeepCopy() *SupervisorRedeployArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorRedeployArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorRedeployArg that shares no memory with it
+func (in *SupervisorRedeployArg) DeepCopyInto(out *SupervisorRedeployArg) {
+	*out = *in
+	out.Manifest = in.Manifest.DeepCopy()
+	out.Signed = in.Signed.DeepCopy()
+	if in.Labels != nil {
+		out.Labels = make(map[string]string, len(in.Labels))
+		for key0, val0 := range in.Labels {
+			out.Labels[key0] = val0
+		}
+	}
+	out.RegistryAuth = in.RegistryAuth.DeepCopy()
+}
+
+// Returns a copy of the SupervisorRedeployReply that shares no memory with it, nil if it is nil
+func (in *SupervisorRedeployReply) DeepCopy() *SupervisorRedeployReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorRedeployReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorRedeployReply that shares no memory with it
+func (in *SupervisorRedeployReply) DeepCopyInto(out *SupervisorRedeployReply) {
+	*out = *in
+	out.Container = in.Container.DeepCopy()
+	out.OldContainer = in.OldContainer.DeepCopy()
+}
+
+// Returns a copy of the SupervisorResizeContainerArg that shares no memory with it, nil if it is nil
+func (in *SupervisorResizeContainerArg) DeepCopy() *SupervisorResizeContainerArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorResizeContainerArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorResizeContainerArg that shares no memory with it
+func (in *SupervisorResizeContainerArg) DeepCopyInto(out *SupervisorResizeContainerArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorResizeContainerReply that shares no memory with it, nil if it is nil
+func (in *SupervisorResizeContainerReply) DeepCopy() *SupervisorResizeContainerReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorResizeContainerReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorResizeContainerReply that shares no memory with it
+func (in *SupervisorResizeContainerReply) DeepCopyInto(out *SupervisorResizeContainerReply) {
+	*out = *in
+	out.Container = in.Container.DeepCopy()
+}
+
+// Returns a copy of the SupervisorRestoreArg that shares no memory with it, nil if it is nil
+func (in *SupervisorRestoreArg) DeepCopy() *SupervisorRestoreArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorRestoreArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorRestoreArg that shares no memory with it
+func (in *SupervisorRestoreArg) DeepCopyInto(out *SupervisorRestoreArg) {
+	*out = *in
+	if in.Backup != nil {
+		out.Backup = make([]byte, len(in.Backup))
+		copy(out.Backup, in.Backup)
+	}
+}
+
+// Returns a copy of the SupervisorRestoreCheckpointArg that shares no memory with it, nil if it is nil
+func (in *SupervisorRestoreCheckpointArg) DeepCopy() *SupervisorRestoreCheckpointArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorRestoreCheckpointArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorRestoreCheckpointArg that shares no memory with it
+func (in *SupervisorRestoreCheckpointArg) DeepCopyInto(out *SupervisorRestoreCheckpointArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorRestoreCheckpointReply that shares no memory with it, nil if it is nil
+func (in *SupervisorRestoreCheckpointReply) DeepCopy() *SupervisorRestoreCheckpointReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorRestoreCheckpointReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorRestoreCheckpointReply that shares no memory with it
+func (in *SupervisorRestoreCheckpointReply) DeepCopyInto(out *SupervisorRestoreCheckpointReply) {
+	*out = *in
+	out.Container = in.Container.DeepCopy()
+}
+
+// Returns a copy of the SupervisorRestoreReply that shares no memory with it, nil if it is nil
+func (in *SupervisorRestoreReply) DeepCopy() *SupervisorRestoreReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorRestoreReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorRestoreReply that shares no memory with it
+func (in *SupervisorRestoreReply) DeepCopyInto(out *SupervisorRestoreReply) {
+	*out = *in
+	if in.ContainerIDs != nil {
+		out.ContainerIDs = make([]string, len(in.ContainerIDs))
+		copy(out.ContainerIDs, in.ContainerIDs)
+	}
+	if in.Errors != nil {
+		out.Errors = make(map[string]string, len(in.Errors))
+		for key0, val0 := range in.Errors {
+			out.Errors[key0] = val0
+		}
+	}
+}
+
+// Returns a copy of the SupervisorRollbackArg that shares no memory with it, nil if it is nil
+func (in *SupervisorRollbackArg) DeepCopy() *SupervisorRollbackArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorRollbackArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorRollbackArg that shares no memory with it
+func (in *SupervisorRollbackArg) DeepCopyInto(out *SupervisorRollbackArg) {
+	*out = *in
+	out.RegistryAuth = in.RegistryAuth.DeepCopy()
+}
+
+// Returns a copy of the SupervisorRollbackReply that shares no memory with it, nil if it is nil
+func (in *SupervisorRollbackReply) DeepCopy() *SupervisorRollbackReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorRollbackReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorRollbackReply that shares no memory with it
+func (in *SupervisorRollbackReply) DeepCopyInto(out *SupervisorRollbackReply) {
+	*out = *in
+	out.Container = in.Container.DeepCopy()
+	out.OldContainer = in.OldContainer.DeepCopy()
+}
+
+// Returns a copy of the SupervisorRollbackStateArg that shares no memory with it, nil if it is nil
+func (in *SupervisorRollbackStateArg) DeepCopy() *SupervisorRollbackStateArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorRollbackStateArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorRollbackStateArg that shares no memory with it
+func (in *SupervisorRollbackStateArg) DeepCopyInto(out *SupervisorRollbackStateArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorRollbackStateReply that shares no memory with it, nil if it is nil
+func (in *SupervisorRollbackStateReply) DeepCopy() *SupervisorRollbackStateReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorRollbackStateReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorRollbackStateReply that shares no memory with it
+func (in *SupervisorRollbackStateReply) DeepCopyInto(out *SupervisorRollbackStateReply) {
+	*out = *in
+	if in.ContainerIDs != nil {
+		out.ContainerIDs = make([]string, len(in.ContainerIDs))
+		copy(out.ContainerIDs, in.ContainerIDs)
+	}
+}
+
+// Returns a copy of the SupervisorSSHAuditArg that shares no memory with it, nil if it is nil
+func (in *SupervisorSSHAuditArg) DeepCopy() *SupervisorSSHAuditArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorSSHAuditArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorSSHAuditArg that shares no memory with it
+func (in *SupervisorSSHAuditArg) DeepCopyInto(out *SupervisorSSHAuditArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorSSHAuditReply that shares no memory with it, nil if it is nil
+func (in *SupervisorSSHAuditReply) DeepCopy() *SupervisorSSHAuditReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorSSHAuditReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorSSHAuditReply that shares no memory with it
+func (in *SupervisorSSHAuditReply) DeepCopyInto(out *SupervisorSSHAuditReply) {
+	*out = *in
+	if in.Records != nil {
+		out.Records = make([]*SSHAccessRecord, len(in.Records))
+		for i0 := range in.Records {
+			out.Records[i0] = in.Records[i0].DeepCopy()
+		}
+	}
+	if in.Grants != nil {
+		out.Grants = make([]*SSHAccessGrant, len(in.Grants))
+		for i0 := range in.Grants {
+			out.Grants[i0] = in.Grants[i0].DeepCopy()
+		}
+	}
+}
+
+// Returns a copy of the SupervisorStatsHistoryArg that shares no memory with it, nil if it is nil
+func (in *SupervisorStatsHistoryArg) DeepCopy() *SupervisorStatsHistoryArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorStatsHistoryArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorStatsHistoryArg that shares no memory with it
+func (in *SupervisorStatsHistoryArg) DeepCopyInto(out *SupervisorStatsHistoryArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorStatsHistoryReply that shares no memory with it, nil if it is nil
+func (in *SupervisorStatsHistoryReply) DeepCopy() *SupervisorStatsHistoryReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorStatsHistoryReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorStatsHistoryReply that shares no memory with it
+func (in *SupervisorStatsHistoryReply) DeepCopyInto(out *SupervisorStatsHistoryReply) {
+	*out = *in
+	if in.Samples != nil {
+		out.Samples = make(map[string][]*StatsSample, len(in.Samples))
+		for key0, val0 := range in.Samples {
+			var cp0 []*StatsSample
+			if val0 != nil {
+				cp0 = make([]*StatsSample, len(val0))
+				for i1 := range val0 {
+					cp0[i1] = val0[i1].DeepCopy()
+				}
+			}
+			out.Samples[key0] = cp0
+		}
+	}
+}
+
+// Returns a copy of the SupervisorTeardownArg that shares no memory with it, nil if it is nil
+func (in *SupervisorTeardownArg) DeepCopy() *SupervisorTeardownArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorTeardownArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorTeardownArg that shares no memory with it
+func (in *SupervisorTeardownArg) DeepCopyInto(out *SupervisorTeardownArg) {
+	*out = *in
+	if in.ContainerIDs != nil {
+		out.ContainerIDs = make([]string, len(in.ContainerIDs))
+		copy(out.ContainerIDs, in.ContainerIDs)
+	}
+	if in.Labels != nil {
+		out.Labels = make(map[string]string, len(in.Labels))
+		for key0, val0 := range in.Labels {
+			out.Labels[key0] = val0
+		}
+	}
+}
+
+// Returns a copy of the SupervisorTeardownReply that shares no memory with it, nil if it is nil
+func (in *SupervisorTeardownReply) DeepCopy() *SupervisorTeardownReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorTeardownReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorTeardownReply that shares no memory with it
+func (in *SupervisorTeardownReply) DeepCopyInto(out *SupervisorTeardownReply) {
+	*out = *in
+	if in.ContainerIDs != nil {
+		out.ContainerIDs = make([]string, len(in.ContainerIDs))
+		copy(out.ContainerIDs, in.ContainerIDs)
+	}
+}
+
+// Returns a copy of the SupervisorUpdateIPGroupArg that shares no memory with it, nil if it is nil
+func (in *SupervisorUpdateIPGroupArg) DeepCopy() *SupervisorUpdateIPGroupArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorUpdateIPGroupArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorUpdateIPGroupArg that shares no memory with it
+func (in *SupervisorUpdateIPGroupArg) DeepCopyInto(out *SupervisorUpdateIPGroupArg) {
+	*out = *in
+	if in.IPs != nil {
+		out.IPs = make([]string, len(in.IPs))
+		copy(out.IPs, in.IPs)
+	}
+}
+
+// Returns a copy of the SupervisorUpdateIPGroupReply that shares no memory with it, nil if it is nil
+func (in *SupervisorUpdateIPGroupReply) DeepCopy() *SupervisorUpdateIPGroupReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorUpdateIPGroupReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorUpdateIPGroupReply that shares no memory with it
+func (in *SupervisorUpdateIPGroupReply) DeepCopyInto(out *SupervisorUpdateIPGroupReply) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorVersionArg that shares no memory with it, nil if it is nil
+func (in *SupervisorVersionArg) DeepCopy() *SupervisorVersionArg {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorVersionArg)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorVersionArg that shares no memory with it
+func (in *SupervisorVersionArg) DeepCopyInto(out *SupervisorVersionArg) {
+	*out = *in
+}
+
+// Returns a copy of the SupervisorVersionReply that shares no memory with it, nil if it is nil
+func (in *SupervisorVersionReply) DeepCopy() *SupervisorVersionReply {
+	if in == nil {
+		return nil
+	}
+	out := new(SupervisorVersionReply)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the SupervisorVersionReply that shares no memory with it
+func (in *SupervisorVersionReply) DeepCopyInto(out *SupervisorVersionReply) {
+	*out = *in
+}
+
+// Returns a copy of the TLSTermination that shares no memory with it, nil if it is nil
+func (in *TLSTermination) DeepCopy() *TLSTermination {
+	if in == nil {
+		return nil
+	}
+	out := new(TLSTermination)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the TLSTermination that shares no memory with it
+func (in *TLSTermination) DeepCopyInto(out *TLSTermination) {
+	*out = *in
+}
+
+// Returns a copy of the TmpfsMount that shares no memory with it, nil if it is nil
+func (in *TmpfsMount) DeepCopy() *TmpfsMount {
+	if in == nil {
+		return nil
+	}
+	out := new(TmpfsMount)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the TmpfsMount that shares no memory with it
+func (in *TmpfsMount) DeepCopyInto(out *TmpfsMount) {
+	*out = *in
+}
+
+// Returns a copy of the Ulimit that shares no memory with it, nil if it is nil
+func (in *Ulimit) DeepCopy() *Ulimit {
+	if in == nil {
+		return nil
+	}
+	out := new(Ulimit)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the Ulimit that shares no memory with it
+func (in *Ulimit) DeepCopyInto(out *Ulimit) {
+	*out = *in
+}
+
+// Returns a copy of the Volume that shares no memory with it, nil if it is nil
+func (in *Volume) DeepCopy() *Volume {
+	if in == nil {
+		return nil
+	}
+	out := new(Volume)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// Sets out to a copy of the Volume that shares no memory with it
+func (in *Volume) DeepCopyInto(out *Volume) {
+	*out = *in
+}
+
+// Returns a copy of v, a value decoded from json or built like one, that shares no maps or slices with it
+func deepCopyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			out[key] = deepCopyValue(val)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, val := range v {
+			out[i] = deepCopyValue(val)
+		}
+		return out
+	case map[string]string:
+		out := make(map[string]string, len(v))
+		for key, val := range v {
+			out[key] = val
+		}
+		return out
+	case []string:
+		return append([]string(nil), v...)
+	}
+	return v
+}
@@ -0,0 +1,273 @@
+//go:build ignore
+// +build ignore
+
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+// Generates deepcopy.go: DeepCopy and DeepCopyInto for every struct in the package and DeepCopy for its named map
+// and slice types. Run it with go generate whenever a type in types.go changes.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+const outputFile = "deepcopy.go"
+
+// types whose values hold no references, so copying the value copies everything
+var plainTypes = map[string]bool{
+	"bool": true, "string": true, "byte": true, "rune": true, "float32": true, "float64": true,
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true,
+	"time.Time": true, "time.Duration": true,
+}
+
+type generator struct {
+	fset    *token.FileSet
+	structs map[string]*ast.StructType
+	named   map[string]ast.Expr // named map and slice types
+	deep    map[string]bool     // memoized needsDeepCopy of local types
+	buf     bytes.Buffer
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *generator) typeString(expr ast.Expr) string {
+	var buf bytes.Buffer
+	if err := format.Node(&buf, g.fset, expr); err != nil {
+		log.Fatal(err)
+	}
+	return buf.String()
+}
+
+// Whether copying a value of the type leaves it sharing memory with the original
+func (g *generator) needsDeepCopy(expr ast.Expr) bool {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if plainTypes[t.Name] {
+			return false
+		}
+		if deep, ok := g.deep[t.Name]; ok {
+			return deep
+		}
+		if _, ok := g.named[t.Name]; ok {
+			return true
+		}
+		st, ok := g.structs[t.Name]
+		if !ok {
+			log.Fatalf("unsupported type %s", t.Name)
+		}
+		g.deep[t.Name] = false // a struct can only refer to itself through a pointer
+		for _, field := range st.Fields.List {
+			if g.needsDeepCopy(field.Type) {
+				g.deep[t.Name] = true
+			}
+		}
+		return g.deep[t.Name]
+	case *ast.SelectorExpr:
+		if !plainTypes[g.typeString(t)] {
+			log.Fatalf("unsupported type %s", g.typeString(t))
+		}
+		return false
+	case *ast.StarExpr, *ast.MapType, *ast.InterfaceType:
+		return true
+	case *ast.ArrayType:
+		return t.Len == nil || g.needsDeepCopy(t.Elt)
+	}
+	log.Fatalf("unsupported type %s", g.typeString(expr))
+	return false
+}
+
+// Write the statements that make dst a deep copy of src. dst must already hold a shallow copy of src, so nil maps
+// and slices are left as they are.
+func (g *generator) copyInto(dst, src string, expr ast.Expr, depth int) {
+	if !g.needsDeepCopy(expr) {
+		g.printf("%s = %s\n", dst, src)
+		return
+	}
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if _, ok := g.named[t.Name]; ok {
+			g.printf("%s = %s.DeepCopy()\n", dst, src)
+		} else {
+			g.printf("%s.DeepCopyInto(&%s)\n", src, dst)
+		}
+	case *ast.StarExpr:
+		ident, ok := t.X.(*ast.Ident)
+		if ok && plainTypes[ident.Name] {
+			g.printf("if %s != nil {\n%s = new(%s)\n*%s = *%s\n}\n", src, dst, ident.Name, dst, src)
+			return
+		}
+		if !ok || g.structs[ident.Name] == nil {
+			log.Fatalf("unsupported pointer type %s", g.typeString(t))
+		}
+		g.printf("%s = %s.DeepCopy()\n", dst, src)
+	case *ast.InterfaceType:
+		g.printf("%s = deepCopyValue(%s)\n", dst, src)
+	case *ast.ArrayType:
+		if t.Len != nil {
+			log.Fatalf("unsupported array type %s", g.typeString(t))
+		}
+		g.printf("if %s != nil {\n", src)
+		g.printf("%s = make(%s, len(%s))\n", dst, g.typeString(t), src)
+		if g.needsDeepCopy(t.Elt) {
+			i := fmt.Sprintf("i%d", depth)
+			g.printf("for %s := range %s {\n", i, src)
+			g.copyInto(dst+"["+i+"]", src+"["+i+"]", t.Elt, depth+1)
+			g.printf("}\n")
+		} else {
+			g.printf("copy(%s, %s)\n", dst, src)
+		}
+		g.printf("}\n")
+	case *ast.MapType:
+		key, val := fmt.Sprintf("key%d", depth), fmt.Sprintf("val%d", depth)
+		g.printf("if %s != nil {\n", src)
+		g.printf("%s = make(%s, len(%s))\n", dst, g.typeString(t), src)
+		g.printf("for %s, %s := range %s {\n", key, val, src)
+		if g.needsDeepCopy(t.Value) {
+			cp := fmt.Sprintf("cp%d", depth)
+			g.printf("var %s %s\n", cp, g.typeString(t.Value))
+			g.copyInto(cp, val, t.Value, depth+1)
+			g.printf("%s[%s] = %s\n", dst, key, cp)
+		} else {
+			g.printf("%s[%s] = %s\n", dst, key, val)
+		}
+		g.printf("}\n}\n")
+	default:
+		log.Fatalf("unsupported type %s", g.typeString(expr))
+	}
+}
+
+func (g *generator) generateStruct(name string, st *ast.StructType) {
+	g.printf("\n// Returns a copy of the %s that shares no memory with it, nil if it is nil\n", name)
+	g.printf("func (in *%s) DeepCopy() *%s {\n", name, name)
+	g.printf("if in == nil {\nreturn nil\n}\nout := new(%s)\nin.DeepCopyInto(out)\nreturn out\n}\n", name)
+	g.printf("\n// Sets out to a copy of the %s that shares no memory with it\n", name)
+	g.printf("func (in *%s) DeepCopyInto(out *%s) {\n*out = *in\n", name, name)
+	for _, field := range st.Fields.List {
+		if !g.needsDeepCopy(field.Type) {
+			continue
+		}
+		for _, fieldName := range field.Names {
+			g.copyInto("out."+fieldName.Name, "in."+fieldName.Name, field.Type, 0)
+		}
+		if len(field.Names) == 0 {
+			log.Fatalf("embedded fields are not supported: %s.%s", name, g.typeString(field.Type))
+		}
+	}
+	g.printf("}\n")
+}
+
+func (g *generator) generateNamed(name string, expr ast.Expr) {
+	g.printf("\n// Returns a copy of the %s that shares no memory with it\n", name)
+	g.printf("func (in %s) DeepCopy() %s {\nvar out %s\n", name, name, name)
+	g.copyInto("out", "in", expr, 0)
+	g.printf("return out\n}\n")
+}
+
+func main() {
+	header, err := ioutil.ReadFile("deepcopy_gen.go")
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the license, without the build constraints above it
+	header = header[bytes.Index(header, []byte("/*")):]
+	license := string(header[:bytes.Index(header, []byte("*/\n"))+3])
+	g := &generator{fset: token.NewFileSet(), structs: map[string]*ast.StructType{}, named: map[string]ast.Expr{},
+		deep: map[string]bool{}}
+	pkgs, err := parser.ParseDir(g.fset, ".", func(fi os.FileInfo) bool {
+		return fi.Name() != outputFile && !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pkg := pkgs["types"]
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				spec := spec.(*ast.TypeSpec)
+				switch t := spec.Type.(type) {
+				case *ast.StructType:
+					g.structs[spec.Name.Name] = t
+				case *ast.MapType, *ast.ArrayType:
+					g.named[spec.Name.Name] = t
+				}
+			}
+		}
+	}
+	g.printf("%s\n// Code generated by deepcopy_gen.go; DO NOT EDIT.\n\npackage types\n", license)
+	names := make([]string, 0, len(g.structs)+len(g.named))
+	for name := range g.structs {
+		names = append(names, name)
+	}
+	for name := range g.named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if st, ok := g.structs[name]; ok {
+			g.generateStruct(name, st)
+		} else {
+			g.generateNamed(name, g.named[name])
+		}
+	}
+	g.printf(`
+// Returns a copy of v, a value decoded from json or built like one, that shares no maps or slices with it
+func deepCopyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			out[key] = deepCopyValue(val)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, val := range v {
+			out[i] = deepCopyValue(val)
+		}
+		return out
+	case map[string]string:
+		out := make(map[string]string, len(v))
+		for key, val := range v {
+			out[key] = val
+		}
+		return out
+	case []string:
+		return append([]string(nil), v...)
+	}
+	return v
+}
+`)
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		log.Fatalf("could not format the generated code: %v\n%s", err, g.buf.Bytes())
+	}
+	if err := ioutil.WriteFile(outputFile, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,137 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Typed views of the data of well-known deps. The data of an AppDep is whatever the app's environment provides,
+// so it arrives as a map[string]interface{}; these decode it into a struct and validate it in one place rather
+// than having every consumer assert its way through the map.
+
+type TypedDep interface {
+	Validate() error
+}
+
+// The dep whose data sets the check_mk contact group of an app's services
+const CmkDepName = "cmk"
+
+type CmkDep struct {
+	ContactGroup string `json:"contact_group"`
+}
+
+func (d *CmkDep) Validate() error {
+	if d.ContactGroup == "" {
+		return errors.New("no contact_group")
+	}
+	return nil
+}
+
+// A database the app connects to
+type DatabaseDep struct {
+	Host     string `json:"host"`
+	Port     uint16 `json:"port"`
+	Database string `json:"database"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (d *DatabaseDep) Validate() error {
+	if d.Host == "" {
+		return errors.New("no host")
+	}
+	if d.Port == 0 {
+		return errors.New("no port")
+	}
+	if d.Database == "" {
+		return errors.New("no database")
+	}
+	return nil
+}
+
+// A service the app reaches at a host and port
+type PortDep struct {
+	Host string `json:"host"`
+	Port uint16 `json:"port"`
+}
+
+func (d *PortDep) Validate() error {
+	if d.Host == "" {
+		return errors.New("no host")
+	}
+	if d.Port == 0 {
+		return errors.New("no port")
+	}
+	return nil
+}
+
+// Decode the data of a dep, an AppDep's DataMap or the value of a dep in a container's config.json, into one
+// of the typed deps above and validate it. Keys the typed dep does not know about are ignored.
+func DecodeDep(data interface{}, dep TypedDep) error {
+	if _, ok := data.(map[string]interface{}); !ok {
+		return fmt.Errorf("expected a map, not %T", data)
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(encoded, dep); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			return fmt.Errorf("%s should be a %s, not a %s", typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+		return err
+	}
+	return dep.Validate()
+}
+
+func ParseCmkDep(data interface{}) (*CmkDep, error) {
+	dep := &CmkDep{}
+	return dep, DecodeDep(data, dep)
+}
+
+func ParseDatabaseDep(data interface{}) (*DatabaseDep, error) {
+	dep := &DatabaseDep{}
+	return dep, DecodeDep(data, dep)
+}
+
+func ParsePortDep(data interface{}) (*PortDep, error) {
+	dep := &PortDep{}
+	return dep, DecodeDep(data, dep)
+}
+
+// The accessors below read DataMap, so the dep's EncryptedData has to have been decrypted into it first.
+
+func (d *AppDep) Cmk() (*CmkDep, error) {
+	return ParseCmkDep(d.DataMap)
+}
+
+func (d *AppDep) Database() (*DatabaseDep, error) {
+	return ParseDatabaseDep(d.DataMap)
+}
+
+func (d *AppDep) Port() (*PortDep, error) {
+	return ParsePortDep(d.DataMap)
+}
+
+// Validate the data of the well-known deps that are not encrypted. Encrypted data is checked by its consumers
+// once it is decrypted.
+func (deps DepsType) Validate() error {
+	if dep := deps[CmkDepName]; dep != nil && dep.EncryptedData == "" && dep.DataMap != nil {
+		if _, err := dep.Cmk(); err != nil {
+			return fmt.Errorf("%s dep: %v", CmkDepName, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// Dep data can hold credentials, so diffs only say that it changed
+const RedactedValue = "<redacted>"
+
+// One difference between two manifests. Path uses the manifest's json field names, with map keys after a dot
+// and list indexes in brackets, e.g. env.LOG_LEVEL or runCommands[1]. Old and New are json, empty when the value
+// was added or removed.
+type ManifestChange struct {
+	Path string `json:"path,omitempty"`
+	Kind string `json:"kind,omitempty"` // one of ChangeAdded, ChangeRemoved or ChangeChanged
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+func (c ManifestChange) String() string {
+	switch c.Kind {
+	case ChangeAdded:
+		return fmt.Sprintf("+ %s: %s", c.Path, c.New)
+	case ChangeRemoved:
+		return fmt.Sprintf("- %s: %s", c.Path, c.Old)
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", c.Path, c.Old, c.New)
+	}
+}
+
+// Returns what changes going from manifest a to manifest b, with map keys in order and list elements by index.
+// A nil manifest diffs as an empty one.
+func DiffManifests(a, b *Manifest) ([]ManifestChange, error) {
+	if a == nil {
+		a = &Manifest{}
+	}
+	if b == nil {
+		b = &Manifest{}
+	}
+	old, err := genericJSON(a)
+	if err != nil {
+		return nil, err
+	}
+	proposed, err := genericJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	changes := []ManifestChange{}
+	diffValues("", old, proposed, &changes)
+	for i := range changes {
+		if changes[i].Path == "deps" || strings.HasPrefix(changes[i].Path, "deps.") {
+			if changes[i].Old != "" {
+				changes[i].Old = RedactedValue
+			}
+			if changes[i].New != "" {
+				changes[i].New = RedactedValue
+			}
+		}
+	}
+	return changes, nil
+}
+
+// Round trip v through json so manifests compare as maps, lists and scalars under their json names
+func genericJSON(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	err = json.Unmarshal(data, &generic)
+	return generic, err
+}
+
+func jsonString(v interface{}) string {
+	data, _ := json.Marshal(v) // v came from json.Unmarshal, so it always marshals
+	return string(data)
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func diffValues(path string, a, b interface{}, changes *[]ManifestChange) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(av)+len(bv))
+		for key := range av {
+			keys = append(keys, key)
+		}
+		for key := range bv {
+			if _, ok := av[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			aval, inA := av[key]
+			bval, inB := bv[key]
+			switch {
+			case !inB:
+				*changes = append(*changes, ManifestChange{Path: joinPath(path, key), Kind: ChangeRemoved,
+					Old: jsonString(aval)})
+			case !inA:
+				*changes = append(*changes, ManifestChange{Path: joinPath(path, key), Kind: ChangeAdded,
+					New: jsonString(bval)})
+			default:
+				diffValues(joinPath(path, key), aval, bval, changes)
+			}
+		}
+		return
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(av) || i < len(bv); i++ {
+			elemPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(bv):
+				*changes = append(*changes, ManifestChange{Path: elemPath, Kind: ChangeRemoved, Old: jsonString(av[i])})
+			case i >= len(av):
+				*changes = append(*changes, ManifestChange{Path: elemPath, Kind: ChangeAdded, New: jsonString(bv[i])})
+			default:
+				diffValues(elemPath, av[i], bv[i], changes)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, ManifestChange{Path: path, Kind: ChangeChanged, Old: jsonString(a),
+			New: jsonString(b)})
+	}
+}
@@ -9,163 +9,1491 @@
  * See the License for the specific language governing permissions and limitations under the License.
  */
 
+//go:generate go run deepcopy_gen.go
+
 package types
 
 import (
 	"atlantis/builder/manifest"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+)
+
+type GenericContainer interface {
+	GetApp() string
+	GetSha() string
+	GetID() string
+	SetDockerID(string)
+	GetDockerID() string
+	GetDockerRepo() string
+	GetIP() string
+	SetIP(string)
+	GetPid() int
+	SetPid(int)
+	GetSSHPort() uint16
+	GetHost() string
+	GetPrimaryPort() uint16
+	GetSecondaryPorts() []uint16
+	GetEnv() string
+	GetLabels() map[string]string
+}
+
+type Container struct {
+	ID             string              `json:"id,omitempty"`
+	DockerID       string              `json:"dockerID,omitempty"`
+	IP             string              `json:"ip,omitempty"`
+	IPv6           string              `json:"ipv6,omitempty"` // global IPv6 address, empty if the container's network has none
+	Pid            int                 `json:"pid,omitempty"`
+	Host           string              `json:"host,omitempty"`
+	PrimaryPort    uint16              `json:"primaryPort,omitempty"`
+	SecondaryPorts []uint16            `json:"secondaryPorts,omitempty"`
+	SSHPort        uint16              `json:"sshPort,omitempty"`
+	HostKeys       []string            `json:"hostKeys,omitempty"` // the container's ssh host keys as "<type> <key>", recorded at deploy
+	App            string              `json:"app,omitempty"`
+	Sha            string              `json:"sha,omitempty"`
+	Env            string              `json:"env,omitempty"`
+	Manifest       *Manifest           `json:"manifest,omitempty"`
+	Readiness      *ProbeStatus        `json:"readiness,omitempty"`
+	Liveness       *ProbeStatus        `json:"liveness,omitempty"`
+	Restarts       uint                `json:"restarts,omitempty"`
+	LastExitCode   int                 `json:"lastExitCode,omitempty"`
+	RunState       string              `json:"runState,omitempty"`    // ContainerRunning or ContainerExited as the supervisor last saw it, empty if unknown
+	ExitedAt       time.Time           `json:"exitedAt,omitempty"`    // when the container was last seen to have exited
+	Discrepancy    string              `json:"discrepancy,omitempty"` // how docker disagrees with the supervisor about the container, empty if it does not
+	OOMKills       uint                `json:"oomKills,omitempty"`    // times the kernel killed a process in the container for running out of memory
+	LastOOMKill    time.Time           `json:"lastOOMKill,omitempty"` // zero if there were none
+	Sidecars       []*SidecarContainer `json:"sidecars,omitempty"`
+	NamedPorts     map[string][]uint16 `json:"namedPorts,omitempty"` // port name -> ports, taken from SecondaryPorts
+	Labels         map[string]string   `json:"labels,omitempty"`
+	GPUs           []uint              `json:"gpus,omitempty"`       // indexes of the GPUs allocated to the container
+	CPUSet         []uint              `json:"cpuSet,omitempty"`     // cores dedicated to the container, empty if it shares the cpus
+	NetworkOf      string              `json:"networkOf,omitempty"`  // id of the container whose network namespace it joined, empty for its own
+	TLSPort        uint16              `json:"tlsPort,omitempty"`    // secondary port the TLS sidecar serves the primary port on, 0 without one
+	BindAddrs      []string            `json:"bindAddrs,omitempty"`  // host addresses the ports are published on, empty for all
+	SSHAddrs       []string            `json:"sshAddrs,omitempty"`   // host addresses the SSH port is published on, empty for all
+	Registry       *Registry           `json:"registry,omitempty"`   // nil for the supervisor's registry
+	Previous       *Release            `json:"previous,omitempty"`   // what the container replaced in a redeploy, nil if it was not a redeploy
+	Checkpoint     string              `json:"checkpoint,omitempty"` // name of the last CRIU checkpoint, empty if there is none
+	CheckpointedAt time.Time           `json:"checkpointedAt,omitempty"`
+	ExpiresAt      time.Time           `json:"expiresAt,omitempty"` // when the container is torn down, zero if it has no TTL
+	TTL            uint                `json:"ttl,omitempty"`       // seconds left until ExpiresAt, as of when the container was last returned by Get or List
+}
+
+func (c *Container) GetID() string {
+	return c.ID
+}
+
+func (c *Container) GetApp() string {
+	return c.App
+}
+
+func (c *Container) GetSha() string {
+	return c.Sha
+}
+
+func (c *Container) SetDockerID(id string) {
+	c.DockerID = id
+}
+
+func (c *Container) GetDockerID() string {
+	return c.DockerID
+}
+
+func (c *Container) GetDockerRepo() string {
+	if c.Registry != nil && c.Registry.Repo != "" {
+		return c.Registry.Repo
+	}
+	return "apps"
+}
+
+func (c *Container) SetIP(ip string) {
+	c.IP = ip
+}
+
+func (c *Container) GetIP() string {
+	return c.IP
+}
+
+// Returns host without the brackets around an IPv6 address, the form ssh and iptables take it in
+func UnbracketHost(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// Returns host:port, with brackets around host if it is an IPv6 address
+func HostPort(host string, port uint16) string {
+	return net.JoinHostPort(UnbracketHost(host), fmt.Sprintf("%d", port))
+}
+
+func (c *Container) SetPid(pid int) {
+	c.Pid = pid
+}
+
+func (c *Container) GetPid() int {
+	return c.Pid
+}
+
+func (c *Container) GetSSHPort() uint16 {
+	return c.SSHPort
+}
+
+func (c *Container) GetHost() string {
+	return c.Host
+}
+
+func (c *Container) GetPrimaryPort() uint16 {
+	return c.PrimaryPort
+}
+
+func (c *Container) GetSecondaryPorts() []uint16 {
+	return c.SecondaryPorts
+}
+
+func (c *Container) GetEnv() string {
+	return c.Env
+}
+
+func (c *Container) GetLabels() map[string]string {
+	return c.Labels
+}
+
+// Returns true if the container has every label in selector with the same value. An empty selector matches
+// every container.
+func (c *Container) MatchesLabels(selector map[string]string) bool {
+	for key, val := range selector {
+		if label, ok := c.Labels[key]; !ok || label != val {
+			return false
+		}
+	}
+	return true
+}
+
+var labelKeyRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_./-]*$`)
+
+func ValidateLabels(labels map[string]string) error {
+	for key, val := range labels {
+		if len(key) > 63 || !labelKeyRegexp.MatchString(key) {
+			return fmt.Errorf("label key %q should be at most 63 letters, digits, '_', '.', '/' or '-'", key)
+		}
+		if len(val) > 255 || strings.ContainsAny(val, "\n\r") {
+			return fmt.Errorf("label %s should be a single line of at most 255 characters", key)
+		}
+	}
+	return nil
+}
+
+// Returns the PORT_<NAME> variables for the container's named ports
+func (c *Container) NamedPortEnv() map[string]string {
+	env := map[string]string{}
+	for name, ports := range c.NamedPorts {
+		env[PortEnvName(name)] = fmt.Sprintf("%d", ports[0])
+		if len(ports) > 1 {
+			for i, port := range ports {
+				env[fmt.Sprintf("%s_%d", PortEnvName(name), i)] = fmt.Sprintf("%d", port)
+			}
+		}
+	}
+	return env
+}
+
+// Returns the first port allocated for the named port declaration, or 0 if there is none
+func (c *Container) NamedPort(name string) uint16 {
+	if ports := c.NamedPorts[name]; len(ports) > 0 {
+		return ports[0]
+	}
+	return 0
+}
+
+// Containers are encoded with lowerCamel keys and their unset times left out, which omitempty does not do for
+// time.Time. This is the format of the supervisor's saved state and what HTTP gateways and other tooling see.
+func (c Container) MarshalJSON() ([]byte, error) {
+	type plain Container // without the MarshalJSON method
+	return json.Marshal(struct {
+		plain
+		ExitedAt       *time.Time `json:"exitedAt,omitempty"`
+		LastOOMKill    *time.Time `json:"lastOOMKill,omitempty"`
+		CheckpointedAt *time.Time `json:"checkpointedAt,omitempty"`
+		ExpiresAt      *time.Time `json:"expiresAt,omitempty"`
+	}{plain(c), optionalTime(c.ExitedAt), optionalTime(c.LastOOMKill), optionalTime(c.CheckpointedAt),
+		optionalTime(c.ExpiresAt)})
+}
+
+// Returns nil for the zero time so it can be left out of json
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (c *Container) RandomID() string {
+	return c.ID[strings.LastIndex(c.ID, "-")+1:]
+}
+
+// How a container is printed by the client and in logs
+const (
+	ContainerFormatShort = "short" // a single line with the id, app, sha, address and run state
+	ContainerFormatLong  = "long"  // a line for each field
+	ContainerFormatTable = "table" // a tab separated row under ContainerTableHeader, for a text/tabwriter
+)
+
+var ContainerFormats = []string{ContainerFormatShort, ContainerFormatLong, ContainerFormatTable}
+
+const ContainerTableHeader = "ID\tAPP\tSHA\tHOST\tPORT\tSTATE\tCPU SHARES\tMEMORY LIMIT"
+
+// Returns the container in one of the ContainerFormats, ContainerFormatLong for any other format. Fields that
+// are not set, the manifest included, are printed as such rather than taken for granted, so this is safe to use
+// on partial containers such as the ones being reserved or imported.
+func (c *Container) Format(format string) string {
+	if c == nil {
+		return "none"
+	}
+	switch format {
+	case ContainerFormatShort:
+		addr := fmt.Sprintf("port %d", c.PrimaryPort)
+		if c.Host != "" {
+			addr = HostPort(c.Host, c.PrimaryPort)
+		}
+		return fmt.Sprintf("%s %s @ %s on %s (%s)", c.ID, orNone(c.App), orNone(c.Sha), addr, c.runStateString())
+	case ContainerFormatTable:
+		cpu, memory := "-", "-"
+		if c.Manifest != nil {
+			cpu = fmt.Sprintf("%d", c.Manifest.CPUShares)
+			memory = fmt.Sprintf("%d", c.Manifest.MemoryLimit)
+		}
+		return strings.Join([]string{c.ID, orNone(c.App), orNone(c.Sha), orNone(c.Host),
+			fmt.Sprintf("%d", c.PrimaryPort), c.runStateString(), cpu, memory}, "\t")
+	}
+	cpu, memory := "none", "none"
+	if c.Manifest != nil {
+		cpu = fmt.Sprintf("%d", c.Manifest.CPUShares)
+		memory = fmt.Sprintf("%d", c.Manifest.MemoryLimit)
+	}
+	return fmt.Sprintf(`%s
+IP              : %s
+IPv6            : %s
+Network Of      : %s
+Pid             : %d
+Host            : %s
+Primary Port    : %d
+SSH Port        : %d
+Secondary Ports : %v
+TLS Port        : %d
+Bound To        : %s
+App             : %s
+SHA             : %s
+CPU Shares      : %s
+Memory Limit    : %s
+Docker ID       : %s
+Readiness       : %s
+Liveness        : %s
+Run State       : %s
+Discrepancy     : %s
+Restarts        : %d
+OOM Kills       : %d
+Sidecars        : %s
+Named Ports     : %v
+Labels          : %v
+GPUs            : %v
+CPU Set         : %v
+Capabilities    : %s
+Previous        : %s
+Checkpoint      : %s
+Expires         : %s`, c.ID, orNone(c.IP), orNone(c.IPv6), orNone(c.NetworkOf), c.Pid, orNone(c.Host),
+		c.PrimaryPort, c.SSHPort, c.SecondaryPorts, c.TLSPort, c.bindString(), orNone(c.App), orNone(c.Sha), cpu,
+		memory, orNone(c.DockerID), c.Readiness, c.Liveness, c.runStateString(), orNone(c.Discrepancy), c.Restarts,
+		c.OOMKills, c.sidecarsString(), c.NamedPorts, c.Labels, c.GPUs, c.CPUSet, c.capabilitiesString(), c.Previous,
+		c.checkpointString(), c.expiresString())
+}
+
+// Returns the capabilities the container was given and had taken away, e.g. "+NET_BIND_SERVICE -NET_RAW"
+func (c *Container) capabilitiesString() string {
+	if c.Manifest == nil || len(c.Manifest.CapAdd)+len(c.Manifest.CapDrop) == 0 {
+		return "default"
+	}
+	changes := []string{}
+	for _, cap := range c.Manifest.CapAdd {
+		changes = append(changes, "+"+cap)
+	}
+	for _, cap := range c.Manifest.CapDrop {
+		changes = append(changes, "-"+cap)
+	}
+	return strings.Join(changes, " ")
+}
+
+func (c *Container) String() string {
+	return c.Format(ContainerFormatLong)
+}
+
+// Returns the addresses the container's ports are published on, e.g. "10.0.0.5 (ssh 127.0.0.1)"
+func (c *Container) bindString() string {
+	addrs := "all addresses"
+	if len(c.BindAddrs) > 0 {
+		addrs = strings.Join(c.BindAddrs, ", ")
+	}
+	if len(c.SSHAddrs) > 0 {
+		addrs += fmt.Sprintf(" (ssh %s)", strings.Join(c.SSHAddrs, ", "))
+	}
+	return addrs
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+func (c *Container) runStateString() string {
+	if c.RunState == "" {
+		return "unknown"
+	}
+	return c.RunState
+}
+
+func (c *Container) checkpointString() string {
+	if c.Checkpoint == "" {
+		return "none"
+	}
+	return fmt.Sprintf("%s (%s)", c.Checkpoint, c.CheckpointedAt.Format(time.RFC3339))
+}
+
+func (c *Container) expiresString() string {
+	if c.ExpiresAt.IsZero() {
+		return "never"
+	}
+	return fmt.Sprintf("%s (%ds left)", c.ExpiresAt.Format(time.RFC3339), c.TTL)
+}
+
+func (c *Container) sidecarsString() string {
+	if len(c.Sidecars) == 0 {
+		return "none"
+	}
+	sidecars := make([]string, len(c.Sidecars))
+	for i, sc := range c.Sidecars {
+		sidecars[i] = sc.String()
+	}
+	return strings.Join(sidecars, ", ")
+}
+
+var registryRepoRegexp = regexp.MustCompile(`^[a-z0-9]+([._/-][a-z0-9]+)*$`)
+
+// A version of an app that ran in a container, kept after a redeploy so it can be rolled back to
+type Release struct {
+	ContainerID string            `json:"containerID,omitempty"`
+	Sha         string            `json:"sha,omitempty"`
+	Manifest    *Manifest         `json:"manifest,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Registry    *Registry         `json:"registry,omitempty"`
+	ReplacedAt  time.Time         `json:"replacedAt,omitempty"`
+}
+
+func (r *Release) String() string {
+	if r == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%s in %s (replaced %s)", r.Sha, r.ContainerID, r.ReplacedAt.Format(time.RFC3339))
+}
+
+// The outcome of the last check_mk re-inventory the supervisor ran after containers came or went
+type InventoryStatus struct {
+	LastRun  time.Time `json:"lastRun,omitempty"`
+	Command  []string  `json:"command,omitempty"`
+	Attempts uint      `json:"attempts,omitempty"` // tries it took, including the last one
+	Error    string    `json:"error,omitempty"`    // of the last attempt, empty if it succeeded
+	Output   string    `json:"output,omitempty"`   // combined output of the last attempt, truncated
+}
+
+// The registry a container's image is pulled from, as <Host>/<Repo>/<app>-<sha>. An empty Host is the
+// supervisor's registry and an empty Repo is "apps".
+type Registry struct {
+	Host string `json:"host,omitempty"`
+	Repo string `json:"repo,omitempty"`
+}
+
+func (r *Registry) Validate() error {
+	if strings.ContainsAny(r.Host, "/ ") {
+		return fmt.Errorf("registry host %q should be a host[:port]", r.Host)
+	}
+	if r.Repo != "" && !registryRepoRegexp.MatchString(r.Repo) {
+		return fmt.Errorf("registry repo %q is not a valid repository name", r.Repo)
+	}
+	return nil
+}
+
+// Credentials for a registry. They are only kept in memory, never saved with the container.
+type RegistryAuth struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Email    string `json:"email,omitempty"`
+}
+
+var sidecarNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// An auxiliary container declared in the manifest, such as a log shipper or a local proxy. Sidecars are
+// deployed after the primary container from <registry>/sidecars/<Image>-<Version>, share its network namespace
+// and are torn down with it. The primary container's log dir is mounted read-only at the same path. MemoryLimit
+// is in MB; 0 means no limit. A sidecar with TLS gets the certificate and key at <ContainerConfigDir>/tls/cert.pem
+// and key.pem.
+type Sidecar struct {
+	Name        string            `json:"name,omitempty"`
+	Image       string            `json:"image,omitempty"`
+	Version     string            `json:"version,omitempty"`
+	Command     []string          `json:"command,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	CPUShares   uint              `json:"cpuShares,omitempty"`
+	MemoryLimit uint              `json:"memoryLimit,omitempty"`
+	TLS         *TLSTermination   `json:"tls,omitempty"`
+}
+
+func (s *Sidecar) Validate() error {
+	if !sidecarNameRegexp.MatchString(s.Name) {
+		return fmt.Errorf("sidecar name %q should be lowercase letters, digits and dashes", s.Name)
+	}
+	if s.Image == "" || s.Version == "" {
+		return fmt.Errorf("sidecar %s requires an image and a version", s.Name)
+	}
+	if s.TLS != nil {
+		if err := s.TLS.Validate(); err != nil {
+			return fmt.Errorf("sidecar %s: %v", s.Name, err)
+		}
+	}
+	return nil
+}
+
+// Name of the sidecar the supervisor injects for a manifest's TLS
+const TLSSidecarName = "tls"
+
+// Terminates TLS in front of the app: a sidecar listens on the container's TLSPort and proxies plain HTTP to its
+// primary port. Cert and Key are secret references to the PEM certificate chain and private key; left empty, the
+// supervisor's own certificate is used.
+type TLSTermination struct {
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+}
+
+func (t *TLSTermination) Validate() error {
+	if (t.Cert == "") != (t.Key == "") {
+		return errors.New("a TLS certificate and its key should be given together")
+	}
+	for _, ref := range []string{t.Cert, t.Key} {
+		if ref == "" {
+			continue
+		}
+		if _, _, _, err := (&Secret{Ref: ref}).ParseRef(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A deployed sidecar. The supervisor replaces a SidecarContainer rather than modifying it, so copies handed out
+// are never changed underneath the caller.
+type SidecarContainer struct {
+	ID              string  `json:"id,omitempty"` // <primary container id>-<sidecar name>
+	DockerID        string  `json:"dockerID,omitempty"`
+	IP              string  `json:"ip,omitempty"`
+	Pid             int     `json:"pid,omitempty"`
+	PrimaryID       string  `json:"primaryID,omitempty"`
+	PrimaryDockerID string  `json:"primaryDockerID,omitempty"` // the network namespace of this docker container is shared
+	PrimaryPort     uint16  `json:"primaryPort,omitempty"`
+	Host            string  `json:"host,omitempty"`
+	Env             string  `json:"env,omitempty"`
+	Spec            Sidecar `json:"spec,omitempty"`
+	Running         bool    `json:"running,omitempty"`
+	Restarts        uint    `json:"restarts,omitempty"`
+	OOMKills        uint    `json:"oomKills,omitempty"`
+}
+
+func (s *SidecarContainer) GetID() string {
+	return s.ID
+}
+
+func (s *SidecarContainer) GetApp() string {
+	return s.Spec.Image
+}
+
+func (s *SidecarContainer) GetSha() string {
+	return s.Spec.Version
+}
+
+func (s *SidecarContainer) SetDockerID(id string) {
+	s.DockerID = id
+}
+
+func (s *SidecarContainer) GetDockerID() string {
+	return s.DockerID
+}
+
+func (s *SidecarContainer) GetDockerRepo() string {
+	return "sidecars"
+}
+
+func (s *SidecarContainer) SetIP(ip string) {
+	s.IP = ip
+}
+
+func (s *SidecarContainer) GetIP() string {
+	return s.IP
+}
+
+func (s *SidecarContainer) SetPid(pid int) {
+	s.Pid = pid
+}
+
+func (s *SidecarContainer) GetPid() int {
+	return s.Pid
+}
+
+// Sidecars share the primary container's network namespace and are not reachable over ssh on their own
+func (s *SidecarContainer) GetSSHPort() uint16 {
+	return 0
+}
+
+func (s *SidecarContainer) GetHost() string {
+	return s.Host
+}
+
+// Returns the primary container's port, the sidecar is reached through its network namespace
+func (s *SidecarContainer) GetPrimaryPort() uint16 {
+	return s.PrimaryPort
+}
+
+func (s *SidecarContainer) GetSecondaryPorts() []uint16 {
+	return nil
+}
+
+func (s *SidecarContainer) GetEnv() string {
+	return s.Env
+}
+
+func (s *SidecarContainer) GetLabels() map[string]string {
+	return nil
+}
+
+func (s *SidecarContainer) String() string {
+	if s == nil {
+		return "none"
+	}
+	state := "running"
+	if !s.Running {
+		state = "not running"
+	}
+	return fmt.Sprintf("%s (%s-%s, %s, %d restarts)", s.Spec.Name, s.Spec.Image, s.Spec.Version, state, s.Restarts)
+}
+
+const (
+	ContainerRunning      = "running"
+	ContainerExited       = "exited"       // and not restarted, by policy or because restarting it failed
+	ContainerCheckpointed = "checkpointed" // stopped by a checkpoint and waiting to be restored from it
+)
+
+const (
+	DiscrepancyRemoved = "removed from docker" // the docker container was removed without the supervisor
 )
 
-type GenericContainer interface {
-	GetApp() string
-	GetSha() string
-	GetID() string
-	SetDockerID(string)
-	GetDockerID() string
-	GetDockerRepo() string
-	GetIP() string
-	SetIP(string)
-	GetPid() int
-	SetPid(int)
-	GetSSHPort() uint16
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+// How the supervisor reacts when a container exits. MaxRetries of 0 means no limit. Backoff is the number of
+// seconds to wait before the first restart and doubles with every restart after that.
+type RestartPolicy struct {
+	Name       string `json:"name,omitempty"`
+	MaxRetries uint   `json:"maxRetries,omitempty"`
+	Backoff    uint   `json:"backoff,omitempty"`
+}
+
+func (p *RestartPolicy) Validate() error {
+	switch p.Name {
+	case RestartNever, RestartOnFailure, RestartAlways:
+		return nil
+	}
+	return errors.New("restart policy should be never, on-failure or always")
+}
+
+// Returns true if a container that exited with exitCode after already being restarted restarts times should be
+// restarted again
+func (p *RestartPolicy) ShouldRestart(exitCode int, restarts uint) bool {
+	if p.MaxRetries > 0 && restarts >= p.MaxRetries {
+		return false
+	}
+	switch p.Name {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return exitCode != 0
+	}
+	return false
+}
+
+const (
+	ProbeExec = "exec"
+	ProbeTCP  = "tcp"
+	ProbeHTTP = "http"
+)
+
+// A readiness or liveness probe declared in the manifest. Exec probes run Command inside the container over
+// ssh, TCP probes connect to Port and HTTP probes GET Path on Port, expecting a 2xx or 3xx. Port defaults to the
+// named port PortName if set, otherwise the container's primary port. The probe fails once FailureThreshold
+// consecutive runs have failed.
+type Probe struct {
+	Type             string `json:"type,omitempty"`
+	Command          string `json:"command,omitempty"`
+	Port             uint16 `json:"port,omitempty"`
+	PortName         string `json:"portName,omitempty"`
+	Path             string `json:"path,omitempty"`
+	Interval         uint   `json:"interval,omitempty"` // seconds
+	Timeout          uint   `json:"timeout,omitempty"`  // seconds
+	FailureThreshold uint   `json:"failureThreshold,omitempty"`
+	RestartOnFailure bool   `json:"restartOnFailure,omitempty"` // liveness only: restart the container when the probe fails
+}
+
+func (p *Probe) Validate() error {
+	switch p.Type {
+	case ProbeExec:
+		if p.Command == "" {
+			return errors.New("exec probe requires a command")
+		}
+	case ProbeTCP, ProbeHTTP:
+	default:
+		return errors.New("probe type should be exec, tcp or http")
+	}
+	return nil
+}
+
+// The latest result of a probe. A new ProbeStatus is created for every result so copies handed out by the
+// supervisor are never modified.
+type ProbeStatus struct {
+	Passing             bool      `json:"passing,omitempty"`
+	ConsecutiveFailures uint      `json:"consecutiveFailures,omitempty"`
+	LastChecked         time.Time `json:"lastChecked,omitempty"`
+	LastError           string    `json:"lastError,omitempty"`
+}
+
+func (s *ProbeStatus) String() string {
+	if s == nil {
+		return "none"
+	}
+	if s.Passing {
+		return fmt.Sprintf("passing (checked %s)", s.LastChecked.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("failing %d times: %s (checked %s)", s.ConsecutiveFailures, s.LastError,
+		s.LastChecked.Format(time.RFC3339))
+}
+
+// Resource limits that may be raised or lowered for a container
+var UlimitNames = map[string]bool{
+	"core": true, "cpu": true, "data": true, "fsize": true, "locks": true, "memlock": true, "msgqueue": true,
+	"nice": true, "nofile": true, "nproc": true, "rss": true, "rtprio": true, "rttime": true, "sigpending": true,
+	"stack": true,
+}
+
+// A resource limit applied to the container's processes, e.g. nofile to raise the file descriptor limit
+type Ulimit struct {
+	Name string `json:"name,omitempty"`
+	Soft int64  `json:"soft,omitempty"`
+	Hard int64  `json:"hard,omitempty"`
+}
+
+func (u *Ulimit) Validate() error {
+	if !UlimitNames[u.Name] {
+		return fmt.Errorf("unknown ulimit %q", u.Name)
+	}
+	if u.Soft < 0 || u.Hard < 0 {
+		return fmt.Errorf("ulimit %s should not be negative", u.Name)
+	}
+	if u.Soft > u.Hard {
+		return fmt.Errorf("soft limit for %s is greater than the hard limit", u.Name)
+	}
+	return nil
+}
+
+// Sysctls that are namespaced per container and so can be set without affecting the host or other containers
+var SafeSysctlPrefixes = []string{"net.", "kernel.shm", "kernel.msg", "fs.mqueue."}
+
+func ValidateSysctl(name string) error {
+	if name == "kernel.sem" {
+		return nil
+	}
+	for _, prefix := range SafeSysctlPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("sysctl %s is not namespaced and can not be set for a container", name)
+}
+
+// Tuning of the container's network stack for apps with unusual networking needs, e.g. a lower MTU behind a
+// tunnel or short keepalives to notice peers that vanished behind a load balancer. Times are in seconds and zero
+// keeps the default. The TCP and conntrack settings become sysctls; the supervisor sets the MTU on eth0.
+type NetworkTuning struct {
+	MTU                  uint `json:"mtu,omitempty"`
+	KeepaliveTime        uint `json:"keepaliveTime,omitempty"`        // idle time before the first keepalive probe
+	KeepaliveInterval    uint `json:"keepaliveInterval,omitempty"`    // between unanswered probes
+	KeepaliveProbes      uint `json:"keepaliveProbes,omitempty"`      // unanswered probes before the connection is dropped
+	ConntrackEstablished uint `json:"conntrackEstablished,omitempty"` // how long idle TCP connections stay tracked
+	ConntrackUDP         uint `json:"conntrackUDP,omitempty"`         // how long idle UDP flows stay tracked
+}
+
+func (t *NetworkTuning) Validate() error {
+	if t.MTU != 0 && (t.MTU < 68 || t.MTU > 65535) {
+		return fmt.Errorf("mtu %d should be between 68 and 65535", t.MTU)
+	}
+	// the kernel's limits, MAX_TCP_KEEPIDLE, MAX_TCP_KEEPINTVL and MAX_TCP_KEEPCNT
+	if t.KeepaliveTime > 32767 || t.KeepaliveInterval > 32767 {
+		return errors.New("keepalive times should be at most 32767 seconds")
+	}
+	if t.KeepaliveProbes > 127 {
+		return errors.New("keepalive probes should be at most 127")
+	}
+	return nil
+}
+
+// Returns the sysctls that apply the tuning
+func (t *NetworkTuning) Sysctls() map[string]string {
+	sysctls := map[string]string{}
+	for name, val := range map[string]uint{
+		"net.ipv4.tcp_keepalive_time":                        t.KeepaliveTime,
+		"net.ipv4.tcp_keepalive_intvl":                       t.KeepaliveInterval,
+		"net.ipv4.tcp_keepalive_probes":                      t.KeepaliveProbes,
+		"net.netfilter.nf_conntrack_tcp_timeout_established": t.ConntrackEstablished,
+		"net.netfilter.nf_conntrack_udp_timeout":             t.ConntrackUDP,
+	} {
+		if val != 0 {
+			sysctls[name] = fmt.Sprintf("%d", val)
+		}
+	}
+	return sysctls
+}
+
+var volumeNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// A volume mounted into the container at Target. Source is either an absolute path on the host or the name of
+// a docker volume, which is created on first use. Either way the data outlives the container, so it persists
+// across restarts and redeploys.
+type Volume struct {
+	Source   string `json:"source,omitempty"`
+	Target   string `json:"target,omitempty"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+}
+
+func (v *Volume) HostPath() bool {
+	return strings.HasPrefix(v.Source, "/")
+}
+
+func (v *Volume) Validate() error {
+	if v.Source == "" {
+		return errors.New("volume requires a source")
+	}
+	if v.HostPath() {
+		if path.Clean(v.Source) != v.Source {
+			return fmt.Errorf("host path %s should be clean", v.Source)
+		}
+	} else if !volumeNameRegexp.MatchString(v.Source) {
+		return fmt.Errorf("volume source %s should be an absolute host path or a volume name", v.Source)
+	}
+	if !strings.HasPrefix(v.Target, "/") || path.Clean(v.Target) != v.Target || v.Target == "/" {
+		return fmt.Errorf("volume target %s should be a clean absolute path", v.Target)
+	}
+	return nil
+}
+
+// A writable in-memory dir. What is written to it counts against the container's memory limit.
+type TmpfsMount struct {
+	Target string `json:"target,omitempty"`
+	SizeMB uint   `json:"sizeMB,omitempty"` // 0 for the runtime's default
+}
+
+// Dirs that are mounted as tmpfs in containers with a read-only root unless the manifest mounts them itself,
+// since sshd and most apps need to write pid files and sockets. Services supervised by runit need their
+// supervise dirs linked into /run.
+var ReadOnlyRootTmpfs = []string{"/tmp", "/run"}
+
+func (t *TmpfsMount) Validate() error {
+	if !strings.HasPrefix(t.Target, "/") || path.Clean(t.Target) != t.Target || t.Target == "/" {
+		return fmt.Errorf("tmpfs target %s should be a clean absolute path", t.Target)
+	}
+	return nil
+}
+
+// Returns the mount options in docker's format
+func (t *TmpfsMount) Options() string {
+	if t.SizeMB == 0 {
+		return "rw,nosuid,nodev"
+	}
+	return fmt.Sprintf("rw,nosuid,nodev,size=%dm", t.SizeMB)
+}
+
+// Returns the tmpfs mounts of the container: the manifest's, plus the defaults a read-only root needs
+func (m *Manifest) TmpfsMounts() []TmpfsMount {
+	mounts := append([]TmpfsMount{}, m.Tmpfs...)
+	if !m.ReadOnlyRoot {
+		return mounts
+	}
+	for _, target := range ReadOnlyRootTmpfs {
+		mounted := false
+		for _, volume := range m.Volumes {
+			mounted = mounted || volume.Target == target
+		}
+		for _, mount := range m.Tmpfs {
+			mounted = mounted || mount.Target == target
+		}
+		if !mounted {
+			mounts = append(mounts, TmpfsMount{Target: target})
+		}
+	}
+	return mounts
+}
+
+// Returns the volume in docker's "source:target[:ro]" bind format
+func (v *Volume) Bind() string {
+	if v.ReadOnly {
+		return v.Source + ":" + v.Target + ":ro"
+	}
+	return v.Source + ":" + v.Target
+}
+
+var portNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// A named port declared in the manifest, e.g. metrics or admin. Count ports are allocated for it (a range,
+// defaults to 1) out of the container's secondary ports and passed to the container as PORT_<NAME> (and
+// PORT_<NAME>_<i> for each port of a range).
+type PortSpec struct {
+	Name  string `json:"name,omitempty"`
+	Count uint16 `json:"count,omitempty"`
+}
+
+func (p *PortSpec) Size() uint16 {
+	if p.Count == 0 {
+		return 1
+	}
+	return p.Count
+}
+
+func (p *PortSpec) Validate() error {
+	if !portNameRegexp.MatchString(p.Name) {
+		return fmt.Errorf("port name %q should be lowercase letters, digits and underscores", p.Name)
+	}
+	if p.Name == "http" || p.Name == "sshd" {
+		return fmt.Errorf("port name %s is reserved", p.Name)
+	}
+	return nil
+}
+
+// Returns the environment variable name the port is exposed as
+func PortEnvName(name string) string {
+	return "PORT_" + strings.ToUpper(name)
+}
+
+const (
+	NetworkBridge = "bridge"
+	NetworkHost   = "host"
+)
+
+var networkNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Containers always get a network the supervisor can ssh into, so "none" and other containers' namespaces are
+// not allowed
+func ValidateNetworkMode(mode string) error {
+	if mode == "" || mode == NetworkBridge || mode == NetworkHost {
+		return nil
+	}
+	if mode == "none" || mode == "default" || !networkNameRegexp.MatchString(mode) {
+		return fmt.Errorf("network mode should be bridge, host or the name of a docker network, got %q", mode)
+	}
+	return nil
+}
+
+// Returns true if the container shares the host's network namespace
+func (m *Manifest) HostNetwork() bool {
+	return m.NetworkMode == NetworkHost
+}
+
+var domainNameRegexp = regexp.MustCompile(`(?i)^([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)*[a-z][a-z0-9-]*[a-z0-9]\.?$`)
+
+// Where the app may connect to besides its dependencies' security groups, enforced with firewall rules. Allow
+// entries are a CIDR, an IP or a domain name, optionally followed by :<port> to allow only that TCP port (IPv6
+// addresses with a port go in brackets). Domain names are resolved when the container is deployed. With
+// DefaultDeny everything the container sends elsewhere is rejected, otherwise that is up to the supervisor.
+type EgressPolicy struct {
+	Allow       []string `json:"allow,omitempty"`
+	DefaultDeny bool     `json:"defaultDeny,omitempty"`
+}
+
+func (p *EgressPolicy) Validate() error {
+	for _, entry := range p.Allow {
+		if _, _, err := ParseEgressEntry(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Splits an allow entry of an egress policy into its CIDR, IP or domain name and its port, 0 for any port
+func ParseEgressEntry(entry string) (string, uint16, error) {
+	host, port := entry, uint16(0)
+	if h, p, err := net.SplitHostPort(entry); err == nil {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil || n == 0 {
+			return "", 0, fmt.Errorf("egress entry %q has an invalid port", entry)
+		}
+		host, port = h, uint16(n)
+	}
+	if _, _, err := net.ParseCIDR(host); err == nil {
+		return host, port, nil
+	}
+	if net.ParseIP(host) != nil || domainNameRegexp.MatchString(host) {
+		return host, port, nil
+	}
+	return "", 0, fmt.Errorf("egress entry %q should be a CIDR, an IP or a domain name", entry)
+}
+
+// Options each logging driver accepts. The supervisor's own log dir is mounted into the container regardless, so
+// this only changes what happens to the container's stdout and stderr.
+var LogDriverOptions = map[string][]string{
+	"json-file": []string{"max-size", "max-file", "compress", "labels", "env", "tag"},
+	"local":     []string{"max-size", "max-file", "compress"},
+	"syslog": []string{"syslog-address", "syslog-facility", "syslog-format", "syslog-tls-ca-cert",
+		"syslog-tls-cert", "syslog-tls-key", "syslog-tls-skip-verify", "tag", "labels", "env"},
+	"journald": []string{"tag", "labels", "env"},
+	"fluentd": []string{"fluentd-address", "fluentd-async", "fluentd-buffer-limit", "fluentd-retry-wait",
+		"fluentd-max-retries", "fluentd-sub-second-precision", "tag", "labels", "env"},
+	"gelf": []string{"gelf-address", "gelf-compression-type", "gelf-compression-level", "tag", "labels", "env"},
+}
+
+// The logging drivers docker can read the logs of back, so they can be shipped
+var ReadableLogDrivers = map[string]bool{"json-file": true, "local": true, "journald": true}
+
+// The docker logging driver for the container and its options, e.g. json-file with max-size and max-file for
+// rotation, or fluentd with a fluentd-address. When unset the docker daemon's default is used.
+type LogConfig struct {
+	Driver  string            `json:"driver,omitempty"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+func (l *LogConfig) Validate() error {
+	allowed, ok := LogDriverOptions[l.Driver]
+	if !ok {
+		return fmt.Errorf("unsupported logging driver %q", l.Driver)
+	}
+	for name := range l.Options {
+		found := false
+		for _, option := range allowed {
+			if name == option {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("logging driver %s does not support option %s", l.Driver, name)
+		}
+	}
+	return nil
+}
+
+const (
+	LogSinkSyslog = "syslog" // RFC 5424 over udp or tcp, the metadata as structured data
+	LogSinkKafka  = "kafka"  // records posted to a Kafka REST proxy, keyed by container
+	LogSinkHTTP   = "http"   // JSON arrays of lines posted to a URL
+
+	LogStreamStdout = "stdout"
+	LogStreamStderr = "stderr"
+)
+
+// Syslog facility codes by name
+var SyslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7, "uucp": 8,
+	"cron": 9, "authpriv": 10, "ftp": 11, "local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20,
+	"local5": 21, "local6": 22, "local7": 23,
+}
+
+var logFieldRegexp = regexp.MustCompile(`^[A-Za-z0-9_.\-]{1,32}$`)
+
+// Where the supervisor ships the container's stdout and stderr, a line at a time with its app, sha, env and
+// container attached. It reads them back from docker, so the logging driver has to be one docker can read:
+// json-file, local or journald.
+type LogShipping struct {
+	Sink     string            `json:"sink"`
+	Address  string            `json:"address"`            // syslog: [udp:// or tcp://]host:port, else a URL
+	Topic    string            `json:"topic,omitempty"`    // kafka only
+	Facility string            `json:"facility,omitempty"` // syslog only, local0 if empty
+	Streams  []string          `json:"streams,omitempty"`  // stdout and stderr if empty
+	Fields   map[string]string `json:"fields,omitempty"`   // attached to every line along with the metadata
+}
+
+func (l *LogShipping) Validate() error {
+	switch l.Sink {
+	case LogSinkSyslog:
+		network, addr := l.SyslogAddress()
+		if network != "udp" && network != "tcp" {
+			return fmt.Errorf("syslog address %q should be udp:// or tcp://", l.Address)
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("syslog address %q should be host:port", l.Address)
+		}
+	case LogSinkKafka, LogSinkHTTP:
+		u, err := url.Parse(l.Address)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s address %q should be an http or https URL", l.Sink, l.Address)
+		}
+	default:
+		return fmt.Errorf("unsupported sink %q, use %s, %s or %s", l.Sink, LogSinkSyslog, LogSinkKafka, LogSinkHTTP)
+	}
+	if (l.Sink == LogSinkKafka) != (l.Topic != "") {
+		return errors.New("a topic is needed for kafka and only for kafka")
+	}
+	if l.Facility != "" {
+		if l.Sink != LogSinkSyslog {
+			return errors.New("a facility is only for syslog")
+		}
+		if _, ok := SyslogFacilities[l.Facility]; !ok {
+			return fmt.Errorf("unknown syslog facility %q", l.Facility)
+		}
+	}
+	for _, stream := range l.Streams {
+		if stream != LogStreamStdout && stream != LogStreamStderr {
+			return fmt.Errorf("unknown stream %q, use %s or %s", stream, LogStreamStdout, LogStreamStderr)
+		}
+	}
+	for name := range l.Fields {
+		if !logFieldRegexp.MatchString(name) {
+			return fmt.Errorf("invalid field name %q", name)
+		}
+	}
+	return nil
+}
+
+// Returns the network and host:port of a syslog sink's address, udp if it names none
+func (l *LogShipping) SyslogAddress() (network, addr string) {
+	if i := strings.Index(l.Address, "://"); i >= 0 {
+		return l.Address[:i], l.Address[i+3:]
+	}
+	return "udp", l.Address
+}
+
+// Returns whether the container's stream is shipped
+func (l *LogShipping) Ships(stream string) bool {
+	if len(l.Streams) == 0 {
+		return true
+	}
+	for _, s := range l.Streams {
+		if s == stream {
+			return true
+		}
+	}
+	return false
+}
+
+// The mandatory access control profile a container is confined by. AppArmor names a profile already loaded on
+// the host (or "unconfined"); the SELinux fields set parts of the container's process label, the runtime picks
+// the parts left empty.
+type MACProfile struct {
+	AppArmor     string `json:"apparmor,omitempty"`
+	SELinuxUser  string `json:"selinuxUser,omitempty"`
+	SELinuxRole  string `json:"selinuxRole,omitempty"`
+	SELinuxType  string `json:"selinuxType,omitempty"`
+	SELinuxLevel string `json:"selinuxLevel,omitempty"` // e.g. s0:c100,c200
+}
+
+const AppArmorUnconfined = "unconfined"
+
+var (
+	appArmorProfileRegexp = regexp.MustCompile(`^[A-Za-z0-9_.\-/]+$`)
+	seLinuxNameRegexp     = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+	seLinuxLevelRegexp    = regexp.MustCompile(`^[A-Za-z0-9_.:,\-]+$`)
+)
+
+func (p *MACProfile) Validate() error {
+	if p.AppArmor == "" && !p.UsesSELinux() {
+		return errors.New("no AppArmor profile or SELinux label")
+	}
+	if p.AppArmor != "" && !appArmorProfileRegexp.MatchString(p.AppArmor) {
+		return fmt.Errorf("invalid AppArmor profile %q", p.AppArmor)
+	}
+	for _, name := range []string{p.SELinuxUser, p.SELinuxRole, p.SELinuxType} {
+		if name != "" && !seLinuxNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid SELinux label part %q", name)
+		}
+	}
+	if p.SELinuxLevel != "" && !seLinuxLevelRegexp.MatchString(p.SELinuxLevel) {
+		return fmt.Errorf("invalid SELinux level %q", p.SELinuxLevel)
+	}
+	return nil
+}
+
+// Returns whether the profile sets any part of an SELinux label
+func (p *MACProfile) UsesSELinux() bool {
+	return p.SELinuxUser != "" || p.SELinuxRole != "" || p.SELinuxType != "" || p.SELinuxLevel != ""
+}
+
+// The Linux capabilities, without their CAP_ prefix, as docker takes them
+var Capabilities = []string{"AUDIT_CONTROL", "AUDIT_READ", "AUDIT_WRITE", "BLOCK_SUSPEND", "BPF", "CHECKPOINT_RESTORE",
+	"CHOWN", "DAC_OVERRIDE", "DAC_READ_SEARCH", "FOWNER", "FSETID", "IPC_LOCK", "IPC_OWNER", "KILL", "LEASE",
+	"LINUX_IMMUTABLE", "MAC_ADMIN", "MAC_OVERRIDE", "MKNOD", "NET_ADMIN", "NET_BIND_SERVICE", "NET_BROADCAST",
+	"NET_RAW", "PERFMON", "SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYSLOG", "SYS_ADMIN", "SYS_BOOT",
+	"SYS_CHROOT", "SYS_MODULE", "SYS_NICE", "SYS_PACCT", "SYS_PTRACE", "SYS_RAWIO", "SYS_RESOURCE", "SYS_TIME",
+	"SYS_TTY_CONFIG", "WAKE_ALARM"}
+
+// Drops every capability, so a manifest can add back only the ones the app needs
+const CapabilityAll = "ALL"
+
+// Returns the capabilities upper cased, without a CAP_ prefix, sorted and without duplicates. ALL is only
+// allowed if all is set, a manifest can drop every capability but not add them.
+func NormalizeCapabilities(caps []string, all bool) ([]string, error) {
+	if len(caps) == 0 {
+		return nil, nil
+	}
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, cap := range caps {
+		name := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(cap)), "CAP_")
+		if !(all && name == CapabilityAll) && !isCapability(name) {
+			return nil, fmt.Errorf("unknown capability %q", cap)
+		}
+		if !seen[name] {
+			seen[name] = true
+			normalized = append(normalized, name)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
 }
 
-type Container struct {
-	ID             string
-	DockerID       string
-	IP             string
-	Pid            int
-	Host           string
-	PrimaryPort    uint16
-	SecondaryPorts []uint16
-	SSHPort        uint16
-	App            string
-	Sha            string
-	Env            string
-	Manifest       *Manifest
+func isCapability(name string) bool {
+	for _, cap := range Capabilities {
+		if name == cap {
+			return true
+		}
+	}
+	return false
 }
 
-func (c *Container) GetID() string {
-	return c.ID
+// Seccomp profiles every host has. Any other name is a custom profile the supervisor's host keeps in its seccomp
+// dir as <name>.json.
+const (
+	SeccompDefault    = "default" // the runtime's default profile, also used when the manifest names none
+	SeccompUnconfined = "unconfined"
+)
+
+var seccompProfileRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.\-]*$`)
+
+// Check that a seccomp profile name is safe to look up as a file
+func ValidateSeccomp(name string) error {
+	if name == "" || name == SeccompDefault || name == SeccompUnconfined {
+		return nil
+	}
+	if !seccompProfileRegexp.MatchString(name) {
+		return fmt.Errorf("invalid seccomp profile name %q", name)
+	}
+	return nil
 }
 
-func (c *Container) GetApp() string {
-	return c.App
+var (
+	secretRefRegexp  = regexp.MustCompile(`^([a-z0-9]+):([^#]+)#(.+)$`)
+	envNameRegexp    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	secretFileRegexp = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]*$`)
+)
+
+// A secret the supervisor resolves at deploy time. Ref is "<provider>:<path>#<key>", e.g.
+// vault:secret/data/myapp#password. The value is passed to the container as the env var Env and/or written to
+// File in the secrets dir of the container's config dir. Only the reference is ever stored, so the value never
+// shows up in the manifest or the saved containers. Prefer File, env vars are visible in docker inspect.
+type Secret struct {
+	Ref  string `json:"ref,omitempty"`
+	Env  string `json:"env,omitempty"`
+	File string `json:"file,omitempty"`
 }
 
-func (c *Container) GetSha() string {
-	return c.Sha
+// Splits Ref into its provider, path and key
+func (s *Secret) ParseRef() (provider, path, key string, err error) {
+	matches := secretRefRegexp.FindStringSubmatch(s.Ref)
+	if matches == nil {
+		return "", "", "", fmt.Errorf("secret reference %q should look like <provider>:<path>#<key>", s.Ref)
+	}
+	return matches[1], matches[2], matches[3], nil
 }
 
-func (c *Container) SetDockerID(id string) {
-	c.DockerID = id
+func (s *Secret) Validate() error {
+	if _, _, _, err := s.ParseRef(); err != nil {
+		return err
+	}
+	if s.Env == "" && s.File == "" {
+		return fmt.Errorf("secret %s should be injected as an env var or a file", s.Ref)
+	}
+	if s.Env != "" && !envNameRegexp.MatchString(s.Env) {
+		return fmt.Errorf("secret env var %q is not a valid name", s.Env)
+	}
+	if s.File != "" && !secretFileRegexp.MatchString(s.File) {
+		return fmt.Errorf("secret file %q should be a plain file name", s.File)
+	}
+	return nil
 }
 
-func (c *Container) GetDockerID() string {
-	return c.DockerID
+type DepsType map[string]*AppDep
+type AppDep struct {
+	SecurityGroup map[string][]uint16    `json:"securityGroup,omitempty"`
+	DataMap       map[string]interface{} `json:"dataMap,omitempty"`
+	EncryptedData string                 `json:"encryptedData,omitempty"`
 }
 
-func (c *Container) GetDockerRepo() string {
-	return "apps"
+// An additional named check declared in the manifest. The monitor runs Command inside the container alongside
+// the scripts found in its check dir. If either threshold is set, 0 included, the first field of the command's
+// output is compared against them; otherwise the exit code is used as the Nagios state (0 OK, 1 Warning,
+// 2 Critical). Interval is the minimum number of seconds between runs; 0 runs the check every time the monitor runs.
+type ManifestCheck struct {
+	Name     string   `json:"name,omitempty"`
+	Command  string   `json:"command,omitempty"`
+	Warning  *float64 `json:"warning,omitempty"`
+	Critical *float64 `json:"critical,omitempty"`
+	Interval uint     `json:"interval,omitempty"`
 }
 
-func (c *Container) SetIP(ip string) {
-	c.IP = ip
+type Manifest struct {
+	SchemaVersion uint              `json:"schemaVersion"` // 0 for manifests from before versioning
+	Name          string            `json:"name,omitempty"`
+	Description   string            `json:"description,omitempty"`
+	Instances     uint              `json:"instances,omitempty"`
+	CPUShares     uint              `json:"cpuShares,omitempty"`
+	MemoryLimit   uint              `json:"memoryLimit,omitempty"`
+	MemorySwap    uint              `json:"memorySwap,omitempty"` // MB of memory+swap, 0 to leave swap unlimited
+	DiskLimit     uint              `json:"diskLimit,omitempty"`  // MB of disk the container can write, 0 for no quota
+	GPUs          uint              `json:"gpus,omitempty"`
+	DedicatedCPUs uint              `json:"dedicatedCPUs,omitempty"` // cores pinned to the container, paid for in CPU shares instead of CPUShares
+	AppType       string            `json:"appType,omitempty"`
+	JavaType      string            `json:"javaType,omitempty"`
+	RunCommands   []string          `json:"runCommands"`
+	RunArgv       [][]string        `json:"runArgv,omitempty"` // run commands as argv arrays, executed without a shell
+	Env           map[string]string `json:"env,omitempty"`     // extra environment variables for the container
+	Deps          DepsType          `json:"deps"`
+	Checks        []ManifestCheck   `json:"checks,omitempty"`
+	CheckUser     string            `json:"checkUser,omitempty"` // user the monitor runs checks as inside the container, empty for its default
+	Readiness     *Probe            `json:"readiness,omitempty"`
+	Liveness      *Probe            `json:"liveness,omitempty"`
+	RestartPolicy *RestartPolicy    `json:"restartPolicy,omitempty"`
+	Ulimits       []Ulimit          `json:"ulimits,omitempty"`
+	Sysctls       map[string]string `json:"sysctls,omitempty"`
+	Volumes       []Volume          `json:"volumes,omitempty"`
+	Sidecars      []Sidecar         `json:"sidecars,omitempty"`
+	Ports         []PortSpec        `json:"ports,omitempty"`
+	NetworkMode   string            `json:"networkMode,omitempty"` // bridge (the default), host or the name of a docker network
+	ProxyPort     uint16            `json:"proxyPort,omitempty"`   // stable host port forwarded to the app's primary ports, 0 for none
+	TLS           *TLSTermination   `json:"tls,omitempty"`         // nil to serve the primary port without TLS
+	Egress        *EgressPolicy     `json:"egress,omitempty"`      // nil to leave egress to the supervisor
+	Network       *NetworkTuning    `json:"network,omitempty"`
+	Logging       *LogConfig        `json:"logging,omitempty"`
+	LogShipping   *LogShipping      `json:"logShipping,omitempty"` // nil to leave the logs where the driver puts them
+	Secrets       []Secret          `json:"secrets,omitempty"`
+	MACProfile    *MACProfile       `json:"macProfile,omitempty"`   // nil for the runtime's default confinement
+	Seccomp       string            `json:"seccomp,omitempty"`      // default, unconfined or a profile on the host
+	CapAdd        []string          `json:"capAdd,omitempty"`       // Linux capabilities on top of the runtime's defaults
+	CapDrop       []string          `json:"capDrop,omitempty"`      // Linux capabilities taken away, ALL for every one
+	ReadOnlyRoot  bool              `json:"readOnlyRoot,omitempty"` // writable are only volumes, tmpfs, logs and config
+	Tmpfs         []TmpfsMount      `json:"tmpfs,omitempty"`
+	// the [env.<name>] sections of the app's manifest, applied by ApplyEnv
+	EnvOverrides map[string]*ManifestOverride `json:"envOverrides,omitempty"`
 }
 
-func (c *Container) GetIP() string {
-	return c.IP
+// What an environment changes in a manifest, so staging and production can share one. Fields left at their
+// zero value keep the manifest's setting; Env is merged into the manifest's and RunCommands replaces its.
+type ManifestOverride struct {
+	MemoryLimit uint              `json:"memoryLimit,omitempty"`
+	Instances   uint              `json:"instances,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	RunCommands []string          `json:"runCommands,omitempty"`
 }
 
-func (c *Container) SetPid(pid int) {
-	c.Pid = pid
+func (o *ManifestOverride) Validate() error {
+	for name := range o.Env {
+		if !envNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+	}
+	return nil
 }
 
-func (c *Container) GetPid() int {
-	return c.Pid
+// Apply the override for env, if the manifest has one. The overrides are kept so applying them again, as a
+// redeploy of the manifest does, changes nothing.
+func (m *Manifest) ApplyEnv(env string) {
+	o := m.EnvOverrides[env]
+	if o == nil {
+		return
+	}
+	if o.MemoryLimit > 0 {
+		m.MemoryLimit = o.MemoryLimit
+	}
+	if o.Instances > 0 {
+		m.Instances = o.Instances
+	}
+	if len(o.Env) > 0 && m.Env == nil {
+		m.Env = make(map[string]string, len(o.Env))
+	}
+	for name, val := range o.Env {
+		m.Env[name] = val
+	}
+	if o.RunCommands != nil {
+		m.RunCommands = append([]string{}, o.RunCommands...)
+	}
 }
 
-func (c *Container) GetSSHPort() uint16 {
-	return c.SSHPort
+var templateRegexp = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+
+// Replace the ${NAME} placeholders in s with their values from vars. Placeholders that are not in vars are left
+// as they are so the shell can still expand them, except for ${deps.*} which must resolve. $${ is replaced
+// with a literal ${.
+func Interpolate(s string, vars map[string]string) (string, error) {
+	return interpolate(s, vars, func(val string) string { return val })
 }
 
-func (c *Container) RandomID() string {
-	return c.ID[strings.LastIndex(c.ID, "-")+1:]
+// Interpolate a command the shell will run. Values are quoted so each placeholder expands to exactly one word,
+// whatever characters the value has; a placeholder should therefore not be inside quotes itself.
+func InterpolateShell(s string, vars map[string]string) (string, error) {
+	return interpolate(s, vars, ShellQuote)
 }
 
-func (c *Container) String() string {
-	return fmt.Sprintf(`%s
-IP              : %s
-Pid             : %d
-Host            : %s
-Primary Port    : %d
-SSH Port        : %d
-Secondary Ports : %v
-App             : %s
-SHA             : %s
-CPU Shares      : %d
-Memory Limit    : %d
-Docker ID       : %s`, c.ID, c.IP, c.Pid, c.Host, c.PrimaryPort, c.SSHPort, c.SecondaryPorts, c.App, c.Sha,
-		c.Manifest.CPUShares, c.Manifest.MemoryLimit, c.DockerID)
+func interpolate(s string, vars map[string]string, quote func(string) string) (string, error) {
+	var err error
+	result := templateRegexp.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		name := match[2 : len(match)-1]
+		if val, ok := vars[name]; ok {
+			return quote(val)
+		}
+		if strings.HasPrefix(name, "deps.") && err == nil {
+			err = fmt.Errorf("unknown dependency data %s", name)
+		}
+		return match
+	})
+	return result, err
 }
 
-type DepsType map[string]*AppDep
-type AppDep struct {
-	SecurityGroup map[string][]uint16
-	DataMap       map[string]interface{}
-	EncryptedData string
+var shellSafeRegexp = regexp.MustCompile(`^[a-zA-Z0-9_@%+=:,./-]+$`)
+
+// Returns s as a single sh word, quoted only if it has to be
+func ShellQuote(s string) string {
+	if shellSafeRegexp.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
 }
 
-type Manifest struct {
-	Name        string
-	Description string
-	Instances   uint
-	CPUShares   uint
-	MemoryLimit uint
-	AppType     string
-	JavaType    string
-	RunCommands []string
-	Deps        DepsType
-}
-
-func (m *Manifest) Dup() *Manifest {
-	runCommands := make([]string, len(m.RunCommands))
-	for i, cmd := range m.RunCommands {
-		runCommands[i] = cmd
+// Check that a string-form run command is a single, complete line of sh: the runit script it ends up in would
+// run a second line as a command of its own and choke on an unterminated quote.
+func ValidateRunCommand(cmd string) error {
+	if strings.TrimSpace(cmd) == "" {
+		return errors.New("empty run command")
 	}
-	deps := DepsType{}
-	for key, val := range m.Deps {
-		deps[key] = &AppDep{
-			SecurityGroup: make(map[string][]uint16, len(val.SecurityGroup)),
-			DataMap:       map[string]interface{}{},
-		}
-		for ipGroupName, ports := range val.SecurityGroup {
-			if len(ports) == 0 {
-				continue
+	if strings.ContainsAny(cmd, "\x00\n\r") {
+		return fmt.Errorf("run command %q should be a single line without NUL bytes", cmd)
+	}
+	var quote rune
+	escaped := false
+	for _, c := range cmd {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
 			}
-			deps[key].SecurityGroup[ipGroupName] = make([]uint16, len(ports))
-			for i, port := range ports {
-				deps[key].SecurityGroup[ipGroupName][i] = port
+		case c == '\\':
+			escaped = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
 			}
+		case c == '\'' || c == '"':
+			quote = c
 		}
-		for innerKey, innerVal := range val.DataMap {
-			deps[key].DataMap[innerKey] = innerVal
+	}
+	if quote != 0 {
+		return fmt.Errorf("run command %q has an unterminated %c quote", cmd, quote)
+	}
+	if escaped {
+		return fmt.Errorf("run command %q ends in a backslash", cmd)
+	}
+	return nil
+}
+
+// Check an argv-form run command. Its arguments are passed as they are, so only NUL bytes, which can not be in
+// an argument, and a missing program are errors.
+func ValidateRunArgv(argv []string) error {
+	if len(argv) == 0 || argv[0] == "" {
+		return errors.New("run command without a program")
+	}
+	for _, arg := range argv {
+		if strings.Contains(arg, "\x00") {
+			return fmt.Errorf("argument %q of run command %s has a NUL byte", arg, argv[0])
 		}
-		deps[key].EncryptedData = val.EncryptedData
 	}
-	return &Manifest{
-		Name:        m.Name,
-		Description: m.Description,
-		Instances:   m.Instances,
-		CPUShares:   m.CPUShares,
-		MemoryLimit: m.MemoryLimit,
-		AppType:     m.AppType,
-		JavaType:    m.JavaType,
-		RunCommands: runCommands,
-		Deps:        deps,
+	return nil
+}
+
+// The manifest schema this supervisor understands. Bump it and add a migration whenever the meaning of an
+// existing field changes or a new field needs a default older manifests do not provide.
+const ManifestSchemaVersion = 1
+
+// manifestMigrations[i] upgrades a manifest from schema version i to i+1
+var manifestMigrations = []func(*Manifest) error{
+	// 0 -> 1: unversioned manifests could leave out deps and run commands entirely, or send nil deps
+	func(m *Manifest) error {
+		if m.Deps == nil {
+			m.Deps = DepsType{}
+		}
+		for name, dep := range m.Deps {
+			if dep == nil {
+				m.Deps[name] = &AppDep{}
+			}
+		}
+		if m.RunCommands == nil {
+			m.RunCommands = []string{}
+		}
+		return nil
+	},
+}
+
+// Upgrade the manifest in place to ManifestSchemaVersion. Manifests from a newer schema are rejected rather
+// than having the fields this supervisor does not know about silently dropped.
+func (m *Manifest) Migrate() error {
+	if m.SchemaVersion > ManifestSchemaVersion {
+		return fmt.Errorf("manifest schema version %d is newer than the supported version %d", m.SchemaVersion,
+			ManifestSchemaVersion)
+	}
+	for m.SchemaVersion < ManifestSchemaVersion {
+		if err := manifestMigrations[m.SchemaVersion](m); err != nil {
+			return fmt.Errorf("could not migrate manifest from schema version %d: %v", m.SchemaVersion, err)
+		}
+		m.SchemaVersion++
+	}
+	return nil
+}
+
+// Manifests always carry deps and run commands, as {} and [] when there are none, so tooling reading them never
+// has to tell null apart from empty. The schema version is always written too.
+func (m Manifest) MarshalJSON() ([]byte, error) {
+	type plain Manifest // without the MarshalJSON method
+	if m.Deps == nil {
+		m.Deps = DepsType{}
 	}
+	if m.RunCommands == nil {
+		m.RunCommands = []string{}
+	}
+	return json.Marshal(plain(m))
 }
 
 func CreateManifest(mt *manifest.Data) (*Manifest, error) {
@@ -174,6 +1502,7 @@ func CreateManifest(mt *manifest.Data) (*Manifest, error) {
 		deps[name] = &AppDep{} // set it here so we can check for it in DepNames()
 	}
 	var cmds []string
+	var argvs [][]string
 	if len(mt.RunCommands) > 0 {
 		cmds = make([]string, len(mt.RunCommands))
 		for i, cmd := range mt.RunCommands {
@@ -186,18 +1515,31 @@ func CreateManifest(mt *manifest.Data) (*Manifest, error) {
 		case []interface{}:
 			cmds = []string{}
 			for _, cmd := range runCommand {
-				cmdStr, ok := cmd.(string)
-				if ok {
-					cmds = append(cmds, cmdStr)
-				} else {
-					return nil, errors.New("Invalid Manifest: non-string element in run_command array!")
+				switch cmd := cmd.(type) {
+				case string:
+					cmds = append(cmds, cmd)
+				case []interface{}:
+					// an argv array
+					argv := make([]string, len(cmd))
+					for i, arg := range cmd {
+						argStr, ok := arg.(string)
+						if !ok {
+							return nil, errors.New("Invalid Manifest: non-string argument in run_command argv array!")
+						}
+						argv[i] = argStr
+					}
+					argvs = append(argvs, argv)
+				default:
+					return nil, errors.New("Invalid Manifest: run_command array elements should be strings or " +
+						"argv arrays")
 				}
 			}
 		default:
-			return nil, errors.New("Invalid Manifest: run_command should be string or []string")
+			return nil, errors.New("Invalid Manifest: run_command should be string, []string or argv arrays")
 		}
 	}
-	return &Manifest{
+	// builder manifests are unversioned
+	m := &Manifest{
 		Name:        mt.Name,
 		Description: mt.Description,
 		Instances:   0,
@@ -206,8 +1548,13 @@ func CreateManifest(mt *manifest.Data) (*Manifest, error) {
 		AppType:     mt.AppType,
 		JavaType:    mt.JavaType,
 		RunCommands: cmds,
+		RunArgv:     argvs,
 		Deps:        deps,
-	}, nil
+	}
+	if err := m.Migrate(); err != nil {
+		return nil, errors.New("Invalid Manifest: " + err.Error())
+	}
+	return m, nil
 }
 
 func (m *Manifest) DepNames() []string {
@@ -224,128 +1571,663 @@ func (m *Manifest) DepNames() []string {
 // Supervisor RPC Types
 // ----------------------------------------------------------------------------------------------------------
 
+// Identifies the caller when the supervisor has an RBAC file. Every RPC arg embeds it, except those of Version and
+// Idle which anybody may call.
+type SupervisorAuthArg struct {
+	Token string `json:"token,omitempty"`
+}
+
+// Lets clients set the token on any arg, through a pointer to it
+func (a *SupervisorAuthArg) SetToken(token string) {
+	a.Token = token
+}
+
+// ------------ Config ------------
+// Used to get the configuration the supervisor is running with
+type SupervisorConfigArg struct {
+	SupervisorAuthArg
+}
+
+type SupervisorConfigReply struct {
+	Config   string    `json:"config,omitempty"`   // TOML, secrets redacted
+	LoadedAt time.Time `json:"loadedAt,omitempty"` // when the config was loaded, at startup or by the last SIGHUP
+}
+
+// ------------ Supervisor Version ------------
+// Used to get the supervisor's build information and the API level it supports
+type SupervisorVersionArg struct {
+	SupervisorAuthArg
+}
+
+type SupervisorVersionReply struct {
+	Version    string `json:"version,omitempty"`
+	GitSha     string `json:"gitSha,omitempty"`
+	BuildDate  string `json:"buildDate,omitempty"`
+	RPCVersion string `json:"rpcVersion,omitempty"`
+	APILevel   int    `json:"apiLevel,omitempty"` // see SupervisorAPILevel
+}
+
 // ------------ Health Check ------------
 // Used to check the health and stats of Supervisor
 type SupervisorHealthCheckArg struct {
+	SupervisorAuthArg
 }
 
 type ResourceStats struct {
-	Total uint
-	Used  uint
-	Free  uint
+	Total uint `json:"total,omitempty"`
+	Used  uint `json:"used,omitempty"`
+	Free  uint `json:"free,omitempty"`
 }
 
 type SupervisorHealthCheckReply struct {
-	Containers *ResourceStats
-	CPUShares  *ResourceStats
-	Memory     *ResourceStats
-	Price      float64
-	Region     string
-	Zone       string
-	Status     string
+	Containers       *ResourceStats `json:"containers,omitempty"`
+	CPUShares        *ResourceStats `json:"cpuShares,omitempty"`    // what containers can reserve, including overcommit
+	Memory           *ResourceStats `json:"memory,omitempty"`       // what containers can reserve, including overcommit
+	RawCPUShares     *ResourceStats `json:"rawCPUShares,omitempty"` // physical, free is 0 if overcommitted
+	RawMemory        *ResourceStats `json:"rawMemory,omitempty"`    // physical, free is 0 if overcommitted
+	CPUOvercommit    float64        `json:"cpuOvercommit,omitempty"`
+	MemoryOvercommit float64        `json:"memoryOvercommit,omitempty"`
+	GPUs             *ResourceStats `json:"gpus,omitempty"`
+	CPUs             *ResourceStats `json:"cpus,omitempty"` // cores that can be dedicated to containers
+	Disk             *ResourceStats `json:"disk,omitempty"` // MB reserved by container disk quotas
+	CgroupVersion    int            `json:"cgroupVersion,omitempty"`
+	Runtime          string         `json:"runtime,omitempty"`        // docker or podman, and whether it is rootless
+	RuntimeVersion   string         `json:"runtimeVersion,omitempty"` // empty if the daemon could not be asked
+	APIVersion       string         `json:"apiVersion,omitempty"`     // docker API version negotiated with the daemon
+	AppArmor         bool           `json:"apparmor,omitempty"`       // whether the host enforces AppArmor profiles
+	SELinux          bool           `json:"selinux,omitempty"`        // whether the host enforces SELinux labels
+	Price            float64        `json:"price,omitempty"`
+	Region           string         `json:"region,omitempty"`
+	Zone             string         `json:"zone,omitempty"`
+	Status           string         `json:"status,omitempty"`
+	// app -> sha -> containers on the host
+	Instances map[string]map[string]uint `json:"instances,omitempty"`
 }
 
 // ------------ Deploy ------------
 // Used to deploy a new app/sha
+// A manifest as the build pipeline wrote it, with a detached signature over exactly those bytes. The json is sent
+// as is, so nothing can change the manifest between signing and verifying.
+type SignedManifest struct {
+	JSON      []byte `json:"json,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// Returns the manifest in the json, without checking the signature
+func (s *SignedManifest) Manifest() (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.Unmarshal(s.JSON, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 type SupervisorDeployArg struct {
-	Host        string
-	App         string
-	Sha         string
-	Env         string
-	ContainerID string
-	Manifest    *Manifest
+	SupervisorAuthArg
+	Host         string            `json:"host,omitempty"`
+	App          string            `json:"app,omitempty"`
+	Sha          string            `json:"sha,omitempty"`
+	Env          string            `json:"env,omitempty"`
+	ContainerID  string            `json:"containerID,omitempty"`
+	Manifest     *Manifest         `json:"manifest,omitempty"`
+	Signed       *SignedManifest   `json:"signed,omitempty"` // instead of Manifest, on supervisors that verify them
+	Labels       map[string]string `json:"labels,omitempty"`
+	Registry     *Registry         `json:"registry,omitempty"`     // overrides the supervisor's registry
+	RegistryAuth *RegistryAuth     `json:"registryAuth,omitempty"` // credentials for Registry, or for the supervisor's registry if it is nil
+	TTL          uint              `json:"ttl,omitempty"`          // seconds until the container is torn down automatically, 0 for never
+	NetworkOf    string            `json:"networkOf,omitempty"`    // id of a container whose network namespace to join, empty for a new one
 }
 
 type SupervisorDeployReply struct {
-	Status    string
-	Container *Container
+	Status    string     `json:"status,omitempty"`
+	Container *Container `json:"container,omitempty"`
+}
+
+// ------------ Redeploy ------------
+// Used to replace a container with one running a new sha of its app. The new container comes up next to the old
+// one on its own ports; once it is ready the old one is put in maintenance, drained and torn down.
+type SupervisorRedeployArg struct {
+	SupervisorAuthArg
+	ContainerID    string            `json:"containerID,omitempty"` // the container to replace
+	NewContainerID string            `json:"newContainerID,omitempty"`
+	Sha            string            `json:"sha,omitempty"`
+	Manifest       *Manifest         `json:"manifest,omitempty"`     // defaults to the old container's
+	Signed         *SignedManifest   `json:"signed,omitempty"`       // instead of Manifest
+	Labels         map[string]string `json:"labels,omitempty"`       // defaults to the old container's
+	RegistryAuth   *RegistryAuth     `json:"registryAuth,omitempty"` // credentials for the old container's registry, if it needs other than the supervisor's
+	ReadyTimeout   uint              `json:"readyTimeout,omitempty"` // seconds to wait for the new container to be ready, 0 for the default
+	DrainTime      uint              `json:"drainTime,omitempty"`    // seconds between putting the old container in maintenance and tearing it down
+}
+
+type SupervisorRedeployReply struct {
+	Status       string     `json:"status,omitempty"`
+	Container    *Container `json:"container,omitempty"`    // the new container
+	OldContainer *Container `json:"oldContainer,omitempty"` // as it was before it was torn down
+}
+
+// ------------ Rollback ------------
+// Used to redeploy the release a container replaced, e.g. to revert a bad redeploy. Works like a redeploy of the
+// previous sha, manifest and labels.
+type SupervisorRollbackArg struct {
+	SupervisorAuthArg
+	ContainerID    string        `json:"containerID,omitempty"`
+	NewContainerID string        `json:"newContainerID,omitempty"`
+	RegistryAuth   *RegistryAuth `json:"registryAuth,omitempty"` // credentials for the previous release's registry, if it needs other than the supervisor's
+	ReadyTimeout   uint          `json:"readyTimeout,omitempty"` // seconds to wait for the new container to be ready, 0 for the default
+	DrainTime      uint          `json:"drainTime,omitempty"`    // seconds between putting the container in maintenance and tearing it down
+}
+
+type SupervisorRollbackReply struct {
+	Status       string     `json:"status,omitempty"`
+	Container    *Container `json:"container,omitempty"`    // running the previous release
+	OldContainer *Container `json:"oldContainer,omitempty"` // as it was before it was torn down
+}
+
+// ------------ DiffManifest ------------
+// Used to show what a redeploy with a new manifest would change in a container's, without deploying anything
+type SupervisorDiffManifestArg struct {
+	SupervisorAuthArg
+	ContainerID string    `json:"containerID,omitempty"`
+	Manifest    *Manifest `json:"manifest,omitempty"` // the proposed manifest, before the container's env overrides
+}
+
+type SupervisorDiffManifestReply struct {
+	Status  string           `json:"status,omitempty"`
+	Changes []ManifestChange `json:"changes"` // empty if the manifests are the same
+}
+
+// ------------ Checkpoint ------------
+// Used to save the state of a running container with CRIU (experimental), e.g. before host maintenance, so it can
+// be restored instead of warming up from scratch
+type SupervisorCheckpointArg struct {
+	SupervisorAuthArg
+	ContainerID string `json:"containerID,omitempty"`
+	Name        string `json:"name,omitempty"` // defaults to one named after the current time
+	Exit        bool   `json:"exit,omitempty"` // stop the container once it is checkpointed
+}
+
+type SupervisorCheckpointReply struct {
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// ------------ Restore Checkpoint ------------
+// Used to start a container stopped by a checkpoint from it
+type SupervisorRestoreCheckpointArg struct {
+	SupervisorAuthArg
+	ContainerID string `json:"containerID,omitempty"`
+	Name        string `json:"name,omitempty"` // defaults to the container's last checkpoint
+}
+
+type SupervisorRestoreCheckpointReply struct {
+	Container *Container `json:"container,omitempty"`
+	Status    string     `json:"status,omitempty"`
 }
 
 // ------------ Teardown ------------
 // Used to teardown a container
 type SupervisorTeardownArg struct {
-	ContainerIDs []string
-	All          bool
+	SupervisorAuthArg
+	ContainerIDs []string          `json:"containerIDs,omitempty"`
+	All          bool              `json:"all,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"` // tear down every container with these labels
 }
 
 type SupervisorTeardownReply struct {
-	ContainerIDs []string
-	Status       string
+	ContainerIDs []string `json:"containerIDs,omitempty"`
+	Status       string   `json:"status,omitempty"`
+}
+
+// ------------ Resize Container ------------
+// Used to change the resources of a running container without redeploying it
+type SupervisorResizeContainerArg struct {
+	SupervisorAuthArg
+	ContainerID string `json:"containerID,omitempty"`
+	CPUShares   uint   `json:"cpuShares,omitempty"`   // 0 to leave as it is
+	MemoryLimit uint   `json:"memoryLimit,omitempty"` // MB, 0 to leave as it is
+}
+
+type SupervisorResizeContainerReply struct {
+	Container *Container `json:"container,omitempty"`
+	Status    string     `json:"status,omitempty"`
 }
 
 // ------------ Get ------------
 // Used to get a container
 type SupervisorGetArg struct {
-	ContainerID string
+	SupervisorAuthArg
+	ContainerID string `json:"containerID,omitempty"`
+}
+
+// What a container is actually using, read from its cgroup. CPUShares is converted back from cpu.weight on
+// cgroup v2.
+type ResourceUsage struct {
+	CgroupVersion int           `json:"cgroupVersion,omitempty"`
+	CPUShares     uint64        `json:"cpuShares,omitempty"`
+	CPUTime       time.Duration `json:"cpuTime,omitempty"`
+	MemoryBytes   uint64        `json:"memoryBytes,omitempty"`
+	MemoryLimit   uint64        `json:"memoryLimit,omitempty"` // 0 if unlimited
 }
 
 type SupervisorGetReply struct {
-	Container *Container
-	Status    string
+	Container *Container     `json:"container,omitempty"`
+	Usage     *ResourceUsage `json:"usage,omitempty"` // nil if the container's cgroup could not be read
+	Status    string         `json:"status,omitempty"`
 }
 
 // ------------ List ------------
 // List Supervisor Containers
 type SupervisorListArg struct {
+	SupervisorAuthArg
+	Labels map[string]string `json:"labels,omitempty"` // only list containers with these labels
 }
 
 type SupervisorListReply struct {
-	Containers  map[string]*Container
-	UnusedPorts []uint16
+	Containers  map[string]*Container      `json:"containers,omitempty"`
+	UnusedPorts []uint16                   `json:"unusedPorts,omitempty"`
+	PortPools   *PortPools                 `json:"portPools,omitempty"`
+	Instances   map[string]map[string]uint `json:"instances,omitempty"` // app -> sha -> listed containers
+}
+
+// An inclusive range of host ports
+type PortRange struct {
+	Min uint16 `toml:"min" json:"min,omitempty"`
+	Max uint16 `toml:"max" json:"max,omitempty"`
+}
+
+func (r PortRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// The host ports containers are given. Excluded ports are skipped, so a range can hold more ports than the pool.
+type PortPools struct {
+	Primary   PortRange `json:"primary,omitempty"`
+	SSH       PortRange `json:"ssh,omitempty"`
+	Secondary PortRange `json:"secondary,omitempty"`
+	Excluded  []uint16  `json:"excluded,omitempty"`
 }
 
 // ------------ Authorize SSH ------------
 // Authorize SSH
 type SupervisorAuthorizeSSHArg struct {
-	ContainerID string
-	User        string
-	PublicKey   string
+	SupervisorAuthArg
+	ContainerID string `json:"containerID,omitempty"`
+	User        string `json:"user,omitempty"`
+	PublicKey   string `json:"publicKey,omitempty"`
+	Requester   string `json:"requester,omitempty"` // who asked for the access, for the audit trail
 }
 
 type SupervisorAuthorizeSSHReply struct {
-	Port   uint16
-	Status string
+	Port        uint16   `json:"port,omitempty"`
+	Certificate *SSHCert `json:"certificate,omitempty"` // set if the supervisor has an SSH CA, log in with it and the key
+	Status      string   `json:"status,omitempty"`
+}
+
+// A short-lived certificate the supervisor's SSH CA signed for a user's key. It lets the key log in as root to the
+// one container it was issued for until ValidBefore, or until it is revoked.
+type SSHCert struct {
+	ContainerID string    `json:"containerID,omitempty"`
+	User        string    `json:"user,omitempty"`
+	KeyID       string    `json:"keyID,omitempty"`
+	Serial      uint64    `json:"serial,omitempty"`
+	ValidBefore time.Time `json:"validBefore,omitempty"`
+	Revoked     bool      `json:"revoked,omitempty"`
+	Certificate string    `json:"certificate,omitempty"` // in authorized_keys format, as ssh-keygen writes it
 }
 
 // ------------ Deauthorize SSH ------------
 // Deauthorize SSH
 type SupervisorDeauthorizeSSHArg struct {
-	ContainerID string
-	User        string
+	SupervisorAuthArg
+	ContainerID string `json:"containerID,omitempty"`
+	User        string `json:"user,omitempty"`
+	Requester   string `json:"requester,omitempty"`
 }
 
 type SupervisorDeauthorizeSSHReply struct {
-	Status string
+	Status string `json:"status,omitempty"`
+}
+
+// ------------ SSH Audit ------------
+// Who was given or lost ssh access to containers, and when
+
+const (
+	SSHAccessAuthorized   = "authorize"
+	SSHAccessDeauthorized = "deauthorize"
+)
+
+type SSHAccessRecord struct {
+	Time        time.Time `json:"time,omitempty"`
+	ContainerID string    `json:"containerID,omitempty"`
+	Action      string    `json:"action,omitempty"`
+	User        string    `json:"user,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"` // SHA256 of the authorized key, empty for deauthorizations
+	Requester   string    `json:"requester,omitempty"`
+	ValidBefore time.Time `json:"validBefore,omitempty"` // when the access runs out on its own, if it was a certificate
+}
+
+func (r *SSHAccessRecord) String() string {
+	str := fmt.Sprintf("%s %s %s %s", r.Time.Format(time.RFC3339), r.ContainerID, r.Action, r.User)
+	if r.Fingerprint != "" {
+		str += " " + r.Fingerprint
+	}
+	if !r.ValidBefore.IsZero() {
+		str += " until " + r.ValidBefore.Format(time.RFC3339)
+	}
+	if r.Requester != "" {
+		str += " by " + r.Requester
+	}
+	return str
+}
+
+// A stretch of time a user's key could log in to a container
+type SSHAccessGrant struct {
+	ContainerID string    `json:"containerID,omitempty"`
+	User        string    `json:"user,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Requester   string    `json:"requester,omitempty"`
+	From        time.Time `json:"from,omitempty"`
+	Until       time.Time `json:"until,omitempty"` // zero if the access has not ended
+}
+
+func (g *SSHAccessGrant) String() string {
+	until := "now"
+	if !g.Until.IsZero() {
+		until = g.Until.Format(time.RFC3339)
+	}
+	str := fmt.Sprintf("%s %s %s from %s until %s", g.ContainerID, g.User, g.Fingerprint,
+		g.From.Format(time.RFC3339), until)
+	if g.Requester != "" {
+		str += " by " + g.Requester
+	}
+	return str
+}
+
+type SupervisorSSHAuditArg struct {
+	SupervisorAuthArg
+	ContainerID string    `json:"containerID,omitempty"` // only this container if set
+	User        string    `json:"user,omitempty"`        // only this user if set
+	Since       time.Time `json:"since,omitempty"`       // zero for the start of the trail
+	Until       time.Time `json:"until,omitempty"`       // zero for now
+}
+
+type SupervisorSSHAuditReply struct {
+	Status  string             `json:"status,omitempty"`
+	Records []*SSHAccessRecord `json:"records,omitempty"` // made between Since and Until, oldest first
+	Grants  []*SSHAccessGrant  `json:"grants,omitempty"`  // access held at any time between Since and Until
 }
 
 // ------------ Update IP Group ------------
 type SupervisorUpdateIPGroupArg struct {
-	Name string
-	IPs  []string
+	SupervisorAuthArg
+	Name string   `json:"name,omitempty"`
+	IPs  []string `json:"ips,omitempty"`
 }
 
 type SupervisorUpdateIPGroupReply struct {
-	Status string
+	Status string `json:"status,omitempty"`
 }
 
 // ------------ Delete IP Group ------------
 type SupervisorDeleteIPGroupArg struct {
-	Name string
+	SupervisorAuthArg
+	Name string `json:"name,omitempty"`
 }
 
 type SupervisorDeleteIPGroupReply struct {
-	Status string
+	Status string `json:"status,omitempty"`
+}
+
+// ------------ Network Rules ------------
+// List the firewall rules network security set up, for one container or all of them
+type SupervisorNetworkRulesArg struct {
+	SupervisorAuthArg
+	ContainerID string `json:"containerID,omitempty"` // only the rules of this container if set
+}
+
+type SupervisorNetworkRulesReply struct {
+	Status         string              `json:"status,omitempty"`
+	HostRules      []string            `json:"hostRules,omitempty"`      // shared by all containers
+	ContainerRules map[string][]string `json:"containerRules,omitempty"` // container id -> rules
 }
 
 // ------------ Container Maintenance ------------
 // Set Container Maintenance Mode
 type SupervisorContainerMaintenanceArg struct {
-	ContainerID string
-	Maintenance bool
+	SupervisorAuthArg
+	ContainerID string `json:"containerID,omitempty"`
+	Maintenance bool   `json:"maintenance,omitempty"`
 }
 
 type SupervisorContainerMaintenanceReply struct {
-	Status string
+	Status string `json:"status,omitempty"`
+}
+
+// ------------ Maintenance ------------
+// Put the whole supervisor in or out of maintenance, during which only RPCs that allow it are run
+type SupervisorMaintenanceArg struct {
+	SupervisorAuthArg
+	Maintenance bool   `json:"maintenance,omitempty"`
+	Reason      string `json:"reason,omitempty"` // kept in the maintenance file for other operators
+}
+
+type SupervisorMaintenanceReply struct {
+	Status string `json:"status,omitempty"`
+	File   string `json:"file,omitempty"` // the supervisor picks the change up the next time it checks this file
+}
+
+// ------------ PrefetchImage ------------
+// Pull the image of an app+sha ahead of deploying it
+type SupervisorPrefetchImageArg struct {
+	SupervisorAuthArg
+	App          string        `json:"app,omitempty"`
+	Sha          string        `json:"sha,omitempty"`
+	Registry     *Registry     `json:"registry,omitempty"`
+	RegistryAuth *RegistryAuth `json:"registryAuth,omitempty"`
+}
+
+type SupervisorPrefetchImageReply struct {
+	Status string `json:"status,omitempty"`
+	Image  string `json:"image,omitempty"`
+}
+
+// ------------ ImageGC ------------
+// Remove images that are not used by any container
+type SupervisorImageGCArg struct {
+	SupervisorAuthArg
+	Retention string `json:"retention,omitempty"` // keep images pulled within this duration, defaults to the supervisor's image retention
+	DryRun    bool   `json:"dryRun,omitempty"`
+}
+
+type SupervisorImageGCReply struct {
+	Status  string   `json:"status,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// ------------ Backup ------------
+// Export the container, port and resource state of the supervisor
+const StateBackupVersion = 1
+
+// Everything needed to rebuild a supervisor's state. The free ports are indexes into the primary port pool (offsets
+// from MinPort with the default pools), the same as they are saved in the state store.
+type StateBackup struct {
+	Version           int                   `json:"version,omitempty"`
+	NumContainers     uint16                `json:"numContainers,omitempty"`
+	NumSecondaryPorts uint16                `json:"numSecondaryPorts,omitempty"`
+	MinPort           uint16                `json:"minPort,omitempty"`
+	CPUShares         uint                  `json:"cpuShares,omitempty"`
+	MemoryLimit       uint                  `json:"memoryLimit,omitempty"`
+	NumGPUs           uint                  `json:"numGPUs,omitempty"`
+	PinnableCPUs      []uint                `json:"pinnableCPUs,omitempty"`
+	SharesPerCPU      uint                  `json:"sharesPerCPU,omitempty"`
+	Containers        map[string]*Container `json:"containers,omitempty"`
+	FreePorts         []uint16              `json:"freePorts,omitempty"`
+}
+
+type SupervisorBackupArg struct {
+	SupervisorAuthArg
+}
+
+type SupervisorBackupReply struct {
+	Status string `json:"status,omitempty"`
+	Backup []byte `json:"backup,omitempty"` // StateBackup as json
+}
+
+// ------------ Restore ------------
+// Import a backup into an empty supervisor and bring its containers back up
+type SupervisorRestoreArg struct {
+	SupervisorAuthArg
+	Backup []byte `json:"backup,omitempty"`
+}
+
+type SupervisorRestoreReply struct {
+	Status       string            `json:"status,omitempty"`
+	ContainerIDs []string          `json:"containerIDs,omitempty"` // containers that are back up
+	Errors       map[string]string `json:"errors,omitempty"`       // containers that were restored but could not be brought back up
+}
+
+// ------------ Export State ------------
+// Dump the saved containers as indented json, for editing by hand
+type SupervisorExportStateArg struct {
+	SupervisorAuthArg
+}
+
+type SupervisorExportStateReply struct {
+	Status string `json:"status,omitempty"`
+	State  []byte `json:"state,omitempty"` // map of container id -> Container as json
+}
+
+// ------------ Import State ------------
+// Replace the saved records of the containers in the json. A null record drops the container from the supervisor
+// without tearing it down.
+type SupervisorImportStateArg struct {
+	SupervisorAuthArg
+	State []byte `json:"state,omitempty"`
+}
+
+type SupervisorImportStateReply struct {
+	Status       string   `json:"status,omitempty"`
+	ContainerIDs []string `json:"containerIDs,omitempty"`
+}
+
+// ------------ List State Snapshots ------------
+// List the saved versions of the container state, oldest first
+type StateSnapshot struct {
+	ID         string    `json:"id,omitempty"`
+	Time       time.Time `json:"time,omitempty"`
+	Containers int       `json:"containers,omitempty"`
+}
+
+type SupervisorListStateSnapshotsArg struct {
+	SupervisorAuthArg
+}
+
+type SupervisorListStateSnapshotsReply struct {
+	Status    string           `json:"status,omitempty"`
+	Snapshots []*StateSnapshot `json:"snapshots,omitempty"`
+}
+
+// ------------ Rollback State ------------
+// Replace the saved containers and ports with a snapshot. Docker containers are left alone.
+type SupervisorRollbackStateArg struct {
+	SupervisorAuthArg
+	SnapshotID string `json:"snapshotID,omitempty"`
+}
+
+type SupervisorRollbackStateReply struct {
+	Status       string   `json:"status,omitempty"`
+	ContainerIDs []string `json:"containerIDs,omitempty"` // containers in the snapshot
+}
+
+// ------------ List Events ------------
+// Things that happened to containers without anyone asking for them
+const (
+	EventExited        = "exited"
+	EventRestarted     = "restarted"
+	EventRestartFailed = "restart_failed"
+	EventGaveUp        = "gave_up" // exited and the restart policy says to leave it
+	EventRemoved       = "removed" // the docker container was removed out from under the supervisor
+	EventCheckpointed  = "checkpointed"
+	EventRestored      = "restored" // from a checkpoint
+	EventExpired       = "expired"  // torn down because its TTL ran out
+)
+
+type ContainerEvent struct {
+	Time        time.Time `json:"time,omitempty"`
+	ContainerID string    `json:"containerID,omitempty"`
+	Type        string    `json:"type,omitempty"`
+	Message     string    `json:"message,omitempty"`
+}
+
+func (e *ContainerEvent) String() string {
+	return fmt.Sprintf("%s %s %s: %s", e.Time.Format(time.RFC3339), e.ContainerID, e.Type, e.Message)
+}
+
+type SupervisorListEventsArg struct {
+	SupervisorAuthArg
+	ContainerID string    `json:"containerID,omitempty"` // only events of this container if set
+	Since       time.Time `json:"since,omitempty"`       // only events after this if set
+}
+
+type SupervisorListEventsReply struct {
+	Status string            `json:"status,omitempty"`
+	Events []*ContainerEvent `json:"events,omitempty"` // oldest first
+}
+
+// ------------ Stats History ------------
+// A sample of a container's resource usage. CPUTime and the network counters count from when the container last
+// started; CPUCores is the average number of cores it used since the previous sample.
+type StatsSample struct {
+	Time        time.Time     `json:"time,omitempty"`
+	CPUTime     time.Duration `json:"cpuTime,omitempty"`
+	CPUCores    float64       `json:"cpuCores,omitempty"`
+	MemoryBytes uint64        `json:"memoryBytes,omitempty"`
+	RxBytes     uint64        `json:"rxBytes,omitempty"`
+	TxBytes     uint64        `json:"txBytes,omitempty"`
+}
+
+func (s *StatsSample) String() string {
+	return fmt.Sprintf("%s cpu %.2f cores memory %d MB rx %d tx %d", s.Time.Format(time.RFC3339), s.CPUCores,
+		s.MemoryBytes/(1024*1024), s.RxBytes, s.TxBytes)
+}
+
+type SupervisorStatsHistoryArg struct {
+	SupervisorAuthArg
+	ContainerID string    `json:"containerID,omitempty"` // only the samples of this container if set
+	Since       time.Time `json:"since,omitempty"`       // zero for the oldest sample kept
+	Until       time.Time `json:"until,omitempty"`       // zero for the newest
+}
+
+type SupervisorStatsHistoryReply struct {
+	Status  string                    `json:"status,omitempty"`
+	Samples map[string][]*StatsSample `json:"samples,omitempty"` // container id -> samples, oldest first
+}
+
+// ------------ Logs ------------
+// Get the output the supervisor captured of a container
+
+// A line a container wrote to stdout or stderr
+type LogLine struct {
+	Time    time.Time `json:"time"`
+	Stream  string    `json:"stream"`
+	Message string    `json:"message"`
+}
+
+func (l *LogLine) String() string {
+	return fmt.Sprintf("%s %s %s", l.Time.Format(time.RFC3339Nano), l.Stream, l.Message)
+}
+
+type SupervisorLogsArg struct {
+	SupervisorAuthArg
+	ContainerID string    `json:"containerID,omitempty"`
+	Stream      string    `json:"stream,omitempty"` // stdout or stderr, both if empty
+	Since       time.Time `json:"since,omitempty"`  // zero for the oldest line kept
+	Lines       uint      `json:"lines,omitempty"`  // the last this many lines, 0 for the default
+}
+
+type SupervisorLogsReply struct {
+	Status string     `json:"status,omitempty"`
+	Lines  []*LogLine `json:"lines,omitempty"` // oldest first
 }
 
 // ------------ Idle ------------
@@ -354,6 +2236,6 @@ type SupervisorIdleArg struct {
 }
 
 type SupervisorIdleReply struct {
-	Idle   bool
-	Status string
+	Idle   bool   `json:"idle,omitempty"`
+	Status string `json:"status,omitempty"`
 }
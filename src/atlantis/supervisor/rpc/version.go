@@ -14,6 +14,7 @@ package rpc
 import (
 	. "atlantis/common"
 	. "atlantis/supervisor/constant"
+	. "atlantis/supervisor/rpc/types"
 )
 
 // Return the current RPC Version
@@ -50,3 +51,42 @@ func (e *VersionExecutor) Execute(t *Task) error {
 func (ih *Supervisor) Version(arg VersionArg, reply *VersionReply) error {
 	return NewTask("Version", &VersionExecutor{arg, reply}).Run()
 }
+
+// Return the supervisor's build information and API level
+type SupervisorVersionExecutor struct {
+	arg   SupervisorVersionArg
+	reply *SupervisorVersionReply
+}
+
+func (e *SupervisorVersionExecutor) Request() interface{} {
+	return e.arg
+}
+
+func (e *SupervisorVersionExecutor) Result() interface{} {
+	return e.reply
+}
+
+func (e *SupervisorVersionExecutor) Description() string {
+	return "SupervisorVersion"
+}
+
+func (e *SupervisorVersionExecutor) Authorize() error {
+	return authorize("SupervisorVersion", &e.arg.SupervisorAuthArg)
+}
+
+func (e *SupervisorVersionExecutor) AllowDuringMaintenance() bool {
+	return true
+}
+
+func (e *SupervisorVersionExecutor) Execute(t *Task) error {
+	e.reply.Version = SupervisorVersion
+	e.reply.GitSha = SupervisorGitSha
+	e.reply.BuildDate = SupervisorBuildDate
+	e.reply.RPCVersion = SupervisorRPCVersion
+	e.reply.APILevel = SupervisorAPILevel
+	return nil
+}
+
+func (ih *Supervisor) SupervisorVersion(arg SupervisorVersionArg, reply *SupervisorVersionReply) error {
+	return NewTask("SupervisorVersion", &SupervisorVersionExecutor{arg, reply}).Run()
+}
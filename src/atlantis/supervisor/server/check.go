@@ -0,0 +1,231 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package server
+
+import (
+	"atlantis/supervisor/containers"
+	"atlantis/supervisor/containers/serialize"
+	scrypto "atlantis/supervisor/crypto"
+	"atlantis/supervisor/discovery"
+	"atlantis/supervisor/dns"
+	"atlantis/supervisor/docker"
+	"crypto/tls"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Validate the config file and command line options, printing each problem and what to do about it. Meant for
+// deployment pipelines to run before restarting the supervisor. Returns the exit status, 1 if there are problems.
+func checkConfig() int {
+	problems := configProblems(defaultConfig())
+	for _, problem := range problems {
+		fmt.Printf("%s: %s\n", opts.Config, problem)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	fmt.Printf("%s: OK\n", opts.Config)
+	return 0
+}
+
+// Returns the problems with the config loaded into cfg that would stop the supervisor starting or break it later
+func configProblems(cfg *Config) []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if err := loadConfig(cfg); err != nil {
+		// the supervisor would run with the defaults, which is never what a config file was written for
+		return []string{err.Error()}
+	}
+	if opts.Config != "" {
+		md, _ := toml.DecodeFile(opts.Config, defaultConfig())
+		for _, key := range md.Undecoded() {
+			add("unknown key %s, check its spelling", key)
+		}
+	}
+	durations := []struct{ key, value string }{
+		{"result_duration", cfg.ResultDuration},
+		{"maintenance_check_interval", cfg.MaintenanceCheckInterval},
+		{"image_retention", cfg.ImageRetention},
+		{"image_gc_interval", cfg.ImageGCInterval},
+		{"ssh_cert_ttl", cfg.SSHCertTTL},
+		{"ssh_audit_retention", cfg.SSHAuditRetention},
+		{"stats_interval", cfg.StatsInterval},
+		{"stats_retention", cfg.StatsRetention},
+		{"capture_retention", cfg.CaptureRetention},
+	}
+	for _, d := range durations {
+		if d.value == "" && (d.key == "image_gc_interval" || d.key == "stats_interval") {
+			continue
+		}
+		if _, err := time.ParseDuration(d.value); err != nil {
+			add("%s %q is not a duration, use one like \"30m\" or \"168h\"", d.key, d.value)
+		}
+	}
+	if _, _, err := net.SplitHostPort(cfg.RpcAddr); err != nil {
+		add("rpc_addr %q should be [host]:port, e.g. \":1337\"", cfg.RpcAddr)
+	}
+	if cfg.NumContainers == 0 {
+		add("num_containers is 0, no container could be deployed")
+	}
+	if err := containers.CheckPortRanges(containerSettings(cfg), cfg.MinPort, cfg.NumContainers,
+		cfg.NumSecondary); err != nil {
+		add("%v Move primary_ports, ssh_ports and secondary_ports apart or widen them.", err)
+	}
+	if _, err := containers.ParseStaticIPRange(cfg.StaticIPNetwork, cfg.StaticIPRange); err != nil {
+		add("static_ip_network and static_ip_range: %v", err)
+	}
+	for _, binds := range []struct {
+		key   string
+		names []string
+	}{{"bind_addresses", cfg.BindAddresses}, {"ssh_bind_addresses", cfg.SSHBindAddresses}} {
+		if _, err := containers.ResolveBindAddresses(binds.names); err != nil {
+			add("%s: %v", binds.key, err)
+		}
+	}
+	if cfg.RestrictEgress && !cfg.EnableNetsec {
+		add("restrict_egress needs enable_netsec, without it no firewall rules are set up")
+	}
+	if cfg.CaptureDir != "" && !filepath.IsAbs(cfg.CaptureDir) {
+		add("capture_dir %q should be an absolute path", cfg.CaptureDir)
+	}
+	if cfg.CaptureMaxFiles < 0 {
+		add("capture_max_files can not be negative, use 0 to keep no rotated logs")
+	}
+	if cfg.TraceEndpoint != "" {
+		if u, err := url.Parse(cfg.TraceEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			add("trace_endpoint %q should be an http or https URL, e.g. \"http://localhost:4318/v1/traces\"",
+				cfg.TraceEndpoint)
+		}
+	}
+	if cfg.CPUOvercommit < 0 || cfg.MemoryOvercommit < 0 {
+		add("cpu_overcommit and memory_overcommit can not be negative, use 0 to not overcommit")
+	}
+	if policy := restartPolicy(cfg); policy != nil {
+		if err := policy.Validate(); err != nil {
+			add("restart_policy: %v", err)
+		}
+	}
+	switch cfg.Runtime {
+	case "", docker.RuntimeDocker, docker.RuntimePodman:
+	default:
+		add("runtime %q is unknown, use %q or %q", cfg.Runtime, docker.RuntimeDocker, docker.RuntimePodman)
+	}
+	switch cfg.Replication {
+	case "":
+	case "etcd", "zookeeper":
+		if len(cfg.ReplicationEndpoints) == 0 {
+			add("replication is %s but replication_endpoints is empty", cfg.Replication)
+		}
+	default:
+		add("replication %q is unknown, use \"etcd\", \"zookeeper\" or leave it empty", cfg.Replication)
+	}
+	if err := scrypto.InitDecrypters(decrypterConfig(cfg)); err != nil {
+		add("decrypter: %v", err)
+	}
+	if problem := checkDir("save_dir", cfg.SaveDir); problem != "" {
+		add("%s", problem)
+	}
+	if problem := checkDir("maintenance_file", filepath.Dir(cfg.MaintenanceFile)); problem != "" {
+		add("%s", problem)
+	}
+	if cfg.CheckScripts != "" && !strings.HasPrefix(cfg.CheckScripts, "http://") &&
+		!strings.HasPrefix(cfg.CheckScripts, "https://") {
+		if problem := checkDir("check_scripts", cfg.CheckScripts); problem != "" {
+			add("%s", problem)
+		}
+	}
+	if cfg.UsernsRemap != "" {
+		if _, _, err := docker.RemappedRoot(cfg.UsernsRemap); err != nil {
+			add("userns_remap: %v, it should match the runtime's userns-remap setting", err)
+		}
+	}
+	if _, err := loadRBAC(cfg); err != nil {
+		add("rbac_file: %v", err)
+	}
+	if _, err := dns.New(dnsConfig(cfg)); err != nil {
+		add("dns_registrar: %v", err)
+	} else if cfg.DNSRegistrar != "" && strings.Trim(cfg.DNSDomain, ".") == "" {
+		add("dns_registrar is %s but dns_domain is empty", cfg.DNSRegistrar)
+	}
+	if _, err := discovery.New(discoveryConfig(cfg)); err != nil {
+		add("discovery: %v", err)
+	}
+	if cfg.DNSAddress != "" && net.ParseIP(cfg.DNSAddress).To4() == nil {
+		add("dns_address %q should be an IPv4 address", cfg.DNSAddress)
+	}
+	if (cfg.TLSSidecarImage == "") != (cfg.TLSSidecarVersion == "") {
+		add("tls_sidecar_image and tls_sidecar_version should be set together")
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		add("tls_cert_file and tls_key_file should be set together")
+	} else if cfg.TLSCertFile != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+			add("tls_cert_file and tls_key_file: %v", err)
+		}
+	}
+	if _, err := loadManifestKeys(cfg); err != nil {
+		add("manifest_keys: %v", err)
+	}
+	if cfg.StateKeyFile != "" && cfg.StateKeyCommand != "" {
+		add("only one of state_key_file and state_key_command can be set")
+	} else if _, err := serialize.LoadKey(cfg.StateKeyFile, cfg.StateKeyCommand); err != nil {
+		add("%v", err)
+	}
+	if cfg.SSHCAKey != "" {
+		for _, key := range []string{cfg.SSHCAKey, cfg.SSHCAKey + ".pub"} {
+			if f, err := os.Open(key); err != nil {
+				add("ssh_ca_key can not be read: %v", err)
+			} else {
+				f.Close()
+			}
+		}
+	}
+	if cfg.SeccompDir != "" {
+		if problem := checkDir("seccomp_dir", cfg.SeccompDir); problem != "" {
+			add("%s", problem)
+		}
+	}
+	if cfg.GPGHome != "" {
+		if problem := checkDir("gpg_home", cfg.GPGHome); problem != "" {
+			add("%s", problem)
+		}
+	}
+	if cfg.VaultAddr != "" && cfg.VaultTokenFile != "" {
+		if f, err := os.Open(cfg.VaultTokenFile); err != nil {
+			add("vault_token_file can not be read: %v", err)
+		} else {
+			f.Close()
+		}
+	}
+	return problems
+}
+
+// Returns a problem if dir is not an existing directory, "" if it is
+func checkDir(key, dir string) string {
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return fmt.Sprintf("%s: %s does not exist, create it with mkdir -p %s", key, dir, dir)
+	} else if err != nil {
+		return fmt.Sprintf("%s: %v", key, err)
+	} else if !info.IsDir() {
+		return fmt.Sprintf("%s: %s is not a directory", key, dir)
+	}
+	return ""
+}
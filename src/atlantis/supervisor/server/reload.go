@@ -0,0 +1,159 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package server
+
+import (
+	"atlantis/supervisor/containers"
+	scrypto "atlantis/supervisor/crypto"
+	"atlantis/supervisor/rpc"
+	"atlantis/supervisor/rpc/types"
+	"bytes"
+	"crypto"
+	"github.com/BurntSushi/toml"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The config keys a SIGHUP reloads. The rest are fixed at startup: changing them takes a restart.
+var reloadable = map[string]bool{
+	"primary_ports":       true,
+	"ssh_ports":           true,
+	"secondary_ports":     true,
+	"excluded_ports":      true,
+	"cpu_overcommit":      true,
+	"memory_overcommit":   true,
+	"restart_policy":      true,
+	"restart_max_retries": true,
+	"restart_backoff":     true,
+	"inventory_command":   true,
+	"inventory_retries":   true,
+	"check_scripts":       true,
+	"check_scripts_dir":   true,
+	"rbac_file":           true,
+	"manifest_keys":       true,
+}
+
+var (
+	configLock     sync.Mutex // guards config and configLoadedAt once the supervisor is running
+	configLoadedAt time.Time
+)
+
+func restartPolicy(cfg *Config) *types.RestartPolicy {
+	if cfg.RestartPolicy == "" {
+		return nil
+	}
+	return &types.RestartPolicy{Name: cfg.RestartPolicy, MaxRetries: cfg.RestartMaxRetries,
+		Backoff: cfg.RestartBackoff}
+}
+
+// Returns the RBAC in the config's rbac_file, nil if it has none
+func loadRBAC(cfg *Config) (*rpc.RBAC, error) {
+	if cfg.RBACFile == "" {
+		return nil, nil
+	}
+	return rpc.LoadRBAC(cfg.RBACFile)
+}
+
+// Returns the keys in the config's manifest_keys, nil if any manifest may be deployed
+func loadManifestKeys(cfg *Config) ([]crypto.PublicKey, error) {
+	if cfg.ManifestKeys == "" {
+		return nil, nil
+	}
+	return scrypto.LoadPublicKeys(cfg.ManifestKeys)
+}
+
+func containerSettings(cfg *Config) *containers.Settings {
+	return &containers.Settings{
+		PrimaryPortRange:     cfg.PrimaryPorts,
+		SSHPortRange:         cfg.SSHPorts,
+		SecondaryPortRange:   cfg.SecondaryPorts,
+		ExcludedPorts:        cfg.ExcludedPorts,
+		CPUOvercommit:        cfg.CPUOvercommit,
+		MemoryOvercommit:     cfg.MemoryOvercommit,
+		DefaultRestartPolicy: restartPolicy(cfg),
+		InventoryCommand:     cfg.InventoryCommand,
+		InventoryRetries:     cfg.InventoryRetries,
+		CheckScripts:         cfg.CheckScripts,
+		CheckScriptsDir:      cfg.CheckScriptsDir,
+	}
+}
+
+func reloadListener() {
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	for range hupChan {
+		log.Printf("[SIGHUP] Reloading %s", opts.Config)
+		if err := reloadConfig(); err != nil {
+			log.Printf("[SIGHUP] -> kept the current config: %v", err)
+		}
+	}
+}
+
+// Re-read the config and apply the reloadable settings. Operations in progress are not interrupted, deploys
+// started afterwards use the new settings.
+func reloadConfig() error {
+	next := defaultConfig()
+	if err := loadConfig(next); err != nil {
+		return err
+	}
+	configLock.Lock()
+	defer configLock.Unlock()
+	current := reflect.ValueOf(config).Elem()
+	fields := reflect.ValueOf(next).Elem()
+	for i := 0; i < fields.NumField(); i++ {
+		key := fields.Type().Field(i).Tag.Get("toml")
+		if reloadable[key] || reflect.DeepEqual(fields.Field(i).Interface(), current.Field(i).Interface()) {
+			continue
+		}
+		log.Printf("[SIGHUP] -> %s changed, restart the supervisor to apply it", key)
+		fields.Field(i).Set(current.Field(i))
+	}
+	// the rbac file is re-read even if its name did not change, so tokens can be rotated with a SIGHUP
+	rbac, err := loadRBAC(next)
+	if err != nil {
+		return err
+	}
+	manifestKeys, err := loadManifestKeys(next)
+	if err != nil {
+		return err
+	}
+	if err := containers.Reload(containerSettings(next)); err != nil {
+		return err
+	}
+	rpc.SetRBAC(rbac)
+	rpc.ManifestKeys = manifestKeys
+	config = next
+	configLoadedAt = time.Now()
+	log.Println("[SIGHUP] -> done")
+	return nil
+}
+
+// Returns the active config as TOML, without secrets
+func activeConfig() (string, time.Time, error) {
+	configLock.Lock()
+	redacted := *config
+	loadedAt := configLoadedAt
+	configLock.Unlock()
+	if redacted.RegistryPassword != "" {
+		redacted.RegistryPassword = "<redacted>"
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(&redacted); err != nil {
+		return "", loadedAt, err
+	}
+	return buf.String(), loadedAt, nil
+}
@@ -14,10 +14,18 @@ package server
 import (
 	. "atlantis/common"
 	"atlantis/crypto"
+	"atlantis/supervisor/cgroup"
 	. "atlantis/supervisor/constant"
 	"atlantis/supervisor/containers"
+	"atlantis/supervisor/containers/serialize"
+	scrypto "atlantis/supervisor/crypto"
+	"atlantis/supervisor/discovery"
+	"atlantis/supervisor/dns"
+	"atlantis/supervisor/docker"
 	"atlantis/supervisor/healthz"
 	"atlantis/supervisor/rpc"
+	"atlantis/supervisor/rpc/types"
+	"atlantis/supervisor/trace"
 	"fmt"
 	"github.com/BurntSushi/toml"
 	"github.com/jigish/go-flags"
@@ -29,21 +37,98 @@ import (
 )
 
 type Config struct {
-	SaveDir                  string  `toml:"save_dir"`
-	NumContainers            uint16  `toml:"num_containers"`
-	NumSecondary             uint16  `toml:"num_secondary"`
-	CPUShares                uint    `toml:"cpu_shares"`
-	MemoryLimit              uint    `toml:"memory_limit"`
-	MinPort                  uint16  `toml:"min_port"`
-	RpcAddr                  string  `toml:"rpc_addr"`
-	RegistryHost             string  `toml:"registry_host"`
-	ResultDuration           string  `toml:"result_duration"`
-	Region                   string  `toml:"region"`
-	Zone                     string  `toml:"zone"`
-	MaintenanceFile          string  `toml:"maintenance_file"`
-	MaintenanceCheckInterval string  `toml:"maintenance_check_interval"`
-	EnableNetsec             bool    `toml:"enable_netsec"`
-	Price                    float64 `toml:"price"`
+	SaveDir                  string          `toml:"save_dir"`
+	NumContainers            uint16          `toml:"num_containers"`
+	NumSecondary             uint16          `toml:"num_secondary"`
+	CPUShares                uint            `toml:"cpu_shares"`
+	MemoryLimit              uint            `toml:"memory_limit"`
+	CPUOvercommit            float64         `toml:"cpu_overcommit"`    // e.g. 1.5 to reserve 1.5x cpu_shares
+	MemoryOvercommit         float64         `toml:"memory_overcommit"` // e.g. 1.2 to reserve 1.2x memory_limit
+	GPUs                     uint            `toml:"gpus"`
+	PinnableCPUs             []uint          `toml:"pinnable_cpus"`  // cores set aside for containers with dedicated cpus
+	SharesPerCPU             uint            `toml:"shares_per_cpu"` // defaults to cpu_shares spread over all the cores
+	DiskLimit                uint            `toml:"disk_limit"`     // MB, defaults to the size of the docker root
+	MinPort                  uint16          `toml:"min_port"`
+	PrimaryPorts             types.PortRange `toml:"primary_ports"`   // defaults to num_containers ports from min_port
+	SSHPorts                 types.PortRange `toml:"ssh_ports"`       // defaults to the ports after the primary ports
+	SecondaryPorts           types.PortRange `toml:"secondary_ports"` // defaults to the ports after the ssh ports
+	ExcludedPorts            []uint16        `toml:"excluded_ports"`  // ports other services on the host are bound to
+	RpcAddr                  string          `toml:"rpc_addr"`
+	RegistryHost             string          `toml:"registry_host"`
+	RegistryUsername         string          `toml:"registry_username"`
+	RegistryPassword         string          `toml:"registry_password"`
+	ResultDuration           string          `toml:"result_duration"`
+	Region                   string          `toml:"region"`
+	Zone                     string          `toml:"zone"`
+	MaintenanceFile          string          `toml:"maintenance_file"`
+	MaintenanceCheckInterval string          `toml:"maintenance_check_interval"`
+	EnableNetsec             bool            `toml:"enable_netsec"`
+	RestartPolicy            string          `toml:"restart_policy"` // for containers without one, empty for none
+	RestartMaxRetries        uint            `toml:"restart_max_retries"`
+	RestartBackoff           uint            `toml:"restart_backoff"`   // seconds
+	EnableIPv6               bool            `toml:"enable_ipv6"`       // publish ports on IPv6 too, needs docker --ipv6
+	StaticIPNetwork          string          `toml:"static_ip_network"` // macvlan or bridge network with static IPs
+	StaticIPRange            string          `toml:"static_ip_range"`   // IPv4 CIDR the network's IPs come from
+	LocalSSHHost             string          `toml:"local_ssh_host"`    // defaults to localhost
+	Runtime                  string          `toml:"runtime"`           // docker or podman, defaults to docker
+	Rootless                 bool            `toml:"rootless"`          // the runtime runs as the supervisor's user
+	RuntimeEndpoint          string          `toml:"runtime_endpoint"`  // defaults to the runtime's socket
+	Checkpoints              bool            `toml:"checkpoints"`       // experimental, needs criu
+	InventoryCommand         string          `toml:"inventory_command"` // check_mk re-inventory, empty for none
+	InventoryRetries         uint            `toml:"inventory_retries"`
+	CheckScripts             string          `toml:"check_scripts"`     // dir or .tar.gz URL synced into containers
+	CheckScriptsDir          string          `toml:"check_scripts_dir"` // defaults to /check_mk_checks
+	Price                    float64         `toml:"price"`
+	Decrypter                string          `toml:"decrypter"`  // default provider for dep data: atlantis, kms, gpg or vault
+	KMSRegion                string          `toml:"kms_region"` // defaults to region
+	GPGHome                  string          `toml:"gpg_home"`
+	VaultAddr                string          `toml:"vault_addr"`
+	VaultTokenFile           string          `toml:"vault_token_file"`
+	VaultMount               string          `toml:"vault_mount"`
+	VaultTransitKey          string          `toml:"vault_transit_key"`
+	ImageRetention           string          `toml:"image_retention"`   // how long unused images are kept
+	ImageGCInterval          string          `toml:"image_gc_interval"` // empty to only remove images on request
+	Replication              string          `toml:"replication"`       // mirror container state to etcd or zookeeper
+	ReplicationEndpoints     []string        `toml:"replication_endpoints"`
+	ReplicationPrefix        string          `toml:"replication_prefix"` // defaults to /atlantis/supervisor/<hostname>
+	StateSnapshots           int             `toml:"state_snapshots"`    // versions of the container state to keep
+	SeccompDir               string          `toml:"seccomp_dir"`        // custom seccomp profiles, as <name>.json
+	UsernsRemap              string          `toml:"userns_remap"`       // the runtime's userns-remap, empty if off
+	SSHCAKey                 string          `toml:"ssh_ca_key"`         // signs ssh certificates, empty for raw keys
+	SSHCertTTL               string          `toml:"ssh_cert_ttl"`
+	SSHAuditRetention        string          `toml:"ssh_audit_retention"`
+	StateKeyFile             string          `toml:"state_key_file"`    // encrypts the saved state, raw, hex or base64
+	StateKeyCommand          string          `toml:"state_key_command"` // prints the state key, if there is no file
+	RBACFile                 string          `toml:"rbac_file"`         // who may call which RPCs, empty for anybody
+	ManifestKeys             string          `toml:"manifest_keys"`     // PEM public keys manifests must be signed with
+	DNSRegistrar             string          `toml:"dns_registrar"`     // route53, nsupdate or dnsmasq, empty for none
+	DNSDomain                string          `toml:"dns_domain"`        // containers are registered as <id>.<domain>
+	DNSAddress               string          `toml:"dns_address"`       // defaults to the address of the host's name
+	DNSTTL                   uint            `toml:"dns_ttl"`           // seconds
+	Route53ZoneID            string          `toml:"route53_zone_id"`
+	NsupdateServer           string          `toml:"nsupdate_server"`   // defaults to the zone's primary
+	NsupdateKeyFile          string          `toml:"nsupdate_key_file"` // TSIG key, empty to not sign updates
+	DnsmasqHostsFile         string          `toml:"dnsmasq_hosts_file"`
+	DnsmasqReloadCommand     string          `toml:"dnsmasq_reload_command"` // e.g. "pkill -HUP dnsmasq"
+	Discovery                string          `toml:"discovery"`              // announce containers to consul or etcd
+	DiscoveryEndpoints       []string        `toml:"discovery_endpoints"`    // defaults to the local consul agent
+	DiscoveryPrefix          string          `toml:"discovery_prefix"`       // etcd only, defaults to /atlantis/instances
+	ConsulTokenFile          string          `toml:"consul_token_file"`
+	TLSSidecarImage          string          `toml:"tls_sidecar_image"` // terminates TLS for manifests that ask for it
+	TLSSidecarVersion        string          `toml:"tls_sidecar_version"`
+	TLSCertFile              string          `toml:"tls_cert_file"` // PEM, for manifests that bring no certificate
+	TLSKeyFile               string          `toml:"tls_key_file"`
+	RestrictEgress           bool            `toml:"restrict_egress"`    // containers only reach their security groups
+	BindAddresses            []string        `toml:"bind_addresses"`     // IPs or interfaces, empty for all addresses
+	SSHBindAddresses         []string        `toml:"ssh_bind_addresses"` // defaults to bind_addresses
+	StatsInterval            string          `toml:"stats_interval"`     // empty to keep no usage history
+	StatsRetention           string          `toml:"stats_retention"`
+	CaptureDir               string          `toml:"capture_dir"`       // empty to leave container output to docker
+	CaptureMaxSize           uint            `toml:"capture_max_size"`  // MB a log grows to before it is rotated
+	CaptureMaxFiles          int             `toml:"capture_max_files"` // rotated logs kept per container and stream
+	CaptureRetention         string          `toml:"capture_retention"` // kept this long after teardown
+	TraceEndpoint            string          `toml:"trace_endpoint"`    // OTLP/HTTP traces URL, empty to not trace
+	TraceServiceName         string          `toml:"trace_service_name"`
 }
 
 type Opts struct {
@@ -52,6 +137,7 @@ type Opts struct {
 	NumSecondary             uint16  `long:"secondary" description:"the # of secondary ports"`
 	CPUShares                uint    `long:"cpu-shares" description:"the total # of CPU shares available"`
 	MemoryLimit              uint    `long:"memory-limit" description:"the total MB of memory available"`
+	GPUs                     uint    `long:"gpus" description:"the # of GPUs available"`
 	MinPort                  uint16  `long:"min-port" description:"the minimum port number to use"`
 	RpcAddr                  string  `long:"rpc" description:"the RPC listen addr"`
 	RegistryHost             string  `long:"registry" description:"the Registry Host to talk to"`
@@ -63,24 +149,43 @@ type Opts struct {
 	MaintenanceCheckInterval string  `long:"maintenance-check-interval" description:"the interval to check the maintenance file"`
 	EnableNetsec             bool    `long:"enable-netsec" description:"enable network security (iptables)"`
 	Price                    float64 `long:"price"`
+	Decrypter                string  `long:"decrypter" description:"the default provider to decrypt dep data with"`
+	MigrateState             bool    `long:"migrate-state" description:"upgrade the saved state to the current format and exit"`
+	CheckConfig              bool    `long:"check-config" description:"check the config for problems and exit"`
 }
 
 var opts = &Opts{}
-var config = &Config{
-	SaveDir:                  DefaultSupervisorSaveDir,
-	NumContainers:            DefaultSupervisorNumContainers,
-	NumSecondary:             DefaultSupervisorNumSecondary,
-	CPUShares:                DefaultSupervisorCPUShares,
-	MemoryLimit:              DefaultSupervisorMemoryLimit,
-	MinPort:                  DefaultSupervisorMinPort,
-	RpcAddr:                  fmt.Sprintf(":%d", DefaultSupervisorRPCPort),
-	RegistryHost:             DefaultSupervisorRegistryHost,
-	ResultDuration:           DefaultResultDuration,
-	Region:                   DefaultRegion,
-	Zone:                     DefaultZone,
-	MaintenanceFile:          DefaultMaintenanceFile,
-	MaintenanceCheckInterval: DefaultMaintenanceCheckInterval,
-	EnableNetsec:             false,
+var config = defaultConfig()
+
+func defaultConfig() *Config {
+	return &Config{
+		SaveDir:                  DefaultSupervisorSaveDir,
+		NumContainers:            DefaultSupervisorNumContainers,
+		NumSecondary:             DefaultSupervisorNumSecondary,
+		CPUShares:                DefaultSupervisorCPUShares,
+		MemoryLimit:              DefaultSupervisorMemoryLimit,
+		MinPort:                  DefaultSupervisorMinPort,
+		RpcAddr:                  fmt.Sprintf(":%d", DefaultSupervisorRPCPort),
+		RegistryHost:             DefaultSupervisorRegistryHost,
+		ResultDuration:           DefaultResultDuration,
+		Region:                   DefaultRegion,
+		Zone:                     DefaultZone,
+		MaintenanceFile:          DefaultMaintenanceFile,
+		MaintenanceCheckInterval: DefaultMaintenanceCheckInterval,
+		EnableNetsec:             false,
+		ImageRetention:           DefaultImageRetention,
+		StateSnapshots:           DefaultStateSnapshots,
+		InventoryCommand:         containers.DefaultInventoryCommand,
+		InventoryRetries:         containers.InventoryRetries,
+		CheckScriptsDir:          containers.DefaultCheckScriptsDir,
+		SSHCertTTL:               containers.DefaultSSHCertTTL.String(),
+		SSHAuditRetention:        containers.DefaultSSHAuditRetention.String(),
+		StatsRetention:           containers.DefaultStatsRetention.String(),
+		CaptureMaxSize:           containers.DefaultCaptureMaxSize,
+		CaptureMaxFiles:          containers.DefaultCaptureMaxFiles,
+		CaptureRetention:         containers.DefaultCaptureRetention.String(),
+		TraceServiceName:         trace.DefaultServiceName,
+	}
 }
 
 type Supervisor struct {
@@ -96,16 +201,143 @@ func New() *Supervisor {
 
 func (ih *Supervisor) Run() {
 	ih.parser.Parse()
+	if opts.CheckConfig {
+		os.Exit(checkConfig())
+	}
 	log.Println("You feelin' lucky, punk?")
 	log.Println("                          -- Supervisor\n")
 	crypto.Init()
 	overlayConfig()
+	if opts.MigrateState {
+		from, err := containers.MigrateState(config.SaveDir)
+		handleError(err)
+		if from == containers.StateFormatVersion {
+			log.Printf("State in %s is already at format version %d.", config.SaveDir, from)
+		} else {
+			log.Printf("Upgraded state in %s from format version %d to %d.", config.SaveDir, from,
+				containers.StateFormatVersion)
+		}
+		return
+	}
 	Region = config.Region
 	Zone = config.Zone
 	Price = config.Price
+	handleError(scrypto.InitDecrypters(decrypterConfig(config)))
 	log.Printf("Initializing Atlantis Supervisor [%s] [%s]", Region, Zone)
+	if config.Runtime != "" {
+		docker.Runtime = config.Runtime
+	}
+	docker.Rootless = config.Rootless
+	docker.Endpoint = config.RuntimeEndpoint
+	log.Printf("Using %s", docker.RuntimeName())
+	docker.UsernsRemap = config.UsernsRemap
+	if docker.UsernsRemap != "" {
+		uid, gid, err := docker.RemappedRoot(docker.UsernsRemap)
+		handleError(err)
+		log.Printf("Containers' root is uid %d, gid %d on the host (userns-remap %s)", uid, gid, docker.UsernsRemap)
+	}
+	if err := cgroup.CheckControllers(); err != nil {
+		log.Printf("WARNING: cpu shares and memory limits may not be enforced: %v", err)
+	}
+	log.Printf("Using cgroup v%d", cgroup.Version())
+	if docker.Rootless && cgroup.Version() != cgroup.V2 {
+		log.Printf("WARNING: rootless containers can only be given cpu shares and memory limits on cgroup v2")
+	}
+	containers.NumGPUs = config.GPUs
+	containers.PinnableCPUs = config.PinnableCPUs
+	containers.SharesPerCPU = config.SharesPerCPU
+	containers.DiskLimit = config.DiskLimit
+	containers.DiskRoot = docker.DataRoot()
+	containers.EnableCheckpoints = config.Checkpoints
+	containers.InventoryCommand = config.InventoryCommand
+	containers.InventoryRetries = config.InventoryRetries
+	containers.CheckScripts = config.CheckScripts
+	containers.CheckScriptsDir = config.CheckScriptsDir
+	containers.SSHCAKey = config.SSHCAKey
+	sshCertTTL, err := time.ParseDuration(config.SSHCertTTL)
+	handleError(err)
+	containers.SSHCertTTL = sshCertTTL
+	sshAuditRetention, err := time.ParseDuration(config.SSHAuditRetention)
+	handleError(err)
+	containers.SSHAuditRetention = sshAuditRetention
+	if config.StatsInterval != "" {
+		containers.StatsInterval, err = time.ParseDuration(config.StatsInterval)
+		handleError(err)
+	}
+	containers.StatsRetention, err = time.ParseDuration(config.StatsRetention)
+	handleError(err)
+	containers.CaptureDir = config.CaptureDir
+	containers.CaptureMaxSize = config.CaptureMaxSize
+	containers.CaptureMaxFiles = config.CaptureMaxFiles
+	containers.CaptureRetention, err = time.ParseDuration(config.CaptureRetention)
+	handleError(err)
+	if config.CaptureDir != "" {
+		// the captured logs are the history, docker only has to keep what was not captured yet
+		docker.DefaultLogConfig = &types.LogConfig{Driver: "json-file",
+			Options: map[string]string{"max-size": "10m", "max-file": "2"}}
+	}
+	stateKey, err := serialize.LoadKey(config.StateKeyFile, config.StateKeyCommand)
+	handleError(err)
+	handleError(serialize.SetKey(stateKey))
+	if stateKey != nil {
+		log.Printf("Encrypting the saved state with key %s", serialize.KeyID(stateKey))
+	}
+	docker.EnableIPv6 = config.EnableIPv6
+	if config.SeccompDir != "" {
+		docker.SeccompDir = config.SeccompDir
+	}
+	if config.LocalSSHHost != "" {
+		containers.LocalSSHHost = config.LocalSSHHost
+	}
+	containers.DefaultRestartPolicy = restartPolicy(config)
+	containers.PrimaryPortRange = config.PrimaryPorts
+	containers.SSHPortRange = config.SSHPorts
+	containers.SecondaryPortRange = config.SecondaryPorts
+	containers.ExcludedPorts = config.ExcludedPorts
+	containers.CPUOvercommit = config.CPUOvercommit
+	containers.MemoryOvercommit = config.MemoryOvercommit
+	containers.StaticIPNetwork = config.StaticIPNetwork
+	containers.StaticIPRange = config.StaticIPRange
+	containers.RestrictEgress = config.RestrictEgress
+	containers.BindAddresses = config.BindAddresses
+	containers.SSHBindAddresses = config.SSHBindAddresses
+	containers.TLSSidecarImage = config.TLSSidecarImage
+	containers.TLSSidecarVersion = config.TLSSidecarVersion
+	docker.TLSCertFile = config.TLSCertFile
+	docker.TLSKeyFile = config.TLSKeyFile
+	containers.Replicator = replicator()
+	containers.DNSRegistrar, err = dns.New(dnsConfig(config))
+	handleError(err)
+	containers.DNSDomain = config.DNSDomain
+	containers.DNSAddress = config.DNSAddress
+	containers.Announcer, err = discovery.New(discoveryConfig(config))
+	handleError(err)
+	containers.NumSnapshots = config.StateSnapshots
+	if config.RegistryUsername != "" {
+		docker.RegistryAuth = &types.RegistryAuth{Username: config.RegistryUsername, Password: config.RegistryPassword}
+	}
 	handleError(containers.Init(config.RegistryHost, config.SaveDir, config.NumContainers, config.NumSecondary,
 		config.MinPort, config.CPUShares, config.MemoryLimit, config.EnableNetsec))
+	imageRetention, err := time.ParseDuration(config.ImageRetention)
+	handleError(err)
+	docker.ImageRetention = imageRetention
+	if config.ImageGCInterval != "" {
+		imageGCInterval, err := time.ParseDuration(config.ImageGCInterval)
+		handleError(err)
+		go docker.ImageGCLoop(imageGCInterval, docker.ImageRetention)
+	}
+	configLoadedAt = time.Now()
+	rpc.ActiveConfig = activeConfig
+	rpc.MaintenanceFile = config.MaintenanceFile
+	rbac, err := loadRBAC(config)
+	handleError(err)
+	rpc.SetRBAC(rbac)
+	rpc.ManifestKeys, err = loadManifestKeys(config)
+	handleError(err)
+	trace.Endpoint = config.TraceEndpoint
+	trace.ServiceName = config.TraceServiceName
+	trace.Host, _ = os.Hostname()
+	trace.Init()
 	handleError(rpc.Init(config.RpcAddr))
 	maintenanceCheckInterval, err := time.ParseDuration(config.MaintenanceCheckInterval)
 	if err != nil {
@@ -113,10 +345,71 @@ func (ih *Supervisor) Run() {
 	}
 	go healthz.Run(8080)
 	go signalListener()
+	go reloadListener()
 	MaintenanceChecker(config.MaintenanceFile, maintenanceCheckInterval)
 	rpc.Listen()
 }
 
+func dnsConfig(cfg *Config) *dns.Config {
+	return &dns.Config{
+		Registrar:            cfg.DNSRegistrar,
+		TTL:                  cfg.DNSTTL,
+		Route53ZoneID:        cfg.Route53ZoneID,
+		NsupdateServer:       cfg.NsupdateServer,
+		NsupdateKeyFile:      cfg.NsupdateKeyFile,
+		DnsmasqHostsFile:     cfg.DnsmasqHostsFile,
+		DnsmasqReloadCommand: cfg.DnsmasqReloadCommand,
+	}
+}
+
+func discoveryConfig(cfg *Config) *discovery.Config {
+	return &discovery.Config{
+		Backend:         cfg.Discovery,
+		Endpoints:       cfg.DiscoveryEndpoints,
+		Prefix:          cfg.DiscoveryPrefix,
+		ConsulTokenFile: cfg.ConsulTokenFile,
+	}
+}
+
+func decrypterConfig(cfg *Config) *scrypto.DecrypterConfig {
+	kmsRegion := cfg.KMSRegion
+	if kmsRegion == "" {
+		kmsRegion = cfg.Region
+	}
+	return &scrypto.DecrypterConfig{
+		Default:         cfg.Decrypter,
+		KMSRegion:       kmsRegion,
+		GPGHome:         cfg.GPGHome,
+		VaultAddr:       cfg.VaultAddr,
+		VaultTokenFile:  cfg.VaultTokenFile,
+		VaultMount:      cfg.VaultMount,
+		VaultTransitKey: cfg.VaultTransitKey,
+	}
+}
+
+// Returns the Replicator for the configured replication, or nil if state is only kept on local disk
+func replicator() serialize.Replicator {
+	prefix := config.ReplicationPrefix
+	if prefix == "" {
+		hostname, err := os.Hostname()
+		handleError(err)
+		prefix = "/atlantis/supervisor/" + hostname
+	}
+	switch config.Replication {
+	case "":
+		return nil
+	case "etcd":
+		return &serialize.EtcdReplicator{Endpoints: config.ReplicationEndpoints, Prefix: prefix}
+	case "zookeeper":
+		replicator, err := serialize.NewZookeeperReplicator(config.ReplicationEndpoints, prefix)
+		handleError(err)
+		return replicator
+	default:
+		log.Fatalln("ERROR: unknown replication " + config.Replication)
+		return nil
+	}
+}
+
 func handleError(err error) {
 	if err != nil {
 		log.Fatalln("ERROR:", err)
@@ -124,49 +417,61 @@ func handleError(err error) {
 }
 
 func overlayConfig() {
+	if err := loadConfig(config); err != nil {
+		log.Println(err)
+		// no need to panic here. we have reasonable defaults.
+	}
+}
+
+// Load the config file into cfg, then override it with the command line options
+func loadConfig(cfg *Config) error {
+	var err error
 	if opts.Config != "" {
-		_, err := toml.DecodeFile(opts.Config, config)
-		if err != nil {
-			log.Println(err)
-			// no need to panic here. we have reasonable defaults.
-		}
+		_, err = toml.DecodeFile(opts.Config, cfg)
 	}
 	if opts.SaveDir != "" {
-		config.SaveDir = opts.SaveDir
+		cfg.SaveDir = opts.SaveDir
 	}
 	if opts.NumContainers != 0 {
-		config.NumContainers = opts.NumContainers
+		cfg.NumContainers = opts.NumContainers
 	}
 	if opts.NumSecondary != 0 {
-		config.NumSecondary = opts.NumSecondary
+		cfg.NumSecondary = opts.NumSecondary
+	}
+	if opts.GPUs != 0 {
+		cfg.GPUs = opts.GPUs
 	}
 	if opts.MinPort != 0 {
-		config.MinPort = opts.MinPort
+		cfg.MinPort = opts.MinPort
 	}
 	if opts.RpcAddr != "" {
-		config.RpcAddr = opts.RpcAddr
+		cfg.RpcAddr = opts.RpcAddr
 	}
 	if opts.RegistryHost != "" {
-		config.RegistryHost = opts.RegistryHost
+		cfg.RegistryHost = opts.RegistryHost
 	}
 	if opts.ResultDuration != "" {
-		config.ResultDuration = opts.ResultDuration
+		cfg.ResultDuration = opts.ResultDuration
 	}
 	if opts.Region != "" {
-		config.Region = opts.Region
+		cfg.Region = opts.Region
 	}
 	if opts.Zone != "" {
-		config.Zone = opts.Zone
+		cfg.Zone = opts.Zone
 	}
 	if opts.MaintenanceFile != "" {
-		config.MaintenanceFile = opts.MaintenanceFile
+		cfg.MaintenanceFile = opts.MaintenanceFile
 	}
 	if opts.MaintenanceCheckInterval != "" {
-		config.MaintenanceCheckInterval = opts.MaintenanceCheckInterval
+		cfg.MaintenanceCheckInterval = opts.MaintenanceCheckInterval
 	}
 	if opts.EnableNetsec {
-		config.EnableNetsec = opts.EnableNetsec
+		cfg.EnableNetsec = opts.EnableNetsec
+	}
+	if opts.Decrypter != "" {
+		cfg.Decrypter = opts.Decrypter
 	}
+	return err
 }
 
 func signalListener() {
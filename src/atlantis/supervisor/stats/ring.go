@@ -0,0 +1,181 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+// Package stats keeps the resource usage history of containers in fixed size files on disk, so it is bounded no
+// matter how long a container runs.
+package stats
+
+import (
+	"atlantis/supervisor/rpc/types"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// A ring file starts with a header of the magic, its capacity and the number of samples ever appended to it,
+// followed by capacity records. Once it is full the oldest record is overwritten.
+const (
+	magic      = "ASTATS1\n"
+	headerSize = 24
+	recordSize = 48
+)
+
+var ringLock sync.Mutex // appends and reads are whole, each sees every record or none of a write
+
+type header struct {
+	capacity uint64
+	appended uint64
+}
+
+func readHeader(f *os.File) (*header, error) {
+	buf := make([]byte, headerSize)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		return nil, err
+	}
+	if string(buf[:len(magic)]) != magic {
+		return nil, errors.New("not a stats file")
+	}
+	h := &header{capacity: binary.BigEndian.Uint64(buf[8:]), appended: binary.BigEndian.Uint64(buf[16:])}
+	if h.capacity == 0 {
+		return nil, errors.New("stats file has no capacity")
+	}
+	return h, nil
+}
+
+func writeHeader(f *os.File, h *header) error {
+	buf := make([]byte, headerSize)
+	copy(buf, magic)
+	binary.BigEndian.PutUint64(buf[8:], h.capacity)
+	binary.BigEndian.PutUint64(buf[16:], h.appended)
+	_, err := f.WriteAt(buf, 0)
+	return err
+}
+
+func encode(sample *types.StatsSample) []byte {
+	buf := make([]byte, recordSize)
+	binary.BigEndian.PutUint64(buf[0:], uint64(sample.Time.UnixNano()))
+	binary.BigEndian.PutUint64(buf[8:], uint64(sample.CPUTime))
+	binary.BigEndian.PutUint64(buf[16:], math.Float64bits(sample.CPUCores))
+	binary.BigEndian.PutUint64(buf[24:], sample.MemoryBytes)
+	binary.BigEndian.PutUint64(buf[32:], sample.RxBytes)
+	binary.BigEndian.PutUint64(buf[40:], sample.TxBytes)
+	return buf
+}
+
+func decode(buf []byte) *types.StatsSample {
+	return &types.StatsSample{
+		Time:        time.Unix(0, int64(binary.BigEndian.Uint64(buf[0:]))),
+		CPUTime:     time.Duration(binary.BigEndian.Uint64(buf[8:])),
+		CPUCores:    math.Float64frombits(binary.BigEndian.Uint64(buf[16:])),
+		MemoryBytes: binary.BigEndian.Uint64(buf[24:]),
+		RxBytes:     binary.BigEndian.Uint64(buf[32:]),
+		TxBytes:     binary.BigEndian.Uint64(buf[40:]),
+	}
+}
+
+// Returns every sample in the file, oldest first
+func readAll(f *os.File) ([]*types.StatsSample, error) {
+	h, err := readHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	count, first := h.appended, uint64(0)
+	if count > h.capacity {
+		count, first = h.capacity, h.appended%h.capacity
+	}
+	buf := make([]byte, count*recordSize)
+	if _, err := f.ReadAt(buf, headerSize); err != nil && err != io.EOF {
+		return nil, err
+	}
+	samples := make([]*types.StatsSample, 0, count)
+	for i := uint64(0); i < count; i++ {
+		slot := (first + i) % h.capacity
+		record := buf[slot*recordSize : (slot+1)*recordSize]
+		if bytes.Equal(record, make([]byte, recordSize)) {
+			// a record that was never written, the file was cut short
+			continue
+		}
+		samples = append(samples, decode(record))
+	}
+	return samples, nil
+}
+
+// Append a sample to the ring file, creating it with room for capacity samples if it does not exist. A file of a
+// different capacity is rewritten with the newest samples that fit.
+func Append(file string, capacity int, sample *types.StatsSample) error {
+	if capacity < 1 {
+		return errors.New("stats capacity should be at least 1")
+	}
+	ringLock.Lock()
+	defer ringLock.Unlock()
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h, err := readHeader(f)
+	if err != nil || h.capacity != uint64(capacity) {
+		var samples []*types.StatsSample
+		if err == nil {
+			if samples, err = readAll(f); err != nil {
+				return err
+			}
+		}
+		// a new, corrupt or resized file starts over, keeping what it can
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		h = &header{capacity: uint64(capacity)}
+		if len(samples) > capacity {
+			samples = samples[len(samples)-capacity:]
+		}
+		for _, old := range samples {
+			if _, err := f.WriteAt(encode(old), headerSize+int64(h.appended)*recordSize); err != nil {
+				return err
+			}
+			h.appended++
+		}
+	}
+	slot := h.appended % h.capacity
+	if _, err := f.WriteAt(encode(sample), headerSize+int64(slot)*recordSize); err != nil {
+		return err
+	}
+	h.appended++
+	return writeHeader(f, h)
+}
+
+// Returns the samples in the ring file taken from since up to until, oldest first. A zero since or until leaves
+// that end open.
+func Read(file string, since, until time.Time) ([]*types.StatsSample, error) {
+	ringLock.Lock()
+	defer ringLock.Unlock()
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	all, err := readAll(f)
+	if err != nil {
+		return nil, err
+	}
+	samples := []*types.StatsSample{}
+	for _, sample := range all {
+		if (since.IsZero() || !sample.Time.Before(since)) && (until.IsZero() || !sample.Time.After(until)) {
+			samples = append(samples, sample)
+		}
+	}
+	return samples, nil
+}
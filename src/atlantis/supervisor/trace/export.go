@@ -0,0 +1,196 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	exportInterval = 5 * time.Second
+	exportBatch    = 512
+	queueSize      = 4 * exportBatch // spans ended while the queue is full are dropped rather than wait
+	exportTimeout  = 10 * time.Second
+
+	// OTLP's span kinds and status codes
+	kindInternal = 1
+	kindServer   = 2
+	statusOk     = 1
+	statusError  = 2
+)
+
+var (
+	spans      chan *Span
+	exportOnce sync.Once
+	flushChan  = make(chan chan bool)
+	client     = &http.Client{Timeout: exportTimeout}
+)
+
+// Start exporting spans to Endpoint, if it is set
+func Init() {
+	if Endpoint == "" {
+		return
+	}
+	exportOnce.Do(func() {
+		spans = make(chan *Span, queueSize)
+		go exportLoop()
+	})
+}
+
+func queue(span *Span) {
+	if spans == nil {
+		return
+	}
+	select {
+	case spans <- span:
+	default:
+		log.Printf("[trace] WARNING: export queue full, dropped span %s", span.Name)
+	}
+}
+
+// Export the spans ended so far now, rather than within the next few seconds
+func Flush() {
+	if spans == nil {
+		return
+	}
+	done := make(chan bool)
+	flushChan <- done
+	<-done
+}
+
+func exportLoop() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, exportBatch)
+	for {
+		var flushed chan bool
+		select {
+		case span := <-spans:
+			batch = append(batch, span)
+			if len(batch) < exportBatch {
+				continue
+			}
+		case <-ticker.C:
+		case flushed = <-flushChan:
+			for len(spans) > 0 {
+				batch = append(batch, <-spans)
+			}
+		}
+		if len(batch) > 0 {
+			if err := export(batch); err != nil {
+				log.Printf("[trace] WARNING: could not export %d spans: %v", len(batch), err)
+			}
+			batch = batch[:0]
+		}
+		if flushed != nil {
+			close(flushed)
+		}
+	}
+}
+
+// OTLP/JSON, see opentelemetry-proto's trace.proto. Ids are hex and 64 bit integers decimal strings.
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+func attributes(attrs map[string]string) []otlpAttribute {
+	list := make([]otlpAttribute, 0, len(attrs))
+	for key, val := range attrs {
+		list = append(list, otlpAttribute{key, otlpValue{val}})
+	}
+	return list
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// Returns batch as an OTLP export request
+func exportRequest(batch []*Span) ([]byte, error) {
+	otlpSpans := make([]otlpSpan, len(batch))
+	for i, span := range batch {
+		span.lock.Lock()
+		otlpSpans[i] = otlpSpan{TraceID: span.TraceID, SpanID: span.SpanID, ParentSpanID: span.ParentID,
+			Name: span.Name, Kind: kindInternal, StartTimeUnixNano: unixNano(span.StartTime),
+			EndTimeUnixNano: unixNano(span.EndTime), Attributes: attributes(span.Attributes),
+			Status: otlpStatus{Code: statusOk}}
+		if span.ParentID == "" {
+			otlpSpans[i].Kind = kindServer
+		}
+		if span.Err != nil {
+			otlpSpans[i].Status = otlpStatus{Code: statusError, Message: span.Err.Error()}
+		}
+		span.lock.Unlock()
+	}
+	resource := map[string]string{"service.name": ServiceName}
+	if Host != "" {
+		resource["host.name"] = Host
+	}
+	return json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": attributes(resource)},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "atlantis/supervisor"},
+				"spans": otlpSpans,
+			}},
+		}},
+	})
+}
+
+func export(batch []*Span) error {
+	body, err := exportRequest(batch)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(Endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s answered %s: %s", Endpoint, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+/* Copyright 2014 Ooyala, Inc. All rights reserved.
+ *
+ * This file is licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+ * except in compliance with the License. You may obtain a copy of the License at
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is
+ * distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and limitations under the License.
+ */
+
+// Times the steps of RPC operations as OpenTelemetry spans and exports them to a collector with OTLP over HTTP in
+// its JSON encoding. Spans are only recorded while an endpoint is set; the functions here return a nil *Span
+// otherwise, and a nil *Span ignores what it is told, so callers need not check.
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const DefaultServiceName = "atlantis-supervisor"
+
+// Set before Init. Endpoint is the collector's traces URL, e.g. http://localhost:4318/v1/traces, empty to trace
+// nothing.
+var (
+	Endpoint    string
+	ServiceName = DefaultServiceName
+	Host        string // reported as host.name
+)
+
+type Span struct {
+	TraceID    string // 32 hex digits
+	SpanID     string // 16 hex digits
+	ParentID   string // empty for the root of a trace
+	Name       string
+	StartTime  time.Time
+	EndTime    time.Time
+	Attributes map[string]string
+	Err        error
+	lock       sync.Mutex
+}
+
+var (
+	boundLock = sync.Mutex{}
+	bound     = map[string]*Span{} // key -> the span what happens to it is part of
+)
+
+func randomID(bytes int) string {
+	id := make([]byte, bytes)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Start a span, a new trace if parent is nil. Returns nil if tracing is off.
+func Start(name string, parent *Span) *Span {
+	if Endpoint == "" {
+		return nil
+	}
+	span := &Span{SpanID: randomID(8), Name: name, StartTime: time.Now(), Attributes: map[string]string{}}
+	if parent != nil {
+		span.TraceID, span.ParentID = parent.TraceID, parent.SpanID
+	} else {
+		span.TraceID = randomID(16)
+	}
+	return span
+}
+
+// Start a span under the one bound to the first of keys that has one. Returns nil if none has, so work done
+// outside of a traced operation, e.g. restarting a container that exited, records nothing.
+func Child(name string, keys ...string) *Span {
+	boundLock.Lock()
+	defer boundLock.Unlock()
+	for _, key := range keys {
+		if parent := bound[key]; parent != nil {
+			return Start(name, parent)
+		}
+	}
+	return nil
+}
+
+// Make the spans Child starts for key children of span, e.g. with a container id as key, so the packages the
+// work is spread over need not pass spans around
+func Bind(key string, span *Span) {
+	if span == nil {
+		return
+	}
+	boundLock.Lock()
+	defer boundLock.Unlock()
+	bound[key] = span
+}
+
+func Unbind(key string) {
+	boundLock.Lock()
+	defer boundLock.Unlock()
+	delete(bound, key)
+}
+
+func (s *Span) SetAttribute(key, val string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Attributes[key] = val
+}
+
+// End the span, failed if err is not nil, and queue it to be exported
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	s.EndTime, s.Err = time.Now(), err
+	s.lock.Unlock()
+	queue(s)
+}